@@ -0,0 +1,284 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package archivefs exposes the backup sets stored in a destination as a read-only FUSE
+// filesystem. Every dataset is a directory holding a directory per backup set, named after
+// its snapshot, with the manifest of the backup set and its volumes. Volumes are downloaded,
+// verified, decrypted, and decompressed when opened so reading one returns its part of the
+// original zfs send stream.
+package archivefs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// ManifestFileName is the name of the file holding the manifest of a backup set.
+const ManifestFileName = "manifest.json"
+
+// Extractor writes the decrypted and decompressed content of a volume of a backup set.
+type Extractor interface {
+	ExtractVolume(ctx context.Context, manifest *helpers.JobInfo, vol *helpers.VolumeInfo, w io.Writer) error
+}
+
+// FS is the filesystem tree built from a list of backup sets.
+type FS struct {
+	root *dir
+}
+
+var _ fs.FS = (*FS)(nil)
+
+// New will build the filesystem tree for the backup sets provided. Volumes are read using extractor.
+func New(sets []*helpers.JobInfo, extractor Extractor) (*FS, error) {
+	root := newDir(time.Time{})
+	for _, set := range sets {
+		parent := root
+		for _, part := range strings.Split(set.VolumeName, "/") {
+			parent = parent.subdir(part, set.BaseSnapshot.CreationTime)
+		}
+
+		name := SnapshotDirName(set)
+		if _, ok := parent.entries[name]; ok {
+			helpers.AppLogger.Warningf("Skipping duplicate backup set %s@%s", set.VolumeName, name)
+			continue
+		}
+
+		manifest, err := json.MarshalIndent(set, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("could not encode the manifest of %s - %v", set.VolumeName, err)
+		}
+
+		snapshot := newDir(set.BaseSnapshot.CreationTime)
+		snapshot.entries[ManifestFileName] = &manifestFile{content: manifest, mtime: set.BaseSnapshot.CreationTime}
+		for _, vol := range set.Volumes {
+			snapshot.entries[VolumeFileName(vol)] = &volumeFile{extractor: extractor, manifest: set, vol: vol}
+		}
+		parent.entries[name] = snapshot
+	}
+	return &FS{root: root}, nil
+}
+
+// Root returns the root directory of the filesystem.
+func (f *FS) Root() (fs.Node, error) {
+	return f.root, nil
+}
+
+// SnapshotDirName returns the name of the directory holding the backup set: @snapshot for a full
+// backup and @incremental-to-@snapshot for an incremental backup.
+func SnapshotDirName(set *helpers.JobInfo) string {
+	if set.IncrementalSnapshot.Name != "" {
+		return fmt.Sprintf("@%s-to-@%s", set.IncrementalSnapshot.Name, set.BaseSnapshot.Name)
+	}
+	return "@" + set.BaseSnapshot.Name
+}
+
+// VolumeFileName returns the name of the file holding the content of a volume.
+func VolumeFileName(vol *helpers.VolumeInfo) string {
+	return fmt.Sprintf("vol%d.zstream", vol.VolumeNumber)
+}
+
+// Mount will serve the filesystem at mountpoint until it is unmounted or ctx is done.
+func Mount(ctx context.Context, filesys *FS, mountpoint string, options ...fuse.MountOption) error {
+	options = append([]fuse.MountOption{fuse.ReadOnly(), fuse.FSName("zfsbackup"), fuse.Subtype("zfsbackup")}, options...)
+	c, err := fuse.Mount(mountpoint, options...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			if uerr := fuse.Unmount(mountpoint); uerr != nil {
+				helpers.AppLogger.Errorf("Could not unmount %s due to error - %v", mountpoint, uerr)
+			}
+		case <-done:
+		}
+	}()
+
+	if err = fs.Serve(c, filesys); err != nil {
+		return err
+	}
+
+	<-c.Ready
+	return c.MountError
+}
+
+// dir is a directory of the filesystem, either a dataset or a backup set.
+type dir struct {
+	mtime   time.Time
+	entries map[string]fs.Node
+}
+
+var (
+	_ fs.Node               = (*dir)(nil)
+	_ fs.NodeStringLookuper = (*dir)(nil)
+	_ fs.HandleReadDirAller = (*dir)(nil)
+)
+
+func newDir(mtime time.Time) *dir {
+	return &dir{mtime: mtime, entries: make(map[string]fs.Node)}
+}
+
+// subdir returns the dataset directory with the name provided, creating it if required.
+func (d *dir) subdir(name string, mtime time.Time) *dir {
+	if mtime.After(d.mtime) {
+		d.mtime = mtime
+	}
+	if sub, ok := d.entries[name].(*dir); ok {
+		if mtime.After(sub.mtime) {
+			sub.mtime = mtime
+		}
+		return sub
+	}
+	sub := newDir(mtime)
+	d.entries[name] = sub
+	return sub
+}
+
+func (d *dir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	a.Mtime = d.mtime
+	a.Ctime = d.mtime
+	return nil
+}
+
+func (d *dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	if node, ok := d.entries[name]; ok {
+		return node, nil
+	}
+	return nil, fuse.ENOENT
+}
+
+func (d *dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	dirents := make([]fuse.Dirent, 0, len(d.entries))
+	for name, node := range d.entries {
+		dirent := fuse.Dirent{Name: name, Type: fuse.DT_File}
+		if _, ok := node.(*dir); ok {
+			dirent.Type = fuse.DT_Dir
+		}
+		dirents = append(dirents, dirent)
+	}
+	sort.Slice(dirents, func(i, j int) bool { return dirents[i].Name < dirents[j].Name })
+	return dirents, nil
+}
+
+// manifestFile is the manifest of a backup set, encoded as JSON.
+type manifestFile struct {
+	content []byte
+	mtime   time.Time
+}
+
+var (
+	_ fs.Node            = (*manifestFile)(nil)
+	_ fs.HandleReadAller = (*manifestFile)(nil)
+)
+
+func (m *manifestFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Size = uint64(len(m.content))
+	a.Mtime = m.mtime
+	a.Ctime = m.mtime
+	return nil
+}
+
+func (m *manifestFile) ReadAll(ctx context.Context) ([]byte, error) {
+	return m.content, nil
+}
+
+// volumeFile is a volume of a backup set, extracted to a temporary file when opened.
+type volumeFile struct {
+	extractor Extractor
+	manifest  *helpers.JobInfo
+	vol       *helpers.VolumeInfo
+}
+
+var (
+	_ fs.Node       = (*volumeFile)(nil)
+	_ fs.NodeOpener = (*volumeFile)(nil)
+)
+
+func (v *volumeFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = 0444
+	a.Size = v.vol.ZFSStreamBytes
+	a.Mtime = v.vol.CreateTime
+	a.Ctime = v.vol.CreateTime
+	return nil
+}
+
+func (v *volumeFile) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	if !req.Flags.IsReadOnly() {
+		return nil, fuse.Errno(fuse.EPERM)
+	}
+
+	f, err := ioutil.TempFile(helpers.BackupTempdir, helpers.LogModuleName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = v.extractor.ExtractVolume(ctx, v.manifest, v.vol, f); err != nil {
+		f.Close()
+		helpers.RemoveTempFile(f.Name())
+		return nil, fuse.EIO
+	}
+
+	// The size of volumes written before the stream bytes were recorded is unknown, read until EOF
+	resp.Flags |= fuse.OpenDirectIO
+	return &volumeHandle{f: f}, nil
+}
+
+// volumeHandle reads an opened volume from its temporary file.
+type volumeHandle struct {
+	f *os.File
+}
+
+var (
+	_ fs.HandleReader   = (*volumeHandle)(nil)
+	_ fs.HandleReleaser = (*volumeHandle)(nil)
+)
+
+func (h *volumeHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := make([]byte, req.Size)
+	n, err := h.f.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+func (h *volumeHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.f.Close()
+	return helpers.RemoveTempFile(h.f.Name())
+}
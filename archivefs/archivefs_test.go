@@ -0,0 +1,164 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package archivefs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+type fakeExtractor struct{}
+
+func (fakeExtractor) ExtractVolume(ctx context.Context, manifest *helpers.JobInfo, vol *helpers.VolumeInfo, w io.Writer) error {
+	_, err := io.WriteString(w, strings.Repeat(vol.ObjectName, 3))
+	return err
+}
+
+func lookup(t *testing.T, node fs.Node, path ...string) fs.Node {
+	for _, name := range path {
+		d, ok := node.(*dir)
+		if !ok {
+			t.Fatalf("expected a directory before %s", name)
+		}
+		var err error
+		if node, err = d.Lookup(context.Background(), name); err != nil {
+			t.Fatalf("could not lookup %s - %v", name, err)
+		}
+	}
+	return node
+}
+
+func TestTree(t *testing.T) {
+	now := time.Now()
+	full := &helpers.JobInfo{
+		VolumeName:   "tank/data",
+		BaseSnapshot: helpers.SnapshotInfo{Name: "snap1", CreationTime: now.Add(-time.Hour)},
+		Volumes:      []*helpers.VolumeInfo{{ObjectName: "a", VolumeNumber: 1}, {ObjectName: "b", VolumeNumber: 2}},
+	}
+	incremental := &helpers.JobInfo{
+		VolumeName:          "tank/data",
+		BaseSnapshot:        helpers.SnapshotInfo{Name: "snap2", CreationTime: now},
+		IncrementalSnapshot: full.BaseSnapshot,
+		Volumes:             []*helpers.VolumeInfo{{ObjectName: "c", VolumeNumber: 1}},
+	}
+	parent := &helpers.JobInfo{
+		VolumeName:   "tank",
+		BaseSnapshot: helpers.SnapshotInfo{Name: "snap1", CreationTime: now},
+	}
+
+	filesys, err := New([]*helpers.JobInfo{full, incremental, parent}, fakeExtractor{})
+	if err != nil {
+		t.Fatalf("could not build the tree - %v", err)
+	}
+	root, _ := filesys.Root()
+
+	dirents, err := lookup(t, root, "tank").(*dir).ReadDirAll(context.Background())
+	if err != nil {
+		t.Fatalf("could not read directory - %v", err)
+	}
+	if len(dirents) != 2 || dirents[0].Name != "@snap1" || dirents[1].Name != "data" || dirents[1].Type != fuse.DT_Dir {
+		t.Errorf("unexpected entries %+v", dirents)
+	}
+
+	dirents, _ = lookup(t, root, "tank", "data").(*dir).ReadDirAll(context.Background())
+	if len(dirents) != 2 || dirents[0].Name != "@snap1" || dirents[1].Name != "@snap1-to-@snap2" {
+		t.Errorf("unexpected entries %+v", dirents)
+	}
+
+	dirents, _ = lookup(t, root, "tank", "data", "@snap1").(*dir).ReadDirAll(context.Background())
+	if len(dirents) != 3 || dirents[0].Name != ManifestFileName || dirents[1].Name != "vol1.zstream" || dirents[2].Name != "vol2.zstream" {
+		t.Errorf("unexpected entries %+v", dirents)
+	}
+
+	if _, err = root.(*dir).Lookup(context.Background(), "missing"); err != fuse.ENOENT {
+		t.Errorf("expected ENOENT, got %v", err)
+	}
+
+	content, err := lookup(t, root, "tank", "data", "@snap1-to-@snap2", ManifestFileName).(*manifestFile).ReadAll(context.Background())
+	if err != nil {
+		t.Fatalf("could not read manifest - %v", err)
+	}
+	var manifest helpers.JobInfo
+	if err = json.Unmarshal(content, &manifest); err != nil || manifest.BaseSnapshot.Name != "snap2" {
+		t.Errorf("unexpected manifest %s - %v", content, err)
+	}
+}
+
+func TestVolumeRead(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "archivefs")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	helpers.BackupTempdir = tempDir
+
+	set := &helpers.JobInfo{
+		VolumeName:   "tank",
+		BaseSnapshot: helpers.SnapshotInfo{Name: "snap1"},
+		Volumes:      []*helpers.VolumeInfo{{ObjectName: "abc", VolumeNumber: 1}},
+	}
+	filesys, err := New([]*helpers.JobInfo{set}, fakeExtractor{})
+	if err != nil {
+		t.Fatalf("could not build the tree - %v", err)
+	}
+	root, _ := filesys.Root()
+
+	node := lookup(t, root, "tank", "@snap1", "vol1.zstream").(*volumeFile)
+	if _, err = node.Open(context.Background(), &fuse.OpenRequest{Flags: fuse.OpenWriteOnly}, &fuse.OpenResponse{}); err == nil {
+		t.Errorf("expected opening for writing to fail")
+	}
+
+	resp := new(fuse.OpenResponse)
+	handle, err := node.Open(context.Background(), &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, resp)
+	if err != nil {
+		t.Fatalf("could not open volume - %v", err)
+	}
+	if resp.Flags&fuse.OpenDirectIO == 0 {
+		t.Errorf("expected direct IO")
+	}
+
+	h := handle.(*volumeHandle)
+	readResp := new(fuse.ReadResponse)
+	if err = h.Read(context.Background(), &fuse.ReadRequest{Offset: 2, Size: 100}, readResp); err != nil {
+		t.Fatalf("could not read volume - %v", err)
+	}
+	if string(readResp.Data) != "cabcabc" {
+		t.Errorf("expected cabcabc, got %s", readResp.Data)
+	}
+
+	if err = h.Release(context.Background(), &fuse.ReleaseRequest{}); err != nil {
+		t.Errorf("could not release volume - %v", err)
+	}
+	if files, _ := ioutil.ReadDir(tempDir); len(files) != 0 {
+		t.Errorf("expected the extracted volume to be removed, found %d files", len(files))
+	}
+}
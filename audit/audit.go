@@ -0,0 +1,190 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package audit keeps an append-only, hash-chained log of the operations run against
+// backups so that changes made to the log after the fact can be detected.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Entry records an operation run against backups. PrevHash is the Hash of the entry before it,
+// and Seq its position in the log, starting at 1.
+type Entry struct {
+	Seq                 uint64
+	Time                time.Time
+	User                string
+	Host                string
+	PID                 int
+	Operation           string
+	VolumeName          string   `json:",omitempty"`
+	Snapshot            string   `json:",omitempty"`
+	IncrementalSnapshot string   `json:",omitempty"`
+	Destinations        []string `json:",omitempty"`
+	Manifests           []string `json:",omitempty"`
+	Result              string
+	Error               string `json:",omitempty"`
+	PrevHash            string
+}
+
+// Record is a line of the audit log: an Entry along with the SHA256 hash of its exact JSON encoding.
+type Record struct {
+	Entry json.RawMessage
+	Hash  string
+}
+
+// ChainError describes where the audit log was found to have been tampered with.
+type ChainError struct {
+	Line   int
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit log broken at line %d: %s", e.Line, e.Reason)
+}
+
+func hash(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Append will add the entry to the audit log at path, created if it doesn't exist, chaining it to the
+// last entry of the log. The Seq and PrevHash of the entry are set and it is returned with its hash.
+// Processes appending to the same log at the same time are serialized.
+func Append(path string, entry Entry) (Entry, string, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return entry, "", err
+	}
+	defer f.Close()
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return entry, "", err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	last, err := lastLine(f)
+	if err != nil {
+		return entry, "", err
+	}
+	entry.Seq, entry.PrevHash = 1, ""
+	if last != nil {
+		var prev Record
+		var prevEntry Entry
+		if err = json.Unmarshal(last, &prev); err != nil {
+			return entry, "", fmt.Errorf("could not read the last entry of the audit log: %v", err)
+		}
+		if err = json.Unmarshal(prev.Entry, &prevEntry); err != nil {
+			return entry, "", fmt.Errorf("could not read the last entry of the audit log: %v", err)
+		}
+		entry.Seq, entry.PrevHash = prevEntry.Seq+1, prev.Hash
+	}
+
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return entry, "", err
+	}
+	record := Record{Entry: raw, Hash: hash(raw)}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return entry, "", err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return entry, "", err
+	}
+	return entry, record.Hash, f.Sync()
+}
+
+// lastLine will return the last line of f, or nil if it is empty.
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil, err
+	}
+
+	// Read back from the end until a whole line is found
+	for chunk := int64(64 * 1024); ; chunk *= 2 {
+		offset := info.Size() - chunk
+		if offset < 0 {
+			offset = 0
+		}
+		buf := make([]byte, info.Size()-offset)
+		if _, err = f.ReadAt(buf, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = bytes.TrimRight(buf, "\n")
+		if idx := bytes.LastIndexByte(buf, '\n'); idx >= 0 {
+			return buf[idx+1:], nil
+		} else if offset == 0 {
+			return buf, nil
+		}
+	}
+}
+
+// Read will return the entries of the audit log at path along with their hashes, checking the chain
+// along the way. A *ChainError is returned along with the entries read so far if it is broken.
+func Read(path string) ([]Entry, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var (
+		entries  []Entry
+		hashes   []string
+		prevHash string
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var record Record
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return entries, hashes, &ChainError{Line: line, Reason: fmt.Sprintf("not a valid record - %v", err)}
+		}
+		if hash(record.Entry) != record.Hash {
+			return entries, hashes, &ChainError{Line: line, Reason: "the entry does not match its hash"}
+		}
+		if err = json.Unmarshal(record.Entry, &entry); err != nil {
+			return entries, hashes, &ChainError{Line: line, Reason: fmt.Sprintf("not a valid entry - %v", err)}
+		}
+		if entry.Seq != uint64(line) {
+			return entries, hashes, &ChainError{Line: line, Reason: fmt.Sprintf("expected entry %d, found entry %d", line, entry.Seq)}
+		}
+		if entry.PrevHash != prevHash {
+			return entries, hashes, &ChainError{Line: line, Reason: "the entry is not chained to the entry before it"}
+		}
+		entries = append(entries, entry)
+		hashes = append(hashes, record.Hash)
+		prevHash = record.Hash
+	}
+	return entries, hashes, scanner.Err()
+}
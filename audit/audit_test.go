@@ -0,0 +1,172 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func appendEntries(t *testing.T, path string, count int) {
+	t.Helper()
+	for idx := 0; idx < count; idx++ {
+		entry := Entry{Time: time.Now().UTC(), User: "root", Operation: "send", VolumeName: "tank/data", Snapshot: fmt.Sprintf("snap%d", idx), Result: "success"}
+		if _, _, err := Append(path, entry); err != nil {
+			t.Fatalf("could not append to the audit log - %v", err)
+		}
+	}
+}
+
+func TestAppendAndRead(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "zfsbackupaudittest")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "audit.log")
+
+	appendEntries(t, path, 3)
+	entry, last, err := Append(path, Entry{Operation: "clean", Destinations: []string{"file:///backups"}, Manifests: []string{"manifests|tank/data|snap0.manifest.gz"}, Result: "success"})
+	if err != nil {
+		t.Fatalf("could not append to the audit log - %v", err)
+	}
+	if entry.Seq != 4 || entry.PrevHash == "" {
+		t.Errorf("expected the entry to be chained as the 4th entry, got %+v", entry)
+	}
+
+	entries, hashes, err := Read(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading the audit log - %v", err)
+	}
+	if len(entries) != 4 || len(hashes) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	if hashes[3] != last || entries[3].PrevHash != hashes[2] || entries[0].PrevHash != "" {
+		t.Errorf("expected the entries to be chained by their hashes")
+	}
+	if entries[1].Snapshot != "snap1" || entries[3].Manifests[0] != "manifests|tank/data|snap0.manifest.gz" {
+		t.Errorf("unexpected entries read: %+v", entries)
+	}
+}
+
+func TestReadTampered(t *testing.T) {
+	testCases := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		line   int
+	}{
+		{
+			name: "Edited",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte("success"), []byte("failure"), 1)
+				return lines
+			},
+			line: 2,
+		},
+		{
+			name: "Removed",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines[:1], lines[2:]...)
+			},
+			line: 2,
+		},
+		{
+			name: "Reordered",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[0], lines[1] = lines[1], lines[0]
+				return lines
+			},
+			line: 1,
+		},
+		{
+			name: "Garbage",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[2] = []byte("not json")
+				return lines
+			},
+			line: 3,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			tempDir, err := ioutil.TempDir("", "zfsbackupaudittest")
+			if err != nil {
+				t.Fatalf("could not create temp dir - %v", err)
+			}
+			defer os.RemoveAll(tempDir)
+			path := filepath.Join(tempDir, "audit.log")
+
+			appendEntries(t, path, 3)
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("could not read the audit log - %v", err)
+			}
+			lines := test.tamper(bytes.Split(bytes.TrimRight(content, "\n"), []byte("\n")))
+			if err = ioutil.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0600); err != nil {
+				t.Fatalf("could not write the audit log - %v", err)
+			}
+
+			_, _, err = Read(path)
+			var cerr *ChainError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("expected a ChainError, got %v", err)
+			}
+			if cerr.Line != test.line {
+				t.Errorf("expected the chain to break at line %d, got %v", test.line, cerr)
+			}
+		})
+	}
+}
+
+func TestAppendConcurrently(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "zfsbackupaudittest")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "audit.log")
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 10; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			appendEntries(t, path, 5)
+		}()
+	}
+	wg.Wait()
+
+	entries, _, err := Read(path)
+	if err != nil {
+		t.Fatalf("expected the chain to hold with concurrent appends - %v", err)
+	}
+	if len(entries) != 50 {
+		t.Errorf("expected 50 entries, got %d", len(entries))
+	}
+}
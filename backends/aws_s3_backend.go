@@ -27,6 +27,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -48,6 +49,12 @@ import (
 // AWSS3BackendPrefix is the URI prefix used for the AWSS3Backend.
 const AWSS3BackendPrefix = "s3"
 
+// abortTimeout bounds how long we try to abort a multipart upload interrupted by a canceled context.
+const abortTimeout = 30 * time.Second
+
+// s3MaxCopySize is the largest object S3 copies in a single request.
+const s3MaxCopySize = 5 * 1024 * 1024 * 1024
+
 // AWSS3Backend integrates with Amazon Web Services' S3.
 type AWSS3Backend struct {
 	conf       *BackendConfig
@@ -228,19 +235,51 @@ func (a *AWSS3Backend) Upload(ctx context.Context, vol *helpers.VolumeInfo) erro
 		r = &reader{vol} // Remove the Seek interface since we are using a Pipe
 	}
 
-	// Do a MultiPart Upload - force the s3manager to compute each chunks md5 hash
-	_, err := a.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket: aws.String(a.bucketName),
 		Key:    aws.String(key),
 		Body:   r,
-	}, s3manager.WithUploaderRequestOptions(options...))
+	}
+	if len(a.conf.ObjectMetadata) > 0 {
+		input.Metadata = aws.StringMap(a.conf.ObjectMetadata)
+	}
+	if len(a.conf.ObjectTags) > 0 {
+		tags := make(url.Values, len(a.conf.ObjectTags))
+		for key, value := range a.conf.ObjectTags {
+			tags.Set(key, value)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+
+	// Do a MultiPart Upload - force the s3manager to compute each chunks md5 hash
+	_, err := a.uploader.UploadWithContext(ctx, input, s3manager.WithUploaderRequestOptions(options...))
 
 	if err != nil {
-		helpers.AppLogger.Debugf("s3 backend: Error while uploading volume %s - %v", vol.ObjectName, err)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("s3 backend: Error while uploading volume %s - %v", vol.ObjectName, err)
+		if merr, ok := err.(s3manager.MultiUploadFailure); ok && ctx.Err() != nil {
+			// The uploader cannot abort the multipart upload with a canceled context, don't let its parts accrue storage charges
+			a.abortMultipartUpload(key, merr.UploadID())
+		}
 	}
 	return err
 }
 
+func (a *AWSS3Backend) abortMultipartUpload(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+
+	_, err := a.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(a.bucketName),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("s3 backend: Could not abort the multipart upload %s of %s - %v", uploadID, key, err)
+	} else {
+		helpers.EnvironmentOf(ctx).Logger.Debugf("s3 backend: Aborted the multipart upload %s of %s", uploadID, key)
+	}
+}
+
 // Delete will delete the given object from the configured bucket
 func (a *AWSS3Backend) Delete(ctx context.Context, key string) error {
 	_, err := a.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
@@ -251,6 +290,70 @@ func (a *AWSS3Backend) Delete(ctx context.Context, key string) error {
 	return err
 }
 
+// Move will copy the given object within the configured bucket, keeping its storage class, and then delete it.
+// Objects larger than S3 copies in a single request are not moved, and objects archived in the Glacier or Deep
+// Archive storage classes can't be until they are restored.
+func (a *AWSS3Backend) Move(ctx context.Context, from, to string) error {
+	head, err := a.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(a.bucketName),
+		Key:    aws.String(from),
+	})
+	if err != nil {
+		return err
+	}
+	if aws.Int64Value(head.ContentLength) > s3MaxCopySize {
+		return ErrMoveNotSupported
+	}
+	switch class := aws.StringValue(head.StorageClass); class {
+	case s3.ObjectStorageClassGlacier, s3.ObjectStorageClassDeepArchive:
+		// S3 only copies the restored copy of an archived object
+		if head.Restore == nil || !strings.Contains(*head.Restore, `ongoing-request="false"`) {
+			return fmt.Errorf("s3 backend: the object %s is archived in the %s storage class and must be restored before it can be moved", from, class)
+		}
+	}
+
+	_, err = a.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:       aws.String(a.bucketName),
+		Key:          aws.String(to),
+		CopySource:   aws.String((&url.URL{Path: a.bucketName + "/" + from}).EscapedPath()),
+		StorageClass: head.StorageClass,
+	})
+	if err != nil {
+		return err
+	}
+	return a.Delete(ctx, from)
+}
+
+// ObjectVersions will list the versions of the object kept by the bucket, newest first, without its delete markers.
+// A bucket without versioning holds a single version of the object.
+func (a *AWSS3Backend) ObjectVersions(ctx context.Context, key string) ([]string, error) {
+	var versions []string
+	err := a.client.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(a.bucketName),
+		Prefix: aws.String(key),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, version := range page.Versions {
+			if aws.StringValue(version.Key) == key {
+				versions = append(versions, aws.StringValue(version.VersionId))
+			}
+		}
+		return true
+	})
+
+	return versions, err
+}
+
+// DeleteVersion will permanently delete the version of the object, as allowed by the object lock of the bucket.
+func (a *AWSS3Backend) DeleteVersion(ctx context.Context, key, version string) error {
+	_, err := a.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(a.bucketName),
+		Key:       aws.String(key),
+		VersionId: aws.String(version),
+	})
+
+	return err
+}
+
 // PreDownload will restore objects from Glacier as required.
 func (a *AWSS3Backend) PreDownload(ctx context.Context, keys []string) error {
 	// First Let's check if any objects are on the GLACIER storage class
@@ -260,7 +363,7 @@ func (a *AWSS3Backend) PreDownload(ctx context.Context, keys []string) error {
 		restoreTier = s3.TierBulk
 	}
 	var bytesToRestore int64
-	helpers.AppLogger.Debugf("s3 backend: will use the %s restore tier when trying to restore from Glacier.", restoreTier)
+	helpers.EnvironmentOf(ctx).Logger.Debugf("s3 backend: will use the %s restore tier when trying to restore from Glacier.", restoreTier)
 	for _, key := range keys {
 		resp, err := a.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 			Bucket: aws.String(a.bucketName),
@@ -270,7 +373,7 @@ func (a *AWSS3Backend) PreDownload(ctx context.Context, keys []string) error {
 			return err
 		}
 		if resp.StorageClass != nil && *resp.StorageClass == s3.ObjectStorageClassGlacier {
-			helpers.AppLogger.Debugf("s3 backend: key %s will be restored from the Glacier storage class.", key)
+			helpers.EnvironmentOf(ctx).Logger.Debugf("s3 backend: key %s will be restored from the Glacier storage class.", key)
 			bytesToRestore += *resp.ContentLength
 			// Let's Start a restore
 			toRestore = append(toRestore, key)
@@ -286,14 +389,14 @@ func (a *AWSS3Backend) PreDownload(ctx context.Context, keys []string) error {
 			})
 			if rerr != nil {
 				if aerr, ok := rerr.(awserr.Error); ok && aerr.Code() != "RestoreAlreadyInProgress" {
-					helpers.AppLogger.Debugf("s3 backend: error trying to restore key %s - %s: %s", key, aerr.Code(), aerr.Message())
+					helpers.EnvironmentOf(ctx).Logger.Debugf("s3 backend: error trying to restore key %s - %s: %s", key, aerr.Code(), aerr.Message())
 					return rerr
 				}
 			}
 		}
 	}
 	if len(toRestore) > 0 {
-		helpers.AppLogger.Infof("s3 backend: waiting for %d objects to restore from Glacier totaling %d bytes (this could take several hours)", len(toRestore), bytesToRestore)
+		helpers.EnvironmentOf(ctx).Logger.Infof("s3 backend: waiting for %d objects to restore from Glacier totaling %d bytes (this could take several hours)", len(toRestore), bytesToRestore)
 		// Now wait for the objects to be restored
 		backoffCount := 1
 		for idx := 0; idx < len(toRestore); idx++ {
@@ -314,7 +417,7 @@ func (a *AWSS3Backend) PreDownload(ctx context.Context, keys []string) error {
 				}
 			} else {
 				backoffCount = 1
-				helpers.AppLogger.Debugf("s3 backend: key %s restored.", key)
+				helpers.EnvironmentOf(ctx).Logger.Debugf("s3 backend: key %s restored.", key)
 			}
 		}
 	}
@@ -343,19 +446,44 @@ func (a *AWSS3Backend) Close() error {
 // List will iterate through all objects in the configured AWS S3 bucket and return
 // a list of keys, filtering by the provided prefix.
 func (a *AWSS3Backend) List(ctx context.Context, prefix string) ([]string, error) {
+	l := make([]string, 0, 1000)
+	err := a.listObjects(ctx, prefix, func(obj *s3.Object) {
+		l = append(l, *obj.Key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// ListVersions will iterate through all objects in the configured AWS S3 bucket and return
+// the ETag of every object found, filtering by the provided prefix.
+func (a *AWSS3Backend) ListVersions(ctx context.Context, prefix string) (map[string]string, error) {
+	versions := make(map[string]string)
+	err := a.listObjects(ctx, prefix, func(obj *s3.Object) {
+		versions[*obj.Key] = aws.StringValue(obj.ETag)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+func (a *AWSS3Backend) listObjects(ctx context.Context, prefix string, fn func(*s3.Object)) error {
 	resp, err := a.client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
 		Bucket:  aws.String(a.bucketName),
 		MaxKeys: aws.Int64(1000),
 		Prefix:  aws.String(prefix),
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	l := make([]string, 0, 1000)
 	for {
 		for _, obj := range resp.Contents {
-			l = append(l, *obj.Key)
+			fn(obj)
 		}
 
 		if !*resp.IsTruncated {
@@ -369,9 +497,9 @@ func (a *AWSS3Backend) List(ctx context.Context, prefix string) ([]string, error
 			ContinuationToken: resp.NextContinuationToken,
 		})
 		if err != nil {
-			return nil, fmt.Errorf("s3 backend: could not list bucket due to error - %v", err)
+			return fmt.Errorf("s3 backend: could not list bucket due to error - %v", err)
 		}
 	}
 
-	return l, nil
+	return nil
 }
@@ -47,17 +47,38 @@ type mockS3Client struct {
 	s3iface.S3API
 
 	headcallcount int
+	aborted       []string
+	copied        []string
+	copiedClasses []string
 }
 
 type mockS3Uploader struct {
 	s3manageriface.UploaderAPI
+
+	uploaded *s3manager.UploadInput
 }
 
 var (
-	s3BadBucket = "badbucket"
-	s3BadKey    = "badkey"
+	s3BadBucket       = "badbucket"
+	s3BadKey          = "badkey"
+	s3MultipartFailed = "multipartfailedkey"
+	s3LargeKey        = "largekey"
+	s3InfrequentKey   = "infrequentkey"
+	s3ArchivedKey     = "archivedkey"
 )
 
+// awsError lets mockMultiUploadFailure embed an awserr.Error without its Error field shadowing the Error method
+type awsError awserr.Error
+
+type mockMultiUploadFailure struct {
+	awsError
+	uploadID string
+}
+
+func (m mockMultiUploadFailure) UploadID() string {
+	return m.uploadID
+}
+
 const s3TestBucketName = "s3bucketbackendtest"
 
 func (m *mockS3Client) DeleteObjectWithContext(ctx aws.Context, in *s3.DeleteObjectInput, _ ...request.Option) (*s3.DeleteObjectOutput, error) {
@@ -68,6 +89,22 @@ func (m *mockS3Client) DeleteObjectWithContext(ctx aws.Context, in *s3.DeleteObj
 	return nil, nil
 }
 
+func (m *mockS3Client) ListObjectVersionsPagesWithContext(ctx aws.Context, in *s3.ListObjectVersionsInput, fn func(*s3.ListObjectVersionsOutput, bool) bool, _ ...request.Option) error {
+	if *in.Prefix == s3BadKey {
+		return errTest
+	}
+
+	// A key sharing the prefix of the one listed and a version kept in a second page
+	fn(&s3.ListObjectVersionsOutput{Versions: []*s3.ObjectVersion{
+		{Key: aws.String(*in.Prefix), VersionId: aws.String("v2")},
+		{Key: aws.String(*in.Prefix + "2"), VersionId: aws.String("v1")},
+	}}, false)
+	fn(&s3.ListObjectVersionsOutput{Versions: []*s3.ObjectVersion{
+		{Key: aws.String(*in.Prefix), VersionId: aws.String("v1")},
+	}}, true)
+	return nil
+}
+
 func (m *mockS3Client) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
 	if *in.Key == s3BadKey {
 		return nil, errTest
@@ -132,6 +169,21 @@ func (m *mockS3Client) HeadObjectWithContext(ctx aws.Context, in *s3.HeadObjectI
 			ContentLength: aws.Int64(50),
 			Restore:       aws.String(restoreString),
 		}, nil
+	case s3LargeKey:
+		return &s3.HeadObjectOutput{
+			StorageClass:  aws.String(s3.ObjectStorageClassStandard),
+			ContentLength: aws.Int64(s3MaxCopySize + 1),
+		}, nil
+	case s3InfrequentKey:
+		return &s3.HeadObjectOutput{
+			StorageClass:  aws.String(s3.ObjectStorageClassStandardIa),
+			ContentLength: aws.Int64(50),
+		}, nil
+	case s3ArchivedKey:
+		return &s3.HeadObjectOutput{
+			StorageClass:  aws.String(s3.ObjectStorageClassDeepArchive),
+			ContentLength: aws.Int64(50),
+		}, nil
 	case "needsrestore":
 		return &s3.HeadObjectOutput{
 			StorageClass:  aws.String(s3.ObjectStorageClassGlacier),
@@ -146,6 +198,15 @@ func (m *mockS3Client) HeadObjectWithContext(ctx aws.Context, in *s3.HeadObjectI
 	}
 }
 
+func (m *mockS3Client) CopyObjectWithContext(ctx aws.Context, in *s3.CopyObjectInput, _ ...request.Option) (*s3.CopyObjectOutput, error) {
+	if *in.Key == s3BadKey {
+		return nil, errTest
+	}
+	m.copied = append(m.copied, *in.CopySource)
+	m.copiedClasses = append(m.copiedClasses, aws.StringValue(in.StorageClass))
+	return nil, nil
+}
+
 func (m *mockS3Client) RestoreObjectWithContext(ctx aws.Context, in *s3.RestoreObjectInput, _ ...request.Option) (*s3.RestoreObjectOutput, error) {
 	switch *in.Key {
 	case s3BadKey:
@@ -156,10 +217,22 @@ func (m *mockS3Client) RestoreObjectWithContext(ctx aws.Context, in *s3.RestoreO
 	return nil, nil
 }
 
+func (m *mockS3Client) AbortMultipartUploadWithContext(ctx aws.Context, in *s3.AbortMultipartUploadInput, _ ...request.Option) (*s3.AbortMultipartUploadOutput, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	m.aborted = append(m.aborted, *in.UploadId)
+	return nil, nil
+}
+
 func (m *mockS3Uploader) UploadWithContext(ctx aws.Context, in *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
-	if *in.Key == s3BadKey {
+	switch *in.Key {
+	case s3BadKey:
 		return nil, errTest
+	case s3MultipartFailed:
+		return nil, mockMultiUploadFailure{awserr.New("MultipartUpload", "upload multipart failed", ctx.Err()), "uploadid"}
 	}
+	m.uploaded = in
 	return nil, nil
 }
 
@@ -383,6 +456,91 @@ func TestS3Upload(t *testing.T) {
 	}
 }
 
+func TestS3UploadAbortsCanceledMultipartUpload(t *testing.T) {
+	_, goodvol, _, err := prepareTestVols()
+	if err != nil {
+		t.Fatalf("error preparing volume for testing - %v", err)
+	}
+	goodvol.ObjectName = s3MultipartFailed
+
+	testCases := []struct {
+		canceled bool
+		aborted  int
+	}{
+		{false, 0},
+		{true, 1},
+	}
+
+	for idx, c := range testCases {
+		client := &mockS3Client{}
+		b := &AWSS3Backend{}
+		if err = b.Init(context.Background(), &BackendConfig{TargetURI: AWSS3BackendPrefix + "://goodbucket"}, WithS3Client(client), WithS3Uploader(&mockS3Uploader{})); err != nil {
+			t.Fatalf("%d: Did not get expected nil error on Init, got %v instead", idx, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if c.canceled {
+			cancel()
+		}
+		if err = b.Upload(ctx, goodvol); err == nil {
+			t.Errorf("%d: Expected an error uploading the volume", idx)
+		}
+		cancel()
+		if len(client.aborted) != c.aborted {
+			t.Errorf("%d: Expected %d aborted multipart uploads, got %v", idx, c.aborted, client.aborted)
+		}
+	}
+}
+
+func TestS3UploadObjectLabels(t *testing.T) {
+	testCases := []struct {
+		tags     map[string]string
+		metadata map[string]string
+		tagging  string
+	}{
+		{nil, nil, ""},
+		{
+			map[string]string{"dataset": "Tank/Data", "type": "full"},
+			map[string]string{"job": "nightly"},
+			"dataset=Tank%2FData&type=full",
+		},
+	}
+
+	for idx, c := range testCases {
+		_, goodvol, _, err := prepareTestVols()
+		if err != nil {
+			t.Fatalf("error preparing volume for testing - %v", err)
+		}
+		if err = goodvol.OpenVolume(); err != nil {
+			t.Fatalf("could not open good volume due to error %v", err)
+		}
+		goodvol.ObjectName = "goodkey"
+
+		uploader := &mockS3Uploader{}
+		b := &AWSS3Backend{}
+		conf := &BackendConfig{TargetURI: AWSS3BackendPrefix + "://goodbucket", ObjectTags: c.tags, ObjectMetadata: c.metadata}
+		if err = b.Init(context.Background(), conf, WithS3Client(&mockS3Client{}), WithS3Uploader(uploader)); err != nil {
+			t.Fatalf("%d: Did not get expected nil error on Init, got %v instead", idx, err)
+		}
+		if err = b.Upload(context.Background(), goodvol); err != nil {
+			t.Fatalf("%d: Did not get expected nil error on Upload, got %v instead", idx, err)
+		}
+
+		if tagging := aws.StringValue(uploader.uploaded.Tagging); tagging != c.tagging {
+			t.Errorf("%d: Expected tagging %q, got %q", idx, c.tagging, tagging)
+		}
+		metadata := aws.StringValueMap(uploader.uploaded.Metadata)
+		if len(metadata) != len(c.metadata) {
+			t.Errorf("%d: Expected metadata %v, got %v", idx, c.metadata, metadata)
+		}
+		for key, value := range c.metadata {
+			if metadata[key] != value {
+				t.Errorf("%d: Expected metadata %v, got %v", idx, c.metadata, metadata)
+			}
+		}
+	}
+}
+
 func TestS3List(t *testing.T) {
 	testCases := []struct {
 		conf    *BackendConfig
@@ -424,6 +582,68 @@ func TestS3List(t *testing.T) {
 	}
 }
 
+func TestS3ObjectVersions(t *testing.T) {
+	testCases := []struct {
+		errTest  errTestFunc
+		key      string
+		versions []string
+	}{
+		{errTest: nilErrTest, key: "goodkey", versions: []string{"v2", "v1"}},
+		{errTest: errTestErrTest, key: s3BadKey},
+	}
+
+	for idx, c := range testCases {
+		b := &AWSS3Backend{}
+		if err := b.Init(context.Background(), &BackendConfig{TargetURI: AWSS3BackendPrefix + "://goodbucket"}, getOptions()...); err != nil {
+			t.Errorf("%d: Did not get expected nil error on Init, got %v instead", idx, err)
+		}
+		versions, err := b.ObjectVersions(context.Background(), c.key)
+		if !c.errTest(err) {
+			t.Errorf("%d: Did not get expected error, got %v instead", idx, err)
+		}
+		if !reflect.DeepEqual(versions, c.versions) {
+			t.Errorf("%d: Expected versions %v, got %v", idx, c.versions, versions)
+		}
+		if err = b.DeleteVersion(context.Background(), c.key, "v1"); !c.errTest(err) {
+			t.Errorf("%d: Did not get expected error deleting a version, got %v instead", idx, err)
+		}
+	}
+}
+
+func TestS3Move(t *testing.T) {
+	testCases := []struct {
+		errTest errTestFunc
+		from    string
+		to      string
+		copied  []string
+		classes []string
+	}{
+		{errTest: nilErrTest, from: "some key", to: "trash/some key", copied: []string{"goodbucket/some%20key"}, classes: []string{s3.StorageClassStandard}},
+		{errTest: errTestErrTest, from: s3BadKey, to: "trash/" + s3BadKey},
+		{errTest: errTestErrTest, from: "goodkey", to: s3BadKey},
+		{errTest: func(err error) bool { return err == ErrMoveNotSupported }, from: s3LargeKey, to: "trash/" + s3LargeKey},
+		{errTest: nilErrTest, from: s3InfrequentKey, to: "trash/" + s3InfrequentKey, copied: []string{"goodbucket/" + s3InfrequentKey}, classes: []string{s3.StorageClassStandardIa}},
+		{errTest: func(err error) bool { return err != nil && err != ErrMoveNotSupported }, from: s3ArchivedKey, to: "trash/" + s3ArchivedKey},
+		{errTest: nilErrTest, from: "needsrestore", to: "trash/needsrestore", copied: []string{"goodbucket/needsrestore"}, classes: []string{s3.StorageClassGlacier}},
+	}
+
+	for idx, c := range testCases {
+		b := &AWSS3Backend{}
+		if err := b.Init(context.Background(), &BackendConfig{TargetURI: AWSS3BackendPrefix + "://goodbucket"}, getOptions()...); err != nil {
+			t.Errorf("%d: Did not get expected nil error on Init, got %v instead", idx, err)
+		}
+		if err := b.Move(context.Background(), c.from, c.to); !c.errTest(err) {
+			t.Errorf("%d: Did not get expected error, got %v instead", idx, err)
+		}
+		if m, ok := b.client.(*mockS3Client); ok && !reflect.DeepEqual(m.copied, c.copied) {
+			t.Errorf("%d: Expected the copies %v, got %v", idx, c.copied, m.copied)
+		}
+		if m, ok := b.client.(*mockS3Client); ok && !reflect.DeepEqual(m.copiedClasses, c.classes) {
+			t.Errorf("%d: Expected the copies in the storage classes %v, got %v", idx, c.classes, m.copiedClasses)
+		}
+	}
+}
+
 func TestS3PreDownload(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode")
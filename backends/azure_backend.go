@@ -33,6 +33,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/pkg/errors"
@@ -64,11 +65,13 @@ type AzureBackend struct {
 	prefix        string
 	containerName string
 	containerSvc  azblob.ContainerURL
+	blocks        *helpers.BufferPool
 }
 
 // Init will initialize the AzureBackend and verify the provided URI is valid/exists.
 func (a *AzureBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Option) error {
 	a.conf = conf
+	a.blocks = helpers.NewBufferPool(conf.UploadChunkSize)
 
 	cleanPrefix := strings.TrimPrefix(a.conf.TargetURI, AzureBackendPrefix+"://")
 	if cleanPrefix == a.conf.TargetURI {
@@ -172,9 +175,11 @@ func (a *AzureBackend) Upload(ctx context.Context, vol *helpers.VolumeInfo) erro
 			blockSize = vol.Size - readBytes
 		}
 
-		buf := make([]byte, blockSize)
+		// The blocks are reused across volumes once staged
+		buf := a.blocks.Get()[:blockSize]
 		n, rerr := io.ReadFull(vol, buf)
 		if rerr != nil && rerr != io.ErrUnexpectedEOF {
+			a.blocks.Put(buf)
 			return rerr
 		}
 
@@ -188,10 +193,13 @@ func (a *AzureBackend) Upload(ctx context.Context, vol *helpers.VolumeInfo) erro
 			case a.conf.MaxParallelUploadBuffer <- true:
 				errg.Go(func() error {
 					defer func() { <-a.conf.MaxParallelUploadBuffer }()
+					defer a.blocks.Put(buf)
 					_, err := blobURL.StageBlock(ctx, blockID, bytes.NewReader(buf[:n]), azblob.LeaseAccessConditions{}, md5sum[:])
 					return err
 				})
 			}
+		} else {
+			a.blocks.Put(buf)
 		}
 
 		if !vol.IsUsingPipe() && readBytes == vol.Size || rerr == io.ErrUnexpectedEOF {
@@ -201,7 +209,7 @@ func (a *AzureBackend) Upload(ctx context.Context, vol *helpers.VolumeInfo) erro
 
 	err := errg.Wait()
 	if err != nil {
-		helpers.AppLogger.Debugf("azure backend: Error while uploading volume %s - %v", vol.ObjectName, err)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("azure backend: Error while uploading volume %s - %v", vol.ObjectName, err)
 		return err
 	}
 
@@ -211,9 +219,9 @@ func (a *AzureBackend) Upload(ctx context.Context, vol *helpers.VolumeInfo) erro
 	}
 
 	// Finally, finalize the storage blob by giving Azure the block list order
-	_, err = blobURL.CommitBlockList(ctx, blockIDs, azblob.BlobHTTPHeaders{ContentMD5: md5Raw}, azblob.Metadata{}, azblob.BlobAccessConditions{})
+	_, err = blobURL.CommitBlockList(ctx, blockIDs, azblob.BlobHTTPHeaders{ContentMD5: md5Raw}, azblob.Metadata(objectMetadata(a.conf)), azblob.BlobAccessConditions{})
 	if err != nil {
-		helpers.AppLogger.Debugf("azure backend: Error while finalizing volume %s - %v", vol.ObjectName, err)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("azure backend: Error while finalizing volume %s - %v", vol.ObjectName, err)
 	}
 	return err
 }
@@ -225,6 +233,36 @@ func (a *AzureBackend) Delete(ctx context.Context, name string) error {
 	return err
 }
 
+// Move will copy the given object within the configured container, waiting for the copy to complete, and then
+// delete it
+func (a *AzureBackend) Move(ctx context.Context, from, to string) error {
+	source := a.containerSvc.NewBlobURL(from)
+	destination := a.containerSvc.NewBlobURL(to)
+	resp, err := destination.StartCopyFromURL(ctx, source.URL(), azblob.Metadata{}, azblob.ModifiedAccessConditions{}, azblob.BlobAccessConditions{})
+	if err != nil {
+		return err
+	}
+
+	// Copies within a storage account usually complete right away
+	status := resp.CopyStatus()
+	for status == azblob.CopyStatusPending {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+		props, perr := destination.GetProperties(ctx, azblob.BlobAccessConditions{})
+		if perr != nil {
+			return perr
+		}
+		status = props.CopyStatus()
+	}
+	if status != azblob.CopyStatusSuccess {
+		return fmt.Errorf("azure backend: could not copy %s to %s, the copy is %s", from, to, status)
+	}
+	return a.Delete(ctx, from)
+}
+
 // PreDownload will do nothing for this backend.
 func (a *AzureBackend) PreDownload(ctx context.Context, keys []string) error {
 	return nil
@@ -249,22 +287,46 @@ func (a *AzureBackend) Close() error {
 // a list of blob names, filtering by the provided prefix.
 func (a *AzureBackend) List(ctx context.Context, prefix string) ([]string, error) {
 	l := make([]string, 0, 5000)
+	err := a.listBlobs(ctx, prefix, func(obj azblob.BlobItem) {
+		l = append(l, obj.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// ListVersions will iterate through all objects in the configured Azure Storage Container and return
+// the ETag of every blob found, filtering by the provided prefix.
+func (a *AzureBackend) ListVersions(ctx context.Context, prefix string) (map[string]string, error) {
+	versions := make(map[string]string)
+	err := a.listBlobs(ctx, prefix, func(obj azblob.BlobItem) {
+		versions[obj.Name] = string(obj.Properties.Etag)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
 
+func (a *AzureBackend) listBlobs(ctx context.Context, prefix string, fn func(azblob.BlobItem)) error {
 	for marker := (azblob.Marker{}); marker.NotDone(); {
 		resp, err := a.containerSvc.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{
 			Prefix:     prefix,
 			MaxResults: 5000,
 		})
 		if err != nil {
-			return nil, errors.Wrap(err, "error while listing blobs from container")
+			return errors.Wrap(err, "error while listing blobs from container")
 		}
 
 		for _, obj := range resp.Segment.BlobItems {
-			l = append(l, obj.Name)
+			fn(obj)
 		}
 
 		marker = resp.NextMarker
 	}
 
-	return l, nil
+	return nil
 }
@@ -115,9 +115,9 @@ func (b *B2Backend) Upload(ctx context.Context, vol *helpers.VolumeInfo) error {
 	sha1Opt := b2.WithAttrsOption(&b2.Attrs{SHA1: vol.SHA1Sum})
 	sha1Opt(w)
 
-	if _, err := io.Copy(w, vol); err != nil {
+	if _, err := helpers.CopySize(w, vol, b.conf.UploadReadSize); err != nil {
 		w.Close()
-		helpers.AppLogger.Debugf("b2 backend: Error while uploading volume %s - %v", vol.ObjectName, err)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("b2 backend: Error while uploading volume %s - %v", vol.ObjectName, err)
 		return err
 	}
 
@@ -41,6 +41,25 @@ type Backend interface {
 	Delete(ctx context.Context, filename string) error                    // Delete the file specified on the configured backend
 }
 
+// Versioner is implemented by the backends that can list a version of their objects, such as their
+// ETag or generation number, that changes whenever an object is replaced.
+type Versioner interface {
+	ListVersions(ctx context.Context, prefix string) (map[string]string, error) // Lists all files in the backend, filtering by the provided prefix, along with their version
+}
+
+// VersionDeleter is implemented by the backends that keep the prior versions of the objects replaced or deleted,
+// such as S3 buckets with versioning enabled, where an object is only destroyed once its versions are deleted.
+type VersionDeleter interface {
+	ObjectVersions(ctx context.Context, filename string) ([]string, error) // Lists the versions of the file kept, newest first
+	DeleteVersion(ctx context.Context, filename, version string) error     // Permanently delete the version of the file specified
+}
+
+// Mover is implemented by the backends that can move an object within the destination without downloading and
+// uploading it again, such as with a server-side copy of the object followed by its deletion.
+type Mover interface {
+	Move(ctx context.Context, from, to string) error // Move the file from to the file to, replacing it if it exists. Returns ErrMoveNotSupported, having done nothing, if the file can't be moved this way
+}
+
 // Option lets users inject functionality to specific backends
 type Option interface {
 	Apply(Backend)
@@ -54,6 +73,25 @@ type BackendConfig struct {
 	MaxRetryTime            time.Duration
 	TargetURI               string
 	UploadChunkSize         int
+	UploadReadSize          int               // The size of the reads of the uploaders streaming volumes, helpers.BufferSize if 0
+	ObjectTags              map[string]string // Tags of the objects uploaded, set as metadata by the backends without object tags
+	ObjectMetadata          map[string]string // Metadata of the objects uploaded, where the backend supports it
+}
+
+// objectMetadata returns the metadata of the objects uploaded with the configuration provided, along with
+// its object tags for the backends that have none. Metadata wins over a tag of the same key.
+func objectMetadata(conf *BackendConfig) map[string]string {
+	if len(conf.ObjectTags) == 0 && len(conf.ObjectMetadata) == 0 {
+		return nil
+	}
+	metadata := make(map[string]string, len(conf.ObjectTags)+len(conf.ObjectMetadata))
+	for key, value := range conf.ObjectTags {
+		metadata[key] = value
+	}
+	for key, value := range conf.ObjectMetadata {
+		metadata[key] = value
+	}
+	return metadata
 }
 
 var (
@@ -61,6 +99,8 @@ var (
 	ErrInvalidURI = errors.New("backends: invalid URI provided to backend")
 	// ErrInvalidPrefix is returned when a backend destination is provided with a URI prefix that isn't registered.
 	ErrInvalidPrefix = errors.New("backends: the provided prefix does not exist")
+	// ErrMoveNotSupported is returned by a Mover that can't move the object asked for within the destination.
+	ErrMoveNotSupported = errors.New("backends: the object can not be moved within the destination")
 )
 
 // GetBackendForURI will try and parse the URI for a matching backend to use.
@@ -70,10 +70,10 @@ func (d *DeleteBackend) List(ctx context.Context, prefix string) ([]string, erro
 // Upload will delete the provided volume, usually found in a temporary folder
 func (d *DeleteBackend) Upload(ctx context.Context, vol *helpers.VolumeInfo) error {
 	if err := vol.DeleteVolume(); err != nil {
-		helpers.AppLogger.Errorf("delete backend: could not delete volume %s due to error: %v", vol.ObjectName, err)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("delete backend: could not delete volume %s due to error: %v", vol.ObjectName, err)
 		return err
 	}
-	helpers.AppLogger.Debugf("delete backend: Deleted Volume %s", vol.ObjectName)
+	helpers.EnvironmentOf(ctx).Logger.Debugf("delete backend: Deleted Volume %s", vol.ObjectName)
 
 	return nil
 }
@@ -22,6 +22,7 @@ package backends
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -50,18 +51,18 @@ func (f *FileBackend) Init(ctx context.Context, conf *BackendConfig, opts ...Opt
 
 	absLocalPath, err := filepath.Abs(cleanPrefix)
 	if err != nil {
-		helpers.AppLogger.Errorf("file backend: Error while verifying path %s - %v", cleanPrefix, err)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("file backend: Error while verifying path %s - %v", cleanPrefix, err)
 		return err
 	}
 
 	fi, err := os.Stat(absLocalPath)
 	if err != nil {
-		helpers.AppLogger.Errorf("file backend: Error while verifying path %s - %v", absLocalPath, err)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("file backend: Error while verifying path %s - %v", absLocalPath, err)
 		return err
 	}
 
 	if !fi.IsDir() {
-		helpers.AppLogger.Errorf("file backend: Provided path is not a directory!")
+		helpers.EnvironmentOf(ctx).Logger.Errorf("file backend: Provided path is not a directory!")
 		return ErrInvalidURI
 	}
 
@@ -80,19 +81,24 @@ func (f *FileBackend) Upload(ctx context.Context, vol *helpers.VolumeInfo) error
 	destinationDir := filepath.Dir(destinationPath)
 
 	if err := os.MkdirAll(destinationDir, os.ModePerm); err != nil {
-		helpers.AppLogger.Debugf("file backend: Could not create path %s due to error - %v", destinationDir, err)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("file backend: Could not create path %s due to error - %v", destinationDir, err)
 		return err
 	}
 
 	w, err := os.Create(destinationPath)
 	if err != nil {
-		helpers.AppLogger.Debugf("file backend: Could not create file %s due to error - %v", destinationPath, err)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("file backend: Could not create file %s due to error - %v", destinationPath, err)
 		return err
 	}
 
-	_, err = io.Copy(w, vol)
+	_, err = helpers.CopySize(w, vol, f.conf.UploadReadSize)
 	if err != nil {
-		helpers.AppLogger.Debugf("file backend: Error while copying volume %s - %v", vol.ObjectName, err)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("file backend: Error while copying volume %s - %v", vol.ObjectName, err)
+		// Don't leave a partial volume behind
+		w.Close()
+		if rerr := os.Remove(destinationPath); rerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("file backend: Could not delete partially copied file %s - %v", destinationPath, rerr)
+		}
 		return err
 	}
 
@@ -104,6 +110,15 @@ func (f *FileBackend) Delete(ctx context.Context, filename string) error {
 	return os.Remove(filepath.Join(f.localPath, filename))
 }
 
+// Move will rename the given object within the provided path
+func (f *FileBackend) Move(ctx context.Context, from, to string) error {
+	destinationPath := filepath.Join(f.localPath, to)
+	if err := os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(f.localPath, from), destinationPath)
+}
+
 // PreDownload does nothing on this backend.
 func (f *FileBackend) PreDownload(ctx context.Context, objects []string) error {
 	return nil
@@ -122,17 +137,33 @@ func (f *FileBackend) Close() error {
 // List will return a list of all files matching the provided prefix
 func (f *FileBackend) List(ctx context.Context, prefix string) ([]string, error) {
 	l := make([]string, 0, 1000)
-	err := filepath.Walk(f.localPath, func(path string, fi os.FileInfo, werr error) error {
+	err := f.walk(prefix, func(name string, fi os.FileInfo) {
+		l = append(l, name)
+	})
+
+	return l, err
+}
+
+// ListVersions will return the size and modification time of all files matching the provided prefix
+func (f *FileBackend) ListVersions(ctx context.Context, prefix string) (map[string]string, error) {
+	versions := make(map[string]string)
+	err := f.walk(prefix, func(name string, fi os.FileInfo) {
+		versions[name] = fmt.Sprintf("%d-%d", fi.Size(), fi.ModTime().UnixNano())
+	})
+
+	return versions, err
+}
+
+func (f *FileBackend) walk(prefix string, fn func(name string, fi os.FileInfo)) error {
+	return filepath.Walk(f.localPath, func(path string, fi os.FileInfo, werr error) error {
 		if werr != nil {
 			return werr
 		}
 
 		trimmedPath := strings.TrimPrefix(path, f.localPath+string(filepath.Separator))
 		if !fi.IsDir() && strings.HasPrefix(trimmedPath, prefix) {
-			l = append(l, trimmedPath)
+			fn(trimmedPath, fi)
 		}
 		return nil
 	})
-
-	return l, err
 }
@@ -136,6 +136,40 @@ func TestFileDelete(t *testing.T) {
 	}
 }
 
+func TestFileMove(t *testing.T) {
+	w, err := ioutil.TempFile("", "filebackendtestfile")
+	if err != nil {
+		t.Fatalf("Error trying to create a tempfile: %v", err)
+	}
+	w.Close()
+	defer os.Remove(w.Name())
+
+	tempName := strings.TrimPrefix(w.Name(), os.TempDir())
+	movedName := filepath.Join("filebackendtestmove", tempName)
+	defer os.RemoveAll(filepath.Join(os.TempDir(), "filebackendtestmove"))
+
+	b := &FileBackend{}
+	if err := b.Init(context.Background(), validFileConfig); err != nil {
+		t.Errorf("Expected error %v, got %v", nil, err)
+	} else {
+		if err = b.Move(context.Background(), tempName, movedName); err != nil {
+			t.Errorf("Expected nil error, got %v", err)
+		}
+
+		// Verify it happened
+		if _, err = os.Stat(w.Name()); !os.IsNotExist(err) {
+			t.Errorf("Expected does not exist error, got %v", err)
+		}
+		if _, err = os.Stat(filepath.Join(os.TempDir(), movedName)); err != nil {
+			t.Errorf("Expected the moved file to exist, got %v", err)
+		}
+
+		if err = b.Move(context.Background(), tempName, movedName); !os.IsNotExist(err) {
+			t.Errorf("Expected does not exist error moving a missing file, got %v", err)
+		}
+	}
+}
+
 func TestFileList(t *testing.T) {
 	tempDir, err := ioutil.TempDir("", "filebackendtesttempdir")
 	if err != nil {
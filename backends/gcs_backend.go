@@ -24,6 +24,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"cloud.google.com/go/storage"
@@ -53,9 +54,11 @@ type GoogleCloudStorageBackend struct {
 type GCSClientInterface interface {
 	BucketExists(context.Context, string) error
 	DeleteObject(c context.Context, b, o string) error
-	NewWriter(c context.Context, b, o string, h uint32, chunkSize int) io.WriteCloser
+	CopyObject(c context.Context, b, src, dst string) error
+	NewWriter(c context.Context, b, o string, h uint32, chunkSize int, metadata map[string]string) io.WriteCloser
 	NewReader(c context.Context, b, o string) (io.ReadCloser, error)
 	ListBucket(c context.Context, b, p string) ([]string, error)
+	ListBucketGenerations(c context.Context, b, p string) (map[string]int64, error)
 	Close() error
 }
 
@@ -73,8 +76,15 @@ func (g *gcsClient) DeleteObject(ctx context.Context, bucket, object string) err
 	return g.client.Bucket(bucket).Object(object).Delete(ctx)
 }
 
-func (g *gcsClient) NewWriter(ctx context.Context, bucket, object string, crc32Hash uint32, chunkSize int) io.WriteCloser {
+func (g *gcsClient) CopyObject(ctx context.Context, bucket, src, dst string) error {
+	b := g.client.Bucket(bucket)
+	_, err := b.Object(dst).CopierFrom(b.Object(src)).Run(ctx)
+	return err
+}
+
+func (g *gcsClient) NewWriter(ctx context.Context, bucket, object string, crc32Hash uint32, chunkSize int, metadata map[string]string) io.WriteCloser {
 	w := g.client.Bucket(bucket).Object(object).NewWriter(ctx)
+	w.Metadata = metadata
 	w.CRC32C = crc32Hash
 	w.SendCRC32C = true
 	w.ChunkSize = chunkSize
@@ -90,21 +100,42 @@ func (g *gcsClient) Close() error {
 }
 
 func (g *gcsClient) ListBucket(ctx context.Context, bucket, prefix string) ([]string, error) {
+	l := make([]string, 0, 1000)
+	err := g.listObjects(ctx, bucket, prefix, func(attrs *storage.ObjectAttrs) {
+		l = append(l, attrs.Name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (g *gcsClient) ListBucketGenerations(ctx context.Context, bucket, prefix string) (map[string]int64, error) {
+	generations := make(map[string]int64)
+	err := g.listObjects(ctx, bucket, prefix, func(attrs *storage.ObjectAttrs) {
+		generations[attrs.Name] = attrs.Generation
+	})
+	if err != nil {
+		return nil, err
+	}
+	return generations, nil
+}
+
+func (g *gcsClient) listObjects(ctx context.Context, bucket, prefix string, fn func(*storage.ObjectAttrs)) error {
 	q := &storage.Query{Prefix: prefix}
 	objects := g.client.Bucket(bucket).Objects(ctx, q)
-	l := make([]string, 0, 1000)
 	for {
 		attrs, err := objects.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("gs backend: could not list bucket due to error - %v", err)
+			return fmt.Errorf("gs backend: could not list bucket due to error - %v", err)
 		}
 
-		l = append(l, attrs.Name)
+		fn(attrs)
 	}
-	return l, nil
+	return nil
 }
 
 type withGCSClient struct{ client GCSClientInterface }
@@ -161,10 +192,10 @@ func (g *GoogleCloudStorageBackend) Upload(ctx context.Context, vol *helpers.Vol
 	}()
 
 	objName := g.prefix + vol.ObjectName
-	w := g.client.NewWriter(ctx, g.bucketName, objName, vol.CRC32CSum32, g.conf.UploadChunkSize)
-	if _, err := io.Copy(w, vol); err != nil {
+	w := g.client.NewWriter(ctx, g.bucketName, objName, vol.CRC32CSum32, g.conf.UploadChunkSize, objectMetadata(g.conf))
+	if _, err := helpers.CopySize(w, vol, g.conf.UploadReadSize); err != nil {
 		w.Close()
-		helpers.AppLogger.Debugf("gs backend: Error while uploading volume %s - %v", vol.ObjectName, err)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("gs backend: Error while uploading volume %s - %v", vol.ObjectName, err)
 		return err
 	}
 	return w.Close()
@@ -176,6 +207,14 @@ func (g *GoogleCloudStorageBackend) Delete(ctx context.Context, filename string)
 	return g.client.DeleteObject(ctx, g.bucketName, filename)
 }
 
+// Move will copy the given object within the configured bucket and then delete it
+func (g *GoogleCloudStorageBackend) Move(ctx context.Context, from, to string) error {
+	if err := g.client.CopyObject(ctx, g.bucketName, from, to); err != nil {
+		return err
+	}
+	return g.client.DeleteObject(ctx, g.bucketName, from)
+}
+
 // PreDownload does nothing on this backend.
 func (g *GoogleCloudStorageBackend) PreDownload(ctx context.Context, objects []string) error {
 	return nil
@@ -199,3 +238,18 @@ func (g *GoogleCloudStorageBackend) Close() error {
 func (g *GoogleCloudStorageBackend) List(ctx context.Context, prefix string) ([]string, error) {
 	return g.client.ListBucket(ctx, g.bucketName, prefix)
 }
+
+// ListVersions will iterate through all objects in the configured GCS bucket and return
+// the generation number of every object found, filtering by the prefix provided.
+func (g *GoogleCloudStorageBackend) ListVersions(ctx context.Context, prefix string) (map[string]string, error) {
+	generations, err := g.client.ListBucketGenerations(ctx, g.bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]string, len(generations))
+	for name, generation := range generations {
+		versions[name] = strconv.FormatInt(generation, 10)
+	}
+	return versions, nil
+}
@@ -49,7 +49,11 @@ func (g *gcsMockClient) DeleteObject(ctx context.Context, bucket, object string)
 	return g.err
 }
 
-func (g *gcsMockClient) NewWriter(ctx context.Context, bucket, object string, crc32Hash uint32, chunkSize int) io.WriteCloser {
+func (g *gcsMockClient) CopyObject(ctx context.Context, bucket, src, dst string) error {
+	return g.err
+}
+
+func (g *gcsMockClient) NewWriter(ctx context.Context, bucket, object string, crc32Hash uint32, chunkSize int, metadata map[string]string) io.WriteCloser {
 	return g.writer
 }
 
@@ -65,6 +69,14 @@ func (g *gcsMockClient) ListBucket(ctx context.Context, bucket, prefix string) (
 	return g.list, g.err
 }
 
+func (g *gcsMockClient) ListBucketGenerations(ctx context.Context, bucket, prefix string) (map[string]int64, error) {
+	generations := make(map[string]int64, len(g.list))
+	for idx, name := range g.list {
+		generations[name] = int64(idx + 1)
+	}
+	return generations, g.err
+}
+
 const (
 	testBucketGood = GoogleCloudStorageBackendPrefix + "://bucketname"
 )
@@ -256,6 +268,42 @@ func TestGCSDelete(t *testing.T) {
 	}
 }
 
+func TestGCSMove(t *testing.T) {
+	testCases := []struct {
+		testcase gcsTestCase
+		output   error
+	}{
+		{
+			testcase: gcsTestCase{
+				client: validClient,
+				conf:   validConfig,
+			},
+			output: nil,
+		},
+		{
+			testcase: gcsTestCase{
+				client: &gcsMockClient{
+					err: errTest,
+				},
+				conf: validConfig,
+			},
+			output: errTest,
+		},
+	}
+
+	for idx, c := range testCases {
+		b := &GoogleCloudStorageBackend{}
+		if err := b.Init(context.Background(), c.testcase.conf, WithGCSClient(c.testcase.client)); err != nil {
+			t.Errorf("%d: error setting up backend - %v", idx, err)
+		} else {
+			err = b.Move(context.Background(), "from", "trash/from")
+			if err != c.output {
+				t.Errorf("%d: Expected %v, got %v", idx, c.output, err)
+			}
+		}
+	}
+}
+
 func TestGCSList(t *testing.T) {
 	testList := []string{"l", "m", "n"}
 	testCases := []struct {
@@ -0,0 +1,281 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// The replication tools Adopt can take a dataset over from.
+const (
+	ToolZrepl    = "zrepl"
+	ToolSyncoid  = "syncoid"
+	ToolZnapzend = "znapzend"
+)
+
+// AdoptTools lists the replication tools Adopt can take a dataset over from.
+var AdoptTools = []string{ToolZrepl, ToolSyncoid, ToolZnapzend}
+
+const zreplLegacyCursor = "zrepl_replication_cursor"
+
+var (
+	zreplCursor         = regexp.MustCompile(`^zrepl_CURSOR_G_[0-9a-f]{16}_J_(.+)$`)
+	zreplLastReceived   = regexp.MustCompile(`^zrepl_last_received_J_(.+)$`)
+	syncoidSnapshot     = regexp.MustCompile(`^syncoid_(.+)_\d{4}-\d{2}-\d{2}:\d{2}:\d{2}:\d{2}`)
+	znapzendDestination = regexp.MustCompile(`^org\.znapzend:dst_([^_]+)$`)
+)
+
+// Adopt will register the snapshot the replication tool provided last sent off-site for the volume described by
+// jobInfo as a full backup set at every destination, so the "smart" options of the send command chain incremental
+// backups onto it instead of sending a full backup first. The backup set only has a manifest: restoring it means
+// receiving the snapshot from the copy the tool keeps, the incremental backups are then restored on top of it.
+// The job is the zrepl job, the syncoid identifier and host, or the znapzend destination the tool sends the volume
+// for, and may be left empty unless there are several. The snapshot is looked up unless jobInfo names one.
+func Adopt(ctx context.Context, jobInfo *helpers.JobInfo, tool, job string) error {
+	if jobInfo.BaseSnapshot.Name == "" {
+		snapshot, err := findHandover(ctx, jobInfo.VolumeName, tool, job)
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not find the snapshot %s last sent for %s - %v", tool, jobInfo.VolumeName, err)
+			return err
+		}
+		jobInfo.BaseSnapshot = helpers.SnapshotInfo{Name: snapshot.Name, CreationTime: snapshot.CreationTime}
+	} else if jobInfo.BaseSnapshot.CreationTime.IsZero() {
+		creationTime, err := helpers.GetCreationDate(ctx, fmt.Sprintf("%s@%s", jobInfo.VolumeName, jobInfo.BaseSnapshot.Name))
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error trying to get creation date of snapshot %s - %v", jobInfo.BaseSnapshot.Name, err)
+			return err
+		}
+		jobInfo.BaseSnapshot.CreationTime = creationTime
+	}
+
+	for _, destination := range jobInfo.Destinations {
+		sets, err := getBackupsForTarget(ctx, jobInfo.VolumeName, destination, jobInfo)
+		if err != nil {
+			return err
+		}
+		if len(sets) != 0 {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("The destination %s already holds %d backup sets of %s, only a volume that was never backed up can be adopted.", destination, len(sets), jobInfo.VolumeName)
+			return fmt.Errorf("%s already holds backup sets of %s", destination, jobInfo.VolumeName)
+		}
+	}
+
+	jobInfo.External = tool
+	jobInfo.IncrementalSnapshot = helpers.SnapshotInfo{}
+	jobInfo.Volumes = nil
+	jobInfo.EndTime = time.Now()
+	if err := resolveManifestFormat(ctx, jobInfo); err != nil {
+		return err
+	}
+
+	manifest, err := saveManifest(ctx, jobInfo, true)
+	if err != nil {
+		return err
+	}
+	defer manifest.DeleteVolume()
+
+	if err = uploadManifest(ctx, jobInfo, manifest, jobInfo.Destinations); err != nil {
+		return err
+	}
+	jobInfo.Manifests = append(jobInfo.Manifests, helpers.ManifestObjectName(jobInfo))
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Adopted %s@%s, last sent by %s, as a full backup set.", jobInfo.VolumeName, jobInfo.BaseSnapshot.Name, tool)
+	return nil
+}
+
+// findHandover will return the latest snapshot of the volume the replication tool sent for the job provided.
+func findHandover(ctx context.Context, volume, tool, job string) (helpers.ZFSObject, error) {
+	objects, err := helpers.GetSnapshotsAndBookmarks(ctx, volume)
+	if err != nil {
+		return helpers.ZFSObject{}, err
+	}
+
+	switch tool {
+	case ToolZrepl:
+		var names []string
+		for _, object := range objects {
+			if !object.Bookmark {
+				names = append(names, fmt.Sprintf("%s@%s", volume, object.Name))
+			}
+		}
+		holds, herr := helpers.GetZFSHolds(ctx, names...)
+		if herr != nil {
+			return helpers.ZFSObject{}, herr
+		}
+		snapshotHolds := make(map[string][]string, len(holds))
+		for name, tags := range holds {
+			snapshotHolds[name[strings.Index(name, "@")+1:]] = tags
+		}
+		return zreplHandover(objects, snapshotHolds, job)
+	case ToolSyncoid:
+		return syncoidHandover(objects, job)
+	case ToolZnapzend:
+		datasetProperties, perr := helpers.GetZFSProperties(ctx, volume, false)
+		if perr != nil {
+			return helpers.ZFSObject{}, perr
+		}
+		var synced []string
+		for property := range datasetProperties[volume] {
+			if match := znapzendDestination.FindStringSubmatch(property); match != nil {
+				synced = append(synced, znapzendSynced(match[1]))
+			}
+		}
+		if len(synced) == 0 {
+			return helpers.ZFSObject{}, fmt.Errorf("%s has no znapzend destination", volume)
+		}
+		snapshotProperties, perr := helpers.GetZFSProperties(ctx, volume, true, synced...)
+		if perr != nil {
+			return helpers.ZFSObject{}, perr
+		}
+		properties := make(map[string]map[string]string, len(snapshotProperties))
+		for name, values := range snapshotProperties {
+			properties[name[strings.Index(name, "@")+1:]] = values
+		}
+		return znapzendHandover(objects, datasetProperties[volume], properties, job)
+	}
+	return helpers.ZFSObject{}, fmt.Errorf("unsupported replication tool %s, expected one of %s", tool, strings.Join(AdoptTools, ", "))
+}
+
+// zreplHandover will return the snapshot zrepl last replicated for the job provided given the snapshots and bookmarks
+// of a dataset, newest first, and the tags of the holds on its snapshots. On the sending side zrepl keeps a replication
+// cursor bookmark of the last snapshot sent by every job, a zrepl_replication_cursor bookmark with releases before
+// 0.3, while on the receiving side it holds the last snapshot received by every job. The bookmark is returned when
+// its snapshot was destroyed.
+func zreplHandover(objects []helpers.ZFSObject, holds map[string][]string, job string) (helpers.ZFSObject, error) {
+	snapshots := make(map[string]helpers.ZFSObject)
+	for _, object := range objects {
+		if !object.Bookmark {
+			if _, ok := snapshots[object.GUID]; !ok {
+				snapshots[object.GUID] = object
+			}
+		}
+	}
+
+	candidates := make(map[string]helpers.ZFSObject)
+	add := func(name string, object helpers.ZFSObject) {
+		if _, ok := candidates[name]; !ok {
+			candidates[name] = object
+		}
+	}
+	for _, object := range objects {
+		if object.Bookmark {
+			name := object.Name
+			if match := zreplCursor.FindStringSubmatch(object.Name); match != nil {
+				name = match[1]
+			} else if object.Name != zreplLegacyCursor {
+				continue
+			}
+			if snapshot, ok := snapshots[object.GUID]; ok {
+				object = snapshot
+			}
+			add(name, object)
+			continue
+		}
+		for _, tag := range holds[object.Name] {
+			if match := zreplLastReceived.FindStringSubmatch(tag); match != nil {
+				add(match[1], object)
+			}
+		}
+	}
+
+	handover, err := pickHandover(ToolZrepl, "job", candidates, job)
+	if err == nil && handover.Bookmark {
+		return handover, fmt.Errorf("the snapshot zrepl last replicated was destroyed, only its bookmark %s is left. Let zrepl replicate a newer snapshot first", handover.Name)
+	}
+	return handover, err
+}
+
+// syncoidHandover will return the snapshot syncoid last synced for the identifier and host provided, as they appear
+// in the name of its sync snapshots, given the snapshots and bookmarks of a dataset, newest first. Syncoid keeps the
+// latest sync snapshot of every target it syncs to.
+func syncoidHandover(objects []helpers.ZFSObject, job string) (helpers.ZFSObject, error) {
+	candidates := make(map[string]helpers.ZFSObject)
+	for _, object := range objects {
+		if match := syncoidSnapshot.FindStringSubmatch(object.Name); match != nil && !object.Bookmark {
+			if _, ok := candidates[match[1]]; !ok {
+				candidates[match[1]] = object
+			}
+		}
+	}
+	return pickHandover(ToolSyncoid, "identifier and host", candidates, job)
+}
+
+// znapzendSynced will return the property znapzend marks the snapshots sent to the destination provided with.
+func znapzendSynced(destination string) string {
+	return fmt.Sprintf("org.znapzend:dst_%s_synced", destination)
+}
+
+// znapzendHandover will return the snapshot znapzend last sent to the destination provided, e.g. a for the dst_a
+// destination of a backup plan, given the snapshots and bookmarks of a dataset, newest first, its properties, and
+// the properties of its snapshots. Znapzend marks every snapshot it sent to a destination as synced to it.
+func znapzendHandover(objects []helpers.ZFSObject, properties map[string]string, snapshotProperties map[string]map[string]string, job string) (helpers.ZFSObject, error) {
+	var destinations []string
+	for property := range properties {
+		if match := znapzendDestination.FindStringSubmatch(property); match != nil {
+			destinations = append(destinations, match[1])
+		}
+	}
+	if len(destinations) == 0 {
+		return helpers.ZFSObject{}, fmt.Errorf("found no znapzend backup plan")
+	}
+
+	candidates := make(map[string]helpers.ZFSObject)
+	for _, destination := range destinations {
+		for _, object := range objects {
+			if synced := snapshotProperties[object.Name][znapzendSynced(destination)]; !object.Bookmark && synced != "" && synced != "0" {
+				candidates[destination] = object
+				break
+			}
+		}
+	}
+	return pickHandover(ToolZnapzend, "destination", candidates, job)
+}
+
+// pickHandover will return the candidate snapshot of the job provided, or the only one when no job is provided.
+func pickHandover(tool, kind string, candidates map[string]helpers.ZFSObject, job string) (helpers.ZFSObject, error) {
+	if job != "" {
+		if handover, ok := candidates[job]; ok {
+			return handover, nil
+		}
+		return helpers.ZFSObject{}, fmt.Errorf("found no snapshot %s sent for the %s %s", tool, kind, job)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return helpers.ZFSObject{}, fmt.Errorf("found no snapshot sent by %s", tool)
+	case 1:
+		for _, handover := range candidates {
+			return handover, nil
+		}
+	}
+
+	names := make([]string, 0, len(candidates))
+	for name := range candidates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return helpers.ZFSObject{}, fmt.Errorf("%s sends the dataset for more than one %s (%s), one must be chosen", tool, kind, strings.Join(names, ", "))
+}
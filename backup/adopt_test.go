@@ -0,0 +1,221 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func adoptObject(name string, bookmark bool, guid string, day int) helpers.ZFSObject {
+	return helpers.ZFSObject{Name: name, Bookmark: bookmark, GUID: guid, CreationTime: time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestZreplHandover(t *testing.T) {
+	sender := []helpers.ZFSObject{
+		adoptObject("zrepl_20240304", false, "4", 4),
+		adoptObject("zrepl_20240303", false, "3", 3),
+		adoptObject("zrepl_CURSOR_G_0000000000000003_J_offsite", true, "3", 3),
+		adoptObject("zrepl_20240302", false, "2", 2),
+		adoptObject("zrepl_CURSOR_G_0000000000000002_J_local", true, "2", 2),
+		adoptObject("zrepl_20240301", false, "1", 1),
+	}
+	legacy := []helpers.ZFSObject{
+		adoptObject("zrepl_20240302", false, "2", 2),
+		adoptObject("zrepl_20240301", false, "1", 1),
+		adoptObject(zreplLegacyCursor, true, "1", 1),
+	}
+	pruned := []helpers.ZFSObject{
+		adoptObject("zrepl_20240304", false, "4", 4),
+		adoptObject("zrepl_CURSOR_G_0000000000000003_J_offsite", true, "3", 3),
+	}
+	receiver := []helpers.ZFSObject{
+		adoptObject("zrepl_20240303", false, "3", 3),
+		adoptObject("zrepl_20240302", false, "2", 2),
+	}
+	receiverHolds := map[string][]string{"zrepl_20240303": {"zrepl_last_received_J_pull", "keep"}}
+
+	testCases := []struct {
+		objects []helpers.ZFSObject
+		holds   map[string][]string
+		job     string
+		expect  string
+		errTest errTestFunc
+	}{
+		{sender, nil, "offsite", "zrepl_20240303", nilErrTest},
+		{sender, nil, "local", "zrepl_20240302", nilErrTest},
+		{sender, nil, "", "", nonNilErrTest},
+		{sender, nil, "other", "", nonNilErrTest},
+		{legacy, nil, "", "zrepl_20240301", nilErrTest},
+		{pruned, nil, "", "", nonNilErrTest},
+		{receiver, receiverHolds, "", "zrepl_20240303", nilErrTest},
+		{receiver, nil, "", "", nonNilErrTest},
+	}
+
+	for idx, c := range testCases {
+		got, err := zreplHandover(c.objects, c.holds, c.job)
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if err == nil && got.Name != c.expect {
+			t.Errorf("%d: expected %s, got %s", idx, c.expect, got.Name)
+		}
+	}
+}
+
+func TestSyncoidHandover(t *testing.T) {
+	objects := []helpers.ZFSObject{
+		adoptObject("autosnap_2024-03-05_00:00:00_daily", false, "5", 5),
+		adoptObject("syncoid_backuphost_2024-03-04:00:00:00-GMT00:00", false, "4", 4),
+		adoptObject("syncoid_nas_offsitehost_2024-03-03:00:00:00", false, "3", 3),
+		adoptObject("syncoid_backuphost_2024-03-02:00:00:00-GMT00:00", true, "2", 2),
+		adoptObject("syncoid_nas_offsitehost_2024-03-01:00:00:00", false, "1", 1),
+	}
+
+	testCases := []struct {
+		objects []helpers.ZFSObject
+		job     string
+		expect  string
+		errTest errTestFunc
+	}{
+		{objects, "backuphost", "syncoid_backuphost_2024-03-04:00:00:00-GMT00:00", nilErrTest},
+		{objects, "nas_offsitehost", "syncoid_nas_offsitehost_2024-03-03:00:00:00", nilErrTest},
+		{objects, "", "", nonNilErrTest},
+		{objects[2:], "", "syncoid_nas_offsitehost_2024-03-03:00:00:00", nilErrTest},
+		{objects[:1], "", "", nonNilErrTest},
+	}
+
+	for idx, c := range testCases {
+		got, err := syncoidHandover(c.objects, c.job)
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if err == nil && got.Name != c.expect {
+			t.Errorf("%d: expected %s, got %s", idx, c.expect, got.Name)
+		}
+	}
+}
+
+func TestZnapzendHandover(t *testing.T) {
+	objects := []helpers.ZFSObject{
+		adoptObject("2024-03-04-000000", false, "4", 4),
+		adoptObject("2024-03-03-000000", false, "3", 3),
+		adoptObject("2024-03-02-000000", false, "2", 2),
+		adoptObject("2024-03-01-000000", false, "1", 1),
+	}
+	oneDestination := map[string]string{
+		"org.znapzend:enabled":    "on",
+		"org.znapzend:dst_a":      "backup/tank",
+		"org.znapzend:dst_a_plan": "1week=>1day",
+	}
+	twoDestinations := map[string]string{
+		"org.znapzend:dst_a": "backup/tank",
+		"org.znapzend:dst_b": "root@offsite:tank",
+	}
+	synced := map[string]map[string]string{
+		"2024-03-03-000000": {"org.znapzend:dst_a_synced": "1"},
+		"2024-03-02-000000": {"org.znapzend:dst_a_synced": "1", "org.znapzend:dst_b_synced": "1"},
+		"2024-03-01-000000": {"org.znapzend:dst_b_synced": "0"},
+	}
+
+	testCases := []struct {
+		properties map[string]string
+		synced     map[string]map[string]string
+		job        string
+		expect     string
+		errTest    errTestFunc
+	}{
+		{oneDestination, synced, "", "2024-03-03-000000", nilErrTest},
+		{twoDestinations, synced, "b", "2024-03-02-000000", nilErrTest},
+		{twoDestinations, synced, "", "", nonNilErrTest},
+		{oneDestination, nil, "", "", nonNilErrTest},
+		{map[string]string{"compression": "lz4"}, synced, "", "", nonNilErrTest},
+	}
+
+	for idx, c := range testCases {
+		got, err := znapzendHandover(objects, c.properties, c.synced, c.job)
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if err == nil && got.Name != c.expect {
+			t.Errorf("%d: expected %s, got %s", idx, c.expect, got.Name)
+		}
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackup-adopt")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = filepath.Join(dir, "work")
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	target := filepath.Join(dir, "target")
+	if err = os.MkdirAll(target, 0700); err != nil {
+		t.Fatalf("could not create target: %v", err)
+	}
+
+	newJob := func() *helpers.JobInfo {
+		return &helpers.JobInfo{
+			VolumeName:     "tank",
+			BaseSnapshot:   helpers.SnapshotInfo{Name: "zrepl_20240303", CreationTime: time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+			StartTime:      time.Now(),
+			Compressor:     helpers.InternalCompressor,
+			Separator:      "|",
+			ManifestPrefix: "manifests",
+			Destinations:   []string{"file://" + target},
+		}
+	}
+
+	if err = Adopt(context.Background(), newJob(), ToolZrepl, ""); err != nil {
+		t.Fatalf("could not adopt the snapshot: %v", err)
+	}
+
+	sets, err := getBackupsForTarget(context.Background(), "tank", "file://"+target, newJob())
+	if err != nil {
+		t.Fatalf("could not list the backup sets: %v", err)
+	}
+	if len(sets) != 1 {
+		t.Fatalf("expected 1 backup set, got %d", len(sets))
+	}
+	if sets[0].External != ToolZrepl || len(sets[0].Volumes) != 0 || sets[0].BaseSnapshot.Name != "zrepl_20240303" {
+		t.Errorf("unexpected adopted backup set %+v", sets[0])
+	}
+	if sameBackupSet(newJob(), sets[0]) {
+		t.Errorf("expected the adopted backup set not to match a full backup of the snapshot")
+	}
+
+	if err = Adopt(context.Background(), newJob(), ToolZrepl, ""); err == nil {
+		t.Errorf("expected an error adopting a volume with backup sets")
+	}
+}
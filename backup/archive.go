@@ -0,0 +1,165 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"io"
+
+	"github.com/cenkalti/backoff"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Archive gives read access to the backup sets stored in a destination without restoring them.
+type Archive struct {
+	jobInfo        *helpers.JobInfo
+	target         string
+	backend        backends.Backend
+	localCachePath string
+}
+
+// OpenArchive will initialize the backend for the first destination provided in jobInfo.
+// Close should be called on the Archive once done with it.
+func OpenArchive(ctx context.Context, jobInfo *helpers.JobInfo) (*Archive, error) {
+	target := jobInfo.Destinations[0]
+
+	backend, err := prepareBackend(ctx, jobInfo, target, nil)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+
+	localCachePath, err := getCacheDir(ctx, target)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, err)
+		backend.Close()
+		return nil, err
+	}
+
+	return &Archive{jobInfo: jobInfo, target: target, backend: backend, localCachePath: localCachePath}, nil
+}
+
+// BackupSets will sync the local cache with the destination and return the manifest of every
+// backup set found, sorted by volume name and creation time.
+func (a *Archive) BackupSets(ctx context.Context) ([]*helpers.JobInfo, error) {
+	safeManifests, _, err := syncCache(ctx, a.jobInfo, a.localCachePath, a.backend)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", a.target, err)
+		return nil, err
+	}
+
+	manifests, err := readAndSortManifests(ctx, a.localCachePath, safeManifests, a.jobInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, manifest := range manifests {
+		manifest.ManifestPrefix = a.jobInfo.ManifestPrefix
+		manifest.SignKey = a.jobInfo.SignKey
+		manifest.EncryptKey = a.jobInfo.EncryptKey
+	}
+	return manifests, nil
+}
+
+// Manifest will return the manifest of the backup set described by jobInfo.
+func (a *Archive) Manifest(ctx context.Context, jobInfo *helpers.JobInfo) (*helpers.JobInfo, error) {
+	return loadManifest(ctx, a.backend, a.localCachePath, jobInfo)
+}
+
+// ExtractVolume will download a volume of the backup set described by manifest, check it against
+// the hash recorded in the manifest, and write its decrypted and decompressed content, a part of the
+// original zfs send stream, to w.
+func (a *Archive) ExtractVolume(ctx context.Context, manifest *helpers.JobInfo, vol *helpers.VolumeInfo, w io.Writer) error {
+	be := backoff.NewExponentialBackOff()
+	be.MaxInterval = a.jobInfo.MaxBackoffTime
+	be.MaxElapsedTime = a.jobInfo.MaxRetryTime
+	retryconf := backoff.WithContext(be, ctx)
+
+	c := make(chan *helpers.VolumeInfo, 1)
+	sequence := downloadSequence{vol, c, nil}
+	operation := func() error {
+		oerr := processSequence(ctx, sequence, a.backend, false)
+		if oerr != nil {
+			helpers.LogFields{Dataset: a.jobInfo.VolumeName, Destination: a.target, Volume: vol.ObjectName, Err: oerr}.Warningf(ctx, "error trying to download file %s - %v", vol.ObjectName, oerr)
+		}
+		return oerr
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Downloading volume %s.", vol.ObjectName)
+	if err := backoff.RetryNotify(operation, retryconf, retryNotifier(ctx, a.jobInfo, vol, a.target)); err != nil {
+		helpers.LogFields{Dataset: a.jobInfo.VolumeName, Destination: a.target, Volume: vol.ObjectName, Err: err}.Errorf(ctx, "Failed to download volume %s due to error: %v.", vol.ObjectName, err)
+		return helpers.NewError(helpers.ErrorKindBackend, err)
+	}
+
+	downloaded := <-c
+	defer downloaded.DeleteVolume()
+
+	if err := downloaded.Extract(ctx, manifest, false); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, err)
+		return err
+	}
+	defer downloaded.Close()
+
+	if _, err := io.Copy(w, downloaded); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, err)
+		return err
+	}
+	return nil
+}
+
+// ExtractStream will write the original zfs send stream of the backup set described by manifest to w,
+// extracting its volumes in order with ExtractVolume and putting the stream of a backup set stored as
+// chunks back together from its layout volumes.
+func (a *Archive) ExtractStream(ctx context.Context, manifest *helpers.JobInfo, w io.Writer) error {
+	var layout *layoutWriter
+	if manifest.Chunking == helpers.ChunkingCDC {
+		layout = &layoutWriter{w: w}
+	}
+
+	for _, vol := range manifest.Volumes {
+		var err error
+		switch {
+		case layout == nil:
+			err = a.ExtractVolume(ctx, manifest, vol, w)
+		case vol.IsLayout:
+			if err = a.ExtractVolume(ctx, manifest, vol, &layout.pending); err == nil {
+				err = layout.endLayout()
+			}
+		default:
+			err = a.ExtractVolume(ctx, manifest, vol, layout)
+		}
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not write volume %s, the stream is incomplete - %v", vol.ObjectName, err)
+			return err
+		}
+	}
+	if layout != nil {
+		return layout.Close()
+	}
+	return nil
+}
+
+// Close will release the backend of the Archive.
+func (a *Archive) Close() error {
+	return a.backend.Close()
+}
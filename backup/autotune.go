@@ -0,0 +1,197 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+const (
+	// uploadTuneGain is the share by which the throughput must improve after adding an upload for
+	// the tuner to keep it and try another one.
+	uploadTuneGain = 0.05
+	// uploadTuneHold is the number of intervals the tuner waits for before trying to add an upload
+	// again once doing so did not help or an upload failed.
+	uploadTuneHold = 6
+)
+
+// uploadTuneInterval is how often the tuner looks at the uploads done and failed to adjust their number.
+var uploadTuneInterval = 10 * time.Second
+
+// uploadTuner adjusts the number of uploads, or the parts of them, in flight at once while a send runs,
+// AIMD style: one more is allowed after every interval the throughput improved with the previous one added,
+// and half as many after any of them failed. The backends take a slot of the upload buffer for every upload
+// or part in flight, so the tuner holds the slots of the buffer it does not allow in it. A nil uploadTuner
+// is valid and tunes nothing.
+type uploadTuner struct {
+	buffer   chan bool
+	max      int
+	limit    int
+	reserved int
+
+	mu       sync.Mutex
+	bytes    uint64
+	failures int
+	since    time.Time
+
+	// State of the throughput probing
+	throughput float64
+	increased  bool
+	hold       int
+
+	env    *helpers.Environment
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// newUploadTuner will start tuning the uploads of the buffer, from start uploads at once up to the capacity
+// of the buffer, until stop is called. The buffer must not be used yet.
+func newUploadTuner(ctx context.Context, buffer chan bool, start int) *uploadTuner {
+	t := &uploadTuner{
+		buffer: buffer,
+		max:    cap(buffer),
+		limit:  start,
+		since:  time.Now(),
+		env:    helpers.EnvironmentOf(ctx),
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	t.settle()
+	go t.run()
+	return t
+}
+
+// progress will wrap the ProgressFunc provided so the tuner learns of the volumes uploaded and
+// the failed attempts at uploading them.
+func (t *uploadTuner) progress(next helpers.ProgressFunc) helpers.ProgressFunc {
+	if t == nil {
+		return next
+	}
+	return func(event helpers.ProgressEvent) {
+		t.mu.Lock()
+		switch event.Type {
+		case helpers.ProgressVolumeUploaded:
+			t.bytes += event.Bytes
+		case helpers.ProgressRetry:
+			t.failures++
+		}
+		t.mu.Unlock()
+		if next != nil {
+			next(event)
+		}
+	}
+}
+
+// stop will stop tuning the uploads. The slots of the buffer held by the tuner are left in it.
+func (t *uploadTuner) stop() {
+	if t == nil {
+		return
+	}
+	close(t.stopCh)
+	<-t.done
+	t.env.Logger.Infof("Finished with %d parallel uploads.", t.limit)
+}
+
+func (t *uploadTuner) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(uploadTuneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.mu.Lock()
+			bytes, failures, elapsed := t.bytes, t.failures, now.Sub(t.since)
+			// Nothing to learn from an interval no upload finished or failed in
+			if bytes != 0 || failures != 0 {
+				t.bytes, t.failures, t.since = 0, 0, now
+			}
+			t.mu.Unlock()
+
+			if bytes != 0 || failures != 0 {
+				if limit := t.next(bytes, failures, elapsed); limit != t.limit {
+					if limit < t.limit {
+						t.env.Logger.Infof("Lowering the number of parallel uploads to %d (%d failed attempts, %s/s).", limit, failures, humanize.IBytes(uint64(float64(bytes)/elapsed.Seconds())))
+					} else {
+						t.env.Logger.Infof("Raising the number of parallel uploads to %d (%s/s).", limit, humanize.IBytes(uint64(float64(bytes)/elapsed.Seconds())))
+					}
+					t.limit = limit
+				}
+			}
+			t.settle()
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+// next will return the number of uploads to allow at once after an interval of the given duration
+// in which bytes were uploaded and failures attempts at uploading failed.
+func (t *uploadTuner) next(bytes uint64, failures int, elapsed time.Duration) int {
+	if failures > 0 {
+		t.increased = false
+		t.hold = uploadTuneHold
+		if t.limit > 1 {
+			return t.limit / 2
+		}
+		return 1
+	}
+
+	throughput := float64(bytes) / elapsed.Seconds()
+	switch {
+	case t.increased && throughput < t.throughput*(1+uploadTuneGain):
+		// The last upload added did not help, so it is taken back
+		t.increased = false
+		t.hold = uploadTuneHold
+		return t.limit - 1
+	case t.hold > 0:
+		t.hold--
+	case t.limit < t.max:
+		t.throughput = throughput
+		t.increased = true
+		return t.limit + 1
+	default:
+		t.increased = false
+	}
+	return t.limit
+}
+
+// settle will take slots of the buffer or give them back until it only allows the current limit of uploads
+// at once. A slot in use is only taken once the upload or part holding it is done.
+func (t *uploadTuner) settle() {
+	for t.reserved < t.max-t.limit {
+		select {
+		case t.buffer <- true:
+			t.reserved++
+		default:
+			return
+		}
+	}
+	for t.reserved > t.max-t.limit {
+		<-t.buffer
+		t.reserved--
+	}
+}
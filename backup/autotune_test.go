@@ -0,0 +1,130 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestUploadTunerNext(t *testing.T) {
+	// An interval of the given throughput (in bytes per second) with failures failed attempts
+	type interval struct {
+		throughput uint64
+		failures   int
+	}
+
+	testCases := []struct {
+		start     int
+		max       int
+		intervals []interval
+		limits    []int
+	}{
+		// Raised while the throughput improves, up to the max
+		{
+			start:     2,
+			max:       4,
+			intervals: []interval{{100, 0}, {200, 0}, {300, 0}, {400, 0}},
+			limits:    []int{3, 4, 4, 4},
+		},
+		// An upload added without improving the throughput is taken back, then held
+		{
+			start:     2,
+			max:       8,
+			intervals: []interval{{100, 0}, {102, 0}, {100, 0}, {100, 0}},
+			limits:    []int{3, 2, 2, 2},
+		},
+		// Halved on failures, never below 1
+		{
+			start:     8,
+			max:       8,
+			intervals: []interval{{100, 1}, {100, 3}, {100, 1}, {100, 1}},
+			limits:    []int{4, 2, 1, 1},
+		},
+		// Raised again once the hold is over
+		{
+			start:     4,
+			max:       8,
+			intervals: []interval{{0, 1}, {100, 0}, {100, 0}, {100, 0}, {100, 0}, {100, 0}, {100, 0}, {100, 0}},
+			limits:    []int{2, 2, 2, 2, 2, 2, 2, 3},
+		},
+	}
+
+	for idx, testCase := range testCases {
+		tuner := &uploadTuner{max: testCase.max, limit: testCase.start}
+		for step, i := range testCase.intervals {
+			tuner.limit = tuner.next(i.throughput, i.failures, time.Second)
+			if tuner.limit != testCase.limits[step] {
+				t.Errorf("%d: expected %d uploads after interval %d, got %d", idx, testCase.limits[step], step, tuner.limit)
+			}
+		}
+	}
+}
+
+func TestUploadTunerSettle(t *testing.T) {
+	buffer := make(chan bool, 4)
+	tuner := &uploadTuner{buffer: buffer, max: 4, limit: 2}
+	tuner.settle()
+	if tuner.reserved != 2 || len(buffer) != 2 {
+		t.Fatalf("expected 2 slots held with 2 uploads allowed, %d are held and %d in the buffer", tuner.reserved, len(buffer))
+	}
+
+	// Both uploads allowed are running, lowering the limit can't take their slots
+	buffer <- true
+	buffer <- true
+	tuner.limit = 1
+	tuner.settle()
+	if tuner.reserved != 2 {
+		t.Errorf("expected a slot in use not to be taken, %d are held", tuner.reserved)
+	}
+	<-buffer
+	tuner.settle()
+	if tuner.reserved != 3 {
+		t.Errorf("expected the slot to be taken once released, %d are held", tuner.reserved)
+	}
+
+	tuner.limit = 4
+	tuner.settle()
+	if tuner.reserved != 0 || len(buffer) != 1 {
+		t.Errorf("expected no slot held with 4 uploads allowed, %d are held and %d in the buffer", tuner.reserved, len(buffer))
+	}
+}
+
+func TestUploadTunerProgress(t *testing.T) {
+	var forwarded int
+	tuner := &uploadTuner{}
+	progress := tuner.progress(func(helpers.ProgressEvent) { forwarded++ })
+	progress(helpers.ProgressEvent{Type: helpers.ProgressVolumeUploaded, Bytes: 10})
+	progress(helpers.ProgressEvent{Type: helpers.ProgressVolumeUploaded, Bytes: 5})
+	progress(helpers.ProgressEvent{Type: helpers.ProgressRetry})
+	progress(helpers.ProgressEvent{Type: helpers.ProgressVolumeCreated, Bytes: 100})
+
+	if tuner.bytes != 15 || tuner.failures != 1 || forwarded != 4 {
+		t.Errorf("expected 15 bytes, 1 failure, and 4 events forwarded, got %d bytes, %d failures, and %d events", tuner.bytes, tuner.failures, forwarded)
+	}
+
+	var nilTuner *uploadTuner
+	if nilTuner.progress(nil) != nil {
+		t.Errorf("expected a nil tuner to leave the progress func as is")
+	}
+}
@@ -37,8 +37,6 @@ import (
 
 	"github.com/cenkalti/backoff"
 	"github.com/dustin/go-humanize"
-	"github.com/miolini/datacounter"
-	"github.com/nightlyone/lockfile"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/someone1/zfsbackup-go/backends"
@@ -52,17 +50,22 @@ var (
 
 // ProcessSmartOptions will compute the snapshots to use
 func ProcessSmartOptions(ctx context.Context, jobInfo *helpers.JobInfo) error {
-	snapshots, err := helpers.GetSnapshots(context.Background(), jobInfo.VolumeName)
+	snapshots, err := helpers.GetSnapshots(ctx, jobInfo.VolumeName)
 	if err != nil {
 		return err
 	}
+	if len(snapshots) == 0 {
+		return helpers.NewError(helpers.ErrorKindZFS, fmt.Errorf("no snapshot of %s found", jobInfo.VolumeName))
+	}
 	jobInfo.BaseSnapshot = snapshots[0]
 	if jobInfo.Full {
-		// TODO: Check if we already have a full backup for this snapshot in the destination(s)
+		// Backup skips the snapshot if the destinations already hold a full backup of it
 		return nil
 	}
 	lastComparableSnapshots := make([]*helpers.SnapshotInfo, len(jobInfo.Destinations))
 	lastBackup := make([]*helpers.SnapshotInfo, len(jobInfo.Destinations))
+	var longestChain int
+	var brokenChain bool
 	for idx := range jobInfo.Destinations {
 		destBackups, derr := getBackupsForTarget(ctx, jobInfo.VolumeName, jobInfo.Destinations[idx], jobInfo)
 		if derr != nil {
@@ -72,6 +75,11 @@ func ProcessSmartOptions(ctx context.Context, jobInfo *helpers.JobInfo) error {
 			continue
 		}
 		lastBackup[idx] = &destBackups[0].BaseSnapshot
+		if length, ok := chainLength(ctx, destBackups); !ok {
+			brokenChain = true
+		} else if length > longestChain {
+			longestChain = length
+		}
 		if jobInfo.Incremental {
 			lastComparableSnapshots[idx] = &destBackups[0].BaseSnapshot
 		}
@@ -105,19 +113,22 @@ func ProcessSmartOptions(ctx context.Context, jobInfo *helpers.JobInfo) error {
 		if lastComparableSnapshots[0].Equal(&snapshots[0]) {
 			return ErrNoOp
 		}
+		if chainTooLong(ctx, jobInfo, longestChain, brokenChain) {
+			return nil
+		}
 		jobInfo.IncrementalSnapshot = *lastComparableSnapshots[0]
 	}
 
 	if jobInfo.FullIfOlderThan != -1*time.Minute {
 		if lastComparableSnapshots[0] == nil {
 			// No previous full backup, so do one
-			helpers.AppLogger.Infof("No previous full backup found, performing full backup.")
+			helpers.EnvironmentOf(ctx).Logger.Infof("No previous full backup found, performing full backup.")
 			return nil
 		}
 
 		if snapshots[0].CreationTime.Sub(lastComparableSnapshots[0].CreationTime) > jobInfo.FullIfOlderThan {
 			// Been more than the allotted time, do a full backup
-			helpers.AppLogger.Infof("Last Full backup was %v and is more than %v before the most recent snapshot, performing full backup.", lastComparableSnapshots[0].CreationTime, jobInfo.FullIfOlderThan)
+			helpers.EnvironmentOf(ctx).Logger.Infof("Last Full backup was %v and is more than %v before the most recent snapshot, performing full backup.", lastComparableSnapshots[0].CreationTime, jobInfo.FullIfOlderThan)
 			return nil
 		}
 
@@ -127,11 +138,14 @@ func ProcessSmartOptions(ctx context.Context, jobInfo *helpers.JobInfo) error {
 		if lastBackup[0].Equal(&snapshots[0]) {
 			return ErrNoOp
 		}
+		if chainTooLong(ctx, jobInfo, longestChain, brokenChain) {
+			return nil
+		}
 
 		if ok, verr := validateSnapShotExists(ctx, lastComparableSnapshots[0], jobInfo.VolumeName); verr != nil {
 			return verr
 		} else if !ok {
-			helpers.AppLogger.Infof("Last Full backup was done on %v but is no longer found in the local target, performing full backup.", lastComparableSnapshots[0].CreationTime, jobInfo.FullIfOlderThan)
+			helpers.EnvironmentOf(ctx).Logger.Infof("Last Full backup was done on %v but is no longer found in the local target, performing full backup.", lastComparableSnapshots[0].CreationTime, jobInfo.FullIfOlderThan)
 			return nil
 		}
 		jobInfo.IncrementalSnapshot = *lastBackup[0]
@@ -139,46 +153,175 @@ func ProcessSmartOptions(ctx context.Context, jobInfo *helpers.JobInfo) error {
 	return nil
 }
 
+// chainLength will return the number of incremental backups chaining the latest of the backups provided,
+// sorted newest first, to its full backup. It returns false if the chain is broken.
+func chainLength(ctx context.Context, backups []*helpers.JobInfo) (int, bool) {
+	if len(backups) == 0 {
+		return 0, true
+	}
+
+	// A new incremental backup chains onto a full backup of the latest snapshot if there is one (e.g. consolidated)
+	latest := backups[0]
+	for _, set := range backups {
+		if set.IncrementalSnapshot.Name == "" && set.BaseSnapshot.Equal(&latest.BaseSnapshot) {
+			return 0, true
+		}
+	}
+
+	linkManifests(ctx, backups)
+	length := 0
+	for set := latest; set.IncrementalSnapshot.Name != ""; set = set.ParentSnap {
+		if set.ParentSnap == nil {
+			return length, false
+		}
+		length++
+	}
+	return length, true
+}
+
+// chainTooLong reports whether an incremental backup onto the longest chain found at the destinations
+// would exceed the MaxChainLength of jobInfo, in which case a full backup must be done instead.
+func chainTooLong(ctx context.Context, jobInfo *helpers.JobInfo, longestChain int, brokenChain bool) bool {
+	if jobInfo.MaxChainLength <= 0 {
+		return false
+	}
+
+	if brokenChain {
+		helpers.EnvironmentOf(ctx).Logger.Infof("The chain of the last backup is broken, performing full backup.")
+		return true
+	}
+
+	if longestChain >= jobInfo.MaxChainLength {
+		helpers.EnvironmentOf(ctx).Logger.Infof("The last backup is chained to its full backup by %d incremental backups, the max chain length is %d, performing full backup.", longestChain, jobInfo.MaxChainLength)
+		return true
+	}
+	return false
+}
+
 // Will list all backups found in the target destination
 func getBackupsForTarget(ctx context.Context, volume, target string, jobInfo *helpers.JobInfo) ([]*helpers.JobInfo, error) {
+	allManifests, err := getManifestsForTarget(ctx, target, jobInfo)
+	if err != nil {
+		return nil, err
+	}
+	decodedManifests := make([]*helpers.JobInfo, 0, len(allManifests))
+	for _, decodedManifest := range allManifests {
+		if strings.Compare(decodedManifest.VolumeName, volume) == 0 {
+			decodedManifests = append(decodedManifests, decodedManifest)
+		}
+	}
+
+	sort.SliceStable(decodedManifests, func(i, j int) bool {
+		return decodedManifests[i].BaseSnapshot.CreationTime.After(decodedManifests[j].BaseSnapshot.CreationTime)
+	})
+	return decodedManifests, nil
+}
+
+// getManifestsForTarget will sync the local cache of the target destination and return the manifests of every
+// backup set found there.
+func getManifestsForTarget(ctx context.Context, target string, jobInfo *helpers.JobInfo) ([]*helpers.JobInfo, error) {
 	// Prepare the backend client
 	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
 	if berr != nil {
-		helpers.AppLogger.Errorf("Could not initialize backend due to error - %v.", berr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend due to error - %v.", berr)
 		return nil, berr
 	}
 
 	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(target)
+	localCachePath, cerr := getCacheDir(ctx, target)
 	if cerr != nil {
-		helpers.AppLogger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
 		return nil, cerr
 	}
 
 	// Sync the local cache
 	safeManifests, _, serr := syncCache(ctx, jobInfo, localCachePath, backend)
 	if serr != nil {
-		helpers.AppLogger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
 		return nil, serr
 	}
 
 	// Read in Manifests and display
-	decodedManifests := make([]*helpers.JobInfo, 0, len(safeManifests))
-	for _, manifest := range safeManifests {
-		manifestPath := filepath.Join(localCachePath, manifest)
-		decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo)
-		if oerr != nil {
-			return nil, oerr
+	return readManifests(ctx, localCachePath, safeManifests, jobInfo)
+}
+
+// backedUp will return the manifest of the backup set jobInfo would send if every one of its destinations
+// already holds it, or nil otherwise.
+func backedUp(ctx context.Context, jobInfo *helpers.JobInfo) (*helpers.JobInfo, error) {
+	var found *helpers.JobInfo
+	for _, destination := range jobInfo.Destinations {
+		destBackups, err := getBackupsForTarget(ctx, jobInfo.VolumeName, destination, jobInfo)
+		if err != nil {
+			return nil, err
 		}
-		if strings.Compare(decodedManifest.VolumeName, volume) == 0 {
-			decodedManifests = append(decodedManifests, decodedManifest)
+
+		var match *helpers.JobInfo
+		for _, bkp := range destBackups {
+			if sameBackupSet(jobInfo, bkp) {
+				match = bkp
+				break
+			}
+		}
+		if match == nil {
+			return nil, nil
+		}
+		if found == nil {
+			found = match
 		}
 	}
+	return found, nil
+}
 
-	sort.SliceStable(decodedManifests, func(i, j int) bool {
-		return decodedManifests[i].BaseSnapshot.CreationTime.After(decodedManifests[j].BaseSnapshot.CreationTime)
-	})
-	return decodedManifests, nil
+// sameBackupSet reports whether the manifest describes the backup set jobInfo would send: the same snapshots
+// of the same volume, sent with the same options.
+func sameBackupSet(jobInfo, manifest *helpers.JobInfo) bool {
+	digest := func(algorithm string) string {
+		if algorithm == "" {
+			return helpers.DigestSHA256
+		}
+		return algorithm
+	}
+
+	return manifest.VolumeName == jobInfo.VolumeName &&
+		manifest.BaseSnapshot.Equal(&jobInfo.BaseSnapshot) &&
+		manifest.IncrementalSnapshot.Equal(&jobInfo.IncrementalSnapshot) &&
+		manifest.IntermediaryIncremental == jobInfo.IntermediaryIncremental &&
+		manifest.Replication == jobInfo.Replication &&
+		manifest.Deduplication == jobInfo.Deduplication &&
+		manifest.Properties == jobInfo.Properties &&
+		manifest.Compressor == jobInfo.Compressor &&
+		manifest.CompressionLevel == jobInfo.CompressionLevel &&
+		digest(manifest.DigestAlgorithm) == digest(jobInfo.DigestAlgorithm) &&
+		manifest.EncryptTo == jobInfo.EncryptTo &&
+		manifest.SignFrom == jobInfo.SignFrom &&
+		manifest.ContentAddressed == (jobInfo.ContentAddressed || jobInfo.Chunking == helpers.ChunkingCDC) &&
+		manifest.Chunking == jobInfo.Chunking &&
+		(jobInfo.Chunking != helpers.ChunkingCDC || manifest.ChunkSize == jobInfo.ChunkSize) &&
+		manifest.ParityVolumes == jobInfo.ParityVolumes &&
+		manifest.External == jobInfo.External
+}
+
+// reportBackedUp will output the backup set found at every destination in place of the one Backup would have sent.
+func reportBackedUp(ctx context.Context, jobInfo, manifest *helpers.JobInfo) {
+	totalWrittenBytes := manifest.TotalBytesWritten()
+	if helpers.EnvironmentOf(ctx).JSONOutput {
+		var doneOutput = struct {
+			AlreadyBackedUp  bool
+			Manifest         string
+			TotalZFSBytes    uint64
+			TotalBackupBytes uint64
+			ElapsedTime      time.Duration
+			FilesUploaded    int
+			Stats            *helpers.BackupStats
+		}{true, helpers.ManifestObjectName(jobInfo), manifest.ZFSStreamBytes, totalWrittenBytes, time.Since(jobInfo.StartTime), 0, manifest.Stats}
+		if j, jerr := json.Marshal(doneOutput); jerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("could not ouput json due to error - %v", jerr)
+		} else {
+			fmt.Fprintf(helpers.EnvironmentOf(ctx).Stdout, "%s", string(j))
+		}
+	} else {
+		fmt.Fprintf(helpers.EnvironmentOf(ctx).Stdout, "Already backed up.\n\tManifest: %s\n\tSent: %s\n\tTotal ZFS Stream Bytes: %d (%s)\n\tTotal Bytes Written: %d (%s)", helpers.ManifestObjectName(jobInfo), manifest.StartTime.Local().Format(time.RFC3339), manifest.ZFSStreamBytes, humanize.IBytes(manifest.ZFSStreamBytes), totalWrittenBytes, humanize.IBytes(totalWrittenBytes))
+	}
 }
 
 // Backup will initiate a backup with the provided configuration.
@@ -186,26 +329,54 @@ func Backup(pctx context.Context, jobInfo *helpers.JobInfo) error {
 	ctx, cancel := context.WithCancel(pctx)
 	defer cancel()
 
+	// Make sure nobody else is backing up the same volume/dataset to the same destinations we are!
+	lock, lerr := lockJob(ctx, jobInfo.VolumeName, jobInfo.Destinations)
+	if errors.Is(lerr, ErrAlreadyRunning) {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot backup %s to %s: %v", jobInfo.VolumeName, strings.Join(jobInfo.Destinations, ", "), lerr)
+		return lerr
+	} else if lerr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot lock %s due to error - %v", jobInfo.VolumeName, lerr)
+		return lerr
+	}
+	defer lock.Unlock()
+
+	// Make sure no other host is backing up the same volume/dataset to our destinations either
+	if jobInfo.LeaseTTL > 0 {
+		releaseLeases, lerr := acquireLeases(ctx, jobInfo, cancel)
+		if lerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot backup %s: %v", jobInfo.VolumeName, lerr)
+			return lerr
+		}
+		defer releaseLeases()
+	}
+
+	if err := resolveManifestFormat(ctx, jobInfo); err != nil {
+		return err
+	}
+
+	journal := openManifestJournal(ctx, jobInfo)
 	if jobInfo.Resume {
 		if err := tryResume(ctx, jobInfo); err != nil {
 			return err
 		}
+		if jobInfo.ParityVolumes > 0 && len(jobInfo.Volumes) > 0 {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("The volumes uploaded by the previous backup attempt are not staged anymore, no parity volumes will be computed for this backup set.")
+			jobInfo.ParityVolumes = 0
+		}
+	} else if err := journal.compacted(); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Could not discard the manifest journal of an earlier backup of this snapshot - %v", err)
 	}
-
-	// Make sure nobody else is working on the same volume/dataset we are!
-	lockFilePath := filepath.Join(os.TempDir(), fmt.Sprintf("zfsbackup.%x.lck", md5.Sum([]byte(jobInfo.VolumeName))))
-	lock, lferr := lockfile.New(lockFilePath)
-	if lferr != nil {
-		helpers.AppLogger.Errorf("Cannot init lock. reason: %v", lferr)
-		return lferr
+	if jobInfo.ParityVolumes == 0 {
+		jobInfo.ParityGroupSize = 0
 	}
-	lferr = lock.TryLock()
-
-	if lferr != nil {
-		helpers.AppLogger.Errorf("Cannot lock %q, reason: %v. If no other execution of %s is working on %s, you may forcefully remove the lock file located %s.", lock, lferr, helpers.ProgramName, jobInfo.VolumeName, lockFilePath)
-		return lferr
+	if jobInfo.Chunking == helpers.ChunkingCDC {
+		// Chunks are stored by their content
+		jobInfo.ContentAddressed = true
+	} else {
+		jobInfo.ChunkSize = 0
 	}
-	defer lock.Unlock()
+
+	jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobStarted})
 
 	fileBufferSize := jobInfo.MaxFileBuffer
 	if fileBufferSize == 0 {
@@ -213,33 +384,94 @@ func Backup(pctx context.Context, jobInfo *helpers.JobInfo) error {
 	}
 
 	// Validate the snapshots we want to use exist
-	if ok, verr := validateSnapShotExists(ctx, &jobInfo.BaseSnapshot, jobInfo.VolumeName); verr != nil {
-		helpers.AppLogger.Errorf("Cannot validate if selected base snapshot exists due to error - %v", verr)
+	if ok, verr := validateSnapShotExists(ctx, &jobInfo.BaseSnapshot, jobInfo.SourceVolume()); verr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot validate if selected base snapshot exists due to error - %v", verr)
 		return verr
 	} else if !ok {
-		helpers.AppLogger.Errorf("Selected base snapshot does not exist!")
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Selected base snapshot does not exist!")
 		return fmt.Errorf("selected base snapshot does not exist")
 	}
 
 	if jobInfo.IncrementalSnapshot.Name != "" {
-		if ok, verr := validateSnapShotExists(ctx, &jobInfo.IncrementalSnapshot, jobInfo.VolumeName); verr != nil {
-			helpers.AppLogger.Errorf("Cannot validate if selected incremental snapshot exists due to error - %v", verr)
+		if ok, verr := validateSnapShotExists(ctx, &jobInfo.IncrementalSnapshot, jobInfo.SourceVolume()); verr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot validate if selected incremental snapshot exists due to error - %v", verr)
 			return verr
 		} else if !ok {
-			helpers.AppLogger.Errorf("Selected incremental snapshot does not exist!")
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Selected incremental snapshot does not exist!")
 			return fmt.Errorf("selected incremental snapshot does not exist")
 		}
 	}
 
+	// A retried job must not send the backup set again once every destination holds it
+	if !jobInfo.SendAgain {
+		if manifest, berr := backedUp(ctx, jobInfo); berr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not check whether the destinations already hold this backup set, sending it - %v", berr)
+		} else if manifest != nil {
+			helpers.EnvironmentOf(ctx).Logger.Noticef("Every destination already holds the backup set %s sent %s, use --sendAgain to send it anyway.", helpers.ManifestObjectName(jobInfo), manifest.StartTime.Local().Format(time.RFC3339))
+			if cacheDir, cerr := getCacheDir(ctx, jobInfo.Destinations[0]); cerr == nil {
+				jobInfo.ManifestPath = filepath.Join(cacheDir, fmt.Sprintf("%x", md5.Sum([]byte(helpers.ManifestObjectName(jobInfo)))))
+			}
+			jobInfo.Manifests = append(jobInfo.Manifests, helpers.ManifestObjectName(jobInfo))
+			jobInfo.AlreadyBackedUp = manifest
+			reportBackedUp(ctx, jobInfo, manifest)
+			jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobFinished})
+			return nil
+		}
+	}
+
+	// Stage the volumes in the working directory so an interrupted backup can resume uploading them
+	var queue *uploadQueue
+	var staged map[int64]*helpers.VolumeInfo
+	if jobInfo.MaxFileBuffer != 0 {
+		var qerr error
+		if queue, qerr = openUploadQueue(ctx, jobInfo); qerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not open the upload queue - %v", qerr)
+			return qerr
+		}
+
+		if jobInfo.Resume {
+			if staged, qerr = queue.staged(ctx, jobInfo); qerr != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot resume backup, %v", qerr)
+				return qerr
+			}
+		} else if queue.Len() > 0 {
+			helpers.EnvironmentOf(ctx).Logger.Noticef("Discarding %d volumes staged by an interrupted backup of this snapshot, use the --resume flag to upload them instead.", queue.Len())
+			if qerr = queue.discard(ctx, jobInfo); qerr != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Could not discard the upload queue - %v", qerr)
+				return qerr
+			}
+		}
+		jobInfo.StagingDir = queue.dir
+	}
+
+	// Only bother asking ZFS for an estimate when someone is listening for it
+	if jobInfo.Progress != nil {
+		if estimate, eerr := helpers.GetZFSSendEstimate(ctx, jobInfo); eerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not estimate the size of the zfs send stream - %v", eerr)
+		} else {
+			jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobPlanned, Bytes: estimate})
+		}
+	}
+
 	startCh := make(chan *helpers.VolumeInfo, fileBufferSize) // Sent to ZFS command and meant to be closed when done
 	stepCh := make(chan *helpers.VolumeInfo, fileBufferSize)  // Used as input to first backend, closed when final manifest is sent through
 
 	var maniwg sync.WaitGroup
 	maniwg.Add(1)
 
-	uploadBuffer := make(chan bool, jobInfo.MaxParallelUploads)
+	uploadBuffer := make(chan bool, jobInfo.UploadWorkers())
 	defer close(uploadBuffer)
 
+	var tuner *uploadTuner
+	if jobInfo.AutoTuneUploads {
+		helpers.EnvironmentOf(ctx).Logger.Infof("Tuning the number of parallel uploads between 1 and %d, starting with %d.", jobInfo.AutoTuneMaxUploads, jobInfo.MaxParallelUploads)
+		tuner = newUploadTuner(ctx, uploadBuffer, jobInfo.MaxParallelUploads)
+		defer tuner.stop()
+		progress := jobInfo.Progress
+		jobInfo.Progress = tuner.progress(progress)
+		defer func() { jobInfo.Progress = progress }()
+	}
+
 	fileBuffer := make(chan bool, fileBufferSize)
 	for i := 0; i < fileBufferSize; i++ {
 		fileBuffer <- true
@@ -273,8 +505,13 @@ func Backup(pctx context.Context, jobInfo *helpers.JobInfo) error {
 	}()
 
 	// Start the ZFS send stream
+	sent := make(chan struct{})
 	group.Go(func() error {
-		return sendStream(ctx, jobInfo, startCh, fileBuffer)
+		if err := sendStream(ctx, jobInfo, startCh, fileBuffer, queue, staged); err != nil {
+			return err
+		}
+		close(sent)
+		return nil
 	})
 
 	var usedBackends []backends.Backend
@@ -289,43 +526,73 @@ func Backup(pctx context.Context, jobInfo *helpers.JobInfo) error {
 	for _, destination := range jobInfo.Destinations {
 		backend, berr := prepareBackend(ctx, jobInfo, destination, uploadBuffer)
 		if berr != nil {
-			helpers.AppLogger.Errorf("Could not initialize backend due to error - %v.", berr)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend due to error - %v.", berr)
 			return berr
 		}
-		_, cerr := getCacheDir(destination)
+		_, cerr := getCacheDir(ctx, destination)
 		if cerr != nil {
-			helpers.AppLogger.Errorf("Could not create cache for destination %s due to error - %v.", destination, cerr)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not create cache for destination %s due to error - %v.", destination, cerr)
 			return cerr
 		}
-		out, waitgroup := retryUploadChainer(ctx, channels[len(channels)-1], backend, jobInfo, destination)
-		channels = append(channels, out)
 		usedBackends = append(usedBackends, backend)
+	}
+
+	if jobInfo.MaxFileBuffer == 0 && len(usedBackends) > 1 {
+		out, waitgroup := teeUploadChainer(ctx, stepCh, usedBackends, jobInfo, queue)
+		channels = append(channels, out)
 		group.Go(waitgroup.Wait)
+	} else {
+		for idx, destination := range jobInfo.Destinations {
+			out, waitgroup := retryUploadChainer(ctx, channels[len(channels)-1], usedBackends[idx], jobInfo, destination, queue)
+			channels = append(channels, out)
+			group.Go(waitgroup.Wait)
+		}
 	}
 
 	// Create and copy a copy of the manifest during the backup procedure for future retry requests
 	group.Go(func() error {
 		defer close(fileBuffer)
 		lastChan := channels[len(channels)-1]
+		checkpointed := false
 		for {
 			select {
 			case vol, ok := <-lastChan:
 				if !ok {
 					return nil
 				}
-				if !vol.IsManifest {
-					helpers.AppLogger.Debugf("Volume %s has finished the entire pipeline.", vol.ObjectName)
-					helpers.AppLogger.Debugf("Adding %s to the manifest volume list.", vol.ObjectName)
+				if vol.IsParity {
+					// Parity volumes are not journaled, a resumed backup does not compute parity for what was uploaded before
+					helpers.EnvironmentOf(ctx).Logger.Debugf("Parity volume %s has finished the entire pipeline.", vol.ObjectName)
+					manifestmutex.Lock()
+					jobInfo.Parity = append(jobInfo.Parity, vol)
+					manifestmutex.Unlock()
+					maniwg.Done()
+				} else if !vol.IsManifest {
+					helpers.EnvironmentOf(ctx).Logger.Debugf("Volume %s has finished the entire pipeline.", vol.ObjectName)
+					helpers.EnvironmentOf(ctx).Logger.Debugf("Adding %s to the manifest volume list.", vol.ObjectName)
 					manifestmutex.Lock()
 					jobInfo.Volumes = append(jobInfo.Volumes, vol)
 					manifestmutex.Unlock()
-					// Write a manifest file and save it locally in order to resume later
-					manifestVol, err := saveManifest(ctx, jobInfo, false)
-					if err != nil {
+					// Record the volume and, every so often, write a manifest file and save it locally in order to resume later
+					if err := journal.record(vol); err != nil {
+						helpers.EnvironmentOf(ctx).Logger.Errorf("Could not record %s in the manifest journal - %v", vol.ObjectName, err)
 						return err
 					}
-					if err = manifestVol.DeleteVolume(); err != nil {
-						helpers.AppLogger.Warningf("Error deleting temporary manifest file  - %v", err)
+					if !checkpointed || journal.due() {
+						manifestVol, err := saveManifest(ctx, jobInfo, false)
+						if err != nil {
+							return err
+						}
+						if err = manifestVol.DeleteVolume(); err != nil {
+							helpers.EnvironmentOf(ctx).Logger.Warningf("Error deleting temporary manifest file  - %v", err)
+						}
+						if err = journal.compacted(); err != nil {
+							helpers.EnvironmentOf(ctx).Logger.Warningf("Could not empty the manifest journal - %v", err)
+						}
+						checkpointed = true
+					}
+					if err := queue.remove(vol); err != nil {
+						helpers.EnvironmentOf(ctx).Logger.Warningf("Could not update the upload queue - %v", err)
 					}
 					maniwg.Done()
 				} else {
@@ -347,16 +614,32 @@ func Backup(pctx context.Context, jobInfo *helpers.JobInfo) error {
 
 	// Final Manifest Creation
 	group.Go(func() error {
-		// TODO: How to incorporate contexts in this go routine?
-		maniwg.Wait() // Wait until the ZFS send command has completed and all volumes have been uploaded to all backends.
-		helpers.AppLogger.Infof("All volumes dispatched in pipeline, finalizing manifest file.")
+		// Wait until the ZFS send command has completed and all volumes have been uploaded to all backends.
+		dispatched := make(chan struct{})
+		go func() {
+			maniwg.Wait()
+			close(dispatched)
+		}()
+		for _, done := range []chan struct{}{dispatched, sent} {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		helpers.EnvironmentOf(ctx).Logger.Infof("All volumes dispatched in pipeline, finalizing manifest file.")
 		manifestmutex.Lock()
 		jobInfo.EndTime = time.Now()
+		stats := jobInfo.BackupStats()
+		jobInfo.Stats = &stats
 		manifestmutex.Unlock()
 		manifestVol, err := saveManifest(ctx, jobInfo, true)
 		if err != nil {
 			return err
 		}
+		if err = journal.compacted(); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not empty the manifest journal - %v", err)
+		}
 		stepCh <- manifestVol
 		close(stepCh)
 		return nil
@@ -366,29 +649,37 @@ func Backup(pctx context.Context, jobInfo *helpers.JobInfo) error {
 	if err != nil {
 		return err
 	}
+	jobInfo.Manifests = append(jobInfo.Manifests, helpers.ManifestObjectName(jobInfo))
+
+	if err = queue.finish(); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Could not delete the staging directory - %v", err)
+	}
 
 	totalWrittenBytes := jobInfo.TotalBytesWritten()
-	if helpers.JSONOutput {
+	if helpers.EnvironmentOf(ctx).JSONOutput {
 		var doneOutput = struct {
 			TotalZFSBytes    uint64
 			TotalBackupBytes uint64
 			ElapsedTime      time.Duration
 			FilesUploaded    int
-		}{jobInfo.ZFSStreamBytes, totalWrittenBytes, time.Since(jobInfo.StartTime), len(jobInfo.Volumes) + 1}
+			Stats            *helpers.BackupStats
+		}{jobInfo.ZFSStreamBytes, totalWrittenBytes, time.Since(jobInfo.StartTime), len(jobInfo.Volumes) + len(jobInfo.Parity) + 1, jobInfo.Stats}
 		if j, jerr := json.Marshal(doneOutput); jerr != nil {
-			helpers.AppLogger.Errorf("could not ouput json due to error - %v", jerr)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("could not ouput json due to error - %v", jerr)
 		} else {
-			fmt.Fprintf(helpers.Stdout, "%s", string(j))
+			fmt.Fprintf(helpers.EnvironmentOf(ctx).Stdout, "%s", string(j))
 		}
 	} else {
-		fmt.Fprintf(helpers.Stdout, "Done.\n\tTotal ZFS Stream Bytes: %d (%s)\n\tTotal Bytes Written: %d (%s)\n\tElapsed Time: %v\n\tTotal Files Uploaded: %d", jobInfo.ZFSStreamBytes, humanize.IBytes(jobInfo.ZFSStreamBytes), totalWrittenBytes, humanize.IBytes(totalWrittenBytes), time.Since(jobInfo.StartTime), len(jobInfo.Volumes)+1)
+		fmt.Fprintf(helpers.EnvironmentOf(ctx).Stdout, "Done.\n\tTotal ZFS Stream Bytes: %d (%s)\n\tTotal Bytes Written: %d (%s)\n\tElapsed Time: %v\n\tTotal Files Uploaded: %d\n\tCompression Ratio: %.2fx\n\tThroughput: %s/s\n\tUpload Retries: %d", jobInfo.ZFSStreamBytes, humanize.IBytes(jobInfo.ZFSStreamBytes), totalWrittenBytes, humanize.IBytes(totalWrittenBytes), time.Since(jobInfo.StartTime), len(jobInfo.Volumes)+len(jobInfo.Parity)+1, jobInfo.Stats.CompressionRatio, humanize.IBytes(jobInfo.Stats.Throughput), jobInfo.Stats.Retries)
 	}
 
-	helpers.AppLogger.Debugf("Cleaning up resources...")
+	jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobFinished, Bytes: totalWrittenBytes})
+
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Cleaning up resources...")
 
 	for _, backend := range usedBackends {
 		if err = backend.Close(); err != nil {
-			helpers.AppLogger.Warningf("Could not properly close backend due to error - %v", err)
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not properly close backend due to error - %v", err)
 		}
 	}
 
@@ -399,11 +690,17 @@ func saveManifest(ctx context.Context, j *helpers.JobInfo, final bool) (*helpers
 	manifestmutex.Lock()
 	defer manifestmutex.Unlock()
 	sort.Sort(helpers.ByVolumeNumber(j.Volumes))
+	sort.Sort(helpers.ByVolumeNumber(j.Parity))
+	j.SchemaVersion = helpers.ManifestSchemaVersion
+	if j.ManifestFormat == helpers.ManifestFormatUpstream {
+		// Upstream releases don't version their manifests
+		j.SchemaVersion = 0
+	}
 
 	// Setup Manifest File
 	manifest, err := helpers.CreateManifestVolume(ctx, j)
 	if err != nil {
-		helpers.AppLogger.Errorf("Error trying to create manifest volume - %v", err)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error trying to create manifest volume - %v", err)
 		return nil, err
 	}
 	safeManifestFile := fmt.Sprintf("%x", md5.Sum([]byte(manifest.ObjectName)))
@@ -411,11 +708,11 @@ func saveManifest(ctx context.Context, j *helpers.JobInfo, final bool) (*helpers
 	jsonEnc := json.NewEncoder(manifest)
 	err = jsonEnc.Encode(j)
 	if err != nil {
-		helpers.AppLogger.Errorf("Could not JSON Encode job information due to error - %v", err)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not JSON Encode job information due to error - %v", err)
 		return nil, err
 	}
 	if err = manifest.Close(); err != nil {
-		helpers.AppLogger.Errorf("Could not close manifest volume due to error - %v", err)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not close manifest volume due to error - %v", err)
 		return nil, err
 	}
 	for _, destination := range j.Destinations {
@@ -423,123 +720,295 @@ func saveManifest(ctx context.Context, j *helpers.JobInfo, final bool) (*helpers
 			continue
 		}
 		safeFolder := fmt.Sprintf("%x", md5.Sum([]byte(destination)))
-		dest := filepath.Join(helpers.WorkingDir, "cache", safeFolder, safeManifestFile)
+		dest := filepath.Join(helpers.EnvironmentOf(ctx).WorkingDir, "cache", safeFolder, safeManifestFile)
 		if err = manifest.CopyTo(dest); err != nil {
-			helpers.AppLogger.Warningf("Could not write manifest volume due to error - %v", err)
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not write manifest volume due to error - %v", err)
 			return nil, err
 		}
-		helpers.AppLogger.Debugf("Copied manifest to local cache for destination %s.", destination)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Copied manifest to local cache for destination %s.", destination)
+		if final && j.ManifestPath == "" {
+			j.ManifestPath = dest
+		}
 	}
 	return manifest, nil
 }
 
-func sendStream(ctx context.Context, j *helpers.JobInfo, c chan<- *helpers.VolumeInfo, buffer <-chan bool) error {
+func sendStream(ctx context.Context, j *helpers.JobInfo, c chan<- *helpers.VolumeInfo, buffer <-chan bool, queue *uploadQueue, staged map[int64]*helpers.VolumeInfo) error {
 	var group *errgroup.Group
 	group, ctx = errgroup.WithContext(ctx)
 
 	cmd := helpers.GetZFSSendCommand(ctx, j)
-	cin, cout := io.Pipe()
+	// zfs send writes to a pipe of our own so its stream can be spliced onward where the platform allows it
+	cin, cout, err := os.Pipe()
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error creating a pipe for the zfs send command - %v", err)
+		return err
+	}
+	defer cin.Close()
 	cmd.Stdout = cout
 	cmd.Stderr = os.Stderr
-	counter := datacounter.NewReaderCounter(cin)
+	var streamed uint64
+	started := make(chan error, 1)
 	usingPipe := false
 	if j.MaxFileBuffer == 0 {
 		usingPipe = true
 	}
+	readSize := int64(j.SendReadSize) * humanize.KiByte
+	if readSize <= 0 {
+		readSize = helpers.DefaultSendReadSize * humanize.KiByte
+	}
+	parity, err := newParityEncoder(j)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error preparing the parity encoder - %v", err)
+		return err
+	}
+	defer parity.discard(ctx)
 
-	group.Go(func() error {
+	group.Go(func() (err error) {
 		var lastTotalBytes uint64
+		sizer := &outputSizer{limit: j.VolumeSize * humanize.MiByte}
 		defer close(c)
-		var err error
 		var volume *helpers.VolumeInfo
+		defer func() {
+			// Unblock zfs send, and so the wait on it, once nothing reads its output anymore
+			if err != nil {
+				cin.Close()
+			}
+			// Don't leave the volume we were creating behind if the stream was interrupted
+			if err != nil && volume != nil && !usingPipe && volume.StagedPath() != "" {
+				volume.Close()
+				if derr := volume.DeleteVolume(); derr != nil && !os.IsNotExist(derr) {
+					helpers.EnvironmentOf(ctx).Logger.Warningf("Could not delete the incomplete volume %s - %v", volume.ObjectName, derr)
+				}
+			}
+		}()
+		// Nothing can be read from the pipe until zfs send is writing to it
+		if err = <-started; err != nil {
+			return err
+		}
+		// Parity volumes go through the pipeline like any volume staged on disk
+		passParity := func(vols []*helpers.VolumeInfo) error {
+			for idx, vol := range vols {
+				<-buffer
+				if perr := passVolume(ctx, c, vol); perr != nil {
+					deleteVolumes(ctx, vols[idx:])
+					return perr
+				}
+			}
+			return nil
+		}
+		addParity := func(vol *helpers.VolumeInfo) ([]*helpers.VolumeInfo, error) {
+			vols, perr := parity.add(ctx, vol)
+			if perr != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Error while computing the parity of volume %s - %v", vol.ObjectName, perr)
+			}
+			return vols, perr
+		}
 		skipBytes, volNum := j.TotalBytesStreamedAndVols()
+
+		// Volumes staged by a previous run are sent through the pipeline as they are
+		resumed, resumedBytes := resumeVolumes(staged, volNum)
+		if j.Chunking == helpers.ChunkingCDC {
+			// Only whole segments of the stream can be resumed
+			resumed, resumedBytes = resumeSegments(j, resumed)
+			skipBytes, volNum = j.TotalBytesStreamedAndVols()
+		}
+		for _, vol := range resumed {
+			helpers.EnvironmentOf(ctx).Logger.Infof("Resuming the upload of the staged volume %s.", vol.ObjectName)
+			var parityVols []*helpers.VolumeInfo
+			if parityVols, err = addParity(vol); err != nil {
+				return err
+			}
+			<-buffer
+			if err = passVolume(ctx, c, vol); err != nil {
+				deleteVolumes(ctx, parityVols)
+				return err
+			}
+			if err = passParity(parityVols); err != nil {
+				return err
+			}
+		}
+		skipBytes += resumedBytes
+		volNum += int64(len(resumed))
 		lastTotalBytes = skipBytes
+		if j.Chunking == helpers.ChunkingCDC {
+			passStaged := func(vol *helpers.VolumeInfo) error {
+				reportVolumeCreated(j, vol)
+				parityVols, perr := addParity(vol)
+				if perr != nil {
+					vol.DeleteVolume()
+					return perr
+				}
+				if perr = queue.add(vol); perr != nil {
+					helpers.EnvironmentOf(ctx).Logger.Warningf("Could not update the upload queue - %v", perr)
+				}
+				if perr = passVolume(ctx, c, vol); perr != nil {
+					deleteVolumes(ctx, parityVols)
+					return perr
+				}
+				return passParity(parityVols)
+			}
+			if streamed, err = sendChunks(ctx, j, cin, skipBytes, volNum, readSize, buffer, passStaged); err != nil {
+				return err
+			}
+			// The last parity group of the backup set is likely short of volumes
+			parityVols, perr := parity.finish(ctx)
+			if perr != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Error while computing the parity of the last volumes - %v", perr)
+				return perr
+			}
+			return passParity(parityVols)
+		}
 		for {
 			// Skip bytes if we are resuming
 			if skipBytes > 0 {
-				helpers.AppLogger.Debugf("Want to skip %d bytes.", skipBytes)
-				written, serr := io.CopyN(ioutil.Discard, counter, int64(skipBytes))
+				helpers.EnvironmentOf(ctx).Logger.Debugf("Want to skip %d bytes.", skipBytes)
+				written, serr := io.CopyN(ioutil.Discard, cin, int64(skipBytes))
+				streamed += uint64(written)
 				if serr != nil && serr != io.EOF {
-					helpers.AppLogger.Errorf("Error while trying to read from the zfs stream to skip %d bytes - %v", skipBytes, serr)
+					helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from the zfs stream to skip %d bytes - %v", skipBytes, serr)
 					return serr
 				}
 				skipBytes -= uint64(written)
-				helpers.AppLogger.Debugf("Skipped %d bytes of the ZFS send stream.", written)
+				helpers.EnvironmentOf(ctx).Logger.Debugf("Skipped %d bytes of the ZFS send stream.", written)
 				continue
 			}
 
 			// Setup next Volume
-			if volume == nil || volume.Counter() >= (j.VolumeSize*humanize.MiByte)-50*humanize.KiByte {
+			full := volume != nil && volumeFull(j, volume, streamed-lastTotalBytes)
+			if volume != nil && j.VolumeSizeOutput {
+				if full, err = sizer.full(ctx, volume, readSize); err != nil {
+					helpers.EnvironmentOf(ctx).Logger.Errorf("Error while flushing the compressor of volume %s - %v", volume.ObjectName, err)
+					return err
+				}
+			}
+			if volume == nil || full {
 				if volume != nil {
-					helpers.AppLogger.Debugf("Finished creating volume %s", volume.ObjectName)
-					volume.ZFSStreamBytes = counter.Count() - lastTotalBytes
-					lastTotalBytes = counter.Count()
+					helpers.EnvironmentOf(ctx).Logger.Debugf("Finished creating volume %s", volume.ObjectName)
+					volume.ZFSStreamBytes = streamed - lastTotalBytes
+					lastTotalBytes = streamed
 					if err = volume.Close(); err != nil {
-						helpers.AppLogger.Errorf("Error while trying to close volume %s - %v", volume.ObjectName, err)
+						helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to close volume %s - %v", volume.ObjectName, err)
 						return err
 					}
+					reportVolumeCreated(j, volume)
 					if !usingPipe {
-						c <- volume
+						var parityVols []*helpers.VolumeInfo
+						if parityVols, err = addParity(volume); err != nil {
+							return err
+						}
+						if err = queue.add(volume); err != nil {
+							helpers.EnvironmentOf(ctx).Logger.Warningf("Could not update the upload queue - %v", err)
+						}
+						if err = passVolume(ctx, c, volume); err != nil {
+							volume = nil // It is staged in the upload queue, keep it
+							deleteVolumes(ctx, parityVols)
+							return err
+						}
+						if err = passParity(parityVols); err != nil {
+							volume = nil
+							return err
+						}
 					}
 				}
 				<-buffer
 				volume, err = helpers.CreateBackupVolume(ctx, j, volNum)
 				if err != nil {
-					helpers.AppLogger.Errorf("Error while creating volume %d - %v", volNum, err)
+					helpers.EnvironmentOf(ctx).Logger.Errorf("Error while creating volume %d - %v", volNum, err)
 					return err
 				}
-				helpers.AppLogger.Debugf("Starting volume %s", volume.ObjectName)
+				helpers.EnvironmentOf(ctx).Logger.Debugf("Starting volume %s", volume.ObjectName)
+				sizer.pending = 0
 				volNum++
 				if usingPipe {
-					c <- volume
+					if err = passVolume(ctx, c, volume); err != nil {
+						return err
+					}
 				}
 			}
 
 			// Write a little at a time and break the output between volumes as needed
-			_, ierr := io.CopyN(volume, counter, helpers.BufferSize*2)
+			n := readSize
+			if j.ContentAddressed {
+				if remaining := int64(j.VolumeSize*humanize.MiByte - (streamed - lastTotalBytes)); remaining < n {
+					n = remaining
+				}
+			}
+			if j.VolumeSizeOutput {
+				n = sizer.fit(volume, n)
+			}
+			written, ierr := volume.CopyFrom(cin, n)
+			streamed += uint64(written)
+			sizer.pending += uint64(written)
 			if ierr == io.EOF {
 				// We are done!
-				helpers.AppLogger.Debugf("Finished creating volume %s", volume.ObjectName)
-				volume.ZFSStreamBytes = counter.Count() - lastTotalBytes
-				lastTotalBytes = counter.Count()
+				helpers.EnvironmentOf(ctx).Logger.Debugf("Finished creating volume %s", volume.ObjectName)
+				volume.ZFSStreamBytes = streamed - lastTotalBytes
+				lastTotalBytes = streamed
 				if err = volume.Close(); err != nil {
-					helpers.AppLogger.Errorf("Error while trying to close volume %s - %v", volume.ObjectName, err)
+					helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to close volume %s - %v", volume.ObjectName, err)
 					return err
 				}
+				reportVolumeCreated(j, volume)
 				if !usingPipe {
-					c <- volume
+					var parityVols []*helpers.VolumeInfo
+					if parityVols, err = addParity(volume); err != nil {
+						return err
+					}
+					if err = queue.add(volume); err != nil {
+						helpers.EnvironmentOf(ctx).Logger.Warningf("Could not update the upload queue - %v", err)
+					}
+					if err = passVolume(ctx, c, volume); err != nil {
+						volume = nil // It is staged in the upload queue, keep it
+						deleteVolumes(ctx, parityVols)
+						return err
+					}
+					volume = nil
+					if err = passParity(parityVols); err != nil {
+						return err
+					}
+					// The last parity group of the backup set is likely short of volumes
+					if parityVols, err = parity.finish(ctx); err != nil {
+						helpers.EnvironmentOf(ctx).Logger.Errorf("Error while computing the parity of the last volumes - %v", err)
+						return err
+					}
+					if err = passParity(parityVols); err != nil {
+						return err
+					}
 				}
 				return nil
 			} else if ierr != nil {
-				helpers.AppLogger.Errorf("Error while trying to read from the zfs stream for volume %s - %v", volume.ObjectName, ierr)
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from the zfs stream for volume %s - %v", volume.ObjectName, ierr)
 				return ierr
 			}
 		}
 	})
 
 	// Start the zfs send command
-	helpers.AppLogger.Infof("Starting zfs send command: %s", strings.Join(cmd.Args, " "))
-	err := cmd.Start()
+	helpers.EnvironmentOf(ctx).Logger.Infof("Starting zfs send command: %s", strings.Join(cmd.Args, " "))
+	err = cmd.Start()
+	// Only zfs send writes to the pipe, we are done with our end either way
+	cout.Close()
+	started <- err
 	if err != nil {
-		helpers.AppLogger.Errorf("Error starting zfs command - %v", err)
-		return err
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error starting zfs command - %v", err)
+		return helpers.NewError(helpers.ErrorKindZFS, err)
 	}
 
 	group.Go(func() error {
-		defer cout.Close()
-		return cmd.Wait()
+		return helpers.NewError(helpers.ErrorKindZFS, cmd.Wait())
 	})
 
 	defer func() {
 		if cmd.ProcessState == nil || !cmd.ProcessState.Exited() {
 			err = cmd.Process.Kill()
 			if err != nil {
-				helpers.AppLogger.Errorf("Could not kill zfs send command due to error - %v", err)
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Could not kill zfs send command due to error - %v", err)
 				return
 			}
 			err = cmd.Process.Release()
 			if err != nil {
-				helpers.AppLogger.Errorf("Could not release resources from zfs send command due to error - %v", err)
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Could not release resources from zfs send command due to error - %v", err)
 				return
 			}
 		}
@@ -552,21 +1021,106 @@ func sendStream(ctx context.Context, j *helpers.JobInfo, c chan<- *helpers.Volum
 
 	err = group.Wait()
 	if err != nil {
-		helpers.AppLogger.Errorf("Error waiting for zfs command to finish - %v", err)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error waiting for zfs command to finish - %v", err)
 		return err
 	}
-	helpers.AppLogger.Infof("zfs send completed without error")
+	helpers.EnvironmentOf(ctx).Logger.Infof("zfs send completed without error")
 	manifestmutex.Lock()
-	j.ZFSStreamBytes = counter.Count()
+	j.ZFSStreamBytes = streamed
 	manifestmutex.Unlock()
 	return nil
 }
 
+// volumeFull reports whether the volume being written, holding streamBytes of the zfs send stream, is as large
+// as a volume should be. Volumes stored by their content hold exactly VolumeSize MiB of the stream so the same
+// stream is always split the same way, whatever the compressor buffered when it was read.
+func volumeFull(j *helpers.JobInfo, volume *helpers.VolumeInfo, streamBytes uint64) bool {
+	if j.ContentAddressed {
+		return streamBytes >= j.VolumeSize*humanize.MiByte
+	}
+	return volume.Counter() >= (j.VolumeSize*humanize.MiByte)-50*humanize.KiByte
+}
+
+// volumeOutputSlack is the room a volume split on its output may be closed with, rather than reading the
+// stream in ever smaller pieces to fill it up.
+const volumeOutputSlack = 64 * humanize.KiByte
+
+// maxOutputBytes bounds what n bytes of the zfs send stream take once written to a volume, should they not
+// compress at all: deflate and OpenPGP store such data in blocks and packets adding a few bytes each, on top of
+// the headers and trailers of the volume.
+func maxOutputBytes(n uint64) uint64 {
+	return n + n/256 + 4*humanize.KiByte
+}
+
+// outputSizer keeps the volumes of a backup set under VolumeSize MiB once compressed and encrypted. What the
+// compressor holds is not known until it is flushed, which stalls it, so it is only flushed once it holds
+// enough for the next read to possibly overflow the volume.
+type outputSizer struct {
+	limit   uint64
+	pending uint64 // Bytes of the stream written to the volume since its compressor was last flushed
+	warned  bool
+}
+
+// room returns how many more bytes of the stream are sure to fit in the volume.
+func (s *outputSizer) room(volume *helpers.VolumeInfo) uint64 {
+	used := volume.Counter() + maxOutputBytes(s.pending)
+	if used >= s.limit {
+		return 0
+	}
+	// The inverse of maxOutputBytes, rounded down
+	return (s.limit - used) * 256 / 257
+}
+
+// full reports whether the volume is too full for another read of n bytes, flushing its compressor first
+// when what it holds is all that stands in the way.
+func (s *outputSizer) full(ctx context.Context, volume *helpers.VolumeInfo, n int64) (bool, error) {
+	if room := s.room(volume); room >= uint64(n) || s.pending == 0 {
+		return room < volumeOutputSlack, nil
+	}
+	flushed, err := volume.Flush()
+	if err != nil {
+		return false, err
+	}
+	if flushed {
+		s.pending = 0
+	} else if !s.warned {
+		s.warned = true
+		helpers.EnvironmentOf(ctx).Logger.Warningf("The compressor can not be flushed, volumes will be split as if the zfs send stream did not compress to stay under the volsize.")
+	}
+	return s.room(volume) < volumeOutputSlack, nil
+}
+
+// fit returns how much of a read of n bytes fits in the volume.
+func (s *outputSizer) fit(volume *helpers.VolumeInfo, n int64) int64 {
+	if room := s.room(volume); room < uint64(n) {
+		return int64(room)
+	}
+	return n
+}
+
+// deleteVolumes will delete the staged volumes provided that will not go through the pipeline.
+func deleteVolumes(ctx context.Context, vols []*helpers.VolumeInfo) {
+	for _, vol := range vols {
+		if err := vol.DeleteVolume(); err != nil && !os.IsNotExist(err) {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not delete the volume %s - %v", vol.ObjectName, err)
+		}
+	}
+}
+
+func reportVolumeCreated(j *helpers.JobInfo, volume *helpers.VolumeInfo) {
+	j.ReportProgress(helpers.ProgressEvent{
+		Type:         helpers.ProgressVolumeCreated,
+		ObjectName:   volume.ObjectName,
+		VolumeNumber: volume.VolumeNumber,
+		Bytes:        volume.ZFSStreamBytes,
+	})
+}
+
 func tryResume(ctx context.Context, j *helpers.JobInfo) error {
 	// Temproary Final Manifest File
 	manifest, merr := helpers.CreateManifestVolume(ctx, j)
 	if merr != nil {
-		helpers.AppLogger.Errorf("Error trying to create manifest volume - %v", merr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error trying to create manifest volume - %v", merr)
 		return merr
 	}
 	defer manifest.DeleteVolume()
@@ -576,26 +1130,26 @@ func tryResume(ctx context.Context, j *helpers.JobInfo) error {
 
 	destination := j.Destinations[0]
 	safeFolder := fmt.Sprintf("%x", md5.Sum([]byte(destination)))
-	origManiPath := filepath.Join(helpers.WorkingDir, "cache", safeFolder, safeManifestFile)
+	origManiPath := filepath.Join(helpers.EnvironmentOf(ctx).WorkingDir, "cache", safeFolder, safeManifestFile)
 
 	if originalManifest, oerr := readManifest(ctx, origManiPath, j); os.IsNotExist(oerr) {
-		helpers.AppLogger.Info("No previous manifest file exists, nothing to resume")
+		helpers.EnvironmentOf(ctx).Logger.Info("No previous manifest file exists, nothing to resume")
 	} else if oerr != nil {
-		helpers.AppLogger.Errorf("Could not open previous manifest file %s due to error: %v", origManiPath, oerr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not open previous manifest file %s due to error: %v", origManiPath, oerr)
 		return oerr
 	} else {
 		if originalManifest.Compressor != j.Compressor {
-			helpers.AppLogger.Errorf("Cannot resume backup, original compressor %s != compressor specified %s", originalManifest.Compressor, j.Compressor)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot resume backup, original compressor %s != compressor specified %s", originalManifest.Compressor, j.Compressor)
 			return fmt.Errorf("option mismatch")
 		}
 
 		if originalManifest.EncryptTo != j.EncryptTo {
-			helpers.AppLogger.Errorf("Cannot resume backup, different encryptTo flags specified (original %v != current %v)", originalManifest.EncryptTo, j.EncryptTo)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot resume backup, different encryptTo flags specified (original %v != current %v)", originalManifest.EncryptTo, j.EncryptTo)
 			return fmt.Errorf("option mismatch")
 		}
 
 		if originalManifest.SignFrom != j.SignFrom {
-			helpers.AppLogger.Errorf("Cannot resume backup, different signFrom flags specified (original %v != current %v)", originalManifest.SignFrom, j.SignFrom)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot resume backup, different signFrom flags specified (original %v != current %v)", originalManifest.SignFrom, j.SignFrom)
 			return fmt.Errorf("option mismatch")
 		}
 
@@ -604,63 +1158,128 @@ func tryResume(ctx context.Context, j *helpers.JobInfo) error {
 		oldCMDLine := strings.Join(currentCMD.Args, " ")
 		currentCMDLine := strings.Join(oldCMD.Args, " ")
 		if strings.Compare(oldCMDLine, currentCMDLine) != 0 {
-			helpers.AppLogger.Errorf("Cannot resume backup, different options given for zfs send command: `%s` != current `%s`", oldCMDLine, currentCMDLine)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot resume backup, different options given for zfs send command: `%s` != current `%s`", oldCMDLine, currentCMDLine)
 			return fmt.Errorf("option mismatch")
 		}
 
 		manifestmutex.Lock()
 		j.Volumes = originalManifest.Volumes
 		j.StartTime = originalManifest.StartTime
+		replayed, rerr := replayManifestJournal(ctx, j)
 		manifestmutex.Unlock()
-		helpers.AppLogger.Infof("Will be resuming previous backup attempt.")
+		if rerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read the manifest journal of the previous backup attempt - %v", rerr)
+			return rerr
+		}
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Replayed %d volumes from the manifest journal of the previous backup attempt.", replayed)
+		helpers.EnvironmentOf(ctx).Logger.Infof("Will be resuming previous backup attempt.")
 	}
 	return nil
 }
 
-func retryUploadChainer(ctx context.Context, in <-chan *helpers.VolumeInfo, b backends.Backend, j *helpers.JobInfo, dest string) (<-chan *helpers.VolumeInfo, *errgroup.Group) {
+func retryUploadChainer(ctx context.Context, in <-chan *helpers.VolumeInfo, b backends.Backend, j *helpers.JobInfo, dest string, queue *uploadQueue) (<-chan *helpers.VolumeInfo, *errgroup.Group) {
 	out := make(chan *helpers.VolumeInfo)
 	parts := strings.Split(dest, "://")
 	prefix := parts[0]
 	var gwg *errgroup.Group
-	if j.MaxParallelUploads > 1 {
+	if j.UploadWorkers() > 1 {
 		gwg, ctx = errgroup.WithContext(ctx)
 	} else {
 		gwg = new(errgroup.Group)
 	}
 
 	var wg sync.WaitGroup
-	wg.Add(j.MaxParallelUploads)
-	for i := 0; i < j.MaxParallelUploads; i++ {
+	wg.Add(j.UploadWorkers())
+	for i := 0; i < j.UploadWorkers(); i++ {
 		gwg.Go(func() error {
 			defer wg.Done()
-			for vol := range in {
+			for {
+				// Listen to context cancellations while waiting for volumes as the input may never be closed
 				select {
 				case <-ctx.Done():
 					return ctx.Err()
-				default:
-					helpers.AppLogger.Debugf("%s backend: Processing volume %s", prefix, vol.ObjectName)
-					// Prepare the backoff retryer (forces the user configured retry options across all backends)
-					be := backoff.NewExponentialBackOff()
-					be.MaxInterval = j.MaxBackoffTime
-					be.MaxElapsedTime = j.MaxRetryTime
-					retryconf := backoff.WithContext(be, ctx)
-
-					operation := volUploadWrapper(ctx, b, vol, prefix)
-					if err := backoff.Retry(operation, retryconf); err != nil {
-						helpers.AppLogger.Errorf("%s backend: Failed to upload volume %s due to error: %v", prefix, vol.ObjectName, err)
+				case vol, ok := <-in:
+					if !ok {
+						return nil
+					}
+					if queue.uploaded(vol, dest) {
+						helpers.LogFields{Dataset: j.VolumeName, Destination: dest, Volume: vol.ObjectName}.Infof(ctx, "%s backend: Volume %s was already uploaded by a previous run, skipping.", prefix, vol.ObjectName)
+						if err := passVolume(ctx, out, vol); err != nil {
+							return err
+						}
+						continue
+					}
+					// Don't start a new upload while transfers are paused
+					helpers.EnvironmentOf(ctx).Transfers.Wait()
+					helpers.EnvironmentOf(ctx).Logger.Debugf("%s backend: Processing volume %s", prefix, vol.ObjectName)
+					stored := false
+					if j.ContentAddressed && !vol.IsManifest && !vol.IsParity && !vol.IsLayout && prefix != backends.DeleteBackendPrefix {
+						var err error
+						if stored, err = contentStored(ctx, b, vol); err != nil {
+							helpers.EnvironmentOf(ctx).Logger.Warningf("%s backend: Could not check whether volume %s is already stored, uploading it - %v", prefix, vol.ObjectName, err)
+						} else if stored {
+							helpers.LogFields{Dataset: j.VolumeName, Destination: dest, Volume: vol.ObjectName}.Infof(ctx, "%s backend: Volume %s is already stored, skipping its upload.", prefix, vol.ObjectName)
+						}
+					}
+					if !stored {
+						// Every branch of a streamed volume must be read at once, it can't wait for an upload slot
+						slots := helpers.EnvironmentOf(ctx).UploadSlots
+						if vol.IsUsingPipe() {
+							slots = nil
+						}
+						if err := slots.Acquire(ctx); err != nil {
+							return err
+						}
+						// Prepare the backoff retryer (forces the user configured retry options across all backends)
+						be := backoff.NewExponentialBackOff()
+						be.MaxInterval = j.MaxBackoffTime
+						be.MaxElapsedTime = j.MaxRetryTime
+						retryconf := backoff.WithContext(be, ctx)
+
+						retries := 0
+						notify := retryNotifier(ctx, j, vol, dest)
+						start := time.Now()
+						operation := volUploadWrapper(ctx, b, vol, prefix)
+						if vol.IsManifest && prefix != backends.DeleteBackendPrefix {
+							operation = manifestUploadWrapper(ctx, b, vol, prefix, j.ManifestFormat != helpers.ManifestFormatUpstream)
+						}
+						err := backoff.RetryNotify(operation, retryconf, func(err error, wait time.Duration) {
+							retries++
+							notify(err, wait)
+						})
+						slots.Release()
+						if err != nil {
+							helpers.LogFields{Dataset: j.VolumeName, Destination: dest, Volume: vol.ObjectName, Err: err}.Errorf(ctx, "%s backend: Failed to upload volume %s due to error: %v", prefix, vol.ObjectName, err)
+							return helpers.NewError(helpers.ErrorKindBackend, err)
+						}
+						if prefix != backends.DeleteBackendPrefix {
+							vol.RecordUpload(time.Since(start), retries)
+						}
+					}
+					helpers.LogFields{Dataset: j.VolumeName, Destination: dest, Volume: vol.ObjectName, Bytes: vol.Size}.Debugf(ctx, "%s backend: Processed volume %s", prefix, vol.ObjectName)
+					if prefix != backends.DeleteBackendPrefix {
+						j.ReportProgress(helpers.ProgressEvent{
+							Type:         helpers.ProgressVolumeUploaded,
+							ObjectName:   vol.ObjectName,
+							Destination:  dest,
+							VolumeNumber: vol.VolumeNumber,
+							Bytes:        vol.Size,
+						})
+						if err := queue.markUploaded(vol, dest); err != nil {
+							helpers.EnvironmentOf(ctx).Logger.Warningf("Could not update the upload queue - %v", err)
+						}
+					}
+					if err := passVolume(ctx, out, vol); err != nil {
 						return err
 					}
-					helpers.AppLogger.Debugf("%s backend: Processed volume %s", prefix, vol.ObjectName)
-					out <- vol
 				}
 			}
-			return nil
 		})
 	}
 
 	gwg.Go(func() error {
 		wg.Wait()
-		helpers.AppLogger.Debugf("%s backend: closing out channel.", prefix)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("%s backend: closing out channel.", prefix)
 		close(out)
 		return nil
 	})
@@ -668,17 +1287,126 @@ func retryUploadChainer(ctx context.Context, in <-chan *helpers.VolumeInfo, b ba
 	return out, gwg
 }
 
+// teeUploadChainer will upload every volume to all the destinations at once, passing it along once every one of
+// them is done with it. A volume streamed without a temporary file can only be read once, so each destination
+// reads its own branch of it, while a staged volume, i.e. the manifest, is uploaded to one destination after another.
+func teeUploadChainer(ctx context.Context, in <-chan *helpers.VolumeInfo, bs []backends.Backend, j *helpers.JobInfo, queue *uploadQueue) (<-chan *helpers.VolumeInfo, *errgroup.Group) {
+	out := make(chan *helpers.VolumeInfo)
+	var gwg *errgroup.Group
+	gwg, ctx = errgroup.WithContext(ctx)
+
+	ins := make([]chan *helpers.VolumeInfo, len(bs))
+	outs := make([]<-chan *helpers.VolumeInfo, len(bs))
+	for idx, b := range bs {
+		ins[idx] = make(chan *helpers.VolumeInfo)
+		var waitgroup *errgroup.Group
+		outs[idx], waitgroup = retryUploadChainer(ctx, ins[idx], b, j, j.Destinations[idx], queue)
+		gwg.Go(waitgroup.Wait)
+	}
+
+	// Wait for a destination to be done with the volume it was handed
+	uploaded := func(idx int) error {
+		select {
+		case _, ok := <-outs[idx]:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				return fmt.Errorf("the upload to %s stopped before it was done", j.Destinations[idx])
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	gwg.Go(func() error {
+		defer close(out)
+		defer func() {
+			for _, c := range ins {
+				close(c)
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case vol, ok := <-in:
+				if !ok {
+					return nil
+				}
+				branches := vol.Branches()
+				for idx := range ins {
+					if branches == nil {
+						if err := passVolume(ctx, ins[idx], vol); err != nil {
+							return err
+						}
+						if err := uploaded(idx); err != nil {
+							return err
+						}
+						continue
+					}
+					if err := passVolume(ctx, ins[idx], branches[idx]); err != nil {
+						return err
+					}
+				}
+				if branches != nil {
+					for idx := range outs {
+						if err := uploaded(idx); err != nil {
+							return err
+						}
+					}
+					// Each destination recorded its upload on its own branch of the volume
+					for _, branch := range branches {
+						vol.RecordUpload(branch.UploadTime, branch.Retries)
+					}
+				}
+				if err := passVolume(ctx, out, vol); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return out, gwg
+}
+
+// passVolume will send the volume to the next stage of the pipeline unless the context is canceled first,
+// in which case the next stage may have stopped reading.
+func passVolume(ctx context.Context, out chan<- *helpers.VolumeInfo, vol *helpers.VolumeInfo) error {
+	select {
+	case out <- vol:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryNotifier returns a backoff.Notify that reports every failed attempt at processing the volume as a retry.
+func retryNotifier(ctx context.Context, j *helpers.JobInfo, vol *helpers.VolumeInfo, dest string) backoff.Notify {
+	return func(err error, wait time.Duration) {
+		helpers.LogFields{Dataset: j.VolumeName, Destination: dest, Volume: vol.ObjectName, Err: err}.Infof(ctx, "Retrying the transfer of %s with %s in %v after error - %v", vol.ObjectName, dest, wait.Round(time.Millisecond), err)
+		j.ReportProgress(helpers.ProgressEvent{
+			Type:         helpers.ProgressRetry,
+			ObjectName:   vol.ObjectName,
+			Destination:  dest,
+			VolumeNumber: vol.VolumeNumber,
+			Message:      err.Error(),
+		})
+	}
+}
+
 func volUploadWrapper(ctx context.Context, b backends.Backend, vol *helpers.VolumeInfo, prefix string) func() error {
 	return func() error {
 		if err := vol.OpenVolume(); err != nil {
-			helpers.AppLogger.Debugf("%s: Error while opening volume %s - %v", prefix, vol.ObjectName, err)
+			helpers.EnvironmentOf(ctx).Logger.Debugf("%s: Error while opening volume %s - %v", prefix, vol.ObjectName, err)
 			return err
 		}
 		defer vol.Close()
 
 		err := b.Upload(ctx, vol)
 		if err != nil {
-			helpers.AppLogger.Debugf("%s: Error while uploading volume %s - %v", prefix, vol.ObjectName, err)
+			helpers.EnvironmentOf(ctx).Logger.Debugf("%s: Error while uploading volume %s - %v", prefix, vol.ObjectName, err)
 		}
 		return err
 	}
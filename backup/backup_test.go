@@ -26,9 +26,12 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -74,6 +77,9 @@ func errTestErrTest(e error) bool          { return e == errTest }
 func errInvalidPrefixErrTest(e error) bool { return e == backends.ErrInvalidPrefix }
 func errInvalidURIErrTest(e error) bool    { return e == backends.ErrInvalidURI }
 func nonNilErrTest(e error) bool           { return e != nil }
+func notExistBackendErrTest(e error) bool {
+	return errors.Is(e, os.ErrNotExist) && helpers.KindOf(e) == helpers.ErrorKindBackend
+}
 func invalidByteErrTest(e error) bool {
 	_, ok := e.(hex.InvalidByteError)
 	return ok
@@ -95,7 +101,7 @@ func TestRetryUploadChainer(t *testing.T) {
 		},
 		{
 			vol:   badVol,
-			valid: os.IsNotExist,
+			valid: notExistBackendErrTest,
 		},
 	}
 
@@ -111,7 +117,7 @@ func TestRetryUploadChainer(t *testing.T) {
 			t.Errorf("%d: Expected error %v, got %v", idx, nil, err)
 		} else {
 			in := make(chan *helpers.VolumeInfo, 1)
-			out, wg := retryUploadChainer(context.Background(), in, b, j, "mock://")
+			out, wg := retryUploadChainer(context.Background(), in, b, j, "mock://", nil)
 			in <- testCase.vol
 			close(in)
 			outVol := <-out
@@ -127,6 +133,159 @@ func TestRetryUploadChainer(t *testing.T) {
 	}
 }
 
+func TestRetryUploadChainerCanceled(t *testing.T) {
+	testCases := []int{1, 4}
+
+	for idx, parallel := range testCases {
+		j := &helpers.JobInfo{
+			MaxParallelUploads: parallel,
+			MaxBackoffTime:     5 * time.Second,
+			MaxRetryTime:       1 * time.Minute,
+		}
+		b := &mockBackend{}
+		if err := b.Init(context.Background(), nil); err != nil {
+			t.Fatalf("%d: Expected error %v, got %v", idx, nil, err)
+		}
+
+		// The input is never closed, the uploaders must stop waiting on it once the context is canceled
+		ctx, cancel := context.WithCancel(context.Background())
+		_, wg := retryUploadChainer(ctx, make(chan *helpers.VolumeInfo), b, j, "mock://", nil)
+		cancel()
+
+		done := make(chan error, 1)
+		go func() { done <- wg.Wait() }()
+		select {
+		case err := <-done:
+			if err != context.Canceled {
+				t.Errorf("%d: Expected error %v, got %v", idx, context.Canceled, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%d: Timed out waiting for the uploaders to stop", idx)
+		}
+	}
+}
+
+// recordingBackend keeps what was uploaded to it, or fails every upload without reading the volume
+type recordingBackend struct {
+	mockBackend
+	fail bool
+	data []byte
+}
+
+func (r *recordingBackend) Upload(ctx context.Context, vol *helpers.VolumeInfo) error {
+	if r.fail {
+		return errTest
+	}
+	var err error
+	r.data, err = ioutil.ReadAll(vol)
+	return err
+}
+
+func TestTeeUploadChainer(t *testing.T) {
+	payload, goodVol, _, err := prepareTestVols()
+	if err != nil {
+		t.Fatalf("error preparing volumes for testing - %v", err)
+	}
+
+	testCases := []struct {
+		piped bool
+		fail  bool
+		valid errTestFunc
+	}{
+		{piped: true, valid: nilErrTest},
+		{piped: false, valid: nilErrTest},
+		{piped: true, fail: true, valid: nonNilErrTest},
+	}
+
+	for idx, testCase := range testCases {
+		j := &helpers.JobInfo{
+			VolumeName:         "pool/dataset",
+			BaseSnapshot:       helpers.SnapshotInfo{Name: "snap"},
+			Destinations:       []string{"mock://a", "mock://b", "mock://c"},
+			MaxParallelUploads: 1,
+			MaxBackoffTime:     10 * time.Millisecond,
+			MaxRetryTime:       50 * time.Millisecond,
+		}
+		bs := []*recordingBackend{{}, {fail: testCase.fail}, {}}
+
+		vol := goodVol
+		writeErr := make(chan error, 1)
+		if testCase.piped {
+			if vol, err = helpers.CreateBackupVolume(context.Background(), j, 1); err != nil {
+				t.Fatalf("%d: error creating a piped volume - %v", idx, err)
+			}
+			go func(vol *helpers.VolumeInfo) {
+				_, werr := io.Copy(vol, bytes.NewReader(payload))
+				if cerr := vol.Close(); werr == nil {
+					werr = cerr
+				}
+				writeErr <- werr
+			}(vol)
+		} else {
+			writeErr <- nil
+		}
+
+		in := make(chan *helpers.VolumeInfo, 1)
+		out, wg := teeUploadChainer(context.Background(), in, []backends.Backend{bs[0], bs[1], bs[2]}, j, nil)
+		in <- vol
+		close(in)
+		outVol := <-out
+
+		errResult := wg.Wait()
+		if !testCase.valid(errResult) {
+			t.Errorf("%d: error %v did not pass validation function", idx, errResult)
+			continue
+		}
+		if werr := <-writeErr; (werr != nil) != testCase.fail {
+			t.Errorf("%d: expected the volume to be written (%v), got error %v", idx, !testCase.fail, werr)
+		}
+		if errResult != nil {
+			continue
+		}
+		if outVol != vol {
+			t.Errorf("%d: did not get same volume passed in back out", idx)
+		}
+		for bidx, b := range bs {
+			if !bytes.Equal(b.data, payload) {
+				t.Errorf("%d: destination %d got %d bytes, expected the %d bytes of the volume", idx, bidx, len(b.data), len(payload))
+			}
+		}
+		if testCase.piped && vol.Size != uint64(len(payload)) {
+			t.Errorf("%d: expected a volume size of %d, got %d", idx, len(payload), vol.Size)
+		}
+	}
+}
+
+func TestChainLength(t *testing.T) {
+	snap := func(name string, hour int) helpers.SnapshotInfo {
+		return helpers.SnapshotInfo{Name: name, CreationTime: time.Date(2017, 1, 1, hour, 0, 0, 0, time.UTC)}
+	}
+	backup := func(base, incremental helpers.SnapshotInfo) *helpers.JobInfo {
+		return &helpers.JobInfo{VolumeName: "tank/data", BaseSnapshot: base, IncrementalSnapshot: incremental}
+	}
+	snap1, snap2, snap3, snap4 := snap("snap1", 1), snap("snap2", 2), snap("snap3", 3), snap("snap4", 4)
+
+	testCases := []struct {
+		backups  []*helpers.JobInfo
+		expected int
+		ok       bool
+	}{
+		{nil, 0, true},
+		{[]*helpers.JobInfo{backup(snap1, helpers.SnapshotInfo{})}, 0, true},
+		{[]*helpers.JobInfo{backup(snap3, snap2), backup(snap2, snap1), backup(snap1, helpers.SnapshotInfo{})}, 2, true},
+		{[]*helpers.JobInfo{backup(snap3, snap1), backup(snap2, snap1), backup(snap1, helpers.SnapshotInfo{})}, 1, true},
+		{[]*helpers.JobInfo{backup(snap3, snap2), backup(snap3, helpers.SnapshotInfo{}), backup(snap2, snap1), backup(snap1, helpers.SnapshotInfo{})}, 0, true},
+		{[]*helpers.JobInfo{backup(snap4, snap3), backup(snap3, snap2), backup(snap1, helpers.SnapshotInfo{})}, 1, false},
+	}
+
+	for idx, c := range testCases {
+		length, ok := chainLength(context.Background(), c.backups)
+		if length != c.expected || ok != c.ok {
+			t.Errorf("%d: expected %d (%v), got %d (%v)", idx, c.expected, c.ok, length, ok)
+		}
+	}
+}
+
 func prepareTestVols() (payload []byte, goodVol *helpers.VolumeInfo, badVol *helpers.VolumeInfo, err error) {
 	payload = make([]byte, 10*1024*1024)
 	if _, err = rand.Read(payload); err != nil {
@@ -159,3 +318,143 @@ func prepareTestVols() (payload []byte, goodVol *helpers.VolumeInfo, badVol *hel
 
 	return
 }
+
+func TestBackedUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupbackedup")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = filepath.Join(dir, "work")
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	created := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	newJob := func(destinations []string) *helpers.JobInfo {
+		return &helpers.JobInfo{
+			VolumeName:          "tank",
+			BaseSnapshot:        helpers.SnapshotInfo{Name: "snap2", CreationTime: created.Add(time.Hour)},
+			IncrementalSnapshot: helpers.SnapshotInfo{Name: "snap1", CreationTime: created},
+			Compressor:          helpers.InternalCompressor,
+			CompressionLevel:    6,
+			DigestAlgorithm:     helpers.DigestSHA256,
+			Separator:           "|",
+			ManifestPrefix:      "manifests",
+			MaxParallelUploads:  1,
+			Destinations:        destinations,
+		}
+	}
+
+	testCases := []struct {
+		stored  int // The number of destinations the manifest is stored at
+		modify  func(j *helpers.JobInfo)
+		matched bool
+	}{
+		{stored: 2, matched: true},
+		{stored: 1, matched: false},
+		{stored: 2, modify: func(j *helpers.JobInfo) { j.DigestAlgorithm = "" }, matched: true},
+		{stored: 2, modify: func(j *helpers.JobInfo) { j.CompressionLevel = 9 }, matched: false},
+		{stored: 2, modify: func(j *helpers.JobInfo) { j.BaseSnapshot.CreationTime = created.Add(2 * time.Hour) }, matched: false},
+		{stored: 2, modify: func(j *helpers.JobInfo) { j.IncrementalSnapshot = helpers.SnapshotInfo{} }, matched: false},
+		{stored: 2, modify: func(j *helpers.JobInfo) { j.ContentAddressed = true }, matched: false},
+	}
+
+	for idx, c := range testCases {
+		var destinations []string
+		for didx := 0; didx < 2; didx++ {
+			target := filepath.Join(dir, fmt.Sprintf("target%d", didx))
+			if err = os.MkdirAll(target, 0700); err != nil {
+				t.Fatalf("could not create target: %v", err)
+			}
+			destinations = append(destinations, "file://"+target)
+		}
+
+		manifest := newJob(destinations[:c.stored])
+		for _, destination := range manifest.Destinations {
+			if _, err = getCacheDir(context.Background(), destination); err != nil {
+				t.Fatalf("%d: could not create the cache: %v", idx, err)
+			}
+		}
+		vol, serr := saveManifest(context.Background(), manifest, true)
+		if serr != nil {
+			t.Fatalf("%d: could not save the manifest: %v", idx, serr)
+		}
+		for _, destination := range manifest.Destinations {
+			if err = vol.CopyTo(filepath.Join(strings.TrimPrefix(destination, "file://"), vol.ObjectName)); err != nil {
+				t.Fatalf("%d: could not store the manifest: %v", idx, err)
+			}
+		}
+		vol.DeleteVolume()
+
+		j := newJob(destinations)
+		if c.modify != nil {
+			c.modify(j)
+		}
+		found, berr := backedUp(context.Background(), j)
+		if berr != nil {
+			t.Errorf("%d: unexpected error %v", idx, berr)
+		}
+		if (found != nil) != c.matched {
+			t.Errorf("%d: expected the backup set to be found (%v), got %v", idx, c.matched, found)
+		}
+
+		os.RemoveAll(dir)
+	}
+}
+
+func TestOutputSizer(t *testing.T) {
+	ctx := context.Background()
+	random := make([]byte, 4*1024*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("could not generate data: %v", err)
+	}
+	// Half of the data compresses well, half of it doesn't
+	compressible := append(bytes.Repeat([]byte("zfsbackup "), 200*1024), random[:2*1024*1024]...)
+
+	for name, data := range map[string][]byte{"random": random, "compressible": compressible} {
+		j := &helpers.JobInfo{
+			VolumeName:       "pool/fs",
+			BaseSnapshot:     helpers.SnapshotInfo{Name: "snap"},
+			Separator:        "|",
+			Compressor:       helpers.InternalCompressor,
+			CompressionLevel: 6,
+			MaxFileBuffer:    1,
+			VolumeSize:       1,
+		}
+		vol, err := helpers.CreateBackupVolume(ctx, j, 1)
+		if err != nil {
+			t.Fatalf("%s: could not create volume: %v", name, err)
+		}
+		sizer := &outputSizer{limit: j.VolumeSize * 1024 * 1024}
+		var written int
+		for written < len(data) {
+			full, ferr := sizer.full(context.Background(), vol, 128*1024)
+			if ferr != nil {
+				t.Fatalf("%s: could not flush the volume: %v", name, ferr)
+			}
+			if full {
+				break
+			}
+			n := int(sizer.fit(vol, 128*1024))
+			if written+n > len(data) {
+				n = len(data) - written
+			}
+			if _, err = vol.Write(data[written : written+n]); err != nil {
+				t.Fatalf("%s: could not write volume: %v", name, err)
+			}
+			written += n
+			sizer.pending += uint64(n)
+		}
+		if err = vol.Close(); err != nil {
+			t.Fatalf("%s: could not close volume: %v", name, err)
+		}
+		if written == len(data) {
+			t.Errorf("%s: expected the data not to fit in a single volume", name)
+		}
+		if vol.Size > sizer.limit || vol.Size < sizer.limit-2*volumeOutputSlack {
+			t.Errorf("%s: expected the volume to end up just under %d bytes, got %d", name, sizer.limit, vol.Size)
+		}
+		vol.DeleteVolume()
+	}
+}
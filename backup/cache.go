@@ -0,0 +1,206 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// cacheIndexFile is the file in a local cache dir recording the version of every manifest cached
+const cacheIndexFile = ".index"
+
+// cacheIndex records when a local cache dir was last synced with its destination and the
+// version of every manifest found there, by the name of its file in the cache dir.
+type cacheIndex struct {
+	Synced    time.Time
+	Manifests map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	ObjectName string
+	Version    string `json:",omitempty"` // The ETag or generation number of the manifest, empty if the backend has none
+}
+
+// CacheStatus describes the local cache of the manifests found at a destination.
+type CacheStatus struct {
+	Target     string
+	Path       string
+	Synced     time.Time // When the cache was last synced with the destination, zero if never
+	Manifests  int       // Manifests cached that are found at the destination
+	Downloaded int       // Manifests downloaded by the sync, missing from the cache or changed since they were cached
+	LocalOnly  int       // Manifests cached that are no longer found at the destination
+}
+
+// SyncCache will bring the local cache of the first destination provided in jobInfo up to date with the
+// manifests found there, downloading those missing or changed since they were cached. With invalidate, every
+// manifest cached is deleted first so all of them are downloaded again.
+func SyncCache(pctx context.Context, jobInfo *helpers.JobInfo, invalidate bool) (*CacheStatus, error) {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	target := jobInfo.Destinations[0]
+	localCachePath, err := getCacheDir(ctx, target)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+
+	backend, err := prepareBackend(ctx, jobInfo, target, nil)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+	defer backend.Close()
+
+	if invalidate {
+		if err = invalidateCache(localCachePath); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not invalidate the cache dir for target %s due to error - %v.", target, err)
+			return nil, err
+		}
+		helpers.EnvironmentOf(ctx).Logger.Infof("Invalidated the cache dir %s.", localCachePath)
+	}
+
+	safeManifests, localOnlyFiles, downloaded, err := refreshCache(ctx, jobInfo, localCachePath, backend)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+
+	return &CacheStatus{
+		Target:     target,
+		Path:       localCachePath,
+		Synced:     readCacheIndex(ctx, localCachePath).Synced,
+		Manifests:  len(safeManifests),
+		Downloaded: downloaded,
+		LocalOnly:  len(localOnlyFiles),
+	}, nil
+}
+
+// String will return a one line summary of the cache status.
+func (c *CacheStatus) String() string {
+	return fmt.Sprintf("Synced %d manifests from %s to %s, %d of them downloaded, %d cached manifests are not found at the destination.",
+		c.Manifests, c.Target, c.Path, c.Downloaded, c.LocalOnly)
+}
+
+// offlineManifests will return the manifests found in the local cache dir of a target that couldn't be
+// reached due to the error provided, which is returned instead if nothing is cached. Once the cache was
+// synced, only the manifests found at the destination by the last sync are returned.
+func offlineManifests(ctx context.Context, localCachePath, target string, err error) ([]string, error) {
+	index := readCacheIndex(ctx, localCachePath)
+	manifests, cerr := cachedManifests(localCachePath)
+	if cerr == nil && !index.Synced.IsZero() {
+		found := manifests[:0]
+		for _, manifest := range manifests {
+			if _, ok := index.Manifests[manifest]; ok {
+				found = append(found, manifest)
+			}
+		}
+		manifests = found
+	}
+	if cerr != nil || len(manifests) == 0 {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not reach target %s and there is no local cache of its manifests to use instead - %v.", target, err)
+		return nil, err
+	}
+
+	synced := "at an unknown time"
+	if !index.Synced.IsZero() {
+		synced = "on " + index.Synced.Local().Format(time.RFC3339)
+	}
+	helpers.EnvironmentOf(ctx).Logger.Warningf("Could not reach target %s, using the %d manifests of its local cache last synced %s instead - %v.", target, len(manifests), synced, err)
+	return manifests, nil
+}
+
+// cachedManifests will return the names of the manifest files found in the local cache dir provided.
+func cachedManifests(localCachePath string) ([]string, error) {
+	files, err := ioutil.ReadDir(localCachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make([]string, 0, len(files))
+	for _, file := range files {
+		// Skip the index of the cache and anything else hidden
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		manifests = append(manifests, file.Name())
+	}
+	return manifests, nil
+}
+
+// readCacheIndex will read the index of the local cache dir provided, an empty one is returned
+// if it was never synced or its index can't be read.
+func readCacheIndex(ctx context.Context, localCachePath string) *cacheIndex {
+	index := &cacheIndex{Manifests: make(map[string]cacheEntry)}
+	data, err := ioutil.ReadFile(filepath.Join(localCachePath, cacheIndexFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not read the index of the local cache dir %s due to error - %v", localCachePath, err)
+		}
+		return index
+	}
+
+	if err = json.Unmarshal(data, index); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Ignoring the corrupt index of the local cache dir %s - %v", localCachePath, err)
+		return &cacheIndex{Manifests: make(map[string]cacheEntry)}
+	}
+	if index.Manifests == nil {
+		index.Manifests = make(map[string]cacheEntry)
+	}
+	return index
+}
+
+func writeCacheIndex(localCachePath string, index *cacheIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(localCachePath, cacheIndexFile)
+	if err = ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// invalidateCache will delete every manifest cached in the local cache dir provided along with its index.
+func invalidateCache(localCachePath string) error {
+	manifests, err := cachedManifests(localCachePath)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range append(manifests, cacheIndexFile) {
+		if err = os.Remove(filepath.Join(localCachePath, manifest)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
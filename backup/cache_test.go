@@ -0,0 +1,125 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestRefreshCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupcache")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	target, localCache := filepath.Join(dir, "target"), filepath.Join(dir, "cache")
+	for _, d := range []string{target, localCache} {
+		if err = os.MkdirAll(d, 0700); err != nil {
+			t.Fatalf("could not create directory: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	j := &helpers.JobInfo{ManifestPrefix: "manifests"}
+	backend, err := prepareBackend(ctx, j, "file://"+target, nil)
+	if err != nil {
+		t.Fatalf("could not prepare backend: %v", err)
+	}
+
+	write := func(name, data string, mtime time.Time) func() {
+		return func() {
+			path := filepath.Join(target, name)
+			if werr := ioutil.WriteFile(path, []byte(data), 0600); werr != nil {
+				t.Fatalf("could not write object: %v", werr)
+			}
+			os.Chtimes(path, mtime, mtime)
+		}
+	}
+	remove := func(name string) func() {
+		return func() { os.Remove(filepath.Join(target, name)) }
+	}
+	then := time.Now().Add(-time.Hour)
+
+	testCases := []struct {
+		change     func()
+		manifests  int
+		downloaded int
+		localOnly  int
+	}{
+		{write("manifests|a", "a", then), 1, 1, 0},
+		{write("manifests|b", "b", then), 2, 1, 0},
+		// Nothing changed at the destination
+		{func() {}, 2, 0, 0},
+		// Replaced at the destination
+		{write("manifests|a", "aa", time.Now()), 2, 1, 0},
+		// Not a manifest
+		{write("Tank|snap1.zstream.gz.vol1", "v", then), 2, 0, 0},
+		{remove("manifests|b"), 1, 0, 1},
+	}
+
+	for idx, c := range testCases {
+		c.change()
+		safeManifests, localOnly, downloaded, rerr := refreshCache(ctx, j, localCache, backend)
+		if rerr != nil {
+			t.Errorf("%d: unexpected error %v", idx, rerr)
+			continue
+		}
+		if len(safeManifests) != c.manifests || downloaded != c.downloaded || len(localOnly) != c.localOnly {
+			t.Errorf("%d: expected %d manifests, %d downloaded, and %d local only, got %d, %d, and %d", idx,
+				c.manifests, c.downloaded, c.localOnly, len(safeManifests), downloaded, len(localOnly))
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(localCache, fmt.Sprintf("%x", md5.Sum([]byte("manifests|a")))))
+	if err != nil || string(data) != "aa" {
+		t.Errorf("expected the replaced manifest to be cached, got %q (%v)", data, err)
+	}
+
+	// Offline, only the manifests found at the destination by the last sync are used
+	errOffline := errors.New("offline")
+	manifests, err := offlineManifests(context.Background(), localCache, "file://"+target, errOffline)
+	sort.Strings(manifests)
+	if err != nil || len(manifests) != 1 || manifests[0] != fmt.Sprintf("%x", md5.Sum([]byte("manifests|a"))) {
+		t.Errorf("expected only the manifest still found at the destination, got %v (%v)", manifests, err)
+	}
+
+	if err = invalidateCache(localCache); err != nil {
+		t.Fatalf("could not invalidate the cache: %v", err)
+	}
+	if _, err = offlineManifests(context.Background(), localCache, "file://"+target, errOffline); err != errOffline {
+		t.Errorf("expected the error reaching the destination for an empty cache, got %v", err)
+	}
+	if _, _, downloaded, _ := refreshCache(ctx, j, localCache, backend); downloaded != 1 {
+		t.Errorf("expected every manifest to be downloaded again once invalidated, got %d", downloaded)
+	}
+}
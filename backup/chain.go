@@ -0,0 +1,147 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"fmt"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// snapshotKey identifies the snapshot of a volume the same way backup sets are linked by.
+func snapshotKey(volume string, snapshot helpers.SnapshotInfo) string {
+	return fmt.Sprintf("%s@%s@%v", volume, snapshot.Name, snapshot.CreationTime.UTC())
+}
+
+// restorePointName will return how a backup set is referred to as a restore point.
+func restorePointName(set *helpers.JobInfo) string {
+	return fmt.Sprintf("%s@%s", set.VolumeName, set.BaseSnapshot.Name)
+}
+
+// chainGraph maps every backup set to the backup sets it could be restored on top of.
+type chainGraph map[*helpers.JobInfo][]*helpers.JobInfo
+
+// newChainGraph will link every incremental backup set provided to every backup set, full or
+// incremental, of the snapshot it was taken from.
+func newChainGraph(sets []*helpers.JobInfo) chainGraph {
+	bySnapshot := make(map[string][]*helpers.JobInfo, len(sets))
+	for _, set := range sets {
+		key := snapshotKey(set.VolumeName, set.BaseSnapshot)
+		bySnapshot[key] = append(bySnapshot[key], set)
+	}
+
+	graph := make(chainGraph, len(sets))
+	for _, set := range sets {
+		graph[set] = nil
+		if set.IncrementalSnapshot.Name != "" {
+			graph[set] = bySnapshot[snapshotKey(set.VolumeName, set.IncrementalSnapshot)]
+		}
+	}
+	return graph
+}
+
+// restorable will return whether every backup set of the graph is chained to a full backup set.
+func (g chainGraph) restorable() map[*helpers.JobInfo]bool {
+	result := make(map[*helpers.JobInfo]bool, len(g))
+	var visit func(set *helpers.JobInfo) bool
+	visit = func(set *helpers.JobInfo) bool {
+		if r, ok := result[set]; ok {
+			return r
+		}
+		// Guard against cycles while visiting the parents
+		result[set] = false
+		r := set.IncrementalSnapshot.Name == ""
+		for _, parent := range g[set] {
+			if r = visit(parent); r {
+				break
+			}
+		}
+		result[set] = r
+		return r
+	}
+
+	for set := range g {
+		visit(set)
+	}
+	return result
+}
+
+// ancestors will return every backup set the backup set provided could be restored on top of, directly or not.
+func (g chainGraph) ancestors(set *helpers.JobInfo) []*helpers.JobInfo {
+	seen := map[*helpers.JobInfo]bool{set: true}
+	var result []*helpers.JobInfo
+	queue := []*helpers.JobInfo{set}
+	for len(queue) > 0 {
+		for _, parent := range g[queue[0]] {
+			if !seen[parent] {
+				seen[parent] = true
+				result = append(result, parent)
+				queue = append(queue, parent)
+			}
+		}
+		queue = queue[1:]
+	}
+	return result
+}
+
+// chainDependencies will return, for every backup set to delete that a retained backup set depends on,
+// the retained restore points that can be restored now but would become unrestorable if it was deleted.
+func chainDependencies(sets, toDelete []*helpers.JobInfo) map[*helpers.JobInfo][]*helpers.JobInfo {
+	deleted := make(map[*helpers.JobInfo]bool, len(toDelete))
+	for _, set := range toDelete {
+		deleted[set] = true
+	}
+
+	remaining := make([]*helpers.JobInfo, 0, len(sets))
+	for _, set := range sets {
+		if !deleted[set] {
+			remaining = append(remaining, set)
+		}
+	}
+
+	graph := newChainGraph(sets)
+	before := graph.restorable()
+	after := newChainGraph(remaining).restorable()
+
+	// A restore point stays restorable as long as one of its backup sets does (e.g. a consolidated full backup set)
+	restorablePoints := make(map[string]bool)
+	for set, ok := range after {
+		if ok {
+			restorablePoints[snapshotKey(set.VolumeName, set.BaseSnapshot)] = true
+		}
+	}
+
+	dependencies := make(map[*helpers.JobInfo][]*helpers.JobInfo)
+	reported := make(map[string]bool)
+	for _, set := range remaining {
+		key := snapshotKey(set.VolumeName, set.BaseSnapshot)
+		if !before[set] || after[set] || restorablePoints[key] || reported[key] {
+			continue
+		}
+		reported[key] = true
+		for _, ancestor := range graph.ancestors(set) {
+			if deleted[ancestor] {
+				dependencies[ancestor] = append(dependencies[ancestor], set)
+			}
+		}
+	}
+	return dependencies
+}
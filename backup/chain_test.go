@@ -0,0 +1,84 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestChainDependencies(t *testing.T) {
+	snap := func(name string, hour int) helpers.SnapshotInfo {
+		return helpers.SnapshotInfo{Name: name, CreationTime: time.Date(2017, 1, 1, hour, 0, 0, 0, time.UTC)}
+	}
+	backup := func(base, incremental helpers.SnapshotInfo) *helpers.JobInfo {
+		return &helpers.JobInfo{VolumeName: "tank/data", BaseSnapshot: base, IncrementalSnapshot: incremental}
+	}
+	snap1, snap2, snap3, snap4 := snap("snap1", 1), snap("snap2", 2), snap("snap3", 3), snap("snap4", 4)
+	full1 := backup(snap1, helpers.SnapshotInfo{})
+	incr2 := backup(snap2, snap1)
+	incr3 := backup(snap3, snap2)
+	full3 := backup(snap3, helpers.SnapshotInfo{})
+	incr4 := backup(snap4, snap3)
+	orphan := backup(snap4, snap("snap0", 0))
+
+	testCases := []struct {
+		sets     []*helpers.JobInfo
+		toDelete []*helpers.JobInfo
+		expected map[*helpers.JobInfo]string
+	}{
+		// Nothing depends on the latest incremental backup set
+		{[]*helpers.JobInfo{full1, incr2, incr3}, []*helpers.JobInfo{incr3}, map[*helpers.JobInfo]string{}},
+		// Every later restore point depends on the full backup set
+		{[]*helpers.JobInfo{full1, incr2, incr3}, []*helpers.JobInfo{full1}, map[*helpers.JobInfo]string{full1: "tank/data@snap2,tank/data@snap3"}},
+		// Deleting the whole chain leaves nothing behind to break
+		{[]*helpers.JobInfo{full1, incr2, incr3}, []*helpers.JobInfo{full1, incr2, incr3}, map[*helpers.JobInfo]string{}},
+		{[]*helpers.JobInfo{full1, incr2, incr3}, []*helpers.JobInfo{full1, incr2}, map[*helpers.JobInfo]string{full1: "tank/data@snap3", incr2: "tank/data@snap3"}},
+		// A consolidated full backup set keeps the later restore points restorable
+		{[]*helpers.JobInfo{full1, incr2, incr3, full3, incr4}, []*helpers.JobInfo{full1, incr2}, map[*helpers.JobInfo]string{}},
+		{[]*helpers.JobInfo{full1, incr2, incr3, full3, incr4}, []*helpers.JobInfo{full3}, map[*helpers.JobInfo]string{}},
+		{[]*helpers.JobInfo{full1, incr2, incr3, full3, incr4}, []*helpers.JobInfo{full3, incr3}, map[*helpers.JobInfo]string{full3: "tank/data@snap4", incr3: "tank/data@snap4"}},
+		// Restore points that are already unrestorable are not reported
+		{[]*helpers.JobInfo{full1, orphan}, []*helpers.JobInfo{full1}, map[*helpers.JobInfo]string{}},
+	}
+
+	for idx, c := range testCases {
+		dependencies := chainDependencies(c.sets, c.toDelete)
+		if len(dependencies) != len(c.expected) {
+			t.Errorf("%d: expected %d backup sets with dependents, got %d", idx, len(c.expected), len(dependencies))
+			continue
+		}
+		for set, expected := range c.expected {
+			var names []string
+			for _, dependent := range dependencies[set] {
+				names = append(names, restorePointName(dependent))
+			}
+			sort.Strings(names)
+			if got := strings.Join(names, ","); got != expected {
+				t.Errorf("%d: expected %s to have dependents %s, got %s", idx, restorePointName(set), expected, got)
+			}
+		}
+	}
+}
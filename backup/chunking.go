@@ -0,0 +1,540 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// A zfs send stream is a sequence of records, each a header of a fixed size followed by its payload. Every
+// header carries the guid of the snapshot sent and a checksum of the stream up to it, so two streams never share
+// their headers even when they send the same data. To find what they do share, the data of the WRITE and SPILL
+// records is split off into chunks, at boundaries found in the data itself so data inserted or removed only
+// changes the chunks around it, while the headers and the payloads of the other records are kept, in order, in
+// the layout volumes of the backup set. A layout volume is a sequence of entries, each the bytes of the stream
+// kept as they are followed by how many bytes of the chunks come next:
+//
+//	uvarint(len(kept)) kept uvarint(chunked)
+//
+// Putting the stream back together does not depend on how it was split, so a stream that is not understood is
+// still stored whole, its data just isn't shared with other streams.
+const (
+	zfsRecordSize  = 312         // sizeof(dmu_replay_record_t)
+	zfsBackupMagic = 0x2F5bacbac // DMU_BACKUP_MAGIC, starts the BEGIN record
+
+	// keptRecordsSize is how much of the records kept in the layout are read at most before they are written
+	keptRecordsSize = 64 * 1024
+	// keptPayloadSize is the largest payload of a record, other than WRITE and SPILL records, kept in the layout
+	keptPayloadSize = 64 * 1024
+	// layoutSegmentSize is how large a layout volume grows before it ends at the next chunk boundary
+	layoutSegmentSize = 4 * 1024 * 1024
+)
+
+// Types of the records of a zfs send stream, see dmu_replay_record_t
+const (
+	drrBegin uint32 = iota
+	drrObject
+	drrFreeObjects
+	drrWrite
+	drrFree
+	drrEnd
+	drrWriteByRef
+	drrSpill
+	drrWriteEmbedded
+	drrObjectRange
+	drrRedact
+)
+
+var errCorruptLayout = errors.New("the layout volume is corrupt")
+
+// gearTable holds the random value the rolling hash of the chunker adds for every byte. Changing it changes where
+// streams are split, so none of the chunks stored before would be shared anymore.
+var gearTable = func() (table [256]uint64) {
+	// splitmix64
+	seed := uint64(0x7a66736261636b75)
+	for idx := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[idx] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// chunker finds the boundaries of chunks in the data it is given with FastCDC: a rolling hash of the last 64
+// bytes ends a chunk when its top bits are all zero, with more bits to match below the average size of a chunk
+// and less above it, and chunks are kept between a quarter and four times the average size.
+type chunker struct {
+	min, avg, max uint64
+	small, large  uint64 // Masks of the hash below and above the average size
+	size, hash    uint64
+}
+
+// newChunker will return a chunker of the average size provided, a power of two.
+func newChunker(avg uint64) *chunker {
+	bits := uint(0)
+	for uint64(1)<<(bits+1) <= avg {
+		bits++
+	}
+	return &chunker{
+		min:   avg / 4,
+		avg:   avg,
+		max:   avg * 4,
+		small: ^uint64(0) << (64 - (bits + 2)),
+		large: ^uint64(0) << (64 - (bits - 2)),
+	}
+}
+
+// next returns how much of p belongs to the current chunk and whether the chunk ends there.
+func (c *chunker) next(p []byte) (int, bool) {
+	for idx, b := range p {
+		c.size++
+		if c.size <= c.min {
+			continue
+		}
+		c.hash = c.hash<<1 + gearTable[b]
+		mask := c.large
+		if c.size < c.avg {
+			mask = c.small
+		}
+		if c.hash&mask == 0 || c.size >= c.max {
+			c.size, c.hash = 0, 0
+			return idx + 1, true
+		}
+	}
+	return len(p), false
+}
+
+// streamSplitter reads a zfs send stream, telling the records kept in the layout apart from the data to chunk.
+type streamSplitter struct {
+	r       io.Reader
+	order   binary.ByteOrder
+	header  [zfsRecordSize]byte
+	chunked uint64 // Bytes of the stream to chunk before the next record
+}
+
+// next will read the records up to the next data to chunk and return them. Once it returns, chunked bytes of
+// the stream must be read with read before it is called again. It returns io.EOF once the stream ends.
+func (s *streamSplitter) next(ctx context.Context) ([]byte, error) {
+	var kept []byte
+	for len(kept) < keptRecordsSize {
+		n, err := io.ReadFull(s.r, s.header[:])
+		kept = append(kept, s.header[:n]...)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return kept, io.EOF
+		} else if err != nil {
+			return kept, err
+		}
+
+		size, chunk, ok := s.payload()
+		if !ok {
+			helpers.EnvironmentOf(ctx).Logger.Debugf("Could not understand the zfs send stream, chunking the rest of it as is.")
+			s.chunked = math.MaxUint64
+			return kept, nil
+		}
+		if chunk || size > keptPayloadSize {
+			if size == 0 {
+				continue
+			}
+			s.chunked = size
+			return kept, nil
+		}
+
+		start := len(kept)
+		kept = append(kept, make([]byte, size)...)
+		n, err = io.ReadFull(s.r, kept[start:])
+		kept = kept[:start+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return kept, io.EOF
+		} else if err != nil {
+			return kept, err
+		}
+	}
+	return kept, nil
+}
+
+// read will read up to len(p) bytes of the data to chunk.
+func (s *streamSplitter) read(p []byte) (int, error) {
+	if uint64(len(p)) > s.chunked {
+		p = p[:s.chunked]
+	}
+	n, err := io.ReadFull(s.r, p)
+	s.chunked -= uint64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// The stream ended, next will tell
+		s.chunked = 0
+		err = nil
+	}
+	return n, err
+}
+
+// skip will read past the first n bytes of the stream, whole segments stored by a previous run.
+func (s *streamSplitter) skip(ctx context.Context, n uint64) error {
+	for n > 0 {
+		if s.chunked > 0 {
+			size := s.chunked
+			if size > n {
+				size = n
+			}
+			skipped, err := io.CopyN(ioutil.Discard, s.r, int64(size))
+			s.chunked -= uint64(skipped)
+			n -= uint64(skipped)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		kept, err := s.next(ctx)
+		if uint64(len(kept)) > n {
+			return fmt.Errorf("the %d bytes to skip end within the records of the stream", n)
+		}
+		n -= uint64(len(kept))
+		if err == io.EOF && n > 0 {
+			return io.ErrUnexpectedEOF
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+// payload returns the size of the payload of the record whose header was read and whether it is data to chunk.
+// It reports false if the header is not one of a zfs send stream.
+func (s *streamSplitter) payload() (uint64, bool, bool) {
+	h := s.header[:]
+	if s.order == nil {
+		// The stream starts with a BEGIN record, written in the byte order of the host that sent it
+		switch {
+		case binary.LittleEndian.Uint64(h[8:]) == zfsBackupMagic:
+			s.order = binary.LittleEndian
+		case binary.BigEndian.Uint64(h[8:]) == zfsBackupMagic:
+			s.order = binary.BigEndian
+		default:
+			return 0, false, false
+		}
+	}
+
+	u := h[8:]
+	switch s.order.Uint32(h) {
+	case drrBegin:
+		return uint64(s.order.Uint32(h[4:])), false, true
+	case drrObject:
+		if raw := s.order.Uint32(u[28:]); raw != 0 {
+			return uint64(raw), false, true
+		}
+		return roundUp8(uint64(s.order.Uint32(u[20:]))), false, true
+	case drrWrite:
+		if compression := u[42]; compression != 0 {
+			return s.order.Uint64(u[88:]), true, true
+		}
+		return s.order.Uint64(u[24:]), true, true
+	case drrSpill:
+		if compressed := s.order.Uint64(u[32:]); compressed != 0 {
+			return compressed, true, true
+		}
+		return s.order.Uint64(u[8:]), true, true
+	case drrWriteEmbedded:
+		return roundUp8(uint64(s.order.Uint32(u[44:]))), false, true
+	case drrFreeObjects, drrFree, drrEnd, drrWriteByRef, drrObjectRange, drrRedact:
+		return 0, false, true
+	}
+	return 0, false, false
+}
+
+func roundUp8(n uint64) uint64 {
+	return (n + 7) &^ 7
+}
+
+// appendLayoutEntry will append the entry of the bytes of the stream kept followed by chunked bytes of the
+// chunks to the layout.
+func appendLayoutEntry(layout *bytes.Buffer, kept []byte, chunked uint64) {
+	var size [binary.MaxVarintLen64]byte
+	layout.Write(size[:binary.PutUvarint(size[:], uint64(len(kept)))])
+	layout.Write(kept)
+	layout.Write(size[:binary.PutUvarint(size[:], chunked)])
+}
+
+// sendChunks will split the zfs send stream read from r into chunks and the layout volumes describing how to put
+// it back together. The stream is stored in segments, each starting with its layout volume, numbered before its
+// chunks but written once they all are, when the layout holds layoutSegmentSize bytes or the chunks VolumeSize
+// MiB. The first skip bytes of the stream, whole segments stored by a previous run, are read past and volNum is
+// the number of the first volume to write. Every volume written is handed to pass. The number of bytes read from
+// r is returned.
+func sendChunks(ctx context.Context, j *helpers.JobInfo, r io.Reader, skip uint64, volNum int64, readSize int64, buffer <-chan bool, pass func(*helpers.VolumeInfo) error) (streamed uint64, err error) {
+	splitter := &streamSplitter{r: r}
+	if skip > 0 {
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Want to skip %d bytes.", skip)
+		if err = splitter.skip(ctx, skip); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from the zfs stream to skip %d bytes - %v", skip, err)
+			return 0, err
+		}
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Skipped %d bytes of the ZFS send stream.", skip)
+	}
+	streamed = skip
+
+	var (
+		chunker   = newChunker(uint64(j.ChunkSize) * humanize.KiByte)
+		chunk     *helpers.VolumeInfo
+		chunkSize uint64
+		layout    bytes.Buffer
+		layoutNum = volNum
+		kept      []byte
+		chunked   uint64
+		// Bytes of the stream held by the layout and the chunks of the segment
+		keptBytes, chunkedBytes uint64
+	)
+	volNum++
+	defer func() {
+		// Don't leave the chunk we were writing behind if the stream was interrupted
+		if err != nil && chunk != nil {
+			chunk.Close()
+			if derr := chunk.DeleteVolume(); derr != nil {
+				helpers.EnvironmentOf(ctx).Logger.Warningf("Could not delete the incomplete chunk %s - %v", chunk.ObjectName, derr)
+			}
+		}
+	}()
+
+	endEntry := func() {
+		if len(kept) > 0 || chunked > 0 {
+			appendLayoutEntry(&layout, kept, chunked)
+		}
+		kept, chunked = kept[:0], 0
+	}
+	closeChunk := func() error {
+		vol := chunk
+		chunk = nil
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Finished creating chunk %s", vol.ObjectName)
+		vol.ZFSStreamBytes = chunkSize
+		chunkSize = 0
+		if cerr := vol.Close(); cerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to close chunk %s - %v", vol.ObjectName, cerr)
+			vol.DeleteVolume()
+			return cerr
+		}
+		return pass(vol)
+	}
+	endSegment := func() error {
+		endEntry()
+		<-buffer
+		vol, cerr := helpers.CreateLayoutVolume(ctx, j, layoutNum)
+		if cerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while creating volume %d - %v", layoutNum, cerr)
+			return cerr
+		}
+		vol.ZFSStreamBytes = keptBytes
+		if _, cerr = vol.Write(layout.Bytes()); cerr == nil {
+			cerr = vol.Close()
+		}
+		if cerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to write volume %s - %v", vol.ObjectName, cerr)
+			vol.Close()
+			vol.DeleteVolume()
+			return cerr
+		}
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Finished creating volume %s", vol.ObjectName)
+		layout.Reset()
+		keptBytes, chunkedBytes = 0, 0
+		return pass(vol)
+	}
+
+	buf := make([]byte, readSize)
+	for {
+		if splitter.chunked == 0 {
+			records, rerr := splitter.next(ctx)
+			if len(records) > 0 {
+				if chunked > 0 {
+					endEntry()
+				}
+				kept = append(kept, records...)
+				keptBytes += uint64(len(records))
+				streamed += uint64(len(records))
+			}
+			if rerr == io.EOF {
+				// We are done!
+				if chunk != nil {
+					if err = closeChunk(); err != nil {
+						return streamed, err
+					}
+				}
+				return streamed, endSegment()
+			} else if rerr != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from the zfs stream - %v", rerr)
+				return streamed, rerr
+			}
+			continue
+		}
+
+		n, rerr := splitter.read(buf)
+		if rerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from the zfs stream - %v", rerr)
+			return streamed, rerr
+		}
+		for data := buf[:n]; len(data) > 0; {
+			if chunk == nil {
+				<-buffer
+				if chunk, err = helpers.CreateBackupVolume(ctx, j, volNum); err != nil {
+					helpers.EnvironmentOf(ctx).Logger.Errorf("Error while creating volume %d - %v", volNum, err)
+					return streamed, err
+				}
+				volNum++
+			}
+			size, end := chunker.next(data)
+			if _, err = chunk.Write(data[:size]); err != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to write chunk %s - %v", chunk.ObjectName, err)
+				return streamed, err
+			}
+			data = data[size:]
+			chunkSize += uint64(size)
+			chunked += uint64(size)
+			chunkedBytes += uint64(size)
+			streamed += uint64(size)
+			if !end {
+				continue
+			}
+
+			if err = closeChunk(); err != nil {
+				return streamed, err
+			}
+			if uint64(layout.Len()+len(kept)) >= layoutSegmentSize || chunkedBytes >= j.VolumeSize*humanize.MiByte {
+				if err = endSegment(); err != nil {
+					return streamed, err
+				}
+				layoutNum = volNum
+				volNum++
+			}
+		}
+	}
+}
+
+// resumeSegments will trim the volumes a resumed backup keeps, those uploaded and those staged by the previous
+// run, to whole segments of the stream: a segment is only known to be complete once the layout volume of the next
+// one was written. It returns the staged volumes to upload and how much of the stream they hold.
+func resumeSegments(j *helpers.JobInfo, staged []*helpers.VolumeInfo) ([]*helpers.VolumeInfo, uint64) {
+	volumes := append(append([]*helpers.VolumeInfo{}, j.Volumes...), staged...)
+	end := 0
+	for idx, vol := range volumes {
+		if vol.IsLayout {
+			end = idx
+		}
+	}
+
+	if end < len(j.Volumes) {
+		j.Volumes = j.Volumes[:end]
+		return nil, 0
+	}
+	var streamBytes uint64
+	staged = staged[:end-len(j.Volumes)]
+	for _, vol := range staged {
+		streamBytes += vol.ZFSStreamBytes
+	}
+	return staged, streamBytes
+}
+
+// layoutWriter puts the zfs send stream of a backup set stored as chunks back together, writing it to w. The
+// volumes of the backup set are written to it in order: every layout volume, once complete, is followed by the
+// chunks it describes.
+type layoutWriter struct {
+	w       io.Writer
+	pending bytes.Buffer // The layout volume being written
+	layout  []byte       // Entries of the current layout volume not written yet
+	chunked uint64       // Bytes of the chunks to write before the next entry
+}
+
+// writeLayout will add p to the layout volume being written.
+func (l *layoutWriter) writeLayout(p []byte) {
+	l.pending.Write(p)
+}
+
+// endLayout will write the stream described by the layout volume written since it was last called.
+func (l *layoutWriter) endLayout() error {
+	if l.chunked > 0 || len(l.layout) > 0 {
+		return errors.New("a layout volume starts before the chunks of the previous one end")
+	}
+	l.layout = append([]byte(nil), l.pending.Bytes()...)
+	l.pending.Reset()
+	return l.advance()
+}
+
+// advance will write the bytes of the stream kept in the layout up to the next entry followed by chunks.
+func (l *layoutWriter) advance() error {
+	for l.chunked == 0 && len(l.layout) > 0 {
+		size, n := binary.Uvarint(l.layout)
+		if n <= 0 || size > uint64(len(l.layout)-n) {
+			return errCorruptLayout
+		}
+		kept := l.layout[n : n+int(size)]
+		chunked, m := binary.Uvarint(l.layout[n+int(size):])
+		if m <= 0 {
+			return errCorruptLayout
+		}
+		if _, err := l.w.Write(kept); err != nil {
+			return err
+		}
+		l.layout = l.layout[n+int(size)+m:]
+		l.chunked = chunked
+	}
+	return nil
+}
+
+// Write will write the data of the chunks in p where the layout puts it in the stream.
+func (l *layoutWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if l.chunked == 0 {
+			return written, errors.New("the chunks hold more data than their layout volume describes")
+		}
+		size := uint64(len(p))
+		if size > l.chunked {
+			size = l.chunked
+		}
+		n, err := l.w.Write(p[:size])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		l.chunked -= size
+		p = p[size:]
+		if err = l.advance(); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close reports whether the whole stream was put back together.
+func (l *layoutWriter) Close() error {
+	if l.chunked > 0 || len(l.layout) > 0 || l.pending.Len() > 0 {
+		return errors.New("the zfs send stream is incomplete, its layout describes chunks that are missing")
+	}
+	return nil
+}
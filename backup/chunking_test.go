@@ -0,0 +1,337 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// testRecord returns a record of a zfs send stream, its header set by set, followed by its payload.
+func testRecord(order binary.ByteOrder, typ uint32, set func(u []byte), payload []byte) []byte {
+	h := make([]byte, zfsRecordSize)
+	order.PutUint32(h, typ)
+	if set != nil {
+		set(h[8:])
+	}
+	return append(h, payload...)
+}
+
+// testStream returns a zfs send stream and the data of it that is chunked.
+func testStream(order binary.ByteOrder) ([]byte, []byte) {
+	rnd := rand.New(rand.NewSource(1))
+	payload := func(size int) []byte {
+		p := make([]byte, size)
+		rnd.Read(p)
+		return p
+	}
+	nvlist, bonus, write, compressed, embedded, spill := payload(24), payload(16), payload(5000), payload(3000), payload(16), payload(600)
+
+	var stream []byte
+	begin := testRecord(order, drrBegin, func(u []byte) { order.PutUint64(u, zfsBackupMagic) }, nvlist)
+	order.PutUint32(begin[4:], uint32(len(nvlist)))
+	stream = append(stream, begin...)
+	stream = append(stream, testRecord(order, drrObject, func(u []byte) { order.PutUint32(u[20:], 13) }, bonus)...)
+	stream = append(stream, testRecord(order, drrWrite, func(u []byte) { order.PutUint64(u[24:], 5000) }, write)...)
+	stream = append(stream, testRecord(order, drrWrite, func(u []byte) {
+		order.PutUint64(u[24:], 8192)
+		u[42] = 15
+		order.PutUint64(u[88:], 3000)
+	}, compressed)...)
+	stream = append(stream, testRecord(order, drrWriteEmbedded, func(u []byte) { order.PutUint32(u[44:], 10) }, embedded)...)
+	stream = append(stream, testRecord(order, drrFree, nil, nil)...)
+	stream = append(stream, testRecord(order, drrSpill, func(u []byte) { order.PutUint64(u[8:], 600) }, spill)...)
+	stream = append(stream, testRecord(order, drrEnd, nil, nil)...)
+
+	return stream, bytes.Join([][]byte{write, compressed, spill}, nil)
+}
+
+// splitTestStream will split the stream read from r like sendChunks does, in a single segment.
+func splitTestStream(r io.Reader, skip uint64) ([]byte, []byte, error) {
+	var (
+		layout  bytes.Buffer
+		data    []byte
+		kept    []byte
+		chunked uint64
+	)
+	splitter := &streamSplitter{r: r}
+	if err := splitter.skip(context.Background(), skip); err != nil {
+		return nil, nil, err
+	}
+	buf := make([]byte, 1000)
+	for {
+		if splitter.chunked == 0 {
+			records, err := splitter.next(context.Background())
+			if len(records) > 0 && chunked > 0 {
+				appendLayoutEntry(&layout, kept, chunked)
+				kept, chunked = nil, 0
+			}
+			kept = append(kept, records...)
+			if err == io.EOF {
+				appendLayoutEntry(&layout, kept, chunked)
+				return layout.Bytes(), data, nil
+			} else if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		n, err := splitter.read(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = append(data, buf[:n]...)
+		chunked += uint64(n)
+	}
+}
+
+func TestSplitStream(t *testing.T) {
+	little, littleData := testStream(binary.LittleEndian)
+	big, bigData := testStream(binary.BigEndian)
+	opaque := make([]byte, 10000)
+	rand.New(rand.NewSource(2)).Read(opaque)
+
+	testCases := []struct {
+		stream []byte
+		data   []byte
+	}{
+		{stream: little, data: littleData},
+		{stream: big, data: bigData},
+		// Not a zfs send stream, all but what was read as the first record is chunked
+		{stream: opaque, data: opaque[zfsRecordSize:]},
+		// Streams cut short are kept as they are
+		{stream: little[:len(little)-zfsRecordSize-100], data: littleData[:len(littleData)-100]},
+		{stream: little[:100], data: nil},
+		{stream: nil, data: nil},
+	}
+
+	for idx, testCase := range testCases {
+		layout, data, err := splitTestStream(bytes.NewReader(testCase.stream), 0)
+		if err != nil {
+			t.Errorf("%d: Unexpected error - %v", idx, err)
+			continue
+		}
+		if !bytes.Equal(data, testCase.data) {
+			t.Errorf("%d: Expected %d bytes to be chunked, got %d", idx, len(testCase.data), len(data))
+		}
+
+		var out bytes.Buffer
+		w := &layoutWriter{w: &out}
+		w.writeLayout(layout)
+		if err = w.endLayout(); err != nil {
+			t.Errorf("%d: Unexpected error - %v", idx, err)
+			continue
+		}
+		for len(data) > 0 {
+			size := 777
+			if size > len(data) {
+				size = len(data)
+			}
+			if _, err = w.Write(data[:size]); err != nil {
+				break
+			}
+			data = data[size:]
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			t.Errorf("%d: Unexpected error - %v", idx, err)
+		}
+		if !bytes.Equal(out.Bytes(), testCase.stream) {
+			t.Errorf("%d: The stream was not put back together", idx)
+		}
+	}
+}
+
+func TestStreamSplitterSkip(t *testing.T) {
+	stream, data := testStream(binary.LittleEndian)
+	// The records up to the first WRITE record, then the first 1000 bytes of its data
+	records := uint64(3*zfsRecordSize + 24 + 16)
+
+	testCases := []struct {
+		skip  uint64
+		data  []byte
+		valid errTestFunc
+	}{
+		{skip: 0, data: data, valid: nilErrTest},
+		{skip: records, data: data, valid: nilErrTest},
+		{skip: records + 1000, data: data[1000:], valid: nilErrTest},
+		{skip: records + 5000, data: data[5000:], valid: nilErrTest},
+		{skip: records - 10, valid: nonNilErrTest},
+		{skip: uint64(len(stream)) + 1, valid: nonNilErrTest},
+	}
+
+	for idx, testCase := range testCases {
+		_, data, err := splitTestStream(bytes.NewReader(stream), testCase.skip)
+		if !testCase.valid(err) {
+			t.Errorf("%d: Unexpected error - %v", idx, err)
+			continue
+		}
+		if err == nil && !bytes.Equal(data, testCase.data) {
+			t.Errorf("%d: Expected %d bytes to be chunked, got %d", idx, len(testCase.data), len(data))
+		}
+	}
+}
+
+func TestLayoutWriter(t *testing.T) {
+	entries := func(chunked ...uint64) []byte {
+		var layout bytes.Buffer
+		for _, size := range chunked {
+			appendLayoutEntry(&layout, []byte("kept"), size)
+		}
+		return layout.Bytes()
+	}
+	// Every step writes a layout volume or data of the chunks
+	type step struct {
+		layout []byte
+		data   string
+	}
+
+	testCases := []struct {
+		steps []step
+		out   string
+		valid errTestFunc
+	}{
+		{steps: []step{{layout: entries(3, 0, 2)}, {data: "abc"}, {data: "de"}}, out: "keptabckeptkeptde", valid: nilErrTest},
+		{steps: []step{{layout: entries(2)}, {data: "ab"}, {layout: entries(0)}, {layout: entries(1)}, {data: "c"}}, out: "keptabkeptkeptc", valid: nilErrTest},
+		{steps: []step{{layout: []byte{}}}, valid: nilErrTest},
+		// More data than the layout describes
+		{steps: []step{{layout: entries(2)}, {data: "abc"}}, valid: nonNilErrTest},
+		// Chunks missing
+		{steps: []step{{layout: entries(4)}, {data: "abc"}}, valid: nonNilErrTest},
+		{steps: []step{{layout: entries(2)}, {layout: entries(1)}}, valid: nonNilErrTest},
+		// Corrupt layouts
+		{steps: []step{{layout: []byte{0x10, 'a'}}}, valid: nonNilErrTest},
+		{steps: []step{{layout: []byte{0x01, 'a'}}}, valid: nonNilErrTest},
+	}
+
+	for idx, testCase := range testCases {
+		var out bytes.Buffer
+		w := &layoutWriter{w: &out}
+		var err error
+		for _, step := range testCase.steps {
+			if step.layout != nil {
+				w.writeLayout(step.layout)
+				err = w.endLayout()
+			} else {
+				_, err = w.Write([]byte(step.data))
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if !testCase.valid(err) {
+			t.Errorf("%d: Unexpected error - %v", idx, err)
+		}
+		if err == nil && out.String() != testCase.out {
+			t.Errorf("%d: Expected %q, got %q", idx, testCase.out, out.String())
+		}
+	}
+}
+
+func TestChunker(t *testing.T) {
+	const avg = 64 * 1024
+	data := make([]byte, 8*1024*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+
+	chunks := func(data []byte) map[[sha256.Size]byte]bool {
+		c := newChunker(avg)
+		found := make(map[[sha256.Size]byte]bool)
+		for len(data) > 0 {
+			// Boundaries don't depend on how the data is read
+			size, end := c.next(data[:len(data)/3+1])
+			for !end && size < len(data) {
+				var more int
+				more, end = c.next(data[size:])
+				size += more
+			}
+			if end && (size < avg/4 || size > avg*4) {
+				t.Errorf("Expected a chunk between %d and %d bytes, got %d", avg/4, avg*4, size)
+			}
+			found[sha256.Sum256(data[:size])] = true
+			data = data[size:]
+		}
+		return found
+	}
+
+	original := chunks(data)
+	if len(original) < 8*1024*1024/avg/2 || len(original) > 8*1024*1024/avg*2 {
+		t.Errorf("Expected about %d chunks, got %d", 8*1024*1024/avg, len(original))
+	}
+	// Data inserted only changes the chunks around it
+	inserted := append(append(append([]byte{}, data[:3*1024*1024]...), data[:1000]...), data[3*1024*1024:]...)
+	shared := 0
+	for sum := range chunks(inserted) {
+		if original[sum] {
+			shared++
+		}
+	}
+	if shared < len(original)-3 {
+		t.Errorf("Expected all but a few of the %d chunks to be shared, %d are", len(original), shared)
+	}
+}
+
+func TestResumeSegments(t *testing.T) {
+	layout := func(number int64) *helpers.VolumeInfo {
+		return &helpers.VolumeInfo{VolumeNumber: number, IsLayout: true, ZFSStreamBytes: 10}
+	}
+	chunk := func(number int64) *helpers.VolumeInfo {
+		return &helpers.VolumeInfo{VolumeNumber: number, ZFSStreamBytes: 100}
+	}
+
+	testCases := []struct {
+		uploaded    []*helpers.VolumeInfo
+		staged      []*helpers.VolumeInfo
+		volumes     int
+		resumed     int
+		streamBytes uint64
+	}{
+		{},
+		{uploaded: []*helpers.VolumeInfo{layout(1), chunk(2)}},
+		{uploaded: []*helpers.VolumeInfo{layout(1), chunk(2), chunk(3), layout(4), chunk(5)}, volumes: 3},
+		{uploaded: []*helpers.VolumeInfo{layout(1), chunk(2)}, staged: []*helpers.VolumeInfo{chunk(3), layout(4)}, volumes: 2, resumed: 1, streamBytes: 100},
+		{staged: []*helpers.VolumeInfo{layout(1), chunk(2), layout(3), chunk(4), layout(5)}, resumed: 4, streamBytes: 220},
+	}
+
+	for idx, testCase := range testCases {
+		j := &helpers.JobInfo{Volumes: testCase.uploaded}
+		resumed, streamBytes := resumeSegments(j, testCase.staged)
+		if len(j.Volumes) != testCase.volumes {
+			t.Errorf("%d: Expected %d uploaded volumes to be kept, got %d", idx, testCase.volumes, len(j.Volumes))
+		}
+		if len(resumed) != testCase.resumed {
+			t.Errorf("%d: Expected %d staged volumes to be resumed, got %d", idx, testCase.resumed, len(resumed))
+		}
+		if streamBytes != testCase.streamBytes {
+			t.Errorf("%d: Expected the resumed volumes to hold %d bytes, got %d", idx, testCase.streamBytes, streamBytes)
+		}
+	}
+}
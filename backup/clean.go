@@ -23,6 +23,7 @@ package backup
 import (
 	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -32,189 +33,429 @@ import (
 	"github.com/cenkalti/backoff"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/someone1/zfsbackup-go/backends"
 	"github.com/someone1/zfsbackup-go/helpers"
 )
 
+// objectWorkers is the number of objects deleted, or moved, at once. Let's not slam the endpoint with a lot of
+// concurrent requests, pick a sensible default and stick to it.
+const objectWorkers = 5
+
+// CleanPlan describes what Clean deletes in a destination and the restore points it leaves there.
+type CleanPlan struct {
+	Destination string
+	// The broken backup sets deleted with --force, and the backup sets only found in the local cache deleted with --cleanLocal.
+	BackupSets []CleanedBackupSet
+	// The manifests of the backup sets deleted along with every object no backup set that is kept references.
+	Objects []string
+	// The size of the objects deleted that are volumes of a backup set, the size of the others is not known.
+	Bytes              uint64
+	UnknownSizeObjects int
+	// When the objects deleted expire from the trash they are moved to, zero if they are deleted right away.
+	TrashedUntil time.Time
+	// The objects in the trash that expired, deleted for good.
+	Purged        []string
+	RestorePoints []VolumeRestorePoint
+
+	manifests      []string // The manifest objects of the backup sets deleted
+	localManifests []string // The paths of the manifests only found in the local cache to delete
+	cachedFiles    []string // The paths of the manifests of the backup sets deleted in the local cache
+}
+
+// CleanedBackupSet is a backup set Clean deletes and why.
+type CleanedBackupSet struct {
+	Volume      string
+	Snapshot    string
+	Incremental string `json:",omitempty"`
+	Reason      string
+
+	broken *helpers.JobInfo // The manifest of a broken backup set
+}
+
 // Clean will remove files found in the desination that are not found in any of the manifests found locally or in the destination.
 // If cleanLocal is true, then local manifests not found in the destination are ignored and deleted. This function will optionally
-// delete broken backup sets in the destination if the --force flag is provided.
-func Clean(pctx context.Context, jobInfo *helpers.JobInfo, cleanLocal bool) error {
+// delete broken backup sets in the destination if the --force flag is provided. It returns what it deleted.
+func Clean(pctx context.Context, jobInfo *helpers.JobInfo, cleanLocal bool) (*CleanPlan, error) {
 	ctx, cancel := context.WithCancel(pctx)
 	defer cancel()
 
-	// Prepare the backend client
 	target := jobInfo.Destinations[0]
-	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
-	if berr != nil {
-		helpers.AppLogger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
-		return berr
+	backend, localCachePath, err := prepareClean(ctx, jobInfo, target)
+	if err != nil {
+		return nil, err
+	}
+	defer backend.Close()
+
+	plan, err := planClean(ctx, jobInfo, backend, localCachePath, cleanLocal)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, manifestPath := range plan.localManifests {
+		err = os.Remove(manifestPath)
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not delete local manifest %s due to error - %v", manifestPath, err)
+			return nil, err
+		}
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Deleted %s.", manifestPath)
+	}
+
+	for _, set := range plan.BackupSets {
+		if set.broken != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("The following backup set is %s. Removing entire backupset:\n\n%s", set.Reason, set.broken.String())
+		}
+	}
+	jobInfo.Manifests = append(jobInfo.Manifests, plan.manifests...)
+	for _, manifestPath := range plan.cachedFiles {
+		err = os.Remove(manifestPath)
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not delete local manifest %s due to error - %v. Continuing.", manifestPath, err)
+		}
+	}
+
+	operation := "delete"
+	if plan.TrashedUntil.IsZero() {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Starting to delete %d objects in destination.", len(plan.Objects))
+	} else {
+		operation = "move to the trash"
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Starting to move %d objects in destination to the trash until %v.", len(plan.Objects), plan.TrashedUntil)
+	}
+
+	// Whatever is left in the plan was not found in any manifest, delete 'em
+	err = forEachObject(ctx, operation, plan.Objects, func(ctx context.Context, object string) error {
+		if plan.TrashedUntil.IsZero() {
+			return backend.Delete(ctx, object)
+		}
+		return moveObject(ctx, backend, object, trashObjectName(object, plan.TrashedUntil))
+	})
+	if err == nil && len(plan.Purged) > 0 {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Starting to delete %d expired objects in the trash of the destination.", len(plan.Purged))
+		err = forEachObject(ctx, "delete", plan.Purged, backend.Delete)
+	}
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not finish clean operation due to error, aborting: %v", err)
+		return nil, err
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Done.")
+	return plan, nil
+}
+
+// forEachObject will run the operation, named as provided, on every object, retrying it on failure, a few objects
+// at a time.
+func forEachObject(pctx context.Context, name string, objects []string, operation func(ctx context.Context, object string) error) error {
+	group, ctx := errgroup.WithContext(pctx)
+
+	objectChan := make(chan string, len(objects))
+	for _, obj := range objects {
+		objectChan <- obj
+	}
+	close(objectChan)
+
+	for i := 0; i < objectWorkers; i++ {
+		group.Go(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case objectPath, ok := <-objectChan:
+					if !ok {
+						return nil
+					}
+
+					be := backoff.NewExponentialBackOff()
+					be.MaxInterval = time.Minute
+					be.MaxElapsedTime = 10 * time.Minute
+					retryconf := backoff.WithContext(be, ctx)
+
+					if berr := backoff.Retry(func() error {
+						return operation(ctx, objectPath)
+					}, retryconf); berr != nil {
+						helpers.EnvironmentOf(ctx).Logger.Errorf("Could not %s object %s due to error - %v", name, objectPath, berr)
+						return helpers.NewError(helpers.ErrorKindBackend, berr)
+					}
+
+					helpers.EnvironmentOf(ctx).Logger.Debugf("Done with %s: %s.", name, objectPath)
+				}
+			}
+		})
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Waiting to %s %d objects in destination.", name, len(objects))
+	return group.Wait()
+}
+
+// PlanClean will compute what Clean would delete in the destination, and the restore points it would leave there,
+// without deleting anything.
+func PlanClean(pctx context.Context, jobInfo *helpers.JobInfo, cleanLocal bool) (*CleanPlan, error) {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	backend, localCachePath, err := prepareClean(ctx, jobInfo, jobInfo.Destinations[0])
+	if err != nil {
+		return nil, err
 	}
 	defer backend.Close()
 
+	return planClean(ctx, jobInfo, backend, localCachePath, cleanLocal)
+}
+
+// prepareClean will initialize the backend of the target and return it along with the path of its local cache.
+func prepareClean(ctx context.Context, jobInfo *helpers.JobInfo, target string) (backends.Backend, string, error) {
+	// Objects are uploaded again when moved to the trash
+	backend, berr := prepareBackend(ctx, jobInfo, target, make(chan bool, objectWorkers))
+	if berr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		return nil, "", berr
+	}
+
 	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(target)
+	localCachePath, cerr := getCacheDir(ctx, target)
 	if cerr != nil {
-		helpers.AppLogger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
-		return cerr
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		backend.Close()
+		return nil, "", cerr
+	}
+	return backend, localCachePath, nil
+}
+
+// planClean will sync the local cache with the destination and work out what cleaning it deletes.
+func planClean(ctx context.Context, jobInfo *helpers.JobInfo, backend backends.Backend, localCachePath string, cleanLocal bool) (*CleanPlan, error) {
+	target := jobInfo.Destinations[0]
+	plan := &CleanPlan{Destination: target}
+	if jobInfo.TrashFor > 0 {
+		plan.TrashedUntil = time.Now().Add(jobInfo.TrashFor).Truncate(time.Second)
 	}
 
 	// Sync the local cache
 	safeManifests, localOnlyFiles, serr := syncCache(ctx, jobInfo, localCachePath, backend)
 	if serr != nil {
-		helpers.AppLogger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
-		return serr
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
+		return nil, serr
 	}
 
 	// Read in Manifests
-	decodedManifests := make([]*helpers.JobInfo, 0, len(safeManifests))
-	for _, manifest := range safeManifests {
-		manifestPath := filepath.Join(localCachePath, manifest)
-		decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo)
-		if oerr != nil {
-			helpers.AppLogger.Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
-			return oerr
+	decodedManifests, rerr := readManifests(ctx, localCachePath, safeManifests, jobInfo)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	// The size of every volume known, including those of the backup sets deleted
+	sizes := make(map[string]uint64)
+	addSizes := func(manifest *helpers.JobInfo) {
+		for _, vol := range append(append([]*helpers.VolumeInfo{}, manifest.Volumes...), manifest.Parity...) {
+			sizes[vol.ObjectName] = vol.Size
 		}
-		decodedManifests = append(decodedManifests, decodedManifest)
+	}
+	for _, manifest := range decodedManifests {
+		addSizes(manifest)
 	}
 
+	// The manifests kept that are only found in the local cache
+	localOnly := make(map[*helpers.JobInfo]bool)
 	if !cleanLocal {
 		if len(localOnlyFiles) > 0 {
-			helpers.AppLogger.Noticef("There are %d local manifests not found in the destination, use --cleanLocal to delete these locally and any of their volumes found in the destination.", len(localOnlyFiles))
+			helpers.EnvironmentOf(ctx).Logger.Noticef("There are %d local manifests not found in the destination, use --cleanLocal to delete these locally and any of their volumes found in the destination.", len(localOnlyFiles))
 			for _, manifest := range localOnlyFiles {
 				manifestPath := filepath.Join(localCachePath, manifest)
 				decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo)
 				if oerr != nil {
-					helpers.AppLogger.Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
-					return oerr
+					helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
+					return nil, oerr
 				}
 				decodedManifests = append(decodedManifests, decodedManifest)
+				localOnly[decodedManifest] = true
 			}
 		}
 	} else {
 		for _, manifest := range localOnlyFiles {
 			manifestPath := filepath.Join(localCachePath, manifest)
-			err := os.Remove(manifestPath)
-			if err != nil {
-				helpers.AppLogger.Errorf("Could not delete local manifest %s due to error - %v", manifestPath, err)
-				return err
+			// The volumes of a backup set still within its minimum retention are kept even if its manifest is gone
+			decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo)
+			if oerr != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
+				return nil, oerr
 			}
-			helpers.AppLogger.Debugf("Deleted %s.", manifestPath)
+			if until := decodedManifest.RetainedUntil(target); time.Now().Before(until) {
+				helpers.EnvironmentOf(ctx).Logger.Warningf("The following backup set is not found in the destination but must be retained there until %v, its local manifest and volumes will not be deleted:\n\n%s", until, decodedManifest.String())
+				decodedManifests = append(decodedManifests, decodedManifest)
+				localOnly[decodedManifest] = true
+				continue
+			}
+			addSizes(decodedManifest)
+			plan.localManifests = append(plan.localManifests, manifestPath)
+			plan.BackupSets = append(plan.BackupSets, newCleanedBackupSet(decodedManifest, "not found in the destination"))
 		}
 	}
 
-	// TODO: The following can be done in a much more efficient way (probably)
-	allObjects, err := backend.List(ctx, "")
-	if err != nil {
-		helpers.AppLogger.Errorf("Could not list objects in backend %s due to error - %v", target, err)
-		return err
+	var allObjects []string
+	var err error
+	var listed time.Time
+	if jobInfo.Inventory != "" {
+		inventory, ierr := ReadInventory(ctx, jobInfo, jobInfo.Inventory)
+		if ierr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read the inventory report %s due to error - %v", jobInfo.Inventory, ierr)
+			return nil, ierr
+		}
+		if bucket := inventoryBucket(target); bucket != "" && bucket != inventory.Bucket {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("The inventory report %s lists the objects of bucket %s, not of the bucket of %s.", jobInfo.Inventory, inventory.Bucket, target)
+			return nil, helpers.NewError(helpers.ErrorKindConfig, errors.New("the inventory report is not the one of the destination"))
+		}
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Using the %d objects listed by the inventory report of %v instead of listing the objects in destination.", len(inventory.Objects), inventory.Taken)
+		allObjects, listed = inventory.Objects, inventory.Taken
+	} else {
+		// TODO: The following can be done in a much more efficient way (probably)
+		allObjects, err = backend.List(ctx, "")
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list objects in backend %s due to error - %v", target, err)
+			return nil, err
+		}
 	}
-
-	// Remove Manifest Files
+	// Remove Manifest, Lease, Canary, and Trash Files, the expired ones of the trash are purged
 	for idx := 0; idx < len(allObjects); idx++ {
-		if strings.HasPrefix(allObjects[idx], jobInfo.ManifestPrefix) {
+		trashed, inTrash := parseTrashObjectName(allObjects[idx])
+		if inTrash && time.Now().After(trashed.Expires) {
+			plan.Purged = append(plan.Purged, allObjects[idx])
+		}
+		if strings.HasPrefix(allObjects[idx], jobInfo.ManifestPrefix) || strings.HasPrefix(allObjects[idx], LeasePrefix) ||
+			strings.HasPrefix(allObjects[idx], CanaryPrefix) || inTrash {
 			allObjects = append(allObjects[:idx], allObjects[idx+1:]...)
 			idx--
 		}
 	}
 
-	// Go through all manifests and remove from the allObjects list what we know should exist
+	existing := make(map[string]bool, len(allObjects))
+	for _, obj := range allObjects {
+		existing[obj] = true
+	}
+
+	// Go through all manifests and find the broken backup sets to delete
+	var toDelete []*helpers.JobInfo
+	missing := make(map[*helpers.JobInfo]string)
+	unchecked := 0
 	for _, manifest := range decodedManifests {
-		for vidx, vol := range manifest.Volumes {
-			found := false
-			for idx := range allObjects {
-				if strings.Compare(vol.ObjectName, allObjects[idx]) == 0 {
-					allObjects = append(allObjects[:idx], allObjects[idx+1:]...)
-					found = true
-					break
-				}
+		// The volumes of a backup set finished after the inventory report was taken may be missing from it
+		if !listed.IsZero() && !manifest.EndTime.Before(listed) {
+			helpers.EnvironmentOf(ctx).Logger.Debugf("Not checking the volumes of the following backup set, it was finished after the inventory report was taken:\n\n%s", manifest.String())
+			unchecked++
+			continue
+		}
+		for _, vol := range manifest.Volumes {
+			if existing[vol.ObjectName] {
+				continue
 			}
 
-			if !found {
-				// Broken backup set! inform the user!
-				if jobInfo.Force {
-					helpers.AppLogger.Warningf("The following backup set is missing volume %s. Removing entire backupset:\n\n%s", vol.ObjectName, manifest.String())
-
-					// Compute the manifest object name and cache name to delete
-					manifest.ManifestPrefix = jobInfo.ManifestPrefix
-					manifest.SignKey = jobInfo.SignKey
-					manifest.EncryptKey = jobInfo.EncryptKey
-					tempManifest, terr := helpers.CreateManifestVolume(ctx, manifest)
-					if terr != nil {
-						helpers.AppLogger.Errorf("Could not compute manifest path due to error - %v.", terr)
-						return terr
-					}
-					allObjects = append(allObjects, tempManifest.ObjectName)
-					tempManifest.Close()
-					tempManifest.DeleteVolume()
-					manifestPath := filepath.Join(localCachePath, fmt.Sprintf("%x", md5.Sum([]byte(tempManifest.ObjectName))))
-					err = os.Remove(manifestPath)
-					if err != nil {
-						helpers.AppLogger.Errorf("Could not delete local manifest %s due to error - %v. Continuing.", manifestPath, err)
-					}
-
-					// Delete all volumes already processed in the manifest
-					for i := 0; i < vidx; i++ {
-						allObjects = append(allObjects, manifest.Volumes[i].ObjectName)
-					}
-					break
-				} else {
-					helpers.AppLogger.Warningf("The following backup set is missing volume %s:\n\n%s\n\nPass the --force flag to delete this backup set.", vol.ObjectName, manifest.String())
-				}
+			// Broken backup set! inform the user!
+			if manifest.KeepForever() {
+				helpers.EnvironmentOf(ctx).Logger.Warningf("The following backup set is missing volume %s but is tagged %s=%s and will not be deleted:\n\n%s", vol.ObjectName, helpers.KeepTag, helpers.KeepForeverValue, manifest.String())
+			} else if until := manifest.RetainedUntil(target); time.Now().Before(until) {
+				helpers.EnvironmentOf(ctx).Logger.Warningf("The following backup set is missing volume %s but must be retained until %v and will not be deleted:\n\n%s", vol.ObjectName, until, manifest.String())
+			} else if jobInfo.Force {
+				toDelete = append(toDelete, manifest)
+				missing[manifest] = vol.ObjectName
+			} else {
+				helpers.EnvironmentOf(ctx).Logger.Warningf("The following backup set is missing volume %s:\n\n%s\n\nPass the --force flag to delete this backup set.", vol.ObjectName, manifest.String())
 			}
+			break
 		}
 	}
 
-	helpers.AppLogger.Noticef("Starting to delete %d objects in destination.", len(allObjects))
-
-	// Whatever is left in allObjects was not found in any manifest, delete 'em
-	var group *errgroup.Group
-	group, ctx = errgroup.WithContext(ctx)
-
-	deleteChan := make(chan string, len(allObjects))
-	for _, obj := range allObjects {
-		deleteChan <- obj
+	if unchecked > 0 {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("The volumes of %d backup sets finished after the inventory report was taken were not checked.", unchecked)
 	}
-	close(deleteChan)
 
-	// Let's not slam the endpoint with a lot of concurrent requests, pick a sensible default and stick to it
-	for i := 0; i < 5; i++ {
-		group.Go(func() error {
-			for {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				case objectPath, ok := <-deleteChan:
-					if !ok {
-						return nil
-					}
+	// Never delete a backup set a retained restore point depends on unless told to
+	dependencies := chainDependencies(decodedManifests, toDelete)
+	deleteSets := toDelete[:0]
+	for _, manifest := range toDelete {
+		dependents := dependencies[manifest]
+		if len(dependents) == 0 {
+			deleteSets = append(deleteSets, manifest)
+			continue
+		}
 
-					be := backoff.NewExponentialBackOff()
-					be.MaxInterval = time.Minute
-					be.MaxElapsedTime = 10 * time.Minute
-					retryconf := backoff.WithContext(be, ctx)
+		names := make([]string, 0, len(dependents))
+		for _, dependent := range dependents {
+			names = append(names, restorePointName(dependent))
+		}
+		if jobInfo.ForceBreakChain {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Deleting the following backup set breaks the chain of %d restore points, these become unrestorable: %s\n\n%s", len(names), strings.Join(names, ", "), manifest.String())
+			deleteSets = append(deleteSets, manifest)
+		} else {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("The following backup set is broken but will not be deleted, %d restore points depend on it (%s). Pass the --forceBreakChain flag to delete it anyway, leaving them unrestorable.\n\n%s", len(names), strings.Join(names, ", "), manifest.String())
+		}
+	}
 
-					operation := func() error {
-						return backend.Delete(ctx, objectPath)
-					}
+	deleted := make(map[*helpers.JobInfo]bool, len(deleteSets))
+	for _, manifest := range deleteSets {
+		deleted[manifest] = true
+		set := newCleanedBackupSet(manifest, "missing volume "+missing[manifest])
+		set.broken = manifest
+		plan.BackupSets = append(plan.BackupSets, set)
 
-					if berr := backoff.Retry(operation, retryconf); berr != nil {
-						helpers.AppLogger.Errorf("Could not delete object %s in due to error - %v", objectPath, berr)
-						return berr
-					}
+		// Compute the manifest object name and cache name to delete
+		manifest.ManifestPrefix = jobInfo.ManifestPrefix
+		manifest.SignKey = jobInfo.SignKey
+		manifest.EncryptKey = jobInfo.EncryptKey
+		tempManifest, terr := helpers.CreateManifestVolume(ctx, manifest)
+		if terr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not compute manifest path due to error - %v.", terr)
+			return nil, terr
+		}
+		allObjects = append(allObjects, tempManifest.ObjectName)
+		plan.manifests = append(plan.manifests, tempManifest.ObjectName)
+		tempManifest.Close()
+		tempManifest.DeleteVolume()
+		plan.cachedFiles = append(plan.cachedFiles, filepath.Join(localCachePath, fmt.Sprintf("%x", md5.Sum([]byte(tempManifest.ObjectName)))))
+	}
 
-					helpers.AppLogger.Debugf("Deleted %s.", filepath.Join(target, objectPath))
-				}
+	// Remove from the allObjects list what we know should exist, the volumes of deleted backup sets are left in it
+	// unless, stored by their content, a backup set that is kept references them as well
+	kept := make([]*helpers.JobInfo, 0, len(decodedManifests))
+	for _, manifest := range decodedManifests {
+		if !deleted[manifest] {
+			kept = append(kept, manifest)
+		}
+	}
+	references := contentReferences(kept)
+	for idx := 0; idx < len(allObjects); idx++ {
+		if count := references[allObjects[idx]]; count > 0 {
+			if count > 1 {
+				helpers.EnvironmentOf(ctx).Logger.Debugf("Keeping %s, referenced by %d backup sets.", allObjects[idx], count)
 			}
-		})
+			allObjects = append(allObjects[:idx], allObjects[idx+1:]...)
+			idx--
+		}
 	}
 
-	helpers.AppLogger.Debugf("Waiting to delete %d objects in destination.", len(allObjects))
-	err = group.Wait()
-	if err != nil {
-		helpers.AppLogger.Errorf("Could not finish clean operation due to error, aborting: %v", err)
-		return err
+	plan.Objects = allObjects
+	for _, obj := range allObjects {
+		if size, ok := sizes[obj]; ok {
+			plan.Bytes += size
+		} else {
+			plan.UnknownSizeObjects++
+		}
+	}
+
+	// The restore points left are those of the backup sets kept that are found in the destination
+	remaining := make([]*helpers.JobInfo, 0, len(kept))
+	for _, manifest := range kept {
+		if !localOnly[manifest] {
+			remaining = append(remaining, manifest)
+		}
 	}
+	sortManifests(remaining)
+	linkManifests(ctx, remaining)
+	plan.RestorePoints = restorePoints(remaining)
+	return plan, nil
+}
 
-	helpers.AppLogger.Noticef("Done.")
-	return nil
+// newCleanedBackupSet will describe the backup set manifest deleted for the reason provided.
+func newCleanedBackupSet(manifest *helpers.JobInfo, reason string) CleanedBackupSet {
+	return CleanedBackupSet{
+		Volume:      manifest.VolumeName,
+		Snapshot:    manifest.BaseSnapshot.Name,
+		Incremental: manifest.IncrementalSnapshot.Name,
+		Reason:      reason,
+	}
 }
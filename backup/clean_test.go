@@ -0,0 +1,207 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestCleanMinRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupclean")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = filepath.Join(dir, "work")
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	testCases := []struct {
+		retention string
+		started   time.Time
+		deleted   bool
+	}{
+		{"", time.Now(), true},
+		{"30d", time.Now(), false},
+		{"file=30d", time.Now(), false},
+		{"s3=30d", time.Now(), true},
+		{"30d", time.Now().Add(-31 * 24 * time.Hour), true},
+	}
+
+	for idx, c := range testCases {
+		target := filepath.Join(dir, "target")
+		if err = os.MkdirAll(target, 0700); err != nil {
+			t.Fatalf("could not create target: %v", err)
+		}
+		retention, perr := helpers.ParseMinRetention(c.retention)
+		if perr != nil {
+			t.Fatalf("%d: could not parse the minimum retention: %v", idx, perr)
+		}
+
+		// A backup set missing its only volume, only kept by its minimum retention
+		manifest := &helpers.JobInfo{
+			VolumeName:     "tank",
+			BaseSnapshot:   helpers.SnapshotInfo{Name: "snap1"},
+			StartTime:      c.started,
+			MinRetention:   retention,
+			Separator:      "|",
+			ManifestPrefix: "manifests",
+			Destinations:   []string{"file://" + target},
+			Volumes:        []*helpers.VolumeInfo{{ObjectName: "tank|snap1.zstream.gz.vol1", VolumeNumber: 1}},
+		}
+		if _, err = getCacheDir(context.Background(), manifest.Destinations[0]); err != nil {
+			t.Fatalf("%d: could not create the cache: %v", idx, err)
+		}
+		vol, serr := saveManifest(context.Background(), manifest, true)
+		if serr != nil {
+			t.Fatalf("%d: could not save the manifest: %v", idx, serr)
+		}
+		manifestPath := filepath.Join(target, vol.ObjectName)
+		if err = vol.CopyTo(manifestPath); err != nil {
+			t.Fatalf("%d: could not store the manifest: %v", idx, err)
+		}
+		vol.DeleteVolume()
+
+		j := &helpers.JobInfo{ManifestPrefix: "manifests", Destinations: manifest.Destinations, Force: true}
+		if _, err = Clean(context.Background(), j, false); err != nil {
+			t.Errorf("%d: unexpected error %v", idx, err)
+		}
+		if _, err = os.Stat(manifestPath); os.IsNotExist(err) != c.deleted {
+			t.Errorf("%d: expected the backup set to be deleted %v, got %v", idx, c.deleted, os.IsNotExist(err))
+		}
+		os.RemoveAll(target)
+	}
+}
+
+func TestPlanClean(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupplanclean")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = filepath.Join(dir, "work")
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	target := filepath.Join(dir, "target")
+	if err = os.MkdirAll(target, 0700); err != nil {
+		t.Fatalf("could not create target: %v", err)
+	}
+	destination := "file://" + target
+	if _, err = getCacheDir(context.Background(), destination); err != nil {
+		t.Fatalf("could not create the cache: %v", err)
+	}
+
+	created := time.Now().Add(-time.Hour)
+	snapshot := func(name string, idx int) helpers.SnapshotInfo {
+		return helpers.SnapshotInfo{Name: name, CreationTime: created.Add(time.Duration(idx) * time.Minute)}
+	}
+	volume := func(name string, size uint64, stored bool) *helpers.VolumeInfo {
+		if stored {
+			if werr := ioutil.WriteFile(filepath.Join(target, name), []byte("volume"), 0600); werr != nil {
+				t.Fatalf("could not store volume %s: %v", name, werr)
+			}
+		}
+		return &helpers.VolumeInfo{ObjectName: name, VolumeNumber: 1, Size: size}
+	}
+	manifests := []*helpers.JobInfo{
+		{BaseSnapshot: snapshot("snap1", 1), Volumes: []*helpers.VolumeInfo{volume("tank|snap1.vol1", 10, true)}},
+		{BaseSnapshot: snapshot("snap2", 2), IncrementalSnapshot: snapshot("snap1", 1), Volumes: []*helpers.VolumeInfo{volume("tank|snap1|to|snap2.vol1", 20, true)}},
+		// A broken backup set, missing its second volume
+		{BaseSnapshot: snapshot("snap3", 3), Volumes: []*helpers.VolumeInfo{volume("tank|snap3.vol1", 100, true), volume("tank|snap3.vol2", 200, false)}},
+		// A broken backup set that must be kept anyway
+		{BaseSnapshot: snapshot("snap4", 4), Tags: map[string]string{helpers.KeepTag: helpers.KeepForeverValue}, Volumes: []*helpers.VolumeInfo{volume("tank|snap4.vol1", 300, true), volume("tank|snap4.vol2", 400, false)}},
+	}
+	kept := []string{"tank|snap4.vol1"}
+	for _, manifest := range manifests {
+		manifest.VolumeName = "tank"
+		manifest.StartTime = created
+		manifest.Separator = "|"
+		manifest.ManifestPrefix = "manifests"
+		manifest.Destinations = []string{destination}
+		vol, serr := saveManifest(context.Background(), manifest, true)
+		if serr != nil {
+			t.Fatalf("could not save the manifest: %v", serr)
+		}
+		if err = vol.CopyTo(filepath.Join(target, vol.ObjectName)); err != nil {
+			t.Fatalf("could not store the manifest: %v", err)
+		}
+		if manifest.KeepForever() {
+			kept = append(kept, vol.ObjectName)
+		}
+		vol.DeleteVolume()
+	}
+	if err = ioutil.WriteFile(filepath.Join(target, "orphan"), []byte("orphan"), 0600); err != nil {
+		t.Fatalf("could not store the orphan object: %v", err)
+	}
+
+	j := &helpers.JobInfo{ManifestPrefix: "manifests", Destinations: []string{destination}, Force: true}
+	plan, err := PlanClean(context.Background(), j, false)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(plan.BackupSets) != 1 || plan.BackupSets[0].Snapshot != "snap3" {
+		t.Errorf("expected the backup set of snap3 to be deleted, got %+v", plan.BackupSets)
+	}
+	// The stored volume of snap3, its manifest, and the orphan object
+	if len(plan.Objects) != 3 {
+		t.Errorf("expected 3 objects to be deleted, got %v", plan.Objects)
+	}
+	if plan.Bytes != 100 || plan.UnknownSizeObjects != 2 {
+		t.Errorf("expected 100 bytes and 2 objects of unknown size to be reclaimed, got %d and %d", plan.Bytes, plan.UnknownSizeObjects)
+	}
+	if len(plan.RestorePoints) != 3 || plan.RestorePoints[0].Snapshot.Name != "snap1" || plan.RestorePoints[1].Depth != 2 || plan.RestorePoints[2].Snapshot.Name != "snap4" {
+		t.Errorf("expected the restore points of snap1, snap2 and snap4 to be left, got %+v", plan.RestorePoints)
+	}
+	for _, object := range plan.Objects {
+		if _, serr := os.Stat(filepath.Join(target, object)); serr != nil {
+			t.Errorf("expected %s to be left by the dry run, got %v", object, serr)
+		}
+	}
+
+	cleaned, err := Clean(context.Background(), j, false)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(cleaned.Objects) != len(plan.Objects) {
+		t.Errorf("expected clean to delete the %d objects planned, got %v", len(plan.Objects), cleaned.Objects)
+	}
+	for _, object := range plan.Objects {
+		if _, serr := os.Stat(filepath.Join(target, object)); !os.IsNotExist(serr) {
+			t.Errorf("expected %s to be deleted, got %v", object, serr)
+		}
+	}
+	for _, object := range kept {
+		if _, serr := os.Stat(filepath.Join(target, object)); serr != nil {
+			t.Errorf("expected %s of the backup set tagged keep=forever to be left, got %v", object, serr)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// reverseCodec "compresses" by flipping every bit, counting how many volumes it compressed.
+type reverseCodec struct {
+	compressed int
+}
+
+type flipWriter struct{ w io.Writer }
+
+func (f flipWriter) Write(p []byte) (int, error) {
+	flipped := make([]byte, len(p))
+	for idx := range p {
+		flipped[idx] = ^p[idx]
+	}
+	return f.w.Write(flipped)
+}
+
+func (flipWriter) Close() error { return nil }
+
+type flipReader struct{ r io.Reader }
+
+func (f flipReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	for idx := range p[:n] {
+		p[idx] = ^p[idx]
+	}
+	return n, err
+}
+
+func (flipReader) Close() error { return nil }
+
+func (r *reverseCodec) Compress(_ context.Context, w io.Writer, _ helpers.CodecOptions) (io.WriteCloser, error) {
+	r.compressed++
+	return flipWriter{w}, nil
+}
+
+func (r *reverseCodec) Decompress(_ context.Context, rd io.Reader) (io.ReadCloser, error) {
+	return flipReader{rd}, nil
+}
+
+func (r *reverseCodec) Extension() string { return "flip" }
+
+func TestParseExecCodec(t *testing.T) {
+	testCases := []struct {
+		definition string
+		name       string
+		compress   []string
+		decompress []string
+		errTest    errTestFunc
+	}{
+		{"zstd=zstd -c -T0 -{level};zstd -d -c", "zstd", []string{"zstd", "-c", "-T0", "-{level}"}, []string{"zstd", "-d", "-c"}, nilErrTest},
+		{" lz4 = lz4 -{level} ; lz4 -d ", "lz4", []string{"lz4", "-{level}"}, []string{"lz4", "-d"}, nilErrTest},
+		{"zstd", "", nil, nil, nonNilErrTest},
+		{"zstd=zstd -c", "", nil, nil, nonNilErrTest},
+		{"zstd=;zstd -d", "", nil, nil, nonNilErrTest},
+		{"zfs=zstd -c;zstd -d", "", nil, nil, nonNilErrTest},
+		{"internal=zstd -c;zstd -d", "", nil, nil, nonNilErrTest},
+		{"zs/td=zstd -c;zstd -d", "", nil, nil, nonNilErrTest},
+	}
+
+	for idx, c := range testCases {
+		name, codec, err := helpers.ParseExecCodec(c.definition)
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if name != c.name || codec.Extension() != c.name {
+			t.Errorf("%d: expected the codec %s, got %s with the extension %s", idx, c.name, name, codec.Extension())
+		}
+		if strings.Join(codec.CompressCommand, " ") != strings.Join(c.compress, " ") || strings.Join(codec.DecompressCommand, " ") != strings.Join(c.decompress, " ") {
+			t.Errorf("%d: expected the commands %v and %v, got %v and %v", idx, c.compress, c.decompress, codec.CompressCommand, codec.DecompressCommand)
+		}
+	}
+}
+
+func TestCodecVolumes(t *testing.T) {
+	ctx := context.Background()
+
+	reverse := &reverseCodec{}
+	if err := helpers.RegisterCodec("testreverse", reverse); err != nil {
+		t.Fatalf("could not register codec: %v", err)
+	}
+	name, gzipCodec, err := helpers.ParseExecCodec("testgzip=gzip -c -{level};gzip -d -c")
+	if err != nil {
+		t.Fatalf("could not parse codec: %v", err)
+	}
+	if err = helpers.RegisterCodec(name, gzipCodec); err != nil {
+		t.Fatalf("could not register codec: %v", err)
+	}
+	if err = helpers.RegisterCodec(helpers.ZfsCompressor, reverse); err == nil {
+		t.Errorf("expected an error registering a codec as %s, got none", helpers.ZfsCompressor)
+	}
+
+	payload := make([]byte, 3*extractChunkSize)
+	if _, err = rand.Read(payload); err != nil {
+		t.Fatalf("could not generate data: %v", err)
+	}
+
+	testCases := []struct {
+		compressor string
+		extension  string
+	}{
+		{helpers.InternalCompressor, ".gz.vol1"},
+		{"testreverse", ".flip.vol1"},
+		{"testgzip", ".testgzip.vol1"},
+	}
+
+	for _, c := range testCases {
+		j := &helpers.JobInfo{
+			VolumeName:       "pool/fs",
+			BaseSnapshot:     helpers.SnapshotInfo{Name: "snap"},
+			Separator:        "|",
+			Compressor:       c.compressor,
+			CompressionLevel: 6,
+			MaxFileBuffer:    1,
+		}
+		vol, verr := helpers.CreateBackupVolume(ctx, j, 1)
+		if verr != nil {
+			t.Fatalf("%s: could not create volume: %v", c.compressor, verr)
+		}
+		if !strings.HasSuffix(vol.ObjectName, c.extension) {
+			t.Errorf("%s: expected the volume name %s to end with %s", c.compressor, vol.ObjectName, c.extension)
+		}
+		if _, err = io.Copy(vol, bytes.NewReader(payload)); err != nil {
+			t.Fatalf("%s: could not write volume: %v", c.compressor, err)
+		}
+		if err = vol.Close(); err != nil {
+			t.Fatalf("%s: could not close volume: %v", c.compressor, err)
+		}
+
+		buffer := make(chan interface{}, 1)
+		buffer <- nil
+		volumes := make(chan *helpers.VolumeInfo, 1)
+		volumes <- vol
+		close(volumes)
+		var out bytes.Buffer
+		if err = extractVolumes(ctx, &out, j, volumes, buffer); err != nil || !bytes.Equal(out.Bytes(), payload) {
+			t.Errorf("%s: expected the volume to extract, got %d bytes (%v)", c.compressor, out.Len(), err)
+		}
+		vol.DeleteVolume()
+	}
+
+	if reverse.compressed != 1 {
+		t.Errorf("expected the registered codec to compress 1 volume, got %d", reverse.compressed)
+	}
+
+	j := &helpers.JobInfo{Compressor: "zfsbackup-no-such-codec"}
+	vol, err := helpers.CreateSimpleVolume(ctx, false)
+	if err != nil {
+		t.Fatalf("could not create volume: %v", err)
+	}
+	defer vol.DeleteVolume()
+	if err = vol.Close(); err != nil {
+		t.Fatalf("could not close volume: %v", err)
+	}
+	if err = vol.Extract(ctx, j, false); err == nil {
+		t.Errorf("expected an error extracting a volume compressed with an unknown codec, got none")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// ErrAlreadyFull is returned when consolidating a snapshot whose backup set is a full backup already.
+var ErrAlreadyFull = errors.New("the snapshot already has a full backup")
+
+// Consolidate will restore the chain of backup sets of the snapshot described by jobInfo, from its first
+// destination, into the scratch volume and send a full backup of the restored snapshot to the destinations.
+// Since the restored snapshot keeps its guid and creation time, the new full backup set replaces the chain
+// it was consolidated from: the incremental backup sets after it link to it and the older backup sets are no
+// longer needed to restore them. The scratch volume must not exist and is destroyed once done unless keepScratch.
+func Consolidate(pctx context.Context, jobInfo *helpers.JobInfo, scratch string, keepScratch bool) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	chain, err := consolidationChain(ctx, jobInfo)
+	if err != nil {
+		return err
+	}
+
+	if _, err = helpers.GetZFSProperty(ctx, "name", scratch); err == nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("The scratch volume %s already exists, it must not exist as it is destroyed once done.", scratch)
+		return fmt.Errorf("scratch volume %s already exists", scratch)
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Consolidating %d backup sets of %s up to %s into a full backup using the scratch volume %s.", len(chain), jobInfo.VolumeName, jobInfo.BaseSnapshot.Name, scratch)
+
+	// Restore the chain into the scratch volume
+	restoreJob := *jobInfo
+	restoreJob.IncrementalSnapshot = helpers.SnapshotInfo{}
+	restoreJob.Destinations = jobInfo.Destinations[:1]
+	restoreJob.LocalVolume = scratch
+	restoreJob.FullPath, restoreJob.LastPath, restoreJob.Origin, restoreJob.ToFile = false, false, "", ""
+	restoreJob.Force = true
+	restoreJob.NotMounted = true
+	err = AutoRestore(ctx, &restoreJob)
+	if err == nil {
+		// Send the restored snapshot as a full backup of the original volume
+		sendJob := *jobInfo
+		sendJob.BaseSnapshot = chain[len(chain)-1].BaseSnapshot
+		sendJob.IncrementalSnapshot = helpers.SnapshotInfo{}
+		sendJob.IntermediaryIncremental = false
+		sendJob.SendVolume = scratch
+		sendJob.Volumes = nil
+		err = Backup(ctx, &sendJob)
+		jobInfo.Volumes = sendJob.Volumes
+		jobInfo.ManifestPath = sendJob.ManifestPath
+	}
+
+	if keepScratch {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Keeping the scratch volume %s.", scratch)
+	} else if derr := helpers.DestroyZFSVolume(helpers.DetachedContext(ctx), scratch); derr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not destroy the scratch volume %s due to error - %v", scratch, derr)
+		if err == nil {
+			err = derr
+		}
+	}
+
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not consolidate %s@%s due to error - %v", jobInfo.VolumeName, jobInfo.BaseSnapshot.Name, err)
+		return err
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Consolidated %d backup sets of %s into a full backup of %s.", len(chain), jobInfo.VolumeName, jobInfo.BaseSnapshot.Name)
+	return nil
+}
+
+// consolidationChain will return the backup sets, oldest first, a restore of the snapshot described by
+// jobInfo needs from its first destination.
+func consolidationChain(ctx context.Context, jobInfo *helpers.JobInfo) ([]*helpers.JobInfo, error) {
+	sets, err := ListBackupSets(ctx, jobInfo, jobInfo.VolumeName, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := chainTo(linkManifests(ctx, sets)[jobInfo.VolumeName], jobInfo.BaseSnapshot.Name)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot consolidate %s@%s from %s: %v", jobInfo.VolumeName, jobInfo.BaseSnapshot.Name, jobInfo.Destinations[0], err)
+		return nil, err
+	}
+	return chain, nil
+}
+
+// chainTo will return the linked backup sets, oldest first, from the full backup set to the incremental
+// backup set of the snapshot provided.
+func chainTo(sets []*helpers.JobInfo, snapshot string) ([]*helpers.JobInfo, error) {
+	var chain []*helpers.JobInfo
+	for _, set := range sets {
+		if strings.Compare(set.BaseSnapshot.Name, snapshot) != 0 {
+			continue
+		}
+		if set.IncrementalSnapshot.Name == "" {
+			return nil, ErrAlreadyFull
+		}
+		if chain == nil {
+			for parent := set; parent != nil; parent = parent.ParentSnap {
+				chain = append([]*helpers.JobInfo{parent}, chain...)
+			}
+		}
+	}
+
+	if chain == nil {
+		return nil, errors.New("could not find snapshot provided")
+	}
+
+	if chain[0].IncrementalSnapshot.Name != "" {
+		return nil, fmt.Errorf("could not find the parent snapshot %s of %s", chain[0].IncrementalSnapshot.Name, chain[0].BaseSnapshot.Name)
+	}
+
+	if chain[0].External != "" {
+		return nil, fmt.Errorf("the full backup set of %s was adopted from %s and has no volumes", chain[0].BaseSnapshot.Name, chain[0].External)
+	}
+
+	return chain, nil
+}
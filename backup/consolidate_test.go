@@ -0,0 +1,66 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestChainTo(t *testing.T) {
+	full := &helpers.JobInfo{BaseSnapshot: helpers.SnapshotInfo{Name: "snap1"}}
+	incr := &helpers.JobInfo{BaseSnapshot: helpers.SnapshotInfo{Name: "snap2"}, IncrementalSnapshot: helpers.SnapshotInfo{Name: "snap1"}, ParentSnap: full}
+	incr2 := &helpers.JobInfo{BaseSnapshot: helpers.SnapshotInfo{Name: "snap3"}, IncrementalSnapshot: helpers.SnapshotInfo{Name: "snap2"}, ParentSnap: incr}
+	broken := &helpers.JobInfo{BaseSnapshot: helpers.SnapshotInfo{Name: "snap4"}, IncrementalSnapshot: helpers.SnapshotInfo{Name: "snap0"}}
+	consolidated := &helpers.JobInfo{BaseSnapshot: helpers.SnapshotInfo{Name: "snap2"}}
+
+	testCases := []struct {
+		sets     []*helpers.JobInfo
+		snapshot string
+		expected []*helpers.JobInfo
+		errTest  func(error) bool
+	}{
+		{[]*helpers.JobInfo{full, incr, incr2}, "snap3", []*helpers.JobInfo{full, incr, incr2}, nilErrTest},
+		{[]*helpers.JobInfo{full, incr, incr2}, "snap2", []*helpers.JobInfo{full, incr}, nilErrTest},
+		{[]*helpers.JobInfo{full, incr, incr2}, "snap1", nil, func(e error) bool { return e == ErrAlreadyFull }},
+		{[]*helpers.JobInfo{full, incr, consolidated}, "snap2", nil, func(e error) bool { return e == ErrAlreadyFull }},
+		{[]*helpers.JobInfo{full, incr}, "snap5", nil, nonNilErrTest},
+		{[]*helpers.JobInfo{full, broken}, "snap4", nil, nonNilErrTest},
+	}
+
+	for idx, c := range testCases {
+		chain, err := chainTo(c.sets, c.snapshot)
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if len(chain) != len(c.expected) {
+			t.Errorf("%d: expected %d backup sets, got %d", idx, len(c.expected), len(chain))
+			continue
+		}
+		for i := range chain {
+			if chain[i] != c.expected[i] {
+				t.Errorf("%d: expected %s at %d, got %s", idx, c.expected[i].BaseSnapshot.Name, i, chain[i].BaseSnapshot.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// contentStored reports whether the volume, named by its content, is already stored in the backend by a
+// previous upload of this or another backup set.
+func contentStored(ctx context.Context, b backends.Backend, vol *helpers.VolumeInfo) (bool, error) {
+	objects, err := b.List(ctx, vol.ObjectName)
+	if err != nil {
+		return false, err
+	}
+	for _, object := range objects {
+		if object == vol.ObjectName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// contentReferences counts, for every volume and parity volume of the manifests provided, the backup sets
+// referencing it. Only volumes stored by their content are referenced by more than one backup set.
+func contentReferences(manifests []*helpers.JobInfo) map[string]int {
+	references := make(map[string]int)
+	for _, manifest := range manifests {
+		seen := make(map[string]bool, len(manifest.Volumes))
+		for _, vol := range append(append([]*helpers.VolumeInfo{}, manifest.Volumes...), manifest.Parity...) {
+			// A backup set may hold the same content twice, it still references it once
+			if seen[vol.ObjectName] {
+				continue
+			}
+			seen[vol.ObjectName] = true
+			references[vol.ObjectName]++
+		}
+	}
+	return references
+}
@@ -0,0 +1,107 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// listBackend lists the same objects whatever the prefix, like a backend where every object shares it
+type listBackend struct {
+	mockBackend
+	objects []string
+	err     error
+}
+
+func (l *listBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return l.objects, l.err
+}
+
+func TestContentStored(t *testing.T) {
+	vol := &helpers.VolumeInfo{ObjectName: "content|abc.zstream.gz"}
+
+	testCases := []struct {
+		objects []string
+		err     error
+		stored  bool
+		valid   errTestFunc
+	}{
+		{objects: nil, stored: false, valid: nilErrTest},
+		{objects: []string{"content|abc.zstream.gz"}, stored: true, valid: nilErrTest},
+		{objects: []string{"content|abc.zstream.gz.pgp", "content|abcd.zstream.gz"}, stored: false, valid: nilErrTest},
+		{err: errTest, stored: false, valid: nonNilErrTest},
+	}
+
+	for idx, testCase := range testCases {
+		stored, err := contentStored(context.Background(), &listBackend{objects: testCase.objects, err: testCase.err}, vol)
+		if !testCase.valid(err) {
+			t.Errorf("%d: Unexpected error - %v", idx, err)
+		}
+		if stored != testCase.stored {
+			t.Errorf("%d: Expected stored to be %v, got %v", idx, testCase.stored, stored)
+		}
+	}
+}
+
+func TestContentReferences(t *testing.T) {
+	manifest := func(names ...string) *helpers.JobInfo {
+		j := &helpers.JobInfo{}
+		for _, name := range names {
+			j.Volumes = append(j.Volumes, &helpers.VolumeInfo{ObjectName: name})
+		}
+		return j
+	}
+
+	testCases := []struct {
+		manifests  []*helpers.JobInfo
+		references map[string]int
+	}{
+		{manifests: nil, references: map[string]int{}},
+		{
+			manifests:  []*helpers.JobInfo{manifest("set1.vol1", "set1.vol2")},
+			references: map[string]int{"set1.vol1": 1, "set1.vol2": 1},
+		},
+		{
+			manifests:  []*helpers.JobInfo{manifest("content|a", "content|b"), manifest("content|a", "content|c")},
+			references: map[string]int{"content|a": 2, "content|b": 1, "content|c": 1},
+		},
+		{
+			manifests:  []*helpers.JobInfo{manifest("content|a", "content|a"), manifest("content|a")},
+			references: map[string]int{"content|a": 2},
+		},
+	}
+
+	for idx, testCase := range testCases {
+		references := contentReferences(testCase.manifests)
+		if len(references) != len(testCase.references) {
+			t.Errorf("%d: Expected %d referenced volumes, got %d (%v)", idx, len(testCase.references), len(references), references)
+			continue
+		}
+		for name, count := range testCase.references {
+			if references[name] != count {
+				t.Errorf("%d: Expected %s to be referenced %d times, got %d", idx, name, count, references[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// topDirectoriesLimit is the number of directories with the most changes reported by a diff
+const topDirectoriesLimit = 10
+
+// RestorePoint summarizes the backup sets that must be restored, oldest first, to reach a snapshot.
+type RestorePoint struct {
+	Snapshot    helpers.SnapshotInfo
+	BackupSets  []*helpers.JobInfo `json:"-"`
+	Volumes     int
+	StoredBytes uint64
+	StreamBytes uint64
+}
+
+// DirectoryChanges is the number of files changed directly under a directory.
+type DirectoryChanges struct {
+	Path    string
+	Changes int
+}
+
+// SnapshotDiff describes the difference between two restore points of a volume: the backup sets
+// only one of them depends on and, when both snapshots are found locally, the files changed between them.
+type SnapshotDiff struct {
+	VolumeName string
+	From       RestorePoint
+	To         RestorePoint
+	// Shared is the number of backup sets both restore points depend on
+	Shared   int
+	OnlyFrom []*helpers.JobInfo
+	OnlyTo   []*helpers.JobInfo
+	// ChangeCounts is keyed by the change types reported by zfs diff (-, +, M, R)
+	ChangeCounts   map[string]int     `json:",omitempty"`
+	TopDirectories []DirectoryChanges `json:",omitempty"`
+}
+
+// Diff will compare the restore points of the snapshots from and to of the volume described by jobInfo
+// found in the target destination and output the difference.
+func Diff(pctx context.Context, jobInfo *helpers.JobInfo, from, to string) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	diff, err := DiffSnapshots(ctx, jobInfo, from, to)
+	if err != nil {
+		return err
+	}
+
+	if helpers.EnvironmentOf(ctx).JSONOutput {
+		j, jerr := json.Marshal(diff)
+		if jerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("could not marshal results to JSON - %v", jerr)
+			return jerr
+		}
+
+		fmt.Fprintln(helpers.EnvironmentOf(ctx).Stdout, string(j))
+		return nil
+	}
+
+	fmt.Fprintln(helpers.EnvironmentOf(ctx).Stdout, diff.String())
+	return nil
+}
+
+// DiffSnapshots will compare the restore points of the snapshots from and to of the volume described by
+// jobInfo found in the target destination. The files changed between the snapshots are only reported
+// when both snapshots are found locally.
+func DiffSnapshots(ctx context.Context, jobInfo *helpers.JobInfo, from, to string) (*SnapshotDiff, error) {
+	archive, err := OpenArchive(ctx, jobInfo)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	sets, err := archive.BackupSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	volumeSets, ok := linkManifests(ctx, sets)[jobInfo.VolumeName]
+	if !ok {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not find any backup sets for volume %s on target.", jobInfo.VolumeName)
+		return nil, fmt.Errorf("no backup sets found for volume %s", jobInfo.VolumeName)
+	}
+
+	fromPoint, err := restorePoint(volumeSets, from)
+	if err != nil {
+		return nil, err
+	}
+	toPoint, err := restorePoint(volumeSets, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := newSnapshotDiff(jobInfo.VolumeName, fromPoint, toPoint)
+
+	older, newer := fromPoint.Snapshot, toPoint.Snapshot
+	if newer.CreationTime.Before(older.CreationTime) {
+		older, newer = newer, older
+	}
+	changes, cerr := helpers.GetZFSDiff(ctx, fmt.Sprintf("%s@%s", jobInfo.VolumeName, older.Name), fmt.Sprintf("%s@%s", jobInfo.VolumeName, newer.Name))
+	if cerr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Infof("Not reporting the files changed between the snapshots, zfs diff failed - %v", cerr)
+	} else {
+		diff.ChangeCounts, diff.TopDirectories = summarizeChanges(changes, topDirectoriesLimit)
+	}
+
+	return diff, nil
+}
+
+// restorePoint will return the backup sets, oldest first, that must be restored to reach the snapshot
+// from the backup sets of a volume linked by linkManifests.
+func restorePoint(sets []*helpers.JobInfo, snapshot string) (RestorePoint, error) {
+	var set *helpers.JobInfo
+	for _, s := range sets {
+		if s.BaseSnapshot.Name == snapshot {
+			set = s
+			break
+		}
+	}
+	if set == nil {
+		return RestorePoint{}, fmt.Errorf("could not find a backup set of snapshot %s", snapshot)
+	}
+
+	var chain []*helpers.JobInfo
+	for ; set != nil; set = set.ParentSnap {
+		chain = append([]*helpers.JobInfo{set}, chain...)
+		if set.IncrementalSnapshot.Name != "" && set.ParentSnap == nil {
+			return RestorePoint{}, fmt.Errorf("the chain of snapshot %s is broken, could not find a backup set of snapshot %s", snapshot, set.IncrementalSnapshot.Name)
+		}
+	}
+	return newRestorePoint(chain), nil
+}
+
+// newRestorePoint will summarize the chain of backup sets provided, oldest first, restoring its last snapshot.
+func newRestorePoint(chain []*helpers.JobInfo) RestorePoint {
+	point := RestorePoint{Snapshot: chain[len(chain)-1].BaseSnapshot, BackupSets: chain}
+	for _, set := range chain {
+		point.Volumes += len(set.Volumes)
+		point.StoredBytes += set.TotalBytesWritten()
+		point.StreamBytes += set.ZFSStreamBytes
+	}
+	return point
+}
+
+func newSnapshotDiff(volumeName string, from, to RestorePoint) *SnapshotDiff {
+	diff := &SnapshotDiff{VolumeName: volumeName, From: from, To: to}
+
+	inFrom := make(map[*helpers.JobInfo]bool, len(from.BackupSets))
+	for _, set := range from.BackupSets {
+		inFrom[set] = true
+	}
+
+	inTo := make(map[*helpers.JobInfo]bool, len(to.BackupSets))
+	for _, set := range to.BackupSets {
+		inTo[set] = true
+		if inFrom[set] {
+			diff.Shared++
+		} else {
+			diff.OnlyTo = append(diff.OnlyTo, set)
+		}
+	}
+
+	for _, set := range from.BackupSets {
+		if !inTo[set] {
+			diff.OnlyFrom = append(diff.OnlyFrom, set)
+		}
+	}
+	return diff
+}
+
+// summarizeChanges will count the changes by type and return the limit directories with the most changes.
+func summarizeChanges(changes []helpers.ZFSChange, limit int) (map[string]int, []DirectoryChanges) {
+	counts := make(map[string]int)
+	byDirectory := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Type]++
+		byDirectory[path.Dir(change.Path)]++
+	}
+
+	directories := make([]DirectoryChanges, 0, len(byDirectory))
+	for dir, count := range byDirectory {
+		directories = append(directories, DirectoryChanges{Path: dir, Changes: count})
+	}
+	sort.Slice(directories, func(i, j int) bool {
+		if directories[i].Changes == directories[j].Changes {
+			return directories[i].Path < directories[j].Path
+		}
+		return directories[i].Changes > directories[j].Changes
+	})
+	if len(directories) > limit {
+		directories = directories[:limit]
+	}
+	return counts, directories
+}
+
+func (d *SnapshotDiff) String() string {
+	var output []string
+	output = append(output, fmt.Sprintf("Difference between %s@%s and %s@%s:\n", d.VolumeName, d.From.Snapshot.Name, d.VolumeName, d.To.Snapshot.Name))
+	output = append(output, d.From.describe(d.VolumeName), d.To.describe(d.VolumeName))
+	output = append(output, fmt.Sprintf("Backup sets both depend on: %d\n", d.Shared))
+
+	for _, side := range []struct {
+		snapshot string
+		sets     []*helpers.JobInfo
+	}{{d.From.Snapshot.Name, d.OnlyFrom}, {d.To.Snapshot.Name, d.OnlyTo}} {
+		if len(side.sets) == 0 {
+			output = append(output, fmt.Sprintf("No backup sets only needed for @%s.\n", side.snapshot))
+			continue
+		}
+		output = append(output, fmt.Sprintf("Backup sets only needed for @%s:", side.snapshot))
+		for _, set := range side.sets {
+			output = append(output, "\t"+backupSetSummary(set))
+		}
+		output = append(output, "")
+	}
+
+	if d.ChangeCounts == nil {
+		output = append(output, "Files changed: unavailable, both snapshots must be found locally.")
+		return strings.Join(output, "\n")
+	}
+
+	output = append(output, fmt.Sprintf("Files changed: %d added, %d removed, %d modified, %d renamed", d.ChangeCounts["+"], d.ChangeCounts["-"], d.ChangeCounts["M"], d.ChangeCounts["R"]))
+	if len(d.TopDirectories) > 0 {
+		output = append(output, "Directories with the most changes:")
+		for _, dir := range d.TopDirectories {
+			output = append(output, fmt.Sprintf("\t%s: %d", dir.Path, dir.Changes))
+		}
+	}
+	return strings.Join(output, "\n")
+}
+
+// describe will describe the restore point of the snapshot of the given volume.
+func (p RestorePoint) describe(volumeName string) string {
+	return fmt.Sprintf("Restore point %s@%s (%v): %d backup sets, %d volumes, %s stored (%s stream)",
+		volumeName, p.Snapshot.Name, p.Snapshot.CreationTime, len(p.BackupSets), p.Volumes, humanize.IBytes(p.StoredBytes), humanize.IBytes(p.StreamBytes))
+}
+
+func backupSetSummary(set *helpers.JobInfo) string {
+	name := "@" + set.BaseSnapshot.Name + " (full)"
+	if set.IncrementalSnapshot.Name != "" {
+		name = fmt.Sprintf("@%s -> @%s", set.IncrementalSnapshot.Name, set.BaseSnapshot.Name)
+	}
+
+	stored := set.TotalBytesWritten()
+	ratio := 0.0
+	if stored > 0 {
+		ratio = float64(set.ZFSStreamBytes) / float64(stored)
+	}
+	return fmt.Sprintf("%s: %d volumes, %s stored, %s stream (%.2fx compression, %s)",
+		name, len(set.Volumes), humanize.IBytes(stored), humanize.IBytes(set.ZFSStreamBytes), ratio, set.Compressor)
+}
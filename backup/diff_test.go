@@ -0,0 +1,91 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestRestorePointDiff(t *testing.T) {
+	created := time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := func(name string, day int) helpers.SnapshotInfo {
+		return helpers.SnapshotInfo{Name: name, CreationTime: created.AddDate(0, 0, day)}
+	}
+
+	full := &helpers.JobInfo{VolumeName: "tank/pgdata", BaseSnapshot: snapshot("snap1", 0), ZFSStreamBytes: 100, Volumes: []*helpers.VolumeInfo{{Size: 50}, {Size: 50}}}
+	incr1 := &helpers.JobInfo{VolumeName: "tank/pgdata", BaseSnapshot: snapshot("snap2", 1), IncrementalSnapshot: snapshot("snap1", 0), ZFSStreamBytes: 10, Volumes: []*helpers.VolumeInfo{{Size: 5}}}
+	incr2 := &helpers.JobInfo{VolumeName: "tank/pgdata", BaseSnapshot: snapshot("snap3", 2), IncrementalSnapshot: snapshot("snap2", 1), ZFSStreamBytes: 1000, Volumes: []*helpers.VolumeInfo{{Size: 500}, {Size: 400}}}
+	orphan := &helpers.JobInfo{VolumeName: "tank/pgdata", BaseSnapshot: snapshot("snap5", 4), IncrementalSnapshot: snapshot("snap4", 3)}
+	sets := linkManifests(context.Background(), []*helpers.JobInfo{full, incr1, incr2, orphan})["tank/pgdata"]
+
+	from, err := restorePoint(sets, "snap2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(from.BackupSets) != 2 || from.BackupSets[0] != full || from.Volumes != 3 || from.StoredBytes != 105 || from.StreamBytes != 110 {
+		t.Errorf("unexpected restore point for snap2: %+v", from)
+	}
+
+	to, err := restorePoint(sets, "snap3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	diff := newSnapshotDiff("tank/pgdata", from, to)
+	if diff.Shared != 2 || len(diff.OnlyFrom) != 0 || len(diff.OnlyTo) != 1 || diff.OnlyTo[0] != incr2 {
+		t.Errorf("unexpected diff: %+v", diff)
+	}
+
+	reverse := newSnapshotDiff("tank/pgdata", to, from)
+	if reverse.Shared != 2 || len(reverse.OnlyFrom) != 1 || len(reverse.OnlyTo) != 0 {
+		t.Errorf("unexpected reversed diff: %+v", reverse)
+	}
+
+	if _, err = restorePoint(sets, "snap5"); err == nil {
+		t.Errorf("expected an error for a broken chain")
+	}
+
+	if _, err = restorePoint(sets, "missing"); err == nil {
+		t.Errorf("expected an error for a missing snapshot")
+	}
+}
+
+func TestSummarizeChanges(t *testing.T) {
+	changes := []helpers.ZFSChange{
+		{Type: "M", Path: "/tank/pgdata/base"},
+		{Type: "+", Path: "/tank/pgdata/base/1"},
+		{Type: "+", Path: "/tank/pgdata/base/2"},
+		{Type: "-", Path: "/tank/pgdata/old"},
+		{Type: "R", Path: "/tank/pgdata/a", NewPath: "/tank/pgdata/b"},
+	}
+
+	counts, dirs := summarizeChanges(changes, 1)
+	if counts["+"] != 2 || counts["-"] != 1 || counts["M"] != 1 || counts["R"] != 1 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+	if len(dirs) != 1 || dirs[0].Path != "/tank/pgdata" || dirs[0].Changes != 3 {
+		t.Errorf("unexpected directories: %v", dirs)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// SizeEstimate predicts the outcome of sending a backup set.
+type SizeEstimate struct {
+	// The size of the zfs send stream as estimated by zfs.
+	StreamBytes uint64
+	// The bytes stored per byte of zfs send stream, 1 if there is no history to learn from.
+	CompressionRatio float64
+	// The number of previous backup sets the compression ratio and throughput were computed from.
+	Samples int
+	// The predicted size of the backup set once compressed, encrypted, and/or signed.
+	Bytes uint64
+	// The predicted number of volumes.
+	Volumes int64
+	// The bytes stored per second by previous backup sets, 0 if there is no history to learn from.
+	Throughput float64
+	// The predicted duration of the send, 0 if there is no history to learn from.
+	Duration time.Duration
+}
+
+// EstimateBackup will predict the size, volume count, and duration of the backup set the provided
+// job would send, learning the compression ratio and throughput from the provided backup sets.
+func EstimateBackup(ctx context.Context, jobInfo *helpers.JobInfo, history []*helpers.JobInfo) (*SizeEstimate, error) {
+	streamBytes, err := helpers.GetZFSSendEstimate(ctx, jobInfo)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not estimate the size of the zfs send stream - %v", err)
+		return nil, err
+	}
+
+	estimate := &SizeEstimate{StreamBytes: streamBytes, CompressionRatio: 1}
+	if ratio, throughput, samples := historicalRates(history, jobInfo.VolumeName, jobInfo.Compressor); samples > 0 {
+		estimate.CompressionRatio = ratio
+		estimate.Throughput = throughput
+		estimate.Samples = samples
+	}
+
+	estimate.Bytes = uint64(float64(streamBytes) * estimate.CompressionRatio)
+	estimate.Volumes = 1
+	if volumeSize := jobInfo.VolumeSize * 1024 * 1024; volumeSize > 0 && estimate.Bytes > volumeSize {
+		estimate.Volumes = int64((estimate.Bytes + volumeSize - 1) / volumeSize)
+	}
+	if estimate.Throughput > 0 {
+		estimate.Duration = time.Duration(float64(estimate.Bytes) / estimate.Throughput * float64(time.Second))
+	}
+	return estimate, nil
+}
+
+// historicalRates returns the compression ratio (bytes stored per byte of zfs send stream) and
+// throughput (bytes stored per second) of the backup sets provided along with the number of
+// backup sets used. Backup sets of the same volume and compressor are preferred, falling back
+// to every backup set using the same compressor.
+func historicalRates(sets []*helpers.JobInfo, volume, compressor string) (ratio, throughput float64, samples int) {
+	var sameVolume, sameCompressor []*helpers.JobInfo
+	for _, set := range sets {
+		if set.ZFSStreamBytes == 0 || set.Compressor != compressor {
+			continue
+		}
+		sameCompressor = append(sameCompressor, set)
+		if set.VolumeName == volume {
+			sameVolume = append(sameVolume, set)
+		}
+	}
+
+	candidates := sameVolume
+	if len(candidates) == 0 {
+		candidates = sameCompressor
+	}
+
+	var streamBytes, storedBytes, timedBytes uint64
+	var elapsed time.Duration
+	for _, set := range candidates {
+		written := set.TotalBytesWritten()
+		streamBytes += set.ZFSStreamBytes
+		storedBytes += written
+		if set.EndTime.After(set.StartTime) {
+			timedBytes += written
+			elapsed += set.EndTime.Sub(set.StartTime)
+		}
+	}
+
+	if streamBytes == 0 {
+		return 0, 0, 0
+	}
+	ratio = float64(storedBytes) / float64(streamBytes)
+	if elapsed > 0 {
+		throughput = float64(timedBytes) / elapsed.Seconds()
+	}
+	return ratio, throughput, len(candidates)
+}
@@ -0,0 +1,69 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestHistoricalRates(t *testing.T) {
+	start := time.Now()
+	set := func(volume, compressor string, stream, stored uint64, took time.Duration) *helpers.JobInfo {
+		return &helpers.JobInfo{
+			VolumeName:     volume,
+			Compressor:     compressor,
+			ZFSStreamBytes: stream,
+			Volumes:        []*helpers.VolumeInfo{{Size: stored}},
+			StartTime:      start,
+			EndTime:        start.Add(took),
+		}
+	}
+
+	sets := []*helpers.JobInfo{
+		set("tank/a", "internal", 1000, 500, 10*time.Second),
+		set("tank/a", "internal", 3000, 1500, 10*time.Second),
+		set("tank/b", "internal", 1000, 900, 0),
+		set("tank/a", "xz", 1000, 100, time.Second),
+		set("tank/a", "internal", 0, 100, time.Second),
+	}
+
+	testCases := []struct {
+		volume, compressor string
+		ratio, throughput  float64
+		samples            int
+	}{
+		{"tank/a", "internal", 0.5, 100, 2},
+		{"tank/b", "internal", 0.9, 0, 1},
+		{"tank/c", "internal", 2900.0 / 5000.0, 100, 3},
+		{"tank/a", "xz", 0.1, 100, 1},
+		{"tank/a", "", 0, 0, 0},
+	}
+
+	for idx, testCase := range testCases {
+		ratio, throughput, samples := historicalRates(sets, testCase.volume, testCase.compressor)
+		if ratio != testCase.ratio || throughput != testCase.throughput || samples != testCase.samples {
+			t.Errorf("%d: expected %v, %v, %d, got %v, %v, %d", idx, testCase.ratio, testCase.throughput, testCase.samples, ratio, throughput, samples)
+		}
+	}
+}
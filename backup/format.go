@@ -0,0 +1,102 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// DestinationFormat is the format of the manifests found at a destination.
+type DestinationFormat struct {
+	Destination string
+	Format      string // The format manifests are written in at the destination, empty if it holds none
+	Native      int    // Manifests of helpers.ManifestFormatNative found
+	Upstream    int    // Manifests of helpers.ManifestFormatUpstream found
+}
+
+// DetectManifestFormats will sync the local cache of every destination of jobInfo and report the format of the
+// manifests found there. A destination holding any manifest of the native format is a native one: upstream
+// manifests found along with them were written before the destination was moved over from upstream.
+func DetectManifestFormats(ctx context.Context, jobInfo *helpers.JobInfo) ([]DestinationFormat, error) {
+	formats := make([]DestinationFormat, 0, len(jobInfo.Destinations))
+	for _, destination := range jobInfo.Destinations {
+		manifests, err := getManifestsForTarget(ctx, destination, jobInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		format := DestinationFormat{Destination: destination}
+		for _, manifest := range manifests {
+			if manifest.ManifestFormat == helpers.ManifestFormatUpstream {
+				format.Upstream++
+			} else {
+				format.Native++
+			}
+		}
+		switch {
+		case format.Native > 0:
+			format.Format = helpers.ManifestFormatNative
+		case format.Upstream > 0:
+			format.Format = helpers.ManifestFormatUpstream
+		}
+		formats = append(formats, format)
+	}
+	return formats, nil
+}
+
+// resolveManifestFormat will settle the format the manifests of jobInfo are written in, the one found at its
+// destinations for helpers.ManifestFormatAuto, and check the backup set can be written in it.
+func resolveManifestFormat(ctx context.Context, jobInfo *helpers.JobInfo) error {
+	if jobInfo.ManifestFormat == helpers.ManifestFormatAuto {
+		formats, err := DetectManifestFormats(ctx, jobInfo)
+		if err != nil {
+			return err
+		}
+
+		format := ""
+		for _, found := range formats {
+			if found.Format == "" {
+				continue
+			}
+			if format != "" && found.Format != format {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("The destinations hold manifests of different formats, send to the destinations with %s manifests separately from those with %s manifests.", format, found.Format)
+				return fmt.Errorf("destinations hold manifests of different formats")
+			}
+			format = found.Format
+		}
+		if format == "" {
+			format = helpers.ManifestFormatNative
+		}
+		helpers.EnvironmentOf(ctx).Logger.Infof("Writing the manifests in the %s format.", format)
+		jobInfo.ManifestFormat = format
+	}
+
+	if jobInfo.ManifestFormat == helpers.ManifestFormatUpstream {
+		if problems := jobInfo.UpstreamIncompatibilities(); len(problems) != 0 {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Upstream releases of zfsbackup-go cannot read a backup set with %s, send it with the native manifest format.", strings.Join(problems, " or "))
+			return fmt.Errorf("backup set cannot be written in the upstream manifest format")
+		}
+	}
+	return nil
+}
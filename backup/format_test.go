@@ -0,0 +1,148 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestManifestFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackup-format")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = filepath.Join(dir, "work")
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	targets := make(map[string]string)
+	for _, name := range []string{"native", "upstream", "empty"} {
+		targets[name] = filepath.Join(dir, name)
+		if err = os.MkdirAll(targets[name], 0700); err != nil {
+			t.Fatalf("could not create target: %v", err)
+		}
+	}
+	uri := func(names ...string) []string {
+		uris := make([]string, len(names))
+		for idx, name := range names {
+			uris[idx] = "file://" + targets[name]
+		}
+		return uris
+	}
+	newJob := func(format string, destinations ...string) *helpers.JobInfo {
+		return &helpers.JobInfo{
+			VolumeName:     "tank",
+			BaseSnapshot:   helpers.SnapshotInfo{Name: "snap1", CreationTime: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+			StartTime:      time.Now(),
+			Compressor:     helpers.InternalCompressor,
+			Separator:      "|",
+			ManifestPrefix: "manifests",
+			ManifestFormat: format,
+			Destinations:   uri(destinations...),
+		}
+	}
+
+	for _, format := range []string{helpers.ManifestFormatNative, helpers.ManifestFormatUpstream} {
+		j := newJob(format, format)
+		if err = resolveManifestFormat(context.Background(), j); err != nil {
+			t.Fatalf("could not resolve the %s format: %v", format, err)
+		}
+		if _, err = getCacheDir(context.Background(), j.Destinations[0]); err != nil {
+			t.Fatalf("could not create the cache: %v", err)
+		}
+		vol, serr := saveManifest(context.Background(), j, true)
+		if serr != nil {
+			t.Fatalf("could not save the %s manifest: %v", format, serr)
+		}
+		err = uploadManifest(context.Background(), j, vol, j.Destinations)
+		vol.DeleteVolume()
+		if err != nil {
+			t.Fatalf("could not upload the %s manifest: %v", format, err)
+		}
+	}
+
+	// Upstream releases read every object of the manifest prefix as a manifest
+	files, err := ioutil.ReadDir(targets["upstream"])
+	if err != nil {
+		t.Fatalf("could not list the upstream target: %v", err)
+	}
+	for _, file := range files {
+		if strings.Contains(file.Name(), helpers.ManifestHistoryExtension) {
+			t.Errorf("expected no history record at the upstream target, found %s", file.Name())
+		}
+	}
+
+	formats, err := DetectManifestFormats(context.Background(), newJob(helpers.ManifestFormatAuto, "native", "upstream", "empty"))
+	if err != nil {
+		t.Fatalf("could not detect the manifest formats: %v", err)
+	}
+	expected := []DestinationFormat{
+		{Destination: uri("native")[0], Format: helpers.ManifestFormatNative, Native: 1},
+		{Destination: uri("upstream")[0], Format: helpers.ManifestFormatUpstream, Upstream: 1},
+		{Destination: uri("empty")[0]},
+	}
+	if len(formats) != len(expected) {
+		t.Fatalf("expected %d destinations, got %d", len(expected), len(formats))
+	}
+	for idx := range expected {
+		if formats[idx] != expected[idx] {
+			t.Errorf("%d: expected %+v, got %+v", idx, expected[idx], formats[idx])
+		}
+	}
+
+	testCases := []struct {
+		format       string
+		destinations []string
+		contentAddr  bool
+		expect       string
+		errTest      errTestFunc
+	}{
+		{helpers.ManifestFormatAuto, []string{"upstream", "empty"}, false, helpers.ManifestFormatUpstream, nilErrTest},
+		{helpers.ManifestFormatAuto, []string{"native"}, false, helpers.ManifestFormatNative, nilErrTest},
+		{helpers.ManifestFormatAuto, []string{"empty"}, false, helpers.ManifestFormatNative, nilErrTest},
+		{helpers.ManifestFormatAuto, []string{"native", "upstream"}, false, "", nonNilErrTest},
+		{helpers.ManifestFormatAuto, []string{"upstream"}, true, "", nonNilErrTest},
+		{helpers.ManifestFormatUpstream, []string{"empty"}, true, "", nonNilErrTest},
+		{helpers.ManifestFormatNative, []string{"upstream"}, true, helpers.ManifestFormatNative, nilErrTest},
+	}
+
+	for idx, c := range testCases {
+		j := newJob(c.format, c.destinations...)
+		j.ContentAddressed = c.contentAddr
+		err := resolveManifestFormat(context.Background(), j)
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if err == nil && j.ManifestFormat != c.expect {
+			t.Errorf("%d: expected the %s format, got %s", idx, c.expect, j.ManifestFormat)
+		}
+	}
+}
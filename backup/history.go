@@ -0,0 +1,168 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// ManifestRecord is a record of a manifest kept in its history. Every final manifest uploaded is also
+// uploaded as a record that is never replaced nor deleted, so a manifest replaced by mistake, or on
+// purpose, can be rolled back.
+type ManifestRecord struct {
+	Manifest   string    // The name of the manifest the record is of
+	Written    time.Time // When the manifest was written
+	ObjectName string    // The name the record is stored as
+}
+
+// currentManifests will return the object names provided without the records of the history of manifests.
+func currentManifests(objectNames []string) []string {
+	manifests := make([]string, 0, len(objectNames))
+	for _, objectName := range objectNames {
+		if _, _, ok := helpers.ParseManifestHistoryObjectName(objectName); !ok {
+			manifests = append(manifests, objectName)
+		}
+	}
+	return manifests
+}
+
+// manifestUploadWrapper will return an operation uploading the manifest volume provided and, if it is a
+// final manifest and history is set, a record of it kept in its history before it.
+func manifestUploadWrapper(ctx context.Context, b backends.Backend, vol *helpers.VolumeInfo, prefix string, history bool) func() error {
+	upload := volUploadWrapper(ctx, b, vol, prefix)
+	if !vol.IsFinalManifest || !history {
+		return upload
+	}
+
+	record := volUploadWrapper(ctx, b, vol.CopyAs(helpers.ManifestHistoryObjectName(vol.ObjectName, vol.CloseTime)), prefix)
+	return func() error {
+		if err := record(); err != nil {
+			return err
+		}
+		return upload()
+	}
+}
+
+// ManifestHistory will return the records kept in the history of the manifests found in the target destination,
+// sorted by manifest and by when they were written. If manifest is not empty, only its records are returned.
+func ManifestHistory(ctx context.Context, jobInfo *helpers.JobInfo, manifest string) ([]ManifestRecord, error) {
+	target := jobInfo.Destinations[0]
+	backend, err := prepareBackend(ctx, jobInfo, target, nil)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+	defer backend.Close()
+
+	objectNames, err := backend.List(ctx, jobInfo.ManifestPrefix)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list manifest files from target %s due to error - %v.", target, err)
+		return nil, helpers.NewError(helpers.ErrorKindBackend, err)
+	}
+
+	var records []ManifestRecord
+	for _, objectName := range objectNames {
+		name, written, ok := helpers.ParseManifestHistoryObjectName(objectName)
+		if !ok || (manifest != "" && name != manifest) {
+			continue
+		}
+		records = append(records, ManifestRecord{Manifest: name, Written: written, ObjectName: objectName})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Manifest != records[j].Manifest {
+			return records[i].Manifest < records[j].Manifest
+		}
+		return records[i].Written.Before(records[j].Written)
+	})
+	return records, nil
+}
+
+// RollbackManifest will upload the record of the history of a manifest stored as recordName in the target
+// destination in place of the manifest. The manifest replaced is left in its history, as is the one uploaded.
+func RollbackManifest(pctx context.Context, jobInfo *helpers.JobInfo, recordName string) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	manifestName, written, ok := helpers.ParseManifestHistoryObjectName(recordName)
+	if !ok {
+		return fmt.Errorf("%s is not a record of the history of a manifest", recordName)
+	}
+
+	target := jobInfo.Destinations[0]
+	backend, err := prepareBackend(ctx, jobInfo, target, nil)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return err
+	}
+	defer backend.Close()
+
+	if _, err = getCacheDir(ctx, target); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not create cache for destination %s due to error - %v.", target, err)
+		return err
+	}
+
+	if err = backend.PreDownload(ctx, []string{recordName}); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not prepare %s for download due to error - %v", recordName, err)
+		return helpers.NewError(helpers.ErrorKindBackend, err)
+	}
+	f, err := ioutil.TempFile(helpers.EnvironmentOf(ctx).TempDir, "manifest")
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not create a temporary file due to error - %v", err)
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err = downloadTo(ctx, backend, recordName, f.Name()); err != nil {
+		return helpers.NewError(helpers.ErrorKindBackend, err)
+	}
+
+	manifest, err := readManifest(ctx, f.Name(), jobInfo)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read the record %s due to error - %v", recordName, err)
+		return err
+	}
+
+	inheritJobOptions(manifest, jobInfo)
+	vol, err := saveManifest(ctx, manifest, true)
+	if err != nil {
+		return err
+	}
+	defer vol.DeleteVolume()
+	// The record of a manifest is named after it, another name means other encryption or signing options
+	if vol.ObjectName != manifestName {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("The manifest %s would be named %s, provide the same encryption and signing options the backup set was sent with.", manifestName, vol.ObjectName)
+		return fmt.Errorf("the manifest %s would be named %s", manifestName, vol.ObjectName)
+	}
+	if err = uploadManifest(ctx, manifest, vol, jobInfo.Destinations); err != nil {
+		return err
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Rolled back the manifest %s to the one written at %v.", manifestName, written.Local())
+	return nil
+}
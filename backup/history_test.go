@@ -0,0 +1,108 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestManifestHistoryObjectName(t *testing.T) {
+	written := time.Date(2020, 5, 1, 12, 30, 0, 42, time.UTC)
+	record := helpers.ManifestHistoryObjectName("manifests|Tank|snap1.manifest.gz.pgp", written.In(time.Local))
+
+	testCases := []struct {
+		objectName string
+		manifest   string
+		written    time.Time
+		ok         bool
+	}{
+		{record, "manifests|Tank|snap1.manifest.gz.pgp", written, true},
+		{"manifests|Tank|snap1.manifest.gz.pgp", "", time.Time{}, false},
+		{"manifests|Tank|snap1.manifest.gz.history-yesterday", "", time.Time{}, false},
+	}
+
+	for idx, c := range testCases {
+		manifest, got, ok := helpers.ParseManifestHistoryObjectName(c.objectName)
+		if ok != c.ok || manifest != c.manifest || !got.Equal(c.written) {
+			t.Errorf("%d: expected %s, %v, %v, got %s, %v, %v", idx, c.manifest, c.written, c.ok, manifest, got, ok)
+		}
+	}
+}
+
+func TestManifestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackuphistory")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	first, second := time.Now().Add(-time.Hour), time.Now()
+	objects := []string{
+		"manifests|Tank|snap1.manifest.gz",
+		helpers.ManifestHistoryObjectName("manifests|Tank|snap1.manifest.gz", second),
+		helpers.ManifestHistoryObjectName("manifests|Tank|snap1.manifest.gz", first),
+		"manifests|Tank|snap2.manifest.gz",
+		helpers.ManifestHistoryObjectName("manifests|Tank|snap2.manifest.gz", first),
+		"Tank|snap1.zstream.gz.vol1",
+	}
+	for _, object := range objects {
+		if err = ioutil.WriteFile(filepath.Join(dir, object), nil, 0600); err != nil {
+			t.Fatalf("could not create object: %v", err)
+		}
+	}
+
+	if got := currentManifests(objects); !reflect.DeepEqual(got, []string{objects[0], objects[3], objects[5]}) {
+		t.Errorf("expected the records to be left out, got %v", got)
+	}
+
+	j := &helpers.JobInfo{ManifestPrefix: "manifests", Destinations: []string{"file://" + dir}}
+	testCases := []struct {
+		manifest string
+		expected []string
+	}{
+		{"", []string{objects[2], objects[1], objects[4]}},
+		{"manifests|Tank|snap1.manifest.gz", []string{objects[2], objects[1]}},
+		{"manifests|Tank|snap3.manifest.gz", nil},
+	}
+
+	for idx, c := range testCases {
+		records, herr := ManifestHistory(context.Background(), j, c.manifest)
+		if herr != nil {
+			t.Errorf("%d: unexpected error %v", idx, herr)
+			continue
+		}
+		var got []string
+		for _, record := range records {
+			got = append(got, record.ObjectName)
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%d: expected %v, got %v", idx, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// CanaryPrefix is the prefix of the canary objects written by CheckImmutability.
+const CanaryPrefix = "canaries"
+
+// ImmutabilityCheck is the outcome of a single attempt to tamper with the canary object.
+type ImmutabilityCheck struct {
+	Action    string // overwrite, delete, or delete-version
+	Prevented bool
+	Detail    string
+}
+
+// ImmutabilityReport describes whether a destination prevented tampering with a canary object.
+type ImmutabilityReport struct {
+	Destination string
+	Canary      string
+	Checks      []ImmutabilityCheck
+	Protected   bool // Every attempt to tamper with the canary object was prevented
+}
+
+// CheckImmutability will write a canary object to the destination and then try to overwrite and delete it,
+// reporting whether the WORM, object lock, or versioning configuration of the destination prevented it.
+// On the backends keeping prior versions of the objects, deleting the original version is attempted as well.
+// The canary is left in place when the destination protects it.
+func CheckImmutability(ctx context.Context, j *helpers.JobInfo, destination string) (*ImmutabilityReport, error) {
+	backend, err := prepareBackend(ctx, j, destination, make(chan bool, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer backend.Close()
+
+	token := make([]byte, 16)
+	if _, err = rand.Read(token); err != nil {
+		return nil, err
+	}
+	canary := fmt.Sprintf("%s%simmutability-%s-%s", CanaryPrefix, j.Separator, time.Now().UTC().Format("20060102T150405Z"), hex.EncodeToString(token))
+
+	return checkImmutability(ctx, backend, destination, canary)
+}
+
+func checkImmutability(ctx context.Context, backend backends.Backend, destination, canary string) (*ImmutabilityReport, error) {
+	report := &ImmutabilityReport{Destination: destination, Canary: canary}
+	original := []byte("zfsbackup immutability canary " + canary + "\n")
+	tampered := []byte("zfsbackup tampered canary " + canary + "\n")
+
+	if err := writeCanary(ctx, backend, canary, original); err != nil {
+		return nil, fmt.Errorf("could not write the canary %s: %v", canary, err)
+	}
+	if content, err := readCanary(ctx, backend, canary); err != nil {
+		return nil, fmt.Errorf("could not read back the canary %s: %v", canary, err)
+	} else if !bytes.Equal(content, original) {
+		return nil, fmt.Errorf("the canary %s read back does not match what was written", canary)
+	}
+	helpers.EnvironmentOf(ctx).Logger.Infof("Wrote the canary %s to %s.", canary, destination)
+
+	versioned, _ := backend.(backends.VersionDeleter)
+	var originalVersion string
+	if versioned != nil {
+		versions, err := versioned.ObjectVersions(ctx, canary)
+		if err != nil {
+			return nil, fmt.Errorf("could not list the versions of the canary %s: %v", canary, err)
+		}
+		if len(versions) > 0 {
+			originalVersion = versions[0]
+		}
+	}
+	// keptVersion reports whether the original version of the canary survived an attempt that went through
+	keptVersion := func() bool {
+		if originalVersion == "" {
+			return false
+		}
+		versions, err := versioned.ObjectVersions(ctx, canary)
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not list the versions of the canary %s - %v", canary, err)
+			return false
+		}
+		for _, version := range versions {
+			if version == originalVersion {
+				return true
+			}
+		}
+		return false
+	}
+
+	overwrite := ImmutabilityCheck{Action: "overwrite"}
+	if err := writeCanary(ctx, backend, canary, tampered); err != nil {
+		overwrite.Prevented = true
+		overwrite.Detail = fmt.Sprintf("the upload was refused: %v", err)
+	} else if content, rerr := readCanary(ctx, backend, canary); rerr == nil && bytes.Equal(content, original) {
+		overwrite.Prevented = true
+		overwrite.Detail = "the upload went through but the canary kept its content"
+	} else if keptVersion() {
+		overwrite.Prevented = true
+		overwrite.Detail = "the canary was replaced but its original version is kept"
+	} else {
+		overwrite.Detail = "the canary was replaced"
+	}
+	report.Checks = append(report.Checks, overwrite)
+
+	remove := ImmutabilityCheck{Action: "delete"}
+	if err := backend.Delete(ctx, canary); err != nil {
+		remove.Prevented = true
+		remove.Detail = fmt.Sprintf("the delete was refused: %v", err)
+	} else if exists, lerr := canaryExists(ctx, backend, canary); lerr == nil && exists {
+		remove.Prevented = true
+		remove.Detail = "the delete went through but the canary is still listed"
+	} else if keptVersion() {
+		remove.Prevented = true
+		remove.Detail = "the canary was deleted but its original version is kept"
+	} else {
+		remove.Detail = "the canary was deleted"
+	}
+	report.Checks = append(report.Checks, remove)
+
+	if originalVersion != "" {
+		removeVersion := ImmutabilityCheck{Action: "delete-version"}
+		if err := versioned.DeleteVersion(ctx, canary, originalVersion); err != nil {
+			removeVersion.Prevented = true
+			removeVersion.Detail = fmt.Sprintf("the delete of the original version was refused: %v", err)
+		} else if keptVersion() {
+			removeVersion.Prevented = true
+			removeVersion.Detail = "the delete of the original version went through but it is still listed"
+		} else {
+			removeVersion.Detail = "the original version was deleted"
+		}
+		report.Checks = append(report.Checks, removeVersion)
+	}
+
+	report.Protected = true
+	for _, check := range report.Checks {
+		report.Protected = report.Protected && check.Prevented
+	}
+	if report.Protected {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("The canary %s is left at %s, it can be deleted once its retention ends.", canary, destination)
+	} else {
+		cleanCanary(ctx, backend, versioned, canary)
+	}
+	return report, nil
+}
+
+// writeCanary will store content as the canary object.
+func writeCanary(ctx context.Context, backend backends.Backend, canary string, content []byte) error {
+	vol, err := helpers.CreateSimpleVolume(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer vol.DeleteVolume()
+
+	if _, err = vol.Write(content); err != nil {
+		vol.Close()
+		return err
+	}
+	if err = vol.Close(); err != nil {
+		return err
+	}
+	vol.ObjectName = canary
+
+	if err = vol.OpenVolume(); err != nil {
+		return err
+	}
+	defer vol.Close()
+	return backend.Upload(ctx, vol)
+}
+
+// readCanary will return the content of the canary object.
+func readCanary(ctx context.Context, backend backends.Backend, canary string) ([]byte, error) {
+	r, err := backend.Download(ctx, canary)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// canaryExists will report whether the canary object is listed by the backend.
+func canaryExists(ctx context.Context, backend backends.Backend, canary string) (bool, error) {
+	objects, err := backend.List(ctx, canary)
+	if err != nil {
+		return false, err
+	}
+	for _, object := range objects {
+		if object == canary {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cleanCanary will delete whatever is left of the canary object at a destination that did not protect it.
+func cleanCanary(ctx context.Context, backend backends.Backend, versioned backends.VersionDeleter, canary string) {
+	if exists, err := canaryExists(ctx, backend, canary); err == nil && exists {
+		if err = backend.Delete(ctx, canary); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not delete the canary %s - %v", canary, err)
+		}
+	}
+	if versioned == nil {
+		return
+	}
+	versions, err := versioned.ObjectVersions(ctx, canary)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Could not list the versions of the canary %s to delete them - %v", canary, err)
+		return
+	}
+	for _, version := range versions {
+		if err = versioned.DeleteVersion(ctx, canary, version); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not delete the version %s of the canary %s - %v", version, canary, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var errLocked = errors.New("locked")
+
+// lockingBackend keeps every version of its objects in memory, refusing what its configuration locks
+type lockingBackend struct {
+	mockBackend
+	worm         bool // Refuse to overwrite or delete an object
+	lockVersions bool // Refuse to delete a version of an object
+
+	versions map[string][]string // Version ids of every object, newest first
+	current  map[string]string   // Current version id of every object, empty once deleted
+	data     map[string][]byte
+}
+
+func newLockingBackend(worm, lockVersions bool) *lockingBackend {
+	return &lockingBackend{
+		worm:         worm,
+		lockVersions: lockVersions,
+		versions:     make(map[string][]string),
+		current:      make(map[string]string),
+		data:         make(map[string][]byte),
+	}
+}
+
+func (l *lockingBackend) Upload(ctx context.Context, vol *helpers.VolumeInfo) error {
+	if l.worm && l.current[vol.ObjectName] != "" {
+		return errLocked
+	}
+	content, err := ioutil.ReadAll(vol)
+	if err != nil {
+		return err
+	}
+	id := strconv.Itoa(len(l.data) + 1)
+	l.data[id] = content
+	l.versions[vol.ObjectName] = append([]string{id}, l.versions[vol.ObjectName]...)
+	l.current[vol.ObjectName] = id
+	return nil
+}
+
+func (l *lockingBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	var objects []string
+	for object, id := range l.current {
+		if id != "" && strings.HasPrefix(object, prefix) {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+func (l *lockingBackend) Download(ctx context.Context, filename string) (io.ReadCloser, error) {
+	id := l.current[filename]
+	if id == "" {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(l.data[id])), nil
+}
+
+func (l *lockingBackend) Delete(ctx context.Context, filename string) error {
+	if l.worm && l.current[filename] != "" {
+		return errLocked
+	}
+	l.current[filename] = ""
+	return nil
+}
+
+// versionedLockingBackend is a lockingBackend whose prior versions can be listed and deleted
+type versionedLockingBackend struct {
+	*lockingBackend
+}
+
+func (v versionedLockingBackend) ObjectVersions(ctx context.Context, filename string) ([]string, error) {
+	return v.versions[filename], nil
+}
+
+func (v versionedLockingBackend) DeleteVersion(ctx context.Context, filename, version string) error {
+	if v.lockVersions {
+		return errLocked
+	}
+	var kept []string
+	for _, id := range v.versions[filename] {
+		if id != version {
+			kept = append(kept, id)
+		}
+	}
+	v.versions[filename] = kept
+	if v.current[filename] == version {
+		v.current[filename] = ""
+		if len(kept) > 0 {
+			v.current[filename] = kept[0]
+		}
+	}
+	return nil
+}
+
+func TestImmutability(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupimmutability")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileBackend, err := prepareBackend(context.Background(), &helpers.JobInfo{MaxParallelUploads: 1}, "file://"+dir, make(chan bool, 1))
+	if err != nil {
+		t.Fatalf("could not prepare backend: %v", err)
+	}
+
+	testCases := []struct {
+		backend   backends.Backend
+		prevented []bool
+		left      bool
+	}{
+		{backend: fileBackend, prevented: []bool{false, false}},
+		{backend: newLockingBackend(true, false), prevented: []bool{true, true}, left: true},
+		{backend: versionedLockingBackend{newLockingBackend(false, false)}, prevented: []bool{true, true, false}},
+		{backend: versionedLockingBackend{newLockingBackend(false, true)}, prevented: []bool{true, true, true}, left: true},
+		{backend: versionedLockingBackend{newLockingBackend(true, true)}, prevented: []bool{true, true, true}, left: true},
+	}
+
+	for idx, c := range testCases {
+		canary := CanaryPrefix + "|immutability-" + strconv.Itoa(idx)
+		report, err := checkImmutability(context.Background(), c.backend, "mock://", canary)
+		if err != nil {
+			t.Errorf("%d: Did not get expected nil error, got %v instead", idx, err)
+			continue
+		}
+
+		protected := true
+		for _, prevented := range c.prevented {
+			protected = protected && prevented
+		}
+		if report.Protected != protected {
+			t.Errorf("%d: Expected the destination to be protected (%v), got %v", idx, protected, report.Protected)
+		}
+		if len(report.Checks) != len(c.prevented) {
+			t.Errorf("%d: Expected %d checks, got %v", idx, len(c.prevented), report.Checks)
+			continue
+		}
+		for cidx, check := range report.Checks {
+			if check.Prevented != c.prevented[cidx] {
+				t.Errorf("%d: Expected the %s to be prevented (%v), got %v", idx, check.Action, c.prevented[cidx], check.Detail)
+			}
+		}
+
+		left, err := canaryExists(context.Background(), c.backend, canary)
+		if versioned, ok := c.backend.(backends.VersionDeleter); ok && !left {
+			versions, verr := versioned.ObjectVersions(context.Background(), canary)
+			left, err = len(versions) > 0, verr
+		}
+		if err != nil || left != c.left {
+			t.Errorf("%d: Expected the canary to be left behind (%v), got %v (%v)", idx, c.left, left, err)
+		}
+	}
+}
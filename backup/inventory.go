@@ -0,0 +1,274 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Inventory is the list of the objects of a bucket read from an S3 Inventory or a GCS Storage Insights report,
+// used by Clean instead of listing every object of the destination.
+type Inventory struct {
+	Bucket  string    // The bucket the report lists the objects of
+	Taken   time.Time // When the objects were listed, objects written since are missing from the report
+	Objects []string  // Keys of the current objects of the bucket
+}
+
+// inventoryManifest holds the fields used of the manifest of an S3 Inventory report or of a GCS Storage
+// Insights report, whichever it is.
+type inventoryManifest struct {
+	// S3 Inventory
+	SourceBucket      string `json:"sourceBucket"`
+	CreationTimestamp string `json:"creationTimestamp"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+
+	// GCS Storage Insights
+	SnapshotTime   string   `json:"snapshot_time"`
+	ShardFileNames []string `json:"report_shards_file_names"`
+}
+
+// ReadInventory will read the inventory report whose manifest is found at location, either a local path or the
+// URI of the manifest in a bucket (e.g. s3://inventory-bucket/source-bucket/config/2024-01-01T01-00Z/manifest.json).
+// The data files of the report are read from where the manifest was found.
+func ReadInventory(ctx context.Context, jobInfo *helpers.JobInfo, location string) (*Inventory, error) {
+	var open func(name string) (io.ReadCloser, error)
+	manifestName := location
+	if parts := strings.SplitN(location, "://", 2); len(parts) == 2 && parts[0] != "file" {
+		bucketParts := strings.SplitN(parts[1], "/", 2)
+		if len(bucketParts) != 2 || bucketParts[1] == "" {
+			return nil, helpers.NewError(helpers.ErrorKindConfig, fmt.Errorf("the inventory report %s does not name its manifest", location))
+		}
+		backend, err := prepareBackend(ctx, jobInfo, parts[0]+"://"+bucketParts[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		defer backend.Close()
+
+		manifestName = bucketParts[1]
+		open = func(name string) (io.ReadCloser, error) {
+			return backend.Download(ctx, name)
+		}
+	} else {
+		manifestName = strings.TrimPrefix(location, "file://")
+		open = func(name string) (io.ReadCloser, error) {
+			return os.Open(resolveInventoryFile(filepath.Dir(manifestName), name))
+		}
+	}
+
+	r, err := open(manifestName)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the inventory report manifest %s due to error - %v", location, err)
+	}
+	defer r.Close()
+
+	return parseInventory(r, path.Dir(filepath.ToSlash(manifestName)), open)
+}
+
+// resolveInventoryFile will find the local copy of the data file of a report whose manifest is in dir. S3 Inventory
+// reports name their data files by their key in the bucket the report is written to, so the longest trailing part of
+// the key found under dir or one of its parents is used.
+func resolveInventoryFile(dir, key string) string {
+	parts := strings.Split(key, "/")
+	for base := dir; ; base = filepath.Dir(base) {
+		for idx := range parts {
+			candidate := filepath.Join(base, filepath.Join(parts[idx:]...))
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate
+			}
+		}
+		if parent := filepath.Dir(base); parent == base {
+			break
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(key))
+}
+
+// parseInventory will read the report whose manifest is provided, opening its data files with open. The data files
+// of a GCS Storage Insights report are named relative to dir, the directory of the manifest.
+func parseInventory(manifest io.Reader, dir string, open func(name string) (io.ReadCloser, error)) (*Inventory, error) {
+	var m inventoryManifest
+	if err := json.NewDecoder(manifest).Decode(&m); err != nil {
+		return nil, fmt.Errorf("could not decode the inventory report manifest due to error - %v", err)
+	}
+
+	switch {
+	case m.FileSchema != "":
+		return parseS3Inventory(&m, open)
+	case len(m.ShardFileNames) > 0:
+		return parseGCSInventory(&m, dir, open)
+	default:
+		return nil, errors.New("the manifest is neither the one of an S3 Inventory nor of a GCS Storage Insights report")
+	}
+}
+
+// parseS3Inventory will read the objects listed by an S3 Inventory report. Only the current version of the objects
+// is kept when the report lists every version.
+func parseS3Inventory(m *inventoryManifest, open func(name string) (io.ReadCloser, error)) (*Inventory, error) {
+	if !strings.EqualFold(m.FileFormat, "CSV") {
+		return nil, fmt.Errorf("the %s format of the S3 Inventory report is not supported, only CSV reports are", m.FileFormat)
+	}
+	millis, err := strconv.ParseInt(m.CreationTimestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the creation time of the S3 Inventory report %q due to error - %v", m.CreationTimestamp, err)
+	}
+
+	columns := make(map[string]int)
+	for idx, column := range strings.Split(m.FileSchema, ",") {
+		columns[strings.TrimSpace(column)] = idx
+	}
+	keyColumn, ok := columns["Key"]
+	if !ok {
+		return nil, errors.New("the S3 Inventory report does not list the key of the objects")
+	}
+	latestColumn, versioned := columns["IsLatest"]
+	deleteColumn, markers := columns["IsDeleteMarker"]
+	width := len(columns)
+
+	inventory := &Inventory{Bucket: m.SourceBucket, Taken: time.Unix(0, millis*int64(time.Millisecond))}
+	for _, file := range m.Files {
+		err = readInventoryFile(open, file.Key, func(record []string) error {
+			if len(record) < width {
+				return fmt.Errorf("expected %d columns, got %d", width, len(record))
+			}
+			if versioned && record[latestColumn] != "true" || markers && record[deleteColumn] == "true" {
+				return nil
+			}
+			key, uerr := url.QueryUnescape(record[keyColumn])
+			if uerr != nil {
+				return fmt.Errorf("could not decode the key %q due to error - %v", record[keyColumn], uerr)
+			}
+			inventory.Objects = append(inventory.Objects, key)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inventory, nil
+}
+
+// parseGCSInventory will read the objects listed by a GCS Storage Insights report.
+func parseGCSInventory(m *inventoryManifest, dir string, open func(name string) (io.ReadCloser, error)) (*Inventory, error) {
+	taken, err := time.Parse(time.RFC3339, m.SnapshotTime)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the snapshot time of the GCS Storage Insights report %q due to error - %v", m.SnapshotTime, err)
+	}
+
+	inventory := &Inventory{Taken: taken}
+	for _, name := range m.ShardFileNames {
+		nameColumn, bucketColumn := -1, -1
+		err = readInventoryFile(open, path.Join(dir, name), func(record []string) error {
+			if nameColumn < 0 {
+				for idx, column := range record {
+					switch column {
+					case "name":
+						nameColumn = idx
+					case "bucket":
+						bucketColumn = idx
+					}
+				}
+				if nameColumn < 0 || bucketColumn < 0 {
+					return errors.New("the GCS Storage Insights report does not list the bucket and name of the objects")
+				}
+				return nil
+			}
+			if len(record) <= nameColumn || len(record) <= bucketColumn {
+				return fmt.Errorf("expected the name and bucket columns, got %d columns", len(record))
+			}
+
+			if inventory.Bucket == "" {
+				inventory.Bucket = record[bucketColumn]
+			} else if inventory.Bucket != record[bucketColumn] {
+				return fmt.Errorf("the GCS Storage Insights report lists the objects of more than one bucket (%s and %s)", inventory.Bucket, record[bucketColumn])
+			}
+			inventory.Objects = append(inventory.Objects, record[nameColumn])
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inventory, nil
+}
+
+// readInventoryFile will call fn with every record of the CSV data file of a report, gzip compressed or not.
+func readInventoryFile(open func(name string) (io.ReadCloser, error), name string, fn func(record []string) error) error {
+	r, err := open(name)
+	if err != nil {
+		return fmt.Errorf("could not open the inventory report file %s due to error - %v", name, err)
+	}
+	defer r.Close()
+
+	var reader io.Reader = bufio.NewReader(r)
+	if magic, _ := reader.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, gerr := gzip.NewReader(reader)
+		if gerr != nil {
+			return fmt.Errorf("could not decompress the inventory report file %s due to error - %v", name, gerr)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+	for {
+		record, rerr := records.Read()
+		if rerr == io.EOF {
+			return nil
+		} else if rerr != nil {
+			return fmt.Errorf("could not read the inventory report file %s due to error - %v", name, rerr)
+		}
+		if err = fn(record); err != nil {
+			return fmt.Errorf("could not read the inventory report file %s due to error - %v", name, err)
+		}
+	}
+}
+
+// inventoryBucket will return the bucket of target an inventory report must list the objects of, empty when target
+// is not stored in a bucket an inventory report is taken of.
+func inventoryBucket(target string) string {
+	parts := strings.SplitN(target, "://", 2)
+	if len(parts) != 2 || (parts[0] != backends.AWSS3BackendPrefix && parts[0] != backends.GoogleCloudStorageBackendPrefix) {
+		return ""
+	}
+	return strings.SplitN(parts[1], "/", 2)[0]
+}
@@ -0,0 +1,210 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func gzipped(t *testing.T, s string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatalf("could not compress: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("could not compress: %v", err)
+	}
+	return buf.String()
+}
+
+func TestParseInventory(t *testing.T) {
+	s3Manifest := `{"sourceBucket": "source", "destinationBucket": "arn:aws:s3:::inventory", "creationTimestamp": "1700000000000",
+		"fileFormat": "%s", "fileSchema": "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size",
+		"files": [{"key": "source/config/data/a.csv.gz"}, {"key": "source/config/data/b.csv"}]}`
+	gcsManifest := `{"snapshot_time": "2023-11-14T00:00:00Z", "shard_count": 2, "report_shards_file_names": ["shard_0.csv", "shard_1.csv"]}`
+	files := map[string]string{
+		"source/config/data/a.csv.gz": gzipped(t, "\"source\",\"tank%7Csnap1.zstream.gz.vol1\",\"v2\",\"true\",\"false\",\"10\"\n"+
+			"\"source\",\"tank%7Csnap1.zstream.gz.vol1\",\"v1\",\"false\",\"false\",\"10\"\n"+
+			"\"source\",\"old+file\",\"v3\",\"true\",\"true\",\"\"\n"),
+		"source/config/data/b.csv": "\"source\",\"my%20file\",\"v1\",\"true\",\"false\",\"10\"\n",
+		"reports/shard_0.csv":      "project,bucket,name,size\np,source,tank|snap1.zstream.gz.vol1,10\n",
+		"reports/shard_1.csv":      "project,bucket,name,size\np,source,my file,10\n",
+		"reports/other.csv":        "project,bucket,name,size\np,source,a,10\np,other,b,10\n",
+		"reports/short.csv":        "bucket,name\nsource\n",
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		content, ok := files[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return ioutil.NopCloser(strings.NewReader(content)), nil
+	}
+
+	testCases := []struct {
+		manifest  string
+		inventory *Inventory
+		errTest   errTestFunc
+	}{
+		{fmt.Sprintf(s3Manifest, "CSV"), &Inventory{Bucket: "source", Taken: time.Unix(1700000000, 0), Objects: []string{"tank|snap1.zstream.gz.vol1", "my file"}}, nilErrTest},
+		{fmt.Sprintf(s3Manifest, "ORC"), nil, nonNilErrTest},
+		{gcsManifest, &Inventory{Bucket: "source", Taken: time.Date(2023, 11, 14, 0, 0, 0, 0, time.UTC), Objects: []string{"tank|snap1.zstream.gz.vol1", "my file"}}, nilErrTest},
+		{`{"snapshot_time": "2023-11-14T00:00:00Z", "report_shards_file_names": ["other.csv"]}`, nil, nonNilErrTest},
+		{`{"snapshot_time": "2023-11-14T00:00:00Z", "report_shards_file_names": ["short.csv"]}`, nil, nonNilErrTest},
+		{`{"snapshot_time": "2023-11-14T00:00:00Z", "report_shards_file_names": ["missing.csv"]}`, nil, nonNilErrTest},
+		{`{"snapshot_time": "yesterday", "report_shards_file_names": ["shard_0.csv"]}`, nil, nonNilErrTest},
+		{`{"files": []}`, nil, nonNilErrTest},
+		{`not json`, nil, nonNilErrTest},
+	}
+
+	for idx, c := range testCases {
+		inventory, err := parseInventory(strings.NewReader(c.manifest), "reports", open)
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if inventory.Bucket != c.inventory.Bucket || !inventory.Taken.Equal(c.inventory.Taken) || !reflect.DeepEqual(inventory.Objects, c.inventory.Objects) {
+			t.Errorf("%d: expected %+v, got %+v", idx, c.inventory, inventory)
+		}
+	}
+}
+
+func TestInventoryBucket(t *testing.T) {
+	testCases := []struct {
+		target string
+		bucket string
+	}{
+		{"s3://bucket", "bucket"},
+		{"s3://bucket/prefix/", "bucket"},
+		{"gs://bucket/prefix", "bucket"},
+		{"azure://container", ""},
+		{"file:///backups", ""},
+	}
+
+	for idx, c := range testCases {
+		if bucket := inventoryBucket(c.target); bucket != c.bucket {
+			t.Errorf("%d: expected %q, got %q", idx, c.bucket, bucket)
+		}
+	}
+}
+
+func TestCleanInventory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupinventory")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = filepath.Join(dir, "work")
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	target := filepath.Join(dir, "target")
+	if err = os.MkdirAll(target, 0700); err != nil {
+		t.Fatalf("could not create target: %v", err)
+	}
+	taken := time.Now().Add(-time.Hour)
+
+	// Backup sets missing their only volume, finished before and after the inventory report was taken
+	var manifestPaths []string
+	for idx, finished := range []time.Time{taken.Add(-time.Hour), taken.Add(time.Minute)} {
+		snapshot := fmt.Sprintf("snap%d", idx+1)
+		manifest := &helpers.JobInfo{
+			VolumeName:     "tank",
+			BaseSnapshot:   helpers.SnapshotInfo{Name: snapshot},
+			StartTime:      finished.Add(-time.Minute),
+			EndTime:        finished,
+			Separator:      "|",
+			ManifestPrefix: "manifests",
+			Destinations:   []string{"file://" + target},
+			Volumes:        []*helpers.VolumeInfo{{ObjectName: "tank|" + snapshot + ".zstream.gz.vol1", VolumeNumber: 1}},
+		}
+		if _, err = getCacheDir(context.Background(), manifest.Destinations[0]); err != nil {
+			t.Fatalf("%d: could not create the cache: %v", idx, err)
+		}
+		vol, serr := saveManifest(context.Background(), manifest, true)
+		if serr != nil {
+			t.Fatalf("%d: could not save the manifest: %v", idx, serr)
+		}
+		manifestPath := filepath.Join(target, vol.ObjectName)
+		if err = vol.CopyTo(manifestPath); err != nil {
+			t.Fatalf("%d: could not store the manifest: %v", idx, err)
+		}
+		vol.DeleteVolume()
+		manifestPaths = append(manifestPaths, manifestPath)
+	}
+
+	// Objects not referenced by any backup set, only one of which is found in the inventory report
+	for _, name := range []string{"listed", "unlisted"} {
+		if err = ioutil.WriteFile(filepath.Join(target, name), []byte(name), 0600); err != nil {
+			t.Fatalf("could not write object: %v", err)
+		}
+	}
+
+	report := filepath.Join(dir, "inventory", "source", "config")
+	if err = os.MkdirAll(filepath.Join(report, "data"), 0700); err != nil {
+		t.Fatalf("could not create the report: %v", err)
+	}
+	if err = os.MkdirAll(filepath.Join(report, "2024-01-01T01-00Z"), 0700); err != nil {
+		t.Fatalf("could not create the report: %v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(report, "data", "a.csv.gz"), []byte(gzipped(t, "\"source\",\"listed\"\n")), 0600); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+	manifest := fmt.Sprintf(`{"sourceBucket": "source", "creationTimestamp": "%d", "fileFormat": "CSV", "fileSchema": "Bucket, Key", "files": [{"key": "source/config/data/a.csv.gz"}]}`, taken.UnixNano()/int64(time.Millisecond))
+	inventoryPath := filepath.Join(report, "2024-01-01T01-00Z", "manifest.json")
+	if err = ioutil.WriteFile(inventoryPath, []byte(manifest), 0600); err != nil {
+		t.Fatalf("could not write the report: %v", err)
+	}
+
+	j := &helpers.JobInfo{ManifestPrefix: "manifests", Destinations: []string{"file://" + target}, Force: true, Inventory: inventoryPath}
+	if _, err = Clean(context.Background(), j, false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := map[string]bool{manifestPaths[0]: false, manifestPaths[1]: true, filepath.Join(target, "listed"): false, filepath.Join(target, "unlisted"): true}
+	for path, exists := range expected {
+		if _, err = os.Stat(path); os.IsNotExist(err) == exists {
+			t.Errorf("expected %s to exist %v, got %v", path, exists, !os.IsNotExist(err))
+		}
+	}
+
+	j.Inventory = filepath.Join(dir, "missing.json")
+	if _, err = Clean(context.Background(), j, false); err == nil {
+		t.Errorf("expected an error reading a missing inventory report")
+	}
+}
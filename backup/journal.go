@@ -0,0 +1,157 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// manifestCompactInterval is the number of volumes recorded in the journal of a backup set before the
+// manifest saved to resume it is rewritten with them.
+const manifestCompactInterval = 32
+
+// manifestJournal records the volumes of a backup set that went through the entire pipeline, one small
+// record each, next to the manifest saved in the local cache of its first destination to resume it. The
+// manifest is only rewritten with the volumes recorded every manifestCompactInterval volumes, instead
+// of after every volume. A nil manifestJournal is valid and records nothing.
+type manifestJournal struct {
+	path    string
+	records int
+}
+
+// openManifestJournal will return the journal of the backup set described by j.
+func openManifestJournal(ctx context.Context, j *helpers.JobInfo) *manifestJournal {
+	if len(j.Destinations) == 0 {
+		return nil
+	}
+	return &manifestJournal{path: manifestJournalPath(ctx, j)}
+}
+
+func manifestJournalPath(ctx context.Context, j *helpers.JobInfo) string {
+	safeFolder := fmt.Sprintf("%x", md5.Sum([]byte(j.Destinations[0])))
+	safeManifestFile := fmt.Sprintf("%x", md5.Sum([]byte(helpers.ManifestObjectName(j))))
+	// Hidden so it is never taken for a manifest of the cache
+	return filepath.Join(helpers.EnvironmentOf(ctx).WorkingDir, "cache", safeFolder, "."+safeManifestFile+".journal")
+}
+
+// record will append the volume provided to the journal.
+func (m *manifestJournal) record(vol *helpers.VolumeInfo) error {
+	if m == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(vol)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(m.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	m.records++
+	return f.Close()
+}
+
+// due reports whether enough volumes were recorded for the manifest to be rewritten with them.
+func (m *manifestJournal) due() bool {
+	return m == nil || m.records >= manifestCompactInterval
+}
+
+// compacted will empty the journal once the manifest was rewritten with every volume recorded.
+func (m *manifestJournal) compacted() error {
+	if m == nil {
+		return nil
+	}
+
+	m.records = 0
+	if err := os.Remove(m.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readManifestJournal will return the volumes recorded in the journal found at path, none if there is no
+// journal. A record cut short by a crash while it was written ends the journal.
+func readManifestJournal(ctx context.Context, path string) ([]*helpers.VolumeInfo, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var volumes []*helpers.VolumeInfo
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		vol := new(helpers.VolumeInfo)
+		if err = json.Unmarshal(scanner.Bytes(), vol); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Ignoring the rest of the manifest journal %s from its record %d - %v", path, len(volumes)+1, err)
+			break
+		}
+		volumes = append(volumes, vol)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// replayManifestJournal will add the volumes recorded in the journal of the backup set described by j
+// that are missing from its volumes, returning how many were added.
+func replayManifestJournal(ctx context.Context, j *helpers.JobInfo) (int, error) {
+	volumes, err := readManifestJournal(ctx, manifestJournalPath(ctx, j))
+	if err != nil {
+		return 0, err
+	}
+
+	known := make(map[int64]bool, len(j.Volumes))
+	for _, vol := range j.Volumes {
+		known[vol.VolumeNumber] = true
+	}
+	added := 0
+	for _, vol := range volumes {
+		if !known[vol.VolumeNumber] {
+			known[vol.VolumeNumber] = true
+			j.Volumes = append(j.Volumes, vol)
+			added++
+		}
+	}
+	return added, nil
+}
@@ -0,0 +1,116 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestManifestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupjournal")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = dir
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	newJob := func(volumes ...int64) *helpers.JobInfo {
+		j := &helpers.JobInfo{
+			VolumeName:     "Tank/Data",
+			BaseSnapshot:   helpers.SnapshotInfo{Name: "snap1"},
+			Compressor:     helpers.InternalCompressor,
+			Separator:      "|",
+			ManifestPrefix: "manifests",
+			Destinations:   []string{"file:///backups"},
+		}
+		for _, num := range volumes {
+			j.Volumes = append(j.Volumes, &helpers.VolumeInfo{VolumeNumber: num})
+		}
+		return j
+	}
+
+	testCases := []struct {
+		recorded []int64
+		known    []int64
+		torn     bool
+		replayed int
+		total    int
+	}{
+		{nil, []int64{1, 2}, false, 0, 2},
+		{[]int64{3, 4}, []int64{1, 2}, false, 2, 4},
+		// Volumes already in the manifest are not added again
+		{[]int64{2, 3}, []int64{1, 2}, false, 1, 3},
+		// A record cut short by a crash ends the journal
+		{[]int64{3, 4}, []int64{1, 2}, true, 2, 4},
+	}
+
+	for idx, c := range testCases {
+		journal := openManifestJournal(context.Background(), newJob())
+		if err = journal.compacted(); err != nil {
+			t.Fatalf("%d: could not empty the journal: %v", idx, err)
+		}
+		if err = os.MkdirAll(filepath.Dir(journal.path), 0700); err != nil {
+			t.Fatalf("%d: could not create the cache dir: %v", idx, err)
+		}
+		for _, num := range c.recorded {
+			vol := &helpers.VolumeInfo{ObjectName: fmt.Sprintf("Tank/Data|snap1.zstream.gz.vol%d", num), VolumeNumber: num, SHA256Sum: "sum"}
+			if err = journal.record(vol); err != nil {
+				t.Fatalf("%d: could not record volume %d: %v", idx, num, err)
+			}
+		}
+		if c.torn {
+			f, oerr := os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND, 0600)
+			if oerr != nil {
+				t.Fatalf("%d: could not open the journal: %v", idx, oerr)
+			}
+			f.Write([]byte(`{"ObjectName":"Tank/Data|snap1.zstream.gz.vol5","Volu`))
+			f.Close()
+		}
+		if journal.due() != (len(c.recorded) >= manifestCompactInterval) {
+			t.Errorf("%d: unexpected due after %d records", idx, len(c.recorded))
+		}
+
+		j := newJob(c.known...)
+		replayed, rerr := replayManifestJournal(context.Background(), j)
+		if rerr != nil {
+			t.Errorf("%d: unexpected error %v", idx, rerr)
+			continue
+		}
+		if replayed != c.replayed || len(j.Volumes) != c.total {
+			t.Errorf("%d: expected %d volumes replayed for %d in total, got %d for %d", idx, c.replayed, c.total, replayed, len(j.Volumes))
+		}
+		for _, vol := range j.Volumes[len(c.known):] {
+			if vol.SHA256Sum != "sum" || vol.ObjectName == "" {
+				t.Errorf("%d: volume %d was not replayed as recorded: %+v", idx, vol.VolumeNumber, vol)
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// LeasePrefix is the prefix of the lease objects a send holds at its destinations.
+const LeasePrefix = "leases"
+
+// ErrLeaseHeld is returned when another send holds the lease of a dataset at a destination.
+var ErrLeaseHeld = errors.New("the lease of this dataset at the destination is held by another backup")
+
+// errLeaseLost is returned when renewing a lease that was taken over by another send.
+var errLeaseLost = errors.New("the lease was taken over by another backup")
+
+// leaseSettleTime is how long we wait after writing a lease before reading it back to make sure
+// another send did not write its own lease at the same time.
+var leaseSettleTime = 2 * time.Second
+
+// Lease is the object a send holds at a destination, so that sends of the same dataset from several hosts
+// (e.g. an HA pair) never interleave their backup sets. It must be renewed before it expires or another send
+// can take it over.
+type Lease struct {
+	Host     string
+	PID      int
+	Token    string
+	Acquired time.Time
+	Expires  time.Time
+}
+
+// String will return a human readable description of who holds this Lease.
+func (l *Lease) String() string {
+	return fmt.Sprintf("%s (pid %d) since %s, expiring %s", l.Host, l.PID, l.Acquired.Format(time.RFC3339), l.Expires.Format(time.RFC3339))
+}
+
+// leaseObjectName will return the name of the lease object of the dataset described by j.
+func leaseObjectName(j *helpers.JobInfo) string {
+	return fmt.Sprintf("%s%s%s.lease", LeasePrefix, j.Separator, j.VolumeName)
+}
+
+// heldLease is a Lease held at a destination.
+type heldLease struct {
+	lease       Lease
+	objectName  string
+	destination string
+	backend     backends.Backend
+}
+
+// acquireLeases will acquire the lease of the dataset described by j at every one of its destinations and keep
+// renewing them until the returned function is called, at which point they are released. If a lease is taken
+// over by another send, or cannot be renewed before it expires, cancel is called.
+func acquireLeases(ctx context.Context, j *helpers.JobInfo, cancel context.CancelFunc) (func(), error) {
+	var held []*heldLease
+	release := func() {
+		for _, h := range held {
+			h.release(context.Background())
+			h.backend.Close()
+		}
+	}
+
+	for _, destination := range j.Destinations {
+		backend, err := prepareBackend(ctx, j, destination, make(chan bool, 1))
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		h, err := acquireLease(ctx, j, backend, destination)
+		if err != nil {
+			backend.Close()
+			release()
+			return nil, err
+		}
+		held = append(held, h)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(j.LeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, h := range held {
+					if err := h.renew(ctx, j.LeaseTTL); err == errLeaseLost || time.Now().After(h.lease.Expires) {
+						helpers.EnvironmentOf(ctx).Logger.Errorf("Lost the lease %s at %s, stopping the backup - %v", h.objectName, h.destination, err)
+						cancel()
+						return
+					} else if err != nil {
+						helpers.EnvironmentOf(ctx).Logger.Warningf("Could not renew the lease %s at %s, will retry - %v", h.objectName, h.destination, err)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+		release()
+	}, nil
+}
+
+// acquireLease will take the lease of the dataset described by j at the destination, unless it is held by
+// another send and is not expired. A lease that is not expired is only taken over if j.StealLease is set.
+func acquireLease(ctx context.Context, j *helpers.JobInfo, backend backends.Backend, destination string) (*heldLease, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, 16)
+	if _, err = rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	h := &heldLease{
+		lease:       Lease{Host: host, PID: os.Getpid(), Token: hex.EncodeToString(token), Acquired: time.Now()},
+		objectName:  leaseObjectName(j),
+		destination: destination,
+		backend:     backend,
+	}
+
+	current, err := readLease(ctx, backend, h.objectName)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil {
+		if time.Now().Before(current.Expires) && !j.StealLease {
+			return nil, fmt.Errorf("%w: %s at %s is held by %v, use --stealLease to take it over", ErrLeaseHeld, h.objectName, destination, current)
+		}
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Taking over the lease %s at %s held by %v.", h.objectName, destination, current)
+	}
+
+	h.lease.Expires = time.Now().Add(j.LeaseTTL)
+	if err = writeLease(ctx, backend, h.objectName, &h.lease); err != nil {
+		return nil, err
+	}
+
+	// Make sure another send did not take the lease at the same time
+	select {
+	case <-time.After(leaseSettleTime):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if current, err = readLease(ctx, backend, h.objectName); err != nil {
+		return nil, err
+	} else if current == nil || current.Token != h.lease.Token {
+		return nil, fmt.Errorf("%w: %s at %s was taken by another backup at the same time", ErrLeaseHeld, h.objectName, destination)
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Infof("Acquired the lease %s at %s until %s.", h.objectName, destination, h.lease.Expires.Format(time.RFC3339))
+	return h, nil
+}
+
+// renew will extend the lease by ttl as long as it is still ours.
+func (h *heldLease) renew(ctx context.Context, ttl time.Duration) error {
+	current, err := readLease(ctx, h.backend, h.objectName)
+	if err != nil {
+		return err
+	} else if current == nil || current.Token != h.lease.Token {
+		return errLeaseLost
+	}
+
+	renewed := h.lease
+	renewed.Expires = time.Now().Add(ttl)
+	if err = writeLease(ctx, h.backend, h.objectName, &renewed); err != nil {
+		return err
+	}
+	h.lease = renewed
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Renewed the lease %s at %s until %s.", h.objectName, h.destination, h.lease.Expires.Format(time.RFC3339))
+	return nil
+}
+
+// release will delete the lease as long as it is still ours.
+func (h *heldLease) release(ctx context.Context) {
+	current, err := readLease(ctx, h.backend, h.objectName)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Could not read the lease %s at %s to release it, it will expire on its own - %v", h.objectName, h.destination, err)
+		return
+	} else if current == nil || current.Token != h.lease.Token {
+		return
+	}
+
+	if err = h.backend.Delete(ctx, h.objectName); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Could not release the lease %s at %s, it will expire on its own - %v", h.objectName, h.destination, err)
+		return
+	}
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Released the lease %s at %s.", h.objectName, h.destination)
+}
+
+// readLease will return the lease stored as objectName, or nil if there is none.
+func readLease(ctx context.Context, backend backends.Backend, objectName string) (*Lease, error) {
+	objects, err := backend.List(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, object := range objects {
+		found = found || object == objectName
+	}
+	if !found {
+		return nil, nil
+	}
+
+	r, err := backend.Download(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	lease := new(Lease)
+	if err = json.NewDecoder(r).Decode(lease); err != nil {
+		return nil, fmt.Errorf("could not decode the lease %s: %v", objectName, err)
+	}
+	return lease, nil
+}
+
+// writeLease will store the lease as objectName.
+func writeLease(ctx context.Context, backend backends.Backend, objectName string, lease *Lease) error {
+	vol, err := helpers.CreateSimpleVolume(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer vol.DeleteVolume()
+
+	if err = json.NewEncoder(vol).Encode(lease); err != nil {
+		vol.Close()
+		return err
+	}
+	if err = vol.Close(); err != nil {
+		return err
+	}
+	vol.ObjectName = objectName
+
+	if err = vol.OpenVolume(); err != nil {
+		return err
+	}
+	defer vol.Close()
+	return backend.Upload(ctx, vol)
+}
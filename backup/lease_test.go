@@ -0,0 +1,95 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestLeases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackuplease")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldSettleTime := leaseSettleTime
+	leaseSettleTime = 0
+	defer func() { leaseSettleTime = oldSettleTime }()
+
+	ctx := context.Background()
+	destination := "file://" + dir
+	j := &helpers.JobInfo{VolumeName: "tank/data", Separator: "|", LeaseTTL: time.Minute, MaxParallelUploads: 1}
+	backend, err := prepareBackend(ctx, j, destination, make(chan bool, 1))
+	if err != nil {
+		t.Fatalf("could not prepare backend: %v", err)
+	}
+
+	first, err := acquireLease(ctx, j, backend, destination)
+	if err != nil {
+		t.Fatalf("could not acquire lease: %v", err)
+	}
+	if err = first.renew(ctx, j.LeaseTTL); err != nil {
+		t.Errorf("could not renew lease: %v", err)
+	}
+
+	if _, err = acquireLease(ctx, j, backend, destination); !errors.Is(err, ErrLeaseHeld) {
+		t.Errorf("expected %v acquiring a held lease, got %v", ErrLeaseHeld, err)
+	}
+
+	steal := *j
+	steal.StealLease = true
+	second, err := acquireLease(ctx, &steal, backend, destination)
+	if err != nil {
+		t.Fatalf("could not steal lease: %v", err)
+	}
+	if err = first.renew(ctx, j.LeaseTTL); err != errLeaseLost {
+		t.Errorf("expected %v renewing a stolen lease, got %v", errLeaseLost, err)
+	}
+	// Must not release a lease we no longer hold
+	first.release(ctx)
+	if lease, lerr := readLease(ctx, backend, second.objectName); lerr != nil || lease == nil || lease.Token != second.lease.Token {
+		t.Errorf("expected the stolen lease to be kept, got %v (%v)", lease, lerr)
+	}
+
+	// An expired lease can be taken over
+	expired := second.lease
+	expired.Expires = time.Now().Add(-time.Second)
+	if err = writeLease(ctx, backend, second.objectName, &expired); err != nil {
+		t.Fatalf("could not write lease: %v", err)
+	}
+	third, err := acquireLease(ctx, j, backend, destination)
+	if err != nil {
+		t.Fatalf("could not take over expired lease: %v", err)
+	}
+
+	third.release(ctx)
+	if lease, lerr := readLease(ctx, backend, third.objectName); lerr != nil || lease != nil {
+		t.Errorf("expected the lease to be released, got %v (%v)", lease, lerr)
+	}
+}
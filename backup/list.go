@@ -30,43 +30,142 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dustin/go-humanize"
+
 	"github.com/someone1/zfsbackup-go/helpers"
 )
 
 // List will sync the manifests found in the target destination to the local cache
 // and then read and output the manifest information describing the backup sets
-// found in the target destination.
-// TODO: Group by volume name?
-func List(pctx context.Context, jobInfo *helpers.JobInfo, startswith string, before, after time.Time) error {
+// found in the target destination. Unless details is true, the backup sets are output as a table
+// showing those with a broken chain and, if maxAge is not 0, the volumes not backed up for longer.
+func List(pctx context.Context, jobInfo *helpers.JobInfo, startswith string, before, after time.Time, maxAge time.Duration, details bool) error {
 	ctx, cancel := context.WithCancel(pctx)
 	defer cancel()
 
-	// Prepare the backend client
-	target := jobInfo.Destinations[0]
-	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
-	if berr != nil {
-		helpers.AppLogger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
-		return berr
+	decodedManifests, localOnlyManifests, err := listBackupSets(ctx, jobInfo, startswith, before, after)
+	if err != nil {
+		return err
+	}
+
+	if !helpers.EnvironmentOf(ctx).JSONOutput {
+		var output []string
+
+		output = append(output, fmt.Sprintf("Found %d backup sets:\n", len(decodedManifests)))
+		if details {
+			for _, manifest := range decodedManifests {
+				output = append(output, manifest.String())
+			}
+		} else if len(decodedManifests) > 0 {
+			output = append(output, backupSetsTable(decodedManifests, maxAge))
+		}
+
+		if len(localOnlyManifests) > 0 {
+			output = append(output, fmt.Sprintf("There are %d manifests found locally that are not on the target destination.", len(localOnlyManifests)))
+			localOnlyOuput := []string{"The following manifests were found locally and can be removed using the clean command."}
+			for _, manifest := range localOnlyManifests {
+				localOnlyOuput = append(localOnlyOuput, manifest.String())
+			}
+			helpers.EnvironmentOf(ctx).Logger.Infof(strings.Join(localOnlyOuput, "\n"))
+		}
+		fmt.Fprintln(helpers.EnvironmentOf(ctx).Stdout, strings.Join(output, "\n"))
+	} else {
+		organizedManifests := linkManifests(ctx, decodedManifests)
+		j, jerr := json.Marshal(organizedManifests)
+		if jerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("could not marshal results to JSON - %v", jerr)
+			return jerr
+		}
+
+		fmt.Fprintln(helpers.EnvironmentOf(ctx).Stdout, string(j))
 	}
-	defer backend.Close()
 
+	return nil
+}
+
+// backupSetsTable will format the backup sets, sorted by volume and snapshot creation time, as a table.
+func backupSetsTable(sets []*helpers.JobInfo, maxAge time.Duration) string {
+	restorable := newChainGraph(sets).restorable()
+	table := helpers.NewTable("VOLUME", "SNAPSHOT", "INCREMENTAL FROM", "CREATED", "VOLUMES", "SIZE", "RATIO", "TOOK", "STATUS")
+	for idx, set := range sets {
+		var status []string
+		if !restorable[set] {
+			status = append(status, helpers.Colorize(helpers.ColorRed, "broken chain"))
+		}
+		// The last backup set of a volume is its most recent one
+		latest := idx == len(sets)-1 || sets[idx+1].VolumeName != set.VolumeName
+		if latest && maxAge > 0 && time.Since(set.BaseSnapshot.CreationTime) > maxAge {
+			status = append(status, helpers.Colorize(helpers.ColorYellow, "overdue"))
+		}
+		if set.External != "" {
+			status = append(status, helpers.Colorize(helpers.ColorYellow, "adopted from "+set.External))
+		}
+		if len(status) == 0 {
+			status = append(status, helpers.Colorize(helpers.ColorGreen, "ok"))
+		}
+
+		incremental := "-"
+		if set.IncrementalSnapshot.Name != "" {
+			incremental = set.IncrementalSnapshot.Name
+		}
+		stats := set.BackupStats()
+		table.Row(
+			set.VolumeName,
+			set.BaseSnapshot.Name,
+			incremental,
+			set.BaseSnapshot.CreationTime.Local().Format(time.RFC3339),
+			fmt.Sprintf("%d", len(set.Volumes)),
+			humanize.IBytes(set.TotalBytesWritten()),
+			fmt.Sprintf("%.2fx", stats.CompressionRatio),
+			stats.WallTime.Round(time.Second).String(),
+			strings.Join(status, ", "),
+		)
+	}
+
+	var buf strings.Builder
+	table.WriteTo(&buf)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// ListBackupSets will sync the manifests found in the target destination to the local cache
+// and return the backup sets they describe, filtered the same way List filters its output.
+func ListBackupSets(ctx context.Context, jobInfo *helpers.JobInfo, startswith string, before, after time.Time) ([]*helpers.JobInfo, error) {
+	decodedManifests, _, err := listBackupSets(ctx, jobInfo, startswith, before, after)
+	return decodedManifests, err
+}
+
+// listBackupSets returns the filtered backup sets found in the target along with the
+// manifests found only in the local cache.
+func listBackupSets(ctx context.Context, jobInfo *helpers.JobInfo, startswith string, before, after time.Time) ([]*helpers.JobInfo, []*helpers.JobInfo, error) {
 	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(jobInfo.Destinations[0])
+	target := jobInfo.Destinations[0]
+	localCachePath, cerr := getCacheDir(ctx, target)
 	if cerr != nil {
-		helpers.AppLogger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
-		return cerr
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		return nil, nil, cerr
 	}
 
-	// Sync the local cache
-	safeManifests, localOnlyFiles, serr := syncCache(ctx, jobInfo, localCachePath, backend)
-	if serr != nil {
-		helpers.AppLogger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
-		return serr
+	// Prepare the backend client and sync the local cache, or fall back to the local cache
+	// alone when the destination can't be reached
+	var safeManifests, localOnlyFiles []string
+	backend, err := prepareBackend(ctx, jobInfo, target, nil)
+	if err == nil {
+		defer backend.Close()
+		safeManifests, localOnlyFiles, err = syncCache(ctx, jobInfo, localCachePath, backend)
+	}
+	if err != nil {
+		if helpers.KindOf(err) != helpers.ErrorKindBackend {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, err)
+			return nil, nil, err
+		}
+		if safeManifests, err = offlineManifests(ctx, localCachePath, target, err); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	decodedManifests, derr := readAndSortManifests(ctx, localCachePath, safeManifests, jobInfo)
 	if derr != nil {
-		return derr
+		return nil, nil, derr
 	}
 
 	// Filter Manifests to only results we care about
@@ -93,72 +192,51 @@ func List(pctx context.Context, jobInfo *helpers.JobInfo, startswith string, bef
 		filteredResults = append(filteredResults, manifest)
 	}
 
-	decodedManifests = filteredResults
-
-	if !helpers.JSONOutput {
-		var output []string
-
-		output = append(output, fmt.Sprintf("Found %d backup sets:\n", len(decodedManifests)))
-		for _, manifest := range decodedManifests {
-			output = append(output, manifest.String())
-		}
-
-		if len(localOnlyFiles) > 0 {
-			output = append(output, fmt.Sprintf("There are %d manifests found locally that are not on the target destination.", len(localOnlyFiles)))
-			localOnlyOuput := []string{"The following manifests were found locally and can be removed using the clean command."}
-			for _, filename := range localOnlyFiles {
-				manifestPath := filepath.Join(localCachePath, filename)
-				decodedManifest, derr := readManifest(ctx, manifestPath, jobInfo)
-				if derr != nil {
-					helpers.AppLogger.Warningf("Could not read local only manifest %s due to error %v", manifestPath, derr)
-					continue
-				}
-				localOnlyOuput = append(localOnlyOuput, decodedManifest.String())
-			}
-			helpers.AppLogger.Infof(strings.Join(localOnlyOuput, "\n"))
-		}
-		fmt.Fprintln(helpers.Stdout, strings.Join(output, "\n"))
-	} else {
-		organizedManifests := linkManifests(decodedManifests)
-		j, jerr := json.Marshal(organizedManifests)
-		if jerr != nil {
-			helpers.AppLogger.Errorf("could not marshal results to JSON - %v", jerr)
-			return jerr
+	var localOnlyManifests []*helpers.JobInfo
+	for _, filename := range localOnlyFiles {
+		manifestPath := filepath.Join(localCachePath, filename)
+		decodedManifest, derr := readManifest(ctx, manifestPath, jobInfo)
+		if derr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not read local only manifest %s due to error %v", manifestPath, derr)
+			continue
 		}
-
-		fmt.Fprintln(helpers.Stdout, string(j))
+		localOnlyManifests = append(localOnlyManifests, decodedManifest)
 	}
 
-	return nil
+	return filteredResults, localOnlyManifests, nil
 }
 
 func readAndSortManifests(ctx context.Context, localCachePath string, manifests []string, jobInfo *helpers.JobInfo) ([]*helpers.JobInfo, error) {
 	// Read in Manifests and display
-	decodedManifests := make([]*helpers.JobInfo, 0, len(manifests))
-	for _, manifest := range manifests {
-		manifestPath := filepath.Join(localCachePath, manifest)
-		decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo)
-		if oerr != nil {
-			helpers.AppLogger.Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
-			return nil, oerr
-		}
-		decodedManifests = append(decodedManifests, decodedManifest)
+	decodedManifests, err := readManifests(ctx, localCachePath, manifests, jobInfo)
+	if err != nil {
+		return nil, err
 	}
 
-	sort.SliceStable(decodedManifests, func(i, j int) bool {
-		cmp := strings.Compare(decodedManifests[i].VolumeName, decodedManifests[j].VolumeName)
+	sortManifests(decodedManifests)
+	return decodedManifests, nil
+}
+
+// sortManifests will sort the manifests by volume and then by the creation time of their snapshot.
+func sortManifests(manifests []*helpers.JobInfo) {
+	sort.SliceStable(manifests, func(i, j int) bool {
+		cmp := strings.Compare(manifests[i].VolumeName, manifests[j].VolumeName)
 		if cmp == 0 {
-			return decodedManifests[i].BaseSnapshot.CreationTime.Before(decodedManifests[j].BaseSnapshot.CreationTime)
+			return manifests[i].BaseSnapshot.CreationTime.Before(manifests[j].BaseSnapshot.CreationTime)
 		}
 		return cmp < 0
-
 	})
+}
 
-	return decodedManifests, nil
+// LinkBackupSets will group the backup sets by volume and link every incremental backup set
+// to the backup set it was taken from, when found. Incremental backup sets left without a
+// ParentSnap have a broken chain and cannot be restored.
+func LinkBackupSets(ctx context.Context, sets []*helpers.JobInfo) map[string][]*helpers.JobInfo {
+	return linkManifests(ctx, sets)
 }
 
 // linkManifests will group manifests by Volume and link parents to their children
-func linkManifests(manifests []*helpers.JobInfo) map[string][]*helpers.JobInfo {
+func linkManifests(ctx context.Context, manifests []*helpers.JobInfo) map[string][]*helpers.JobInfo {
 	if manifests == nil {
 		return nil
 	}
@@ -192,7 +270,7 @@ func linkManifests(manifests []*helpers.JobInfo) map[string][]*helpers.JobInfo {
 			if psnap, ok := manifestsByID[manifestID]; ok {
 				val.ParentSnap = psnap
 			} else {
-				helpers.AppLogger.Warningf("Could not find matching parent for %v", val)
+				helpers.EnvironmentOf(ctx).Logger.Warningf("Could not find matching parent for %v", val)
 			}
 		}
 
@@ -200,18 +278,49 @@ func linkManifests(manifests []*helpers.JobInfo) map[string][]*helpers.JobInfo {
 	return manifestTree
 }
 
+// readManifests will read the manifests provided from the local cache, jobInfo.ManifestWorkers at
+// a time, and return them in the same order.
+func readManifests(ctx context.Context, localCachePath string, manifests []string, jobInfo *helpers.JobInfo) ([]*helpers.JobInfo, error) {
+	decodedManifests := make([]*helpers.JobInfo, len(manifests))
+	err := forEachManifest(ctx, jobInfo, len(manifests), func(ctx context.Context, idx int) error {
+		manifestPath := filepath.Join(localCachePath, manifests[idx])
+		decodedManifest, oerr := readManifest(ctx, manifestPath, jobInfo)
+		if oerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read manifest %s due to error - %v", manifestPath, oerr)
+			return oerr
+		}
+		decodedManifests[idx] = decodedManifest
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodedManifests, nil
+}
+
 func readManifest(ctx context.Context, manifestPath string, j *helpers.JobInfo) (*helpers.JobInfo, error) {
+	decodedManifest, _, err := readMigratedManifest(ctx, manifestPath, j)
+	return decodedManifest, err
+}
+
+// readMigratedManifest will read the manifest, migrated to the current schema, and report whether it was
+// written with an older one.
+func readMigratedManifest(ctx context.Context, manifestPath string, j *helpers.JobInfo) (*helpers.JobInfo, bool, error) {
 	decodedManifest := new(helpers.JobInfo)
 	manifestVol, err := helpers.ExtractLocal(ctx, j, manifestPath, true)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer manifestVol.Close()
 	decoder := json.NewDecoder(manifestVol)
 	err = decoder.Decode(decodedManifest)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	return decodedManifest, nil
+	migrated, err := helpers.MigrateManifest(decodedManifest)
+	if err != nil {
+		return nil, false, err
+	}
+	return decodedManifest, migrated, nil
 }
@@ -0,0 +1,109 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestBackupSetsTable(t *testing.T) {
+	now := time.Now()
+	snap := func(name string, age time.Duration) helpers.SnapshotInfo {
+		return helpers.SnapshotInfo{Name: name, CreationTime: now.Add(-age)}
+	}
+	backup := func(volume string, base, incremental helpers.SnapshotInfo) *helpers.JobInfo {
+		return &helpers.JobInfo{VolumeName: volume, BaseSnapshot: base, IncrementalSnapshot: incremental}
+	}
+	snap1, snap2, snap3 := snap("snap1", 72*time.Hour), snap("snap2", 48*time.Hour), snap("snap3", time.Hour)
+	full1 := backup("tank/data", snap1, helpers.SnapshotInfo{})
+	incr2 := backup("tank/data", snap2, snap1)
+	orphan := backup("tank/data", snap3, snap("snap0", 96*time.Hour))
+	other := backup("tank/other", snap1, helpers.SnapshotInfo{})
+
+	testCases := []struct {
+		sets     []*helpers.JobInfo
+		maxAge   time.Duration
+		expected []string
+	}{
+		{[]*helpers.JobInfo{full1, incr2}, 0, []string{"ok", "ok"}},
+		// Only the most recent backup set of a volume can be overdue
+		{[]*helpers.JobInfo{full1, incr2}, 24 * time.Hour, []string{"ok", "overdue"}},
+		{[]*helpers.JobInfo{full1, incr2, other}, 60 * time.Hour, []string{"ok", "ok", "overdue"}},
+		{[]*helpers.JobInfo{full1, incr2, orphan}, 24 * time.Hour, []string{"ok", "ok", "broken chain"}},
+		{[]*helpers.JobInfo{orphan}, 30 * time.Minute, []string{"broken chain, overdue"}},
+	}
+
+	for idx, c := range testCases {
+		lines := strings.Split(backupSetsTable(c.sets, c.maxAge), "\n")
+		if len(lines) != len(c.expected)+1 {
+			t.Errorf("%d: expected %d lines, got %d", idx, len(c.expected)+1, len(lines))
+			continue
+		}
+		statusColumn := strings.Index(lines[0], "STATUS")
+		for row, expected := range c.expected {
+			if got := lines[row+1][statusColumn:]; got != expected {
+				t.Errorf("%d: expected backup set %s to have status %s, got %s", idx, restorePointName(c.sets[row]), expected, got)
+			}
+		}
+	}
+}
+
+func TestBackupSetsTableStats(t *testing.T) {
+	start := time.Now()
+	// A backup set sent before its statistics were recorded has them computed from its manifest
+	computed := &helpers.JobInfo{
+		VolumeName:     "tank/data",
+		BaseSnapshot:   helpers.SnapshotInfo{Name: "snap1"},
+		ZFSStreamBytes: 3000,
+		Volumes:        []*helpers.VolumeInfo{{Size: 1000}},
+		StartTime:      start,
+		EndTime:        start.Add(90 * time.Second),
+	}
+	recorded := &helpers.JobInfo{
+		VolumeName:   "tank/other",
+		BaseSnapshot: helpers.SnapshotInfo{Name: "snap1"},
+		Stats:        &helpers.BackupStats{CompressionRatio: 1.5, WallTime: time.Hour},
+	}
+
+	testCases := []struct {
+		set   *helpers.JobInfo
+		ratio string
+		took  string
+	}{
+		{computed, "3.00x", "1m30s"},
+		{recorded, "1.50x", "1h0m0s"},
+	}
+
+	for idx, c := range testCases {
+		lines := strings.Split(backupSetsTable([]*helpers.JobInfo{c.set}, 0), "\n")
+		ratio, took := strings.Index(lines[0], "RATIO"), strings.Index(lines[0], "TOOK")
+		if got := strings.TrimSpace(lines[1][ratio:took]); got != c.ratio {
+			t.Errorf("%d: expected a compression ratio of %s, got %s", idx, c.ratio, got)
+		}
+		if got := strings.Fields(lines[1][took:])[0]; got != c.took {
+			t.Errorf("%d: expected the backup set to have taken %s, got %s", idx, c.took, got)
+		}
+	}
+}
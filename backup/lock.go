@@ -0,0 +1,91 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// ErrAlreadyRunning is returned when a backup of the same dataset to the same destinations is already running.
+var ErrAlreadyRunning = errors.New("a backup of this dataset to these destinations is already running")
+
+// jobLock is an exclusive lock on a dataset and its destinations, held for as long as the backup runs.
+// The lock is released by the operating system if the process dies, so it never goes stale.
+type jobLock struct {
+	f *os.File
+}
+
+// jobKey identifies the backups of a dataset to a set of destinations, regardless of their order.
+func jobKey(volume string, destinations []string) string {
+	sorted := append([]string(nil), destinations...)
+	sort.Strings(sorted)
+	return fmt.Sprintf("%s|%s", volume, strings.Join(sorted, ","))
+}
+
+// lockJob will take the lock of the backups of the volume to the destinations without waiting for it,
+// returning an error wrapping ErrAlreadyRunning if another process holds it.
+func lockJob(ctx context.Context, volume string, destinations []string) (*jobLock, error) {
+	dir := filepath.Join(helpers.EnvironmentOf(ctx).WorkingDir, "locks")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create lock directory %s due to error: %v", dir, err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%x.lck", md5.Sum([]byte(jobKey(volume, destinations)))))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == syscall.EWOULDBLOCK {
+		pid, _ := ioutil.ReadAll(f)
+		f.Close()
+		return nil, fmt.Errorf("%w (pid %s, lock file %s)", ErrAlreadyRunning, strings.TrimSpace(string(pid)), path)
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Record who holds the lock for the benefit of whoever fails to take it
+	if err = f.Truncate(0); err == nil {
+		_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+	}
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Could not write our pid to the lock file %s - %v", path, err)
+	}
+	return &jobLock{f: f}, nil
+}
+
+// Unlock will release the lock.
+func (l *jobLock) Unlock() error {
+	defer l.f.Close()
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+}
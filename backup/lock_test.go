@@ -0,0 +1,98 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestJobKey(t *testing.T) {
+	testCases := []struct {
+		volume       string
+		destinations []string
+		expect       string
+	}{
+		{"tank/data", []string{"gs://bucket"}, "tank/data|gs://bucket"},
+		{"tank/data", []string{"s3://bucket", "gs://bucket"}, "tank/data|gs://bucket,s3://bucket"},
+		{"tank/data", nil, "tank/data|"},
+	}
+
+	for idx, c := range testCases {
+		if got := jobKey(c.volume, c.destinations); got != c.expect {
+			t.Errorf("%d: expected %s, got %s", idx, c.expect, got)
+		}
+	}
+}
+
+func TestLockJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackuplock")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = dir
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	lock, err := lockJob(context.Background(), "tank/data", []string{"gs://bucket", "s3://bucket"})
+	if err != nil {
+		t.Fatalf("could not lock job: %v", err)
+	}
+
+	testCases := []struct {
+		volume       string
+		destinations []string
+		locked       bool
+	}{
+		{"tank/data", []string{"gs://bucket", "s3://bucket"}, true},
+		{"tank/data", []string{"s3://bucket", "gs://bucket"}, true},
+		{"tank/data", []string{"gs://bucket"}, false},
+		{"tank/other", []string{"gs://bucket", "s3://bucket"}, false},
+	}
+
+	for idx, c := range testCases {
+		other, lerr := lockJob(context.Background(), c.volume, c.destinations)
+		if c.locked && !errors.Is(lerr, ErrAlreadyRunning) {
+			t.Errorf("%d: expected %v, got %v", idx, ErrAlreadyRunning, lerr)
+		} else if !c.locked {
+			if lerr != nil {
+				t.Errorf("%d: expected no error, got %v", idx, lerr)
+			} else {
+				other.Unlock()
+			}
+		}
+	}
+
+	if err = lock.Unlock(); err != nil {
+		t.Fatalf("could not unlock job: %v", err)
+	}
+	if lock, err = lockJob(context.Background(), "tank/data", []string{"gs://bucket", "s3://bucket"}); err != nil {
+		t.Fatalf("could not lock job again: %v", err)
+	}
+	lock.Unlock()
+}
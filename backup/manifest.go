@@ -0,0 +1,405 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cenkalti/backoff"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// ExportManifests will sync the manifests found in the target destination to the local cache and write
+// every one of them, decrypted and decompressed, as an indented JSON file to the directory dir.
+func ExportManifests(pctx context.Context, jobInfo *helpers.JobInfo, dir string) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	target := jobInfo.Destinations[0]
+	backend, err := prepareBackend(ctx, jobInfo, target, nil)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return err
+	}
+	defer backend.Close()
+
+	localCachePath, err := getCacheDir(ctx, target)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, err)
+		return err
+	}
+
+	objectNames, err := backend.List(ctx, jobInfo.ManifestPrefix)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list manifest files from target %s due to error - %v.", target, err)
+		return err
+	}
+	objectNames = currentManifests(objectNames)
+
+	if _, _, err = syncCache(ctx, jobInfo, localCachePath, backend); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, err)
+		return err
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not create directory %s due to error - %v.", dir, err)
+		return err
+	}
+
+	for _, objectName := range objectNames {
+		manifestPath := filepath.Join(localCachePath, fmt.Sprintf("%x", md5.Sum([]byte(objectName))))
+		manifest, rerr := readManifest(ctx, manifestPath, jobInfo)
+		if rerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read manifest %s due to error - %v", objectName, rerr)
+			return rerr
+		}
+
+		exportPath := filepath.Join(dir, exportFileName(objectName))
+		if err = writeManifestFile(exportPath, manifest); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not export manifest %s to %s due to error - %v", objectName, exportPath, err)
+			return err
+		}
+		helpers.EnvironmentOf(ctx).Logger.Infof("Exported manifest %s to %s.", objectName, exportPath)
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Exported %d manifests from %s to %s.", len(objectNames), target, dir)
+	return nil
+}
+
+// ImportManifests will read every JSON file found in the directory dir as the manifest of a backup set, as
+// written by ExportManifests, and upload it to every destination provided in jobInfo, compressed, encrypted,
+// and signed according to jobInfo. A manifest already found in a destination is replaced.
+func ImportManifests(pctx context.Context, jobInfo *helpers.JobInfo, dir string) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list files in %s due to error - %v.", dir, err)
+		return err
+	}
+
+	var manifests []*helpers.JobInfo
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		manifestPath := filepath.Join(dir, file.Name())
+		manifest, rerr := readManifestFile(manifestPath)
+		if rerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read manifest %s due to error - %v", manifestPath, rerr)
+			return rerr
+		}
+		manifests = append(manifests, manifest)
+	}
+
+	if len(manifests) == 0 {
+		return fmt.Errorf("no manifests found in %s", dir)
+	}
+
+	for _, destination := range jobInfo.Destinations {
+		if _, err = getCacheDir(ctx, destination); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not create cache for destination %s due to error - %v.", destination, err)
+			return err
+		}
+	}
+
+	for _, manifest := range manifests {
+		inheritJobOptions(manifest, jobInfo)
+		vol, serr := saveManifest(ctx, manifest, true)
+		if serr != nil {
+			return serr
+		}
+		err = uploadManifest(ctx, manifest, vol, jobInfo.Destinations)
+		vol.DeleteVolume()
+		if err != nil {
+			return err
+		}
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Imported %d manifests from %s.", len(manifests), dir)
+	return nil
+}
+
+// MigrateManifests will sync the manifests found in the target destination to the local cache and upload
+// every one of them written with an older schema, migrated to the current one, in place of the original.
+// With dryRun, the manifests that would be migrated are only reported.
+func MigrateManifests(pctx context.Context, jobInfo *helpers.JobInfo, dryRun bool) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	target := jobInfo.Destinations[0]
+	backend, err := prepareBackend(ctx, jobInfo, target, nil)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return err
+	}
+	defer backend.Close()
+
+	localCachePath, err := getCacheDir(ctx, target)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, err)
+		return err
+	}
+
+	objectNames, err := backend.List(ctx, jobInfo.ManifestPrefix)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list manifest files from target %s due to error - %v.", target, err)
+		return err
+	}
+	objectNames = currentManifests(objectNames)
+
+	if _, _, err = syncCache(ctx, jobInfo, localCachePath, backend); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, err)
+		return err
+	}
+
+	migrated := 0
+	for _, objectName := range objectNames {
+		manifestPath := filepath.Join(localCachePath, fmt.Sprintf("%x", md5.Sum([]byte(objectName))))
+		manifest, outdated, rerr := readMigratedManifest(ctx, manifestPath, jobInfo)
+		if rerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read manifest %s due to error - %v", objectName, rerr)
+			return rerr
+		}
+		if !outdated {
+			helpers.EnvironmentOf(ctx).Logger.Debugf("Manifest %s is already of schema %d.", objectName, helpers.ManifestSchemaVersion)
+			continue
+		}
+		migrated++
+		if dryRun {
+			helpers.EnvironmentOf(ctx).Logger.Noticef("Manifest %s would be migrated to schema %d.", objectName, helpers.ManifestSchemaVersion)
+			continue
+		}
+
+		inheritJobOptions(manifest, jobInfo)
+		manifest.ManifestFormat = helpers.ManifestFormatNative
+		vol, serr := saveManifest(ctx, manifest, true)
+		if serr != nil {
+			return serr
+		}
+		// A manifest written under another name would leave the original one behind
+		if vol.ObjectName != objectName {
+			vol.DeleteVolume()
+			helpers.EnvironmentOf(ctx).Logger.Errorf("The migrated manifest %s would be named %s, provide the same encryption and signing options the backup set was sent with.", objectName, vol.ObjectName)
+			return fmt.Errorf("the migrated manifest %s would be named %s", objectName, vol.ObjectName)
+		}
+		err = uploadManifest(ctx, manifest, vol, jobInfo.Destinations)
+		vol.DeleteVolume()
+		if err != nil {
+			return err
+		}
+		helpers.EnvironmentOf(ctx).Logger.Infof("Migrated manifest %s to schema %d.", objectName, helpers.ManifestSchemaVersion)
+	}
+
+	if dryRun {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("%d of the %d manifests found at %s would be migrated to schema %d.", migrated, len(objectNames), target, helpers.ManifestSchemaVersion)
+	} else {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Migrated %d of the %d manifests found at %s to schema %d.", migrated, len(objectNames), target, helpers.ManifestSchemaVersion)
+	}
+	return nil
+}
+
+// uploadManifest will upload the manifest volume of the backup set described by jobInfo, and a record of it kept
+// in its history, to every destination.
+func uploadManifest(ctx context.Context, jobInfo *helpers.JobInfo, manifest *helpers.VolumeInfo, destinations []string) error {
+	uploadBuffer := make(chan bool, 1)
+	defer close(uploadBuffer)
+
+	for _, destination := range destinations {
+		backend, err := prepareBackend(ctx, jobInfo, destination, uploadBuffer)
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", destination, err)
+			return err
+		}
+
+		be := backoff.NewExponentialBackOff()
+		be.MaxInterval = jobInfo.MaxBackoffTime
+		be.MaxElapsedTime = jobInfo.MaxRetryTime
+		retryconf := backoff.WithContext(be, ctx)
+
+		err = backoff.RetryNotify(manifestUploadWrapper(ctx, backend, manifest, destination, jobInfo.ManifestFormat != helpers.ManifestFormatUpstream), retryconf, retryNotifier(ctx, jobInfo, manifest, destination))
+		backend.Close()
+		if err != nil {
+			helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: destination, Volume: manifest.ObjectName, Err: err}.Errorf(ctx, "Failed to upload the manifest %s to %s due to error - %v", manifest.ObjectName, destination, err)
+			return helpers.NewError(helpers.ErrorKindBackend, err)
+		}
+		helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: destination, Volume: manifest.ObjectName, Bytes: manifest.Size}.Noticef(ctx, "Uploaded the manifest %s to %s.", manifest.ObjectName, destination)
+	}
+
+	return nil
+}
+
+// inheritJobOptions will copy the options of jobInfo that are not stored in a manifest but are
+// required to write and upload it, or the volumes of its backup set, to the manifest.
+func inheritJobOptions(manifest, jobInfo *helpers.JobInfo) {
+	manifest.ManifestPrefix = jobInfo.ManifestPrefix
+	manifest.EncryptKey = jobInfo.EncryptKey
+	manifest.SignKey = jobInfo.SignKey
+	manifest.Destinations = jobInfo.Destinations
+	manifest.MaxBackoffTime = jobInfo.MaxBackoffTime
+	manifest.MaxRetryTime = jobInfo.MaxRetryTime
+	manifest.MaxParallelUploads = jobInfo.MaxParallelUploads
+	manifest.UploadChunkSize = jobInfo.UploadChunkSize
+	manifest.UploadReadSize = jobInfo.UploadReadSize
+	manifest.Progress = jobInfo.Progress
+	manifest.JobName = jobInfo.JobName
+	manifest.ObjectTags = jobInfo.ObjectTags
+	manifest.ObjectMetadata = jobInfo.ObjectMetadata
+}
+
+// exportFileName returns the name of the file the manifest stored as objectName is exported to.
+func exportFileName(objectName string) string {
+	return strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(objectName) + ".json"
+}
+
+func writeManifestFile(path string, manifest *helpers.JobInfo) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(manifest); err != nil {
+		out.Close()
+		os.Remove(path)
+		return err
+	}
+	return out.Close()
+}
+
+func readManifestFile(path string) (*helpers.JobInfo, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	manifest := new(helpers.JobInfo)
+	if err = json.NewDecoder(in).Decode(manifest); err != nil {
+		return nil, err
+	}
+
+	if manifest.VolumeName == "" || manifest.BaseSnapshot.Name == "" {
+		return nil, fmt.Errorf("the manifest does not name the volume and snapshot of its backup set")
+	}
+	if _, err = helpers.MigrateManifest(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ManifestValidation is the outcome of checking a manifest against helpers.ManifestJSONSchema.
+type ManifestValidation struct {
+	Manifest string   // Object name of the manifest, or path of the file checked
+	Valid    bool     // The manifest conforms to the schema
+	Problems []string `json:",omitempty"` // Every way the manifest does not conform to the schema
+}
+
+func validateManifest(name string, data []byte) *ManifestValidation {
+	problems := helpers.ValidateManifestJSON(data)
+	return &ManifestValidation{Manifest: name, Valid: len(problems) == 0, Problems: problems}
+}
+
+// ValidateManifestFile will check the manifest in the local file at path against helpers.ManifestJSONSchema. The
+// file is either a JSON manifest, as exported, or a manifest as stored, decrypted and decompressed according to
+// jobInfo.
+func ValidateManifestFile(ctx context.Context, jobInfo *helpers.JobInfo, path string) (*ManifestValidation, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not read %s due to error - %v", path, err)
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		if data, err = readManifestObject(ctx, jobInfo, path); err != nil {
+			return &ManifestValidation{Manifest: path, Problems: []string{fmt.Sprintf("could not read the manifest - %v", err)}}, nil
+		}
+	}
+	return validateManifest(path, data), nil
+}
+
+// ValidateManifests will sync the manifests found in the target destination to the local cache and check every one
+// of them against helpers.ManifestJSONSchema.
+func ValidateManifests(pctx context.Context, jobInfo *helpers.JobInfo) ([]*ManifestValidation, error) {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	target := jobInfo.Destinations[0]
+	backend, err := prepareBackend(ctx, jobInfo, target, nil)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+	defer backend.Close()
+
+	localCachePath, err := getCacheDir(ctx, target)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+
+	objectNames, err := backend.List(ctx, jobInfo.ManifestPrefix)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list manifest files from target %s due to error - %v.", target, err)
+		return nil, err
+	}
+	objectNames = currentManifests(objectNames)
+
+	if _, _, err = syncCache(ctx, jobInfo, localCachePath, backend); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+
+	validations := make([]*ManifestValidation, 0, len(objectNames))
+	for _, objectName := range objectNames {
+		manifestPath := filepath.Join(localCachePath, fmt.Sprintf("%x", md5.Sum([]byte(objectName))))
+		data, rerr := readManifestObject(ctx, jobInfo, manifestPath)
+		if rerr != nil {
+			validations = append(validations, &ManifestValidation{Manifest: objectName, Problems: []string{fmt.Sprintf("could not read the manifest - %v", rerr)}})
+			continue
+		}
+		validations = append(validations, validateManifest(objectName, data))
+	}
+	return validations, nil
+}
+
+// readManifestObject will return the JSON of the manifest stored in the local file at path, decrypted and
+// decompressed according to jobInfo.
+func readManifestObject(ctx context.Context, jobInfo *helpers.JobInfo, path string) ([]byte, error) {
+	manifestVol, err := helpers.ExtractLocal(ctx, jobInfo, path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer manifestVol.Close()
+	return ioutil.ReadAll(manifestVol)
+}
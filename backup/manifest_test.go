@@ -0,0 +1,352 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestExportFileName(t *testing.T) {
+	testCases := []struct {
+		objectName string
+		expect     string
+	}{
+		{"manifests|Tank|snap1.manifest.gz", "manifests|Tank|snap1.manifest.gz.json"},
+		{"manifests|Tank/Data|snap1|to|snap2.manifest.gz.pgp", "manifests|Tank_Data|snap1|to|snap2.manifest.gz.pgp.json"},
+	}
+
+	for idx, c := range testCases {
+		if got := exportFileName(c.objectName); got != c.expect {
+			t.Errorf("%d: expected %s, got %s", idx, c.expect, got)
+		}
+	}
+}
+
+func TestListManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupdiscover")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	objects := []string{
+		"manifests|Tank|snap1.manifest.gz",
+		"Tank|snap1.zstream.gz.vol1",
+		"offsite/m+Tank+snap1+to+snap2.manifest.gz",
+		"offsite/Tank+snap1+to+snap2.zstream.gz.vol1",
+		"other|Tank|snap3.manifest.gz.pgp",
+	}
+	for _, object := range objects {
+		path := filepath.Join(dir, object)
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("could not create directory: %v", err)
+		}
+		if err = ioutil.WriteFile(path, nil, 0600); err != nil {
+			t.Fatalf("could not create object: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	backend, err := prepareBackend(ctx, &helpers.JobInfo{}, "file://"+dir, nil)
+	if err != nil {
+		t.Fatalf("could not prepare backend: %v", err)
+	}
+
+	testCases := []struct {
+		j      *helpers.JobInfo
+		expect []string
+	}{
+		{&helpers.JobInfo{ManifestPrefix: "manifests"}, []string{"manifests|Tank|snap1.manifest.gz"}},
+		{&helpers.JobInfo{ManifestPrefix: "manifests", DiscoverManifests: true}, []string{"manifests|Tank|snap1.manifest.gz", "offsite/m+Tank+snap1+to+snap2.manifest.gz"}},
+	}
+
+	for idx, c := range testCases {
+		got, lerr := listManifests(ctx, c.j, backend)
+		if lerr != nil {
+			t.Errorf("%d: unexpected error %v", idx, lerr)
+			continue
+		}
+		names := make([]string, 0, len(got))
+		for name, version := range got {
+			if version == "" {
+				t.Errorf("%d: expected a version for %s", idx, name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, c.expect) {
+			t.Errorf("%d: expected %v, got %v", idx, c.expect, names)
+		}
+	}
+}
+
+func TestManifestFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupmanifest")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := &helpers.JobInfo{
+		VolumeName:   "Tank/Data",
+		BaseSnapshot: helpers.SnapshotInfo{Name: "snap1"},
+		Compressor:   helpers.InternalCompressor,
+		Separator:    "|",
+		Volumes:      []*helpers.VolumeInfo{{ObjectName: "Tank/Data|snap1.zstream.gz.vol1", VolumeNumber: 1}},
+	}
+
+	path := filepath.Join(dir, "manifest.json")
+	if err = writeManifestFile(path, manifest); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+	if err = writeManifestFile(path, manifest); err == nil {
+		t.Errorf("expected an error overwriting an exported manifest")
+	}
+
+	read, err := readManifestFile(path)
+	if err != nil {
+		t.Fatalf("could not read manifest: %v", err)
+	}
+	if read.VolumeName != manifest.VolumeName || !read.BaseSnapshot.Equal(&manifest.BaseSnapshot) || len(read.Volumes) != 1 || read.Volumes[0].ObjectName != manifest.Volumes[0].ObjectName {
+		t.Errorf("expected %v, got %v", manifest, read)
+	}
+
+	if err = ioutil.WriteFile(path, []byte(`{"VolumeName": "Tank/Data"}`), 0600); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+	if _, err = readManifestFile(path); err == nil {
+		t.Errorf("expected an error reading a manifest without a snapshot")
+	}
+}
+
+func TestManifestFileSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupschema")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		manifest string
+		digest   string
+		errTest  errTestFunc
+	}{
+		// Manifests of schema 1 do not record their schema nor, for SHA256, their digest algorithm
+		{`{"VolumeName": "Tank/Data", "BaseSnapshot": {"Name": "snap1"}}`, helpers.DigestSHA256, nilErrTest},
+		{`{"VolumeName": "Tank/Data", "BaseSnapshot": {"Name": "snap1"}, "DigestAlgorithm": "blake3"}`, helpers.DigestBLAKE3, nilErrTest},
+		{`{"VolumeName": "Tank/Data", "BaseSnapshot": {"Name": "snap1"}, "DigestAlgorithm": "xxh3", "SchemaVersion": 2}`, helpers.DigestXXH3, nilErrTest},
+		{`{"VolumeName": "Tank/Data", "BaseSnapshot": {"Name": "snap1"}, "SchemaVersion": 99}`, "", nonNilErrTest},
+	}
+
+	path := filepath.Join(dir, "manifest.json")
+	for idx, c := range testCases {
+		if err = ioutil.WriteFile(path, []byte(c.manifest), 0600); err != nil {
+			t.Fatalf("could not write manifest: %v", err)
+		}
+		read, rerr := readManifestFile(path)
+		if !c.errTest(rerr) {
+			t.Errorf("%d: unexpected error %v", idx, rerr)
+			continue
+		}
+		if rerr != nil {
+			continue
+		}
+		if read.SchemaVersion != helpers.ManifestSchemaVersion {
+			t.Errorf("%d: expected the manifest to be migrated to schema %d, got %d", idx, helpers.ManifestSchemaVersion, read.SchemaVersion)
+		}
+		if read.DigestAlgorithm != c.digest {
+			t.Errorf("%d: expected the digest algorithm %s, got %s", idx, c.digest, read.DigestAlgorithm)
+		}
+	}
+}
+
+func TestManifestJSONSchemaFile(t *testing.T) {
+	published, err := ioutil.ReadFile(filepath.Join("..", "helpers", "manifest.schema.json"))
+	if err != nil {
+		t.Fatalf("could not read the published schema: %v", err)
+	}
+	if string(published) != helpers.ManifestJSONSchema {
+		t.Errorf("expected helpers/manifest.schema.json to match helpers.ManifestJSONSchema")
+	}
+}
+
+func TestValidateManifestJSON(t *testing.T) {
+	valid := &helpers.JobInfo{
+		StartTime:        time.Now(),
+		EndTime:          time.Now(),
+		VolumeName:       "Tank/Data",
+		BaseSnapshot:     helpers.SnapshotInfo{Name: "snap2", CreationTime: time.Now()},
+		Compressor:       helpers.InternalCompressor,
+		CompressionLevel: 6,
+		DigestAlgorithm:  helpers.DigestBLAKE3,
+		Separator:        "|",
+		ZFSStreamBytes:   1 << 40,
+		Version:          helpers.VersionNumber,
+		SchemaVersion:    helpers.ManifestSchemaVersion,
+		Tags:             map[string]string{"keep": "forever"},
+		MinRetention:     map[string]time.Duration{"": time.Hour},
+		ParityVolumes:    1,
+		ParityGroupSize:  16,
+		Stats:            &helpers.BackupStats{StreamBytes: 1 << 40, CompressionRatio: 1.5, WallTime: time.Minute},
+		Volumes: []*helpers.VolumeInfo{{
+			ObjectName:      "Tank/Data|snap2.zstream.gz.vol1",
+			VolumeNumber:    1,
+			DigestAlgorithm: helpers.DigestBLAKE3,
+			DigestSum:       "00ff",
+			SHA256Sum:       strings.Repeat("ab", 32),
+			MD5Sum:          strings.Repeat("cd", 16),
+			CRC32CSum32:     4294967295,
+			Size:            1024,
+		}},
+		Parity: []*helpers.VolumeInfo{{ObjectName: "Tank/Data|snap2.zstream.gz.parity1", VolumeNumber: 1, IsParity: true}},
+	}
+	data, err := json.Marshal(valid)
+	if err != nil {
+		t.Fatalf("could not encode manifest: %v", err)
+	}
+	replace := func(old, new string) string {
+		if !strings.Contains(string(data), old) {
+			t.Fatalf("the manifest does not contain %s", old)
+		}
+		return strings.Replace(string(data), old, new, 1)
+	}
+
+	testCases := []struct {
+		manifest string
+		problem  string
+	}{
+		{string(data), ""},
+		// A manifest of schema 1, as written before manifests recorded it
+		{`{"StartTime": "2017-01-01T00:00:00Z", "EndTime": "2017-01-01T00:00:00Z", "VolumeName": "Tank", "BaseSnapshot": {"CreationTime": "2017-01-01T00:00:00Z", "Name": "snap1"}, "IncrementalSnapshot": {"CreationTime": "0001-01-01T00:00:00Z", "Name": ""}, "Compressor": "internal", "Separator": "|", "Volumes": null}`, ""},
+		{"not json", "not a JSON document"},
+		{string(data) + string(data), "more than one JSON document"},
+		{replace(`"VolumeName":"Tank/Data",`, ""), "/: missing the required property VolumeName"},
+		{replace(`"VolumeName":"Tank/Data"`, `"VolumeName":""`), "/VolumeName: expected at least 1 characters"},
+		{replace(`"Replication"`, `"Replicaton"`), "/: unknown property Replicaton"},
+		{replace(`"CompressionLevel":6`, `"CompressionLevel":"6"`), "/CompressionLevel: expected integer, got string"},
+		{replace(`"CompressionLevel":6`, `"CompressionLevel":6.5`), "/CompressionLevel: expected integer, got number"},
+		{replace(`"CompressionLevel":6`, `"CompressionLevel":10`), "/CompressionLevel: 10 is greater than 9"},
+		{replace(`"ZFSStreamBytes":1099511627776`, `"ZFSStreamBytes":-1`), "/ZFSStreamBytes: -1 is less than 0"},
+		{replace(`"DigestAlgorithm":"blake3"`, `"DigestAlgorithm":"md4"`), "/DigestAlgorithm: md4 is not one of"},
+		{replace(`"SHA256Sum":"abab`, `"SHA256Sum":"ABAB`), "/Volumes/0/SHA256Sum: "},
+		{replace(`"keep":"forever"`, `"keep":1`), "/Tags/keep: expected string, got integer"},
+		{replace(`"StartTime":"`, `"StartTime":"yesterday`), "/StartTime: \"yesterday"},
+		{replace(`"IsParity":true`, `"IsParity":"yes"`), "/Parity/0/IsParity: expected boolean, got string"},
+		{replace(fmt.Sprintf(`"SchemaVersion":%d`, helpers.ManifestSchemaVersion), `"SchemaVersion":99`), "is of schema 99"},
+	}
+
+	for idx, c := range testCases {
+		problems := helpers.ValidateManifestJSON([]byte(c.manifest))
+		if c.problem == "" {
+			if len(problems) != 0 {
+				t.Errorf("%d: expected the manifest to be valid, got %v", idx, problems)
+			}
+			continue
+		}
+		if len(problems) != 1 || !strings.Contains(problems[0], c.problem) {
+			t.Errorf("%d: expected the problem %q, got %v", idx, c.problem, problems)
+		}
+	}
+}
+
+func TestValidateManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupvalidate")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = filepath.Join(dir, "work")
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	ctx := context.Background()
+	target := filepath.Join(dir, "target")
+	if err = os.MkdirAll(target, 0700); err != nil {
+		t.Fatalf("could not create target: %v", err)
+	}
+
+	manifest := &helpers.JobInfo{
+		VolumeName:     "tank",
+		BaseSnapshot:   helpers.SnapshotInfo{Name: "snap1"},
+		StartTime:      time.Now(),
+		Compressor:     helpers.InternalCompressor,
+		Separator:      "|",
+		ManifestPrefix: "manifests",
+		Destinations:   []string{"file://" + target},
+		Volumes:        []*helpers.VolumeInfo{{ObjectName: "tank|snap1.zstream.gz.vol1", VolumeNumber: 1}},
+	}
+	if _, err = getCacheDir(context.Background(), manifest.Destinations[0]); err != nil {
+		t.Fatalf("could not create the cache: %v", err)
+	}
+	vol, err := saveManifest(ctx, manifest, true)
+	if err != nil {
+		t.Fatalf("could not save the manifest: %v", err)
+	}
+	manifestPath := filepath.Join(target, vol.ObjectName)
+	if err = vol.CopyTo(manifestPath); err != nil {
+		t.Fatalf("could not store the manifest: %v", err)
+	}
+	vol.DeleteVolume()
+
+	// A corrupt manifest is reported without stopping the others from being checked
+	if err = ioutil.WriteFile(filepath.Join(target, "manifests|tank|snap2.manifest.gz"), []byte("corrupt"), 0600); err != nil {
+		t.Fatalf("could not store the manifest: %v", err)
+	}
+
+	j := &helpers.JobInfo{ManifestPrefix: "manifests", Separator: "|", Destinations: manifest.Destinations}
+	validations, err := ValidateManifests(ctx, j)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(validations) != 2 || !validations[0].Valid || validations[1].Valid || len(validations[1].Problems) != 1 {
+		t.Errorf("expected the first manifest to be valid and the second not, got %+v %+v", validations[0], validations[1])
+	}
+
+	// Stored and exported manifests can be checked as files
+	validation, err := ValidateManifestFile(ctx, j, manifestPath)
+	if err != nil || !validation.Valid {
+		t.Errorf("expected the stored manifest to be valid, got %+v (%v)", validation, err)
+	}
+	exportPath := filepath.Join(dir, "manifest.json")
+	if err = ioutil.WriteFile(exportPath, []byte(`{"VolumeName": "tank"}`), 0600); err != nil {
+		t.Fatalf("could not write manifest: %v", err)
+	}
+	validation, err = ValidateManifestFile(ctx, j, exportPath)
+	if err != nil || validation.Valid {
+		t.Errorf("expected the exported manifest to be invalid, got %+v (%v)", validation, err)
+	}
+	if _, err = ValidateManifestFile(ctx, j, filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected an error checking a missing file, got none")
+	}
+}
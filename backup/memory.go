@@ -0,0 +1,101 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"fmt"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// MemoryUse is the memory, in bytes, the buffers of a send are expected to use.
+type MemoryUse struct {
+	Uploads     uint64 // A chunk and a read buffer for every upload, or part of one, in flight
+	Compression uint64 // The blocks the internal compressor compresses in parallel and their output
+	Staging     uint64 // The volumes staged, when staged in memory
+	Reads       uint64 // The reads of the zfs send stream
+}
+
+// Total will return the memory used by all the buffers.
+func (m MemoryUse) Total() uint64 {
+	return m.Uploads + m.Compression + m.Staging + m.Reads
+}
+
+// EstimateMemory will return the memory the buffers of the send described by j are expected to use when
+// compressing on the given number of cores, and staging its volumes in memory if inMemory is set.
+func EstimateMemory(j *helpers.JobInfo, cores int, inMemory bool) MemoryUse {
+	uploadReadSize := j.UploadReadSize
+	if uploadReadSize == 0 {
+		uploadReadSize = helpers.DefaultUploadReadSize
+	}
+	sendReadSize := j.SendReadSize
+	if sendReadSize == 0 {
+		sendReadSize = helpers.DefaultSendReadSize
+	}
+
+	var m MemoryUse
+	m.Uploads = uint64(j.UploadWorkers()) * (uint64(j.UploadChunkSize)*humanize.MiByte + uint64(uploadReadSize)*humanize.KiByte)
+	m.Reads = uint64(sendReadSize) * humanize.KiByte
+	if j.Compressor == helpers.InternalCompressor {
+		blockSize := j.CompressBlockSize
+		if blockSize == 0 {
+			blockSize = helpers.DefaultCompressorBlockSize
+		}
+		m.Compression = 2 * uint64(cores) * uint64(blockSize) * humanize.KiByte
+	}
+	if inMemory && j.MaxFileBuffer > 0 {
+		// Volumes may grow slightly past the volume size
+		m.Staging = uint64(j.MaxFileBuffer+1) * j.VolumeSize * humanize.MiByte
+	}
+	return m
+}
+
+// FitMemory will lower the parallelism of the send described by j until its buffers are expected to fit
+// in limit bytes: the uploads in flight first, then the cores the internal compressor runs on, and then
+// the volumes staged in memory. It returns the number of cores to compress on, or an error if the buffers
+// can't fit even with a single upload, core, and volume.
+func FitMemory(j *helpers.JobInfo, limit uint64, cores int, inMemory bool) (int, error) {
+	for {
+		m := EstimateMemory(j, cores, inMemory)
+		if m.Total() <= limit {
+			return cores, nil
+		}
+
+		switch {
+		case j.AutoTuneUploads && j.AutoTuneMaxUploads > j.MaxParallelUploads:
+			j.AutoTuneMaxUploads--
+		case j.MaxParallelUploads > 1:
+			j.MaxParallelUploads--
+			if j.AutoTuneUploads {
+				j.AutoTuneMaxUploads = j.MaxParallelUploads
+			}
+		case cores > 1 && m.Compression > 0:
+			cores--
+		case inMemory && j.MaxFileBuffer > 1:
+			j.MaxFileBuffer--
+		default:
+			return cores, fmt.Errorf("the buffers of the send need at least %s (%s for the uploads, %s for the compressor, %s for the staged volumes, %s for the reads), more than the %s allowed",
+				humanize.IBytes(m.Total()), humanize.IBytes(m.Uploads), humanize.IBytes(m.Compression), humanize.IBytes(m.Staging), humanize.IBytes(m.Reads), humanize.IBytes(limit))
+		}
+	}
+}
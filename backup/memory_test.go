@@ -0,0 +1,117 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"testing"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	j := &helpers.JobInfo{
+		Compressor:         helpers.InternalCompressor,
+		MaxParallelUploads: 4,
+		MaxFileBuffer:      5,
+		VolumeSize:         100,
+		UploadChunkSize:    10,
+		UploadReadSize:     256,
+		SendReadSize:       512,
+		CompressBlockSize:  1024,
+	}
+
+	m := EstimateMemory(j, 2, true)
+	expected := MemoryUse{
+		Uploads:     4 * (10*humanize.MiByte + 256*humanize.KiByte),
+		Compression: 2 * 2 * humanize.MiByte,
+		Staging:     6 * 100 * humanize.MiByte,
+		Reads:       512 * humanize.KiByte,
+	}
+	if m != expected {
+		t.Errorf("expected %+v, got %+v", expected, m)
+	}
+	if m.Total() != expected.Uploads+expected.Compression+expected.Staging+expected.Reads {
+		t.Errorf("expected the total to add up every buffer, got %d", m.Total())
+	}
+
+	j.Compressor = "xz"
+	if m = EstimateMemory(j, 2, false); m.Compression != 0 || m.Staging != 0 {
+		t.Errorf("expected no memory used by an external compressor or volumes staged on disk, got %+v", m)
+	}
+}
+
+func TestFitMemory(t *testing.T) {
+	testCases := []struct {
+		limit      uint64
+		inMemory   bool
+		autoTune   bool
+		uploads    int
+		autoTuneTo int
+		cores      int
+		fileBuffer int
+		err        bool
+	}{
+		// Fits as is
+		{limit: 1024, uploads: 4, cores: 4, fileBuffer: 5},
+		// Fewer uploads first
+		{limit: 30, uploads: 2, cores: 4, fileBuffer: 5},
+		// The highest number of uploads to tune up to before the number to start with
+		{limit: 50, autoTune: true, uploads: 4, autoTuneTo: 4, cores: 4, fileBuffer: 5},
+		{limit: 30, autoTune: true, uploads: 2, autoTuneTo: 2, cores: 4, fileBuffer: 5},
+		// Then fewer cores
+		{limit: 14, uploads: 1, cores: 1, fileBuffer: 5},
+		// Then fewer volumes staged in memory
+		{limit: 320, inMemory: true, uploads: 1, cores: 1, fileBuffer: 2},
+		// Volumes staged on disk don't count
+		{limit: 300, uploads: 4, cores: 4, fileBuffer: 5},
+		// Can't fit a single upload
+		{limit: 5, uploads: 1, cores: 1, fileBuffer: 5, err: true},
+	}
+
+	for idx, testCase := range testCases {
+		j := &helpers.JobInfo{
+			Compressor:         helpers.InternalCompressor,
+			MaxParallelUploads: 4,
+			AutoTuneUploads:    testCase.autoTune,
+			AutoTuneMaxUploads: 8,
+			MaxFileBuffer:      5,
+			VolumeSize:         100,
+			UploadChunkSize:    10,
+			UploadReadSize:     256,
+			SendReadSize:       512,
+			CompressBlockSize:  1024,
+		}
+
+		cores, err := FitMemory(j, testCase.limit*humanize.MiByte, 4, testCase.inMemory)
+		if (err != nil) != testCase.err {
+			t.Errorf("%d: expected an error %v, got %v", idx, testCase.err, err)
+			continue
+		}
+		if j.MaxParallelUploads != testCase.uploads || cores != testCase.cores || j.MaxFileBuffer != testCase.fileBuffer {
+			t.Errorf("%d: expected %d uploads, %d cores, and %d volumes staged, got %d, %d, and %d", idx, testCase.uploads, testCase.cores, testCase.fileBuffer, j.MaxParallelUploads, cores, j.MaxFileBuffer)
+		}
+		if testCase.autoTune && j.AutoTuneMaxUploads != testCase.autoTuneTo {
+			t.Errorf("%d: expected to tune up to %d uploads, got %d", idx, testCase.autoTuneTo, j.AutoTuneMaxUploads)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/reedsolomon"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// parityChunkSize is how much of every volume of a parity group is encoded or reconstructed at a time
+const parityChunkSize = 1024 * 1024
+
+var errNoParity = errors.New("the backup set has no parity volumes")
+
+// parityEncoder computes the Reed-Solomon parity volumes of a backup set as its volumes are staged. Every
+// ParityGroupSize volumes, in the order of their numbers, make up a parity group with ParityVolumes parity
+// volumes, as large as its largest volume, from which any ParityVolumes volumes of the group can be rebuilt.
+// The parity of the group is accumulated in temporary files one volume at a time, so neither the volumes nor
+// the parity are held in memory.
+type parityEncoder struct {
+	j      *helpers.JobInfo
+	enc    reedsolomon.Encoder
+	groups map[int64]*parityGroup
+	data   []byte
+	parity [][]byte
+}
+
+// parityGroup holds the parity accumulated for the volumes added to a group so far.
+type parityGroup struct {
+	files []*os.File
+	added int
+	size  int64
+}
+
+// newParityEncoder returns a parityEncoder for the backup set described by j, or nil if it has no parity volumes.
+func newParityEncoder(j *helpers.JobInfo) (*parityEncoder, error) {
+	if j.ParityVolumes <= 0 {
+		return nil, nil
+	}
+	enc, err := reedsolomon.New(j.ParityGroupSize, j.ParityVolumes)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parityEncoder{
+		j:      j,
+		enc:    enc,
+		groups: make(map[int64]*parityGroup),
+		data:   make([]byte, parityChunkSize),
+		parity: make([][]byte, j.ParityVolumes),
+	}
+	for idx := range p.parity {
+		p.parity[idx] = make([]byte, parityChunkSize)
+	}
+	return p, nil
+}
+
+// parityPosition returns the parity group of the volume numbered volnum and its index in the group.
+func parityPosition(j *helpers.JobInfo, volnum int64) (group int64, index int) {
+	return (volnum - 1) / int64(j.ParityGroupSize), int((volnum - 1) % int64(j.ParityGroupSize))
+}
+
+// add will encode the staged volume provided into the parity of its group, returning the parity volumes of the
+// group once all of its volumes were added.
+func (p *parityEncoder) add(ctx context.Context, vol *helpers.VolumeInfo) ([]*helpers.VolumeInfo, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	number, index := parityPosition(p.j, vol.VolumeNumber)
+	group, ok := p.groups[number]
+	if !ok {
+		group = &parityGroup{files: make([]*os.File, p.j.ParityVolumes)}
+		p.groups[number] = group
+		for idx := range group.files {
+			f, err := ioutil.TempFile(helpers.EnvironmentOf(ctx).TempDir, "parity")
+			if err != nil {
+				return nil, err
+			}
+			group.files[idx] = f
+		}
+	}
+
+	in, err := os.Open(vol.StagedPath())
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var offset int64
+	for {
+		n, rerr := io.ReadFull(in, p.data)
+		if n > 0 {
+			// Parity not written yet reads as zeros
+			for idx, f := range group.files {
+				read, err := f.ReadAt(p.parity[idx][:n], offset)
+				if err != nil && err != io.EOF {
+					return nil, err
+				}
+				zero(p.parity[idx][read:n])
+			}
+			parity := make([][]byte, len(p.parity))
+			for idx := range parity {
+				parity[idx] = p.parity[idx][:n]
+			}
+			if err = p.enc.EncodeIdx(p.data[:n], index, parity); err != nil {
+				return nil, err
+			}
+			for idx, f := range group.files {
+				if _, err = f.WriteAt(parity[idx], offset); err != nil {
+					return nil, err
+				}
+			}
+			offset += int64(n)
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		} else if rerr != nil {
+			return nil, rerr
+		}
+	}
+	if offset > group.size {
+		group.size = offset
+	}
+	group.added++
+
+	if group.added < p.j.ParityGroupSize {
+		return nil, nil
+	}
+	return p.finishGroup(ctx, number)
+}
+
+// finish will return the parity volumes of the groups not complete yet, i.e. the last group of the backup set.
+func (p *parityEncoder) finish(ctx context.Context) ([]*helpers.VolumeInfo, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	var vols []*helpers.VolumeInfo
+	for number := range p.groups {
+		groupVols, err := p.finishGroup(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		vols = append(vols, groupVols...)
+	}
+	return vols, nil
+}
+
+// finishGroup will write the parity accumulated for the group to parity volumes and drop the group.
+func (p *parityEncoder) finishGroup(ctx context.Context, number int64) ([]*helpers.VolumeInfo, error) {
+	group := p.groups[number]
+	delete(p.groups, number)
+	defer group.discard(ctx)
+
+	vols := make([]*helpers.VolumeInfo, 0, len(group.files))
+	for idx, f := range group.files {
+		vol, err := helpers.CreateSimpleVolume(ctx, false)
+		if err != nil {
+			return nil, err
+		}
+		vols = append(vols, vol)
+		vol.VolumeNumber = number*int64(p.j.ParityVolumes) + int64(idx) + 1
+		vol.ObjectName = helpers.ParityVolumeObjectName(p.j, vol.VolumeNumber)
+		vol.IsParity = true
+
+		// Every parity volume of the group is as large as its largest volume
+		if _, err = helpers.Copy(vol, io.NewSectionReader(f, 0, group.size)); err == nil {
+			err = vol.Close()
+		}
+		if err != nil {
+			vol.Close()
+			for _, v := range vols {
+				v.DeleteVolume()
+			}
+			return nil, err
+		}
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Created parity volume %s.", vol.ObjectName)
+	}
+	return vols, nil
+}
+
+// discard will delete the parity accumulated for the groups not complete yet.
+func (p *parityEncoder) discard(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	for number, group := range p.groups {
+		group.discard(ctx)
+		delete(p.groups, number)
+	}
+}
+
+func (g *parityGroup) discard(ctx context.Context) {
+	for _, f := range g.files {
+		if f == nil {
+			continue
+		}
+		f.Close()
+		if err := helpers.RemoveTempFile(f.Name()); err != nil && !os.IsNotExist(err) {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not delete the parity file %s - %v", f.Name(), err)
+		}
+	}
+}
+
+func zero(b []byte) {
+	for idx := range b {
+		b[idx] = 0
+	}
+}
+
+// paritySource is a volume of a parity group read to reconstruct another volume of the group.
+type paritySource struct {
+	vol  *helpers.VolumeInfo
+	r    io.ReadCloser
+	in   io.Reader
+	hash hash.Hash
+	read uint64
+}
+
+// reconstructVolume will rebuild the volume provided, missing or corrupt in the backend, from the other volumes
+// of its parity group and the parity volumes of the group. Volumes found corrupt along the way are left out and
+// the volume is rebuilt again without them, as long as no more volumes than there are parity volumes are left out.
+// The rebuilt volume is returned closed, its digests checked against those recorded in the manifest.
+func reconstructVolume(ctx context.Context, backend backends.Backend, manifest *helpers.JobInfo, target *helpers.VolumeInfo) (*helpers.VolumeInfo, error) {
+	if manifest == nil || manifest.ParityVolumes <= 0 || manifest.ParityGroupSize <= 0 {
+		return nil, errNoParity
+	}
+
+	number, targetIndex := parityPosition(manifest, target.VolumeNumber)
+	shards := make([]*helpers.VolumeInfo, manifest.ParityGroupSize+manifest.ParityVolumes)
+	for _, vol := range manifest.Volumes {
+		if group, index := parityPosition(manifest, vol.VolumeNumber); group == number {
+			shards[index] = vol
+		}
+	}
+	for _, vol := range manifest.Parity {
+		if index := vol.VolumeNumber - number*int64(manifest.ParityVolumes) - 1; index >= 0 && index < int64(manifest.ParityVolumes) {
+			shards[manifest.ParityGroupSize+int(index)] = vol
+		}
+	}
+
+	var size uint64
+	for _, vol := range shards[manifest.ParityGroupSize:] {
+		if vol == nil {
+			continue
+		}
+		size = vol.Size
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("no parity volumes of the group of volume %s are recorded", target.ObjectName)
+	}
+
+	enc, err := reedsolomon.New(manifest.ParityGroupSize, manifest.ParityVolumes)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := map[int]bool{targetIndex: true}
+	for {
+		vol, bad, rerr := reconstructFrom(ctx, backend, enc, manifest, shards, excluded, targetIndex, size)
+		if rerr == nil {
+			return vol, nil
+		}
+		if bad < 0 {
+			return nil, rerr
+		}
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Volume %s of the parity group of %s is damaged as well - %v", shards[bad].ObjectName, target.ObjectName, rerr)
+		excluded[bad] = true
+	}
+}
+
+// reconstructFrom will rebuild the volume at targetIndex of the group from the volumes of the group not
+// excluded. If a volume read turns out to be corrupt, its index is returned along with the error.
+func reconstructFrom(ctx context.Context, backend backends.Backend, enc reedsolomon.Encoder, manifest *helpers.JobInfo, shards []*helpers.VolumeInfo, excluded map[int]bool, targetIndex int, size uint64) (*helpers.VolumeInfo, int, error) {
+	target := shards[targetIndex]
+
+	// Pick as many volumes as there are data volumes in a group to rebuild it from. The volumes the last
+	// group of a backup set is short of are known to be all zeros, so they are never downloaded.
+	sources := make(map[int]*paritySource)
+	present := 0
+	for index, vol := range shards {
+		if present == manifest.ParityGroupSize {
+			break
+		}
+		if excluded[index] {
+			continue
+		}
+		if vol == nil {
+			if index < manifest.ParityGroupSize {
+				present++
+			}
+			continue
+		}
+		sources[index] = &paritySource{vol: vol, hash: sha256.New()}
+		present++
+	}
+	if present < manifest.ParityGroupSize {
+		return nil, -1, fmt.Errorf("too many volumes of the parity group of %s are damaged, only %d of the %d required are left", target.ObjectName, present, manifest.ParityGroupSize)
+	}
+
+	defer func() {
+		for _, source := range sources {
+			if source.r != nil {
+				source.r.Close()
+			}
+		}
+	}()
+	for index, source := range sources {
+		r, err := backend.Download(ctx, source.vol.ObjectName)
+		if err != nil {
+			return nil, index, err
+		}
+		source.r, source.in = r, helpers.EnvironmentOf(ctx).Transfers.Reader(r)
+	}
+
+	vol, err := helpers.CreateDigestVolume(ctx, false, target.ChecksumAlgorithm())
+	if err != nil {
+		return nil, -1, err
+	}
+	vol.ObjectName = target.ObjectName
+	vol.IsLayout = target.IsLayout
+	vol.VolumeNumber = target.VolumeNumber
+	fail := func(index int, err error) (*helpers.VolumeInfo, int, error) {
+		vol.Close()
+		vol.DeleteVolume()
+		return nil, index, err
+	}
+
+	buffers := make([][]byte, len(shards))
+	for index := range buffers {
+		buffers[index] = make([]byte, parityChunkSize)
+	}
+	chunk := make([][]byte, len(shards))
+	for offset := uint64(0); offset < size; offset += parityChunkSize {
+		if err = ctx.Err(); err != nil {
+			return fail(-1, err)
+		}
+		n := size - offset
+		if n > parityChunkSize {
+			n = parityChunkSize
+		}
+		for index := range chunk {
+			chunk[index] = nil
+			if excluded[index] {
+				continue
+			}
+			source, ok := sources[index]
+			if !ok {
+				if shards[index] == nil && index < manifest.ParityGroupSize {
+					chunk[index] = buffers[index][:n]
+					zero(chunk[index])
+				}
+				continue
+			}
+			// Volumes shorter than the parity are padded with zeros
+			buf := buffers[index][:n]
+			want := uint64(0)
+			if source.vol.Size > offset {
+				want = source.vol.Size - offset
+			}
+			if want > n {
+				want = n
+			}
+			read, rerr := io.ReadFull(source.in, buf[:want])
+			source.hash.Write(buf[:read])
+			source.read += uint64(read)
+			if rerr != nil {
+				return fail(index, rerr)
+			}
+			zero(buf[want:])
+			chunk[index] = buf
+		}
+		// Missing shards are rebuilt in the spare capacity of their buffer
+		chunk[targetIndex] = buffers[targetIndex][:0]
+		if err = enc.ReconstructData(chunk); err != nil {
+			return fail(-1, err)
+		}
+
+		if offset < target.Size {
+			end := target.Size - offset
+			if end > n {
+				end = n
+			}
+			if _, err = vol.Write(chunk[targetIndex][:end]); err != nil {
+				return fail(-1, err)
+			}
+		}
+	}
+
+	for index, source := range sources {
+		if sum := fmt.Sprintf("%x", source.hash.Sum(nil)); source.read != source.vol.Size || sum != source.vol.SHA256Sum {
+			return fail(index, fmt.Errorf("SHA256 hash mismatch, expected %s but got %s", source.vol.SHA256Sum, sum))
+		}
+	}
+
+	if err = vol.Close(); err != nil {
+		return fail(-1, err)
+	}
+	if err = vol.VerifyDigests(target); err != nil {
+		vol.DeleteVolume()
+		return nil, -1, err
+	}
+	helpers.LogFields{Volume: target.ObjectName, Bytes: vol.Size}.Noticef(ctx, "Rebuilt volume %s from its parity group.", target.ObjectName)
+	return vol, -1, nil
+}
@@ -0,0 +1,159 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// objectBackend serves the objects it holds by name
+type objectBackend struct {
+	mockBackend
+	objects map[string][]byte
+}
+
+func (o *objectBackend) Download(ctx context.Context, filename string) (io.ReadCloser, error) {
+	data, ok := o.objects[filename]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestReconstructVolume(t *testing.T) {
+	ctx := context.Background()
+	j := &helpers.JobInfo{
+		VolumeName:      "pool/dataset",
+		BaseSnapshot:    helpers.SnapshotInfo{Name: "snap"},
+		Separator:       "|",
+		ParityVolumes:   2,
+		ParityGroupSize: 3,
+	}
+
+	// Two parity groups, the second short of two volumes
+	objects := make(map[string][]byte)
+	encoder, err := newParityEncoder(j)
+	if err != nil {
+		t.Fatalf("could not create the parity encoder: %v", err)
+	}
+	defer encoder.discard(context.Background())
+
+	var parityVols []*helpers.VolumeInfo
+	for idx, size := range []int{300 * 1024, 1536 * 1024, parityChunkSize, 7} {
+		data := make([]byte, size)
+		if _, err = rand.Read(data); err != nil {
+			t.Fatalf("could not generate data: %v", err)
+		}
+		vol, verr := helpers.CreateSimpleVolume(ctx, false)
+		if verr != nil {
+			t.Fatalf("could not create volume: %v", verr)
+		}
+		if _, err = vol.Write(data); err != nil {
+			t.Fatalf("could not write volume: %v", err)
+		}
+		if err = vol.Close(); err != nil {
+			t.Fatalf("could not close volume: %v", err)
+		}
+		vol.VolumeNumber = int64(idx + 1)
+		vol.ObjectName = fmt.Sprintf("vol%d", vol.VolumeNumber)
+		vols, perr := encoder.add(ctx, vol)
+		if perr != nil {
+			t.Fatalf("could not add volume %d to the parity: %v", vol.VolumeNumber, perr)
+		}
+		parityVols = append(parityVols, vols...)
+		vol.DeleteVolume()
+		objects[vol.ObjectName] = data
+		j.Volumes = append(j.Volumes, vol)
+	}
+	if len(parityVols) != 2 {
+		t.Fatalf("expected the first parity group to be complete with 2 parity volumes, got %d", len(parityVols))
+	}
+	vols, err := encoder.finish(ctx)
+	if err != nil {
+		t.Fatalf("could not finish the parity: %v", err)
+	}
+	parityVols = append(parityVols, vols...)
+	for _, vol := range parityVols {
+		data, rerr := ioutil.ReadFile(vol.StagedPath())
+		if rerr != nil {
+			t.Fatalf("could not read parity volume %s: %v", vol.ObjectName, rerr)
+		}
+		vol.DeleteVolume()
+		objects[vol.ObjectName] = data
+		j.Parity = append(j.Parity, vol)
+	}
+	parity := func(number int64) string { return helpers.ParityVolumeObjectName(j, number) }
+
+	testCases := []struct {
+		target  int64
+		missing []string
+		corrupt []string
+		valid   errTestFunc
+	}{
+		{target: 1, missing: []string{"vol1"}, valid: nilErrTest},
+		{target: 2, corrupt: []string{"vol2"}, valid: nilErrTest},
+		{target: 3, missing: []string{"vol3"}, corrupt: []string{"vol1"}, valid: nilErrTest},
+		{target: 1, missing: []string{"vol1", parity(1)}, corrupt: []string{"vol2"}, valid: nonNilErrTest},
+		{target: 2, missing: []string{"vol1", "vol2", "vol3"}, valid: nonNilErrTest},
+		{target: 4, missing: []string{"vol4", parity(3)}, valid: nilErrTest},
+		{target: 4, missing: []string{"vol4", parity(3), parity(4)}, valid: nonNilErrTest},
+	}
+
+	for idx, testCase := range testCases {
+		b := &objectBackend{objects: make(map[string][]byte, len(objects))}
+		for name, data := range objects {
+			b.objects[name] = data
+		}
+		for _, name := range testCase.missing {
+			delete(b.objects, name)
+		}
+		for _, name := range testCase.corrupt {
+			data := append([]byte{}, b.objects[name]...)
+			data[len(data)/2] ^= 0xff
+			b.objects[name] = data
+		}
+
+		target := j.Volumes[testCase.target-1]
+		rebuilt, rerr := reconstructVolume(ctx, b, j, target)
+		if !testCase.valid(rerr) {
+			t.Errorf("%d: Unexpected error - %v", idx, rerr)
+		}
+		if rebuilt == nil {
+			continue
+		}
+		data, rerr := ioutil.ReadFile(rebuilt.StagedPath())
+		rebuilt.DeleteVolume()
+		if rerr != nil {
+			t.Errorf("%d: Could not read the rebuilt volume - %v", idx, rerr)
+		} else if !bytes.Equal(data, objects[target.ObjectName]) {
+			t.Errorf("%d: The rebuilt volume %s does not match the original", idx, target.ObjectName)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Plan describes the work a send or receive would do without doing any of it.
+type Plan struct {
+	Operation    string
+	Destinations []string
+	Steps        []*PlanStep
+}
+
+// PlanStep describes a single backup set that would be sent or restored.
+type PlanStep struct {
+	VolumeName          string
+	BaseSnapshot        string
+	IncrementalSnapshot string `json:",omitempty"`
+	// The size of the zfs send stream as estimated by zfs for a send, or the size of the volumes to download for a receive.
+	Bytes uint64
+	// The objects that would be created at every destination for a send, or downloaded for a receive.
+	// For a send, the number of volumes is estimated from the uncompressed stream size.
+	Objects []string
+	// The zfs send or zfs recv command that would run.
+	Command string `json:",omitempty"`
+	// The local file the stream would be written to instead of running zfs recv.
+	File string `json:",omitempty"`
+}
+
+// PlanBackup will compute the backup set the provided job would send without sending it.
+func PlanBackup(ctx context.Context, jobInfo *helpers.JobInfo) (*Plan, error) {
+	if ok, verr := validateSnapShotExists(ctx, &jobInfo.BaseSnapshot, jobInfo.VolumeName); verr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot validate if selected base snapshot exists due to error - %v", verr)
+		return nil, verr
+	} else if !ok {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Selected base snapshot does not exist!")
+		return nil, fmt.Errorf("selected base snapshot does not exist")
+	}
+
+	if jobInfo.IncrementalSnapshot.Name != "" {
+		if ok, verr := validateSnapShotExists(ctx, &jobInfo.IncrementalSnapshot, jobInfo.VolumeName); verr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot validate if selected incremental snapshot exists due to error - %v", verr)
+			return nil, verr
+		} else if !ok {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Selected incremental snapshot does not exist!")
+			return nil, fmt.Errorf("selected incremental snapshot does not exist")
+		}
+	}
+
+	if !jobInfo.SendAgain {
+		if manifest, berr := backedUp(ctx, jobInfo); berr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not check whether the destinations already hold this backup set - %v", berr)
+		} else if manifest != nil {
+			helpers.EnvironmentOf(ctx).Logger.Noticef("Every destination already holds the backup set %s, it would not be sent again.", helpers.ManifestObjectName(jobInfo))
+			return &Plan{Operation: "send", Destinations: jobInfo.Destinations}, nil
+		}
+	}
+
+	estimate, err := helpers.GetZFSSendEstimate(ctx, jobInfo)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not estimate the size of the zfs send stream - %v", err)
+		return nil, err
+	}
+
+	volumes := int64(1)
+	if volumeSize := jobInfo.VolumeSize * 1024 * 1024; volumeSize > 0 && estimate > volumeSize {
+		volumes = int64((estimate + volumeSize - 1) / volumeSize)
+	}
+
+	step := &PlanStep{
+		VolumeName:          jobInfo.VolumeName,
+		BaseSnapshot:        jobInfo.BaseSnapshot.Name,
+		IncrementalSnapshot: jobInfo.IncrementalSnapshot.Name,
+		Bytes:               estimate,
+		Command:             strings.Join(helpers.GetZFSSendCommand(ctx, jobInfo).Args, " "),
+	}
+	if jobInfo.Chunking == helpers.ChunkingCDC {
+		// Chunks are named by a digest only known once they are written and numbered along with the layout volumes
+		chunks := int64(1)
+		if chunkSize := uint64(jobInfo.ChunkSize) * 1024; estimate > chunkSize {
+			chunks = int64((estimate + chunkSize - 1) / chunkSize)
+		}
+		step.Objects = append(step.Objects,
+			fmt.Sprintf("%s<number of each of about %d layout volumes>", strings.TrimSuffix(helpers.BackupVolumeObjectName(jobInfo, 0), "0"), volumes),
+			helpers.ContentVolumeObjectName(jobInfo, fmt.Sprintf("<sha256 of each of about %d chunks>", chunks)))
+		volumes += chunks
+	} else {
+		for volnum := int64(1); volnum <= volumes; volnum++ {
+			if jobInfo.ContentAddressed {
+				// Named by a digest only known once the volume is written
+				step.Objects = append(step.Objects, helpers.ContentVolumeObjectName(jobInfo, fmt.Sprintf("<sha256 of volume %d>", volnum)))
+				continue
+			}
+			step.Objects = append(step.Objects, helpers.BackupVolumeObjectName(jobInfo, volnum))
+		}
+	}
+	if jobInfo.ParityVolumes > 0 {
+		groups := (volumes + int64(jobInfo.ParityGroupSize) - 1) / int64(jobInfo.ParityGroupSize)
+		for number := int64(1); number <= groups*int64(jobInfo.ParityVolumes); number++ {
+			step.Objects = append(step.Objects, helpers.ParityVolumeObjectName(jobInfo, number))
+		}
+	}
+	step.Objects = append(step.Objects, helpers.ManifestObjectName(jobInfo))
+
+	return &Plan{Operation: "send", Destinations: jobInfo.Destinations, Steps: []*PlanStep{step}}, nil
+}
+
+// PlanReceive will compute the backup sets the provided job would restore without restoring them.
+func PlanReceive(ctx context.Context, jobInfo *helpers.JobInfo) (*Plan, error) {
+	plan := &Plan{Operation: "receive", Destinations: jobInfo.Destinations[:1]}
+
+	if jobInfo.AutoRestore {
+		jobsToRestore, err := restoreChain(ctx, jobInfo)
+		if err != nil {
+			return nil, err
+		}
+		for idx, job := range jobsToRestore {
+			plan.Steps = append(plan.Steps, receiveStep(ctx, jobInfo, job, streamFilePath(jobInfo.ToFile, idx, len(jobsToRestore))))
+		}
+		return plan, nil
+	}
+
+	target := jobInfo.Destinations[0]
+	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
+	if berr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		return nil, berr
+	}
+	defer backend.Close()
+
+	localCachePath, cerr := getCacheDir(ctx, target)
+	if cerr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		return nil, cerr
+	}
+
+	manifest, err := loadManifest(ctx, backend, localCachePath, jobInfo)
+	if err != nil {
+		return nil, err
+	}
+	plan.Steps = append(plan.Steps, receiveStep(ctx, jobInfo, manifest, jobInfo.ToFile))
+	return plan, nil
+}
+
+func receiveStep(ctx context.Context, jobInfo, manifest *helpers.JobInfo, toFile string) *PlanStep {
+	restoreJob := *jobInfo
+	restoreJob.BaseSnapshot = manifest.BaseSnapshot
+	restoreJob.IncrementalSnapshot = manifest.IncrementalSnapshot
+
+	step := &PlanStep{
+		VolumeName:          manifest.VolumeName,
+		BaseSnapshot:        manifest.BaseSnapshot.Name,
+		IncrementalSnapshot: manifest.IncrementalSnapshot.Name,
+		Bytes:               manifest.TotalBytesWritten(),
+		File:                toFile,
+	}
+	if toFile == "" {
+		step.Command = strings.Join(helpers.GetZFSReceiveCommand(ctx, &restoreJob).Args, " ")
+	}
+	for _, vol := range manifest.Volumes {
+		step.Objects = append(step.Objects, vol.ObjectName)
+	}
+	return step
+}
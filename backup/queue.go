@@ -0,0 +1,249 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+const queueFileName = "queue.json"
+
+// uploadQueue persists the volumes of a backup set staged in the working directory along with the destinations
+// they were uploaded to, so a backup interrupted by a crash can be resumed by uploading the volumes already created
+// instead of creating them again. A nil uploadQueue is valid and persists nothing.
+type uploadQueue struct {
+	mu    sync.Mutex
+	dir   string
+	state queueState
+}
+
+// queueState is what is persisted to disk. The options the volumes were created with must match the ones of
+// the job resuming from them.
+type queueState struct {
+	ZFSCommandLine string
+	Compressor     string
+	EncryptTo      string
+	SignFrom       string
+	Volumes        map[string]*queuedVolume
+}
+
+type queuedVolume struct {
+	Volume *helpers.VolumeInfo
+	// SHA1Sum is not part of the JSON encoding of a VolumeInfo but is required by some backends to upload it
+	SHA1Sum      string
+	Path         string
+	Destinations []string
+}
+
+// openUploadQueue will return the upload queue of the backup set described by j, reading what a previous run
+// persisted, if anything. The volumes of the backup set should be staged in the directory of the queue.
+func openUploadQueue(ctx context.Context, j *helpers.JobInfo) (*uploadQueue, error) {
+	// Backups of the same snapshot to different destinations may run concurrently
+	key := jobKey(helpers.ManifestObjectName(j), j.Destinations)
+	dir := filepath.Join(helpers.EnvironmentOf(ctx).WorkingDir, "staging", fmt.Sprintf("%x", md5.Sum([]byte(key))))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create staging directory %s due to error: %v", dir, err)
+	}
+
+	q := &uploadQueue{dir: dir, state: newQueueState(ctx, j)}
+	data, err := ioutil.ReadFile(filepath.Join(dir, queueFileName))
+	if os.IsNotExist(err) {
+		return q, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state queueState
+	if err = json.Unmarshal(data, &state); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Ignoring the unreadable upload queue %s - %v", filepath.Join(dir, queueFileName), err)
+		return q, nil
+	}
+	if state.Volumes != nil {
+		q.state = state
+	}
+	return q, nil
+}
+
+func newQueueState(ctx context.Context, j *helpers.JobInfo) queueState {
+	return queueState{
+		ZFSCommandLine: strings.Join(helpers.GetZFSSendCommand(ctx, j).Args, " "),
+		Compressor:     j.Compressor,
+		EncryptTo:      j.EncryptTo,
+		SignFrom:       j.SignFrom,
+		Volumes:        make(map[string]*queuedVolume),
+	}
+}
+
+// Len returns the number of volumes in the queue.
+func (q *uploadQueue) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.state.Volumes)
+}
+
+// staged will return the volumes of the queue still found on disk, keyed by volume number, ready to be uploaded
+// to the destinations they were not uploaded to yet. An error is returned if the volumes were created with
+// options different from the ones of j.
+func (q *uploadQueue) staged(ctx context.Context, j *helpers.JobInfo) (map[int64]*helpers.VolumeInfo, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	current := newQueueState(ctx, j)
+	if q.state.ZFSCommandLine != current.ZFSCommandLine || q.state.Compressor != current.Compressor ||
+		q.state.EncryptTo != current.EncryptTo || q.state.SignFrom != current.SignFrom {
+		return nil, fmt.Errorf("the staged volumes were created with different options (zfs send command `%s`, compressor %s, encryptTo %s, signFrom %s)",
+			q.state.ZFSCommandLine, q.state.Compressor, q.state.EncryptTo, q.state.SignFrom)
+	}
+
+	staged := make(map[int64]*helpers.VolumeInfo, len(q.state.Volumes))
+	for name, queued := range q.state.Volumes {
+		if _, err := os.Stat(queued.Path); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("The staged volume %s is no longer found at %s and will be created again.", name, queued.Path)
+			delete(q.state.Volumes, name)
+			continue
+		}
+		queued.Volume.SHA1Sum = queued.SHA1Sum
+		queued.Volume.UseStagedFile(queued.Path)
+		staged[queued.Volume.VolumeNumber] = queued.Volume
+	}
+	return staged, q.save()
+}
+
+// discard will delete every staged volume and empty the queue.
+func (q *uploadQueue) discard(ctx context.Context, j *helpers.JobInfo) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, queued := range q.state.Volumes {
+		if err := helpers.RemoveTempFile(queued.Path); err != nil && !os.IsNotExist(err) {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Could not delete the staged volume %s - %v", queued.Path, err)
+		}
+	}
+	q.state = newQueueState(ctx, j)
+	return q.save()
+}
+
+// add will persist a volume just created and staged on disk.
+func (q *uploadQueue) add(vol *helpers.VolumeInfo) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.state.Volumes[vol.ObjectName] = &queuedVolume{Volume: vol, SHA1Sum: vol.SHA1Sum, Path: vol.StagedPath()}
+	return q.save()
+}
+
+// markUploaded will persist that the volume was uploaded to the destination.
+func (q *uploadQueue) markUploaded(vol *helpers.VolumeInfo, destination string) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued, ok := q.state.Volumes[vol.ObjectName]
+	if !ok {
+		return nil
+	}
+	queued.Destinations = append(queued.Destinations, destination)
+	return q.save()
+}
+
+// uploaded reports whether the volume was already uploaded to the destination.
+func (q *uploadQueue) uploaded(vol *helpers.VolumeInfo, destination string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if queued, ok := q.state.Volumes[vol.ObjectName]; ok {
+		for _, d := range queued.Destinations {
+			if d == destination {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remove will drop a volume that went through the entire pipeline from the queue.
+func (q *uploadQueue) remove(vol *helpers.VolumeInfo) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.state.Volumes, vol.ObjectName)
+	return q.save()
+}
+
+// finish will delete the queue and its staging directory once the backup set is complete.
+func (q *uploadQueue) finish() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return helpers.RemoveTempDir(q.dir)
+}
+
+// save will atomically write the state of the queue to disk. The caller must hold the lock.
+func (q *uploadQueue) save() error {
+	data, err := json.Marshal(q.state)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(q.dir, queueFileName)
+	if err = ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// resumeVolumes will return, sorted by volume number, the staged volumes that follow the volume numbered
+// volNum, along with the number of bytes of the zfs send stream they hold.
+func resumeVolumes(staged map[int64]*helpers.VolumeInfo, volNum int64) (volumes []*helpers.VolumeInfo, streamBytes uint64) {
+	for vol, ok := staged[volNum]; ok; vol, ok = staged[volNum] {
+		volumes = append(volumes, vol)
+		streamBytes += vol.ZFSStreamBytes
+		volNum++
+	}
+	return volumes, streamBytes
+}
@@ -0,0 +1,167 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestResumeVolumes(t *testing.T) {
+	staged := map[int64]*helpers.VolumeInfo{
+		1: {VolumeNumber: 1, ZFSStreamBytes: 10},
+		2: {VolumeNumber: 2, ZFSStreamBytes: 20},
+		4: {VolumeNumber: 4, ZFSStreamBytes: 40},
+	}
+
+	testCases := []struct {
+		volNum      int64
+		expectVols  []int64
+		expectBytes uint64
+	}{
+		{1, []int64{1, 2}, 30},
+		{2, []int64{2}, 20},
+		{3, nil, 0},
+		{4, []int64{4}, 40},
+	}
+
+	for idx, c := range testCases {
+		vols, streamBytes := resumeVolumes(staged, c.volNum)
+		if streamBytes != c.expectBytes || len(vols) != len(c.expectVols) {
+			t.Errorf("%d: expected volumes %v (%d bytes), got %d volumes (%d bytes)", idx, c.expectVols, c.expectBytes, len(vols), streamBytes)
+			continue
+		}
+		for i, vol := range vols {
+			if vol.VolumeNumber != c.expectVols[i] {
+				t.Errorf("%d: expected volume %d at index %d, got %d", idx, c.expectVols[i], i, vol.VolumeNumber)
+			}
+		}
+	}
+}
+
+func TestUploadQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupqueue")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = dir
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	ctx := context.Background()
+	j := &helpers.JobInfo{
+		VolumeName:   "Tank/Data",
+		BaseSnapshot: helpers.SnapshotInfo{Name: "snap1"},
+		Compressor:   helpers.InternalCompressor,
+		Separator:    "|",
+	}
+
+	queue, err := openUploadQueue(ctx, j)
+	if err != nil {
+		t.Fatalf("could not open the upload queue: %v", err)
+	}
+	if queue.Len() != 0 {
+		t.Fatalf("expected an empty queue, got %d volumes", queue.Len())
+	}
+
+	var vols []*helpers.VolumeInfo
+	for i := int64(1); i <= 2; i++ {
+		path := filepath.Join(queue.dir, fmt.Sprintf("vol%d", i))
+		if err = ioutil.WriteFile(path, []byte("data"), 0600); err != nil {
+			t.Fatalf("could not write volume: %v", err)
+		}
+		vol := &helpers.VolumeInfo{ObjectName: fmt.Sprintf("Tank/Data|snap1.zstream.gz.vol%d", i), VolumeNumber: i, SHA1Sum: "sha1"}
+		vol.UseStagedFile(path)
+		if err = queue.add(vol); err != nil {
+			t.Fatalf("could not add volume: %v", err)
+		}
+		vols = append(vols, vol)
+	}
+
+	if err = queue.markUploaded(vols[0], "file:///a"); err != nil {
+		t.Fatalf("could not mark volume as uploaded: %v", err)
+	}
+	if err = queue.remove(vols[1]); err != nil {
+		t.Fatalf("could not remove volume: %v", err)
+	}
+	if err = queue.add(vols[1]); err != nil {
+		t.Fatalf("could not add volume: %v", err)
+	}
+	if err = os.Remove(vols[1].StagedPath()); err != nil {
+		t.Fatalf("could not delete volume: %v", err)
+	}
+
+	// Reopen the queue as a new run would
+	queue, err = openUploadQueue(ctx, j)
+	if err != nil {
+		t.Fatalf("could not reopen the upload queue: %v", err)
+	}
+	if queue.Len() != 2 {
+		t.Fatalf("expected 2 volumes in the queue, got %d", queue.Len())
+	}
+
+	staged, err := queue.staged(ctx, j)
+	if err != nil {
+		t.Fatalf("could not read the staged volumes: %v", err)
+	}
+	if len(staged) != 1 || staged[1] == nil {
+		t.Fatalf("expected only volume 1 to be staged, got %v", staged)
+	}
+	if staged[1].SHA1Sum != "sha1" || staged[1].StagedPath() != vols[0].StagedPath() {
+		t.Errorf("expected the staged volume to be restored, got %v", staged[1])
+	}
+	if !queue.uploaded(staged[1], "file:///a") || queue.uploaded(staged[1], "file:///b") {
+		t.Errorf("expected volume 1 to be uploaded to file:///a only")
+	}
+
+	other := *j
+	other.Compressor = "xz"
+	if _, err = queue.staged(ctx, &other); err == nil {
+		t.Errorf("expected an error resuming with a different compressor")
+	}
+
+	if err = queue.discard(ctx, j); err != nil {
+		t.Fatalf("could not discard the queue: %v", err)
+	}
+	if _, err = os.Stat(vols[0].StagedPath()); !os.IsNotExist(err) {
+		t.Errorf("expected the staged volume to be deleted, got %v", err)
+	}
+
+	if err = queue.finish(); err != nil {
+		t.Fatalf("could not finish the queue: %v", err)
+	}
+	if _, err = os.Stat(queue.dir); !os.IsNotExist(err) {
+		t.Errorf("expected the staging directory to be deleted, got %v", err)
+	}
+
+	var nilQueue *uploadQueue
+	if nilQueue.Len() != 0 || nilQueue.add(vols[0]) != nil || nilQueue.uploaded(vols[0], "file:///a") || nilQueue.finish() != nil {
+		t.Errorf("expected a nil queue to persist nothing")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoRemap is returned when none of the mappings provided applies to the volume being restored.
+var ErrNoRemap = errors.New("no mapping applies to the volume")
+
+// RemapVolume will return the local volume the backed up volume provided should be restored to according
+// to the source=target dataset mappings provided. The mapping of the longest source dataset containing the
+// volume is used and the rest of its path is kept, e.g. tank/prod=backuppool/restore restores tank/prod/db
+// to backuppool/restore/db. Since zfs recv places the children of a replication stream under the volume it
+// receives into, a mapping for a child of the volume must agree with where it would land.
+func RemapVolume(volume string, mappings map[string]string) (string, error) {
+	var source string
+	for src := range mappings {
+		if (volume == src || strings.HasPrefix(volume, src+"/")) && len(src) > len(source) {
+			source = src
+		}
+	}
+	if source == "" {
+		return "", ErrNoRemap
+	}
+
+	target := mappings[source] + strings.TrimPrefix(volume, source)
+	for src, dst := range mappings {
+		if !strings.HasPrefix(src, volume+"/") {
+			continue
+		}
+		if expected := target + strings.TrimPrefix(src, volume); dst != expected {
+			return "", fmt.Errorf("%s would be restored to %s along with %s, it cannot be remapped to %s", src, expected, volume, dst)
+		}
+	}
+	return target, nil
+}
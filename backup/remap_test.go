@@ -0,0 +1,60 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import "testing"
+
+func TestRemapVolume(t *testing.T) {
+	mappings := map[string]string{
+		"tank":           "backuppool/tank",
+		"tank/prod":      "backuppool/restore",
+		"tank/prod/db/a": "backuppool/restore/db/a",
+		"tank/other":     "elsewhere",
+	}
+	conflicting := map[string]string{
+		"tank/prod":    "backuppool/restore",
+		"tank/prod/db": "scratch/db",
+	}
+
+	testCases := []struct {
+		volume   string
+		mappings map[string]string
+		expect   string
+		errTest  errTestFunc
+	}{
+		{"tank/prod", mappings, "backuppool/restore", nilErrTest},
+		{"tank/prod/db", mappings, "backuppool/restore/db", nilErrTest},
+		{"tank/production", mappings, "backuppool/tank/production", nilErrTest},
+		{"tank/other/x", mappings, "elsewhere/x", nilErrTest},
+		{"pool/prod", mappings, "", func(e error) bool { return e == ErrNoRemap }},
+		{"tank/prod/db", conflicting, "scratch/db", nilErrTest},
+		{"tank/prod", conflicting, "", nonNilErrTest},
+	}
+
+	for idx, c := range testCases {
+		got, err := RemapVolume(c.volume, c.mappings)
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+		} else if got != c.expect {
+			t.Errorf("%d: expected %s, got %s", idx, c.expect, got)
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -40,9 +41,17 @@ import (
 	"github.com/someone1/zfsbackup-go/helpers"
 )
 
+// The snapshot names selecting the newest restore point of a volume, and the newest full backup set of it,
+// when receiving.
+const (
+	LatestSelector     = "latest"
+	LatestFullSelector = "latest-full"
+)
+
 type downloadSequence struct {
-	volume *helpers.VolumeInfo
-	c      chan<- *helpers.VolumeInfo
+	volume   *helpers.VolumeInfo
+	c        chan<- *helpers.VolumeInfo
+	manifest *helpers.JobInfo // The backup set of the volume, to rebuild it from its parity group if it has one
 }
 
 // AutoRestore will compute which snapshots need to be restored to get to the snapshot provided,
@@ -51,64 +60,148 @@ func AutoRestore(pctx context.Context, jobInfo *helpers.JobInfo) error {
 	ctx, cancel := context.WithCancel(pctx)
 	defer cancel()
 
-	// Prepare the backend client
+	jobsToRestore, err := restoreChain(ctx, jobInfo)
+	if err != nil {
+		return err
+	}
+
+	if len(jobsToRestore) == 0 {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Selected snapshot already exists, nothing to do!")
+		return nil
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Infof("Need to restore %d snapshots.", len(jobsToRestore))
+	jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobStarted})
+
 	target := jobInfo.Destinations[0]
 	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
 	if berr != nil {
-		helpers.AppLogger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
 		return berr
 	}
 	defer backend.Close()
 
-	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(jobInfo.Destinations[0])
+	localCachePath, cerr := getCacheDir(ctx, target)
 	if cerr != nil {
-		helpers.AppLogger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
 		return cerr
 	}
 
+	// We have a list of snapshots we need to restore, oldest first
+	sets := make([]*restoreSet, 0, len(jobsToRestore))
+	for idx, job := range jobsToRestore {
+		setJob := *jobInfo
+		setJob.BaseSnapshot = job.BaseSnapshot
+		setJob.IncrementalSnapshot = job.IncrementalSnapshot
+		setJob.Compressor = job.Compressor
+		setJob.Separator = job.Separator
+		manifest, merr := loadManifest(ctx, backend, localCachePath, &setJob)
+		if merr != nil {
+			return merr
+		}
+		sets = append(sets, &restoreSet{manifest: manifest, toFile: streamFilePath(jobInfo.ToFile, idx, len(jobsToRestore))})
+	}
+
+	if err := restoreSets(ctx, jobInfo, backend, target, sets); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Failed to restore snapshot.")
+		return err
+	}
+
+	jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobFinished})
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Done.")
+
+	return nil
+}
+
+// restoreChain will return the backup sets, oldest first, that need to be restored to reach
+// the snapshot requested, or the latest snapshot of the volume requested, from what is found locally.
+func restoreChain(ctx context.Context, jobInfo *helpers.JobInfo) ([]*helpers.JobInfo, error) {
+	// Prepare the backend client
+	target := jobInfo.Destinations[0]
+	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
+	if berr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		return nil, berr
+	}
+	defer backend.Close()
+
+	// Get the local cache dir
+	localCachePath, cerr := getCacheDir(ctx, jobInfo.Destinations[0])
+	if cerr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		return nil, cerr
+	}
+
 	// Sync the local cache
 	safeManifests, _, serr := syncCache(ctx, jobInfo, localCachePath, backend)
 	if serr != nil {
-		helpers.AppLogger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
-		return serr
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, serr)
+		return nil, serr
 	}
 
 	decodedManifests, derr := readAndSortManifests(ctx, localCachePath, safeManifests, jobInfo)
 	if derr != nil {
-		return derr
+		return nil, derr
 	}
-	manifestTree := linkManifests(decodedManifests)
+	manifestTree := linkManifests(ctx, decodedManifests)
 	var ok bool
 	var volumeSnaps []*helpers.JobInfo
 	if volumeSnaps, ok = manifestTree[jobInfo.VolumeName]; !ok {
-		helpers.AppLogger.Errorf("Could not find any snapshots for volume %s, none found on target.", jobInfo.VolumeName)
-		return errors.New("could not determine any snapshots for provided volume")
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not find any snapshots for volume %s, none found on target.", jobInfo.VolumeName)
+		return nil, errors.New("could not determine any snapshots for provided volume")
+	}
+
+	// Restore to the newest restore point, unless a snapshot is actually named like the selector
+	if IsLatestSelector(jobInfo.BaseSnapshot.Name) && !hasSnapshot(volumeSnaps, jobInfo.BaseSnapshot.Name) {
+		fullOnly := jobInfo.BaseSnapshot.Name == LatestFullSelector
+		snapshot, ok := latestRestorePoint(volumeSnaps, fullOnly)
+		if !ok {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not find any restorable snapshot of volume %s to resolve %s on target.", jobInfo.VolumeName, jobInfo.BaseSnapshot.Name)
+			return nil, errors.New("could not find a restorable snapshot for the selector provided")
+		}
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Resolved %s to snapshot %s taken %v.", jobInfo.BaseSnapshot.Name, snapshot.Name, snapshot.CreationTime)
+		jobInfo.BaseSnapshot = snapshot
+	}
+
+	// Restore to the latest snapshot taken at or before the time provided
+	if jobInfo.BaseSnapshot.Name == "" && !jobInfo.RestoreAt.IsZero() {
+		helpers.EnvironmentOf(ctx).Logger.Infof("Trying to determine the latest snapshot of volume %s taken at or before %v.", jobInfo.VolumeName, jobInfo.RestoreAt)
+		snapshot, ok := snapshotAt(volumeSnaps, jobInfo.RestoreAt)
+		if !ok {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not find any snapshot of volume %s taken at or before %v on target.", jobInfo.VolumeName, jobInfo.RestoreAt)
+			return nil, errors.New("could not find a snapshot taken at or before the time provided")
+		}
+		jobInfo.BaseSnapshot = snapshot
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Restoring to snapshot %s taken %v.", jobInfo.BaseSnapshot.Name, jobInfo.BaseSnapshot.CreationTime)
 	}
 
 	// Restore to the latest snapshot available for the volume provided if no snapshot was provided
 	if jobInfo.BaseSnapshot.Name == "" {
-		helpers.AppLogger.Infof("Trying to determine latest snapshot for volume %s.", jobInfo.VolumeName)
+		helpers.EnvironmentOf(ctx).Logger.Infof("Trying to determine latest snapshot for volume %s.", jobInfo.VolumeName)
 		jobInfo.BaseSnapshot = (volumeSnaps[len(volumeSnaps)-1].BaseSnapshot)
-		helpers.AppLogger.Infof("Restoring to snapshot %s.", jobInfo.BaseSnapshot.Name)
+		helpers.EnvironmentOf(ctx).Logger.Infof("Restoring to snapshot %s.", jobInfo.BaseSnapshot.Name)
 	}
 
-	// Find the matching backup job for the snapshot we want to restore to
+	// Find the matching backup job for the snapshot we want to restore to, preferring a full backup (e.g. consolidated) over an incremental one
 	var jobToRestore *helpers.JobInfo
 	for _, job := range volumeSnaps {
-		if strings.Compare(job.BaseSnapshot.Name, jobInfo.BaseSnapshot.Name) == 0 {
+		if strings.Compare(job.BaseSnapshot.Name, jobInfo.BaseSnapshot.Name) != 0 {
+			continue
+		}
+		if jobToRestore == nil || job.IncrementalSnapshot.Name == "" {
 			jobToRestore = job
+		}
+		if job.IncrementalSnapshot.Name == "" {
 			break
 		}
 	}
 	if jobToRestore == nil {
-		helpers.AppLogger.Errorf("Could not find the snapshot %v for volume %s on backend.", jobInfo.BaseSnapshot.Name, jobInfo.VolumeName)
-		return errors.New("could not find snapshot provided")
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not find the snapshot %v for volume %s on backend.", jobInfo.BaseSnapshot.Name, jobInfo.VolumeName)
+		return nil, errors.New("could not find snapshot provided")
 	}
 
 	// We have the snapshot we'd like to restore to, let's figure out whats already found locally and restore as required
-	jobsToRestore := make([]*helpers.JobInfo, 0, 10)
-	helpers.AppLogger.Infof("Calculating how to restore to %s.", jobInfo.BaseSnapshot.Name)
+	helpers.EnvironmentOf(ctx).Logger.Infof("Calculating how to restore to %s.", jobInfo.BaseSnapshot.Name)
 	volume := jobInfo.LocalVolume
 	parts := strings.Split(jobInfo.VolumeName, "/")
 	if jobInfo.FullPath {
@@ -120,70 +213,145 @@ func AutoRestore(pctx context.Context, jobInfo *helpers.JobInfo) error {
 		volume = fmt.Sprintf("%s/%s", volume, parts[len(parts)-1])
 	}
 
-	snapshots, err := helpers.GetSnapshots(ctx, volume)
-	if err != nil {
-		// TODO: There are some error cases that are ok to ignore!
-		snapshots = []helpers.SnapshotInfo{}
+	// Nothing is received locally when writing the streams to files, every backup set of the chain is needed
+	var snapshots []helpers.SnapshotInfo
+	if jobInfo.ToFile == "" {
+		var err error
+		snapshots, err = localSnapshots(ctx, volume)
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list the snapshots already found on %s due to error: %v", volume, err)
+			return nil, err
+		}
 	}
 
-	if jobInfo.Origin != "" {
+	if jobInfo.Origin != "" && jobInfo.ToFile == "" {
 		originSnapshot, oerr := helpers.GetSnapshots(ctx, jobInfo.Origin)
 		if oerr != nil {
-			helpers.AppLogger.Errorf("Could not get origin snapshot %s info due to error: %v", jobInfo.Origin, oerr)
-			return oerr
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get origin snapshot %s info due to error: %v", jobInfo.Origin, oerr)
+			return nil, oerr
 		}
 
 		if len(originSnapshot) == 1 {
 			// The origin snapshot can be added as an existing snapshot we can start the restore from
 			snapshots = append(snapshots, originSnapshot[0])
 		} else {
-			helpers.AppLogger.Errorf("Could not find origin snapshot %s", jobInfo.Origin)
-			return fmt.Errorf("Could not find origin snapshot %s", jobInfo.Origin)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not find origin snapshot %s", jobInfo.Origin)
+			return nil, fmt.Errorf("Could not find origin snapshot %s", jobInfo.Origin)
 		}
 	}
 
+	jobsToRestore, found, err := missingSets(jobToRestore, snapshots)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot restore to %s on %s - %v", jobInfo.BaseSnapshot.Name, volume, err)
+		return nil, err
+	}
+	for _, job := range jobsToRestore {
+		helpers.EnvironmentOf(ctx).Logger.Infof("Adding backup job for %s to the restore list.", job.BaseSnapshot.Name)
+	}
+	if found != nil {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Snapshot %s already exists on %s, skipping the backup sets up to it.", found.Name, volume)
+	}
+	return jobsToRestore, nil
+}
+
+// missingSets will walk the backup chain ending at the backup set provided back to the latest snapshot
+// found in the snapshots provided, returning the backup sets that still need to be restored, oldest
+// first, along with the snapshot the restore will start from, if any.
+func missingSets(set *helpers.JobInfo, snapshots []helpers.SnapshotInfo) ([]*helpers.JobInfo, *helpers.SnapshotInfo, error) {
+	jobsToRestore := make([]*helpers.JobInfo, 0, 10)
+	var found *helpers.SnapshotInfo
 	for {
 		// See if the snapshots we want to restore already exist
-		if ok := validateSnapShotExistsFromSnaps(&jobToRestore.BaseSnapshot, snapshots); ok {
+		if ok := validateSnapShotExistsFromSnaps(&set.BaseSnapshot, snapshots); ok {
+			found = &set.BaseSnapshot
 			break
 		}
 
-		helpers.AppLogger.Infof("Adding backup job for %s to the restore list.", jobToRestore.BaseSnapshot.Name)
-		jobsToRestore = append(jobsToRestore, jobToRestore)
-		if jobToRestore.IncrementalSnapshot.Name == "" {
+		// A snapshot of the same name that is not the one backed up can't be received over
+		for idx := range snapshots {
+			if snapshots[idx].Name == set.BaseSnapshot.Name {
+				return nil, nil, fmt.Errorf("snapshot %s already exists but was created at %v instead of %v as the one backed up",
+					set.BaseSnapshot.Name, snapshots[idx].CreationTime, set.BaseSnapshot.CreationTime)
+			}
+		}
+
+		if set.External != "" {
+			return nil, nil, fmt.Errorf("snapshot %s was adopted from %s, receive it from the copy %s keeps first", set.BaseSnapshot.Name, set.External, set.External)
+		}
+
+		jobsToRestore = append(jobsToRestore, set)
+		if set.IncrementalSnapshot.Name == "" {
 			// This is a full backup, no need to go further back
 			break
 		}
-		if jobToRestore.ParentSnap == nil {
-			helpers.AppLogger.Errorf("Want to restore parent snap %s but it is not found in the backend, aborting.", jobToRestore.IncrementalSnapshot.Name)
-			return errors.New("could not find parent snapshot")
+		if set.ParentSnap == nil {
+			return nil, nil, fmt.Errorf("parent snapshot %s is not found in the backend", set.IncrementalSnapshot.Name)
 		}
-		jobToRestore = jobToRestore.ParentSnap
+		set = set.ParentSnap
 	}
 
-	helpers.AppLogger.Infof("Need to restore %d snapshots.", len(jobsToRestore))
+	// Restore the oldest snapshot first
+	for i, j := 0, len(jobsToRestore)-1; i < j; i, j = i+1, j-1 {
+		jobsToRestore[i], jobsToRestore[j] = jobsToRestore[j], jobsToRestore[i]
+	}
+	return jobsToRestore, found, nil
+}
 
-	// We have a list of snapshots we need to restore, start at the end and work our way down
-	for i := len(jobsToRestore) - 1; i >= 0; i-- {
-		jobInfo.BaseSnapshot = jobsToRestore[i].BaseSnapshot
-		jobInfo.IncrementalSnapshot = jobsToRestore[i].IncrementalSnapshot
-		jobInfo.Volumes = jobsToRestore[i].Volumes
-		jobInfo.Compressor = jobsToRestore[i].Compressor
-		jobInfo.Separator = jobsToRestore[i].Separator
-		helpers.AppLogger.Infof("Restoring snapshot %s (%d/%d)", jobInfo.BaseSnapshot.Name, len(jobsToRestore)-i, len(jobsToRestore))
-		if err := Receive(ctx, jobInfo); err != nil {
-			helpers.AppLogger.Errorf("Failed to restore snapshot.")
-			return err
+// snapshotAt will return the latest snapshot backed up by the backup sets provided, sorted oldest first,
+// that was taken at or before the time provided.
+func snapshotAt(sets []*helpers.JobInfo, at time.Time) (helpers.SnapshotInfo, bool) {
+	for idx := len(sets) - 1; idx >= 0; idx-- {
+		if !sets[idx].BaseSnapshot.CreationTime.After(at) {
+			return sets[idx].BaseSnapshot, true
 		}
 	}
+	return helpers.SnapshotInfo{}, false
+}
 
-	helpers.AppLogger.Noticef("Done.")
+// IsLatestSelector will return whether the snapshot name provided selects the newest restore point
+// of the volume, see LatestSelector and LatestFullSelector. Receiving one implies an auto restore.
+func IsLatestSelector(name string) bool {
+	return name == LatestSelector || name == LatestFullSelector
+}
 
-	return nil
+// hasSnapshot will return whether any of the backup sets provided is of the snapshot named.
+func hasSnapshot(sets []*helpers.JobInfo, name string) bool {
+	for _, set := range sets {
+		if set.BaseSnapshot.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// latestRestorePoint will return the snapshot of the newest backup set provided, sorted oldest first,
+// that is chained to a full backup set, or of the newest full backup set if fullOnly is set.
+func latestRestorePoint(sets []*helpers.JobInfo, fullOnly bool) (helpers.SnapshotInfo, bool) {
+	restorable := newChainGraph(sets).restorable()
+	for idx := len(sets) - 1; idx >= 0; idx-- {
+		if fullOnly && sets[idx].IncrementalSnapshot.Name != "" {
+			continue
+		}
+		if restorable[sets[idx]] {
+			return sets[idx].BaseSnapshot, true
+		}
+	}
+	return helpers.SnapshotInfo{}, false
 }
 
 // Receive will download and restore the backup job described to the Volume target provided.
-func Receive(pctx context.Context, jobInfo *helpers.JobInfo) error {
+func Receive(ctx context.Context, jobInfo *helpers.JobInfo) error {
+	jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobStarted})
+	if err := receive(ctx, jobInfo, jobInfo.ToFile); err != nil {
+		return err
+	}
+	jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobFinished})
+	return nil
+}
+
+// receive will download and restore the backup job described, writing the zfs send stream to the
+// local file toFile instead of receiving it when provided.
+func receive(pctx context.Context, jobInfo *helpers.JobInfo, toFile string) error {
 	ctx, cancel := context.WithCancel(pctx)
 	defer cancel()
 
@@ -192,95 +360,92 @@ func Receive(pctx context.Context, jobInfo *helpers.JobInfo) error {
 	// Prepare the backend client
 	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
 	if berr != nil {
-		helpers.AppLogger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
 		return berr
 	}
 	defer backend.Close()
 
 	// Get the local cache dir
-	localCachePath, cerr := getCacheDir(target)
+	localCachePath, cerr := getCacheDir(ctx, target)
 	if cerr != nil {
-		helpers.AppLogger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
 		return cerr
 	}
 
-	// See if the snapshots we want to restore already exist
-	volume := jobInfo.LocalVolume
-	parts := strings.Split(jobInfo.VolumeName, "/")
-	if jobInfo.FullPath {
-		parts[0] = volume
-		volume = strings.Join(parts, "/")
-	}
+	if toFile == "" {
+		// See if the snapshots we want to restore already exist
+		volume := jobInfo.LocalVolume
+		parts := strings.Split(jobInfo.VolumeName, "/")
+		if jobInfo.FullPath {
+			parts[0] = volume
+			volume = strings.Join(parts, "/")
+		}
 
-	if jobInfo.LastPath {
-		volume = fmt.Sprintf("%s/%s", volume, parts[len(parts)-1])
-	}
+		if jobInfo.LastPath {
+			volume = fmt.Sprintf("%s/%s", volume, parts[len(parts)-1])
+		}
 
-	if jobInfo.BaseSnapshot.CreationTime.IsZero() {
-		if ok, verr := validateSnapShotExists(ctx, &jobInfo.BaseSnapshot, volume); verr != nil {
-			helpers.AppLogger.Errorf("Cannot validate if selected base snapshot exists due to error - %v", verr)
-			return verr
-		} else if ok {
-			helpers.AppLogger.Noticef("Selected base snapshot already exists, nothing to do!")
-			return nil
+		if jobInfo.BaseSnapshot.CreationTime.IsZero() {
+			if ok, verr := validateSnapShotExists(ctx, &jobInfo.BaseSnapshot, volume); verr != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot validate if selected base snapshot exists due to error - %v", verr)
+				return verr
+			} else if ok {
+				helpers.EnvironmentOf(ctx).Logger.Noticef("Selected base snapshot already exists, nothing to do!")
+				return nil
+			}
 		}
-	}
 
-	// Check that we have the parent snap shot this wants to restore from
-	if jobInfo.IncrementalSnapshot.Name != "" && jobInfo.IncrementalSnapshot.CreationTime.IsZero() {
-		if ok, verr := validateSnapShotExists(ctx, &jobInfo.IncrementalSnapshot, volume); verr != nil {
-			helpers.AppLogger.Errorf("Cannot validate if selected incremental snapshot exists due to error - %v", verr)
-			return verr
-		} else if !ok {
-			helpers.AppLogger.Errorf("Selected incremental snapshot does not exist!")
-			return fmt.Errorf("selected incremental snapshot does not exist")
+		// Check that we have the parent snap shot this wants to restore from
+		if jobInfo.IncrementalSnapshot.Name != "" && jobInfo.IncrementalSnapshot.CreationTime.IsZero() {
+			if ok, verr := validateSnapShotExists(ctx, &jobInfo.IncrementalSnapshot, volume); verr != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Cannot validate if selected incremental snapshot exists due to error - %v", verr)
+				return verr
+			} else if !ok {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Selected incremental snapshot does not exist!")
+				return fmt.Errorf("selected incremental snapshot does not exist")
+			}
 		}
 	}
 
-	// Compute the Manifest File
-	tempManifest, err := helpers.CreateManifestVolume(ctx, jobInfo)
+	manifest, err := loadManifest(ctx, backend, localCachePath, jobInfo)
 	if err != nil {
-		helpers.AppLogger.Errorf("Error trying to create manifest volume - %v", err)
 		return err
 	}
-	tempManifest.Close()
-	tempManifest.DeleteVolume()
-	safeManifestFile := fmt.Sprintf("%x", md5.Sum([]byte(tempManifest.ObjectName)))
-	safeManifestPath := filepath.Join(localCachePath, safeManifestFile)
 
-	// Check to see if we have the manifest file locally
-	manifest, err := readManifest(ctx, safeManifestPath, jobInfo)
-	if err != nil {
-		if os.IsNotExist(err) {
-			err = backend.PreDownload(ctx, []string{tempManifest.ObjectName})
-			if err != nil {
-				helpers.AppLogger.Errorf("Error trying to pre download manifest volume %s - %v", tempManifest.ObjectName, err)
-				return err
-			}
-			// Try and download the manifest file from the backend
-			downloadTo(ctx, backend, tempManifest.ObjectName, safeManifestPath)
-			manifest, err = readManifest(ctx, safeManifestPath, jobInfo)
-		}
-		if err != nil {
-			helpers.AppLogger.Errorf("Error trying to retrieve manifest volume - %v", err)
-			return err
-		}
-	}
+	return restoreSets(ctx, jobInfo, backend, target, []*restoreSet{{manifest: manifest, toFile: toFile}})
+}
 
-	manifest.ManifestPrefix = jobInfo.ManifestPrefix
-	manifest.SignKey = jobInfo.SignKey
-	manifest.EncryptKey = jobInfo.EncryptKey
+// restoreSet is a backup set to restore along with the channel its downloaded volumes are sent to, in order.
+type restoreSet struct {
+	manifest *helpers.JobInfo
+	toFile   string
+	volumes  chan *helpers.VolumeInfo
+}
+
+// restoreSets will restore the backup sets provided one after the other, in order. A single pool of workers
+// downloads and verifies the volumes of all of them so the volumes of the next backup sets are prefetched
+// while the current one is received, with at most MaxFileBuffer volumes downloaded ahead at any time. With
+// VerifyFirst, every volume of every backup set is downloaded and verified before anything is received.
+func restoreSets(pctx context.Context, jobInfo *helpers.JobInfo, backend backends.Backend, target string, sets []*restoreSet) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
 
 	// Get list of Objects
-	toDownload := make([]string, len(manifest.Volumes))
-	for idx := range manifest.Volumes {
-		toDownload[idx] = manifest.Volumes[idx].ObjectName
+	var toDownload []string
+	var volumeCount int
+	for _, set := range sets {
+		jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobPlanned, Bytes: set.manifest.TotalBytesWritten()})
+		jobInfo.Manifests = append(jobInfo.Manifests, helpers.ManifestObjectName(set.manifest))
+		for idx := range set.manifest.Volumes {
+			toDownload = append(toDownload, set.manifest.Volumes[idx].ObjectName)
+		}
+		volumeCount += len(set.manifest.Volumes)
 	}
 
 	// PreDownload step
-	err = backend.PreDownload(ctx, toDownload)
+	err := backend.PreDownload(ctx, toDownload)
 	if err != nil {
-		helpers.AppLogger.Errorf("Error trying to pre download backup set volumes - %v", err)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error trying to pre download backup set volumes - %v", err)
 		return err
 	}
 	toDownload = nil
@@ -293,16 +458,28 @@ func Receive(pctx context.Context, jobInfo *helpers.JobInfo) error {
 		usePipe = true
 	}
 
-	downloadChannel := make(chan downloadSequence, len(manifest.Volumes))
-	bufferChannel := make(chan interface{}, fileBufferSize)
-	orderedChannels := make([]chan *helpers.VolumeInfo, len(manifest.Volumes))
+	bufferSize := fileBufferSize
+	if jobInfo.VerifyFirst {
+		// Every volume is kept on the local disk until the stream is verified
+		bufferSize = volumeCount
+		helpers.EnvironmentOf(ctx).Logger.Noticef("Downloading and verifying all %d volumes before restoring anything.", volumeCount)
+	}
+
+	downloadChannel := make(chan downloadSequence, volumeCount)
+	bufferChannel := make(chan interface{}, bufferSize)
+	verified := make(chan struct{})
+	orderedChannels := make([][]chan *helpers.VolumeInfo, len(sets))
 	defer close(bufferChannel)
 
-	// Queue up files to download
-	for idx := range manifest.Volumes {
-		c := make(chan *helpers.VolumeInfo, 1)
-		orderedChannels[idx] = c
-		downloadChannel <- downloadSequence{manifest.Volumes[idx], c}
+	// Queue up files to download, backup set after backup set
+	for sidx, set := range sets {
+		set.volumes = make(chan *helpers.VolumeInfo, len(set.manifest.Volumes))
+		orderedChannels[sidx] = make([]chan *helpers.VolumeInfo, len(set.manifest.Volumes))
+		for idx := range set.manifest.Volumes {
+			c := make(chan *helpers.VolumeInfo, 1)
+			orderedChannels[sidx][idx] = c
+			downloadChannel <- downloadSequence{set.manifest.Volumes[idx], c, set.manifest}
+		}
 	}
 	close(downloadChannel)
 
@@ -320,90 +497,291 @@ func Receive(pctx context.Context, jobInfo *helpers.JobInfo) error {
 					if !ok {
 						return nil
 					}
-					defer close(sequence.c)
-					select {
-					case <-ctx.Done():
-						return ctx.Err()
-					case bufferChannel <- nil:
-					}
-
-					be := backoff.NewExponentialBackOff()
-					be.MaxInterval = jobInfo.MaxBackoffTime
-					be.MaxElapsedTime = jobInfo.MaxRetryTime
-					retryconf := backoff.WithContext(be, ctx)
-
-					operation := func() error {
-						oerr := processSequence(ctx, sequence, backend, usePipe)
-						if oerr != nil {
-							helpers.AppLogger.Warningf("error trying to download file %s - %v", sequence.volume.ObjectName, oerr)
-						}
-						return oerr
-					}
-
-					helpers.AppLogger.Debugf("Downloading volume %s.", sequence.volume.ObjectName)
-
-					if berr := backoff.Retry(operation, retryconf); berr != nil {
-						helpers.AppLogger.Errorf("Failed to download volume %s due to error: %v, aborting...", sequence.volume.ObjectName, berr)
-						return berr
+					if err := downloadVolume(ctx, jobInfo, backend, target, sequence, bufferChannel, usePipe); err != nil {
+						return err
 					}
 				}
 			}
 		})
 	}
 
-	// Order the downloaded Volumes
-	orderedVolumes := make(chan *helpers.VolumeInfo, len(toDownload))
+	// Order the downloaded Volumes of every backup set
 	wg.Go(func() error {
-		defer close(orderedVolumes)
-		for _, c := range orderedChannels {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case orderedVolumes <- <-c:
-				continue
+		if !jobInfo.VerifyFirst {
+			close(verified)
+		}
+		for sidx, set := range sets {
+			for _, c := range orderedChannels[sidx] {
+				var vol *helpers.VolumeInfo
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case vol = <-c:
+				}
+				if vol == nil {
+					// The download failed, its worker is returning the error
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				if jobInfo.VerifyFirst {
+					if err := verifyVolume(ctx, set.manifest, vol); err != nil {
+						helpers.LogFields{Dataset: set.manifest.VolumeName, Volume: vol.ObjectName, Err: err}.Errorf(ctx, "Could not verify volume %s, nothing was restored - %v", vol.ObjectName, err)
+						vol.DeleteVolume()
+						return helpers.NewError(helpers.ErrorKindVerification, err)
+					}
+					jobInfo.ReportProgress(helpers.ProgressEvent{
+						Type:         helpers.ProgressVolumeVerified,
+						ObjectName:   vol.ObjectName,
+						Destination:  target,
+						VolumeNumber: vol.VolumeNumber,
+						Bytes:        vol.Size,
+					})
+				}
+				set.volumes <- vol
 			}
+			close(set.volumes)
+		}
+		if jobInfo.VerifyFirst {
+			helpers.EnvironmentOf(ctx).Logger.Noticef("Verified %d volumes.", volumeCount)
+			close(verified)
 		}
 		return nil
 	})
 
-	// Prepare ZFS Receive command
-	cmd := helpers.GetZFSReceiveCommand(ctx, jobInfo)
+	// Restore the backup sets one after the other as their volumes come in
 	wg.Go(func() error {
-		return receiveStream(ctx, cmd, manifest, orderedVolumes, bufferChannel)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-verified:
+		}
+		for idx, set := range sets {
+			jobInfo.BaseSnapshot = set.manifest.BaseSnapshot
+			jobInfo.IncrementalSnapshot = set.manifest.IncrementalSnapshot
+			jobInfo.Volumes = set.manifest.Volumes
+			jobInfo.Compressor = set.manifest.Compressor
+			jobInfo.Separator = set.manifest.Separator
+			if len(sets) > 1 {
+				helpers.EnvironmentOf(ctx).Logger.Infof("Restoring snapshot %s (%d/%d)", jobInfo.BaseSnapshot.Name, idx+1, len(sets))
+			}
+
+			var rerr error
+			if set.toFile != "" {
+				rerr = writeStreamFile(ctx, set.toFile, set.manifest, set.volumes, bufferChannel)
+			} else {
+				// Prepare ZFS Receive command
+				cmd := helpers.GetZFSReceiveCommand(ctx, jobInfo)
+				rerr = receiveStream(ctx, cmd, set.manifest, set.volumes, bufferChannel)
+			}
+			if rerr != nil {
+				return rerr
+			}
+			helpers.EnvironmentOf(ctx).Logger.Noticef("Done. Elapsed Time: %v", time.Since(jobInfo.StartTime))
+		}
+		return nil
 	})
 
 	// Wait for processes to finish
 	err = wg.Wait()
 	if err != nil {
-		helpers.AppLogger.Errorf("There was an error during the restore process, aborting: %v", err)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("There was an error during the restore process, aborting: %v", err)
+		if jobInfo.VerifyFirst {
+			deleteDownloadedVolumes(sets, orderedChannels)
+		}
 		return err
 	}
 
-	helpers.AppLogger.Noticef("Done. Elapsed Time: %v", time.Since(jobInfo.StartTime))
 	return nil
 }
 
+// downloadVolume will download and verify the volume of the sequence provided, once there is room for it in buffer,
+// and send it to the channel of the sequence.
+func downloadVolume(ctx context.Context, jobInfo *helpers.JobInfo, backend backends.Backend, target string, sequence downloadSequence, buffer chan<- interface{}, usePipe bool) error {
+	defer close(sequence.c)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case buffer <- nil:
+	}
+
+	be := backoff.NewExponentialBackOff()
+	be.MaxInterval = jobInfo.MaxBackoffTime
+	be.MaxElapsedTime = jobInfo.MaxRetryTime
+	retryconf := backoff.WithContext(be, ctx)
+
+	rebuilt := false
+	operation := func() error {
+		oerr := processSequence(ctx, sequence, backend, usePipe)
+		if oerr != nil {
+			helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: target, Volume: sequence.volume.ObjectName, Err: oerr}.Warningf(ctx, "error trying to download file %s - %v", sequence.volume.ObjectName, oerr)
+			// Rebuild a volume that could not be downloaded from its parity group once before retrying
+			if !usePipe && !rebuilt && len(sequence.manifest.Parity) > 0 {
+				rebuilt = true
+				vol, rerr := reconstructVolume(ctx, backend, sequence.manifest, sequence.volume)
+				if rerr == nil {
+					sequence.c <- vol
+					return nil
+				}
+				helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: target, Volume: sequence.volume.ObjectName, Err: rerr}.Warningf(ctx, "Could not rebuild volume %s from its parity group - %v", sequence.volume.ObjectName, rerr)
+			}
+		}
+		return oerr
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Downloading volume %s.", sequence.volume.ObjectName)
+
+	if berr := backoff.RetryNotify(operation, retryconf, retryNotifier(ctx, jobInfo, sequence.volume, target)); berr != nil {
+		helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: target, Volume: sequence.volume.ObjectName, Err: berr}.Errorf(ctx, "Failed to download volume %s due to error: %v, aborting...", sequence.volume.ObjectName, berr)
+		return helpers.NewError(helpers.ErrorKindBackend, berr)
+	}
+	jobInfo.ReportProgress(helpers.ProgressEvent{
+		Type:         helpers.ProgressVolumeDownloaded,
+		ObjectName:   sequence.volume.ObjectName,
+		Destination:  target,
+		VolumeNumber: sequence.volume.VolumeNumber,
+		Bytes:        sequence.volume.Size,
+	})
+	return nil
+}
+
+// deleteDownloadedVolumes will delete the volumes downloaded for the backup sets provided that were never
+// restored. Only valid to be called once nothing else sends to or receives from their channels.
+func deleteDownloadedVolumes(sets []*restoreSet, orderedChannels [][]chan *helpers.VolumeInfo) {
+	drain := func(c chan *helpers.VolumeInfo) {
+		for {
+			select {
+			case vol, ok := <-c:
+				if !ok {
+					return
+				}
+				if vol != nil {
+					vol.DeleteVolume()
+				}
+			default:
+				return
+			}
+		}
+	}
+	for sidx, set := range sets {
+		drain(set.volumes)
+		for _, c := range orderedChannels[sidx] {
+			drain(c)
+		}
+	}
+}
+
+// verifyVolume will read the downloaded volume provided through its decryption, signature verification, and
+// decompression without using the data read, so a corrupt volume is found before its stream is received.
+func verifyVolume(ctx context.Context, j *helpers.JobInfo, vol *helpers.VolumeInfo) error {
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Verifying %s.", vol.ObjectName)
+	if err := vol.Extract(ctx, j, false); err != nil {
+		vol.Close()
+		return err
+	}
+	if _, err := io.Copy(ioutil.Discard, vol); err != nil {
+		vol.Close()
+		return err
+	}
+	return vol.Close()
+}
+
+// loadManifest will read the manifest describing the backup set requested in jobInfo from the local
+// cache, downloading it from the backend first if it is not found there.
+func loadManifest(ctx context.Context, backend backends.Backend, localCachePath string, jobInfo *helpers.JobInfo) (*helpers.JobInfo, error) {
+	var manifest *helpers.JobInfo
+	var err error
+	if jobInfo.DiscoverManifests {
+		manifest, err = discoverManifest(ctx, backend, localCachePath, jobInfo)
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error trying to discover the manifest - %v", err)
+			return nil, err
+		}
+	} else {
+		// Compute the Manifest File
+		tempManifest, terr := helpers.CreateManifestVolume(ctx, jobInfo)
+		if terr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error trying to create manifest volume - %v", terr)
+			return nil, terr
+		}
+		tempManifest.Close()
+		tempManifest.DeleteVolume()
+		safeManifestFile := fmt.Sprintf("%x", md5.Sum([]byte(tempManifest.ObjectName)))
+		safeManifestPath := filepath.Join(localCachePath, safeManifestFile)
+
+		// Check to see if we have the manifest file locally
+		manifest, err = readManifest(ctx, safeManifestPath, jobInfo)
+		if err != nil {
+			if os.IsNotExist(err) {
+				err = backend.PreDownload(ctx, []string{tempManifest.ObjectName})
+				if err != nil {
+					helpers.EnvironmentOf(ctx).Logger.Errorf("Error trying to pre download manifest volume %s - %v", tempManifest.ObjectName, err)
+					return nil, err
+				}
+				// Try and download the manifest file from the backend
+				downloadTo(ctx, backend, tempManifest.ObjectName, safeManifestPath)
+				manifest, err = readManifest(ctx, safeManifestPath, jobInfo)
+			}
+			if err != nil {
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Error trying to retrieve manifest volume - %v", err)
+				return nil, err
+			}
+		}
+	}
+
+	manifest.ManifestPrefix = jobInfo.ManifestPrefix
+	manifest.SignKey = jobInfo.SignKey
+	manifest.EncryptKey = jobInfo.EncryptKey
+	manifest.Progress = jobInfo.Progress
+
+	return manifest, nil
+}
+
+// discoverManifest will sync every manifest found at the destination to the local cache and return the
+// one describing the backup set requested in jobInfo, whatever manifest prefix and separator it was stored with.
+func discoverManifest(ctx context.Context, backend backends.Backend, localCachePath string, jobInfo *helpers.JobInfo) (*helpers.JobInfo, error) {
+	safeManifests, _, err := syncCache(ctx, jobInfo, localCachePath, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := readAndSortManifests(ctx, localCachePath, safeManifests, jobInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, manifest := range manifests {
+		if manifest.VolumeName == jobInfo.VolumeName && manifest.BaseSnapshot.Name == jobInfo.BaseSnapshot.Name &&
+			manifest.IncrementalSnapshot.Name == jobInfo.IncrementalSnapshot.Name {
+			return manifest, nil
+		}
+	}
+	return nil, fmt.Errorf("no manifest found for %s@%s out of the %d discovered", jobInfo.VolumeName, jobInfo.BaseSnapshot.Name, len(manifests))
+}
+
 func processSequence(ctx context.Context, sequence downloadSequence, backend backends.Backend, usePipe bool) error {
 	r, rerr := backend.Download(ctx, sequence.volume.ObjectName)
 	if rerr != nil {
-		helpers.AppLogger.Infof("Could not get %s due to error %v.", sequence.volume.ObjectName, rerr)
+		helpers.LogFields{Volume: sequence.volume.ObjectName, Err: rerr}.Infof(ctx, "Could not get %s due to error %v.", sequence.volume.ObjectName, rerr)
 		return rerr
 	}
 	defer r.Close()
-	vol, err := helpers.CreateSimpleVolume(ctx, usePipe)
+	vol, err := helpers.CreateDigestVolume(ctx, usePipe, sequence.volume.ChecksumAlgorithm())
 	if err != nil {
-		helpers.AppLogger.Noticef("Could not create temporary file to download %s due to error - %v.", sequence.volume.ObjectName, err)
+		helpers.LogFields{Volume: sequence.volume.ObjectName, Err: err}.Infof(ctx, "Could not create temporary file to download %s due to error - %v.", sequence.volume.ObjectName, err)
 		return err
 	}
 
 	vol.ObjectName = sequence.volume.ObjectName
+	vol.IsLayout = sequence.volume.IsLayout
 	if usePipe {
+		// The volume is read as it is downloaded, don't let it reach zfs recv as a whole unless it is intact
+		vol.ExpectDigests(sequence.volume)
 		sequence.c <- vol
 	}
 
-	_, err = io.Copy(vol, r)
+	_, err = helpers.Copy(vol, helpers.EnvironmentOf(ctx).Transfers.Reader(r))
 	if err != nil {
-		helpers.AppLogger.Noticef("Could not download file %s to the local cache dir due to error - %v.", sequence.volume.ObjectName, err)
+		helpers.LogFields{Volume: sequence.volume.ObjectName, Err: err}.Infof(ctx, "Could not download file %s to the local cache dir due to error - %v.", sequence.volume.ObjectName, err)
 		vol.Close()
 		vol.DeleteVolume()
 		if usePipe {
@@ -412,20 +790,21 @@ func processSequence(ctx context.Context, sequence downloadSequence, backend bac
 		return err
 	}
 	if cerr := vol.Close(); cerr != nil {
-		helpers.AppLogger.Noticef("Could not close temporary file to download %s due to error - %v.", sequence.volume.ObjectName, cerr)
+		if usePipe && helpers.KindOf(cerr) == helpers.ErrorKindVerification {
+			helpers.LogFields{Volume: sequence.volume.ObjectName, Bytes: vol.Size, Err: cerr}.Errorf(ctx, "Corrupted volume %s - %v", sequence.volume.ObjectName, cerr)
+			return backoff.Permanent(cerr)
+		}
+		helpers.LogFields{Volume: sequence.volume.ObjectName, Err: cerr}.Infof(ctx, "Could not close temporary file to download %s due to error - %v.", sequence.volume.ObjectName, cerr)
 		return cerr
 	}
 
-	// Verify the SHA256 Hash, if it doesn't match, ditch it!
-	if vol.SHA256Sum != sequence.volume.SHA256Sum {
-		helpers.AppLogger.Infof("Hash mismatch for %s, got %s but expected %s. Retrying.", sequence.volume.ObjectName, vol.SHA256Sum, sequence.volume.SHA256Sum)
-		if usePipe {
-			return backoff.Permanent(fmt.Errorf("cannot retry when using no file buffer, aborting"))
-		}
+	// Verify the digests, if they don't match, ditch it!
+	if verr := vol.VerifyDigests(sequence.volume); verr != nil {
+		helpers.LogFields{Volume: sequence.volume.ObjectName, Bytes: vol.Size, Err: verr}.Infof(ctx, "%v. Retrying.", verr)
 		vol.DeleteVolume()
-		return fmt.Errorf("SHA256 hash mismatch for %s, got %s but expected %s", sequence.volume.ObjectName, vol.SHA256Sum, sequence.volume.SHA256Sum)
+		return verr
 	}
-	helpers.AppLogger.Debugf("Downloaded %s.", sequence.volume.ObjectName)
+	helpers.LogFields{Volume: sequence.volume.ObjectName, Bytes: sequence.volume.Size}.Debugf(ctx, "Downloaded %s.", sequence.volume.ObjectName)
 
 	if !usePipe {
 		sequence.c <- vol
@@ -443,23 +822,23 @@ func receiveStream(ctx context.Context, cmd *exec.Cmd, j *helpers.JobInfo, c <-c
 	group, ctx = errgroup.WithContext(ctx)
 
 	// Start the zfs receive command
-	helpers.AppLogger.Infof("Starting zfs receive command: %s", strings.Join(cmd.Args, " "))
+	helpers.EnvironmentOf(ctx).Logger.Infof("Starting zfs receive command: %s", strings.Join(cmd.Args, " "))
 	err := cmd.Start()
 	if err != nil {
-		helpers.AppLogger.Errorf("Error starting zfs command - %v", err)
-		return err
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error starting zfs command - %v", err)
+		return helpers.NewError(helpers.ErrorKindZFS, err)
 	}
 
 	defer func() {
 		if cmd.ProcessState == nil || !cmd.ProcessState.Exited() {
 			err = cmd.Process.Kill()
 			if err != nil {
-				helpers.AppLogger.Errorf("Could not kill zfs send command due to error - %v", err)
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Could not kill zfs send command due to error - %v", err)
 				return
 			}
 			err = cmd.Process.Release()
 			if err != nil {
-				helpers.AppLogger.Errorf("Could not release resources from zfs send command due to error - %v", err)
+				helpers.EnvironmentOf(ctx).Logger.Errorf("Could not release resources from zfs send command due to error - %v", err)
 				return
 			}
 		}
@@ -468,69 +847,220 @@ func receiveStream(ctx context.Context, cmd *exec.Cmd, j *helpers.JobInfo, c <-c
 	// Extract ZFS stream from files and send it to the zfs command
 	group.Go(func() error {
 		defer once.Do(func() { cout.Close() })
+		return extractVolumes(ctx, cout, j, c, buffer)
+	})
+
+	group.Go(func() error {
+		defer once.Do(func() { cout.Close() })
+		return helpers.NewError(helpers.ErrorKindZFS, cmd.Wait())
+	})
+
+	// Wait for the command to finish
+	err = group.Wait()
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error waiting for zfs command to finish - %v", err)
+		return err
+	}
+	helpers.EnvironmentOf(ctx).Logger.Infof("zfs receive completed without error")
+
+	return nil
+}
+
+// writeStreamFile will write the zfs send stream extracted from the volumes to a new local file.
+func writeStreamFile(ctx context.Context, path string, j *helpers.JobInfo, c <-chan *helpers.VolumeInfo, buffer <-chan interface{}) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not create %s to write the zfs send stream to due to error - %v", path, err)
+		return err
+	}
+
+	if err = extractVolumes(ctx, f, j, c, buffer); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not write the zfs send stream to %s due to error - %v", path, err)
+		return err
+	}
+	if err = f.Close(); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not write the zfs send stream to %s due to error - %v", path, err)
+		return err
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Wrote the zfs send stream of %s@%s to %s", j.VolumeName, j.BaseSnapshot.Name, path)
+	return nil
+}
+
+// extractChunkSize is the size of the chunks volumes are extracted in ahead of the writer, up to
+// extractAheadChunks of them are buffered in memory.
+const (
+	extractChunkSize   = 1024 * 1024
+	extractAheadChunks = 16
+)
+
+// chunkBuffers holds the chunks volumes are extracted in, handed back once written.
+var chunkBuffers = helpers.NewBufferPool(extractChunkSize)
+
+// extractedChunk is a chunk of the zfs send stream extracted from a volume, or of the layout of the stream
+// extracted from a layout volume, restored is set once the last chunk of the volume was extracted.
+type extractedChunk struct {
+	data     []byte
+	layout   bool
+	restored *helpers.VolumeInfo
+}
+
+// extractVolumes will write the zfs send stream extracted from the volumes received, in order, to w. The
+// volumes are decrypted and decompressed ahead of w, so the next volume is already being extracted while
+// the current one is still written. The stream of a backup set stored as chunks is put back together from
+// its layout volumes.
+func extractVolumes(ctx context.Context, w io.Writer, j *helpers.JobInfo, c <-chan *helpers.VolumeInfo, buffer <-chan interface{}) error {
+	var layout *layoutWriter
+	if j.Chunking == helpers.ChunkingCDC {
+		layout = &layoutWriter{w: w}
+		w = layout
+	}
+	group, gctx := errgroup.WithContext(ctx)
+	chunks := make(chan extractedChunk, extractAheadChunks)
+
+	group.Go(func() error {
+		defer close(chunks)
 		for {
 			select {
 			case vol, ok := <-c:
 				if !ok {
 					return nil
 				}
-				helpers.AppLogger.Debugf("Processing %s.", vol.ObjectName)
-				eerr := vol.Extract(ctx, j, false)
-				if eerr != nil {
-					helpers.AppLogger.Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, eerr)
+				if err := extractVolume(gctx, j, vol, chunks); err != nil {
 					return err
 				}
-				_, eerr = io.Copy(cout, vol)
-				if eerr != nil {
-					helpers.AppLogger.Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, eerr)
-					return eerr
-				}
-				vol.Close()
-				vol.DeleteVolume()
-				helpers.AppLogger.Debugf("Processed %s.", vol.ObjectName)
-				vol = nil
 				<-buffer
-			case <-ctx.Done():
-				return ctx.Err()
+			case <-gctx.Done():
+				return gctx.Err()
 			}
 		}
 	})
 
 	group.Go(func() error {
-		defer once.Do(func() { cout.Close() })
-		return cmd.Wait()
+		for {
+			select {
+			case chunk, ok := <-chunks:
+				if !ok {
+					return nil
+				}
+				if chunk.layout {
+					layout.writeLayout(chunk.data)
+					chunkBuffers.Put(chunk.data)
+					continue
+				}
+				if chunk.restored != nil {
+					if chunk.restored.IsLayout {
+						if err := layout.endLayout(); err != nil {
+							helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to write the zfs send stream from the layout volume %s - %v", chunk.restored.ObjectName, err)
+							return helpers.NewError(helpers.ErrorKindVerification, err)
+						}
+					}
+					helpers.EnvironmentOf(ctx).Logger.Debugf("Processed %s.", chunk.restored.ObjectName)
+					j.ReportProgress(helpers.ProgressEvent{
+						Type:       helpers.ProgressVolumeRestored,
+						ObjectName: chunk.restored.ObjectName,
+					})
+					continue
+				}
+				_, err := w.Write(chunk.data)
+				chunkBuffers.Put(chunk.data)
+				if err != nil {
+					helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to write the zfs send stream - %v", err)
+					return err
+				}
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+		}
 	})
 
-	// Wait for the command to finish
-	err = group.Wait()
-	if err != nil {
-		helpers.AppLogger.Errorf("Error waiting for zfs command to finish - %v", err)
+	if err := group.Wait(); err != nil {
 		return err
 	}
-	helpers.AppLogger.Infof("zfs receive completed without error")
+	if layout != nil {
+		if err := layout.Close(); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to write the zfs send stream - %v", err)
+			return helpers.NewError(helpers.ErrorKindVerification, err)
+		}
+	}
+	return nil
+}
+
+// extractVolume will decrypt and decompress the volume provided into chunks, deleting it once done.
+func extractVolume(ctx context.Context, j *helpers.JobInfo, vol *helpers.VolumeInfo, chunks chan<- extractedChunk) error {
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Processing %s.", vol.ObjectName)
+	defer vol.DeleteVolume()
+	defer vol.Close()
+
+	if err := vol.Extract(ctx, j, false); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, err)
+		return helpers.NewError(helpers.ErrorKindVerification, err)
+	}
+
+	for {
+		data := chunkBuffers.Get()
+		n, err := io.ReadFull(vol, data)
+		if n > 0 {
+			select {
+			case chunks <- extractedChunk{data: data[:n], layout: vol.IsLayout}:
+			case <-ctx.Done():
+				chunkBuffers.Put(data)
+				return ctx.Err()
+			}
+		} else {
+			chunkBuffers.Put(data)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from volume %s - %v", vol.ObjectName, err)
+			return helpers.NewError(helpers.ErrorKindVerification, err)
+		}
+	}
 
+	select {
+	case chunks <- extractedChunk{restored: vol}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	return nil
 }
 
+// streamFilePath returns the file to write the stream of the idx-th of total backup sets to. The number
+// of the backup set is added before the extension of path when restoring more than one.
+func streamFilePath(path string, idx, total int) string {
+	if path == "" || total <= 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), idx+1, ext)
+}
+
 func downloadTo(ctx context.Context, backend backends.Backend, objectName, toPath string) error {
 	r, rerr := backend.Download(ctx, objectName)
 	if rerr == nil {
 		defer r.Close()
 		out, oerr := os.Create(toPath)
 		if oerr != nil {
-			helpers.AppLogger.Errorf("Could not create file in the local cache dir due to error - %v.", oerr)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not create file in the local cache dir due to error - %v.", oerr)
 			return oerr
 		}
 		defer out.Close()
 
-		_, err := io.Copy(out, r)
+		_, err := helpers.Copy(out, helpers.EnvironmentOf(ctx).Transfers.Reader(r))
 		if err != nil {
-			helpers.AppLogger.Errorf("Could not download file %s to the local cache dir due to error - %v.", objectName, err)
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not download file %s to the local cache dir due to error - %v.", objectName, err)
 			return err
 		}
-		helpers.AppLogger.Debugf("Downloaded %s to local cache.", objectName)
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Downloaded %s to local cache.", objectName)
 	} else {
-		helpers.AppLogger.Errorf("Could not download file %s to the local cache dir due to error - %v.", objectName, rerr)
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not download file %s to the local cache dir due to error - %v.", objectName, rerr)
 		return rerr
 	}
 	return nil
@@ -0,0 +1,405 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestStreamFilePath(t *testing.T) {
+	testCases := []struct {
+		path   string
+		idx    int
+		total  int
+		expect string
+	}{
+		{"", 0, 2, ""},
+		{"/mnt/usb/stream.zfs", 0, 1, "/mnt/usb/stream.zfs"},
+		{"/mnt/usb/stream.zfs", 0, 3, "/mnt/usb/stream.1.zfs"},
+		{"/mnt/usb/stream.zfs", 2, 3, "/mnt/usb/stream.3.zfs"},
+		{"/mnt/usb/stream", 1, 2, "/mnt/usb/stream.2"},
+	}
+
+	for idx, c := range testCases {
+		if got := streamFilePath(c.path, c.idx, c.total); got != c.expect {
+			t.Errorf("%d: expected %s, got %s", idx, c.expect, got)
+		}
+	}
+}
+
+func TestSnapshotAt(t *testing.T) {
+	snap := func(name string, day int) *helpers.JobInfo {
+		return &helpers.JobInfo{BaseSnapshot: helpers.SnapshotInfo{Name: name, CreationTime: time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC)}}
+	}
+	sets := []*helpers.JobInfo{snap("snap1", 1), snap("snap2", 5), snap("snap3", 10)}
+
+	testCases := []struct {
+		at     time.Time
+		expect string
+		ok     bool
+	}{
+		{time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC), "", false},
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "snap1", true},
+		{time.Date(2024, 3, 4, 23, 59, 59, 0, time.UTC), "snap1", true},
+		{time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), "snap2", true},
+		{time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), "snap3", true},
+	}
+
+	for idx, c := range testCases {
+		got, ok := snapshotAt(sets, c.at)
+		if got.Name != c.expect || ok != c.ok {
+			t.Errorf("%d: expected %s (%v), got %s (%v)", idx, c.expect, c.ok, got.Name, ok)
+		}
+	}
+}
+
+func TestLatestRestorePoint(t *testing.T) {
+	snap := func(name string, day int) helpers.SnapshotInfo {
+		return helpers.SnapshotInfo{Name: name, CreationTime: time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC)}
+	}
+	set := func(base, incremental helpers.SnapshotInfo) *helpers.JobInfo {
+		return &helpers.JobInfo{VolumeName: "tank/data", BaseSnapshot: base, IncrementalSnapshot: incremental}
+	}
+	full1 := set(snap("snap1", 1), helpers.SnapshotInfo{})
+	incr2 := set(snap("snap2", 2), snap("snap1", 1))
+	full3 := set(snap("snap3", 3), helpers.SnapshotInfo{})
+	incr4 := set(snap("snap4", 4), snap("snap3", 3))
+	orphan5 := set(snap("snap5", 5), snap("snap0", 0))
+
+	testCases := []struct {
+		sets     []*helpers.JobInfo
+		fullOnly bool
+		expect   string
+		ok       bool
+	}{
+		{[]*helpers.JobInfo{full1, incr2, full3, incr4}, false, "snap4", true},
+		{[]*helpers.JobInfo{full1, incr2, full3, incr4}, true, "snap3", true},
+		{[]*helpers.JobInfo{full1, incr2, orphan5}, false, "snap2", true},
+		{[]*helpers.JobInfo{full1, incr2, orphan5}, true, "snap1", true},
+		{[]*helpers.JobInfo{incr2, orphan5}, false, "", false},
+		{[]*helpers.JobInfo{incr2}, true, "", false},
+	}
+
+	for idx, c := range testCases {
+		got, ok := latestRestorePoint(c.sets, c.fullOnly)
+		if got.Name != c.expect || ok != c.ok {
+			t.Errorf("%d: expected %s (%v), got %s (%v)", idx, c.expect, c.ok, got.Name, ok)
+		}
+	}
+
+	if !IsLatestSelector(LatestSelector) || !IsLatestSelector(LatestFullSelector) || IsLatestSelector("snap1") {
+		t.Errorf("unexpected result from IsLatestSelector")
+	}
+	if !hasSnapshot([]*helpers.JobInfo{full1, incr2}, "snap2") || hasSnapshot([]*helpers.JobInfo{full1, incr2}, LatestSelector) {
+		t.Errorf("unexpected result from hasSnapshot")
+	}
+}
+
+func TestMissingSets(t *testing.T) {
+	snap := func(name string, day int) helpers.SnapshotInfo {
+		return helpers.SnapshotInfo{Name: name, CreationTime: time.Date(2024, 3, day, 0, 0, 0, 0, time.UTC)}
+	}
+	full := &helpers.JobInfo{BaseSnapshot: snap("snap1", 1)}
+	incr2 := &helpers.JobInfo{BaseSnapshot: snap("snap2", 2), IncrementalSnapshot: snap("snap1", 1), ParentSnap: full}
+	incr3 := &helpers.JobInfo{BaseSnapshot: snap("snap3", 3), IncrementalSnapshot: snap("snap2", 2), ParentSnap: incr2}
+	orphan := &helpers.JobInfo{BaseSnapshot: snap("snap4", 4), IncrementalSnapshot: snap("snap0", 0)}
+	adopted := &helpers.JobInfo{BaseSnapshot: snap("snap1", 1), External: ToolZrepl}
+	adoptedIncr := &helpers.JobInfo{BaseSnapshot: snap("snap2", 2), IncrementalSnapshot: snap("snap1", 1), ParentSnap: adopted}
+
+	testCases := []struct {
+		set       *helpers.JobInfo
+		snapshots []helpers.SnapshotInfo
+		expect    []*helpers.JobInfo
+		found     string
+		errTest   errTestFunc
+	}{
+		{incr3, nil, []*helpers.JobInfo{full, incr2, incr3}, "", nilErrTest},
+		{incr3, []helpers.SnapshotInfo{snap("snap1", 1)}, []*helpers.JobInfo{incr2, incr3}, "snap1", nilErrTest},
+		{incr3, []helpers.SnapshotInfo{snap("snap2", 2), snap("snap1", 1)}, []*helpers.JobInfo{incr3}, "snap2", nilErrTest},
+		{incr3, []helpers.SnapshotInfo{snap("snap3", 3)}, []*helpers.JobInfo{}, "snap3", nilErrTest},
+		{incr3, []helpers.SnapshotInfo{snap("snap2", 20)}, nil, "", nonNilErrTest},
+		{orphan, nil, nil, "", nonNilErrTest},
+		{adoptedIncr, nil, nil, "", nonNilErrTest},
+		{adoptedIncr, []helpers.SnapshotInfo{snap("snap1", 1)}, []*helpers.JobInfo{adoptedIncr}, "snap1", nilErrTest},
+	}
+
+	for idx, c := range testCases {
+		got, found, err := missingSets(c.set, c.snapshots)
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if len(got) != len(c.expect) {
+			t.Errorf("%d: expected %d backup sets, got %d", idx, len(c.expect), len(got))
+			continue
+		}
+		for i := range got {
+			if got[i] != c.expect[i] {
+				t.Errorf("%d: expected %s at %d, got %s", idx, c.expect[i].BaseSnapshot.Name, i, got[i].BaseSnapshot.Name)
+			}
+		}
+		var name string
+		if found != nil {
+			name = found.Name
+		}
+		if name != c.found {
+			t.Errorf("%d: expected to start from %s, got %s", idx, c.found, name)
+		}
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errTest }
+
+func TestExtractVolumes(t *testing.T) {
+	ctx := context.Background()
+	j := &helpers.JobInfo{Compressor: ""}
+
+	newVolumes := func(sizes ...int) ([]byte, chan *helpers.VolumeInfo, chan interface{}) {
+		var payload []byte
+		c := make(chan *helpers.VolumeInfo, len(sizes))
+		buffer := make(chan interface{}, len(sizes))
+		for _, size := range sizes {
+			data := make([]byte, size)
+			if _, err := rand.Read(data); err != nil {
+				t.Fatalf("could not generate data: %v", err)
+			}
+			vol, err := helpers.CreateSimpleVolume(ctx, false)
+			if err != nil {
+				t.Fatalf("could not create volume: %v", err)
+			}
+			if _, err = io.Copy(vol, bytes.NewReader(data)); err != nil {
+				t.Fatalf("could not write volume: %v", err)
+			}
+			if err = vol.Close(); err != nil {
+				t.Fatalf("could not close volume: %v", err)
+			}
+			payload = append(payload, data...)
+			c <- vol
+			buffer <- nil
+		}
+		close(c)
+		return payload, c, buffer
+	}
+
+	testCases := [][]int{
+		{},
+		{10},
+		{extractChunkSize, extractChunkSize + 1, 3*extractChunkSize - 1},
+		{extractChunkSize * (extractAheadChunks + 4), 0, 1024},
+	}
+
+	for idx, sizes := range testCases {
+		payload, c, buffer := newVolumes(sizes...)
+		var out bytes.Buffer
+		if err := extractVolumes(ctx, &out, j, c, buffer); err != nil {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if !bytes.Equal(out.Bytes(), payload) {
+			t.Errorf("%d: expected %d bytes extracted, got %d different bytes", idx, len(payload), out.Len())
+		}
+		if len(buffer) != 0 {
+			t.Errorf("%d: expected every volume to be released from the buffer, %d were not", idx, len(buffer))
+		}
+	}
+
+	_, c, buffer := newVolumes(3*extractChunkSize, extractChunkSize)
+	if err := extractVolumes(ctx, failingWriter{}, j, c, buffer); err != errTest {
+		t.Errorf("expected %v writing to a failing writer, got %v", errTest, err)
+	}
+}
+
+func TestVerifyVolume(t *testing.T) {
+	ctx := context.Background()
+	j := &helpers.JobInfo{Compressor: helpers.InternalCompressor}
+
+	payload := make([]byte, 3*extractChunkSize)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatalf("could not generate data: %v", err)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(payload); err != nil {
+		t.Fatalf("could not compress data: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("could not compress data: %v", err)
+	}
+	truncated := compressed.Bytes()[:compressed.Len()/2]
+
+	testCases := []struct {
+		data    []byte
+		errTest errTestFunc
+	}{
+		{compressed.Bytes(), nilErrTest},
+		{truncated, nonNilErrTest},
+		{payload, nonNilErrTest},
+	}
+
+	for idx, c := range testCases {
+		vol, err := helpers.CreateSimpleVolume(ctx, false)
+		if err != nil {
+			t.Fatalf("could not create volume: %v", err)
+		}
+		if _, err = io.Copy(vol, bytes.NewReader(c.data)); err != nil {
+			t.Fatalf("could not write volume: %v", err)
+		}
+		if err = vol.Close(); err != nil {
+			t.Fatalf("could not close volume: %v", err)
+		}
+
+		if err = verifyVolume(ctx, j, vol); !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+		} else if err == nil {
+			// The volume must still be readable once verified
+			buffer := make(chan interface{}, 1)
+			buffer <- nil
+			volumes := make(chan *helpers.VolumeInfo, 1)
+			volumes <- vol
+			close(volumes)
+			var out bytes.Buffer
+			if err = extractVolumes(ctx, &out, j, volumes, buffer); err != nil || !bytes.Equal(out.Bytes(), payload) {
+				t.Errorf("%d: expected the verified volume to extract, got %d bytes (%v)", idx, out.Len(), err)
+			}
+			continue
+		}
+		vol.DeleteVolume()
+	}
+}
+
+// downloadBackend serves the same data for every object downloaded from it
+type downloadBackend struct {
+	mockBackend
+	data []byte
+}
+
+func (d *downloadBackend) Download(ctx context.Context, filename string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(d.data)), nil
+}
+
+func TestProcessSequenceDigest(t *testing.T) {
+	ctx := context.Background()
+
+	payload := make([]byte, 1024*1024)
+	if _, err := rand.Read(payload); err != nil {
+		t.Fatalf("could not generate data: %v", err)
+	}
+	tampered := append([]byte{}, payload...)
+	tampered[len(tampered)/2] ^= 0xff
+
+	testCases := []struct {
+		algorithm string
+		pipe      bool
+		data      []byte
+		errTest   errTestFunc
+	}{
+		{"", false, payload, nilErrTest},
+		{helpers.DigestSHA256, false, payload, nilErrTest},
+		{helpers.DigestBLAKE3, false, payload, nilErrTest},
+		{helpers.DigestXXH3, false, payload, nilErrTest},
+		{helpers.DigestSHA256, false, tampered, nonNilErrTest},
+		{helpers.DigestBLAKE3, false, tampered, nonNilErrTest},
+		{helpers.DigestXXH3, false, tampered, nonNilErrTest},
+		{helpers.DigestSHA256, true, payload, nilErrTest},
+		{helpers.DigestXXH3, true, payload, nilErrTest},
+		{helpers.DigestSHA256, true, tampered, nonNilErrTest},
+		{helpers.DigestXXH3, true, tampered, nonNilErrTest},
+	}
+
+	for idx, c := range testCases {
+		// The volume as recorded in its manifest
+		recorded, err := helpers.CreateDigestVolume(ctx, false, c.algorithm)
+		if err != nil {
+			t.Fatalf("%d: could not create volume: %v", idx, err)
+		}
+		if _, err = io.Copy(recorded, bytes.NewReader(payload)); err != nil {
+			t.Fatalf("%d: could not write volume: %v", idx, err)
+		}
+		if err = recorded.Close(); err != nil {
+			t.Fatalf("%d: could not close volume: %v", idx, err)
+		}
+		recorded.DeleteVolume()
+		recorded.ObjectName = "pool/dataset|snap.zstream.vol1"
+		if c.algorithm == "" {
+			// A volume recorded before the digest algorithm could be chosen
+			recorded.DigestAlgorithm = ""
+		}
+		if recorded.SHA256Sum == "" {
+			t.Errorf("%d: expected the SHA256 digest to be recorded with the %s one", idx, c.algorithm)
+		}
+
+		out := make(chan *helpers.VolumeInfo, 1)
+		read := make(chan error, 1)
+		if c.pipe {
+			// The reader of a piped volume must fail rather than reach the end of a corrupted one
+			go func() {
+				vol := <-out
+				data, rerr := ioutil.ReadAll(vol)
+				if rerr == nil && !bytes.Equal(data, payload) {
+					rerr = errTest
+				}
+				vol.Close()
+				read <- rerr
+			}()
+		}
+
+		err = processSequence(ctx, downloadSequence{recorded, out, nil}, &downloadBackend{data: c.data}, c.pipe)
+		if perr, ok := err.(*backoff.PermanentError); ok {
+			err = perr.Err
+		}
+		if !c.errTest(err) {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if c.pipe {
+			if rerr := <-read; !c.errTest(rerr) || (rerr != nil && helpers.KindOf(rerr) != helpers.ErrorKindVerification) {
+				t.Errorf("%d: unexpected error reading the volume %v", idx, rerr)
+			}
+		}
+		if err != nil {
+			if helpers.KindOf(err) != helpers.ErrorKindVerification {
+				t.Errorf("%d: expected a verification error, got %v", idx, err)
+			}
+			continue
+		}
+		if c.pipe {
+			continue
+		}
+		vol := <-out
+		if vol.Checksum() == "" || vol.Checksum() != recorded.Checksum() || vol.ChecksumAlgorithm() != recorded.ChecksumAlgorithm() {
+			t.Errorf("%d: expected the %s digest %s, got the %s digest %s", idx, recorded.ChecksumAlgorithm(), recorded.Checksum(), vol.ChecksumAlgorithm(), vol.Checksum())
+		}
+		vol.DeleteVolume()
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// VolumeRestorePoint is a snapshot of a volume that can be restored from the backup sets found at a target.
+type VolumeRestorePoint struct {
+	Volume string
+	RestorePoint
+	Full  bool // Whether the snapshot is restored from a full backup set alone
+	Depth int  // How many backup sets are received to restore the snapshot from scratch
+}
+
+// RestorePoints will sync the manifests found in the target destination to the local cache and return every
+// snapshot of the volume provided, or of every volume if empty, that can be restored from them along with the
+// backup sets downloaded to restore it from scratch. The volume can end with a '*' to match as only a prefix.
+func RestorePoints(ctx context.Context, jobInfo *helpers.JobInfo, volume string) ([]VolumeRestorePoint, error) {
+	sets, _, err := listBackupSets(ctx, jobInfo, volume, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	linkManifests(ctx, sets)
+	return restorePoints(sets), nil
+}
+
+// restorePoints will return the restore points of the linked backup sets provided, sorted by volume and snapshot
+// creation time. Like an auto restore, a snapshot is restored from its full backup set when it has one, and snapshots
+// whose chain is broken or that were adopted from another tool are left out.
+func restorePoints(sets []*helpers.JobInfo) []VolumeRestorePoint {
+	var points []VolumeRestorePoint
+	bySnapshot := make(map[string]int)
+	for _, set := range sets {
+		key := snapshotKey(set.VolumeName, set.BaseSnapshot)
+		idx, seen := bySnapshot[key]
+		if seen && (points[idx].Full || set.IncrementalSnapshot.Name != "") {
+			// Prefer the full backup set of a snapshot, e.g. a consolidated one
+			continue
+		}
+
+		chain, _, err := missingSets(set, nil)
+		if err != nil {
+			continue
+		}
+		point := VolumeRestorePoint{
+			Volume:       set.VolumeName,
+			RestorePoint: newRestorePoint(chain),
+			Full:         set.IncrementalSnapshot.Name == "",
+			Depth:        len(chain),
+		}
+
+		if seen {
+			points[idx] = point
+		} else {
+			bySnapshot[key] = len(points)
+			points = append(points, point)
+		}
+	}
+	return points
+}
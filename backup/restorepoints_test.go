@@ -0,0 +1,92 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestRestorePoints(t *testing.T) {
+	snap := func(name string, day int) helpers.SnapshotInfo {
+		return helpers.SnapshotInfo{Name: name, CreationTime: time.Date(2017, 1, day, 0, 0, 0, 0, time.UTC)}
+	}
+	backup := func(volume string, base, incremental helpers.SnapshotInfo, size uint64) *helpers.JobInfo {
+		return &helpers.JobInfo{VolumeName: volume, BaseSnapshot: base, IncrementalSnapshot: incremental, Volumes: []*helpers.VolumeInfo{{Size: size}}}
+	}
+	snap1, snap2, snap3 := snap("snap1", 1), snap("snap2", 2), snap("snap3", 3)
+
+	testCases := []struct {
+		sets     func() []*helpers.JobInfo
+		expected []string
+	}{
+		{
+			func() []*helpers.JobInfo {
+				return []*helpers.JobInfo{backup("tank/a", snap1, helpers.SnapshotInfo{}, 100), backup("tank/a", snap2, snap1, 10), backup("tank/a", snap3, snap2, 1)}
+			},
+			[]string{"tank/a@snap1 full 1 100", "tank/a@snap2 incremental 2 110", "tank/a@snap3 incremental 3 111"},
+		},
+		// A consolidated full backup set is preferred over the incremental one of the same snapshot
+		{
+			func() []*helpers.JobInfo {
+				return []*helpers.JobInfo{backup("tank/a", snap1, helpers.SnapshotInfo{}, 100), backup("tank/a", snap2, snap1, 10), backup("tank/a", snap2, helpers.SnapshotInfo{}, 105), backup("tank/a", snap3, snap2, 1)}
+			},
+			[]string{"tank/a@snap1 full 1 100", "tank/a@snap2 full 1 105", "tank/a@snap3 incremental 2 106"},
+		},
+		// Broken chains and adopted backup sets can't be restored from scratch
+		{
+			func() []*helpers.JobInfo {
+				adopted := backup("tank/b", snap1, helpers.SnapshotInfo{}, 100)
+				adopted.External = ToolZrepl
+				return []*helpers.JobInfo{backup("tank/a", snap1, helpers.SnapshotInfo{}, 100), backup("tank/a", snap3, snap2, 1), adopted, backup("tank/b", snap2, snap1, 10)}
+			},
+			[]string{"tank/a@snap1 full 1 100"},
+		},
+		{func() []*helpers.JobInfo { return nil }, nil},
+	}
+
+	for idx, c := range testCases {
+		sets := c.sets()
+		linkManifests(context.Background(), sets)
+		points := restorePoints(sets)
+		if len(points) != len(c.expected) {
+			t.Errorf("%d: expected %d restore points, got %d", idx, len(c.expected), len(points))
+			continue
+		}
+		for pidx, point := range points {
+			kind := "incremental"
+			if point.Full {
+				kind = "full"
+			}
+			got := fmt.Sprintf("%s@%s %s %d %d", point.Volume, point.Snapshot.Name, kind, point.Depth, point.StoredBytes)
+			if got != c.expected[pidx] {
+				t.Errorf("%d: expected restore point %s, got %s", idx, c.expected[pidx], got)
+			}
+			if len(point.BackupSets) != point.Depth {
+				t.Errorf("%d: expected a chain of %d backup sets, got %d", idx, point.Depth, len(point.BackupSets))
+			}
+		}
+	}
+}
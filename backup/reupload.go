@@ -0,0 +1,311 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cenkalti/backoff"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Reupload will regenerate volumes of the backup set described by jobInfo from its snapshots, which must still
+// be found locally, and upload them to the first destination provided, replacing the missing or corrupt copies found
+// there. The volumes numbered in volumeNumbers are regenerated or, if none are provided, every volume missing from the
+// destination along with, if checkHashes is true, every volume not matching the hash recorded in the manifest.
+// The zfs send stream is created again with the options recorded in the manifest and split along the same
+// boundaries, and the manifest is updated and uploaded again if the content of a regenerated volume differs
+// (e.g. when encrypted) from the one it replaces.
+func Reupload(pctx context.Context, jobInfo *helpers.JobInfo, volumeNumbers []int64, checkHashes bool) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	target := jobInfo.Destinations[0]
+	uploadBuffer := make(chan bool, 1)
+	defer close(uploadBuffer)
+
+	backend, err := prepareBackend(ctx, jobInfo, target, uploadBuffer)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return err
+	}
+	defer backend.Close()
+
+	localCachePath, err := getCacheDir(ctx, target)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, err)
+		return err
+	}
+
+	manifest, err := loadManifest(ctx, backend, localCachePath, jobInfo)
+	if err != nil {
+		return err
+	}
+	jobInfo.Manifests = append(jobInfo.Manifests, helpers.ManifestObjectName(manifest))
+	sort.Sort(helpers.ByVolumeNumber(manifest.Volumes))
+	if manifest.Chunking != "" {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("The stream of the backup set is stored as chunks, its volumes cannot be regenerated.")
+		return fmt.Errorf("cannot reupload the volumes of a backup set stored as chunks")
+	}
+
+	var damaged []*helpers.VolumeInfo
+	if len(volumeNumbers) > 0 {
+		damaged, err = selectVolumes(manifest.Volumes, volumeNumbers)
+	} else {
+		damaged, err = findDamagedVolumes(ctx, backend, manifest, checkHashes)
+	}
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not determine the volumes to upload again - %v", err)
+		return err
+	}
+
+	if len(damaged) == 0 {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("No missing or corrupt volumes found in %s, nothing to do.", target)
+		return nil
+	}
+
+	inheritJobOptions(manifest, jobInfo)
+	manifest.MaxFileBuffer = 1
+	for _, vol := range damaged {
+		name := helpers.BackupVolumeObjectName(manifest, vol.VolumeNumber)
+		if manifest.ContentAddressed {
+			name = helpers.ContentVolumeObjectName(manifest, vol.SHA256Sum)
+		}
+		if name != vol.ObjectName {
+			return fmt.Errorf("volume %d would be named %s instead of %s, provide the same encryption and signing options the backup set was sent with", vol.VolumeNumber, name, vol.ObjectName)
+		}
+	}
+
+	for _, snapshot := range []helpers.SnapshotInfo{manifest.BaseSnapshot, manifest.IncrementalSnapshot} {
+		if snapshot.Name == "" {
+			continue
+		}
+		if ok, _ := validateSnapShotExists(ctx, &snapshot, manifest.VolumeName); !ok {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("The snapshot %s@%s is not found locally, the volumes cannot be regenerated.", manifest.VolumeName, snapshot.Name)
+			return fmt.Errorf("snapshot %s@%s not found", manifest.VolumeName, snapshot.Name)
+		}
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Infof("Regenerating %d volumes of the backup set.", len(damaged))
+	regenerated, err := regenerateVolumes(ctx, manifest, damaged)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, vol := range regenerated {
+			vol.DeleteVolume()
+		}
+	}()
+
+	be := backoff.NewExponentialBackOff()
+	be.MaxInterval = jobInfo.MaxBackoffTime
+	be.MaxElapsedTime = jobInfo.MaxRetryTime
+
+	changed := false
+	for idx, vol := range regenerated {
+		be.Reset()
+		retryconf := backoff.WithContext(be, ctx)
+		if err = backoff.RetryNotify(volUploadWrapper(ctx, backend, vol, target), retryconf, retryNotifier(ctx, jobInfo, vol, target)); err != nil {
+			helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: target, Volume: vol.ObjectName, Err: err}.Errorf(ctx, "Failed to upload volume %s to %s due to error - %v", vol.ObjectName, target, err)
+			return helpers.NewError(helpers.ErrorKindBackend, err)
+		}
+		helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: target, Volume: vol.ObjectName, Bytes: vol.Size}.Infof(ctx, "Uploaded volume %s to %s.", vol.ObjectName, target)
+
+		if vol.Checksum() != damaged[idx].Checksum() {
+			helpers.EnvironmentOf(ctx).Logger.Infof("The content of volume %s differs from the volume it replaces, the manifest will be updated.", vol.ObjectName)
+			changed = true
+		}
+		for vidx := range manifest.Volumes {
+			if manifest.Volumes[vidx].VolumeNumber == vol.VolumeNumber {
+				manifest.Volumes[vidx] = vol
+			}
+		}
+	}
+
+	if changed {
+		manifestVol, merr := saveManifest(ctx, manifest, true)
+		if merr != nil {
+			return merr
+		}
+		defer manifestVol.DeleteVolume()
+
+		if err = uploadManifest(ctx, manifest, manifestVol, []string{target}); err != nil {
+			return err
+		}
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Uploaded %d volumes again to %s.", len(regenerated), target)
+	return nil
+}
+
+// selectVolumes will return the volumes numbered in volumeNumbers, sorted by volume number.
+func selectVolumes(volumes []*helpers.VolumeInfo, volumeNumbers []int64) ([]*helpers.VolumeInfo, error) {
+	selected := make([]*helpers.VolumeInfo, 0, len(volumeNumbers))
+	for _, vol := range volumes {
+		for _, number := range volumeNumbers {
+			if vol.VolumeNumber == number {
+				selected = append(selected, vol)
+				break
+			}
+		}
+	}
+
+	if len(selected) != len(volumeNumbers) {
+		return nil, fmt.Errorf("the backup set only has volumes 1 to %d", len(volumes))
+	}
+	return selected, nil
+}
+
+// findDamagedVolumes will return the volumes of the manifest missing from the backend and, if checkHashes
+// is true, those not matching the hash recorded in the manifest once downloaded.
+func findDamagedVolumes(ctx context.Context, backend backends.Backend, manifest *helpers.JobInfo, checkHashes bool) ([]*helpers.VolumeInfo, error) {
+	names := make([]string, len(manifest.Volumes))
+	for idx, vol := range manifest.Volumes {
+		names[idx] = vol.ObjectName
+	}
+
+	objects, err := backend.List(ctx, commonPrefix(names))
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		found[object] = true
+	}
+
+	var damaged []*helpers.VolumeInfo
+	for _, vol := range manifest.Volumes {
+		if !found[vol.ObjectName] {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Volume %s is missing.", vol.ObjectName)
+			damaged = append(damaged, vol)
+			continue
+		}
+
+		if !checkHashes {
+			continue
+		}
+
+		c := make(chan *helpers.VolumeInfo, 1)
+		if perr := processSequence(ctx, downloadSequence{vol, c, nil}, backend, false); perr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Volume %s could not be verified - %v", vol.ObjectName, perr)
+			damaged = append(damaged, vol)
+			continue
+		}
+		downloaded := <-c
+		downloaded.DeleteVolume()
+	}
+	return damaged, nil
+}
+
+// regenerateVolumes will run the zfs send command recorded in the manifest and write the part of the stream of
+// every volume provided, sorted by volume number, to a new volume.
+func regenerateVolumes(pctx context.Context, manifest *helpers.JobInfo, volumes []*helpers.VolumeInfo) ([]*helpers.VolumeInfo, error) {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	offsets := make(map[int64]uint64, len(manifest.Volumes))
+	var offset uint64
+	for _, vol := range manifest.Volumes {
+		offsets[vol.VolumeNumber] = offset
+		offset += vol.ZFSStreamBytes
+	}
+
+	cmd := helpers.GetZFSSendCommand(ctx, manifest)
+	cmd.Stderr = os.Stderr
+	stream, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Infof("Starting zfs send command: %s", strings.Join(cmd.Args, " "))
+	if err = cmd.Start(); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error starting zfs command - %v", err)
+		return nil, err
+	}
+	defer func() {
+		cancel()
+		cmd.Wait()
+	}()
+
+	var position uint64
+	regenerated := make([]*helpers.VolumeInfo, 0, len(volumes))
+	cleanup := func() {
+		for _, vol := range regenerated {
+			vol.DeleteVolume()
+		}
+	}
+
+	for _, vol := range volumes {
+		if _, err = io.CopyN(ioutil.Discard, stream, int64(offsets[vol.VolumeNumber]-position)); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from the zfs stream - %v", err)
+			cleanup()
+			return nil, err
+		}
+
+		newVol, verr := helpers.CreateBackupVolume(ctx, manifest, vol.VolumeNumber)
+		if verr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while creating volume %d - %v", vol.VolumeNumber, verr)
+			cleanup()
+			return nil, verr
+		}
+		regenerated = append(regenerated, newVol)
+
+		if _, err = helpers.CopyN(newVol, stream, int64(vol.ZFSStreamBytes)); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to read from the zfs stream for volume %s, was the snapshot changed? - %v", vol.ObjectName, err)
+			newVol.Close()
+			cleanup()
+			return nil, err
+		}
+		newVol.ZFSStreamBytes = vol.ZFSStreamBytes
+		if err = newVol.Close(); err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Error while trying to close volume %s - %v", newVol.ObjectName, err)
+			cleanup()
+			return nil, err
+		}
+		position = offsets[vol.VolumeNumber] + vol.ZFSStreamBytes
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Regenerated volume %s.", newVol.ObjectName)
+	}
+
+	return regenerated, nil
+}
+
+// commonPrefix returns the longest prefix shared by every name.
+func commonPrefix(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	prefix := names[0]
+	for _, name := range names[1:] {
+		for !strings.HasPrefix(name, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
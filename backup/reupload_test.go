@@ -0,0 +1,61 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestCommonPrefix(t *testing.T) {
+	testCases := []struct {
+		names  []string
+		expect string
+	}{
+		{nil, ""},
+		{[]string{"tank|snap1.zstream.gz.vol1"}, "tank|snap1.zstream.gz.vol1"},
+		{[]string{"tank|snap1.zstream.gz.vol1", "tank|snap1.zstream.gz.vol2", "tank|snap1.zstream.gz.vol10"}, "tank|snap1.zstream.gz.vol"},
+		{[]string{"tank|snap1", "pool|snap1"}, ""},
+	}
+
+	for idx, c := range testCases {
+		if got := commonPrefix(c.names); got != c.expect {
+			t.Errorf("%d: expected %s, got %s", idx, c.expect, got)
+		}
+	}
+}
+
+func TestSelectVolumes(t *testing.T) {
+	volumes := []*helpers.VolumeInfo{{VolumeNumber: 1}, {VolumeNumber: 2}, {VolumeNumber: 3}}
+
+	selected, err := selectVolumes(volumes, []int64{3, 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(selected) != 2 || selected[0] != volumes[0] || selected[1] != volumes[2] {
+		t.Errorf("expected volumes 1 and 3 in order, got %v", selected)
+	}
+
+	if _, err = selectVolumes(volumes, []int64{4}); err == nil {
+		t.Errorf("expected an error selecting a volume not in the backup set")
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// SearchFilter describes the backup sets a Search should return. Empty fields match every backup set.
+type SearchFilter struct {
+	// Dataset is a glob (see path.Match) the volume name of the backup set must match.
+	Dataset string
+	// Tags must all be set on the backup set. An empty value matches any value of the tag.
+	Tags map[string]string
+	// After and Before bound the creation time of the snapshot of the backup set.
+	After  time.Time
+	Before time.Time
+}
+
+// Search will read the manifests found in the local cache of the target destination, optionally syncing
+// it with the destination first, and output the backup sets matching the filter.
+func Search(pctx context.Context, jobInfo *helpers.JobInfo, filter SearchFilter, refresh bool) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	manifests, err := SearchBackupSets(ctx, jobInfo, filter, refresh)
+	if err != nil {
+		return err
+	}
+
+	if helpers.EnvironmentOf(ctx).JSONOutput {
+		j, jerr := json.Marshal(manifests)
+		if jerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("could not marshal results to JSON - %v", jerr)
+			return jerr
+		}
+
+		fmt.Fprintln(helpers.EnvironmentOf(ctx).Stdout, string(j))
+		return nil
+	}
+
+	output := []string{fmt.Sprintf("Found %d matching backup sets:\n", len(manifests))}
+	for _, manifest := range manifests {
+		output = append(output, manifest.String())
+	}
+	fmt.Fprintln(helpers.EnvironmentOf(ctx).Stdout, strings.Join(output, "\n"))
+	return nil
+}
+
+// SearchBackupSets will read the manifests found in the local cache of the target destination, optionally
+// syncing it with the destination first, and return the backup sets matching the filter.
+func SearchBackupSets(ctx context.Context, jobInfo *helpers.JobInfo, filter SearchFilter, refresh bool) ([]*helpers.JobInfo, error) {
+	if filter.Dataset != "" {
+		if _, err := path.Match(filter.Dataset, ""); err != nil {
+			return nil, fmt.Errorf("invalid dataset pattern %s - %v", filter.Dataset, err)
+		}
+	}
+
+	target := jobInfo.Destinations[0]
+	localCachePath, err := getCacheDir(ctx, target)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+
+	var safeManifests []string
+	if refresh {
+		backend, berr := prepareBackend(ctx, jobInfo, target, nil)
+		if berr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+			return nil, berr
+		}
+		safeManifests, _, err = syncCache(ctx, jobInfo, localCachePath, backend)
+		backend.Close()
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not sync cache dir for target %s due to error - %v.", target, err)
+			return nil, err
+		}
+	} else {
+		safeManifests, err = cachedManifests(localCachePath)
+		if err != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list files in the cache dir for target %s due to error - %v.", target, err)
+			return nil, err
+		}
+		if len(safeManifests) == 0 {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("The local cache for target %s is empty, use --refresh to sync it with the target first.", target)
+		}
+	}
+
+	manifests, err := readAndSortManifests(ctx, localCachePath, safeManifests, jobInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := manifests[:0]
+	for _, manifest := range manifests {
+		if filter.Match(manifest) {
+			matches = append(matches, manifest)
+		}
+	}
+	return matches, nil
+}
+
+// Match reports whether the backup set described by manifest matches the filter.
+func (f SearchFilter) Match(manifest *helpers.JobInfo) bool {
+	if f.Dataset != "" {
+		if ok, _ := path.Match(f.Dataset, manifest.VolumeName); !ok {
+			return false
+		}
+	}
+
+	for key, value := range f.Tags {
+		tag, ok := manifest.Tags[key]
+		if !ok || (value != "" && tag != value) {
+			return false
+		}
+	}
+
+	if !f.After.IsZero() && !manifest.BaseSnapshot.CreationTime.After(f.After) {
+		return false
+	}
+
+	if !f.Before.IsZero() && !manifest.BaseSnapshot.CreationTime.Before(f.Before) {
+		return false
+	}
+
+	return true
+}
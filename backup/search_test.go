@@ -0,0 +1,63 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestSearchFilterMatch(t *testing.T) {
+	created := time.Date(2017, 11, 15, 0, 0, 0, 0, time.UTC)
+	manifest := &helpers.JobInfo{
+		VolumeName:   "tank/pgdata",
+		BaseSnapshot: helpers.SnapshotInfo{Name: "snap1", CreationTime: created},
+		Tags:         map[string]string{"purpose": "pre-upgrade", "ticket": "OPS-1234"},
+	}
+
+	testCases := []struct {
+		filter SearchFilter
+		expect bool
+	}{
+		{SearchFilter{}, true},
+		{SearchFilter{Dataset: "tank/*"}, true},
+		{SearchFilter{Dataset: "tank/pgdata"}, true},
+		{SearchFilter{Dataset: "tank"}, false},
+		{SearchFilter{Dataset: "pool/*"}, false},
+		{SearchFilter{Tags: map[string]string{"purpose": "pre-upgrade"}}, true},
+		{SearchFilter{Tags: map[string]string{"purpose": ""}}, true},
+		{SearchFilter{Tags: map[string]string{"purpose": "pre-upgrade", "ticket": "OPS-1234"}}, true},
+		{SearchFilter{Tags: map[string]string{"purpose": "nightly"}}, false},
+		{SearchFilter{Tags: map[string]string{"keep": ""}}, false},
+		{SearchFilter{After: created.Add(-time.Hour), Before: created.Add(time.Hour)}, true},
+		{SearchFilter{After: created}, false},
+		{SearchFilter{Before: created}, false},
+		{SearchFilter{Dataset: "tank/*", Tags: map[string]string{"purpose": "nightly"}}, false},
+	}
+
+	for idx, c := range testCases {
+		if got := c.filter.Match(manifest); got != c.expect {
+			t.Errorf("%d: expected %v, got %v", idx, c.expect, got)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Seed will send the full backup set described by jobInfo to the local directory stageDir instead of its
+// destinations, to be shipped and loaded into them out of band, and upload only its manifest to the
+// destinations so the incremental backups sent over the network chain onto the seeded backup set.
+func Seed(pctx context.Context, jobInfo *helpers.JobInfo, stageDir string) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	if jobInfo.IncrementalSnapshot.Name != "" {
+		return fmt.Errorf("only a full backup can be seeded")
+	}
+
+	// The manifest is written in the format of the destinations, not the one of the local directory
+	if err := resolveManifestFormat(ctx, jobInfo); err != nil {
+		return err
+	}
+
+	destinations := jobInfo.Destinations
+	jobInfo.Destinations = []string{fmt.Sprintf("%s://%s", backends.FileBackendPrefix, stageDir)}
+	err := Backup(ctx, jobInfo)
+	jobInfo.Destinations = destinations
+	if err != nil {
+		return err
+	}
+
+	for _, destination := range destinations {
+		if _, cerr := getCacheDir(ctx, destination); cerr != nil {
+			helpers.EnvironmentOf(ctx).Logger.Errorf("Could not create cache for destination %s due to error - %v.", destination, cerr)
+			return cerr
+		}
+	}
+
+	manifest, err := saveManifest(ctx, jobInfo, true)
+	if err != nil {
+		return err
+	}
+	defer manifest.DeleteVolume()
+
+	return uploadManifest(ctx, jobInfo, manifest, destinations)
+}
@@ -0,0 +1,151 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackup-seed")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir := helpers.WorkingDir
+	helpers.WorkingDir = filepath.Join(dir, "work")
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+
+	// A fake zfs binary sending a stream of random data for the only snapshot of Tank/Data
+	stream := make([]byte, 3<<20)
+	if _, err = rand.Read(stream); err != nil {
+		t.Fatalf("could not generate the stream: %v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "stream"), stream, 0600); err != nil {
+		t.Fatalf("could not write the stream: %v", err)
+	}
+	script := `#!/bin/sh
+case "$1" in
+list) printf 'Tank/Data@snap1\t1700000000\n';;
+get) echo 1700000000;;
+send) case "$*" in *" -n"*) printf 'size\t3145728\n';; *) cat ` + filepath.Join(dir, "stream") + `;; esac;;
+esac
+`
+	oldZFSPath := helpers.ZFSPath
+	helpers.ZFSPath = filepath.Join(dir, "zfs")
+	defer func() { helpers.ZFSPath = oldZFSPath }()
+	if err = ioutil.WriteFile(helpers.ZFSPath, []byte(script), 0700); err != nil {
+		t.Fatalf("could not write the fake zfs binary: %v", err)
+	}
+
+	stage := filepath.Join(dir, "stage")
+	target := filepath.Join(dir, "target")
+	for _, d := range []string{stage, target} {
+		if err = os.MkdirAll(d, 0700); err != nil {
+			t.Fatalf("could not create %s: %v", d, err)
+		}
+	}
+
+	newJob := func() *helpers.JobInfo {
+		return &helpers.JobInfo{
+			VolumeName:         "Tank/Data",
+			BaseSnapshot:       helpers.SnapshotInfo{Name: "snap1", CreationTime: time.Unix(1700000000, 0)},
+			StartTime:          time.Now(),
+			Compressor:         helpers.InternalCompressor,
+			CompressionLevel:   6,
+			DigestAlgorithm:    helpers.DigestSHA256,
+			VolumeSize:         1,
+			MaxFileBuffer:      2,
+			MaxParallelUploads: 2,
+			MaxRetryTime:       time.Minute,
+			MaxBackoffTime:     time.Second,
+			Separator:          "|",
+			UploadChunkSize:    10,
+			SendReadSize:       helpers.DefaultSendReadSize,
+			CompressBlockSize:  helpers.DefaultCompressorBlockSize,
+			UploadReadSize:     helpers.DefaultUploadReadSize,
+			ManifestPrefix:     "manifests",
+			Version:            helpers.VersionNumber,
+			Destinations:       []string{"file://" + target},
+		}
+	}
+
+	job := newJob()
+	job.IncrementalSnapshot = helpers.SnapshotInfo{Name: "snap0"}
+	if err = Seed(context.Background(), job, stage); err == nil {
+		t.Errorf("expected an error seeding an incremental backup")
+	}
+
+	if err = Seed(context.Background(), newJob(), stage); err != nil {
+		t.Fatalf("could not seed the backup set: %v", err)
+	}
+
+	// The volumes are only staged, the destination only gets the manifest (and its history)
+	list := func(d string) (volumes, manifests []string) {
+		err := filepath.Walk(d, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			name, _ := filepath.Rel(d, path)
+			if strings.HasSuffix(name, ".manifest.gz") {
+				manifests = append(manifests, name)
+			} else if !strings.HasPrefix(name, "manifests") {
+				volumes = append(volumes, name)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("could not list %s: %v", d, err)
+		}
+		return volumes, manifests
+	}
+	stagedVolumes, stagedManifests := list(stage)
+	targetVolumes, targetManifests := list(target)
+	if len(stagedVolumes) < 2 || len(stagedManifests) != 1 {
+		t.Errorf("expected the volumes and manifest to be staged, got %v and %v", stagedVolumes, stagedManifests)
+	}
+	if len(targetVolumes) != 0 || len(targetManifests) != 1 {
+		t.Errorf("expected only the manifest at the destination, got %v and %v", targetVolumes, targetManifests)
+	}
+
+	sets, err := getBackupsForTarget(context.Background(), "Tank/Data", "file://"+target, newJob())
+	if err != nil {
+		t.Fatalf("could not list the backup sets: %v", err)
+	}
+	if len(sets) != 1 || sets[0].BaseSnapshot.Name != "snap1" || len(sets[0].Volumes) != len(stagedVolumes) {
+		t.Fatalf("expected the destination to hold the seeded backup set of %d volumes, got %+v", len(stagedVolumes), sets)
+	}
+	for _, vol := range sets[0].Volumes {
+		if _, serr := os.Stat(filepath.Join(stage, vol.ObjectName)); serr != nil {
+			t.Errorf("expected the volume %s of the manifest to be staged: %v", vol.ObjectName, serr)
+		}
+	}
+}
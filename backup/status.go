@@ -0,0 +1,107 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// The levels of the status of a volume, from the least to the most severe, matching the exit codes of a Nagios plugin.
+const (
+	StatusOK = iota
+	StatusWarning
+	StatusCritical
+	StatusUnknown
+)
+
+// StatusLevelNames are the names of the status levels, as output by a Nagios plugin.
+var StatusLevelNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// VolumeStatus is the status of the backups of a volume found at a target.
+type VolumeStatus struct {
+	Volume     string
+	Latest     *helpers.JobInfo `json:"-"` // The most recent backup set of the volume, nil if none was found
+	Snapshot   string           // The snapshot of the most recent backup set
+	Created    time.Time        // When the snapshot of the most recent backup set was taken
+	Age        time.Duration    // How long ago the snapshot of the most recent backup set was taken
+	Restorable bool             // Whether the most recent backup set is chained to a full backup set
+	Level      int              // One of StatusOK, StatusWarning, or StatusCritical
+	Reason     string           // Why the volume is at this level
+}
+
+// Status will sync the manifests found in the target destination to the local cache and report the status of the
+// most recent backup set of every volume found there, or only of volume if provided. A volume is at StatusCritical
+// if it has no backup set, if its most recent one can't be restored, or if its snapshot is older than maxAge, and
+// at StatusWarning if its snapshot is older than warnAge. Ages of 0 are not checked.
+func Status(ctx context.Context, jobInfo *helpers.JobInfo, volume string, warnAge, maxAge time.Duration) ([]VolumeStatus, error) {
+	sets, _, err := listBackupSets(ctx, jobInfo, volume, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return volumeStatuses(sets, volume, warnAge, maxAge, time.Now()), nil
+}
+
+// volumeStatuses will report the status of the volumes of the backup sets provided, sorted by volume and snapshot
+// creation time, as of now.
+func volumeStatuses(sets []*helpers.JobInfo, volume string, warnAge, maxAge time.Duration, now time.Time) []VolumeStatus {
+	restorable := newChainGraph(sets).restorable()
+
+	var statuses []VolumeStatus
+	for idx, set := range sets {
+		// The last backup set of a volume is its most recent one
+		if idx != len(sets)-1 && sets[idx+1].VolumeName == set.VolumeName {
+			continue
+		}
+
+		status := VolumeStatus{
+			Volume:     set.VolumeName,
+			Latest:     set,
+			Snapshot:   set.BaseSnapshot.Name,
+			Created:    set.BaseSnapshot.CreationTime,
+			Age:        now.Sub(set.BaseSnapshot.CreationTime),
+			Restorable: restorable[set],
+		}
+		age := status.Age.Round(time.Minute)
+		switch {
+		case !status.Restorable:
+			status.Level = StatusCritical
+			status.Reason = fmt.Sprintf("the most recent backup set (%s) has a broken chain", status.Snapshot)
+		case maxAge > 0 && status.Age > maxAge:
+			status.Level = StatusCritical
+			status.Reason = fmt.Sprintf("the most recent backup set (%s) is %v old, more than %v", status.Snapshot, age, maxAge)
+		case warnAge > 0 && status.Age > warnAge:
+			status.Level = StatusWarning
+			status.Reason = fmt.Sprintf("the most recent backup set (%s) is %v old, more than %v", status.Snapshot, age, warnAge)
+		default:
+			status.Reason = fmt.Sprintf("the most recent backup set (%s) is %v old", status.Snapshot, age)
+		}
+		statuses = append(statuses, status)
+	}
+
+	if volume != "" && len(statuses) == 0 {
+		statuses = append(statuses, VolumeStatus{Volume: volume, Level: StatusCritical, Reason: "no backup set found"})
+	}
+	return statuses
+}
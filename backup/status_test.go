@@ -0,0 +1,78 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestVolumeStatuses(t *testing.T) {
+	now := time.Date(2017, 1, 3, 0, 0, 0, 0, time.UTC)
+	snap := func(name string, hoursAgo int) helpers.SnapshotInfo {
+		return helpers.SnapshotInfo{Name: name, CreationTime: now.Add(-time.Duration(hoursAgo) * time.Hour)}
+	}
+	backup := func(volume string, base, incremental helpers.SnapshotInfo) *helpers.JobInfo {
+		return &helpers.JobInfo{VolumeName: volume, BaseSnapshot: base, IncrementalSnapshot: incremental}
+	}
+	snap1, snap2, snap3 := snap("snap1", 48), snap("snap2", 25), snap("snap3", 1)
+	full1 := backup("tank/a", snap1, helpers.SnapshotInfo{})
+	incr2 := backup("tank/a", snap2, snap1)
+	incr3 := backup("tank/a", snap3, snap2)
+	fullB := backup("tank/b", snap1, helpers.SnapshotInfo{})
+	orphan := backup("tank/c", snap3, snap2)
+
+	testCases := []struct {
+		sets     []*helpers.JobInfo
+		volume   string
+		warnAge  time.Duration
+		maxAge   time.Duration
+		expected map[string]int
+	}{
+		{[]*helpers.JobInfo{full1, incr2, incr3}, "", 0, 0, map[string]int{"tank/a": StatusOK}},
+		{[]*helpers.JobInfo{full1, incr2, incr3, fullB}, "", 0, 0, map[string]int{"tank/a": StatusOK, "tank/b": StatusOK}},
+		{[]*helpers.JobInfo{full1, incr2, incr3, fullB}, "", 24 * time.Hour, 0, map[string]int{"tank/a": StatusOK, "tank/b": StatusWarning}},
+		{[]*helpers.JobInfo{full1, incr2, incr3, fullB}, "", 24 * time.Hour, 26 * time.Hour, map[string]int{"tank/a": StatusOK, "tank/b": StatusCritical}},
+		{[]*helpers.JobInfo{full1, incr2}, "tank/a", 24 * time.Hour, 26 * time.Hour, map[string]int{"tank/a": StatusWarning}},
+		{[]*helpers.JobInfo{full1, incr2}, "tank/a", 0, 24 * time.Hour, map[string]int{"tank/a": StatusCritical}},
+		// The latest backup set must be restorable, whatever its age
+		{[]*helpers.JobInfo{orphan}, "", 0, 0, map[string]int{"tank/c": StatusCritical}},
+		{[]*helpers.JobInfo{full1, incr3}, "", 0, 0, map[string]int{"tank/a": StatusCritical}},
+		// A volume without any backup set is critical
+		{nil, "tank/d", 0, 0, map[string]int{"tank/d": StatusCritical}},
+		{nil, "", 0, 0, map[string]int{}},
+	}
+
+	for idx, c := range testCases {
+		statuses := volumeStatuses(c.sets, c.volume, c.warnAge, c.maxAge, now)
+		if len(statuses) != len(c.expected) {
+			t.Errorf("%d: expected %d volumes, got %d", idx, len(c.expected), len(statuses))
+			continue
+		}
+		for _, status := range statuses {
+			if level, ok := c.expected[status.Volume]; !ok || level != status.Level {
+				t.Errorf("%d: expected %s to be %s, got %s (%s)", idx, status.Volume, StatusLevelNames[level], StatusLevelNames[status.Level], status.Reason)
+			}
+		}
+	}
+}
@@ -24,39 +24,67 @@ import (
 	"context"
 	"crypto/md5"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
 
 	"github.com/someone1/zfsbackup-go/backends"
 	"github.com/someone1/zfsbackup-go/helpers"
 )
 
 func prepareBackend(ctx context.Context, j *helpers.JobInfo, backendURI string, uploadBuffer chan bool) (backends.Backend, error) {
-	helpers.AppLogger.Debugf("Initializing Backend %s", backendURI)
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Initializing Backend %s", backendURI)
+	objectTags, err := j.RenderObjectLabels(j.ObjectTags)
+	if err != nil {
+		return nil, helpers.NewError(helpers.ErrorKindConfig, err)
+	}
+	objectMetadata, err := j.RenderObjectLabels(j.ObjectMetadata)
+	if err != nil {
+		return nil, helpers.NewError(helpers.ErrorKindConfig, err)
+	}
+
 	conf := &backends.BackendConfig{
 		MaxParallelUploadBuffer: uploadBuffer,
 		TargetURI:               backendURI,
-		MaxParallelUploads:      j.MaxParallelUploads,
+		MaxParallelUploads:      j.UploadWorkers(),
 		MaxBackoffTime:          j.MaxBackoffTime,
 		MaxRetryTime:            j.MaxRetryTime,
 		UploadChunkSize:         j.UploadChunkSize * 1024 * 1024,
+		UploadReadSize:          j.UploadReadSize * 1024,
+		ObjectTags:              objectTags,
+		ObjectMetadata:          objectMetadata,
 	}
 
 	backend, err := backends.GetBackendForURI(backendURI)
 	if err != nil {
-		return nil, err
+		return nil, helpers.NewError(helpers.ErrorKindConfig, err)
 	}
 
 	err = backend.Init(ctx, conf)
 
-	return backend, err
+	return backend, helpers.NewError(helpers.ErrorKindBackend, err)
+}
+
+// CheckDestination will initialize the backend for the provided URI and list its manifests,
+// confirming the destination is valid and its credentials are usable without transferring any data.
+func CheckDestination(ctx context.Context, j *helpers.JobInfo, backendURI string) error {
+	backend, err := prepareBackend(ctx, j, backendURI, nil)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	_, err = backend.List(ctx, j.ManifestPrefix)
+	return helpers.NewError(helpers.ErrorKindBackend, err)
 }
 
-func getCacheDir(backendURI string) (string, error) {
+func getCacheDir(ctx context.Context, backendURI string) (string, error) {
 	safeFolder := fmt.Sprintf("%x", md5.Sum([]byte(backendURI)))
-	dest := filepath.Join(helpers.WorkingDir, "cache", safeFolder)
+	dest := filepath.Join(helpers.EnvironmentOf(ctx).WorkingDir, "cache", safeFolder)
 	oerr := os.MkdirAll(dest, os.ModePerm)
 	if oerr != nil {
 		return "", fmt.Errorf("could not create cache directory %s due to an error: %v", dest, oerr)
@@ -65,64 +93,188 @@ func getCacheDir(backendURI string) (string, error) {
 	return dest, nil
 }
 
+// listManifests will return the object names of the manifests found at the destination along with
+// their version, empty if the backend can't tell the versions of its objects apart.
+func listManifests(ctx context.Context, j *helpers.JobInfo, backend backends.Backend) (map[string]string, error) {
+	prefix := j.ManifestPrefix
+	if j.DiscoverManifests {
+		prefix = ""
+	}
+
+	var objects map[string]string
+	if versioner, ok := backend.(backends.Versioner); ok {
+		versions, err := versioner.ListVersions(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		objects = versions
+	} else {
+		names, err := backend.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		objects = make(map[string]string, len(names))
+		for _, name := range names {
+			objects[name] = ""
+		}
+	}
+	for object := range objects {
+		if _, _, ok := helpers.ParseManifestHistoryObjectName(object); ok {
+			delete(objects, object)
+		} else if _, ok := parseTrashObjectName(object); ok {
+			delete(objects, object)
+		}
+	}
+
+	if !j.DiscoverManifests {
+		return objects, nil
+	}
+
+	total := len(objects)
+	for object := range objects {
+		if !helpers.IsManifestObjectName(j, object) {
+			delete(objects, object)
+		}
+	}
+	helpers.EnvironmentOf(ctx).Logger.Debugf("Discovered %d manifests out of %d objects.", len(objects), total)
+	return objects, nil
+}
+
 // Returns local manifest paths that exist in the backend and those that do not
 func syncCache(ctx context.Context, j *helpers.JobInfo, localCache string, backend backends.Backend) ([]string, []string, error) {
+	safeManifests, localOnlyFiles, _, err := refreshCache(ctx, j, localCache, backend)
+	return safeManifests, localOnlyFiles, err
+}
+
+// refreshCache will download the manifests found at the destination that are missing from the local cache,
+// or that changed since they were cached, and return the local manifest paths that exist in the backend,
+// those that do not, and how many manifests were downloaded.
+func refreshCache(ctx context.Context, j *helpers.JobInfo, localCache string, backend backends.Backend) ([]string, []string, int, error) {
 	// List all manifests at the destination
-	manifests, merr := backend.List(ctx, j.ManifestPrefix)
+	objects, merr := listManifests(ctx, j, backend)
 	if merr != nil {
-		return nil, nil, fmt.Errorf("could not list manifest files from the backed due to error - %v", merr)
-	}
-
-	// Make it safe for local file system storage
-	safeManifests := make([]string, len(manifests))
-	for idx := range manifests {
-		safeManifests[idx] = fmt.Sprintf("%x", md5.Sum([]byte(manifests[idx])))
+		return nil, nil, 0, helpers.NewError(helpers.ErrorKindBackend, fmt.Errorf("could not list manifest files from the backed due to error - %v", merr))
 	}
 
 	// Check what manifests we have locally, and if we are missing any, download them
-	files, ferr := ioutil.ReadDir(localCache)
+	cached, ferr := cachedManifests(localCache)
 	if ferr != nil {
-		return nil, nil, fmt.Errorf("could not list files from the local cache dir due to error - %v", ferr)
+		return nil, nil, 0, fmt.Errorf("could not list files from the local cache dir due to error - %v", ferr)
 	}
+	isCached := make(map[string]bool, len(cached))
+	for _, file := range cached {
+		isCached[file] = true
+	}
+	index := readCacheIndex(ctx, localCache)
 
-	var localOnlyFiles []string
-	var foundFiles []string
-	for _, file := range files {
-		if file.IsDir() {
+	// Make it safe for local file system storage
+	var manifests, safeManifests, foundFiles []string
+	synced := make(map[string]cacheEntry, len(objects))
+	seen := make(map[string]bool, len(objects))
+	names := make([]string, 0, len(objects))
+	for object := range objects {
+		names = append(names, object)
+	}
+	sort.Strings(names)
+	for _, object := range names {
+		safeManifest := fmt.Sprintf("%x", md5.Sum([]byte(object)))
+		seen[safeManifest] = true
+		entry, indexed := index.Manifests[safeManifest]
+		switch {
+		case !isCached[safeManifest]:
+		case objects[object] == "" || (indexed && entry.Version == objects[object]):
+			foundFiles = append(foundFiles, safeManifest)
+			synced[safeManifest] = cacheEntry{ObjectName: object, Version: objects[object]}
 			continue
+		default:
+			helpers.EnvironmentOf(ctx).Logger.Debugf("The manifest %s changed since it was cached, downloading it again.", object)
 		}
-		found := false
-		for idx := range manifests {
-			if strings.Compare(file.Name(), safeManifests[idx]) == 0 {
-				found = true
-				foundFiles = append(foundFiles, safeManifests[idx])
-				manifests = append(manifests[:idx], manifests[idx+1:]...)
-				safeManifests = append(safeManifests[:idx], safeManifests[idx+1:]...)
-				break
-			}
-		}
-		if !found {
-			localOnlyFiles = append(localOnlyFiles, file.Name())
+		manifests = append(manifests, object)
+		safeManifests = append(safeManifests, safeManifest)
+	}
+
+	var localOnlyFiles []string
+	for _, file := range cached {
+		if !seen[file] {
+			localOnlyFiles = append(localOnlyFiles, file)
 		}
 	}
 
 	pderr := backend.PreDownload(ctx, manifests)
 	if pderr != nil {
-		return nil, nil, fmt.Errorf("could not prepare manifests for download due to error - %v", pderr)
+		return nil, nil, 0, fmt.Errorf("could not prepare manifests for download due to error - %v", pderr)
 	}
 
+	downloaded := make([]bool, len(manifests))
 	if len(manifests) > 0 {
-		helpers.AppLogger.Debugf("Syncing %d manifests to local cache.", len(manifests))
+		helpers.EnvironmentOf(ctx).Logger.Debugf("Syncing %d manifests to local cache.", len(manifests))
 
 		// manifests should only contain what we don't have locally
-		for idx, manifest := range manifests {
-			downloadTo(ctx, backend, manifest, filepath.Join(localCache, safeManifests[idx]))
+		if err := forEachManifest(ctx, j, len(manifests), func(ctx context.Context, idx int) error {
+			downloaded[idx] = downloadTo(ctx, backend, manifests[idx], filepath.Join(localCache, safeManifests[idx])) == nil
+			return nil
+		}); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	// Only remember the manifests found at the destination, those not downloaded are tried again next time
+	count := 0
+	for idx, manifest := range manifests {
+		if downloaded[idx] {
+			synced[safeManifests[idx]] = cacheEntry{ObjectName: manifest, Version: objects[manifest]}
+			count++
 		}
 	}
+	index.Manifests = synced
+	index.Synced = time.Now()
+	if err := writeCacheIndex(localCache, index); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Warningf("Could not save the index of the local cache dir %s due to error - %v", localCache, err)
+	}
 
 	safeManifests = append(safeManifests, foundFiles...)
 
-	return safeManifests, localOnlyFiles, nil
+	return safeManifests, localOnlyFiles, count, nil
+}
+
+// forEachManifest will call fn with the index of each of the n manifests provided from at most
+// j.ManifestWorkers goroutines at once, returning the first error encountered.
+func forEachManifest(ctx context.Context, j *helpers.JobInfo, n int, fn func(ctx context.Context, idx int) error) error {
+	workers := j.ManifestWorkers
+	if workers <= 0 {
+		workers = helpers.DefaultManifestWorkers
+	}
+	if workers > n {
+		workers = n
+	}
+
+	group, gctx := errgroup.WithContext(ctx)
+	next := make(chan int)
+	for i := 0; i < workers; i++ {
+		group.Go(func() error {
+			for idx := range next {
+				if err := fn(gctx, idx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+feed:
+	for idx := 0; idx < n; idx++ {
+		select {
+		case next <- idx:
+		case <-gctx.Done():
+			break feed
+		}
+	}
+	close(next)
+
+	if err := group.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
 }
 
 func validateSnapShotExists(ctx context.Context, snapshot *helpers.SnapshotInfo, target string) (bool, error) {
@@ -134,6 +286,18 @@ func validateSnapShotExists(ctx context.Context, snapshot *helpers.SnapshotInfo,
 	return validateSnapShotExistsFromSnaps(snapshot, snapshots), nil
 }
 
+// localSnapshots will return the snapshots found on the target provided, none if the target does not exist.
+func localSnapshots(ctx context.Context, target string) ([]helpers.SnapshotInfo, error) {
+	snapshots, err := helpers.GetSnapshots(ctx, target)
+	if err != nil {
+		if strings.Contains(err.Error(), "dataset does not exist") {
+			return []helpers.SnapshotInfo{}, nil
+		}
+		return nil, err
+	}
+	return snapshots, nil
+}
+
 func validateSnapShotExistsFromSnaps(snapshot *helpers.SnapshotInfo, snapshots []helpers.SnapshotInfo) bool {
 	for _, snap := range snapshots {
 		if snap.Equal(snapshot) {
@@ -0,0 +1,105 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestForEachManifest(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	testCases := []struct {
+		workers int
+		n       int
+		failAt  int
+		err     error
+	}{
+		{0, 20, -1, nil},
+		{1, 20, -1, nil},
+		{4, 20, -1, nil},
+		{32, 3, -1, nil},
+		{4, 0, -1, nil},
+		{4, 20, 7, errFailed},
+	}
+
+	for idx, c := range testCases {
+		var lock sync.Mutex
+		running, maxRunning := 0, 0
+		seen := make([]bool, c.n)
+		release, released := make(chan struct{}), false
+		limit := c.workers
+		if limit == 0 {
+			limit = helpers.DefaultManifestWorkers
+		}
+		if limit > c.n {
+			limit = c.n
+		}
+
+		j := &helpers.JobInfo{ManifestWorkers: c.workers}
+		err := forEachManifest(context.Background(), j, c.n, func(ctx context.Context, i int) error {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			seen[i] = true
+			// Hold the first workers until every one of them has started
+			if running == limit && !released {
+				released = true
+				close(release)
+			}
+			lock.Unlock()
+
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+			if i == c.failAt {
+				return errFailed
+			}
+			return nil
+		})
+		if err != c.err {
+			t.Errorf("%d: expected error %v, got %v", idx, c.err, err)
+			continue
+		}
+		if maxRunning != limit {
+			t.Errorf("%d: expected %d manifests read at once, got %d", idx, limit, maxRunning)
+		}
+		if c.err == nil {
+			for i := range seen {
+				if !seen[i] {
+					t.Errorf("%d: manifest %d was never read", idx, i)
+				}
+			}
+		}
+	}
+}
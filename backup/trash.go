@@ -0,0 +1,217 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// TrashPrefix is the prefix of the objects clean moves to the trash of a destination instead of deleting them.
+const TrashPrefix = "trash/"
+
+// trashTimeFormat formats the time an object in the trash expires at, part of its name.
+const trashTimeFormat = "20060102T150405Z"
+
+// TrashedObject is an object moved to the trash of a destination, deleted for good by clean once it expires.
+type TrashedObject struct {
+	Name    string // The name of the object in the trash
+	Object  string // The name of the object it was deleted from and is undeleted to
+	Expires time.Time
+}
+
+// trashObjectName returns the name object is kept as in the trash until it expires.
+func trashObjectName(object string, expires time.Time) string {
+	return fmt.Sprintf("%s%s/%s", TrashPrefix, expires.UTC().Format(trashTimeFormat), object)
+}
+
+// parseTrashObjectName returns the object in the trash stored as name. It returns false if name is not the name of
+// an object in the trash.
+func parseTrashObjectName(name string) (TrashedObject, bool) {
+	if !strings.HasPrefix(name, TrashPrefix) {
+		return TrashedObject{}, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, TrashPrefix), "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return TrashedObject{}, false
+	}
+	expires, err := time.Parse(trashTimeFormat, parts[0])
+	if err != nil {
+		return TrashedObject{}, false
+	}
+	return TrashedObject{Name: name, Object: parts[1], Expires: expires}, true
+}
+
+// moveObject will move the object from to the object to at the backend, within the destination if the backend
+// can, otherwise by downloading it, uploading it again and then deleting it.
+func moveObject(ctx context.Context, backend backends.Backend, from, to string) error {
+	if mover, ok := backend.(backends.Mover); ok {
+		if err := mover.Move(ctx, from, to); err != backends.ErrMoveNotSupported {
+			return err
+		}
+	}
+
+	r, err := backend.Download(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	vol, err := helpers.CreateSimpleVolume(ctx, false)
+	if err != nil {
+		return err
+	}
+	defer vol.DeleteVolume()
+
+	if _, err = io.Copy(vol, r); err != nil {
+		vol.Close()
+		return err
+	}
+	if err = vol.Close(); err != nil {
+		return err
+	}
+	vol.ObjectName = to
+
+	if err = vol.OpenVolume(); err != nil {
+		return err
+	}
+	err = backend.Upload(ctx, vol)
+	vol.Close()
+	if err != nil {
+		return err
+	}
+	return backend.Delete(ctx, from)
+}
+
+// ListTrash will return the objects in the trash of the target destination, those expiring last first.
+func ListTrash(ctx context.Context, jobInfo *helpers.JobInfo) ([]TrashedObject, error) {
+	target := jobInfo.Destinations[0]
+	backend, err := prepareBackend(ctx, jobInfo, target, nil)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+	defer backend.Close()
+
+	return listTrash(ctx, backend, target)
+}
+
+func listTrash(ctx context.Context, backend backends.Backend, target string) ([]TrashedObject, error) {
+	names, err := backend.List(ctx, TrashPrefix)
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list objects in backend %s due to error - %v", target, err)
+		return nil, err
+	}
+
+	trashed := make([]TrashedObject, 0, len(names))
+	for _, name := range names {
+		if object, ok := parseTrashObjectName(name); ok {
+			trashed = append(trashed, object)
+		}
+	}
+	sort.SliceStable(trashed, func(i, j int) bool {
+		return trashed[i].Expires.After(trashed[j].Expires)
+	})
+	return trashed, nil
+}
+
+// Undelete will move the objects in the trash of the target destination back to where they were deleted from,
+// only those whose name matches one of the shell patterns provided if any. An object deleted several times is
+// undeleted from the last time it was, and an object found in the destination again is left in the trash.
+// It returns the objects undeleted.
+func Undelete(pctx context.Context, jobInfo *helpers.JobInfo, patterns []string) ([]TrashedObject, error) {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	target := jobInfo.Destinations[0]
+	backend, err := prepareBackend(ctx, jobInfo, target, make(chan bool, objectWorkers))
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, err)
+		return nil, err
+	}
+	defer backend.Close()
+
+	trashed, err := listTrash(ctx, backend, target)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := backend.List(ctx, "")
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not list objects in backend %s due to error - %v", target, err)
+		return nil, err
+	}
+	existing := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		existing[object] = true
+	}
+
+	var undelete []TrashedObject
+	byName := make(map[string]TrashedObject)
+	for _, object := range trashed {
+		if !matchesAny(object.Object, patterns) {
+			continue
+		}
+		if existing[object.Object] {
+			helpers.EnvironmentOf(ctx).Logger.Warningf("Not undeleting %s, it is found in the destination again. It is kept in the trash until %v.", object.Object, object.Expires)
+			continue
+		}
+		existing[object.Object] = true
+		undelete = append(undelete, object)
+		byName[object.Name] = object
+	}
+
+	names := make([]string, 0, len(undelete))
+	for _, object := range undelete {
+		names = append(names, object.Name)
+	}
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Starting to undelete %d objects in destination.", len(names))
+	err = forEachObject(ctx, "undelete", names, func(ctx context.Context, name string) error {
+		return moveObject(ctx, backend, name, byName[name].Object)
+	})
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not finish undelete operation due to error, aborting: %v", err)
+		return nil, err
+	}
+
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Done.")
+	return undelete, nil
+}
+
+// matchesAny reports whether name matches one of the shell patterns provided, or true if there are none.
+func matchesAny(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
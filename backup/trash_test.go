@@ -0,0 +1,196 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestTrashObjectName(t *testing.T) {
+	expires := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	name := trashObjectName("tank/data|snap1.zstream.gz.vol1", expires)
+	object, ok := parseTrashObjectName(name)
+	if !ok {
+		t.Fatalf("expected %s to be an object in the trash", name)
+	}
+	if object.Object != "tank/data|snap1.zstream.gz.vol1" || !object.Expires.Equal(expires) {
+		t.Errorf("expected the object and expiration to round trip, got %+v", object)
+	}
+
+	for _, name := range []string{"tank/data|snap1.zstream.gz.vol1", "trash/data|snap1", "trash/20240102T030405Z/", "manifests|trash/20240102T030405Z/x"} {
+		if _, ok := parseTrashObjectName(name); ok {
+			t.Errorf("expected %s not to be an object in the trash", name)
+		}
+	}
+}
+
+func TestCleanTrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackuptrash")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldWorkingDir, oldTempdir := helpers.WorkingDir, helpers.BackupTempdir
+	helpers.WorkingDir, helpers.BackupTempdir = filepath.Join(dir, "work"), dir
+	defer func() { helpers.WorkingDir, helpers.BackupTempdir = oldWorkingDir, oldTempdir }()
+
+	target := filepath.Join(dir, "target")
+	store := func(name, content string) {
+		path := filepath.Join(target, name)
+		if werr := os.MkdirAll(filepath.Dir(path), 0700); werr != nil {
+			t.Fatalf("could not create the directory of %s: %v", name, werr)
+		}
+		if werr := ioutil.WriteFile(path, []byte(content), 0600); werr != nil {
+			t.Fatalf("could not store %s: %v", name, werr)
+		}
+	}
+	exists := func(name string) bool {
+		_, serr := os.Stat(filepath.Join(target, name))
+		return serr == nil
+	}
+
+	store("orphan", "orphan")
+	expired := trashObjectName("expired", time.Now().Add(-time.Hour))
+	store(expired, "expired")
+	older := trashObjectName("again", time.Now().Add(time.Hour))
+	store(older, "older")
+
+	j := &helpers.JobInfo{ManifestPrefix: "manifests", Destinations: []string{"file://" + target}, TrashFor: 2 * time.Hour}
+	plan, err := Clean(context.Background(), j, false)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(plan.Objects) != 1 || plan.Objects[0] != "orphan" {
+		t.Errorf("expected only the orphan object to be moved to the trash, got %v", plan.Objects)
+	}
+	if len(plan.Purged) != 1 || plan.Purged[0] != expired {
+		t.Errorf("expected the expired object to be purged, got %v", plan.Purged)
+	}
+	trashed := trashObjectName("orphan", plan.TrashedUntil)
+	if exists("orphan") || !exists(trashed) {
+		t.Errorf("expected the orphan object to be moved to %s", trashed)
+	}
+	if exists(expired) || !exists(older) {
+		t.Errorf("expected only the expired object to be deleted from the trash")
+	}
+
+	// The last deletion of an object is undeleted, unless the object is found in the destination again
+	store(trashObjectName("again", time.Now().Add(2*time.Hour)), "newer")
+	undeleted, err := Undelete(context.Background(), j, []string{"again"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(undeleted) != 1 {
+		t.Fatalf("expected one object to be undeleted, got %+v", undeleted)
+	}
+	if content, rerr := ioutil.ReadFile(filepath.Join(target, "again")); rerr != nil || string(content) != "newer" {
+		t.Errorf("expected the last deletion of the object to be undeleted, got %q (%v)", content, rerr)
+	}
+	if undeleted, err = Undelete(context.Background(), j, []string{"again"}); err != nil || len(undeleted) != 0 {
+		t.Errorf("expected the object found again not to be undeleted, got %+v (%v)", undeleted, err)
+	}
+	if !exists(older) {
+		t.Errorf("expected the older deletion of the object to be left in the trash")
+	}
+
+	if undeleted, err = Undelete(context.Background(), j, nil); err != nil || len(undeleted) != 1 || undeleted[0].Object != "orphan" {
+		t.Errorf("expected the orphan object to be undeleted, got %+v (%v)", undeleted, err)
+	}
+	if !exists("orphan") || exists(trashed) {
+		t.Errorf("expected the orphan object to be moved back from the trash")
+	}
+}
+
+// copyingBackend hides the Mover implementation of the backend it wraps
+type copyingBackend struct {
+	backends.Backend
+}
+
+// unsupportedMover refuses to move any object
+type unsupportedMover struct {
+	backends.Backend
+	moves int
+}
+
+func (u *unsupportedMover) Move(ctx context.Context, from, to string) error {
+	u.moves++
+	return backends.ErrMoveNotSupported
+}
+
+func TestMoveObject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupmove")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldTempdir := helpers.BackupTempdir
+	helpers.BackupTempdir = dir
+	defer func() { helpers.BackupTempdir = oldTempdir }()
+
+	target := filepath.Join(dir, "target")
+	if err = os.Mkdir(target, 0700); err != nil {
+		t.Fatalf("could not create the target: %v", err)
+	}
+	fileBackend := &backends.FileBackend{}
+	if err = fileBackend.Init(context.Background(), &backends.BackendConfig{TargetURI: "file://" + target, MaxParallelUploadBuffer: make(chan bool, 1)}); err != nil {
+		t.Fatalf("could not initialize the backend: %v", err)
+	}
+	mover := &unsupportedMover{Backend: fileBackend}
+
+	testCases := []struct {
+		backend backends.Backend
+	}{
+		{backend: fileBackend},
+		{backend: copyingBackend{fileBackend}},
+		{backend: mover},
+	}
+
+	for idx, c := range testCases {
+		from, to := fmt.Sprintf("object%d", idx), trashObjectName(fmt.Sprintf("object%d", idx), time.Now())
+		if err = ioutil.WriteFile(filepath.Join(target, from), []byte(from), 0600); err != nil {
+			t.Fatalf("%d: could not store %s: %v", idx, from, err)
+		}
+		if err = moveObject(context.Background(), c.backend, from, to); err != nil {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if _, err = os.Stat(filepath.Join(target, from)); !os.IsNotExist(err) {
+			t.Errorf("%d: expected %s to be deleted, got %v", idx, from, err)
+		}
+		if content, rerr := ioutil.ReadFile(filepath.Join(target, to)); rerr != nil || string(content) != from {
+			t.Errorf("%d: expected %s to be moved to %s, got %q (%v)", idx, from, to, content, rerr)
+		}
+	}
+	if mover.moves != 1 {
+		t.Errorf("expected the backend to be asked to move the object once, got %d", mover.moves)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Verify will download every volume of the backup set described by jobInfo from the first destination
+// provided and check it against the digest recorded in its manifest. Nothing is restored and every
+// downloaded volume is removed from the local disk once it has been checked. Unless JSON output was
+// requested, the result of every volume is output as a table.
+func Verify(pctx context.Context, jobInfo *helpers.JobInfo) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	target := jobInfo.Destinations[0]
+
+	// Prepare the backend client
+	backend, berr := prepareBackend(ctx, jobInfo, target, nil)
+	if berr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not initialize backend for target %s due to error - %v.", target, berr)
+		return berr
+	}
+	defer backend.Close()
+
+	// Get the local cache dir
+	localCachePath, cerr := getCacheDir(ctx, target)
+	if cerr != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Could not get cache dir for target %s due to error - %v.", target, cerr)
+		return cerr
+	}
+
+	manifest, err := loadManifest(ctx, backend, localCachePath, jobInfo)
+	if err != nil {
+		return err
+	}
+	if manifest.External != "" {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("The backup set of %s was adopted from %s and has no volumes to verify.", manifest.BaseSnapshot.Name, manifest.External)
+		return fmt.Errorf("backup set adopted from %s has no volumes", manifest.External)
+	}
+	jobInfo.Manifests = append(jobInfo.Manifests, helpers.ManifestObjectName(manifest))
+
+	jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobPlanned, Bytes: manifest.TotalBytesWritten()})
+
+	// The parity volumes are checked along with the volumes they protect
+	checked := append(append([]*helpers.VolumeInfo{}, manifest.Volumes...), manifest.Parity...)
+	toDownload := make([]string, len(checked))
+	for idx := range checked {
+		toDownload[idx] = checked[idx].ObjectName
+	}
+
+	if err = backend.PreDownload(ctx, toDownload); err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("Error trying to pre download backup set volumes - %v", err)
+		return err
+	}
+
+	jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobStarted})
+
+	fileBufferSize := jobInfo.MaxFileBuffer
+	if fileBufferSize <= 0 {
+		fileBufferSize = 1
+	}
+
+	volumes := make(chan *helpers.VolumeInfo, len(checked))
+	for _, vol := range checked {
+		volumes <- vol
+	}
+	close(volumes)
+
+	var (
+		group     *errgroup.Group
+		resultsMu sync.Mutex
+		results   = make(map[*helpers.VolumeInfo]error, len(checked))
+	)
+	group, ctx = errgroup.WithContext(ctx)
+
+	for i := 0; i < fileBufferSize; i++ {
+		group.Go(func() error {
+			for vol := range volumes {
+				be := backoff.NewExponentialBackOff()
+				be.MaxInterval = jobInfo.MaxBackoffTime
+				be.MaxElapsedTime = jobInfo.MaxRetryTime
+				retryconf := backoff.WithContext(be, ctx)
+
+				c := make(chan *helpers.VolumeInfo, 1)
+				sequence := downloadSequence{vol, c, manifest}
+				var damaged error
+				operation := func() error {
+					oerr := processSequence(ctx, sequence, backend, false)
+					if oerr != nil {
+						helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: target, Volume: vol.ObjectName, Err: oerr}.Warningf(ctx, "error trying to verify file %s - %v", vol.ObjectName, oerr)
+						// A volume that can be rebuilt from its parity group is reported as such instead of failing
+						if damaged == nil && !vol.IsParity && len(manifest.Parity) > 0 {
+							damaged = oerr
+							rebuilt, rerr := reconstructVolume(ctx, backend, manifest, vol)
+							if rerr == nil {
+								c <- rebuilt
+								return nil
+							}
+							helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: target, Volume: vol.ObjectName, Err: rerr}.Warningf(ctx, "Could not rebuild volume %s from its parity group - %v", vol.ObjectName, rerr)
+						}
+					}
+					return oerr
+				}
+
+				helpers.EnvironmentOf(ctx).Logger.Debugf("Verifying volume %s.", vol.ObjectName)
+				if err := backoff.RetryNotify(operation, retryconf, retryNotifier(ctx, jobInfo, vol, target)); err != nil {
+					helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: target, Volume: vol.ObjectName, Err: err}.Errorf(ctx, "Failed to verify volume %s due to error: %v, aborting...", vol.ObjectName, err)
+					// Volumes whose verification was cut short by another failing are left unverified
+					if ctx.Err() == nil {
+						resultsMu.Lock()
+						results[vol] = err
+						resultsMu.Unlock()
+					}
+					return helpers.NewError(helpers.ErrorKindBackend, err)
+				}
+				resultsMu.Lock()
+				results[vol] = nil
+				if damaged != nil {
+					helpers.LogFields{Dataset: jobInfo.VolumeName, Destination: target, Volume: vol.ObjectName, Err: damaged}.Warningf(ctx, "Volume %s is damaged but was rebuilt from its parity group, use the reupload command to repair it - %v", vol.ObjectName, damaged)
+					results[vol] = &parityRebuiltError{damaged}
+				}
+				resultsMu.Unlock()
+
+				downloaded := <-c
+				if err := downloaded.DeleteVolume(); err != nil {
+					helpers.EnvironmentOf(ctx).Logger.Warningf("Could not delete downloaded volume %s - %v", vol.ObjectName, err)
+				}
+
+				jobInfo.ReportProgress(helpers.ProgressEvent{
+					Type:         helpers.ProgressVolumeVerified,
+					ObjectName:   vol.ObjectName,
+					Destination:  target,
+					VolumeNumber: vol.VolumeNumber,
+					Bytes:        vol.Size,
+				})
+			}
+			return nil
+		})
+	}
+
+	err = group.Wait()
+	if !helpers.EnvironmentOf(ctx).JSONOutput {
+		printVerifyResults(ctx, checked, results)
+	}
+	if err != nil {
+		helpers.EnvironmentOf(ctx).Logger.Errorf("There was an error during the verify process, aborting: %v", err)
+		return err
+	}
+
+	jobInfo.ReportProgress(helpers.ProgressEvent{Type: helpers.ProgressJobFinished, Bytes: manifest.TotalBytesWritten()})
+	helpers.EnvironmentOf(ctx).Logger.Noticef("Verified %d volumes. Elapsed Time: %v", len(checked), time.Since(jobInfo.StartTime))
+	return nil
+}
+
+// parityRebuiltError records that a volume was damaged but could be rebuilt from its parity group.
+type parityRebuiltError struct {
+	err error
+}
+
+func (p *parityRebuiltError) Error() string {
+	return fmt.Sprintf("rebuilt from parity - %v", p.err)
+}
+
+// printVerifyResults will output whether every volume provided was verified, failed verification, or
+// was not verified at all, as recorded in results.
+func printVerifyResults(ctx context.Context, volumes []*helpers.VolumeInfo, results map[*helpers.VolumeInfo]error) {
+	table := helpers.NewTable("VOLUME", "SIZE", "RESULT")
+	for _, vol := range volumes {
+		result := helpers.Colorize(helpers.ColorYellow, "not verified")
+		err, ok := results[vol]
+		if rebuilt, isRebuilt := err.(*parityRebuiltError); isRebuilt {
+			result = helpers.Colorize(helpers.ColorYellow, fmt.Sprintf("damaged, rebuilt from parity: %v", rebuilt.err))
+		} else if ok && err == nil {
+			result = helpers.Colorize(helpers.ColorGreen, "ok")
+		} else if ok {
+			result = helpers.Colorize(helpers.ColorRed, fmt.Sprintf("failed: %v", err))
+		}
+		table.Row(vol.ObjectName, humanize.IBytes(vol.Size), result)
+	}
+	table.WriteTo(helpers.EnvironmentOf(ctx).Stdout)
+}
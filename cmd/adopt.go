@@ -0,0 +1,130 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	adoptFrom    string
+	adoptFromJob string
+)
+
+// adoptCmd represents the adopt command
+var adoptCmd = &cobra.Command{
+	Use:   "adopt [flags] volume[@snapshot] uri(s)",
+	Short: "adopt will take over the off-site backups of a volume from zrepl, syncoid, or znapzend without sending a full backup first.",
+	Long: `adopt will take over the off-site backups of a volume from zrepl, syncoid, or znapzend without sending a full backup first.
+
+The snapshot the replication tool provided with --from last sent off-site is found from its naming,
+hold, and bookmark conventions: the replication cursor bookmarks (or the last received holds on the
+receiving side) of zrepl, the sync snapshots of syncoid, and the snapshots znapzend marks as synced to
+a destination. Only a manifest is uploaded to the destinations, registering the snapshot as a full
+backup set, so send --increment chains incremental backups onto it right away. The snapshot must still
+exist when the first incremental backup is sent, and restoring the incremental backups requires
+receiving it from the copy kept by the replication tool first. A snapshot can be provided to skip the
+lookup. The destinations must not hold any backup set of the volume yet.`,
+	PreRunE: validateAdoptFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := runJob("adopt", func(ctx context.Context) error {
+			return backup.Adopt(ctx, &jobInfo, adoptFrom, adoptFromJob)
+		}); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(helpers.Stdout, "Adopted %s@%s from %s.\n", jobInfo.VolumeName, jobInfo.BaseSnapshot.Name, adoptFrom)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(adoptCmd)
+
+	adoptCmd.Flags().StringVar(&adoptFrom, "from", "", fmt.Sprintf("the replication tool sending the volume off-site until now, one of %s.", strings.Join(backup.AdoptTools, ", ")))
+	adoptCmd.Flags().StringVar(&adoptFromJob, "fromJob", "", "the zrepl job, syncoid identifier and host (as found in the name of its sync snapshots), or znapzend destination (e.g. a for dst_a) to take over from. Required when the replication tool sends the volume for more than one.")
+	adoptCmd.Flags().StringSliceVar(&sendTags, "tag", nil, "a key=value pair to tag the adopted backup set with, stored in its manifest and shown by list and search. Can be repeated.")
+	adoptCmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed upload. Use 0 for no limit.")
+	adoptCmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an upload.")
+	adoptCmd.Flags().StringVar(&jobInfo.Separator, "separator", "|", "the separator to use between object component names.")
+}
+
+// ResetAdoptJobInfo exists solely for integration testing
+func ResetAdoptJobInfo() {
+	resetRootFlags()
+	adoptFrom = ""
+	adoptFromJob = ""
+	sendTags = nil
+	jobInfo.Tags = nil
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{}
+	jobInfo.IncrementalSnapshot = helpers.SnapshotInfo{}
+	jobInfo.External = ""
+	jobInfo.MaxRetryTime = 12 * time.Hour
+	jobInfo.MaxBackoffTime = 30 * time.Minute
+	jobInfo.Separator = "|"
+}
+
+func validateAdoptFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	supported := false
+	for _, tool := range backup.AdoptTools {
+		supported = supported || tool == adoptFrom
+	}
+	if !supported {
+		helpers.AppLogger.Errorf("Invalid replication tool provided with --from, expected one of %s, got %s instead", strings.Join(backup.AdoptTools, ", "), adoptFrom)
+		return errInvalidInput
+	}
+
+	parts := strings.Split(args[0], "@")
+	if len(parts) > 2 || parts[0] == "" || len(parts) == 2 && parts[1] == "" {
+		helpers.AppLogger.Errorf("Invalid volume provided. Expected format <volume>[@<snapshot>], got %s instead", args[0])
+		return errInvalidInput
+	}
+
+	tags, terr := parseTags(sendTags, false)
+	if terr != nil {
+		helpers.AppLogger.Errorf("%v", terr)
+		return errInvalidInput
+	}
+
+	jobInfo.StartTime = time.Now()
+	jobInfo.Version = helpers.VersionNumber
+	jobInfo.Tags = tags
+	jobInfo.VolumeName = parts[0]
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{}
+	if len(parts) == 2 {
+		jobInfo.BaseSnapshot.Name = parts[1]
+	}
+	jobInfo.Destinations = strings.Split(args[1], ",")
+
+	return validateDestinationURIs(jobInfo.Destinations)
+}
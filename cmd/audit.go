@@ -0,0 +1,185 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/audit"
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var auditLog string
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "List the operations recorded in the audit log.",
+	Long: `List the send, receive, verify, reupload, and clean operations recorded in the audit log
+given by --auditLog, after checking that none of its entries were modified or removed.`,
+	PreRunE: validateAuditFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, _, err := readAuditLog()
+		if err != nil {
+			return err
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(entries)
+		}
+
+		w := tabwriter.NewWriter(helpers.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SEQ\tTIME\tUSER\tOPERATION\tSNAPSHOT\tDESTINATIONS\tRESULT")
+		for _, entry := range entries {
+			snapshot := entry.VolumeName
+			if entry.Snapshot != "" {
+				snapshot = fmt.Sprintf("%s@%s", entry.VolumeName, entry.Snapshot)
+			}
+			result := entry.Result
+			if entry.Error != "" {
+				result = fmt.Sprintf("%s (%s)", result, entry.Error)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s@%s\t%s\t%s\t%s\t%s\n", entry.Seq, entry.Time.Local().Format(time.RFC3339), entry.User, entry.Host, entry.Operation, snapshot, strings.Join(entry.Destinations, ","), result)
+		}
+		return w.Flush()
+	},
+}
+
+// auditVerifyCmd represents the audit verify command
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that no entry of the audit log was modified, removed, or reordered.",
+	Long: `Check that no entry of the audit log was modified, removed, or reordered and print the hash
+of its last entry. Keep that hash somewhere else to also be able to tell if the last entries were removed.`,
+	PreRunE: validateAuditFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, hashes, err := readAuditLog()
+		if err != nil {
+			return err
+		}
+
+		var head string
+		if len(hashes) > 0 {
+			head = hashes[len(hashes)-1]
+		}
+		if helpers.JSONOutput {
+			return printJSON(struct {
+				Entries int
+				Head    string
+			}{len(entries), head})
+		}
+		fmt.Fprintf(helpers.Stdout, "The audit log is intact with %d entries, the hash of the last entry is %s\n", len(entries), head)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditVerifyCmd)
+
+	RootCmd.PersistentFlags().StringVar(&auditLog, "auditLog", "", "the path to a hash-chained log to record every send, receive, verify, reupload, and clean operation in, see the audit command.")
+}
+
+func resetAuditFlags() {
+	auditLog = ""
+}
+
+func validateAuditFlags(cmd *cobra.Command, args []string) error {
+	if auditLog == "" {
+		helpers.AppLogger.Errorf("You must specify the audit log to read with --auditLog")
+		return errInvalidInput
+	}
+	return nil
+}
+
+func readAuditLog() ([]audit.Entry, []string, error) {
+	entries, hashes, err := audit.Read(auditLog)
+	if err != nil {
+		if _, ok := err.(*audit.ChainError); ok {
+			helpers.AppLogger.Errorf("The audit log %s was tampered with - %v", auditLog, err)
+			return nil, nil, helpers.NewError(helpers.ErrorKindVerification, err)
+		}
+		helpers.AppLogger.Errorf("Could not read the audit log %s due to error - %v", auditLog, err)
+		return nil, nil, err
+	}
+	return entries, hashes, nil
+}
+
+// recordAudit will append the operation just run with the options of j and its outcome to the
+// audit log, if one was requested. Failing to do so is only logged.
+func recordAudit(operation string, j *helpers.JobInfo, err error) {
+	if auditLog == "" {
+		return
+	}
+
+	entry := audit.Entry{
+		Time:                time.Now().UTC(),
+		User:                auditUser(),
+		PID:                 os.Getpid(),
+		Operation:           operation,
+		VolumeName:          j.VolumeName,
+		Snapshot:            j.BaseSnapshot.Name,
+		IncrementalSnapshot: j.IncrementalSnapshot.Name,
+		Manifests:           j.Manifests,
+		Result:              "success",
+	}
+	entry.Host, _ = os.Hostname()
+	for _, destination := range j.Destinations {
+		// The send pipeline adds the delete backend to clean up the volumes once uploaded
+		if destination != backends.DeleteBackendPrefix+"://" {
+			entry.Destinations = append(entry.Destinations, destination)
+		}
+	}
+	switch {
+	case err == errInterrupted:
+		entry.Result = "interrupted"
+	case err != nil:
+		entry.Result = "failure"
+		entry.Error = err.Error()
+	}
+
+	if _, _, aerr := audit.Append(auditLog, entry); aerr != nil {
+		helpers.AppLogger.Warningf("Could not record the %s operation in the audit log %s - %v", operation, auditLog, aerr)
+	}
+}
+
+// auditUser returns the user running the operation, the one who invoked sudo if run through it.
+func auditUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	// There may not be an entry for us in the user database of a container
+	if containerMode {
+		return fmt.Sprintf("uid %d", os.Getuid())
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return fmt.Sprintf("uid %d", os.Getuid())
+}
@@ -0,0 +1,127 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// catCmd represents the cat command
+var catCmd = &cobra.Command{
+	Use:   "cat [flags] snapshot uri",
+	Short: "cat will write the original zfs send stream of a backup set to stdout.",
+	Long: `cat will write the original zfs send stream of a backup set to stdout.
+
+Every volume is downloaded, checked against the hash recorded in the manifest, decrypted,
+and decompressed before being written, in order, to stdout so the stream can be piped into
+"zfs receive" on another host, inspected with zstreamdump, or archived elsewhere.`,
+	PreRunE: validateCatFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		archive, err := backup.OpenArchive(ctx, &jobInfo)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+
+		manifest, err := archive.Manifest(ctx, &jobInfo)
+		if err != nil {
+			return err
+		}
+
+		if err = archive.ExtractStream(ctx, manifest, os.Stdout); err != nil {
+			return err
+		}
+
+		helpers.AppLogger.Noticef("Wrote %d volumes to stdout. Elapsed Time: %v", len(manifest.Volumes), time.Since(jobInfo.StartTime))
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(catCmd)
+
+	catCmd.Flags().StringVarP(&jobInfo.IncrementalSnapshot.Name, "incremental", "i", "", "Used to specify the incremental snapshot the backup set was taken from.")
+	catCmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed download. Use 0 for no limit.")
+	catCmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an download.")
+	catCmd.Flags().StringVar(&jobInfo.Separator, "separator", "|", "the separator to use between object component names (used only for the initial manifest we are looking for).")
+}
+
+// ResetCatJobInfo exists solely for integration testing
+func ResetCatJobInfo() {
+	resetRootFlags()
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{}
+	jobInfo.IncrementalSnapshot = helpers.SnapshotInfo{}
+	jobInfo.MaxRetryTime = 12 * time.Hour
+	jobInfo.MaxBackoffTime = 30 * time.Minute
+	jobInfo.Separator = "|"
+}
+
+func validateCatFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	if terminal.IsTerminal(int(os.Stdout.Fd())) {
+		helpers.AppLogger.Errorf("Refusing to write a zfs send stream to a terminal, redirect stdout to a file or pipe it to another command")
+		return fmt.Errorf("stdout is a terminal")
+	}
+	jobInfo.StartTime = time.Now()
+
+	parts := strings.Split(args[0], "@")
+	if len(parts) != 2 {
+		helpers.AppLogger.Errorf("Invalid snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
+		return errInvalidInput
+	}
+	jobInfo.VolumeName = parts[0]
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+	jobInfo.Destinations = []string{args[1]}
+
+	if jobInfo.IncrementalSnapshot.Name != "" {
+		jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, jobInfo.VolumeName)
+		jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, "@")
+	}
+
+	_, err := backends.GetBackendForURI(args[1])
+	if err == backends.ErrInvalidPrefix {
+		helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", args[1])
+		return errInvalidInput
+	} else if err == backends.ErrInvalidURI {
+		helpers.AppLogger.Errorf("Invalid destination URI, was given %s", args[1])
+		return errInvalidInput
+	}
+
+	return nil
+}
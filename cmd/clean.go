@@ -22,24 +22,61 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	humanize "github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
 
 	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
 )
 
-var cleanLocal bool
+var (
+	cleanLocal    bool
+	cleanReport   bool
+	cleanTrashFor string
+)
 
 // cleanCmd represents the clean command
 var cleanCmd = &cobra.Command{
-	Use:           "clean [flags] uri",
-	Short:         "Clean will delete any objects in the target that are not found in the manifest files found in the target.",
-	Long:          `Clean will delete any objects in the target that are not found in the manifest files found in the target.`,
+	Use:   "clean [flags] uri...",
+	Short: "Clean will delete any objects in the target that are not found in the manifest files found in the target.",
+	Long: `Clean will delete any objects in the target that are not found in the manifest files found in the target.
+
+Several targets are cleaned one after the other. Use --dryRun to review what would be
+deleted before running it: the backup sets and objects deleted from every target, the space
+reclaimed, and the restore points left there. --report prints the same report of what a
+clean deleted.
+
+With --trashFor, the objects are moved to the trash of the target instead, from where the
+undelete command can bring them back until they expire. Every clean deletes the objects of
+the trash that expired.`,
 	SilenceErrors: true,
 	PreRunE:       validateCleanFlags,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		jobInfo.Destinations = []string{args[0]}
-		return backup.Clean(context.Background(), &jobInfo, cleanLocal)
+		plans := make([]*backup.CleanPlan, 0, len(args))
+		for _, destination := range args {
+			jobInfo.Destinations = []string{destination}
+			jobInfo.Manifests = nil
+			var plan *backup.CleanPlan
+			var err error
+			if dryRun {
+				plan, err = backup.PlanClean(context.Background(), &jobInfo, cleanLocal)
+			} else {
+				plan, err = backup.Clean(context.Background(), &jobInfo, cleanLocal)
+				recordAudit("clean", &jobInfo, err)
+			}
+			if err != nil {
+				return err
+			}
+			plans = append(plans, plan)
+		}
+
+		if !dryRun && !cleanReport {
+			return nil
+		}
+		return printCleanPlans(plans)
 	},
 }
 
@@ -47,13 +84,117 @@ func init() {
 	RootCmd.AddCommand(cleanCmd)
 
 	cleanCmd.Flags().BoolVarP(&cleanLocal, "cleanLocal", "", false, "Delete any files found in the local cache that shouldn't be there.")
-	cleanCmd.Flags().BoolVarP(&jobInfo.Force, "force", "", false, "This will force the deletion of broken backup sets (sets where volumes expected in the manifest file are not found). Use with caution.")
+	cleanCmd.Flags().BoolVarP(&jobInfo.Force, "force", "", false, "This will force the deletion of broken backup sets (sets where volumes expected in the manifest file are not found), unless tagged keep=forever or other backup sets still depend on them. Use with caution.")
+	cleanCmd.Flags().BoolVarP(&jobInfo.ForceBreakChain, "forceBreakChain", "", false, "used with --force, also delete the broken backup sets that other backup sets are chained to, printing the restore points this leaves unrestorable. Use with extreme caution.")
+	cleanCmd.Flags().StringVarP(&jobInfo.Inventory, "inventory", "", "", "the path or URI (e.g. s3://inventory-bucket/path/manifest.json) of the manifest of an S3 Inventory or GCS Storage Insights report of the target's bucket to read its objects from instead of listing them. Objects written since the report was taken are never deleted.")
+	cleanCmd.Flags().BoolVar(&dryRun, "dryRun", false, "print the backup sets and objects that would be deleted from every target, the space reclaimed, and the restore points left there without deleting anything, remotely or in the local cache.")
+	cleanCmd.Flags().StringVar(&cleanTrashFor, "trashFor", "", "move the objects to delete to the trash/ prefix of the target, where they are kept for this long (e.g. 7d, 2w, or 36h) and can be brought back with undelete, instead of deleting them right away. Every clean deletes the objects of the trash that expired. They are still stored, and billed, until then.")
+	cleanCmd.Flags().BoolVar(&cleanReport, "report", false, "print the backup sets and objects deleted from every target, the space reclaimed, and the restore points left there once cleaned.")
+}
+
+// ResetCleanJobInfo exists solely for integration testing
+func ResetCleanJobInfo() {
+	resetRootFlags()
+	cleanLocal = false
+	cleanReport = false
+	cleanTrashFor = ""
+	jobInfo.TrashFor = 0
+	dryRun = false
+	jobInfo.Force = false
+	jobInfo.ForceBreakChain = false
+	jobInfo.Inventory = ""
 }
 
 func validateCleanFlags(cmd *cobra.Command, args []string) error {
-	if len(args) != 1 {
+	if len(args) == 0 {
 		cmd.Usage()
 		return errInvalidInput
 	}
+
+	if jobInfo.ForceBreakChain && !jobInfo.Force {
+		helpers.AppLogger.Errorf("The forceBreakChain flag requires the force flag.")
+		return errInvalidInput
+	}
+
+	jobInfo.TrashFor = 0
+	if cleanTrashFor != "" {
+		d, err := helpers.ParseDays(cleanTrashFor)
+		if err != nil || d <= 0 {
+			helpers.AppLogger.Errorf("Invalid trashFor %s, expected a positive duration such as 7d or 36h.", cleanTrashFor)
+			return errInvalidInput
+		}
+		jobInfo.TrashFor = d
+	}
+
+	return validateDestinationURIs(args)
+}
+
+// printCleanPlans will print what cleaning every target deletes and the restore points left there.
+func printCleanPlans(plans []*backup.CleanPlan) error {
+	if helpers.JSONOutput {
+		return printJSON(plans)
+	}
+
+	for idx, plan := range plans {
+		if idx > 0 {
+			fmt.Fprintln(helpers.Stdout)
+		}
+		if dryRun {
+			fmt.Fprintf(helpers.Stdout, "Dry run of a clean of %s, nothing will be deleted.\n", plan.Destination)
+		} else {
+			fmt.Fprintf(helpers.Stdout, "Cleaned %s.\n", plan.Destination)
+		}
+
+		fmt.Fprintf(helpers.Stdout, "\nBackup sets deleted: %d\n", len(plan.BackupSets))
+		for _, set := range plan.BackupSets {
+			fmt.Fprintf(helpers.Stdout, "  %s@%s", set.Volume, set.Snapshot)
+			if set.Incremental != "" {
+				fmt.Fprintf(helpers.Stdout, " (incremental from %s)", set.Incremental)
+			}
+			fmt.Fprintf(helpers.Stdout, ": %s\n", set.Reason)
+		}
+
+		reclaimed := humanize.IBytes(plan.Bytes)
+		if plan.UnknownSizeObjects > 0 {
+			reclaimed += fmt.Sprintf(" plus %d objects of unknown size", plan.UnknownSizeObjects)
+		}
+		if plan.TrashedUntil.IsZero() {
+			fmt.Fprintf(helpers.Stdout, "\nObjects deleted: %d, reclaiming %s\n", len(plan.Objects), reclaimed)
+		} else {
+			fmt.Fprintf(helpers.Stdout, "\nObjects moved to the trash: %d, reclaiming %s once they expire at %s\n", len(plan.Objects), reclaimed, plan.TrashedUntil.Local().Format(time.RFC3339))
+		}
+		for _, object := range plan.Objects {
+			fmt.Fprintf(helpers.Stdout, "  %s\n", object)
+		}
+
+		if len(plan.Purged) > 0 {
+			fmt.Fprintf(helpers.Stdout, "\nExpired objects deleted from the trash: %d\n", len(plan.Purged))
+			for _, object := range plan.Purged {
+				fmt.Fprintf(helpers.Stdout, "  %s\n", object)
+			}
+		}
+
+		fmt.Fprintf(helpers.Stdout, "\nRestore points left: %d\n", len(plan.RestorePoints))
+		if len(plan.RestorePoints) == 0 {
+			continue
+		}
+		table := helpers.NewTable("NAME", "CREATED", "TYPE", "DEPTH", "DOWNLOAD")
+		for _, point := range plan.RestorePoints {
+			kind := "incremental"
+			if point.Full {
+				kind = "full"
+			}
+			table.Row(
+				fmt.Sprintf("%s@%s", point.Volume, point.Snapshot.Name),
+				point.Snapshot.CreationTime.Local().Format(time.RFC3339),
+				kind,
+				fmt.Sprintf("%d", point.Depth),
+				humanize.IBytes(point.StoredBytes),
+			)
+		}
+		if _, err := table.WriteTo(helpers.Stdout); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,50 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var codecDefinitions []string
+
+func init() {
+	RootCmd.PersistentFlags().StringArrayVar(&codecDefinitions, "codec", nil, "define a compressor running external binaries, as name=<compress command>;<decompress command> with "+helpers.CodecLevel+" replaced by the compression level, e.g. zstd=zstd -c -T0 -"+helpers.CodecLevel+";zstd -d -c. Send with --compressor name, the name is recorded in the manifest and the volumes are decompressed with the codec of that name on restore, so define it there too. Can be given more than once.")
+}
+
+func resetCodecFlags() {
+	codecDefinitions = nil
+}
+
+// registerCodecs will register the codecs defined with --codec.
+func registerCodecs() error {
+	for _, definition := range codecDefinitions {
+		name, codec, err := helpers.ParseExecCodec(definition)
+		if err != nil {
+			return err
+		}
+		if err = helpers.RegisterCodec(name, codec); err != nil {
+			return err
+		}
+		helpers.AppLogger.Debugf("Registered the codec %s compressing with %v and decompressing with %v.", name, codec.CompressCommand, codec.DecompressCommand)
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Sections of the config file that do not hold flag values directly.
+const (
+	datasetsSection     = "datasets"
+	destinationsSection = "destinations"
+	jobsSection         = "jobs"
+	profilesSection     = "profiles"
+)
+
+// Prefix of the environmental variables that may be used in place of any flag.
+const envPrefix = "ZFSBACKUP_"
+
+// Destination URIs contain dots, so nested keys are delimited with something they do not contain.
+const configKeyDelimiter = "::"
+
+var (
+	configFile     string
+	profile        string
+	configFileUsed string
+	// The job being executed by the run command, if any
+	namedJob *configSection
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&configFile, "config", "", "the path to a YAML or TOML config file providing values for any flag not provided on the command line or by its ZFSBACKUP_* environmental variable. If not provided, a config.yaml or config.toml file is looked for in /etc/zfsbackup and ~/.zfsbackup.")
+	RootCmd.PersistentFlags().StringVar(&profile, "profile", "", "the name of a profile in the config file to apply.")
+}
+
+func resetConfigFlags() {
+	configFile = ""
+	profile = ""
+	configFileUsed = ""
+	namedJob = nil
+}
+
+// configSection is a set of flag values read from the config file.
+type configSection struct {
+	name   string
+	values map[string]interface{}
+}
+
+// applyConfig will set every flag of the command that was not provided on the command line
+// from its environmental variable or, failing that, from the config file. Values from the
+// config file are applied from the least to the most specific section: the top level, the
+// command's section (e.g. send), the section of every destination and then the dataset the
+// command was given, the job being run, and finally the profile requested.
+func applyConfig(cmd *cobra.Command, args []string) error {
+	flags := make(map[string]*pflag.Flag)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		flags[strings.ToLower(f.Name)] = f
+	})
+
+	// The config file and profile may themselves come from the environment
+	if err := applyEnv(flags["config"], flags["profile"]); err != nil {
+		return err
+	}
+
+	settings, err := readConfig()
+	if err != nil {
+		return err
+	}
+	if settings == nil && profile != "" {
+		return fmt.Errorf("the profile %s was requested but no config file was found", profile)
+	}
+
+	if settings != nil {
+		sections, serr := configSections(cmd, args, settings)
+		if serr != nil {
+			return serr
+		}
+		if err = applySections(flags, knownFlags(cmd.Root()), sections); err != nil {
+			return err
+		}
+	}
+
+	envFlags := make([]*pflag.Flag, 0, len(flags))
+	for _, f := range flags {
+		envFlags = append(envFlags, f)
+	}
+	return applyEnv(envFlags...)
+}
+
+func applySections(flags map[string]*pflag.Flag, known map[string]bool, sections []configSection) error {
+	for _, section := range sections {
+		keys := make([]string, 0, len(section.values))
+		for key := range section.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			f, ok := flags[key]
+			if !ok {
+				if !known[key] {
+					return fmt.Errorf("unknown option %s in the %s section of the config file", key, section.name)
+				}
+				// An option for another command
+				continue
+			}
+			if f.Changed {
+				continue
+			}
+			if err := setFlag(f, section.values[key]); err != nil {
+				return fmt.Errorf("invalid value for %s in the %s section of the config file - %v", key, section.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyEnv will set the flags not provided on the command line from their environmental variable, if set.
+func applyEnv(flags ...*pflag.Flag) error {
+	for _, f := range flags {
+		if f == nil || f.Changed || f.Name == "help" {
+			continue
+		}
+		name := envName(f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setFlag(f, value); err != nil {
+				return fmt.Errorf("invalid value for %s in the %s environmental variable - %v", f.Name, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envName will return the environmental variable for a flag, e.g. ZFSBACKUP_MAX_RETRY_TIME for maxRetryTime.
+func envName(flag string) string {
+	var name []rune
+	runes := []rune(flag)
+	for idx, r := range runes {
+		if idx > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[idx-1]) || unicode.IsDigit(runes[idx-1])) {
+			name = append(name, '_')
+		}
+		name = append(name, unicode.ToUpper(r))
+	}
+	return envPrefix + string(name)
+}
+
+func readConfig() (map[string]interface{}, error) {
+	v := viper.NewWithOptions(viper.KeyDelimiter(configKeyDelimiter))
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+	} else {
+		v.SetConfigName("config")
+		v.AddConfigPath("/etc/zfsbackup")
+		v.AddConfigPath(filepath.Join("$HOME", ".zfsbackup"))
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok && configFile == "" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read config file - %v", err)
+	}
+	configFileUsed = v.ConfigFileUsed()
+	return v.AllSettings(), nil
+}
+
+func configSections(cmd *cobra.Command, args []string, settings map[string]interface{}) ([]configSection, error) {
+	commands := make(map[string]bool)
+	for _, c := range cmd.Root().Commands() {
+		commands[c.Name()] = true
+	}
+
+	top := configSection{name: "top level", values: make(map[string]interface{})}
+	for key, value := range settings {
+		if key == datasetsSection || key == destinationsSection || key == jobsSection || key == profilesSection || commands[key] {
+			continue
+		}
+		top.values[key] = value
+	}
+	sections := []configSection{top}
+
+	if values, ok := settings[cmd.Name()]; ok {
+		section, err := configMap(cmd.Name(), values)
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, section)
+	}
+
+	dataset, destinations := jobTargets(args)
+	for _, destination := range destinations {
+		if section, ok, err := namedSection(settings, destinationsSection, destination); err != nil {
+			return nil, err
+		} else if ok {
+			sections = append(sections, section)
+		}
+	}
+
+	if dataset != "" {
+		if section, ok, err := namedSection(settings, datasetsSection, dataset); err != nil {
+			return nil, err
+		} else if ok {
+			sections = append(sections, section)
+		}
+	}
+
+	if namedJob != nil {
+		sections = append(sections, *namedJob)
+	}
+
+	if profile != "" {
+		section, ok, err := namedSection(settings, profilesSection, profile)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("could not find the profile %s in the config file", profile)
+		}
+		sections = append(sections, section)
+	}
+	return sections, nil
+}
+
+// checkConfigKeys will return an error for every option of the config file, outside of the
+// jobs section, that is not a flag of any command.
+func checkConfigKeys(root *cobra.Command, settings map[string]interface{}) []error {
+	known := knownFlags(root)
+	commands := make(map[string]bool)
+	for _, c := range root.Commands() {
+		commands[c.Name()] = true
+	}
+
+	var errs []error
+	checkSection := func(name string, values interface{}) {
+		section, err := configMap(name, values)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		for key := range section.values {
+			if !known[key] {
+				errs = append(errs, fmt.Errorf("unknown option %s in the %s section of the config file", key, section.name))
+			}
+		}
+	}
+
+	for key, value := range settings {
+		switch {
+		case key == jobsSection:
+			// Checked along with the job definitions
+		case key == datasetsSection || key == destinationsSection || key == profilesSection:
+			named, ok := value.(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("the %s section of the config file should be a map", key))
+				continue
+			}
+			for name, values := range named {
+				checkSection(fmt.Sprintf("%s %s", key, name), values)
+			}
+		case commands[key]:
+			checkSection(key, value)
+		case !known[key]:
+			errs = append(errs, fmt.Errorf("unknown option %s in the top level section of the config file", key))
+		}
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
+
+// jobTargets will find the dataset and destinations a command was given in its arguments.
+func jobTargets(args []string) (dataset string, destinations []string) {
+	for _, arg := range args {
+		if strings.Contains(arg, "://") {
+			destinations = append(destinations, strings.Split(arg, ",")...)
+		} else if dataset == "" {
+			dataset = strings.Split(arg, "@")[0]
+		}
+	}
+	return dataset, destinations
+}
+
+// namedSection will return the section found under parent with the given name, ignoring case.
+func namedSection(settings map[string]interface{}, parent, name string) (configSection, bool, error) {
+	sectionName := fmt.Sprintf("%s %s", parent, name)
+	values, ok := settings[parent]
+	if !ok {
+		return configSection{}, false, nil
+	}
+	named, ok := values.(map[string]interface{})
+	if !ok {
+		return configSection{}, false, fmt.Errorf("the %s section of the config file should be a map", parent)
+	}
+	values, ok = named[strings.ToLower(name)]
+	if !ok {
+		return configSection{}, false, nil
+	}
+	section, err := configMap(sectionName, values)
+	return section, err == nil, err
+}
+
+func configMap(name string, values interface{}) (configSection, error) {
+	m, ok := values.(map[string]interface{})
+	if !ok {
+		return configSection{}, fmt.Errorf("the %s section of the config file should be a map", name)
+	}
+	return configSection{name: name, values: m}, nil
+}
+
+// setFlag will set the flag to the value read from the config file or the environment. The values of a flag
+// holding a list replace those it held rather than being appended to them, so a later section or the
+// environment overrides an earlier section.
+func setFlag(f *pflag.Flag, value interface{}) error {
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		if list, ok := value.([]interface{}); ok {
+			values := make([]string, len(list))
+			for idx := range list {
+				values[idx] = fmt.Sprint(list[idx])
+			}
+			return slice.Replace(values)
+		}
+		if err := slice.Replace(nil); err != nil {
+			return err
+		}
+	}
+	return f.Value.Set(configValue(value))
+}
+
+// configValue will convert a value read from the config file to the form a flag expects.
+func configValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		parts := make([]string, len(list))
+		for idx := range list {
+			parts[idx] = fmt.Sprint(list[idx])
+		}
+		return strings.Join(parts, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// knownFlags will return the lower cased names of every flag of every command.
+func knownFlags(root *cobra.Command) map[string]bool {
+	known := make(map[string]bool)
+	var visit func(c *cobra.Command)
+	visit = func(c *cobra.Command) {
+		c.Flags().VisitAll(func(f *pflag.Flag) {
+			known[strings.ToLower(f.Name)] = true
+		})
+		c.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+			known[strings.ToLower(f.Name)] = true
+		})
+		for _, child := range c.Commands() {
+			visit(child)
+		}
+	}
+	visit(root)
+	return known
+}
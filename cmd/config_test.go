@@ -0,0 +1,61 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestSetFlag(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	slice := flags.StringSlice("slice", []string{"default"}, "")
+	array := flags.StringArray("array", nil, "")
+
+	// A later section replaces the values of an earlier one
+	sections := []configSection{
+		{name: "global", values: map[string]interface{}{"slice": []interface{}{"a", "b"}, "array": "x,y"}},
+		{name: "send", values: map[string]interface{}{"slice": "c", "array": []interface{}{"z,w"}}},
+	}
+	known := map[string]bool{"slice": true, "array": true}
+	if err := applySections(map[string]*pflag.Flag{"slice": flags.Lookup("slice"), "array": flags.Lookup("array")}, known, sections); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(*slice, []string{"c"}) {
+		t.Errorf("expected the send section to replace the slice, got %v", *slice)
+	}
+	if !reflect.DeepEqual(*array, []string{"z,w"}) {
+		t.Errorf("expected the send section to replace the array without splitting its values, got %v", *array)
+	}
+
+	// The environment replaces the config file
+	os.Setenv("ZFSBACKUP_SLICE", "d,e")
+	defer os.Unsetenv("ZFSBACKUP_SLICE")
+	if err := applyEnv(flags.Lookup("slice")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(*slice, []string{"d", "e"}) {
+		t.Errorf("expected the environment to replace the slice, got %v", *slice)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var keepScratch bool
+
+// consolidateCmd represents the consolidate command
+var consolidateCmd = &cobra.Command{
+	Use:   "consolidate [flags] snapshot scratch_volume uri(s)",
+	Short: "consolidate will collapse the chain of backup sets of a snapshot into a new full backup set without reading the volume it was taken from.",
+	Long: `consolidate will collapse the chain of backup sets of a snapshot into a new full backup set without reading the volume it was taken from.
+
+The full backup set and every incremental backup set up to the snapshot are downloaded from the first
+destination and received into the scratch volume, which must not exist, and a full backup of the
+received snapshot is sent to the destinations as a backup set of the original volume. The received
+snapshot keeps the guid and creation time of the original one, so the incremental backup sets taken
+after it chain onto the new full backup set and the backup sets it was consolidated from are no longer
+needed to restore them. The scratch volume is destroyed once done unless --keepScratch is set.`,
+	PreRunE: validateConsolidateFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runJob("send", func(ctx context.Context) error {
+			return backup.Consolidate(ctx, &jobInfo, args[1], keepScratch)
+		})
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(consolidateCmd)
+
+	consolidateCmd.Flags().BoolVar(&keepScratch, "keepScratch", false, "keep the scratch volume the backup sets were received into instead of destroying it once done.")
+	consolidateCmd.Flags().StringSliceVar(&sendTags, "tag", nil, "a key=value pair to tag the consolidated backup set with, stored in its manifest and shown by list and search. Can be repeated.")
+	consolidateCmd.Flags().StringVar(&minRetention, "minRetention", "", "keep the backup set for at least this long after it was sent: clean refuses to delete it from a destination before then, whatever the flags it is given. A duration such as 30d, 4w, or 12h for every destination, or a comma separated list of them by backend, e.g. s3=90d,gs=30d. Recorded in the manifest.")
+	addVolumeFlags(consolidateCmd)
+}
+
+// ResetConsolidateJobInfo exists solely for integration testing
+func ResetConsolidateJobInfo() {
+	ResetSendJobInfo()
+	keepScratch = false
+}
+
+func validateConsolidateFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	parts := strings.Split(args[0], "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		helpers.AppLogger.Errorf("Invalid snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
+		return errInvalidInput
+	}
+
+	if args[1] == "" || strings.Contains(args[1], "@") || args[1] == parts[0] {
+		helpers.AppLogger.Errorf("Invalid scratch volume provided, it must be a volume other than %s. Was given %s", parts[0], args[1])
+		return errInvalidInput
+	}
+
+	if err := jobInfo.ValidateSendFlags(context.Background()); err != nil {
+		helpers.AppLogger.Error(err)
+		return err
+	}
+
+	if err := checkStagingSpace(&jobInfo); err != nil {
+		return err
+	}
+
+	if err := fitMemory(&jobInfo); err != nil {
+		return err
+	}
+
+	tags, terr := parseTags(sendTags, false)
+	if terr != nil {
+		helpers.AppLogger.Errorf("%v", terr)
+		return errInvalidInput
+	}
+	retention, rerr := helpers.ParseMinRetention(minRetention)
+	if rerr != nil {
+		helpers.AppLogger.Errorf("%v", rerr)
+		return errInvalidInput
+	}
+	if err := parseObjectLabels(); err != nil {
+		return err
+	}
+
+	jobInfo.StartTime = time.Now()
+	jobInfo.Version = helpers.VersionNumber
+	jobInfo.Tags = tags
+	jobInfo.MinRetention = retention
+	jobInfo.VolumeName = parts[0]
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+	jobInfo.Destinations = strings.Split(args[2], ",")
+
+	return validateDestinationURIs(jobInfo.Destinations)
+}
@@ -0,0 +1,61 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	containerMode bool
+	secretsDir    string
+)
+
+func init() {
+	RootCmd.PersistentFlags().BoolVar(&containerMode, "container", false, "run in a container or jail: the --workingDirectory must be an absolute path as the home directory and user database are never looked up, secrets are read from the files in --secretsDir, passphrases are never prompted for, and the logs are written to stdout as JSON unless --logFormat or --logOutput is provided or the command writes its own output to stdout as JSON or a stream.")
+	RootCmd.PersistentFlags().StringVar(&secretsDir, "secretsDir", "/run/secrets", "the directory of the secrets mounted in --container mode, each file sets the environmental variable it is named after to its contents (e.g. PGP_PASSPHRASE or AWS_SECRET_ACCESS_KEY) unless it is already set.")
+}
+
+func resetContainerFlags() {
+	containerMode = false
+	secretsDir = "/run/secrets"
+}
+
+// applyContainerMode will check the paths given are explicit and load the secrets mounted, in --container mode.
+func applyContainerMode() error {
+	if !containerMode {
+		return nil
+	}
+
+	if !filepath.IsAbs(workingDirectory) {
+		return fmt.Errorf("the working directory must be provided as an absolute path with --workingDirectory in --container mode, was given %s", workingDirectory)
+	}
+
+	if dir, err := os.Stat(secretsDir); err == nil && dir.IsDir() {
+		loadCredentialsFrom(secretsDir)
+		if len(passphrase) == 0 {
+			passphrase = []byte(os.Getenv("PGP_PASSPHRASE"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/cost"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	storageClasses string
+	pricingPath    string
+)
+
+// costCmd represents the cost command
+var costCmd = &cobra.Command{
+	Use:   "cost [flags] uri(s)",
+	Short: "cost will estimate the monthly storage cost of every destination and the cost of a full restore from it.",
+	Long: `cost will estimate the monthly storage cost of every destination and the cost of a full restore from it.
+
+The size of every backup set found at a destination is priced with the storage class
+provided for its backend, or the backend's default storage class. The cost of a full
+restore includes the retrieval and transfer of every backup set needed to restore the
+latest snapshot of every volume. Default prices can be overridden with a JSON file,
+see the README for its format.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			cmd.Usage()
+			return errInvalidInput
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pricing, err := cost.LoadPricing(pricingPath)
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not load the pricing due to error - %v", err)
+			return err
+		}
+
+		classes, err := parseStorageClasses(storageClasses)
+		if err != nil {
+			helpers.AppLogger.Errorf("%v", err)
+			return errInvalidInput
+		}
+
+		var reports []*cost.Report
+		for _, destination := range strings.Split(args[0], ",") {
+			class, price, perr := pricing.Lookup(destination, classes[strings.ToLower(strings.SplitN(destination, "://", 2)[0])])
+			if perr != nil {
+				helpers.AppLogger.Errorf("Could not price %s - %v", destination, perr)
+				return perr
+			}
+
+			j := jobInfo
+			j.Destinations = []string{destination}
+			sets, lerr := backup.ListBackupSets(context.Background(), &j, "", time.Time{}, time.Time{})
+			if lerr != nil {
+				helpers.AppLogger.Errorf("Could not list the backup sets of %s due to error - %v", destination, lerr)
+				return lerr
+			}
+			reports = append(reports, cost.NewReport(destination, class, price, backup.LinkBackupSets(context.Background(), sets)))
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(reports)
+		}
+
+		var monthly, restore float64
+		w := tabwriter.NewWriter(helpers.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DESTINATION\tCLASS\tBACKUP SETS\tSTORED\tMONTHLY COST\tRESTORE SIZE\tRESTORE COST")
+		for _, report := range reports {
+			monthly += report.MonthlyStorage
+			restore += report.Restore
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t$%.2f\t%s\t$%.2f\n", report.Destination, report.StorageClass, report.BackupSets,
+				humanize.IBytes(report.Bytes), report.MonthlyStorage, humanize.IBytes(report.RestoreBytes), report.Restore)
+		}
+		if len(reports) > 1 {
+			fmt.Fprintf(w, "TOTAL\t\t\t\t$%.2f\t\t$%.2f\n", monthly, restore)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(costCmd)
+
+	costCmd.Flags().StringVar(&storageClasses, "storageClass", "", "a comma separated list of the storage class used by each backend, e.g. s3=glacier,gs=coldline. Backends not listed are priced with their default storage class.")
+	costCmd.Flags().StringVar(&pricingPath, "pricing", "", "the path to a JSON file overriding the default price of storage classes, see the README for its format.")
+}
+
+func resetCostFlags() {
+	storageClasses = ""
+	pricingPath = ""
+}
+
+// parseStorageClasses will parse a comma separated list of backend=class pairs.
+func parseStorageClasses(value string) (map[string]string, error) {
+	classes := make(map[string]string)
+	if value == "" {
+		return classes, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid storage class %s, expected the format backend=class", pair)
+		}
+		classes[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return classes, nil
+}
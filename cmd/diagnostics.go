@@ -0,0 +1,106 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime/trace"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	pprofAddr string
+	traceFile string
+
+	pprofServer *http.Server
+	traceOut    *os.File
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&pprofAddr, "pprofAddr", "", "the address (host:port) to serve the pprof CPU, heap, goroutine, and execution trace profiles of this process on under /debug/pprof/ while it runs, e.g. 127.0.0.1:6060. Anyone able to reach it can profile the process, keep it on localhost.")
+	RootCmd.PersistentFlags().StringVar(&traceFile, "traceFile", "", "the path of a file to write an execution trace of the whole run to, see go tool trace.")
+}
+
+func resetDiagnosticsFlags() {
+	pprofAddr = ""
+	traceFile = ""
+	stopDiagnostics()
+}
+
+// startDiagnostics will serve the pprof profiles and start the execution trace requested, if any. Each is only
+// started once per process, as the flags are processed again for every job of a run.
+func startDiagnostics() error {
+	if traceFile != "" && traceOut == nil {
+		f, err := os.Create(traceFile)
+		if err != nil {
+			return fmt.Errorf("could not create the trace file %s - %v", traceFile, err)
+		}
+		if err = trace.Start(f); err != nil {
+			f.Close()
+			return fmt.Errorf("could not start the execution trace - %v", err)
+		}
+		traceOut = f
+		helpers.AppLogger.Infof("Writing an execution trace to %s", traceFile)
+	}
+
+	if pprofAddr != "" && pprofServer == nil {
+		// Listen right away so an address in use fails the command instead of going unnoticed
+		l, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			return fmt.Errorf("could not listen on %s to serve the pprof profiles - %v", pprofAddr, err)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		pprofServer = &http.Server{Handler: mux}
+		go func(s *http.Server) {
+			if err := s.Serve(l); err != nil && err != http.ErrServerClosed {
+				helpers.AppLogger.Warningf("Stopped serving the pprof profiles - %v", err)
+			}
+		}(pprofServer)
+		helpers.AppLogger.Noticef("Serving the pprof profiles on http://%s/debug/pprof/", l.Addr())
+	}
+	return nil
+}
+
+// stopDiagnostics will stop serving the pprof profiles and flush the execution trace, if either was started.
+func stopDiagnostics() {
+	if pprofServer != nil {
+		pprofServer.Close()
+		pprofServer = nil
+	}
+	if traceOut != nil {
+		trace.Stop()
+		if err := traceOut.Close(); err != nil {
+			helpers.AppLogger.Warningf("Could not write the execution trace to %s - %v", traceOut.Name(), err)
+		}
+		traceOut = nil
+	}
+}
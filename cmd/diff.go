@@ -0,0 +1,93 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [flags] volume@snapshotA volume@snapshotB uri",
+	Short: "diff will report the size and composition difference between two backed up snapshots of a volume.",
+	Long: `diff will report the size and composition difference between two backed up snapshots of a volume.
+
+The backup sets each snapshot depends on to be restored are compared, reporting the size, volumes,
+and compression of the backup sets only one of them needs. When both snapshots are found locally,
+the files changed between them, as reported by "zfs diff", are summarized as well.`,
+	PreRunE: validateDiffFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return backup.Diff(context.Background(), &jobInfo, jobInfo.IncrementalSnapshot.Name, jobInfo.BaseSnapshot.Name)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+}
+
+// ResetDiffJobInfo exists solely for integration testing
+func ResetDiffJobInfo() {
+	resetRootFlags()
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{}
+	jobInfo.IncrementalSnapshot = helpers.SnapshotInfo{}
+}
+
+func validateDiffFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	from := strings.Split(args[0], "@")
+	if len(from) != 2 {
+		helpers.AppLogger.Errorf("Invalid snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
+		return errInvalidInput
+	}
+	jobInfo.VolumeName = from[0]
+	jobInfo.IncrementalSnapshot = helpers.SnapshotInfo{Name: from[1]}
+
+	// The second snapshot may be given without its volume
+	to := strings.Split(args[1], "@")
+	if len(to) != 2 || (to[0] != "" && to[0] != jobInfo.VolumeName) {
+		helpers.AppLogger.Errorf("Invalid snapshot provided. Expected format %s@<snapshot>, got %s instead", jobInfo.VolumeName, args[1])
+		return errInvalidInput
+	}
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{Name: to[1]}
+
+	_, err := backends.GetBackendForURI(args[2])
+	if err == backends.ErrInvalidPrefix {
+		helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", args[2])
+		return errInvalidInput
+	} else if err == backends.ErrInvalidURI {
+		helpers.AppLogger.Errorf("Invalid destination URI, was given %s", args[2])
+		return errInvalidInput
+	}
+	jobInfo.Destinations = []string{args[2]}
+
+	return nil
+}
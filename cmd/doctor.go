@@ -0,0 +1,272 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Results of a doctor check
+const (
+	doctorOK     = "OK"
+	doctorWarn   = "WARN"
+	doctorFailed = "FAILED"
+)
+
+// Cloud providers reject requests signed with a clock that is off by more than a few minutes.
+const maxClockSkew = 5 * time.Minute
+
+// Pool features that allow for more efficient or resumable sends.
+var sendFeatures = []struct {
+	name, purpose string
+}{
+	{"embedded_data", "sending compact streams of small or highly compressible blocks"},
+	{"extensible_dataset", "resumable sends (--resume)"},
+	{"large_blocks", "sending records larger than 128KiB"},
+	{"lz4_compress", "lz4 compression"},
+}
+
+var clockURL string
+
+// doctorCheck is the outcome of a single check made by the doctor command.
+type doctorCheck struct {
+	Check       string
+	Status      string
+	Detail      string
+	Remediation string `json:",omitempty"`
+}
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [flags] [uri(s)]",
+	Short: "doctor will check the environment zfsbackup runs in and suggest how to fix any problem found.",
+	Long: `doctor will check the environment zfsbackup runs in and suggest how to fix any problem found.
+
+The zfs binary and the feature flags of every pool, the working directory permissions,
+the space available for volumes of the given size, the clock skew against the clockURL
+if one is provided, and the reachability of every destination given are checked.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		checks := []doctorCheck{checkZFSBinary(ctx)}
+		checks = append(checks, checkPoolFeatures(ctx)...)
+		checks = append(checks, checkWorkingDirectory(), checkTempSpace())
+		if clockURL != "" {
+			checks = append(checks, checkClockSkew(ctx, clockURL))
+		}
+		for _, arg := range args {
+			for _, destination := range strings.Split(arg, ",") {
+				checks = append(checks, checkBackend(ctx, destination))
+			}
+		}
+
+		if helpers.JSONOutput {
+			if err := printJSON(checks); err != nil {
+				return err
+			}
+		} else {
+			printDoctorChecks(checks)
+		}
+
+		var failed int
+		for _, check := range checks {
+			if check.Status == doctorFailed {
+				failed++
+			}
+		}
+		if failed > 0 {
+			// The checks already describe what is wrong
+			cmd.SilenceUsage = true
+			err := fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			helpers.AppLogger.Errorf("Problems were found with the environment - %v", err)
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(doctorCmd)
+
+	doctorCmd.Flags().Uint64Var(&jobInfo.VolumeSize, "volsize", 200, "the volume size (in MiB) to check the available temporary space against.")
+	doctorCmd.Flags().IntVar(&jobInfo.MaxFileBuffer, "maxFileBuffer", 5, "the number of volumes to check the available temporary space against.")
+	doctorCmd.Flags().StringVar(&clockURL, "clockURL", "", "the URL whose Date header the local clock is compared against, e.g. the endpoint of a destination. The clock is not checked if not provided.")
+}
+
+func resetDoctorFlags() {
+	clockURL = ""
+}
+
+func printDoctorChecks(checks []doctorCheck) {
+	for _, check := range checks {
+		fmt.Fprintf(helpers.Stdout, "%-7s %-18s %s\n", check.Status, check.Check, check.Detail)
+		if check.Remediation != "" {
+			fmt.Fprintf(helpers.Stdout, "%-7s %-18s -> %s\n", "", "", check.Remediation)
+		}
+	}
+}
+
+func checkZFSBinary(ctx context.Context) doctorCheck {
+	check := doctorCheck{Check: "zfs binary", Status: doctorOK}
+	version, err := helpers.GetZFSVersion(ctx)
+	if err == nil {
+		check.Detail = version
+		return check
+	}
+
+	// Older releases do not support the version command
+	if _, perr := helpers.GetZFSPools(ctx); perr != nil {
+		check.Status = doctorFailed
+		check.Detail = perr.Error()
+		check.Remediation = fmt.Sprintf("Install ZFS or provide the path to the zfs executable with --zfsPath (currently %s), and make sure the user running zfsbackup may use it.", helpers.ZFSPath)
+		return check
+	}
+	check.Status = doctorWarn
+	check.Detail = "could not determine the zfs version, it may predate resumable and compressed sends"
+	check.Remediation = "Upgrade to a recent release of ZFS."
+	return check
+}
+
+func checkPoolFeatures(ctx context.Context) []doctorCheck {
+	pools, err := helpers.GetZFSPools(ctx)
+	if err != nil {
+		// Reported by the zfs binary check
+		return nil
+	}
+
+	checks := make([]doctorCheck, 0, len(pools))
+	for _, pool := range pools {
+		check := doctorCheck{Check: "pool " + pool, Status: doctorOK, Detail: "all send related features are enabled"}
+		features, ferr := helpers.GetZPoolFeatures(ctx, pool)
+		if ferr != nil {
+			check.Status = doctorWarn
+			check.Detail = fmt.Sprintf("could not get the pool features - %v", ferr)
+			check.Remediation = "Make sure the zpool executable is found alongside the zfs executable."
+			checks = append(checks, check)
+			continue
+		}
+
+		var missing []string
+		for _, feature := range sendFeatures {
+			if state := features[feature.name]; state != "enabled" && state != "active" {
+				missing = append(missing, fmt.Sprintf("%s (%s)", feature.name, feature.purpose))
+			}
+		}
+		if len(missing) > 0 {
+			check.Status = doctorWarn
+			check.Detail = "missing features: " + strings.Join(missing, ", ")
+			check.Remediation = fmt.Sprintf("Run \"zpool upgrade %s\" once every system that imports the pool supports these features.", pool)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkWorkingDirectory() doctorCheck {
+	check := doctorCheck{Check: "working directory", Status: doctorOK, Detail: helpers.WorkingDir}
+	for _, dir := range []string{helpers.WorkingDir, helpers.BackupTempdir} {
+		f, err := ioutil.TempFile(dir, "doctor")
+		if err == nil {
+			f.Close()
+			err = os.Remove(f.Name())
+		}
+		if err != nil {
+			check.Status = doctorFailed
+			check.Detail = err.Error()
+			check.Remediation = fmt.Sprintf("Make sure the user running zfsbackup can write to %s, or provide another directory with --workingDirectory.", dir)
+			return check
+		}
+	}
+	return check
+}
+
+func checkTempSpace() doctorCheck {
+	check := doctorCheck{Check: "temporary space", Status: doctorOK}
+	if jobInfo.MaxFileBuffer == 0 {
+		check.Detail = "not needed with a maxFileBuffer of 0"
+		return check
+	}
+
+	available, needed, err := stagingSpace(&jobInfo)
+	if err != nil {
+		check.Status = doctorWarn
+		check.Detail = fmt.Sprintf("could not get the space available in %s - %v", helpers.BackupTempdir, err)
+		return check
+	}
+
+	check.Detail = fmt.Sprintf("%s available in %s, %s needed for %d volumes of %dMiB", humanize.IBytes(available), helpers.BackupTempdir, humanize.IBytes(needed), jobInfo.MaxFileBuffer, jobInfo.VolumeSize)
+	if available < needed {
+		check.Status = doctorFailed
+		check.Remediation = "Free up space, provide a working directory on a larger filesystem with --workingDirectory or a larger --tempDir, or lower --volsize or --maxFileBuffer."
+	}
+	return check
+}
+
+func checkClockSkew(ctx context.Context, url string) doctorCheck {
+	check := doctorCheck{Check: "clock skew", Status: doctorOK}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var date time.Time
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+			resp.Body.Close()
+			date, err = http.ParseTime(resp.Header.Get("Date"))
+		}
+	}
+	if err != nil {
+		check.Status = doctorWarn
+		check.Detail = fmt.Sprintf("could not get the time from %s - %v", url, err)
+		check.Remediation = "Provide another URL with --clockURL, or none to skip this check."
+		return check
+	}
+
+	skew := time.Since(date).Round(time.Second)
+	check.Detail = fmt.Sprintf("the local clock is off by %v compared to %s", skew, url)
+	if skew > maxClockSkew || skew < -maxClockSkew {
+		check.Status = doctorFailed
+		check.Remediation = "Synchronize the system clock with NTP (e.g. chrony or systemd-timesyncd), cloud storage providers reject requests signed with a skewed clock."
+	}
+	return check
+}
+
+func checkBackend(ctx context.Context, destination string) doctorCheck {
+	check := doctorCheck{Check: "destination", Status: doctorOK, Detail: destination}
+	if err := backup.CheckDestination(ctx, &jobInfo, destination); err != nil {
+		check.Status = doctorFailed
+		check.Detail = fmt.Sprintf("%s - %v", destination, err)
+		check.Remediation = "Check the destination URI and the credentials its backend reads from the environment, see the README for the details of every backend."
+	}
+	return check
+}
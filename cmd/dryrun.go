@@ -0,0 +1,69 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var dryRun bool
+
+// runDryRun will compute and print the plan of a job without executing it.
+func runDryRun(planner func(context.Context, *helpers.JobInfo) (*backup.Plan, error)) error {
+	plan, err := planner(context.Background(), &jobInfo)
+	if err != nil {
+		helpers.AppLogger.Errorf("Could not plan the job due to error - %v", err)
+		return err
+	}
+
+	if helpers.JSONOutput {
+		return printJSON(plan)
+	}
+
+	fmt.Fprintf(helpers.Stdout, "Dry run of a %s with %s, nothing will be executed.\n", plan.Operation, strings.Join(plan.Destinations, ", "))
+	if len(plan.Steps) == 0 {
+		fmt.Fprintln(helpers.Stdout, "Nothing to do.")
+	}
+	for _, step := range plan.Steps {
+		fmt.Fprintf(helpers.Stdout, "\n%s@%s", step.VolumeName, step.BaseSnapshot)
+		if step.IncrementalSnapshot != "" {
+			fmt.Fprintf(helpers.Stdout, " (incremental from %s)", step.IncrementalSnapshot)
+		}
+		fmt.Fprintf(helpers.Stdout, "\n  Size: %s\n", humanize.IBytes(step.Bytes))
+		if step.File != "" {
+			fmt.Fprintf(helpers.Stdout, "  File: %s\n", step.File)
+		} else {
+			fmt.Fprintf(helpers.Stdout, "  Command: %s\n", step.Command)
+		}
+		fmt.Fprintf(helpers.Stdout, "  Objects:\n")
+		for _, object := range step.Objects {
+			fmt.Fprintf(helpers.Stdout, "    %s\n", object)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// estimateCmd represents the estimate command
+var estimateCmd = &cobra.Command{
+	Use:   "estimate [flags] snapshot [uri]",
+	Short: "estimate will predict the size, volume count, and duration of a backup before sending it.",
+	Long: `estimate will predict the size, volume count, and duration of a backup before sending it.
+
+The size of the zfs send stream is estimated by zfs. If a uri is provided, the compression
+ratio and throughput of the backup sets found there, preferring those of the same volume
+and compressor, are used to predict the size stored and the time the send would take.`,
+	PreRunE: validateEstimateFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		var history []*helpers.JobInfo
+		if len(jobInfo.Destinations) > 0 {
+			var err error
+			if history, err = backup.ListBackupSets(ctx, &jobInfo, "", time.Time{}, time.Time{}); err != nil {
+				helpers.AppLogger.Errorf("Could not list the backup sets of %s due to error - %v", jobInfo.Destinations[0], err)
+				return err
+			}
+		}
+
+		estimate, err := backup.EstimateBackup(ctx, &jobInfo, history)
+		if err != nil {
+			return err
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(estimate)
+		}
+
+		fmt.Fprintf(helpers.Stdout, "Estimate for %s@%s", jobInfo.VolumeName, jobInfo.BaseSnapshot.Name)
+		if jobInfo.IncrementalSnapshot.Name != "" {
+			fmt.Fprintf(helpers.Stdout, " (incremental from %s)", jobInfo.IncrementalSnapshot.Name)
+		}
+		fmt.Fprintf(helpers.Stdout, "\n  zfs send stream: %s\n", humanize.IBytes(estimate.StreamBytes))
+		if estimate.Samples > 0 {
+			fmt.Fprintf(helpers.Stdout, "  Compression ratio: %.2f (from %d previous backup sets)\n", estimate.CompressionRatio, estimate.Samples)
+		} else {
+			fmt.Fprintln(helpers.Stdout, "  Compression ratio: unknown, no previous backup sets to learn from")
+		}
+		fmt.Fprintf(helpers.Stdout, "  Size: %s in %d volumes of %dMiB\n", humanize.IBytes(estimate.Bytes), estimate.Volumes, jobInfo.VolumeSize)
+		if estimate.Duration > 0 {
+			fmt.Fprintf(helpers.Stdout, "  Duration: %v (at %s/s)\n", estimate.Duration.Round(time.Second), humanize.IBytes(uint64(estimate.Throughput)))
+		} else {
+			fmt.Fprintln(helpers.Stdout, "  Duration: unknown, no previous backup sets to learn from")
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(estimateCmd)
+
+	estimateCmd.Flags().BoolVarP(&jobInfo.Replication, "replication", "R", false, "See the -R flag on zfs send for more information")
+	estimateCmd.Flags().BoolVarP(&jobInfo.Deduplication, "deduplication", "D", false, "See the -D flag for zfs send for more information.")
+	estimateCmd.Flags().StringVarP(&jobInfo.IncrementalSnapshot.Name, "incremental", "i", "", "See the -i flag on zfs send for more information")
+	estimateCmd.Flags().StringVarP(&fullIncremental, "intermediary", "I", "", "See the -I flag on zfs send for more information")
+	estimateCmd.Flags().BoolVarP(&jobInfo.Properties, "properties", "p", false, "See the -p flag on zfs send for more information.")
+	estimateCmd.Flags().Uint64Var(&jobInfo.VolumeSize, "volsize", 200, "the maximum size (in MiB) of a volume.")
+	estimateCmd.Flags().StringVar(&jobInfo.Compressor, "compressor", helpers.InternalCompressor, "the compressor the backup would use, only previous backup sets using the same compressor are used to predict the compression ratio.")
+}
+
+func validateEstimateFlags(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	if jobInfo.IncrementalSnapshot.Name != "" && fullIncremental != "" {
+		helpers.AppLogger.Errorf("The flags -i and -I are mutually exclusive. Please specify only one of these flags.")
+		return errInvalidInput
+	}
+
+	parts := strings.Split(args[0], "@")
+	if len(parts) != 2 {
+		helpers.AppLogger.Errorf("Invalid snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
+		return errInvalidInput
+	}
+	jobInfo.VolumeName = parts[0]
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+
+	if fullIncremental != "" {
+		jobInfo.IncrementalSnapshot.Name = fullIncremental
+		jobInfo.IntermediaryIncremental = true
+	}
+	jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, jobInfo.VolumeName)
+	jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, "@")
+
+	if len(args) == 2 {
+		jobInfo.Destinations = []string{args[1]}
+	}
+	return nil
+}
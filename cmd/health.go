@@ -0,0 +1,123 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Where the serve command answers liveness and readiness checks on its --httpAddr. The daemon is alive as long
+// as it answers at healthPath or livePath, and ready to serve requests while it answers readyPath with 200.
+const (
+	healthPath = "/healthz"
+	livePath   = "/livez"
+	readyPath  = "/readyz"
+)
+
+var (
+	healthAddr    string
+	healthTimeout time.Duration
+	healthReady   bool
+)
+
+// healthCmd represents the health command
+var healthCmd = &cobra.Command{
+	Use:   "health [flags]",
+	Short: "health checks that a serve daemon is up and answering, e.g. as the health check of its container.",
+	Long: `health checks that a serve daemon is up and answering on the --httpAddr it serves
+its metrics on, or with --ready that it is ready to serve requests, exiting with 0 if it
+is and 1 otherwise. Nothing is read or written in the working directory, so it can be
+run as the health check of a container or jail without sharing its volumes.`,
+	// Nothing to set up, it only talks to the daemon
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// A failed check is not a usage error
+		cmd.SilenceUsage = true
+		path := healthPath
+		if healthReady {
+			path = readyPath
+		}
+		client := &http.Client{Timeout: healthTimeout}
+		resp, err := client.Get("http://" + healthAddr + path)
+		if err != nil {
+			helpers.AppLogger.Errorf("The daemon is not answering on %s - %v", healthAddr, err)
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			helpers.AppLogger.Errorf("The daemon answered on %s with %s", healthAddr, resp.Status)
+			return fmt.Errorf("unhealthy: %s", resp.Status)
+		}
+		fmt.Fprintln(helpers.Stdout, "ok")
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(healthCmd)
+
+	healthCmd.Flags().StringVar(&healthAddr, "httpAddr", "127.0.0.1:8080", "the --httpAddr the serve daemon to check serves its metrics on.")
+	healthCmd.Flags().DurationVar(&healthTimeout, "timeout", 5*time.Second, "the time to wait for the daemon to answer.")
+	healthCmd.Flags().BoolVar(&healthReady, "ready", false, "check that the daemon is ready to serve requests, not only alive: it is not while starting up or shutting down.")
+}
+
+// ResetHealthJobInfo exists solely for integration testing
+func ResetHealthJobInfo() {
+	resetRootFlags()
+	healthAddr = "127.0.0.1:8080"
+	healthTimeout = 5 * time.Second
+	healthReady = false
+}
+
+// serveHealth answers the liveness checks of a serve daemon, it is alive as long as it answers.
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok\n")
+}
+
+// readiness answers the readiness checks of a serve daemon, it is ready once set and until unset when shutting down.
+type readiness struct {
+	ready int32
+}
+
+func (r *readiness) set(ready bool) {
+	var value int32
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&r.ready, value)
+}
+
+func (r *readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if atomic.LoadInt32(&r.ready) == 0 {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ok\n")
+}
@@ -0,0 +1,122 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	preHook               string
+	postHook              string
+	hookTimeout           time.Duration
+	abortOnPreHookFailure bool
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&preHook, "preHook", "", "a shell command to run before a send, receive, or verify job resolves its snapshots (e.g. to quiesce a database and take a snapshot). The ZFSBACKUP_OPERATION, ZFSBACKUP_VOLUME, ZFSBACKUP_SNAPSHOT, and ZFSBACKUP_DESTINATIONS environmental variables describe the job.")
+	RootCmd.PersistentFlags().StringVar(&postHook, "postHook", "", "a shell command to run once a send, receive, or verify job completes. In addition to the preHook variables, ZFSBACKUP_STATUS (success or failure), ZFSBACKUP_ERROR, ZFSBACKUP_BYTES, and ZFSBACKUP_MANIFEST (the local path of the manifest written by a send) are set.")
+	RootCmd.PersistentFlags().DurationVar(&hookTimeout, "hookTimeout", 10*time.Minute, "the maximum time a hook may run before it is killed. Use 0 for no limit.")
+	RootCmd.PersistentFlags().BoolVar(&abortOnPreHookFailure, "abortOnPreHookFailure", true, "abort the job if the preHook fails or times out, otherwise the failure is logged and the job continues.")
+}
+
+func resetHookFlags() {
+	preHook = ""
+	postHook = ""
+	hookTimeout = 10 * time.Minute
+	abortOnPreHookFailure = true
+}
+
+// runPreHook will run the preHook, if any, for the operation about to run on the
+// snapshot and destinations found in args.
+func runPreHook(operation string, args []string) error {
+	if preHook == "" {
+		return nil
+	}
+	if dryRun {
+		helpers.AppLogger.Noticef("Not running the preHook for a dry run.")
+		return nil
+	}
+
+	parts := strings.SplitN(args[0], "@", 2)
+	env := map[string]string{
+		"ZFSBACKUP_OPERATION": operation,
+		"ZFSBACKUP_VOLUME":    parts[0],
+	}
+	if len(parts) == 2 {
+		env["ZFSBACKUP_SNAPSHOT"] = parts[1]
+	}
+	if len(args) > 1 {
+		env["ZFSBACKUP_DESTINATIONS"] = args[1]
+	}
+
+	if err := helpers.RunHook(context.Background(), preHook, hookTimeout, env); err != nil {
+		if abortOnPreHookFailure {
+			helpers.AppLogger.Errorf("Aborting, the pre hook failed - %v", err)
+			return err
+		}
+		helpers.AppLogger.Warningf("Continuing even though the pre hook failed - %v", err)
+	}
+	return nil
+}
+
+// runFailedPostHook will run the postHook for a job that failed once its preHook ran but before the job itself
+// started, so whatever the preHook did (e.g. quiescing a database) is undone.
+func runFailedPostHook(operation string, err error) {
+	if preHook == "" || dryRun {
+		return
+	}
+	runPostHook(postHook, control.NewResult(operation, &jobInfo, err), "")
+}
+
+// runPostHook will run the hook, if any, describing the result of the job and the manifest it wrote.
+func runPostHook(hook string, result control.Result, manifestPath string) error {
+	if hook == "" {
+		return nil
+	}
+
+	status := "success"
+	if result.Failed() {
+		status = "failure"
+	}
+	env := map[string]string{
+		"ZFSBACKUP_OPERATION":    result.Operation,
+		"ZFSBACKUP_VOLUME":       result.VolumeName,
+		"ZFSBACKUP_SNAPSHOT":     result.Snapshot,
+		"ZFSBACKUP_DESTINATIONS": strings.Join(result.Destinations, ","),
+		"ZFSBACKUP_STATUS":       status,
+		"ZFSBACKUP_ERROR":        result.Error,
+		"ZFSBACKUP_BYTES":        fmt.Sprintf("%d", result.Bytes),
+		"ZFSBACKUP_MANIFEST":     manifestPath,
+	}
+
+	err := helpers.RunHook(context.Background(), hook, hookTimeout, env)
+	if err != nil {
+		helpers.AppLogger.Errorf("The post hook failed - %v", err)
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// testImmutabilityCmd represents the test-immutability command
+var testImmutabilityCmd = &cobra.Command{
+	Use:   "test-immutability [flags] uri(s)",
+	Short: "test-immutability will check that the provided targets prevent overwriting and deleting the objects stored.",
+	Long: `test-immutability will check that the provided targets prevent overwriting and deleting the objects stored.
+
+A canary object is written to every target, then overwritten and deleted. When the
+target keeps the prior versions of its objects (e.g. an s3 bucket with versioning),
+the original version of the canary is deleted as well. A target is protected when
+every one of these attempts was refused or left the original canary in place, as
+its WORM, object lock, or retention configuration should. The canary is left behind
+at a protected target until its retention ends, and deleted otherwise.
+
+The command fails unless every target is protected.`,
+	PreRunE: validateTestImmutabilityFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		var reports []*backup.ImmutabilityReport
+		for _, destination := range args {
+			report, err := backup.CheckImmutability(ctx, &jobInfo, destination)
+			if err != nil {
+				helpers.AppLogger.Errorf("Could not test the immutability of %s - %v", destination, err)
+				return err
+			}
+			reports = append(reports, report)
+		}
+
+		var unprotected int
+		for _, report := range reports {
+			if !report.Protected {
+				unprotected++
+			}
+		}
+
+		if helpers.JSONOutput {
+			if err := printJSON(reports); err != nil {
+				return err
+			}
+		} else {
+			table := helpers.NewTable("RESULT", "ACTION", "DESTINATION", "DETAIL")
+			for _, report := range reports {
+				for _, check := range report.Checks {
+					result := "PREVENTED"
+					if !check.Prevented {
+						result = "ALLOWED"
+					}
+					table.Row(result, check.Action, report.Destination, check.Detail)
+				}
+			}
+			table.WriteTo(helpers.Stdout)
+		}
+
+		if unprotected > 0 {
+			// The checks already describe what is wrong
+			cmd.SilenceUsage = true
+			err := fmt.Errorf("%d of %d targets allowed the canary to be tampered with", unprotected, len(reports))
+			helpers.AppLogger.Errorf("The targets are not protected - %v", err)
+			return err
+		}
+		helpers.AppLogger.Noticef("Every target prevented the canary from being tampered with.")
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(testImmutabilityCmd)
+
+	testImmutabilityCmd.Flags().StringVar(&jobInfo.Separator, "separator", "|", "the separator to use between object component names.")
+}
+
+func validateTestImmutabilityFlags(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	return validateDestinationURIs(args)
+}
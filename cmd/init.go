@@ -0,0 +1,362 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var initOutput string
+
+var initConfigTemplate = template.Must(template.New("config").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(`# Generated by zfsbackup init
+{{- if .EncryptTo}}
+encryptTo: {{quote .EncryptTo}}
+signFrom: {{quote .EncryptTo}}
+secretKeyRingPath: {{quote .SecretKeyRingPath}}
+publicKeyRingPath: {{quote .PublicKeyRingPath}}
+{{- end}}
+
+jobs:
+{{- range .Jobs}}
+  {{quote .Name}}:
+    dataset: {{quote .Dataset}}
+    destinations: [{{range $i, $d := .Destinations}}{{if $i}}, {{end}}{{quote $d}}{{end}}]
+    schedule: {{quote .Schedule}}
+    description: {{quote .Description}}
+    fullIfOlderThan: {{quote .FullIfOlderThan}}
+{{- end}}
+`))
+
+// initConfig holds the answers given to the init command.
+type initConfig struct {
+	EncryptTo         string
+	SecretKeyRingPath string
+	PublicKeyRingPath string
+	Jobs              []initJob
+}
+
+// initJob is a job definition written by the init command.
+type initJob struct {
+	Name            string
+	Dataset         string
+	Destinations    []string
+	Schedule        string
+	Description     string
+	FullIfOlderThan string
+}
+
+// prompter asks questions on the terminal.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init [flags]",
+	Short: "init will interactively create a config file with a job for every dataset to back up.",
+	Long: `init will interactively create a config file with a job for every dataset to back up.
+
+You will be asked for a destination, which is tested before continuing, whether to
+encrypt and sign backups with an existing or newly generated PGP key, the datasets to
+back up, and a schedule. Generated keys are not protected by a passphrase, keep the
+secret keyring safe and store a copy of it away from the backups.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if containerMode {
+			helpers.AppLogger.Errorf("The init command is interactive and cannot be used in --container mode, write the config file yourself.")
+			return errInvalidInput
+		}
+		p := &prompter{in: bufio.NewReader(os.Stdin), out: helpers.Stdout}
+		config, err := runInitWizard(p)
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not complete the setup - %v", err)
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err = initConfigTemplate.Execute(&buf, config); err != nil {
+			helpers.AppLogger.Errorf("Could not generate the config file - %v", err)
+			return err
+		}
+
+		if _, serr := os.Stat(initOutput); serr == nil {
+			overwrite, perr := p.confirm(fmt.Sprintf("%s already exists, overwrite it?", initOutput), false)
+			if perr != nil {
+				return perr
+			}
+			if !overwrite {
+				fmt.Fprintf(helpers.Stdout, "Not writing the config file, it would have been:\n\n%s", buf.String())
+				return nil
+			}
+		}
+
+		if err = os.MkdirAll(filepath.Dir(initOutput), 0700); err != nil {
+			helpers.AppLogger.Errorf("Could not create the config directory due to error - %v", err)
+			return err
+		}
+		if err = ioutil.WriteFile(initOutput, buf.Bytes(), 0600); err != nil {
+			helpers.AppLogger.Errorf("Could not write %s due to error - %v", initOutput, err)
+			return err
+		}
+
+		fmt.Fprintf(helpers.Stdout, "\nWrote %s, next steps:\n", initOutput)
+		fmt.Fprintf(helpers.Stdout, "  Check the configuration: zfsbackup --config %s validate-config\n", initOutput)
+		for _, job := range config.Jobs {
+			fmt.Fprintf(helpers.Stdout, "  Run the first backup of %s: zfsbackup --config %s run %s\n", job.Dataset, initOutput, job.Name)
+			fmt.Fprintf(helpers.Stdout, "  Schedule it: zfsbackup --config %s install-systemd --job %s\n", initOutput, job.Name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().StringVar(&initOutput, "output", defaultInitOutput(), "the path to write the config file to.")
+}
+
+func resetInitFlags() {
+	initOutput = defaultInitOutput()
+}
+
+// defaultInitOutput is the first path the config file is searched for.
+func defaultInitOutput() string {
+	if os.Geteuid() == 0 {
+		return filepath.Join("/etc/zfsbackup", "config.yaml")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".zfsbackup", "config.yaml")
+}
+
+func runInitWizard(p *prompter) (*initConfig, error) {
+	config := new(initConfig)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Destination
+	var destination string
+	for {
+		answer, err := p.ask("Destination URI (e.g. gs://bucket-name, s3://bucket-name/prefix, file:///mnt/backups)", "")
+		if err != nil {
+			return nil, err
+		}
+		if answer == "" {
+			continue
+		}
+		fmt.Fprintf(p.out, "Testing %s...\n", answer)
+		if err = backup.CheckDestination(ctx, &jobInfo, answer); err == nil {
+			destination = answer
+			break
+		}
+		fmt.Fprintf(p.out, "Could not use %s - %v\n", answer, err)
+		retry, perr := p.confirm("Try another destination?", true)
+		if perr != nil {
+			return nil, perr
+		}
+		if !retry {
+			return nil, fmt.Errorf("no usable destination was given")
+		}
+	}
+
+	// Encryption
+	encrypt, err := p.confirm("Encrypt and sign backups?", true)
+	if err != nil {
+		return nil, err
+	}
+	if encrypt {
+		if err = initEncryption(p, config); err != nil {
+			return nil, err
+		}
+	}
+
+	// Datasets
+	datasets, err := selectDatasets(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Schedule
+	schedule, err := p.ask("Schedule, as a cron expression or systemd calendar event", "0 2 * * *")
+	if err != nil {
+		return nil, err
+	}
+	var fullIfOlderThan string
+	for {
+		if fullIfOlderThan, err = p.ask("Do a full backup when the last one is older than", "720h"); err != nil {
+			return nil, err
+		}
+		if _, err = time.ParseDuration(fullIfOlderThan); err == nil {
+			break
+		}
+		fmt.Fprintf(p.out, "Invalid duration %s - %v\n", fullIfOlderThan, err)
+	}
+
+	for _, dataset := range datasets {
+		config.Jobs = append(config.Jobs, initJob{
+			Name:            strings.ToLower(strings.Replace(dataset, "/", "-", -1)),
+			Dataset:         dataset,
+			Destinations:    []string{destination},
+			Schedule:        schedule,
+			Description:     fmt.Sprintf("Incremental backup of %s, full backup every %s", dataset, fullIfOlderThan),
+			FullIfOlderThan: fullIfOlderThan,
+		})
+	}
+	return config, nil
+}
+
+func initEncryption(p *prompter, config *initConfig) error {
+	existing, err := p.confirm("Use an existing PGP key?", false)
+	if err != nil {
+		return err
+	}
+
+	if existing {
+		if config.SecretKeyRingPath, err = p.ask("Path to the secret keyring", ""); err != nil {
+			return err
+		}
+		if config.PublicKeyRingPath, err = p.ask("Path to the public keyring", ""); err != nil {
+			return err
+		}
+		if config.EncryptTo, err = p.ask("Email of the key to encrypt and sign with", ""); err != nil {
+			return err
+		}
+		if err = helpers.LoadPrivateRing(config.SecretKeyRingPath); err != nil {
+			return fmt.Errorf("could not load the secret keyring - %v", err)
+		}
+		if err = helpers.LoadPublicRing(config.PublicKeyRingPath); err != nil {
+			return fmt.Errorf("could not load the public keyring - %v", err)
+		}
+		if helpers.GetPrivateKeyByEmail(config.EncryptTo) == nil {
+			return fmt.Errorf("could not find a secret key for %s", config.EncryptTo)
+		}
+		return nil
+	}
+
+	name, err := p.ask("Name for the new key", "zfsbackup")
+	if err != nil {
+		return err
+	}
+	for config.EncryptTo == "" {
+		if config.EncryptTo, err = p.ask("Email for the new key", ""); err != nil {
+			return err
+		}
+	}
+
+	dir := filepath.Dir(initOutput)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	config.SecretKeyRingPath = filepath.Join(dir, "secring.gpg.asc")
+	config.PublicKeyRingPath = filepath.Join(dir, "pubring.gpg.asc")
+	if err = helpers.GenerateKeyRings(name, config.EncryptTo, config.SecretKeyRingPath, config.PublicKeyRingPath); err != nil {
+		return fmt.Errorf("could not generate a PGP key - %v", err)
+	}
+	fmt.Fprintf(p.out, "Generated a PGP key for %s in %s, it is not protected by a passphrase. Keep a copy of it somewhere safe, the backups cannot be restored without it.\n", config.EncryptTo, config.SecretKeyRingPath)
+	return nil
+}
+
+func selectDatasets(ctx context.Context, p *prompter) ([]string, error) {
+	datasets, err := helpers.GetZFSDatasets(ctx)
+	if err != nil {
+		helpers.AppLogger.Warningf("Could not list the datasets - %v", err)
+	}
+	for i, dataset := range datasets {
+		fmt.Fprintf(p.out, "  %3d) %s\n", i+1, dataset)
+	}
+
+	for {
+		answer, err := p.ask("Datasets to back up, by number or name (comma separated)", "")
+		if err != nil {
+			return nil, err
+		}
+
+		var selected []string
+		for _, choice := range strings.Split(answer, ",") {
+			choice = strings.TrimSpace(choice)
+			if choice == "" {
+				continue
+			}
+			if n, cerr := strconv.Atoi(choice); cerr == nil && n > 0 && n <= len(datasets) {
+				choice = datasets[n-1]
+			}
+			selected = append(selected, choice)
+		}
+		if len(selected) > 0 {
+			return uniqueStrings(selected), nil
+		}
+	}
+}
+
+// ask will prompt for an answer, returning the default provided for an empty answer.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+
+	answer, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return "", fmt.Errorf("could not read the answer - %v", err)
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// confirm will prompt for a yes or no answer.
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	for {
+		answer, err := p.ask(fmt.Sprintf("%s (%s)", question, choices), "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestInitWizard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupinit")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer resetInitFlags()
+
+	// A fake zfs binary listing the datasets to choose from
+	zfsPath := helpers.ZFSPath
+	defer func() { helpers.ZFSPath = zfsPath }()
+	helpers.ZFSPath = filepath.Join(dir, "zfs")
+	if err = ioutil.WriteFile(helpers.ZFSPath, []byte("#!/bin/sh\nprintf 'tank\\ntank/home\\ntank/vm\\n'\n"), 0700); err != nil {
+		t.Fatalf("could not write the fake zfs binary: %v", err)
+	}
+
+	destination := filepath.Join(dir, "backups")
+	if err = os.Mkdir(destination, 0700); err != nil {
+		t.Fatalf("could not create the destination: %v", err)
+	}
+	initOutput = filepath.Join(dir, "config", "config.yaml")
+
+	answers := []string{
+		"file://" + filepath.Join(dir, "missing"), // not usable
+		"", // try another destination
+		"file://" + destination,
+		"y", // encrypt
+		"n", // generate a new key
+		"",  // default key name
+		"",  // an email is required
+		"backup@example.com",
+		"2, tank/other, tank/home",
+		"",      // default schedule
+		"bogus", // not a duration
+		"48h",
+	}
+	out := new(bytes.Buffer)
+	p := &prompter{in: bufio.NewReader(strings.NewReader(strings.Join(answers, "\n") + "\n")), out: out}
+	config, err := runInitWizard(p)
+	if err != nil {
+		t.Fatalf("unexpected error running the wizard: %v\n%s", err, out.String())
+	}
+
+	if !strings.Contains(out.String(), "Could not use file://") || !strings.Contains(out.String(), "Invalid duration bogus") {
+		t.Errorf("expected the wizard to explain the rejected answers, got:\n%s", out.String())
+	}
+	if config.EncryptTo != "backup@example.com" {
+		t.Errorf("expected to encrypt to the new key, got %q", config.EncryptTo)
+	}
+	for _, path := range []string{config.SecretKeyRingPath, config.PublicKeyRingPath} {
+		if filepath.Dir(path) != filepath.Dir(initOutput) {
+			t.Errorf("expected the keyrings to be written next to the config file, got %s", path)
+		}
+		if _, serr := os.Stat(path); serr != nil {
+			t.Errorf("expected the keyring %s to be written: %v", path, serr)
+		}
+	}
+
+	var names, datasets []string
+	for _, job := range config.Jobs {
+		names = append(names, job.Name)
+		datasets = append(datasets, job.Dataset)
+		if !reflect.DeepEqual(job.Destinations, []string{"file://" + destination}) || job.Schedule != "0 2 * * *" || job.FullIfOlderThan != "48h" {
+			t.Errorf("unexpected job %+v", job)
+		}
+	}
+	if expected := []string{"tank/home", "tank/other"}; !reflect.DeepEqual(datasets, expected) {
+		t.Errorf("expected jobs for %v, got %v", expected, datasets)
+	}
+	if expected := []string{"tank-home", "tank-other"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected jobs named %v, got %v", expected, names)
+	}
+
+	// Running it again must not replace the generated key
+	p = &prompter{in: bufio.NewReader(strings.NewReader(strings.Join([]string{"file://" + destination, "y", "n", "", "backup@example.com"}, "\n") + "\n")), out: out}
+	if _, err = runInitWizard(p); err == nil || !strings.Contains(err.Error(), "could not generate a PGP key") {
+		t.Errorf("expected an error generating a key over the existing one, got %v", err)
+	}
+
+	// Giving up on the destination
+	p = &prompter{in: bufio.NewReader(strings.NewReader("file://" + filepath.Join(dir, "missing") + "\nn\n")), out: out}
+	if _, err = runInitWizard(p); err == nil || !strings.Contains(err.Error(), "no usable destination") {
+		t.Errorf("expected an error without a usable destination, got %v", err)
+	}
+
+	// Running out of answers
+	p = &prompter{in: bufio.NewReader(strings.NewReader("file://" + destination + "\nn\n")), out: out}
+	if _, err = runInitWizard(p); err == nil {
+		t.Errorf("expected an error when the answers run out")
+	}
+}
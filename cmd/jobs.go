@@ -0,0 +1,475 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+	"github.com/someone1/zfsbackup-go/metrics"
+	"github.com/someone1/zfsbackup-go/notify"
+)
+
+var progressPID int
+
+// jobsCmd represents the jobs command
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List the send, receive, and verify jobs currently running from this working directory.",
+	Long: `List the send, receive, and verify jobs currently running from this working directory
+along with their progress, throughput, and estimated time remaining.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		statuses, err := control.ListJobs(control.SocketDir(helpers.WorkingDir))
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not list running jobs due to error - %v", err)
+			return err
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(statuses)
+		}
+
+		if len(statuses) == 0 {
+			fmt.Fprintln(helpers.Stdout, "No running jobs found.")
+			return nil
+		}
+
+		table := helpers.NewTable("PID", "OPERATION", "SNAPSHOT", "PROGRESS", "THROUGHPUT", "RETRIES", "ETA")
+		for _, status := range statuses {
+			progress := humanize.IBytes(status.BytesDone)
+			if status.TotalBytes > 0 {
+				progress = fmt.Sprintf("%s (%.1f%%)", progress, status.Percent())
+			}
+			retries := fmt.Sprintf("%d", status.Retries)
+			if status.Retries > 0 {
+				retries = helpers.Colorize(helpers.ColorYellow, retries)
+			}
+			eta := "-"
+			if status.Paused {
+				eta = helpers.Colorize(helpers.ColorYellow, "paused")
+			} else if status.ETA > 0 {
+				eta = status.ETA.Round(time.Second).String()
+			}
+			table.Row(fmt.Sprintf("%d", status.PID), status.Operation, fmt.Sprintf("%s@%s", status.VolumeName, status.Snapshot), progress, fmt.Sprintf("%s/s", humanize.IBytes(uint64(status.Throughput))), retries, eta)
+		}
+		_, err = table.WriteTo(helpers.Stdout)
+		return err
+	},
+}
+
+// progressCmd represents the progress command
+var progressCmd = &cobra.Command{
+	Use:     "progress [flags] [pid]",
+	Short:   "Show the detailed progress of a running job, or of all running jobs if no pid is given.",
+	Long:    `Show the detailed progress of a running job, or of all running jobs if no pid is given.`,
+	PreRunE: validateProgressFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		statuses, err := control.ListJobs(control.SocketDir(helpers.WorkingDir))
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not list running jobs due to error - %v", err)
+			return err
+		}
+
+		if progressPID != 0 {
+			var found []control.Status
+			for _, status := range statuses {
+				if status.PID == progressPID {
+					found = append(found, status)
+				}
+			}
+			if len(found) == 0 {
+				helpers.AppLogger.Errorf("No running job found with pid %d", progressPID)
+				return errInvalidInput
+			}
+			statuses = found
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(statuses)
+		}
+
+		var output []string
+		for _, status := range statuses {
+			output = append(output, status.String())
+		}
+		fmt.Fprintln(helpers.Stdout, strings.Join(output, "\n\n"))
+		return nil
+	},
+}
+
+// jobsPauseCmd represents the jobs pause command
+var jobsPauseCmd = &cobra.Command{
+	Use:   "pause [flags] [pid]",
+	Short: "Pause the transfers of a running job, or of all running jobs if no pid is given.",
+	Long: `Pause the transfers of a running job, or of all running jobs if no pid is given.
+The job keeps its temporary files and waits to be resumed, after at most as many volumes
+as allowed by --maxFileBuffer were created. Sending SIGUSR1 to a job has the same effect.`,
+	PreRunE: validateProgressFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return controlJobs(control.CommandPause)
+	},
+}
+
+// jobsResumeCmd represents the jobs resume command
+var jobsResumeCmd = &cobra.Command{
+	Use:   "resume [flags] [pid]",
+	Short: "Resume the transfers of a paused job, or of all paused jobs if no pid is given.",
+	Long: `Resume the transfers of a paused job, or of all paused jobs if no pid is given.
+Sending SIGUSR2 to a job has the same effect.`,
+	PreRunE: validateProgressFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return controlJobs(control.CommandResume)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(jobsCmd)
+	RootCmd.AddCommand(progressCmd)
+
+	jobsCmd.AddCommand(jobsPauseCmd)
+	jobsCmd.AddCommand(jobsResumeCmd)
+}
+
+// ResetProgressJobInfo exists solely for integration testing
+func ResetProgressJobInfo() {
+	resetRootFlags()
+	progressPID = 0
+}
+
+func validateProgressFlags(cmd *cobra.Command, args []string) error {
+	progressPID = 0
+	switch len(args) {
+	case 0:
+	case 1:
+		pid, err := strconv.Atoi(args[0])
+		if err != nil || pid <= 0 {
+			helpers.AppLogger.Errorf("Invalid pid provided, was given %s", args[0])
+			return errInvalidInput
+		}
+		progressPID = pid
+	default:
+		cmd.Usage()
+		return errInvalidInput
+	}
+	return nil
+}
+
+// controlJobs will send the command to the job run by progressPID, or to every running job if none was given.
+func controlJobs(command string) error {
+	statuses, err := control.ListJobs(control.SocketDir(helpers.WorkingDir))
+	if err != nil {
+		helpers.AppLogger.Errorf("Could not list running jobs due to error - %v", err)
+		return err
+	}
+
+	var results []control.Status
+	for _, status := range statuses {
+		if progressPID != 0 && status.PID != progressPID {
+			continue
+		}
+		result, serr := control.Send(control.SocketPath(control.SocketDir(helpers.WorkingDir), status.PID), command)
+		if serr != nil {
+			helpers.AppLogger.Errorf("Could not %s job with pid %d due to error - %v", command, status.PID, serr)
+			return serr
+		}
+		results = append(results, result)
+	}
+
+	if progressPID != 0 && len(results) == 0 {
+		helpers.AppLogger.Errorf("No running job found with pid %d", progressPID)
+		return errInvalidInput
+	}
+
+	if helpers.JSONOutput {
+		return printJSON(results)
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintln(helpers.Stdout, "No running jobs found.")
+	}
+	for _, result := range results {
+		state := "running"
+		if result.Paused {
+			state = "paused"
+		}
+		fmt.Fprintf(helpers.Stdout, "Job %s %s@%s (pid %d) is %s.\n", result.Operation, result.VolumeName, result.Snapshot, result.PID, state)
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	j, err := json.Marshal(v)
+	if err != nil {
+		helpers.AppLogger.Errorf("could not dump results to JSON - %v", err)
+		return err
+	}
+	fmt.Fprintln(helpers.Stdout, string(j))
+	return nil
+}
+
+// runJob will run the job for the given operation, reporting its progress on a control
+// socket in the working directory so it can be queried with the jobs and progress commands,
+// and to any observers requested on the command line.
+func runJob(operation string, job func(ctx context.Context) error) error {
+	tracker := control.NewTracker(operation, os.Getpid())
+	observers, err := jobObservers()
+	if err != nil {
+		return err
+	}
+
+	helpers.SetLogDataset(jobInfo.VolumeName)
+	defer helpers.SetLogDataset("")
+
+	stopPauseSignals := handlePauseSignals()
+	defer stopPauseSignals()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped, stopStopSignals := handleStopSignals(operation, cancel)
+	defer stopStopSignals()
+	windowClosed, stopWindow := enforceWindow(ctx, operation, cancel)
+	defer stopWindow()
+	interrupted := func() bool {
+		return stopped() || windowClosed()
+	}
+
+	server, serr := control.Serve(control.SocketDir(helpers.WorkingDir), tracker)
+	if serr != nil {
+		helpers.AppLogger.Warningf("Could not open control socket, the progress of this job will not be available - %v", serr)
+	} else {
+		helpers.AppLogger.Debugf("Reporting job progress on %s", server.Path())
+		defer func() {
+			if cerr := server.Close(); cerr != nil {
+				helpers.AppLogger.Warningf("Could not close control socket %s - %v", server.Path(), cerr)
+			}
+		}()
+	}
+
+	return runObservedJob(ctx, operation, &jobInfo, tracker.Update, observers, interrupted, postHook, job)
+}
+
+// runObservedJob will run the job for the given operation on j, reporting its progress to progress,
+// if not nil, and to the observers. Once done, the outcome of the job is recorded in the audit log,
+// given to the observers, and to the post hook.
+func runObservedJob(ctx context.Context, operation string, j *helpers.JobInfo, progress helpers.ProgressFunc, observers []control.Observer, interrupted func() bool, hook string, job func(ctx context.Context) error) error {
+	j.Progress = func(event helpers.ProgressEvent) {
+		if progress != nil {
+			progress(event)
+		}
+		for _, o := range observers {
+			o.Progress(operation, event)
+		}
+	}
+
+	err := job(ctx)
+	if err != nil && interrupted() {
+		helpers.AppLogger.Noticef("The %s job was interrupted - %v", operation, err)
+		if operation == "send" {
+			helpers.AppLogger.Noticef("Run it again with the --resume flag to continue where it left off.")
+		}
+		err = errInterrupted
+	}
+
+	recordAudit(operation, j, err)
+
+	result := control.NewResult(operation, j, err)
+	for _, o := range observers {
+		o.Finished(result)
+		if closer, ok := o.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+
+	// Only report a failing post hook if the job itself succeeded
+	if herr := runPostHook(hook, result, j.ManifestPath); herr != nil && err == nil {
+		err = helpers.NewError(helpers.ErrorKindPartial, herr)
+	}
+	return err
+}
+
+// handlePauseSignals will pause every transfer on SIGUSR1 and resume them on SIGUSR2 until the returned
+// function is called, at which point paused transfers are resumed.
+func handlePauseSignals() func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				if sig == syscall.SIGUSR1 {
+					helpers.Transfers.Pause()
+				} else {
+					helpers.Transfers.Resume()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+		helpers.Transfers.Resume()
+	}
+}
+
+// handleStopSignals will cancel the job on SIGINT or SIGTERM so it can stop cleanly, keeping what it needs
+// to be resumed, and exit immediately on a second signal. The first returned function reports whether the
+// job was interrupted, the second one stops handling the signals.
+func handleStopSignals(operation string, cancel context.CancelFunc) (func() bool, func()) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	var interrupted int32
+	go func() {
+		select {
+		case sig := <-sigs:
+			atomic.StoreInt32(&interrupted, 1)
+			helpers.AppLogger.Noticef("Received %v, stopping the %s job. Send it again to exit immediately.", sig, operation)
+			// Paused transfers must be able to notice the job is stopping
+			helpers.Transfers.Resume()
+			cancel()
+		case <-done:
+			return
+		}
+
+		select {
+		case sig := <-sigs:
+			helpers.AppLogger.Errorf("Received %v again, exiting immediately.", sig)
+			os.Exit(exitCodeInterrupted)
+		case <-done:
+		}
+	}()
+
+	isInterrupted := func() bool {
+		return atomic.LoadInt32(&interrupted) == 1
+	}
+	stop := func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+	return isInterrupted, stop
+}
+
+// jobObservers will return the observers requested on the command line. Any observer
+// that is an io.Closer should be closed once the job is done.
+func jobObservers() ([]control.Observer, error) {
+	var observers []control.Observer
+	n, err := newNotifier()
+	if err != nil {
+		return nil, err
+	} else if n != nil {
+		observers = append(observers, n)
+	}
+
+	if s := newStatsD(); s != nil {
+		observers = append(observers, s)
+	}
+	if healthcheckURL != "" {
+		observers = append(observers, notify.NewHealthcheck(healthcheckURL))
+	}
+	if pushGatewayURL != "" {
+		observers = append(observers, &pushObserver{Metrics: metrics.New(), url: pushGatewayURL})
+	}
+	if progressJSON == "-" {
+		observers = append(observers, control.NewEventStream(helpers.Stdout))
+	} else if progressJSON != "" {
+		s, err := control.OpenEventStream(progressJSON)
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not open %s to write the progress events to - %v", progressJSON, err)
+			return nil, err
+		}
+		observers = append(observers, s)
+	}
+	if d := newDisplay(); d != nil {
+		observers = append(observers, d)
+	}
+	return observers, nil
+}
+
+// newDisplay will return a Display drawing the progress of the job on stderr, with the logs printed above
+// it until it is closed, if stderr is a terminal the logs are written to and it wasn't disabled.
+func newDisplay() *displayObserver {
+	fd := int(os.Stderr.Fd())
+	if noProgressBar || logOutput == "stdout" || !terminal.IsTerminal(fd) {
+		return nil
+	}
+	width, _, err := terminal.GetSize(fd)
+	if err != nil {
+		width = 0
+	}
+	d := control.NewDisplay(os.Stderr, width, time.Second)
+	setLogBackend(d)
+	return &displayObserver{Display: d}
+}
+
+// newStatsD will return a StatsD emitter if one was requested and could be set up.
+func newStatsD() *metrics.StatsD {
+	if statsdAddr == "" {
+		return nil
+	}
+	s, err := metrics.NewStatsD(statsdAddr, statsdDatadog)
+	if err != nil {
+		helpers.AppLogger.Warningf("Could not set up statsd emitter for %s, no statsd metrics will be sent - %v", statsdAddr, err)
+		return nil
+	}
+	return s
+}
+
+// displayObserver prints the logs back on stderr once the Display it draws the progress of the job with is closed.
+type displayObserver struct {
+	*control.Display
+}
+
+func (d *displayObserver) Close() error {
+	setLogBackend(consoleLogs())
+	return d.Display.Close()
+}
+
+// pushObserver pushes the metrics it collects to a Pushgateway once the job is finished.
+type pushObserver struct {
+	*metrics.Metrics
+	url string
+}
+
+func (p *pushObserver) Finished(result control.Result) {
+	p.Metrics.Finished(result)
+	if err := p.Push(p.url, result.VolumeName); err != nil {
+		helpers.AppLogger.Warningf("Could not push metrics to %s - %v", p.url, err)
+	}
+}
@@ -0,0 +1,363 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	launchdJob      string
+	launchdSchedule string
+	launchdPlistDir string
+	launchdUser     string
+	launchdGroup    string
+	launchdLogPath  string
+	launchdPrint    bool
+)
+
+// maxCalendarIntervals caps how many dictionaries a cron expression may expand to, launchd has no
+// equivalent of steps or ranges and needs one dictionary per combination.
+const maxCalendarIntervals = 1000
+
+// launchdPath is the PATH given to the job, launchd jobs only get /usr/bin:/bin:/usr/sbin:/sbin which
+// does not include where OpenZFS on macOS installs its binaries.
+const launchdPath = "/usr/local/zfs/bin:/usr/local/bin:/usr/bin:/bin:/usr/sbin:/sbin"
+
+// cronShortcuts are the calendar intervals of the named schedules.
+var cronShortcuts = map[string][]map[string]int{
+	"hourly":   {{"Minute": 0}},
+	"daily":    {{"Hour": 0, "Minute": 0}},
+	"midnight": {{"Hour": 0, "Minute": 0}},
+	"weekly":   {{"Weekday": 0, "Hour": 0, "Minute": 0}},
+	"monthly":  {{"Day": 1, "Hour": 0, "Minute": 0}},
+}
+
+var plistTemplate = template.Must(template.New("plist").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{html .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{html .Executable}}</string>
+		<string>--config</string>
+		<string>{{html .Config}}</string>
+		<string>run</string>
+		<string>{{html .Job.Name}}</string>
+	</array>
+{{- if .User}}
+	<key>UserName</key>
+	<string>{{html .User}}</string>
+{{- end}}
+{{- if .Group}}
+	<key>GroupName</key>
+	<string>{{html .Group}}</string>
+{{- end}}
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>PATH</key>
+		<string>{{html .Path}}</string>
+	</dict>
+	<key>StartCalendarInterval</key>
+	<array>
+{{- range .Intervals}}
+		<dict>
+{{- range $key, $value := .}}
+			<key>{{$key}}</key>
+			<integer>{{$value}}</integer>
+{{- end}}
+		</dict>
+{{- end}}
+	</array>
+	<key>ProcessType</key>
+	<string>Background</string>
+	<key>Nice</key>
+	<integer>10</integer>
+	<key>LowPriorityIO</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{html .LogPath}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{html .LogPath}}</string>
+</dict>
+</plist>
+`))
+
+// installLaunchdCmd represents the install-launchd command
+var installLaunchdCmd = &cobra.Command{
+	Use:   "install-launchd [flags]",
+	Short: "install-launchd will write a launchd daemon running a job defined in the config file on macOS.",
+	Long: `install-launchd will write a launchd daemon running a job defined in the config file on macOS.
+
+The daemon runs the job on its schedule, converted from cron syntax to the StartCalendarInterval
+dictionaries launchd expects. Its PATH includes /usr/local/zfs/bin, where OpenZFS on macOS installs
+the zfs command. Unlike cron, launchd runs a job missed while the Mac was asleep once it wakes up.
+Secrets are not written to the plist, provide them with a PGP key file or the config file instead.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		label := "com.github.someone1.zfsbackup." + invalidUnitChars.ReplaceAllString(launchdJob, "-")
+		plist, err := launchdPlist(label)
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not generate the launchd plist - %v", err)
+			return err
+		}
+
+		if launchdPrint {
+			fmt.Fprintf(helpers.Stdout, "%s", plist)
+			return nil
+		}
+
+		path := filepath.Join(launchdPlistDir, label+".plist")
+		if err = ioutil.WriteFile(path, plist, 0644); err != nil {
+			helpers.AppLogger.Errorf("Could not write %s due to error - %v", path, err)
+			return err
+		}
+		helpers.AppLogger.Noticef("Wrote %s", path)
+		fmt.Fprintf(helpers.Stdout, "Load the daemon with: launchctl bootstrap system %s\n", path)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(installLaunchdCmd)
+
+	installLaunchdCmd.Flags().StringVar(&launchdJob, "job", "", "the name of the job, defined in the config file, to run.")
+	installLaunchdCmd.Flags().StringVar(&launchdSchedule, "schedule", "", "when to run the job, as a cron expression or one of hourly, daily, weekly, or monthly. Defaults to the schedule of the job.")
+	installLaunchdCmd.Flags().StringVar(&launchdPlistDir, "plistDir", "/Library/LaunchDaemons", "the directory to write the plist to.")
+	installLaunchdCmd.Flags().StringVar(&launchdUser, "user", "", "the user to run the job as, defaults to root. The user must be allowed to run the zfs commands the job needs.")
+	installLaunchdCmd.Flags().StringVar(&launchdGroup, "group", "", "the group to run the job as, defaults to the primary group of the user (wheel for root).")
+	installLaunchdCmd.Flags().StringVar(&launchdLogPath, "logPath", "", "the file launchd writes the output of the job to. Defaults to /Library/Logs/zfsbackup-<job>.log.")
+	installLaunchdCmd.Flags().BoolVar(&launchdPrint, "print", false, "print the plist instead of writing it.")
+}
+
+func resetLaunchdFlags() {
+	launchdJob = ""
+	launchdSchedule = ""
+	launchdPlistDir = "/Library/LaunchDaemons"
+	launchdUser = ""
+	launchdGroup = ""
+	launchdLogPath = ""
+	launchdPrint = false
+}
+
+func launchdPlist(label string) ([]byte, error) {
+	if launchdJob == "" {
+		return nil, fmt.Errorf("a job must be provided with --job")
+	}
+	if configFileUsed == "" {
+		return nil, fmt.Errorf("no config file was found to read the job from")
+	}
+
+	jobs, err := readJobDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	job, err := findJobDefinition(jobs, launchdJob)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule := launchdSchedule
+	if schedule == "" {
+		schedule = job.Schedule
+	}
+	if schedule == "" {
+		return nil, fmt.Errorf("the job %s has no schedule, provide one with --schedule", job.Name)
+	}
+	intervals, err := calendarIntervals(schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	config, err := filepath.Abs(configFileUsed)
+	if err != nil {
+		return nil, err
+	}
+
+	path := launchdPath
+	if filepath.IsAbs(helpers.ZFSPath) {
+		path = filepath.Dir(helpers.ZFSPath) + ":" + path
+	}
+	logPath := launchdLogPath
+	if logPath == "" {
+		logPath = fmt.Sprintf("/Library/Logs/zfsbackup-%s.log", invalidUnitChars.ReplaceAllString(job.Name, "-"))
+	}
+
+	data := map[string]interface{}{
+		"Label":      label,
+		"Job":        job,
+		"User":       launchdUser,
+		"Group":      launchdGroup,
+		"Executable": executable,
+		"Config":     config,
+		"Path":       path,
+		"Intervals":  intervals,
+		"LogPath":    logPath,
+	}
+
+	plist := new(bytes.Buffer)
+	if err = plistTemplate.Execute(plist, data); err != nil {
+		return nil, err
+	}
+	return plist.Bytes(), nil
+}
+
+// calendarIntervals will convert a cron expression (minute hour day-of-month month day-of-week), or
+// one of the named schedules, to launchd StartCalendarInterval dictionaries. A dictionary only runs
+// when all of its keys match, so when both the day of the month and the day of the week are restricted
+// the dictionaries of each are listed separately to keep the cron behavior of running on either.
+func calendarIntervals(schedule string) ([]map[string]int, error) {
+	if intervals, ok := cronShortcuts[strings.TrimPrefix(strings.ToLower(strings.TrimSpace(schedule)), "@")]; ok {
+		return intervals, nil
+	}
+
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("unsupported schedule %s, expected a cron expression", schedule)
+	}
+
+	type cronSpec struct {
+		key    string
+		fields cronRange
+	}
+	specs := []cronSpec{{"Minute", cronMinutes}, {"Hour", cronHours}, {"Day", cronDaysOfMonth}, {"Month", cronMonths}, {"Weekday", cronWeekdays}}
+	values := make([][]int, len(specs))
+	for idx, spec := range specs {
+		expanded, err := expandCronField(fields[idx], spec.fields)
+		if err != nil {
+			return nil, err
+		}
+		values[idx] = expanded
+	}
+
+	var intervals []map[string]int
+	combine := func(skip int) {
+		combined := []map[string]int{{}}
+		for idx, spec := range specs {
+			if idx == skip || values[idx] == nil {
+				continue
+			}
+			var next []map[string]int
+			for _, interval := range combined {
+				for _, value := range values[idx] {
+					entry := map[string]int{spec.key: value}
+					for key, v := range interval {
+						entry[key] = v
+					}
+					next = append(next, entry)
+				}
+			}
+			combined = next
+		}
+		intervals = append(intervals, combined...)
+	}
+	if values[2] != nil && values[4] != nil {
+		combine(4)
+		combine(2)
+	} else {
+		combine(-1)
+	}
+
+	if len(intervals) > maxCalendarIntervals {
+		return nil, fmt.Errorf("the schedule %s expands to %d calendar intervals, more than the %d supported", schedule, len(intervals), maxCalendarIntervals)
+	}
+	return intervals, nil
+}
+
+// expandCronField will list the values matched by a single field of a cron expression, given as
+// numbers or names, or nil if it matches any value.
+func expandCronField(field string, r cronRange) ([]int, error) {
+	if field == "*" {
+		return nil, nil
+	}
+
+	matched := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		value, step := part, 1
+		idx := strings.Index(part, "/")
+		if idx != -1 {
+			var err error
+			value = part[:idx]
+			if step, err = strconv.Atoi(part[idx+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in the %s %s of the cron expression", r.name, part)
+			}
+		}
+
+		first, last := r.min, r.max
+		if r.weekdays && value == "*" {
+			// Sunday is both 0 and 7
+			last = 6
+		}
+		if value != "*" {
+			bounds := strings.SplitN(value, "-", 2)
+			var err error
+			if first, err = r.value(bounds[0]); err != nil {
+				return nil, err
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = r.value(bounds[1]); err != nil {
+					return nil, err
+				}
+				if last < first {
+					return nil, fmt.Errorf("invalid range in the %s %s of the cron expression", r.name, part)
+				}
+			} else if idx != -1 {
+				// A step from a single value runs to the last value, whatever the step
+				last = r.max
+			}
+		}
+
+		for number := first; number <= last; number += step {
+			matched[number] = true
+		}
+	}
+
+	// Both 0 and 7 are Sunday for the day of the week
+	if r.weekdays && matched[7] {
+		delete(matched, 7)
+		matched[0] = true
+	}
+
+	values := make([]int, 0, len(matched))
+	for number := range matched {
+		values = append(values, number)
+	}
+	sort.Ints(values)
+	return values, nil
+}
@@ -0,0 +1,64 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCalendarIntervals(t *testing.T) {
+	testCases := []struct {
+		schedule  string
+		intervals []map[string]int
+		valid     bool
+	}{
+		{"@daily", []map[string]int{{"Hour": 0, "Minute": 0}}, true},
+		{"* * * * *", []map[string]int{{}}, true},
+		{"0 2 * * *", []map[string]int{{"Hour": 2, "Minute": 0}}, true},
+		{"*/20 * * * *", []map[string]int{{"Minute": 0}, {"Minute": 20}, {"Minute": 40}}, true},
+		{"0 20/1 * * *", []map[string]int{{"Hour": 20, "Minute": 0}, {"Hour": 21, "Minute": 0}, {"Hour": 22, "Minute": 0}, {"Hour": 23, "Minute": 0}}, true},
+		{"0 20/2 * * *", []map[string]int{{"Hour": 20, "Minute": 0}, {"Hour": 22, "Minute": 0}}, true},
+		{"0 3 * * 7", []map[string]int{{"Weekday": 0, "Hour": 3, "Minute": 0}}, true},
+		{"0 3 * * */3", []map[string]int{{"Weekday": 0, "Hour": 3, "Minute": 0}, {"Weekday": 3, "Hour": 3, "Minute": 0}, {"Weekday": 6, "Hour": 3, "Minute": 0}}, true},
+		{"0 3 * * mon,FRI", []map[string]int{{"Weekday": 1, "Hour": 3, "Minute": 0}, {"Weekday": 5, "Hour": 3, "Minute": 0}}, true},
+		{"0 3 1 Jun-Aug *", []map[string]int{{"Month": 6, "Day": 1, "Hour": 3, "Minute": 0}, {"Month": 7, "Day": 1, "Hour": 3, "Minute": 0}, {"Month": 8, "Day": 1, "Hour": 3, "Minute": 0}}, true},
+		// Cron runs when either the day of the month or the day of the week matches
+		{"0 3 1 * sun", []map[string]int{{"Day": 1, "Hour": 3, "Minute": 0}, {"Weekday": 0, "Hour": 3, "Minute": 0}}, true},
+		{"0 3 * * funday", nil, false},
+		{"0 3 * * sat-sun", nil, false},
+		{"0 24 * * *", nil, false},
+		{"0 0 * 13 *", nil, false},
+		{"*/0 * * * *", nil, false},
+		{"daily at 3", nil, false},
+	}
+
+	for _, c := range testCases {
+		intervals, err := calendarIntervals(c.schedule)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected it to be valid: %v, got %v", c.schedule, c.valid, err)
+			continue
+		}
+		if c.valid && !reflect.DeepEqual(intervals, c.intervals) {
+			t.Errorf("%s: expected the calendar intervals %v, got %v", c.schedule, c.intervals, intervals)
+		}
+	}
+}
@@ -36,6 +36,8 @@ var (
 	afterStr   string
 	before     time.Time
 	after      time.Time
+	listMaxAge time.Duration
+	listLong   bool
 )
 
 // listCmd represents the list command
@@ -62,7 +64,7 @@ var listCmd = &cobra.Command{
 		}
 
 		jobInfo.Destinations = []string{args[0]}
-		return backup.List(context.Background(), &jobInfo, startsWith, before, after)
+		return backup.List(context.Background(), &jobInfo, startsWith, before, after, listMaxAge, listLong)
 	},
 }
 
@@ -72,6 +74,8 @@ func init() {
 	listCmd.Flags().StringVar(&startsWith, "volumeName", "", "Filter results to only this volume name, can end with a '*' to match as only a prefix")
 	listCmd.Flags().StringVar(&beforeStr, "before", "", "Filter results to only this backups before this specified date & time (format: yyyy-MM-ddTHH:mm:ss, parsed in local TZ)")
 	listCmd.Flags().StringVar(&afterStr, "after", "", "Filter results to only this backups after this specified date & time (format: yyyy-MM-ddTHH:mm:ss, parsed in local TZ)")
+	listCmd.Flags().DurationVar(&listMaxAge, "maxAge", 0, "mark the volumes whose most recent backup set is of a snapshot older than this as overdue, e.g. 26h for daily backups. Use 0 to not check.")
+	listCmd.Flags().BoolVar(&listLong, "long", false, "output every detail of the backup sets instead of a table.")
 }
 
 func validateListFlags(cmd *cobra.Command, args []string) error {
@@ -108,4 +112,6 @@ func ResetListJobInfo() {
 	afterStr = ""
 	before = time.Time{}
 	after = time.Time{}
+	listMaxAge = 0
+	listLong = false
 }
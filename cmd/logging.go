@@ -0,0 +1,348 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/op/go-logging"
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+	"github.com/someone1/zfsbackup-go/journald"
+	"github.com/someone1/zfsbackup-go/logfile"
+)
+
+var (
+	logFile        string
+	logFileMaxSize uint64
+	logFileRotate  time.Duration
+	logFileKeep    int
+	logSyslog      bool
+	syslogAddr     string
+	syslogFacility string
+	syslogTag      string
+	logJournald    bool
+	logFormat      string
+	logOutput      string
+
+	logWriter    *logfile.Writer
+	syslogWriter *syslog.Writer
+	journal      *journald.Journal
+)
+
+// logFileFormat includes the pid as several runs may log to the same file.
+var logFileFormat = logging.MustStringFormatter("%{time:2006-01-02T15:04:05.000Z07:00} [%{pid}] %{level:.4s} %{message}")
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// journalPriorities maps the log levels to the syslog priorities the journal expects.
+var journalPriorities = map[logging.Level]syslog.Priority{
+	logging.CRITICAL: syslog.LOG_CRIT,
+	logging.ERROR:    syslog.LOG_ERR,
+	logging.WARNING:  syslog.LOG_WARNING,
+	logging.NOTICE:   syslog.LOG_NOTICE,
+	logging.INFO:     syslog.LOG_INFO,
+	logging.DEBUG:    syslog.LOG_DEBUG,
+}
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&logFile, "logFile", "", "the path of a file to also write the logs to, e.g. /var/log/zfsbackup.log. It is rotated according to --logFileMaxSize and --logFileRotate.")
+	RootCmd.PersistentFlags().Uint64Var(&logFileMaxSize, "logFileMaxSize", 100, "the size (in MiB) the log file is rotated at. Use 0 to not rotate it by size.")
+	RootCmd.PersistentFlags().DurationVar(&logFileRotate, "logFileRotate", 0, "rotate the log file once written to in a later period of this duration than its last write, e.g. 24h rotates it daily at midnight UTC. Use 0 to not rotate it by time.")
+	RootCmd.PersistentFlags().IntVar(&logFileKeep, "logFileKeep", 7, "the number of rotated log files to keep, the oldest ones are deleted. Use 0 to keep all of them.")
+	RootCmd.PersistentFlags().BoolVar(&logSyslog, "syslog", false, "also send the logs to syslog, see --syslogAddr, --syslogFacility, and --syslogTag.")
+	RootCmd.PersistentFlags().StringVar(&syslogAddr, "syslogAddr", "", "the address of the syslog server to send the logs to (e.g. udp://logs.example.com:514 or tcp://logs.example.com:514), the local syslog daemon is used if empty.")
+	RootCmd.PersistentFlags().StringVar(&syslogFacility, "syslogFacility", "daemon", "the syslog facility to log with. Possible values are kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp, and local0 through local7.")
+	RootCmd.PersistentFlags().StringVar(&syslogTag, "syslogTag", helpers.ProgramName, "the tag (or identifier) the logs are sent to syslog or the systemd journal with.")
+	RootCmd.PersistentFlags().StringVar(&logFormat, "logFormat", "", "the format of the logs written to the console and the log file. Possible values are text and json (one JSON object per line with the ts, level, msg, dataset, destination, volume, bytes, and err fields). Defaults to json in --container mode and text otherwise.")
+	RootCmd.PersistentFlags().StringVar(&logOutput, "logOutput", "", "the console output the logs are written to. Possible values are stderr and stdout. Defaults to stdout in --container mode, unless the command writes the zfs send stream or JSON (--jsonOutput or --progressJSON -) to stdout, and stderr otherwise.")
+	RootCmd.PersistentFlags().BoolVar(&logJournald, "journald", false, "also send the logs to the systemd journal with structured fields. The logs are not written to stderr when it is already connected to the journal (e.g. in a systemd service).")
+}
+
+func resetLoggingFlags() {
+	logFile = ""
+	logFileMaxSize = 100
+	logFileRotate = 0
+	logFileKeep = 7
+	logSyslog = false
+	syslogAddr = ""
+	syslogFacility = "daemon"
+	syslogTag = helpers.ProgramName
+	logJournald = false
+	logFormat = ""
+	logOutput = ""
+	closeLogOutputs()
+}
+
+// openLogOutputs will set up the log file, syslog, and journal outputs requested, if any, to log to along with stderr.
+// stdoutUse describes what the command writes to stdout for another program to read, if anything, which the logs are
+// never interleaved with.
+func openLogOutputs(stdoutUse string) error {
+	closeLogOutputs()
+
+	facility, ok := syslogFacilities[strings.ToLower(syslogFacility)]
+	if !ok {
+		return fmt.Errorf("invalid syslog facility %s", syslogFacility)
+	}
+	// Containers collect what is written to stdout, best as one JSON object per line
+	if logFormat == "" {
+		logFormat = "text"
+		if containerMode {
+			logFormat = "json"
+		}
+	}
+	if logOutput == "" {
+		logOutput = "stderr"
+		if containerMode && stdoutUse == "" {
+			logOutput = "stdout"
+		}
+	}
+	if logFormat != "text" && logFormat != "json" {
+		return fmt.Errorf("invalid log format %s, expected text or json", logFormat)
+	}
+	if logOutput != "stderr" && logOutput != "stdout" {
+		return fmt.Errorf("invalid log output %s, expected stderr or stdout", logOutput)
+	}
+	if logOutput == "stdout" && stdoutUse != "" {
+		return fmt.Errorf("the logs can not be written to stdout along with %s, provide --logOutput stderr", stdoutUse)
+	}
+	if logFileKeep < 0 {
+		return fmt.Errorf("the number of rotated log files to keep must be greater than or equal to 0, was given %d", logFileKeep)
+	}
+
+	if logFile != "" {
+		w, err := logfile.New(logFile, int64(logFileMaxSize*humanize.MiByte), logFileRotate, logFileKeep)
+		if err != nil {
+			return fmt.Errorf("could not open the log file %s - %v", logFile, err)
+		}
+		logWriter = w
+	}
+
+	if logSyslog {
+		w, err := dialSyslog(facility)
+		if err != nil {
+			closeLogOutputs()
+			return fmt.Errorf("could not connect to syslog - %v", err)
+		}
+		syslogWriter = w
+	}
+
+	if logJournald {
+		j, err := journald.Open(journald.SocketPath)
+		if err != nil {
+			closeLogOutputs()
+			return fmt.Errorf("could not connect to the systemd journal - %v", err)
+		}
+		journal = j
+	}
+
+	setLogBackend(consoleLogs())
+	return nil
+}
+
+// dialSyslog will connect to the syslog server at syslogAddr, or the local syslog daemon.
+func dialSyslog(facility syslog.Priority) (*syslog.Writer, error) {
+	if syslogAddr == "" {
+		return syslog.New(facility|syslog.LOG_INFO, syslogTag)
+	}
+	u, err := url.Parse(syslogAddr)
+	if err != nil || u.Host == "" || (u.Scheme != "udp" && u.Scheme != "tcp") {
+		return nil, fmt.Errorf("invalid syslog address %s, expected udp://host:port or tcp://host:port", syslogAddr)
+	}
+	return syslog.Dial(u.Scheme, u.Host, facility|syslog.LOG_INFO, syslogTag)
+}
+
+// closeLogOutputs will close any log file, syslog, or journal output and only log to stderr again.
+func closeLogOutputs() {
+	if logWriter != nil {
+		logWriter.Close()
+		logWriter = nil
+	}
+	if syslogWriter != nil {
+		syslogWriter.Close()
+		syslogWriter = nil
+	}
+	if journal != nil {
+		journal.Close()
+		journal = nil
+	}
+	setLogBackend(os.Stderr)
+}
+
+// machineReadableStdout will describe what the command writes to stdout for another program to read, if anything.
+func machineReadableStdout(cmd *cobra.Command) string {
+	switch {
+	case cmd == catCmd:
+		return "the zfs send stream of cat"
+	case progressJSON == "-":
+		return "--progressJSON -"
+	case helpers.JSONOutput:
+		return "--jsonOutput"
+	}
+	return ""
+}
+
+// consoleLogs will return the --logOutput the logs are written to, or nil if the logs are sent to the journal it is
+// connected to anyway.
+func consoleLogs() io.Writer {
+	w := os.Stderr
+	if logOutput == "stdout" {
+		w = os.Stdout
+	}
+	if journal != nil && journald.StreamConnected(w) {
+		return nil
+	}
+	return w
+}
+
+// setLogBackend will have the logs written to w, if not nil, and to the other outputs set up.
+func setLogBackend(w io.Writer) {
+	var backends []logging.Backend
+	if w != nil {
+		if logFormat == "json" {
+			backends = append(backends, &jsonBackend{w: w})
+		} else {
+			backends = append(backends, logging.NewLogBackend(w, "", log.LstdFlags))
+		}
+	}
+	if logWriter != nil {
+		if logFormat == "json" {
+			backends = append(backends, &jsonBackend{w: logWriter})
+		} else {
+			backends = append(backends, logging.NewBackendFormatter(logging.NewLogBackend(logWriter, "", 0), logFileFormat))
+		}
+	}
+	if syslogWriter != nil {
+		backends = append(backends, &logging.SyslogBackend{Writer: syslogWriter})
+	}
+	if journal != nil {
+		backends = append(backends, &journalBackend{journal: journal})
+	}
+	helpers.SetLogBackend(logging.MultiLogger(backends...))
+}
+
+// journalBackend sends every log record to the systemd journal along with where it was logged from.
+type journalBackend struct {
+	journal *journald.Journal
+}
+
+func (b *journalBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	fields := map[string]string{
+		"MESSAGE":           rec.Message(),
+		"PRIORITY":          strconv.Itoa(int(journalPriorities[level])),
+		"SYSLOG_IDENTIFIER": syslogTag,
+		"SYSLOG_FACILITY":   strconv.Itoa(int(syslogFacilities[strings.ToLower(syslogFacility)] >> 3)),
+	}
+	logFields := helpers.FieldsOf(rec)
+	for name, value := range map[string]string{
+		"ZFSBACKUP_DATASET":     logFields.Dataset,
+		"ZFSBACKUP_DESTINATION": logFields.Destination,
+		"ZFSBACKUP_VOLUME":      logFields.Volume,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	if logFields.Bytes > 0 {
+		fields["ZFSBACKUP_BYTES"] = strconv.FormatUint(logFields.Bytes, 10)
+	}
+	if logFields.Err != nil {
+		fields["ZFSBACKUP_ERROR"] = logFields.Err.Error()
+	}
+	if pc, file, line, ok := runtime.Caller(calldepth + 1); ok {
+		fields["CODE_FILE"] = file
+		fields["CODE_LINE"] = strconv.Itoa(line)
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			fields["CODE_FUNC"] = fn.Name()
+		}
+	}
+	return b.journal.Send(fields)
+}
+
+// jsonLine is a line of the logs written by a jsonBackend.
+type jsonLine struct {
+	Time  string `json:"ts"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+	helpers.LogFields
+	Err string `json:"err,omitempty"`
+}
+
+// jsonBackend writes every log record as a line of JSON along with its fields.
+type jsonBackend struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (b *jsonBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	line := jsonLine{
+		Time:      rec.Time.Format(time.RFC3339Nano),
+		Level:     strings.ToLower(level.String()),
+		Msg:       rec.Message(),
+		LogFields: helpers.FieldsOf(rec),
+	}
+	if line.LogFields.Err != nil {
+		line.Err = line.LogFields.Err.Error()
+	}
+	j, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err = b.w.Write(append(j, '\n'))
+	return err
+}
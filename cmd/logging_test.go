@@ -0,0 +1,70 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestLogOutput(t *testing.T) {
+	defer resetContainerFlags()
+	defer resetRootFlags()
+
+	testCases := []struct {
+		container   bool
+		jsonOutput  bool
+		progress    string
+		cat         bool
+		logOutput   string
+		expect      string
+		expectError bool
+	}{
+		{false, false, "", false, "", "stderr", false},
+		{true, false, "", false, "", "stdout", false},
+		{true, true, "", false, "", "stderr", false},
+		{true, false, "-", false, "", "stderr", false},
+		{true, false, "/run/progress.fifo", false, "", "stdout", false},
+		{true, false, "", true, "", "stderr", false},
+		{false, false, "", false, "stdout", "stdout", false},
+		{false, true, "", false, "stdout", "", true},
+		{true, false, "-", false, "stdout", "", true},
+		{false, false, "", true, "stdout", "", true},
+	}
+
+	for idx, c := range testCases {
+		resetLoggingFlags()
+		containerMode, helpers.JSONOutput, progressJSON, logOutput = c.container, c.jsonOutput, c.progress, c.logOutput
+		cmd := sendCmd
+		if c.cat {
+			cmd = catCmd
+		}
+		err := openLogOutputs(machineReadableStdout(cmd))
+		if (err != nil) != c.expectError {
+			t.Errorf("%d: expected error %v, got %v", idx, c.expectError, err)
+			continue
+		}
+		if !c.expectError && logOutput != c.expect {
+			t.Errorf("%d: expected the logs to be written to %s, got %s", idx, c.expect, logOutput)
+		}
+	}
+}
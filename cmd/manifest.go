@@ -0,0 +1,352 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var manifestDir string
+
+// manifestCmd represents the manifest command
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "manifest will export, import, validate, or roll back the manifests describing the backup sets found at a target.",
+	Long: `manifest will export, import, validate, or roll back the manifests describing the backup sets found at a target.
+
+Exported manifests are decrypted and decompressed JSON files that can be audited offline, kept as
+a copy of the metadata of a target, edited, and imported back to the same or another target, e.g.
+when migrating backup sets between destinations or recovering lost or damaged manifests.
+
+Every manifest uploaded is also kept as a record in the history of the manifest, which is never
+replaced nor deleted, so a manifest replaced by a buggy run or an attacker can be rolled back.`,
+}
+
+// manifestExportCmd represents the manifest export command
+var manifestExportCmd = &cobra.Command{
+	Use:     "export [flags] uri directory",
+	Short:   "export will write every manifest found at the provided target as a JSON file to a local directory.",
+	Long:    `export will write every manifest found at the provided target, decrypted and decompressed, as a JSON file to a local directory.`,
+	PreRunE: validateManifestExportFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return backup.ExportManifests(context.Background(), &jobInfo, manifestDir)
+	},
+}
+
+// manifestImportCmd represents the manifest import command
+var manifestImportCmd = &cobra.Command{
+	Use:   "import [flags] directory uri(s)",
+	Short: "import will upload every JSON manifest found in a local directory to the provided target(s).",
+	Long: `import will upload every JSON manifest found in a local directory to the provided target(s).
+
+Every manifest is compressed, encrypted, and signed according to the options provided before being
+uploaded, replacing any manifest of the same backup set already found at a target. Provide the same
+encryption and signing options the backup sets were sent with so their manifests keep their names.`,
+	PreRunE: validateManifestImportFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return backup.ImportManifests(context.Background(), &jobInfo, manifestDir)
+	},
+}
+
+// manifestHistoryCmd represents the manifest history command
+var manifestHistoryCmd = &cobra.Command{
+	Use:     "history [flags] uri [manifest]",
+	Short:   "history will list the records kept in the history of the manifests found at the provided target.",
+	Long:    `history will list the records kept in the history of the manifests found at the provided target, or of the manifest named, oldest first.`,
+	PreRunE: validateManifestHistoryFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var manifest string
+		if len(args) > 1 {
+			manifest = args[1]
+		}
+		records, err := backup.ManifestHistory(context.Background(), &jobInfo, manifest)
+		if err != nil {
+			return err
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(records)
+		}
+		table := helpers.NewTable("MANIFEST", "WRITTEN", "RECORD")
+		for _, record := range records {
+			table.Row(record.Manifest, record.Written.Local().Format(time.RFC3339Nano), record.ObjectName)
+		}
+		table.WriteTo(helpers.Stdout)
+		return nil
+	},
+}
+
+// manifestRollbackCmd represents the manifest rollback command
+var manifestRollbackCmd = &cobra.Command{
+	Use:   "rollback [flags] uri record",
+	Short: "rollback will replace a manifest found at the provided target with a record kept in its history.",
+	Long: `rollback will replace a manifest found at the provided target with a record kept in its history, as listed by the history command.
+
+The manifest replaced is kept in its history, so a rollback can itself be rolled back. Provide the same
+encryption and signing options the backup set was sent with so its manifest keeps its name.`,
+	PreRunE: validateManifestRollbackFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return backup.RollbackManifest(context.Background(), &jobInfo, args[1])
+	},
+}
+
+// manifestValidateCmd represents the manifest validate command
+var manifestValidateCmd = &cobra.Command{
+	Use:   "validate [flags] file|uri",
+	Short: "validate will check a manifest file, or every manifest found at the provided target, against the manifest schema.",
+	Long: `validate will check a manifest file, or every manifest found at the provided target, against the manifest schema.
+
+The file is either a JSON manifest, as exported (e.g. one edited before being imported back), or a
+manifest as stored at a target, decrypted and decompressed according to the options provided. Every
+way a manifest does not conform to the schema, as printed by the schema command, is listed.
+
+The command fails unless every manifest conforms to the schema.`,
+	PreRunE: validateManifestValidateFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		var validations []*backup.ManifestValidation
+		if len(jobInfo.Destinations) > 0 {
+			var err error
+			if validations, err = backup.ValidateManifests(ctx, &jobInfo); err != nil {
+				return err
+			}
+		} else {
+			validation, err := backup.ValidateManifestFile(ctx, &jobInfo, args[0])
+			if err != nil {
+				return err
+			}
+			validations = append(validations, validation)
+		}
+
+		var invalid int
+		for _, validation := range validations {
+			if !validation.Valid {
+				invalid++
+			}
+		}
+
+		if helpers.JSONOutput {
+			if err := printJSON(validations); err != nil {
+				return err
+			}
+		} else {
+			table := helpers.NewTable("RESULT", "MANIFEST", "PROBLEM")
+			for _, validation := range validations {
+				if validation.Valid {
+					table.Row("VALID", validation.Manifest, "")
+				}
+				for _, problem := range validation.Problems {
+					table.Row("INVALID", validation.Manifest, problem)
+				}
+			}
+			table.WriteTo(helpers.Stdout)
+		}
+
+		if invalid > 0 {
+			// The problems already describe what is wrong
+			cmd.SilenceUsage = true
+			err := fmt.Errorf("%d of %d manifests do not conform to the manifest schema", invalid, len(validations))
+			helpers.AppLogger.Errorf("The manifests are not valid - %v", err)
+			return err
+		}
+		helpers.AppLogger.Noticef("Every manifest conforms to the manifest schema.")
+		return nil
+	},
+}
+
+// manifestFormatCmd represents the manifest format command
+var manifestFormatCmd = &cobra.Command{
+	Use:   "format [flags] uri(s)",
+	Short: "format will report the format of the manifests found at every provided target.",
+	Long: `format will report the format of the manifests found at every provided target.
+
+Manifests are either of the native format, recording the schema they were written with, or of the
+upstream format written by the upstream releases of zfsbackup-go, which do not record one. A target
+holding any manifest of the native format is reported as native. This is the format --manifestFormat
+auto writes the manifests of a target in.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			cmd.Usage()
+			return errInvalidInput
+		}
+		return validateManifestDestinations(strings.Split(args[0], ","))
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		formats, err := backup.DetectManifestFormats(context.Background(), &jobInfo)
+		if err != nil {
+			return err
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(formats)
+		}
+		table := helpers.NewTable("DESTINATION", "FORMAT", "NATIVE", "UPSTREAM")
+		for _, format := range formats {
+			name := format.Format
+			if name == "" {
+				name = "-"
+			}
+			table.Row(format.Destination, name, strconv.Itoa(format.Native), strconv.Itoa(format.Upstream))
+		}
+		_, err = table.WriteTo(helpers.Stdout)
+		return err
+	},
+}
+
+// manifestSchemaCmd represents the manifest schema command
+var manifestSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "schema will print the JSON Schema of the manifests.",
+	Long: `schema will print the JSON Schema (draft-07) of the manifests written by this version of zfsbackup, as exported
+by the export command, for external tools to check or read them with.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, err := fmt.Fprint(helpers.Stdout, helpers.ManifestJSONSchema)
+		return err
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(manifestCmd)
+	manifestCmd.AddCommand(manifestExportCmd)
+	manifestCmd.AddCommand(manifestImportCmd)
+	manifestCmd.AddCommand(manifestHistoryCmd)
+	manifestCmd.AddCommand(manifestRollbackCmd)
+	manifestCmd.AddCommand(manifestValidateCmd)
+	manifestCmd.AddCommand(manifestSchemaCmd)
+	manifestCmd.AddCommand(manifestFormatCmd)
+
+	manifestImportCmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed upload. Use 0 for no limit.")
+	manifestImportCmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an upload.")
+	manifestImportCmd.Flags().IntVar(&jobInfo.UploadChunkSize, "uploadChunkSize", 10, "the chunk size, in MiB, to use when uploading. A minimum of 5MiB and maximum of 100MiB is enforced.")
+	manifestRollbackCmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed upload. Use 0 for no limit.")
+	manifestRollbackCmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an upload.")
+	manifestRollbackCmd.Flags().IntVar(&jobInfo.UploadChunkSize, "uploadChunkSize", 10, "the chunk size, in MiB, to use when uploading. A minimum of 5MiB and maximum of 100MiB is enforced.")
+}
+
+// ResetManifestJobInfo exists solely for integration testing
+func ResetManifestJobInfo() {
+	resetRootFlags()
+	manifestDir = ""
+	jobInfo.MaxRetryTime = 12 * time.Hour
+	jobInfo.MaxBackoffTime = 30 * time.Minute
+	jobInfo.UploadChunkSize = 10
+}
+
+func validateManifestExportFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	if err := validateManifestDestinations([]string{args[0]}); err != nil {
+		return err
+	}
+
+	var err error
+	if manifestDir, err = filepath.Abs(args[1]); err != nil {
+		helpers.AppLogger.Errorf("Invalid directory provided, was given %s - %v", args[1], err)
+		return errInvalidInput
+	}
+	return nil
+}
+
+func validateManifestImportFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	var err error
+	if manifestDir, err = filepath.Abs(args[0]); err != nil {
+		helpers.AppLogger.Errorf("Invalid directory provided, was given %s - %v", args[0], err)
+		return errInvalidInput
+	}
+
+	return validateManifestDestinations(strings.Split(args[1], ","))
+}
+
+func validateManifestHistoryFlags(cmd *cobra.Command, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	return validateManifestDestinations([]string{args[0]})
+}
+
+func validateManifestRollbackFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	if _, _, ok := helpers.ParseManifestHistoryObjectName(args[1]); !ok {
+		helpers.AppLogger.Errorf("Not the record of a manifest, was given %s", args[1])
+		return errInvalidInput
+	}
+
+	return validateManifestDestinations([]string{args[0]})
+}
+
+func validateManifestValidateFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	// Anything that is not a destination URI is a local file
+	if _, err := backends.GetBackendForURI(args[0]); err != nil {
+		if _, serr := os.Stat(args[0]); serr != nil {
+			helpers.AppLogger.Errorf("Not a destination URI nor a manifest file, was given %s - %v", args[0], serr)
+			return errInvalidInput
+		}
+		return nil
+	}
+	return validateManifestDestinations([]string{args[0]})
+}
+
+func validateManifestDestinations(destinations []string) error {
+	for _, destination := range destinations {
+		_, err := backends.GetBackendForURI(destination)
+		if err == backends.ErrInvalidPrefix {
+			helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", destination)
+			return errInvalidInput
+		} else if err == backends.ErrInvalidURI {
+			helpers.AppLogger.Errorf("Invalid destination URI, was given %s", destination)
+			return errInvalidInput
+		}
+	}
+	jobInfo.Destinations = destinations
+	return nil
+}
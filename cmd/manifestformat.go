@@ -0,0 +1,42 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package cmd
+
+import (
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&jobInfo.ManifestFormat, "manifestFormat", helpers.ManifestFormatNative, "the format the manifests are written in: native, upstream for manifests the upstream releases of zfsbackup-go can read too (no history of the manifests is kept), or auto for the format of the manifests already found at the destinations. Manifests of both formats are always read.")
+}
+
+func resetManifestFormatFlags() {
+	jobInfo.ManifestFormat = helpers.ManifestFormatNative
+}
+
+// validateManifestFormat will check the format provided with --manifestFormat is supported.
+func validateManifestFormat() error {
+	switch jobInfo.ManifestFormat {
+	case helpers.ManifestFormatNative, helpers.ManifestFormatUpstream, helpers.ManifestFormatAuto:
+		return nil
+	}
+	helpers.AppLogger.Errorf("Invalid manifest format provided, expected one of %s, %s, or %s, got %s instead", helpers.ManifestFormatNative, helpers.ManifestFormatUpstream, helpers.ManifestFormatAuto, jobInfo.ManifestFormat)
+	return errInvalidInput
+}
@@ -0,0 +1,75 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+)
+
+// migrateManifestsCmd represents the migrate-manifests command
+var migrateManifestsCmd = &cobra.Command{
+	Use:   "migrate-manifests [flags] uri",
+	Short: "migrate-manifests will upgrade the manifests found at a target to the current manifest schema.",
+	Long: `migrate-manifests will upgrade the manifests found at a target to the current manifest schema.
+
+Manifests written by older versions of zfsbackup are always readable, they are migrated to the current
+schema as they are read, but are left as they are at the target. This command uploads every manifest of
+an older schema, migrated, in place of the original one. Provide the same encryption and signing options
+the backup sets were sent with so their manifests keep their names. Manifests of a newer schema than this
+version of zfsbackup supports can't be read and fail the migration.`,
+	SilenceErrors: true,
+	PreRunE:       validateMigrateManifestsFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return backup.MigrateManifests(context.Background(), &jobInfo, dryRun)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(migrateManifestsCmd)
+
+	migrateManifestsCmd.Flags().BoolVar(&dryRun, "dryRun", false, "only report the manifests that would be migrated.")
+	migrateManifestsCmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed upload. Use 0 for no limit.")
+	migrateManifestsCmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an upload.")
+	migrateManifestsCmd.Flags().IntVar(&jobInfo.UploadChunkSize, "uploadChunkSize", 10, "the chunk size, in MiB, to use when uploading. A minimum of 5MiB and maximum of 100MiB is enforced.")
+}
+
+// ResetMigrateManifestsJobInfo exists solely for integration testing
+func ResetMigrateManifestsJobInfo() {
+	resetRootFlags()
+	dryRun = false
+	jobInfo.MaxRetryTime = 12 * time.Hour
+	jobInfo.MaxBackoffTime = 30 * time.Minute
+	jobInfo.UploadChunkSize = 10
+}
+
+func validateMigrateManifestsFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	return validateManifestDestinations(args)
+}
@@ -0,0 +1,138 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/archivefs"
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var mountAllowOther bool
+
+// mountCmd represents the mount command
+var mountCmd = &cobra.Command{
+	Use:   "mount [flags] uri mountpoint",
+	Short: "mount will expose the backup sets found at the provided target as a read-only filesystem.",
+	Long: `mount will expose the backup sets found at the provided target as a read-only filesystem.
+
+Every dataset is a directory holding a directory per backup set, named @snapshot for a full
+backup or @incremental-to-@snapshot for an incremental backup, with the manifest of the
+backup set (manifest.json) and its volumes (vol1.zstream, vol2.zstream, ...). A volume is
+downloaded, verified, decrypted, and decompressed when opened, reading it returns its part of
+the original zfs send stream. The filesystem is served until it is unmounted or interrupted.`,
+	PreRunE: validateMountFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		archive, err := backup.OpenArchive(ctx, &jobInfo)
+		if err != nil {
+			return err
+		}
+		defer archive.Close()
+
+		sets, err := archive.BackupSets(ctx)
+		if err != nil {
+			return err
+		}
+
+		filesys, err := archivefs.New(sets, archive)
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not build the filesystem - %v", err)
+			return err
+		}
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+		go func() {
+			select {
+			case sig := <-sigs:
+				helpers.AppLogger.Noticef("Received %v, unmounting %s.", sig, args[1])
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		var options []fuse.MountOption
+		if mountAllowOther {
+			options = append(options, fuse.AllowOther())
+		}
+
+		helpers.AppLogger.Noticef("Serving %d backup sets from %s at %s", len(sets), args[0], args[1])
+		if err = archivefs.Mount(ctx, filesys, args[1], options...); err != nil {
+			helpers.AppLogger.Errorf("Could not mount the backup sets at %s due to error - %v", args[1], err)
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(mountCmd)
+
+	mountCmd.Flags().BoolVar(&mountAllowOther, "allowOther", false, "allow other users to access the filesystem, requires user_allow_other in /etc/fuse.conf when not run as root.")
+	mountCmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed download. Use 0 for no limit.")
+	mountCmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an download.")
+}
+
+// ResetMountJobInfo exists solely for integration testing
+func ResetMountJobInfo() {
+	resetRootFlags()
+	mountAllowOther = false
+	jobInfo.MaxRetryTime = 12 * time.Hour
+	jobInfo.MaxBackoffTime = 30 * time.Minute
+}
+
+func validateMountFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	_, err := backends.GetBackendForURI(args[0])
+	if err == backends.ErrInvalidPrefix {
+		helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", args[0])
+		return errInvalidInput
+	} else if err == backends.ErrInvalidURI {
+		helpers.AppLogger.Errorf("Invalid destination URI, was given %s", args[0])
+		return errInvalidInput
+	}
+	jobInfo.Destinations = []string{args[0]}
+
+	if info, serr := os.Stat(args[1]); serr != nil || !info.IsDir() {
+		helpers.AppLogger.Errorf("The mountpoint %s must be an existing directory", args[1])
+		return errInvalidInput
+	}
+
+	return nil
+}
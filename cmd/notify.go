@@ -0,0 +1,102 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+	"github.com/someone1/zfsbackup-go/notify"
+)
+
+var (
+	notifyOn       string
+	notifyTemplate string
+	notifyWebhook  string
+	notifySlack    string
+	notifyEmail    string
+	smtpServer     string
+	smtpFrom       string
+	smtpUsername   string
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&notifyOn, "notifyOn", "failure,degraded", "a comma separated list of the job outcomes to send notifications for. Possible values are success, failure, and degraded (succeeded after retrying).")
+	RootCmd.PersistentFlags().StringVar(&notifyTemplate, "notifyTemplate", "", "the path to a Go text/template file used to render notification messages, see the README for the fields available.")
+	RootCmd.PersistentFlags().StringVar(&notifyWebhook, "notifyWebhook", "", "a URL to POST a JSON description of the job and the notification message to.")
+	RootCmd.PersistentFlags().StringVar(&notifySlack, "notifySlack", "", "a Slack compatible incoming webhook URL to post the notification message to.")
+	RootCmd.PersistentFlags().StringVar(&notifyEmail, "notifyEmail", "", "a comma separated list of email addresses to mail the notification message to, requires smtpServer and smtpFrom.")
+	RootCmd.PersistentFlags().StringVar(&smtpServer, "smtpServer", "", "the SMTP server (host:port) to send notification emails through.")
+	RootCmd.PersistentFlags().StringVar(&smtpFrom, "smtpFrom", "", "the email address notification emails are sent from.")
+	RootCmd.PersistentFlags().StringVar(&smtpUsername, "smtpUsername", "", "the username to authenticate to the SMTP server with, the password is read from the SMTP_PASSWORD environmental variable.")
+}
+
+func resetNotifyFlags() {
+	notifyOn = "failure,degraded"
+	notifyTemplate = ""
+	notifyWebhook = ""
+	notifySlack = ""
+	notifyEmail = ""
+	smtpServer = ""
+	smtpFrom = ""
+	smtpUsername = ""
+}
+
+// newNotifier will return a Notifier for the notification options provided, or nil if
+// no notifications were requested.
+func newNotifier() (*notify.Notifier, error) {
+	var senders []notify.Sender
+	if notifyWebhook != "" {
+		senders = append(senders, notify.NewWebhook(notifyWebhook))
+	}
+	if notifySlack != "" {
+		senders = append(senders, notify.NewSlack(notifySlack))
+	}
+	if notifyEmail != "" {
+		if smtpServer == "" || smtpFrom == "" {
+			helpers.AppLogger.Errorf("You must specify an SMTP server and from address to send notification emails")
+			return nil, errInvalidInput
+		}
+		senders = append(senders, notify.NewEmail(smtpServer, smtpFrom, strings.Split(notifyEmail, ","), smtpUsername, os.Getenv("SMTP_PASSWORD")))
+	}
+	if len(senders) == 0 {
+		return nil, nil
+	}
+
+	var tmpl string
+	if notifyTemplate != "" {
+		b, err := ioutil.ReadFile(notifyTemplate)
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not read notification template %s due to error - %v", notifyTemplate, err)
+			return nil, err
+		}
+		tmpl = string(b)
+	}
+
+	n, err := notify.NewNotifier(tmpl, strings.Split(notifyOn, ","), senders...)
+	if err != nil {
+		helpers.AppLogger.Errorf("Invalid notification options - %v", err)
+		return nil, err
+	}
+	return n, nil
+}
@@ -0,0 +1,180 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+	"github.com/someone1/zfsbackup-go/pinentry"
+)
+
+// passphraseAttempts is how many times the passphrase of a key is prompted for before giving up.
+const passphraseAttempts = 3
+
+var (
+	pinentryPath          string
+	encryptPassphraseFile string
+	signPassphraseFile    string
+
+	// The passphrases that unlocked a key so far, tried on the next keys before prompting again
+	passphrases [][]byte
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&pinentryPath, "pinentry", "", "the pinentry program (e.g. pinentry-curses or pinentry-gnome3) to prompt for the passphrase of an encrypted PGP key with when it is not provided by the PGP_PASSPHRASE environmental variable, on the GPG_TTY terminal or DISPLAY. It is read from the terminal if not provided.")
+	RootCmd.PersistentFlags().StringVar(&encryptPassphraseFile, "encryptPassphraseFile", "", "the path of a file holding the passphrase of the --encryptTo key, instead of the PGP_ENCRYPT_PASSPHRASE or PGP_PASSPHRASE environmental variables.")
+	RootCmd.PersistentFlags().StringVar(&signPassphraseFile, "signPassphraseFile", "", "the path of a file holding the passphrase of the --signFrom key, instead of the PGP_SIGN_PASSPHRASE or PGP_PASSPHRASE environmental variables.")
+}
+
+func resetPassphraseFlags() {
+	pinentryPath = ""
+	encryptPassphraseFile = ""
+	signPassphraseFile = ""
+	passphrases = nil
+}
+
+// keyPassphrase will return the passphrase provided for a single key, read from the file if any, or from the
+// environmental variable. It is nil if neither is provided.
+func keyPassphrase(env, file string) ([]byte, error) {
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the passphrase file %s - %v", file, err)
+		}
+		return []byte(strings.TrimRight(string(data), "\r\n")), nil
+	}
+	if value := os.Getenv(env); value != "" {
+		return []byte(value), nil
+	}
+	return nil, nil
+}
+
+// unlockKey will decrypt the private key and subkeys of the entity, trying the passphrase provided for it, if
+// any, then PGP_PASSPHRASE and the passphrases that unlocked other keys before prompting for one.
+func unlockKey(e *openpgp.Entity, own []byte) error {
+	keys := []*packet.PrivateKey{e.PrivateKey}
+	for _, subkey := range e.Subkeys {
+		keys = append(keys, subkey.PrivateKey)
+	}
+
+	for _, k := range keys {
+		if k == nil || !k.Encrypted {
+			continue
+		}
+		if len(own) != 0 && k.Decrypt(own) == nil {
+			continue
+		}
+		if err := unlockPrivateKey(e, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unlockPrivateKey(e *openpgp.Entity, k *packet.PrivateKey) error {
+	if len(passphrases) == 0 && len(passphrase) != 0 {
+		passphrases = append(passphrases, passphrase)
+	}
+	for _, p := range passphrases {
+		if k.Decrypt(p) == nil {
+			return nil
+		}
+	}
+
+	description := fmt.Sprintf("Enter the passphrase to unlock the PGP key %s", k.KeyIdShortString())
+	for name := range e.Identities {
+		description = fmt.Sprintf("Enter the passphrase to unlock the PGP key %s of %s", k.KeyIdShortString(), name)
+		break
+	}
+
+	var wrong string
+	for attempt := 0; attempt < passphraseAttempts; attempt++ {
+		p, err := promptPassphrase(description, wrong)
+		if err != nil {
+			return err
+		}
+		if err = k.Decrypt(p); err == nil {
+			passphrases = append(passphrases, p)
+			return nil
+		}
+		helpers.AppLogger.Warningf("Could not unlock the PGP key %s - %v", k.KeyIdShortString(), err)
+		wrong = "Wrong passphrase, please try again."
+	}
+	return fmt.Errorf("could not unlock the PGP key %s after %d attempts", k.KeyIdShortString(), passphraseAttempts)
+}
+
+// promptPassphrase will ask for a passphrase with the --pinentry program, or on the terminal otherwise.
+func promptPassphrase(description, wrong string) ([]byte, error) {
+	if containerMode {
+		return nil, fmt.Errorf("passphrases are not prompted for in --container mode, provide it as the PGP_PASSPHRASE secret in %s", secretsDir)
+	}
+
+	if pinentryPath != "" {
+		return pinentry.GetPIN(context.Background(), pinentryPath, pinentry.Prompt{
+			Title:       helpers.ProgramName,
+			Description: description,
+			Prompt:      "Passphrase:",
+			Error:       wrong,
+			TTYName:     gpgTTY(),
+			TTYType:     os.Getenv("TERM"),
+			Display:     os.Getenv("DISPLAY"),
+		})
+	}
+
+	// Prompt on the controlling terminal, stdin may be piped in
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to prompt for the passphrase on, provide it with the PGP_PASSPHRASE environmental variable or prompt for it with --pinentry - %v", err)
+	}
+	defer tty.Close()
+
+	if wrong != "" {
+		fmt.Fprintln(tty, wrong)
+	}
+	fmt.Fprintf(tty, "%s: ", description)
+	p, err := terminal.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	return p, err
+}
+
+// gpgTTY will return the terminal to prompt on as GnuPG would, GPG_TTY or the terminal of stdin.
+func gpgTTY() string {
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		return tty
+	}
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		if tty, err := os.Readlink("/proc/self/fd/0"); err == nil {
+			return tty
+		}
+		// Without procfs (e.g. macOS), the controlling terminal is the one of stdin
+		return "/dev/tty"
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// privateDirMode is the mode the working directory and the temp and cache directories in it are kept at, as
+// staged volumes and cached manifests may hold sensitive data.
+const privateDirMode os.FileMode = 0700
+
+var umask string
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&umask, "umask", "", "the umask (in octal, e.g. 077) to create files with, including the volumes written to the file destinations. It is left as is if not provided.")
+}
+
+func resetPermissionFlags() {
+	umask = ""
+}
+
+// applyUmask will set the umask of the process to the one provided with --umask, if any.
+func applyUmask() error {
+	if umask == "" {
+		return nil
+	}
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0777 {
+		return fmt.Errorf("invalid umask %s, expected an octal value between 000 and 777", umask)
+	}
+	syscall.Umask(int(mask))
+	return nil
+}
+
+// ensurePrivateDir will create the directory at path, described by name in the logs, only accessible by us.
+// An existing directory must be owned by us and is made only accessible by us if it is not already.
+func ensurePrivateDir(path, name string) error {
+	dir, serr := os.Stat(path)
+	if os.IsNotExist(serr) {
+		if err := os.Mkdir(path, privateDirMode); err != nil {
+			helpers.AppLogger.Errorf("Could not create %s %s due to error - %v", name, path, err)
+			return err
+		}
+		return nil
+	} else if serr != nil {
+		helpers.AppLogger.Errorf("Could not check %s %s due to error - %v", name, path, serr)
+		return serr
+	}
+
+	if !dir.IsDir() {
+		helpers.AppLogger.Errorf("Cannot create %s because another non-directory object already exists in that path (%s)", name, path)
+		return errInvalidInput
+	}
+
+	if stat, ok := dir.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		helpers.AppLogger.Errorf("The %s %s is owned by uid %d, not by the user running zfsbackup (uid %d). Change its owner or use another --workingDirectory.", name, path, stat.Uid, os.Geteuid())
+		return errInvalidInput
+	}
+
+	if mode := dir.Mode().Perm(); mode&^privateDirMode != 0 {
+		if err := os.Chmod(path, privateDirMode); err != nil {
+			helpers.AppLogger.Errorf("Could not restrict the permissions of %s %s from %#o to %#o due to error - %v", name, path, mode, privateDirMode, err)
+			return err
+		}
+		helpers.AppLogger.Warningf("Restricted the permissions of %s %s from %#o to %#o, it may hold sensitive data.", name, path, mode, privateDirMode)
+	}
+	return nil
+}
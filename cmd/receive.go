@@ -21,8 +21,10 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -33,19 +35,31 @@ import (
 	"github.com/someone1/zfsbackup-go/helpers"
 )
 
+var (
+	restoreAtStr string
+	remaps       []string
+	remapFile    string
+)
+
 // receiveCmd represents the receive command
 var receiveCmd = &cobra.Command{
-	Use:     "receive [flags] filesystem|volume|snapshot-to-restore uri local_volume",
+	Use:     "receive [flags] filesystem|volume|snapshot-to-restore uri [local_volume]",
 	Short:   "receive will restore a snapshot of a ZFS volume similar to how the \"zfs recv\" command works.",
 	Long:    `receive will restore a snapshot of a ZFS volume similar to how the "zfs recv" command works.`,
 	PreRunE: validateReceiveFlags,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		helpers.AppLogger.Infof("Limiting the number of active files to %d", jobInfo.MaxFileBuffer)
 
-		if jobInfo.AutoRestore {
-			return backup.AutoRestore(context.Background(), &jobInfo)
+		if dryRun {
+			return runDryRun(backup.PlanReceive)
 		}
-		return backup.Receive(context.Background(), &jobInfo)
+
+		return runJob("receive", func(ctx context.Context) error {
+			if jobInfo.AutoRestore {
+				return backup.AutoRestore(ctx, &jobInfo)
+			}
+			return backup.Receive(ctx, &jobInfo)
+		})
 	},
 }
 
@@ -53,14 +67,20 @@ func init() {
 	RootCmd.AddCommand(receiveCmd)
 
 	// ZFS recv command options
-	receiveCmd.Flags().BoolVar(&jobInfo.AutoRestore, "auto", false, "Automatically restore to the snapshot provided, or to the latest snapshot of the volume provided, cannot be used with the --incremental flag.")
+	receiveCmd.Flags().BoolVar(&jobInfo.AutoRestore, "auto", false, "Automatically restore to the snapshot provided, or to the latest snapshot of the volume provided, cannot be used with the --incremental flag. Implied by the latest and latest-full snapshot selectors (e.g. Tank/Dataset@latest).")
+	receiveCmd.Flags().StringVar(&restoreAtStr, "at", "", "restore to the latest snapshot of the volume provided taken at or before this date & time, along with the backup sets it is chained to (format: yyyy-MM-dd or yyyy-MM-ddTHH:mm:ss, parsed in local TZ, or RFC3339 e.g. 2024-03-01T00:00:00Z). Implies --auto.")
 	receiveCmd.Flags().BoolVarP(&jobInfo.FullPath, "fullPath", "d", false, "See the -d flag on zfs recv for more information")
 	receiveCmd.Flags().BoolVarP(&jobInfo.LastPath, "lastPath", "e", false, "See the -e flag for zfs recv for more information.")
+	receiveCmd.Flags().StringSliceVar(&remaps, "remap", nil, "a source=target pair of datasets, the volume restored is received under the target of the longest source containing it, keeping the rest of its path (e.g. tank/prod=backuppool/restore restores tank/prod/db to backuppool/restore/db). Can be repeated. The local_volume argument is then optional and -d/-e cannot be used.")
+	receiveCmd.Flags().StringVar(&remapFile, "remapFile", "", "a file of source=target pairs of datasets, one per line, to use along with any --remap flag. Empty lines and lines starting with # are ignored.")
 	receiveCmd.Flags().BoolVarP(&jobInfo.Force, "force", "F", false, "See the -F flag for zfs recv for more information.")
 	receiveCmd.Flags().BoolVarP(&jobInfo.NotMounted, "unmounted", "u", false, "See the -u flag for zfs recv for more information.")
 	receiveCmd.Flags().StringVarP(&jobInfo.Origin, "origin", "o", "", "See the -o flag on zfs recv for more information.")
 	receiveCmd.Flags().StringVarP(&jobInfo.IncrementalSnapshot.Name, "incremental", "i", "", "Used to specify the snapshot target to restore from.")
-	receiveCmd.Flags().IntVar(&jobInfo.MaxFileBuffer, "maxFileBuffer", 5, "the maximum number of files to have active during the upload process. Should be set to at least the number of max parallel uploads. Set to 0 to bypass local storage and upload straight to your destination - this will limit you to a single destination and disable any hash checks for the upload where available.")
+	receiveCmd.Flags().StringVar(&jobInfo.ToFile, "toFile", "", "write the zfs send stream to this local file instead of receiving it with zfs recv, the local_volume argument is then optional. When restoring more than one backup set with --auto, the number of every backup set, in the order they must be received, is added before the file extension (e.g. stream.1.zfs, stream.2.zfs).")
+	receiveCmd.Flags().BoolVar(&dryRun, "dryRun", false, "print the snapshots that would be restored, their size, the objects that would be downloaded, and the zfs recv commands that would run without executing anything. Hooks are not run.")
+	receiveCmd.Flags().IntVar(&jobInfo.MaxFileBuffer, "maxFileBuffer", 5, "the maximum number of volumes to download, in parallel, ahead of the one being received. When restoring more than one backup set with --auto, the volumes of the next backup sets are downloaded while the current one is received. Set to 0 to bypass local storage and stream every volume straight into zfs recv, one at a time.")
+	receiveCmd.Flags().BoolVar(&jobInfo.VerifyFirst, "verifyFirst", false, "download every volume of the backup sets to restore and verify its hash, signature, and that it can be decrypted and decompressed before receiving any of them, so a corrupt volume can't leave a restore half applied. Every volume is kept on the local disk until it is received and --maxFileBuffer only limits the parallel downloads.")
 	receiveCmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed download. Use 0 for no limit.")
 	receiveCmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an download.")
 	receiveCmd.Flags().StringVar(&jobInfo.Separator, "separator", "|", "the separator to use between object component names (used only for the initial manifest we are looking for).")
@@ -75,6 +95,15 @@ func ResetReceiveJobInfo() {
 	jobInfo.Force = false
 	jobInfo.NotMounted = false
 	jobInfo.Origin = ""
+	jobInfo.ToFile = ""
+	jobInfo.LocalVolume = ""
+	jobInfo.DiscoverManifests = false
+	jobInfo.VerifyFirst = false
+	restoreAtStr = ""
+	remaps = nil
+	remapFile = ""
+	jobInfo.RestoreAt = time.Time{}
+	dryRun = false
 	jobInfo.BaseSnapshot = helpers.SnapshotInfo{}
 	jobInfo.IncrementalSnapshot = helpers.SnapshotInfo{}
 	jobInfo.MaxFileBuffer = 5
@@ -84,18 +113,36 @@ func ResetReceiveJobInfo() {
 }
 
 func validateReceiveFlags(cmd *cobra.Command, args []string) error {
-	if len(args) != 3 {
+	remapping := len(remaps) > 0 || remapFile != ""
+	if len(args) != 3 && ((jobInfo.ToFile == "" && !remapping) || len(args) != 2) {
 		cmd.Usage()
 		return errInvalidInput
 	}
-	jobInfo.StartTime = time.Now()
 
 	parts := strings.Split(args[0], "@")
+	if restoreAtStr != "" {
+		if len(parts) != 1 {
+			helpers.AppLogger.Errorf("The --at flag selects the snapshot to restore, please only specify the volume to restore, do not include any snapshot information.")
+			return errInvalidInput
+		}
+
+		var err error
+		if jobInfo.RestoreAt, err = parseSearchTime(restoreAtStr); err != nil {
+			helpers.AppLogger.Errorf("could not parse at time '%s' due to error: %v", restoreAtStr, err)
+			return errInvalidInput
+		}
+		jobInfo.AutoRestore = true
+	}
+
 	if len(parts) != 2 && !jobInfo.AutoRestore {
 		helpers.AppLogger.Errorf("Invalid base snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
 		return errInvalidInput
 	} else if len(parts) == 2 {
 		jobInfo.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+		// Selecting the newest restore point implies an auto restore
+		if backup.IsLatestSelector(parts[1]) {
+			jobInfo.AutoRestore = true
+		}
 	}
 
 	if jobInfo.FullPath && jobInfo.LastPath {
@@ -105,7 +152,18 @@ func validateReceiveFlags(cmd *cobra.Command, args []string) error {
 
 	jobInfo.VolumeName = parts[0]
 	jobInfo.Destinations = strings.Split(args[1], ",")
-	jobInfo.LocalVolume = args[2]
+	// A local directory may have been copied from elsewhere, don't rely on the manifest prefix and separator to find the manifests
+	jobInfo.DiscoverManifests = strings.HasPrefix(jobInfo.Destinations[0], backends.FileBackendPrefix+"://")
+	if len(args) == 3 {
+		jobInfo.LocalVolume = args[2]
+	}
+
+	if remapping {
+		if err := remapLocalVolume(); err != nil {
+			helpers.AppLogger.Errorf("Could not remap %s - %v", jobInfo.VolumeName, err)
+			return errInvalidInput
+		}
+	}
 
 	// Intelligently restore to the snapshot wanted
 	if jobInfo.AutoRestore && jobInfo.IncrementalSnapshot.Name != "" {
@@ -113,9 +171,36 @@ func validateReceiveFlags(cmd *cobra.Command, args []string) error {
 		return errInvalidInput
 	}
 
+	if jobInfo.VerifyFirst && jobInfo.MaxFileBuffer == 0 {
+		helpers.AppLogger.Errorf("Cannot verify the volumes before restoring them without a file buffer, please set --maxFileBuffer to a value greater than 0.")
+		return errInvalidInput
+	}
+
 	// Remove 'origin=' from beggining of -o argument
 	jobInfo.Origin = strings.TrimPrefix(jobInfo.Origin, "origin=")
 
+	if jobInfo.ToFile != "" && jobInfo.Origin != "" {
+		helpers.AppLogger.Errorf("Cannot provide an origin when writing the stream to a file.")
+		return errInvalidInput
+	}
+
+	for _, destination := range jobInfo.Destinations {
+		_, err := backends.GetBackendForURI(destination)
+		if err == backends.ErrInvalidPrefix {
+			helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", destination)
+			return errInvalidInput
+		} else if err == backends.ErrInvalidURI {
+			helpers.AppLogger.Errorf("Invalid destination URI, was given %s", destination)
+			return errInvalidInput
+		}
+	}
+
+	// The preHook runs once nothing else can fail the validation of the job
+	if err := runPreHook("receive", args); err != nil {
+		return err
+	}
+	jobInfo.StartTime = time.Now()
+
 	if !jobInfo.AutoRestore {
 		// Let's see if we already have this snap shot
 		creationTime, err := helpers.GetCreationDate(context.TODO(), fmt.Sprintf("%s@%s", jobInfo.LocalVolume, jobInfo.BaseSnapshot.Name))
@@ -132,16 +217,65 @@ func validateReceiveFlags(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	for _, destination := range jobInfo.Destinations {
-		_, err := backends.GetBackendForURI(destination)
-		if err == backends.ErrInvalidPrefix {
-			helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", destination)
-			return errInvalidInput
-		} else if err == backends.ErrInvalidURI {
-			helpers.AppLogger.Errorf("Invalid destination URI, was given %s", destination)
-			return errInvalidInput
+	return nil
+}
+
+// remapLocalVolume will set the local volume to restore to from the --remap and --remapFile mappings.
+func remapLocalVolume() error {
+	if jobInfo.LocalVolume != "" || jobInfo.ToFile != "" {
+		return fmt.Errorf("cannot remap the volume along with a local_volume or --toFile")
+	}
+	if jobInfo.FullPath || jobInfo.LastPath {
+		return fmt.Errorf("cannot remap the volume along with the -d or -e options")
+	}
+
+	pairs := remaps
+	if remapFile != "" {
+		filePairs, err := readRemapFile(remapFile)
+		if err != nil {
+			return err
+		}
+		pairs = append(filePairs, remaps...)
+	}
+
+	mappings := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(pair, "@") {
+			return fmt.Errorf("invalid mapping provided, expected format source=target, got %s instead", pair)
+		}
+		source, target := strings.TrimSuffix(parts[0], "/"), strings.TrimSuffix(parts[1], "/")
+		if dst, ok := mappings[source]; ok && dst != target {
+			return fmt.Errorf("%s is mapped to both %s and %s", source, dst, target)
 		}
+		mappings[source] = target
 	}
 
+	volume, err := backup.RemapVolume(jobInfo.VolumeName, mappings)
+	if err != nil {
+		return err
+	}
+	helpers.AppLogger.Infof("Restoring %s to %s.", jobInfo.VolumeName, volume)
+	jobInfo.LocalVolume = volume
 	return nil
 }
+
+// readRemapFile will read the source=target pairs of the file provided, one per line.
+func readRemapFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var pairs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pairs = append(pairs, line)
+	}
+	return pairs, scanner.Err()
+}
@@ -0,0 +1,112 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var restorePointsFull bool
+
+// restorePointsCmd represents the restore-points command
+var restorePointsCmd = &cobra.Command{
+	Use:   "restore-points [flags] volume uri",
+	Short: "restore-points lists every snapshot of a volume that can be restored from the target, and what it takes to restore it.",
+	Long: `restore-points lists every snapshot of a volume that can be restored from the target, and what it takes to restore it.
+
+Every snapshot is listed along with whether it is restored from a full backup set alone, the
+depth of its chain (how many backup sets are received to restore it), and how much is
+downloaded to restore it from scratch, following the same chain an auto restore would.
+Snapshots whose chain is broken are left out. The volume can end with a '*' to match as
+only a prefix.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			cmd.Usage()
+			return errInvalidInput
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jobInfo.Destinations = []string{args[1]}
+		points, err := backup.RestorePoints(context.Background(), &jobInfo, args[0])
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not list the restore points of %s due to error - %v", args[0], err)
+			return err
+		}
+
+		if restorePointsFull {
+			full := points[:0]
+			for _, point := range points {
+				if point.Full {
+					full = append(full, point)
+				}
+			}
+			points = full
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(points)
+		}
+
+		if len(points) == 0 {
+			fmt.Fprintf(helpers.Stdout, "No restore point found for %s.\n", args[0])
+			return nil
+		}
+
+		table := helpers.NewTable("NAME", "CREATED", "TYPE", "DEPTH", "VOLUMES", "DOWNLOAD")
+		for _, point := range points {
+			kind := "incremental"
+			if point.Full {
+				kind = "full"
+			}
+			table.Row(
+				fmt.Sprintf("%s@%s", point.Volume, point.Snapshot.Name),
+				point.Snapshot.CreationTime.Local().Format(time.RFC3339),
+				kind,
+				fmt.Sprintf("%d", point.Depth),
+				fmt.Sprintf("%d", point.Volumes),
+				humanize.IBytes(point.StoredBytes),
+			)
+		}
+		_, err = table.WriteTo(helpers.Stdout)
+		return err
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(restorePointsCmd)
+
+	restorePointsCmd.Flags().BoolVar(&restorePointsFull, "full", false, "only list the snapshots restored from a full backup set alone.")
+}
+
+// ResetRestorePointsJobInfo exists solely for integration testing
+func ResetRestorePointsJobInfo() {
+	resetRootFlags()
+	restorePointsFull = false
+}
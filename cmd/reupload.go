@@ -0,0 +1,126 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	reuploadVolumes     []int
+	reuploadCheckHashes bool
+)
+
+// reuploadCmd represents the reupload command
+var reuploadCmd = &cobra.Command{
+	Use:   "reupload [flags] snapshot uri",
+	Short: "reupload will regenerate missing or corrupt volumes of a backup set from its local snapshot and upload them again.",
+	Long: `reupload will regenerate missing or corrupt volumes of a backup set from its local snapshot and upload them again.
+
+The zfs send stream of the backup set is created again with the options recorded in its manifest and
+split along the same boundaries, so only the volumes provided with --volume (e.g. as reported by the
+verify command) are uploaded again, healing the target without sending the whole chain again. Without
+--volume, the volumes missing from the target are regenerated, along with the volumes not matching the
+hash recorded in the manifest when --checkHashes is provided. The snapshots of the backup set must still
+be found locally.`,
+	PreRunE: validateReuploadFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		volumeNumbers := make([]int64, len(reuploadVolumes))
+		for idx := range reuploadVolumes {
+			volumeNumbers[idx] = int64(reuploadVolumes[idx])
+		}
+
+		return runJob("reupload", func(ctx context.Context) error {
+			return backup.Reupload(ctx, &jobInfo, volumeNumbers, reuploadCheckHashes)
+		})
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(reuploadCmd)
+
+	reuploadCmd.Flags().StringVarP(&jobInfo.IncrementalSnapshot.Name, "incremental", "i", "", "Used to specify the incremental snapshot the backup set was taken from.")
+	reuploadCmd.Flags().IntSliceVar(&reuploadVolumes, "volume", nil, "the number of a volume to regenerate and upload again. Can be repeated.")
+	reuploadCmd.Flags().BoolVar(&reuploadCheckHashes, "checkHashes", false, "when no volume is provided, download every volume found in the target and regenerate those not matching the hash recorded in the manifest along with the missing ones.")
+	reuploadCmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed upload. Use 0 for no limit.")
+	reuploadCmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an upload.")
+	reuploadCmd.Flags().IntVar(&jobInfo.UploadChunkSize, "uploadChunkSize", 10, "the chunk size, in MiB, to use when uploading. A minimum of 5MiB and maximum of 100MiB is enforced.")
+	reuploadCmd.Flags().StringVar(&jobInfo.Separator, "separator", "|", "the separator to use between object component names (used only for the initial manifest we are looking for).")
+}
+
+// ResetReuploadJobInfo exists solely for integration testing
+func ResetReuploadJobInfo() {
+	resetRootFlags()
+	reuploadVolumes = nil
+	reuploadCheckHashes = false
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{}
+	jobInfo.IncrementalSnapshot = helpers.SnapshotInfo{}
+	jobInfo.MaxRetryTime = 12 * time.Hour
+	jobInfo.MaxBackoffTime = 30 * time.Minute
+	jobInfo.UploadChunkSize = 10
+	jobInfo.Separator = "|"
+}
+
+func validateReuploadFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	if len(reuploadVolumes) > 0 && reuploadCheckHashes {
+		helpers.AppLogger.Errorf("The --checkHashes option cannot be used along with --volume.")
+		return errInvalidInput
+	}
+	jobInfo.StartTime = time.Now()
+
+	parts := strings.Split(args[0], "@")
+	if len(parts) != 2 {
+		helpers.AppLogger.Errorf("Invalid snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
+		return errInvalidInput
+	}
+	jobInfo.VolumeName = parts[0]
+	jobInfo.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+	jobInfo.Destinations = []string{args[1]}
+
+	if jobInfo.IncrementalSnapshot.Name != "" {
+		jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, jobInfo.VolumeName)
+		jobInfo.IncrementalSnapshot.Name = strings.TrimPrefix(jobInfo.IncrementalSnapshot.Name, "@")
+	}
+
+	_, err := backends.GetBackendForURI(args[1])
+	if err == backends.ErrInvalidPrefix {
+		helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", args[1])
+		return errInvalidInput
+	} else if err == backends.ErrInvalidURI {
+		helpers.AppLogger.Errorf("Invalid destination URI, was given %s", args[1])
+		return errInvalidInput
+	}
+
+	return nil
+}
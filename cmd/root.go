@@ -22,7 +22,6 @@ package cmd
 
 import (
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"os/user"
@@ -45,9 +44,42 @@ var (
 	secretKeyRingPath string
 	publicKeyRingPath string
 	workingDirectory  string
+	pushGatewayURL    string
+	statsdAddr        string
+	statsdDatadog     bool
+	healthcheckURL    string
+	progressJSON      string
+	noProgressBar     bool
+	noColor           bool
+	quiet             bool
 	errInvalidInput   = errors.New("invalid input")
+	errInterrupted    = errors.New("interrupted")
 )
 
+// exitCodeInterrupted is the exit code of a job stopped by SIGINT or SIGTERM or by its backup window closing
+// (EX_TEMPFAIL), which can be run again with the --resume flag where supported. A job refusing to start outside
+// of its backup window exits with it as well.
+const exitCodeInterrupted = 75
+
+// The exit codes of a failed command by the kind of error it failed with. A command that fails with an
+// error that was not classified exits with 1.
+var exitCodes = map[helpers.ErrorKind]int{
+	helpers.ErrorKindUnknown:      1,
+	helpers.ErrorKindConfig:       2,
+	helpers.ErrorKindZFS:          3,
+	helpers.ErrorKindBackend:      4,
+	helpers.ErrorKindVerification: 5,
+	helpers.ErrorKindPartial:      6,
+}
+
+// commandError is the JSON object printed with --jsonOutput when a command fails.
+type commandError struct {
+	Command  string
+	Error    string
+	Kind     string
+	ExitCode int
+}
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "zfsbackup",
@@ -67,22 +99,72 @@ destination of your choosing.`,
 // Execute adds all child commands to the root command sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
-		os.Exit(-1)
+	RootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return helpers.NewError(helpers.ErrorKindConfig, err)
+	})
+
+	cmd, err := RootCmd.ExecuteC()
+	if err != nil {
+		// The post run is skipped when a command fails, don't leave what it staged behind
+		postRunCleanup(cmd, nil)
+	}
+	stopDiagnostics()
+	closeLogOutputs()
+	if err == nil {
+		return
 	}
+
+	kind, code := exitCode(err)
+	if helpers.JSONOutput {
+		printJSON(commandError{Command: cmd.Name(), Error: err.Error(), Kind: kind, ExitCode: code})
+	}
+	os.Exit(code)
+}
+
+// exitCode will return the kind of the error a command failed with and the exit code to exit with.
+func exitCode(err error) (string, int) {
+	if result, ok := err.(checkResult); ok {
+		return "check", int(result)
+	}
+	switch {
+	case err == errInterrupted:
+		return "interrupted", exitCodeInterrupted
+	case err == errOutsideWindow:
+		return "window", exitCodeInterrupted
+	case err == errInvalidInput:
+		return helpers.ErrorKindConfig.String(), exitCodes[helpers.ErrorKindConfig]
+	}
+	kind := helpers.KindOf(err)
+	return kind.String(), exitCodes[kind]
 }
 
 func init() {
 	RootCmd.PersistentFlags().IntVar(&numCores, "numCores", 2, "number of CPU cores to utilize. Do not exceed the number of CPU cores on the system.")
 	RootCmd.PersistentFlags().StringVar(&logLevel, "logLevel", "notice", "this controls the verbosity level of logging. Possible values are critical, error, warning, notice, info, debug.")
+	RootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "only log errors and don't draw the progress of jobs, the result of the command is still output. Overrides --logLevel.")
 	RootCmd.PersistentFlags().StringVar(&secretKeyRingPath, "secretKeyRingPath", "", "the path to the PGP secret key ring")
 	RootCmd.PersistentFlags().StringVar(&publicKeyRingPath, "publicKeyRingPath", "", "the path to the PGP public key ring")
 	RootCmd.PersistentFlags().StringVar(&workingDirectory, "workingDirectory", "~/.zfsbackup", "the working directory path for zfsbackup.")
 	RootCmd.PersistentFlags().StringVar(&jobInfo.ManifestPrefix, "manifestPrefix", "manifests", "the prefix to use for all manifest files.")
+	RootCmd.PersistentFlags().IntVar(&jobInfo.ManifestWorkers, "manifestWorkers", helpers.DefaultManifestWorkers, "the number of manifests to download from the destination and decrypt at once when reading every backup set found there.")
 	RootCmd.PersistentFlags().StringVar(&jobInfo.EncryptTo, "encryptTo", "", "the email of the user to encrypt the data to from the provided public keyring.")
 	RootCmd.PersistentFlags().StringVar(&jobInfo.SignFrom, "signFrom", "", "the email of the user to sign on behalf of from the provided private keyring.")
-	RootCmd.PersistentFlags().StringVar(&helpers.ZFSPath, "zfsPath", "zfs", "the path to the zfs executable.")
-	RootCmd.PersistentFlags().BoolVar(&helpers.JSONOutput, "jsonOutput", false, "dump results as a JSON string - on success only")
+	RootCmd.PersistentFlags().StringVar(&helpers.ZFSPath, "zfsPath", "zfs", "the path to the zfs executable. If zfs is not found in the PATH, the install location of OpenZFS on macOS (/usr/local/zfs/bin) is tried.")
+	RootCmd.PersistentFlags().StringVar(&helpers.SSHHost, "sshHost", "", "the [user@]host to run the zfs commands on over ssh, streaming zfs send and receive back to this host which does the compression, encryption, and transfers. The --zfsPath is that of the remote host.")
+	RootCmd.PersistentFlags().IntVar(&helpers.SSHPort, "sshPort", 0, "the port to connect to the --sshHost on. Use 0 for the ssh default.")
+	RootCmd.PersistentFlags().StringVar(&helpers.SSHIdentityFile, "sshIdentityFile", "", "the private key to authenticate to the --sshHost with, the ssh defaults are used if not provided.")
+	RootCmd.PersistentFlags().StringVar(&helpers.SSHPath, "sshPath", "ssh", "the path to the ssh executable.")
+	RootCmd.PersistentFlags().IntVar(&helpers.Nice, "nice", 0, "the niceness, from -20 to 19, to run the zfs send and receive commands and the external compressors with, on the --sshHost too, so backups yield the CPU to latency-sensitive workloads. Use 0 to leave it as is.")
+	RootCmd.PersistentFlags().StringVar(&helpers.IONice, "ionice", "", "the I/O scheduling class to run the zfs send and receive commands and the external compressors with, on the --sshHost too: idle, or best-effort or realtime with an optional level from 0 (highest) to 7, e.g. best-effort:7. Requires the ionice binary (Linux). It is left as is if not provided.")
+	RootCmd.PersistentFlags().BoolVar(&helpers.JSONOutput, "jsonOutput", false, "dump results as a JSON string, or a JSON object with the command, error, kind of error, and exit code on failure.")
+	RootCmd.PersistentFlags().StringVar(&statsdAddr, "statsdAddr", "", "the address (host:port) of a statsd server to emit per job counters and timings to over UDP.")
+	RootCmd.PersistentFlags().BoolVar(&statsdDatadog, "statsdDatadog", false, "send the job details as dogstatsd tags instead of including the volume name in the statsd metric names.")
+	RootCmd.PersistentFlags().StringVar(&healthcheckURL, "healthcheckURL", "", "the URL of a healthchecks.io style check to ping when a send, receive, or verify job starts (<url>/start), succeeds (<url>), or fails (<url>/fail).")
+	RootCmd.PersistentFlags().StringVar(&progressJSON, "progressJSON", "", "write the progress events of send, receive, and verify jobs (job started, volume created, uploaded, or downloaded, with the bytes done so far, retries, job finished) and their result as newline delimited JSON to this file or FIFO, or to stdout if -.")
+	RootCmd.PersistentFlags().BoolVar(&noProgressBar, "noProgressBar", false, "do not draw the live progress of send, receive, and verify jobs (snapshot, volume, throughput to every destination, percent done, and ETA) when stderr is a terminal.")
+	RootCmd.PersistentFlags().BoolVar(&noColor, "noColor", false, "do not colorize the output of the list, jobs, and verify commands when stdout is a terminal. Setting the NO_COLOR environmental variable has the same effect.")
+	RootCmd.PersistentFlags().StringVar(&pushGatewayURL, "pushGatewayURL", "", "the URL of a Prometheus Pushgateway to push the metrics of a send, receive, or verify job to once it is done.")
+	loadCredentials()
 	passphrase = []byte(os.Getenv("PGP_PASSPHRASE"))
 }
 
@@ -94,14 +176,56 @@ func resetRootFlags() {
 	publicKeyRingPath = ""
 	workingDirectory = "~/.zfsbackup"
 	jobInfo.ManifestPrefix = "manifests"
+	jobInfo.ManifestWorkers = helpers.DefaultManifestWorkers
 	jobInfo.EncryptTo = ""
 	jobInfo.SignFrom = ""
 	helpers.ZFSPath = "zfs"
+	helpers.SSHHost = ""
+	helpers.SSHPort = 0
+	helpers.SSHIdentityFile = ""
+	helpers.SSHPath = "ssh"
+	helpers.Nice = 0
+	helpers.IONice = ""
 	helpers.JSONOutput = false
+	pushGatewayURL = ""
+	statsdAddr = ""
+	statsdDatadog = false
+	healthcheckURL = ""
+	progressJSON = ""
+	noProgressBar = false
+	noColor = false
+	helpers.Color = false
+	quiet = false
+	resetLoggingFlags()
+	resetDiagnosticsFlags()
+	resetNotifyFlags()
+	resetAuditFlags()
+	resetStagingFlags()
+	resetPermissionFlags()
+	resetPassphraseFlags()
+	resetContainerFlags()
+	resetHookFlags()
+	resetConfigFlags()
+	resetRunFlags()
+	resetDoctorFlags()
+	resetCostFlags()
+	resetSystemdFlags()
+	resetLaunchdFlags()
+	resetInitFlags()
+	resetCodecFlags()
+	resetManifestFormatFlags()
 }
 
 func processFlags(cmd *cobra.Command, args []string) error {
-	switch strings.ToLower(logLevel) {
+	// Log after the log level is set, which may come from the environment or config file
+	configErr := applyConfig(cmd, args)
+
+	level := logLevel
+	if quiet {
+		level = "error"
+		noProgressBar = true
+	}
+	switch strings.ToLower(level) {
 	case "critical":
 		logging.SetLevel(logging.CRITICAL, helpers.LogModuleName)
 	case "error":
@@ -119,15 +243,83 @@ func processFlags(cmd *cobra.Command, args []string) error {
 		return errInvalidInput
 	}
 
+	if err := openLogOutputs(machineReadableStdout(cmd)); err != nil {
+		helpers.AppLogger.Errorf("Could not set up the logging requested - %v", err)
+		return helpers.NewError(helpers.ErrorKindConfig, err)
+	}
+
+	// Only colorize what is read by a person, see https://no-color.org
+	helpers.Color = !noColor && os.Getenv("NO_COLOR") == "" && !helpers.JSONOutput && helpers.Stdout == os.Stdout && terminal.IsTerminal(int(os.Stdout.Fd()))
+
+	if configErr != nil {
+		helpers.AppLogger.Errorf("Could not apply the config file or environmental variables - %v", configErr)
+		return helpers.NewError(helpers.ErrorKindConfig, configErr)
+	} else if configFileUsed != "" {
+		helpers.AppLogger.Infof("Loaded config file %s", configFileUsed)
+	}
+
+	if err := startDiagnostics(); err != nil {
+		helpers.AppLogger.Errorf("Could not set up the diagnostics requested - %v", err)
+		return helpers.NewError(helpers.ErrorKindConfig, err)
+	}
+
+	if helpers.SSHPort < 0 || helpers.SSHPort > 65535 {
+		helpers.AppLogger.Errorf("The ssh port provided is an invalid value. It must be between 0 and 65535. %d was given.", helpers.SSHPort)
+		return errInvalidInput
+	}
+
+	if helpers.SSHHost != "" {
+		helpers.AppLogger.Infof("Running the zfs commands on %s over ssh.", helpers.SSHHost)
+	}
+	helpers.ResolveZFSPath()
+
+	if err := applyContainerMode(); err != nil {
+		helpers.AppLogger.Errorf("%v", err)
+		return errInvalidInput
+	}
+
+	if err := helpers.ValidatePriority(); err != nil {
+		helpers.AppLogger.Errorf("%v", err)
+		return errInvalidInput
+	}
+
+	if err := registerCodecs(); err != nil {
+		helpers.AppLogger.Errorf("%v", err)
+		return errInvalidInput
+	}
+
+	if err := validateManifestFormat(); err != nil {
+		return err
+	}
+
+	if err := applyUmask(); err != nil {
+		helpers.AppLogger.Errorf("%v", err)
+		return errInvalidInput
+	}
+
+	if jobInfo.ManifestWorkers <= 0 {
+		helpers.AppLogger.Errorf("The number of manifest workers provided is an invalid value. It must be greater than 0. %d was given.", jobInfo.ManifestWorkers)
+		return errInvalidInput
+	}
+
 	if numCores <= 0 {
 		helpers.AppLogger.Errorf("The number of cores to use provided is an invalid value. It must be greater than 0. %d was given.", numCores)
 		return errInvalidInput
 	}
 
 	if numCores > runtime.NumCPU() {
-		helpers.AppLogger.Warningf("Ignoring user provided number of cores (%d) and using the number of detected cores (%d).", numCores, runtime.NumCPU())
+		// Only warn about a value that was actually asked for, not the default
+		if f := cmd.Flags().Lookup("numCores"); f != nil && f.Value.String() != f.DefValue {
+			helpers.AppLogger.Warningf("Ignoring user provided number of cores (%d) and using the number of detected cores (%d).", numCores, runtime.NumCPU())
+		} else {
+			helpers.AppLogger.Infof("Using the number of detected cores (%d).", runtime.NumCPU())
+		}
 		numCores = runtime.NumCPU()
 	}
+	if limit := helpers.CgroupCPULimit(); limit > 0 && numCores > limit {
+		helpers.AppLogger.Infof("Using the number of cores allowed by the CPU quota of our cgroup (%d).", limit)
+		numCores = limit
+	}
 	helpers.AppLogger.Infof("Setting number of cores to: %d", numCores)
 	runtime.GOMAXPROCS(numCores)
 
@@ -163,22 +355,14 @@ func processFlags(cmd *cobra.Command, args []string) error {
 			return errInvalidInput
 		}
 
-		if jobInfo.EncryptKey.PrivateKey != nil && jobInfo.EncryptKey.PrivateKey.Encrypted {
-			validatePassphrase()
-			if err := jobInfo.EncryptKey.PrivateKey.Decrypt(passphrase); err != nil {
-				helpers.AppLogger.Errorf("Error decrypting private key: %v", err)
-				return errInvalidInput
-			}
+		own, err := keyPassphrase("PGP_ENCRYPT_PASSPHRASE", encryptPassphraseFile)
+		if err != nil {
+			helpers.AppLogger.Errorf("%v", err)
+			return errInvalidInput
 		}
-
-		for _, subkey := range jobInfo.EncryptKey.Subkeys {
-			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
-				validatePassphrase()
-				if err := subkey.PrivateKey.Decrypt(passphrase); err != nil {
-					helpers.AppLogger.Errorf("Error decrypting subkey's private key: %v", err)
-					return errInvalidInput
-				}
-			}
+		if err = unlockKey(jobInfo.EncryptKey, own); err != nil {
+			helpers.AppLogger.Errorf("Error decrypting private key: %v", err)
+			return errInvalidInput
 		}
 	}
 
@@ -188,22 +372,14 @@ func processFlags(cmd *cobra.Command, args []string) error {
 			return errInvalidInput
 		}
 
-		if jobInfo.SignKey.PrivateKey != nil && jobInfo.SignKey.PrivateKey.Encrypted {
-			validatePassphrase()
-			if err := jobInfo.SignKey.PrivateKey.Decrypt(passphrase); err != nil {
-				helpers.AppLogger.Errorf("Error decrypting private key: %v", err)
-				return errInvalidInput
-			}
+		own, err := keyPassphrase("PGP_SIGN_PASSPHRASE", signPassphraseFile)
+		if err != nil {
+			helpers.AppLogger.Errorf("%v", err)
+			return errInvalidInput
 		}
-
-		for _, subkey := range jobInfo.SignKey.Subkeys {
-			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
-				validatePassphrase()
-				if err := subkey.PrivateKey.Decrypt(passphrase); err != nil {
-					helpers.AppLogger.Errorf("Error decrypting subkey's private key: %v", err)
-					return errInvalidInput
-				}
-			}
+		if err = unlockKey(jobInfo.SignKey, own); err != nil {
+			helpers.AppLogger.Errorf("Error decrypting private key: %v", err)
+			return errInvalidInput
 		}
 	}
 
@@ -216,7 +392,7 @@ func processFlags(cmd *cobra.Command, args []string) error {
 }
 
 func postRunCleanup(cmd *cobra.Command, args []string) {
-	err := os.RemoveAll(helpers.BackupTempdir)
+	err := helpers.RemoveTempDir(helpers.BackupTempdir)
 	if err != nil {
 		helpers.AppLogger.Errorf("Could not clean working temporary directory - %v", err)
 	}
@@ -234,27 +410,13 @@ func setupGlobalVars() error {
 		workingDirectory = filepath.Join(usr.HomeDir, strings.TrimPrefix(workingDirectory, "~"))
 	}
 
-	if dir, serr := os.Stat(workingDirectory); serr == nil && !dir.IsDir() {
-		helpers.AppLogger.Errorf("Cannot create working directory because another non-directory object already exists in that path (%s)", workingDirectory)
-		return errInvalidInput
-	} else if serr != nil {
-		err := os.Mkdir(workingDirectory, 0755)
-		if err != nil {
-			helpers.AppLogger.Errorf("Could not create working directory %s due to error - %v", workingDirectory, err)
-			return err
-		}
+	if err := ensurePrivateDir(workingDirectory, "working directory"); err != nil {
+		return err
 	}
 
-	dirPath := filepath.Join(workingDirectory, "temp")
-	if dir, serr := os.Stat(dirPath); serr == nil && !dir.IsDir() {
-		helpers.AppLogger.Errorf("Cannot create temp dir in working directory because another non-directory object already exists in that path (%s)", dirPath)
-		return errInvalidInput
-	} else if serr != nil {
-		err := os.Mkdir(dirPath, 0755)
-		if err != nil {
-			helpers.AppLogger.Errorf("Could not create temp directory %s due to error - %v", dirPath, err)
-			return err
-		}
+	dirPath, err := stagingDir()
+	if err != nil {
+		return err
 	}
 
 	tempdir, err := ioutil.TempDir(dirPath, helpers.LogModuleName)
@@ -266,16 +428,8 @@ func setupGlobalVars() error {
 	helpers.BackupTempdir = tempdir
 	helpers.WorkingDir = workingDirectory
 
-	dirPath = filepath.Join(workingDirectory, "cache")
-	if dir, serr := os.Stat(dirPath); serr == nil && !dir.IsDir() {
-		helpers.AppLogger.Errorf("Cannot create cache dir in working directory because another non-directory object already exists in that path (%s)", dirPath)
-		return errInvalidInput
-	} else if serr != nil {
-		err := os.Mkdir(dirPath, 0755)
-		if err != nil {
-			helpers.AppLogger.Errorf("Could not create cache directory %s due to error - %v", dirPath, err)
-			return err
-		}
+	if err = ensurePrivateDir(filepath.Join(workingDirectory, "cache"), "cache directory"); err != nil {
+		return err
 	}
 
 	if maxUploadSpeed != 0 {
@@ -284,15 +438,3 @@ func setupGlobalVars() error {
 	}
 	return nil
 }
-
-func validatePassphrase() {
-	var err error
-	if len(passphrase) == 0 {
-		fmt.Fprint(helpers.Stdout, "Enter passphrase to decrypt encryption key: ")
-		passphrase, err = terminal.ReadPassword(0)
-		if err != nil {
-			helpers.AppLogger.Errorf("Error reading user input for encryption key passphrase: %v", err)
-			panic(err)
-		}
-	}
-}
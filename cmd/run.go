@@ -0,0 +1,509 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/juju/ratelimit"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Options of a job definition that are not flag values.
+var jobKeys = map[string]bool{
+	"command":      true,
+	"dataset":      true,
+	"destinations": true,
+	"target":       true,
+	"schedule":     true,
+	"description":  true,
+}
+
+var (
+	listJobDefinitions bool
+	runParallel        int
+)
+
+// jobDefinition is a named job found in the jobs section of the config file.
+type jobDefinition struct {
+	Name         string
+	Command      string
+	Dataset      string
+	Destinations []string
+	Target       string
+	Schedule     string
+	Description  string
+	options      map[string]interface{}
+}
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run [flags] jobname...",
+	Short: "run will execute jobs defined in the jobs section of the config file.",
+	Long: `run will execute jobs defined in the jobs section of the config file.
+
+A job names the command to run (send by default), the dataset and destinations
+it applies to, the local volume to restore to for a receive (target), and the
+value of any other flag. The schedule and description of a job are only hints
+shown by the --list flag.
+
+Several send jobs can be given to run them together, up to --parallel of them
+at the same time. Every upload of these jobs draws from a single bandwidth
+budget: the lowest --maxUploadSpeed of the jobs is the cap of all of them. The
+jobs must share the working directory, temp directory, keyrings, zfs and ssh
+settings, priority, and backup window, which apply to the whole process.`,
+	// Flags are processed once the job is known
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyEnv(cmd.Flags().Lookup("config")); err != nil {
+			helpers.AppLogger.Errorf("Could not read the job definitions - %v", err)
+			return helpers.NewError(helpers.ErrorKindConfig, err)
+		}
+
+		jobs, err := readJobDefinitions()
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not read the job definitions - %v", err)
+			return helpers.NewError(helpers.ErrorKindConfig, err)
+		}
+
+		if listJobDefinitions {
+			return printJobDefinitions(jobs)
+		}
+
+		if len(args) == 0 {
+			cmd.Usage()
+			return errInvalidInput
+		}
+
+		if runParallel <= 0 {
+			helpers.AppLogger.Errorf("The number of jobs to run in parallel provided is an invalid value. It must be greater than 0. %d was given.", runParallel)
+			return errInvalidInput
+		}
+
+		selected := make([]*jobDefinition, 0, len(args))
+		for _, name := range args {
+			job, ferr := findJobDefinition(jobs, name)
+			if ferr != nil {
+				return ferr
+			}
+			selected = append(selected, job)
+		}
+
+		if len(selected) == 1 {
+			return runJobDefinition(cmd, selected[0])
+		}
+		return runJobDefinitions(cmd, selected)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(runCmd)
+
+	runCmd.Flags().BoolVar(&listJobDefinitions, "list", false, "list the jobs defined in the config file instead of running one.")
+	runCmd.Flags().IntVar(&runParallel, "parallel", 1, "the number of send jobs to run at the same time when more than one job is given.")
+}
+
+func resetRunFlags() {
+	listJobDefinitions = false
+	runParallel = 1
+}
+
+// findJobDefinition will return the job named name, ignoring case.
+func findJobDefinition(jobs []jobDefinition, name string) (*jobDefinition, error) {
+	for idx := range jobs {
+		if strings.EqualFold(jobs[idx].Name, name) {
+			return &jobs[idx], nil
+		}
+	}
+	err := fmt.Errorf("could not find the job %s in the config file", name)
+	helpers.AppLogger.Errorf("%v", err)
+	return nil, helpers.NewError(helpers.ErrorKindConfig, err)
+}
+
+func runJobDefinition(cmd *cobra.Command, job *jobDefinition) error {
+	target, args, err := prepareJobDefinition(cmd, job)
+	if err != nil {
+		return err
+	}
+	return target.RunE(target, args)
+}
+
+// jobArgs returns the arguments of the command the job runs, nil if the command is not supported.
+func jobArgs(job *jobDefinition) []string {
+	switch job.Command {
+	case "send":
+		return []string{job.Dataset, strings.Join(job.Destinations, ",")}
+	case "receive":
+		return []string{job.Dataset, strings.Join(job.Destinations, ","), job.Target}
+	case "verify":
+		return []string{job.Dataset, job.Destinations[0]}
+	}
+	return nil
+}
+
+// prepareJobDefinition will apply the options of the job and validate them with the command
+// it runs, returning the command along with its arguments.
+func prepareJobDefinition(cmd *cobra.Command, job *jobDefinition) (*cobra.Command, []string, error) {
+	var target *cobra.Command
+	for _, c := range cmd.Root().Commands() {
+		if c.Name() == job.Command {
+			target = c
+		}
+	}
+
+	args := jobArgs(job)
+	if target == nil || args == nil {
+		err := fmt.Errorf("the job %s has an unsupported command %s, must be one of send, receive, or verify", job.Name, job.Command)
+		helpers.AppLogger.Errorf("%v", err)
+		return nil, nil, helpers.NewError(helpers.ErrorKindConfig, err)
+	}
+
+	// Merge the global flags given to run with the flags of the command to run
+	target.InheritedFlags()
+	namedJob = &configSection{name: "jobs " + job.Name, values: job.options}
+	if err := processFlags(target, args); err != nil {
+		return nil, nil, err
+	}
+
+	helpers.AppLogger.Infof("Running the job %s (%s %s)", job.Name, job.Command, strings.Join(args, " "))
+	jobInfo.JobName = job.Name
+	if target.PreRunE != nil {
+		if err := target.PreRunE(target, args); err != nil {
+			return nil, nil, err
+		}
+	}
+	return target, args, nil
+}
+
+// preparedJob is a send job whose options were applied and validated, ready to be run.
+type preparedJob struct {
+	name      string
+	jobInfo   helpers.JobInfo
+	observers []control.Observer
+	postHook  string
+	err       error // Why the job can't be run, it is then only reported as failed
+}
+
+// runJobDefinitions will prepare the send jobs one after the other and then run them, up to
+// --parallel of them at the same time, with every upload drawing from the same rate-limit bucket.
+func runJobDefinitions(cmd *cobra.Command, jobs []*jobDefinition) error {
+	for _, job := range jobs {
+		if job.Command != "send" {
+			err := fmt.Errorf("the job %s runs %s, only send jobs can be run together", job.Name, job.Command)
+			helpers.AppLogger.Errorf("%v", err)
+			return helpers.NewError(helpers.ErrorKindConfig, err)
+		}
+	}
+
+	// Every job starts from the flags given to run, not from the options of the previous job
+	base := jobInfo
+	var (
+		prepared    []*preparedJob
+		uploadSpeed uint64
+		settings    []jobSetting
+		tempdir     string
+	)
+	for idx, job := range jobs {
+		if idx > 0 {
+			jobInfo = base
+			if err := resetUnchangedFlags(sendCmd); err != nil {
+				helpers.AppLogger.Errorf("Could not reset the options of the job %s before preparing the job %s - %v", jobs[idx-1].Name, job.Name, err)
+				return helpers.NewError(helpers.ErrorKindConfig, err)
+			}
+		}
+
+		_, _, err := prepareJobDefinition(cmd, job)
+		// Every job creates its own temporary directory but they all share the last one, which is in
+		// the same staging directory as theirs
+		if tempdir != "" && tempdir != helpers.BackupTempdir {
+			if rerr := helpers.RemoveTempDir(tempdir); rerr != nil {
+				helpers.AppLogger.Warningf("Could not clean temporary directory %s - %v", tempdir, rerr)
+			}
+		}
+		tempdir = helpers.BackupTempdir
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			err = fmt.Errorf("the job %s is a dry run, which can't be run together with other jobs", job.Name)
+			helpers.AppLogger.Errorf("%v", err)
+			return helpers.NewError(helpers.ErrorKindConfig, err)
+		}
+		// Every job is run with the process-wide settings of the last job prepared
+		if idx == 0 {
+			settings = sharedJobSettings()
+		} else if name := mismatchedSetting(settings, sharedJobSettings()); name != "" {
+			err = fmt.Errorf("the job %s has a different %s than the job %s, they can't be run together", job.Name, name, jobs[0].Name)
+			helpers.AppLogger.Errorf("%v", err)
+			return helpers.NewError(helpers.ErrorKindConfig, err)
+		}
+		if maxUploadSpeed != 0 && (uploadSpeed == 0 || maxUploadSpeed < uploadSpeed) {
+			uploadSpeed = maxUploadSpeed
+		}
+
+		// A job sending the datasets matching a pattern is run as one job per dataset
+		datasets := sendDatasets
+		if len(datasets) == 0 {
+			datasets = []string{jobInfo.VolumeName}
+		}
+		for _, dataset := range datasets {
+			p := &preparedJob{name: job.Name, jobInfo: jobInfo, postHook: postHook}
+			if len(sendDatasets) > 0 {
+				p.name = fmt.Sprintf("%s (%s)", job.Name, dataset)
+				p.jobInfo.VolumeName = dataset
+				if serr := backup.ProcessSmartOptions(context.Background(), &p.jobInfo); serr == backup.ErrNoOp {
+					helpers.AppLogger.Noticef("Nothing new to send for %s, skipping it.", dataset)
+					continue
+				} else if serr != nil {
+					// As with send, a dataset that can't be sent does not stop the others
+					helpers.AppLogger.Errorf("Error while trying to process smart option for %s of the job %s - %v", dataset, job.Name, serr)
+					p.err = serr
+					prepared = append(prepared, p)
+					continue
+				}
+			}
+			if p.observers, err = jobObservers(); err != nil {
+				return err
+			}
+			prepared = append(prepared, p)
+		}
+	}
+
+	helpers.BackupUploadBucket = nil
+	if uploadSpeed != 0 {
+		helpers.AppLogger.Infof("Limiting the upload speed of the %d jobs to %s/s in total.", len(prepared), humanize.Bytes(uploadSpeed*humanize.KByte))
+		helpers.BackupUploadBucket = ratelimit.NewBucketWithRate(float64(uploadSpeed*humanize.KByte), int64(uploadSpeed*humanize.KByte))
+	}
+
+	stopPauseSignals := handlePauseSignals()
+	defer stopPauseSignals()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped, stopStopSignals := handleStopSignals("send", cancel)
+	defer stopStopSignals()
+	windowClosed, stopWindow := enforceWindow(ctx, "send", cancel)
+	defer stopWindow()
+	interrupted := func() bool {
+		return stopped() || windowClosed()
+	}
+
+	helpers.AppLogger.Noticef("Running %d jobs, up to %d at the same time. Their progress is not available from the jobs and progress commands.", len(prepared), runParallel)
+	errs := make([]error, len(prepared))
+	sem := make(chan struct{}, runParallel)
+	var wg sync.WaitGroup
+	for idx, p := range prepared {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(idx int, p *preparedJob) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if p.err != nil {
+				errs[idx] = p.err
+				return
+			}
+			// A job waiting for its turn may only get it once the backup window closed
+			if errs[idx] = checkWindow("the job " + p.name); errs[idx] != nil {
+				return
+			}
+			helpers.AppLogger.Noticef("Starting the job %s", p.name)
+			errs[idx] = runObservedJob(ctx, "send", &p.jobInfo, nil, p.observers, interrupted, p.postHook, func(ctx context.Context) error {
+				return backup.Backup(ctx, &p.jobInfo)
+			})
+			if errs[idx] != nil {
+				helpers.AppLogger.Errorf("The job %s failed - %v", p.name, errs[idx])
+			} else {
+				helpers.AppLogger.Noticef("The job %s is done", p.name)
+			}
+		}(idx, p)
+	}
+	wg.Wait()
+
+	var failed int
+	var err error
+	for _, jerr := range errs {
+		if jerr != nil {
+			failed++
+			if err == nil {
+				err = jerr
+			}
+		}
+	}
+	if failed > 0 {
+		helpers.AppLogger.Errorf("%d of the %d jobs failed.", failed, len(prepared))
+	}
+	return err
+}
+
+// jobSetting is a setting a job is run with that is shared by the whole process.
+type jobSetting struct {
+	name  string
+	value string
+}
+
+// sharedJobSettings will return the process-wide settings of the job last prepared. The zfs commands
+// of every job run together are run on the same host with the same priority, their transfers are paused
+// or stopped together when the backup window closes, and they share the working directory, the staging
+// directory, and the keyrings.
+func sharedJobSettings() []jobSetting {
+	return []jobSetting{
+		{"working directory", helpers.WorkingDir},
+		{"temp directory", filepath.Dir(helpers.BackupTempdir)},
+		{"secret key ring", secretKeyRingPath},
+		{"public key ring", publicKeyRingPath},
+		{"zfs path", helpers.ZFSPath},
+		{"ssh host", helpers.SSHHost},
+		{"ssh port", strconv.Itoa(helpers.SSHPort)},
+		{"ssh identity file", helpers.SSHIdentityFile},
+		{"ssh path", helpers.SSHPath},
+		{"nice", strconv.Itoa(helpers.Nice)},
+		{"ionice", helpers.IONice},
+		{"backup window", windowSpec},
+		{"window policy", windowPolicy},
+	}
+}
+
+// mismatchedSetting will return the name of the first setting whose value differs between the
+// settings given, or an empty string if they all match.
+func mismatchedSetting(settings, other []jobSetting) string {
+	for idx, setting := range settings {
+		if setting != other[idx] {
+			return setting.name
+		}
+	}
+	return ""
+}
+
+// resetUnchangedFlags will set every flag of cmd that was not given on the command line back
+// to its default value, undoing what the config file of a previous job set.
+func resetUnchangedFlags(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			err = s.Replace(nil)
+		} else {
+			err = f.Value.Set(f.DefValue)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %v", f.Name, err)
+		}
+	})
+	return err
+}
+
+// readJobDefinitions will return the jobs defined in the config file sorted by name.
+func readJobDefinitions() ([]jobDefinition, error) {
+	settings, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, fmt.Errorf("no config file was found")
+	}
+
+	section, ok := settings[jobsSection]
+	if !ok {
+		return nil, nil
+	}
+	named, ok := section.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the %s section of the config file should be a map", jobsSection)
+	}
+
+	jobs := make([]jobDefinition, 0, len(named))
+	for name, values := range named {
+		section, err := configMap("jobs "+name, values)
+		if err != nil {
+			return nil, err
+		}
+
+		job := jobDefinition{Name: name, Command: "send", options: make(map[string]interface{})}
+		for key, value := range section.values {
+			if !jobKeys[key] {
+				job.options[key] = value
+				continue
+			}
+			switch key {
+			case "command":
+				job.Command = strings.ToLower(configValue(value))
+			case "dataset":
+				job.Dataset = configValue(value)
+			case "destinations":
+				job.Destinations = strings.Split(configValue(value), ",")
+			case "target":
+				job.Target = configValue(value)
+			case "schedule":
+				job.Schedule = configValue(value)
+			case "description":
+				job.Description = configValue(value)
+			}
+		}
+
+		if job.Dataset == "" || len(job.Destinations) == 0 {
+			return nil, fmt.Errorf("the job %s must provide a dataset and at least one destination", name)
+		}
+		if job.Command == "verify" && len(job.Destinations) > 1 {
+			return nil, fmt.Errorf("the job %s verifies a backup set at a single destination, but %d destinations are provided", name, len(job.Destinations))
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs, nil
+}
+
+func printJobDefinitions(jobs []jobDefinition) error {
+	if helpers.JSONOutput {
+		return printJSON(jobs)
+	}
+
+	if len(jobs) == 0 {
+		fmt.Fprintln(helpers.Stdout, "No jobs defined.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(helpers.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCOMMAND\tDATASET\tDESTINATIONS\tSCHEDULE\tDESCRIPTION")
+	for _, job := range jobs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", job.Name, job.Command, job.Dataset, strings.Join(job.Destinations, ","), job.Schedule, job.Description)
+	}
+	return w.Flush()
+}
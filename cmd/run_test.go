@@ -0,0 +1,176 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestJobDefinitions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupjobs")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer resetConfigFlags()
+
+	configFile = filepath.Join(dir, "config.yaml")
+	write := func(config string) {
+		if werr := ioutil.WriteFile(configFile, []byte(config), 0600); werr != nil {
+			t.Fatalf("could not write config: %v", werr)
+		}
+	}
+
+	write(`jobs:
+  restore:
+    command: receive
+    dataset: Tank/Dataset@snap1
+    destinations: [gs://bucket, s3://bucket]
+    target: Tank/Restored
+`)
+	jobs, err := readJobDefinitions()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("expected one job, got %+v (%v)", jobs, err)
+	}
+	expected := []string{"Tank/Dataset@snap1", "gs://bucket,s3://bucket", "Tank/Restored"}
+	if args := jobArgs(&jobs[0]); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected the receive job to run with %v, got %v", expected, args)
+	}
+
+	write(`jobs:
+  check:
+    command: verify
+    dataset: Tank/Dataset@snap1
+    destinations: [gs://bucket, s3://bucket]
+`)
+	if _, err = readJobDefinitions(); err == nil || !strings.Contains(err.Error(), "single destination") {
+		t.Errorf("expected a verify job with several destinations to be rejected, got %v", err)
+	}
+}
+
+func TestResetUnchangedFlags(t *testing.T) {
+	cmd := &cobra.Command{Use: "test"}
+	given := cmd.Flags().String("given", "default", "")
+	configured := cmd.Flags().String("configured", "default", "")
+	count := cmd.Flags().Int("count", 1, "")
+	list := cmd.Flags().StringSlice("list", nil, "")
+
+	// Flags given on the command line are marked as changed, the config file of a job sets them as is
+	if err := cmd.Flags().Set("given", "command line"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for name, value := range map[string]string{"configured": "job", "count": "5", "list": "a,b"} {
+		if err := cmd.Flags().Lookup(name).Value.Set(value); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	if err := resetUnchangedFlags(cmd); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if *given != "command line" {
+		t.Errorf("expected the flag given on the command line to be kept, got %s", *given)
+	}
+	if *configured != "default" || *count != 1 || len(*list) != 0 {
+		t.Errorf("expected the flags set by the job to be reset to their defaults, got %s, %d, and %v", *configured, *count, *list)
+	}
+}
+
+func TestMismatchedSetting(t *testing.T) {
+	oldWorkingDir, oldTempdir, oldIdentity, oldRing := helpers.WorkingDir, helpers.BackupTempdir, helpers.SSHIdentityFile, secretKeyRingPath
+	defer func() {
+		helpers.WorkingDir, helpers.BackupTempdir, helpers.SSHIdentityFile, secretKeyRingPath = oldWorkingDir, oldTempdir, oldIdentity, oldRing
+	}()
+
+	helpers.WorkingDir, helpers.BackupTempdir = "/var/lib/zfsbackup", "/var/lib/zfsbackup/temp/zfsbackup123"
+	helpers.SSHIdentityFile, secretKeyRingPath = "", ""
+	first := sharedJobSettings()
+
+	// Every job gets its own temporary directory in the staging directory
+	helpers.BackupTempdir = "/var/lib/zfsbackup/temp/zfsbackup456"
+	if name := mismatchedSetting(first, sharedJobSettings()); name != "" {
+		t.Errorf("expected the settings to match, got a different %s", name)
+	}
+
+	testCases := []struct {
+		name  string
+		apply func()
+		reset func()
+	}{
+		{"working directory", func() { helpers.WorkingDir = "/srv/zfsbackup" }, func() { helpers.WorkingDir = "/var/lib/zfsbackup" }},
+		{"temp directory", func() { helpers.BackupTempdir = "/mnt/staging/zfsbackup789" }, func() { helpers.BackupTempdir = "/var/lib/zfsbackup/temp/zfsbackup456" }},
+		{"secret key ring", func() { secretKeyRingPath = "/etc/zfsbackup/other.gpg" }, func() { secretKeyRingPath = "" }},
+		{"ssh identity file", func() { helpers.SSHIdentityFile = "/root/.ssh/other" }, func() { helpers.SSHIdentityFile = "" }},
+	}
+
+	for _, c := range testCases {
+		c.apply()
+		if name := mismatchedSetting(first, sharedJobSettings()); name != c.name {
+			t.Errorf("expected a different %s to be found, got %q", c.name, name)
+		}
+		c.reset()
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupjobs")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer resetConfigFlags()
+	defer resetRunFlags()
+
+	configFile = filepath.Join(dir, "config.yaml")
+	config := `jobs:
+  nightly:
+    dataset: Tank/Dataset
+    destinations: [gs://bucket]
+  restore:
+    command: receive
+    dataset: Tank/Dataset@snap1
+    destinations: [gs://bucket]
+    target: Tank/Restored
+`
+	if err = ioutil.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("could not write config: %v", err)
+	}
+
+	runParallel = 0
+	if err = runCmd.RunE(runCmd, []string{"nightly", "restore"}); err != errInvalidInput {
+		t.Errorf("expected --parallel 0 to be rejected, got %v", err)
+	}
+
+	// Only send jobs are run together
+	runParallel = 2
+	if err = runCmd.RunE(runCmd, []string{"nightly", "restore"}); err == nil || !strings.Contains(err.Error(), "only send jobs") {
+		t.Errorf("expected a receive job to be rejected, got %v", err)
+	}
+}
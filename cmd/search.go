@@ -0,0 +1,147 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	searchFilter    backup.SearchFilter
+	searchTags      []string
+	searchAfterStr  string
+	searchBeforeStr string
+	searchRefresh   bool
+)
+
+// searchCmd represents the search command
+var searchCmd = &cobra.Command{
+	Use:   "search [flags] uri",
+	Short: "search will find the backup sets of the provided target matching the dataset, tags, and dates provided.",
+	Long: `search will find the backup sets of the provided target matching the dataset, tags, and dates provided.
+
+Only the manifests found in the local cache of the target are searched, so no request is made to the
+target unless --refresh is provided. Every filter provided must match for a backup set to be returned.`,
+	PreRunE: validateSearchFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return backup.Search(context.Background(), &jobInfo, searchFilter, searchRefresh)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(searchCmd)
+
+	searchCmd.Flags().StringVar(&searchFilter.Dataset, "dataset", "", "only return backup sets of the datasets matching this glob (e.g. tank/*).")
+	searchCmd.Flags().StringArrayVar(&searchTags, "tag", nil, "only return backup sets tagged with this key=value pair, or with this key when no value is provided. Can be repeated.")
+	searchCmd.Flags().StringVar(&searchAfterStr, "after", "", "only return backup sets of snapshots taken after this date & time (format: yyyy-MM-dd or yyyy-MM-ddTHH:mm:ss, parsed in local TZ).")
+	searchCmd.Flags().StringVar(&searchBeforeStr, "before", "", "only return backup sets of snapshots taken before this date & time (format: yyyy-MM-dd or yyyy-MM-ddTHH:mm:ss, parsed in local TZ).")
+	searchCmd.Flags().BoolVar(&searchRefresh, "refresh", false, "sync the local cache with the target before searching it.")
+}
+
+// ResetSearchJobInfo exists solely for integration testing
+func ResetSearchJobInfo() {
+	resetRootFlags()
+	searchFilter = backup.SearchFilter{}
+	searchTags = nil
+	searchAfterStr = ""
+	searchBeforeStr = ""
+	searchRefresh = false
+}
+
+func validateSearchFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	_, err := backends.GetBackendForURI(args[0])
+	if err == backends.ErrInvalidPrefix {
+		helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", args[0])
+		return errInvalidInput
+	} else if err == backends.ErrInvalidURI {
+		helpers.AppLogger.Errorf("Invalid destination URI, was given %s", args[0])
+		return errInvalidInput
+	}
+	jobInfo.Destinations = []string{args[0]}
+
+	if searchFilter.Tags, err = parseTags(searchTags, true); err != nil {
+		helpers.AppLogger.Errorf("%v", err)
+		return errInvalidInput
+	}
+
+	if searchFilter.After, err = parseSearchTime(searchAfterStr); err != nil {
+		helpers.AppLogger.Errorf("could not parse after time '%s' due to error: %v", searchAfterStr, err)
+		return errInvalidInput
+	}
+
+	if searchFilter.Before, err = parseSearchTime(searchBeforeStr); err != nil {
+		helpers.AppLogger.Errorf("could not parse before time '%s' due to error: %v", searchBeforeStr, err)
+		return errInvalidInput
+	}
+
+	return nil
+}
+
+// parseTags will parse key=value pairs into a map. If allowEmpty is true, a key without a value is accepted.
+func parseTags(pairs []string, allowEmpty bool) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	tags := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid tag provided, expected format key=value, got %s instead", pair)
+		}
+		if len(parts) == 1 {
+			if !allowEmpty {
+				return nil, fmt.Errorf("invalid tag provided, expected format key=value, got %s instead", pair)
+			}
+			parts = append(parts, "")
+		}
+		tags[parts[0]] = parts[1]
+	}
+	return tags, nil
+}
+
+func parseSearchTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if len(value) == len("2006-01-02") {
+		return time.ParseInLocation("2006-01-02", value, time.Local)
+	}
+	if len(value) > len(time.RFC3339[:19]) {
+		return time.Parse(time.RFC3339, value)
+	}
+	return time.ParseInLocation(time.RFC3339[:19], value, time.Local)
+}
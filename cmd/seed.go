@@ -0,0 +1,99 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var seedDir string
+
+// seedCmd represents the seed command
+var seedCmd = &cobra.Command{
+	Use:   "seed [flags] snapshot directory uri(s)",
+	Short: "seed will write a full backup to a local directory, to be shipped to the destinations out of band, and register it with the destinations.",
+	Long: `seed will write a full backup to a local directory, to be shipped to the destinations out of band, and register it with the destinations.
+
+The volumes of the full backup of the snapshot are written, compressed, encrypted, and signed as
+usual, to the local directory (e.g. a disk to be mailed to the storage provider) while only its
+manifest is uploaded to the destinations. Incremental backups sent over the network then chain onto
+the seeded backup set right away. The content of the directory must be loaded into the destinations,
+keeping the relative path of every file as its object name, before the backup set can be restored.`,
+	PreRunE: validateSeedFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helpers.AppLogger.Infof("Writing the volumes of %s to %s", args[0], seedDir)
+		if err := runJob("send", func(ctx context.Context) error {
+			return backup.Seed(ctx, &jobInfo, seedDir)
+		}); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(helpers.Stdout, "\nShip the content of %s and load it into %s, keeping the relative path of every file as its object name. Run \"zfsbackup verify %s %s\" once loaded.\n", seedDir, strings.Join(jobInfo.Destinations, ", "), args[0], jobInfo.Destinations[0])
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(seedCmd)
+
+	seedCmd.Flags().StringSliceVar(&sendTags, "tag", nil, "a key=value pair to tag the backup set with, stored in its manifest and shown by list and search. Can be repeated.")
+	seedCmd.Flags().StringVar(&minRetention, "minRetention", "", "keep the backup set for at least this long after it was sent: clean refuses to delete it from a destination before then, whatever the flags it is given. A duration such as 30d, 4w, or 12h for every destination, or a comma separated list of them by backend, e.g. s3=90d,gs=30d. Recorded in the manifest.")
+	addVolumeFlags(seedCmd)
+}
+
+// ResetSeedJobInfo exists solely for integration testing
+func ResetSeedJobInfo() {
+	ResetSendJobInfo()
+	seedDir = ""
+}
+
+func validateSeedFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 3 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	if !strings.Contains(args[0], "@") {
+		helpers.AppLogger.Errorf("Invalid snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
+		return errInvalidInput
+	}
+
+	var err error
+	if seedDir, err = filepath.Abs(args[1]); err != nil {
+		helpers.AppLogger.Errorf("Invalid directory provided, was given %s - %v", args[1], err)
+		return errInvalidInput
+	}
+	if info, serr := os.Stat(seedDir); serr != nil || !info.IsDir() {
+		helpers.AppLogger.Errorf("The directory %s to write the volumes to must exist", seedDir)
+		return errInvalidInput
+	}
+
+	return validateSendFlags(cmd, []string{args[0], args[2]})
+}
@@ -23,6 +23,8 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,12 +39,20 @@ var (
 	jobInfo         helpers.JobInfo
 	fullIncremental string
 	maxUploadSpeed  uint64
+	maxMemory       uint64
 	passphrase      []byte
+	sendTags        []string
+	sendRemote      string
+	minRetention    string
+	objectTags      []string
+	objectMetadata  []string
+	sendExcludes    []string
+	sendDatasets    []string
 )
 
 // sendCmd represents the send command
 var sendCmd = &cobra.Command{
-	Use:     "send [flags] filesystem|volume|snapshot uri(s)",
+	Use:     "send [flags] filesystem|volume|snapshot|pattern uri(s)",
 	Short:   "send will backup of a ZFS volume similar to how the \"zfs send\" command works.",
 	Long:    `send take a subset of the`,
 	PreRunE: validateSendFlags,
@@ -52,6 +62,8 @@ var sendCmd = &cobra.Command{
 		helpers.AppLogger.Infof("Max Backoff Time will be %v", jobInfo.MaxBackoffTime)
 		helpers.AppLogger.Infof("Max Upload Retry Time will be %v", jobInfo.MaxRetryTime)
 		helpers.AppLogger.Infof("Upload Chunk Size will be %dMiB", jobInfo.UploadChunkSize)
+		helpers.AppLogger.Infof("Volume digests will be computed with %s", jobInfo.DigestAlgorithm)
+		helpers.AppLogger.Infof("Pipeline buffer sizes will be %dKiB (zfs send reads), %dKiB (compressor blocks), %dKiB (upload reads)", jobInfo.SendReadSize, jobInfo.CompressBlockSize, jobInfo.UploadReadSize)
 		if jobInfo.EncryptKey != nil {
 			helpers.AppLogger.Infof("Will be using encryption key for %s", jobInfo.EncryptTo)
 		}
@@ -60,7 +72,19 @@ var sendCmd = &cobra.Command{
 			helpers.AppLogger.Infof("Will be signed from %s", jobInfo.SignFrom)
 		}
 
-		return backup.Backup(context.Background(), &jobInfo)
+		if len(sendDatasets) > 0 {
+			// A dataset failing to be sent is not a usage error
+			cmd.SilenceUsage = true
+			return sendMatchingDatasets()
+		}
+
+		if dryRun {
+			return runDryRun(backup.PlanBackup)
+		}
+
+		return runJob("send", func(ctx context.Context) error {
+			return backup.Backup(ctx, &jobInfo)
+		})
 	},
 }
 
@@ -68,28 +92,61 @@ func init() {
 	RootCmd.AddCommand(sendCmd)
 
 	// ZFS send command options
-	sendCmd.Flags().BoolVarP(&jobInfo.Replication, "replication", "R", false, "See the -R flag on zfs send for more information")
-	sendCmd.Flags().BoolVarP(&jobInfo.Deduplication, "deduplication", "D", false, "See the -D flag for zfs send for more information.")
 	sendCmd.Flags().StringVarP(&jobInfo.IncrementalSnapshot.Name, "incremental", "i", "", "See the -i flag on zfs send for more information")
 	sendCmd.Flags().StringVarP(&fullIncremental, "intermediary", "I", "", "See the -I flag on zfs send for more information")
-	sendCmd.Flags().BoolVarP(&jobInfo.Properties, "properties", "p", false, "See the -p flag on zfs send for more information.")
 
 	// Specific to download only
-	sendCmd.Flags().Uint64Var(&jobInfo.VolumeSize, "volsize", 200, "the maximum size (in MiB) a volume should be before splitting to a new volume. Note: zfsbackup will try its best to stay close/under this limit but it is not garaunteed.")
-	sendCmd.Flags().IntVar(&jobInfo.CompressionLevel, "compressionLevel", 6, "the compression level to use with the compressor. Valid values are between 1-9.")
+	sendCmd.Flags().BoolVar(&dryRun, "dryRun", false, "print the snapshots that would be sent, their estimated size, the objects that would be created at every destination, and the zfs send command that would run without executing anything. Hooks are not run.")
 	sendCmd.Flags().BoolVar(&jobInfo.Resume, "resume", false, "set this flag to true when you want to try and resume a previously cancled or failed backup. It is up to the caller to ensure the same command line arguments are provided between the original backup and the resumed one.")
+	sendCmd.Flags().BoolVar(&jobInfo.SendAgain, "sendAgain", false, "send the backup set even if every destination already holds one of the same snapshots sent with the same options. Otherwise the send is skipped and succeeds, reporting the backup set as already backed up, so a retried job does not upload it again.")
 	sendCmd.Flags().BoolVar(&jobInfo.Full, "full", false, "set this flag to take a full backup of the specified volume using the most recent snapshot.")
 	sendCmd.Flags().BoolVar(&jobInfo.Incremental, "increment", false, "set this flag to do an incremental backup of the most recent snapshot from the most recent snapshot found in the target.")
 	sendCmd.Flags().DurationVar(&jobInfo.FullIfOlderThan, "fullIfOlderThan", -1*time.Minute, "set this flag to do an incremental backup of the most recent snapshot from the most recent snapshot found in the target unless the it's been greater than the time specified in this flag, then do a full backup.")
-	sendCmd.Flags().StringVar(&jobInfo.Compressor, "compressor", helpers.InternalCompressor, "specify to use the internal (parallel) gzip implementation or an external binary (e.g. gzip, bzip2, pigz, lzma, xz, etc.) Syntax must be similar to the gzip compression tool) to compress the stream for storage. Please take into consideration time, memory, and CPU usage for any of the compressors used. All manifests utilize the internal compressor. If value is zfs, the zfs stream will be created compressed. See the -c flag on zfs send for more information.")
-
-	sendCmd.Flags().IntVar(&jobInfo.MaxFileBuffer, "maxFileBuffer", 5, "the maximum number of files to have active during the upload process. Should be set to at least the number of max parallel uploads. Set to 0 to bypass local storage and upload straight to your destination - this will limit you to a single destination and disable any hash checks for the upload where available.")
-	sendCmd.Flags().IntVar(&jobInfo.MaxParallelUploads, "maxParallelUploads", 4, "the maximum number of uploads to run in parallel.")
-	sendCmd.Flags().Uint64Var(&maxUploadSpeed, "maxUploadSpeed", 0, "the maximum upload speed (in KB/s) the program should use between all upload workers. Use 0 for no limit")
-	sendCmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed upload. Use 0 for no limit.")
-	sendCmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an upload.")
-	sendCmd.Flags().StringVar(&jobInfo.Separator, "separator", "|", "the separator to use between object component names.")
-	sendCmd.Flags().IntVar(&jobInfo.UploadChunkSize, "uploadChunkSize", 10, "the chunk size, in MiB, to use when uploading. A minimum of 5MiB and maximum of 100MiB is enforced.")
+	sendCmd.Flags().IntVar(&jobInfo.MaxChainLength, "maxChainLength", 0, "used with --increment or --fullIfOlderThan, do a full backup instead of an incremental one once the chain of the last backup found in the target holds this many incremental backups, bounding the time to restore and the number of backups a single corrupted one makes unrestorable. Use 0 for no limit.")
+	sendCmd.Flags().StringSliceVar(&sendTags, "tag", nil, "a key=value pair to tag the backup set with, stored in its manifest and shown by list and search. Can be repeated. A backup set tagged keep=forever is never deleted by clean.")
+	sendCmd.Flags().StringVar(&minRetention, "minRetention", "", "keep the backup set for at least this long after it was sent: clean refuses to delete it from a destination before then, whatever the flags it is given. A duration such as 30d, 4w, or 12h for every destination, or a comma separated list of them by backend, e.g. s3=90d,gs=30d. Recorded in the manifest.")
+	sendCmd.Flags().DurationVar(&jobInfo.LeaseTTL, "leaseTTL", 0, "acquire a lease object for the volume at every destination, renewed for this duration until the backup is done, so a send of the same volume from another host (e.g. an HA pair) can't interleave backup sets with this one. A lease not renewed in time can be taken over. Use 0 to not take any lease.")
+	sendCmd.Flags().StringVar(&sendRemote, "remote", "", "the user@host[:port] to back up the volume of, the zfs commands (listing snapshots, looking up their creation dates, and zfs send) are run on it over ssh while the compression, encryption, and uploads are done locally. A shorthand for --sshHost and --sshPort.")
+	sendCmd.Flags().StringSliceVar(&sendExcludes, "exclude", nil, "a dataset, or a shell pattern of datasets, to leave out when the volume to send is a pattern such as tank/vm/*. Can be repeated.")
+	sendCmd.Flags().StringVar(&windowSpec, "window", "", "the time of the day, as HH:MM-HH:MM in the local time zone, the send may run in, e.g. 22:00-06:00. The send refuses to start outside of it, and what happens if it is still running when the window closes depends on --windowPolicy.")
+	sendCmd.Flags().StringVar(&windowPolicy, "windowPolicy", windowPolicyPause, "what to do when the --window closes while the send is still running: pause its uploads until the window opens again, or abort it, keeping its volumes staged so it can be run again with --resume.")
+	sendCmd.Flags().BoolVar(&jobInfo.StealLease, "stealLease", false, "take over the lease of the volume at the destinations even if another host holds it and it is not expired. Requires --leaseTTL.")
+	addVolumeFlags(sendCmd)
+}
+
+// addVolumeFlags will add the flags controlling how the zfs send stream is created, split into volumes, and uploaded.
+func addVolumeFlags(cmd *cobra.Command) {
+	// ZFS send command options
+	cmd.Flags().BoolVarP(&jobInfo.Replication, "replication", "R", false, "See the -R flag on zfs send for more information")
+	cmd.Flags().BoolVarP(&jobInfo.Deduplication, "deduplication", "D", false, "See the -D flag for zfs send for more information.")
+	cmd.Flags().BoolVarP(&jobInfo.Properties, "properties", "p", false, "See the -p flag on zfs send for more information.")
+
+	cmd.Flags().Uint64Var(&jobInfo.VolumeSize, "volsize", 200, "the maximum size (in MiB) a volume should be before splitting to a new volume. Note: zfsbackup will try its best to stay close/under this limit but it is not garaunteed.")
+	cmd.Flags().BoolVar(&jobInfo.VolumeSizeOutput, "volsizeOutput", false, "keep every volume under the volsize once compressed and encrypted, rather than splitting them once that much was written out, which the compressor may overshoot by the blocks it holds. Every volume but the last then ends up within about 64KiB of the volsize, whatever the data compresses to, at the cost of flushing the compressor as it fills up. External compressors can not be flushed, volumes are then split as if the data did not compress. Can not be used with contentAddressed or chunking.")
+	cmd.Flags().IntVar(&jobInfo.CompressionLevel, "compressionLevel", 6, "the compression level to use with the compressor. Valid values are between 1-9.")
+	cmd.Flags().StringVar(&jobInfo.DigestAlgorithm, "digestAlgorithm", helpers.DigestSHA256, "the algorithm of a second digest of every volume, recorded in the manifest along with its SHA256 digest and checked with it whenever the volume is downloaded. Possible values are sha256 (no second digest), blake3, and xxh3 (not cryptographic).")
+	cmd.Flags().StringVar(&jobInfo.Compressor, "compressor", helpers.InternalCompressor, "specify to use the internal (parallel) gzip implementation, a codec defined with --codec, or an external binary (e.g. gzip, bzip2, pigz, lzma, xz, etc.) Syntax must be similar to the gzip compression tool) to compress the stream for storage. Please take into consideration time, memory, and CPU usage for any of the compressors used. All manifests utilize the internal compressor. If value is zfs, the zfs stream will be created compressed. See the -c flag on zfs send for more information.")
+
+	cmd.Flags().IntVar(&jobInfo.MaxFileBuffer, "maxFileBuffer", 5, "the maximum number of files to have active during the upload process. Should be set to at least the number of max parallel uploads. Set to 0 to bypass local storage and upload straight to your destinations, all of them at once - this will disable any hash checks for the upload where available.")
+	cmd.Flags().BoolVar(&jobInfo.ContentAddressed, "contentAddressed", false, "name the volumes by the SHA256 digest of their content instead of their backup set and volume number, and skip the upload of those already stored at a destination, so identical volumes, e.g. of the same snapshot sent again, are stored once. clean only deletes a volume once no backup set references it. Volumes only match when they are neither encrypted nor signed. Requires --maxFileBuffer to be greater than 0.")
+	cmd.Flags().IntVar(&jobInfo.ParityVolumes, "parityVolumes", 0, "the number of Reed-Solomon parity volumes to compute for every --parityGroupSize volumes and upload along with them. receive and verify rebuild a missing or corrupt volume from the other volumes of its group and the parity volumes, as long as no more volumes of the group than there are parity volumes are damaged. Requires --maxFileBuffer to be greater than 0. Use 0 for no parity volumes.")
+	cmd.Flags().IntVar(&jobInfo.ParityGroupSize, "parityGroupSize", helpers.DefaultParityGroupSize, "the number of volumes every group of --parityVolumes parity volumes protects, the last group of a backup set may hold fewer. The volumes of a group are downloaded at once to rebuild one of them.")
+	cmd.Flags().StringVar(&jobInfo.Chunking, "chunking", "", "set to cdc to split the data of the zfs send stream into chunks at boundaries found in the data itself, stored by their content and shared with every backup set of the destination, instead of into volumes of --volsize. The records of the stream are kept in layout volumes of the backup set, so repeated full backups only upload the data that changed. Chunks only match when they are neither encrypted nor signed. Requires --maxFileBuffer to be greater than 0.")
+	cmd.Flags().IntVar(&jobInfo.ChunkSize, "chunkSize", helpers.DefaultChunkSize, "the average size, in KiB, of the chunks of --chunking cdc, a power of two between 64 and 16384. Chunks are between a quarter and four times as large.")
+	cmd.Flags().IntVar(&jobInfo.MaxParallelUploads, "maxParallelUploads", 4, "the maximum number of uploads to run in parallel.")
+	cmd.Flags().BoolVar(&jobInfo.AutoTuneUploads, "autoTuneUploads", false, "adjust the number of parallel uploads (and the parts of them in flight for the s3 and b2 destinations) while the send runs instead of keeping --maxParallelUploads, the number it starts with: one more is allowed while the throughput improves, half as many once an upload fails.")
+	cmd.Flags().IntVar(&jobInfo.AutoTuneMaxUploads, "autoTuneMaxUploads", 16, "the highest number of parallel uploads --autoTuneUploads may reach.")
+	cmd.Flags().Uint64Var(&maxUploadSpeed, "maxUploadSpeed", 0, "the maximum upload speed (in KB/s) the program should use between all upload workers. Use 0 for no limit")
+	cmd.Flags().Uint64Var(&maxMemory, "maxMemory", 0, "the maximum memory (in MiB) the buffers of the uploads, the internal compressor, and the volumes staged in a tmpfs mount should use together. The number of parallel uploads, cores, and volumes staged are lowered, in that order, until they fit. Use 0 for no limit")
+	cmd.Flags().DurationVar(&jobInfo.MaxRetryTime, "maxRetryTime", 12*time.Hour, "the maximum time that can elapse when retrying a failed upload. Use 0 for no limit.")
+	cmd.Flags().DurationVar(&jobInfo.MaxBackoffTime, "maxBackoffTime", 30*time.Minute, "the maximum delay you'd want a worker to sleep before retrying an upload.")
+	cmd.Flags().StringVar(&jobInfo.Separator, "separator", "|", "the separator to use between object component names.")
+	cmd.Flags().IntVar(&jobInfo.UploadChunkSize, "uploadChunkSize", 10, "the chunk size, in MiB, to use when uploading. A minimum of 5MiB and maximum of 100MiB is enforced.")
+	cmd.Flags().IntVar(&jobInfo.SendReadSize, "sendReadSize", helpers.DefaultSendReadSize, "the size, in KiB, of the reads of the zfs send stream, volumes are split between reads. Larger reads help fast pipelines, smaller ones keep volumes closer to volsize. Between 4KiB and 64MiB.")
+	cmd.Flags().IntVar(&jobInfo.CompressBlockSize, "compressorBlockSize", helpers.DefaultCompressorBlockSize, "the size, in KiB, of the blocks the internal compressor compresses in parallel, up to one per core. Larger blocks compress better and faster at the cost of memory. Between 32KiB and 64MiB.")
+	cmd.Flags().StringSliceVar(&objectTags, "objectTag", nil, "a key=value pair to tag every volume and manifest uploaded to the s3 destinations with, or to set as their metadata at the gs and azure destinations, e.g. for cost allocation or lifecycle rules. The value is a Go text/template rendered with the fields {{.Dataset}}, {{.Snapshot}}, {{.SnapshotDate}}, {{.BackupType}} (full or incremental), and {{.Job}}. Keys are letters, digits, and underscores. Can be repeated.")
+	cmd.Flags().StringSliceVar(&objectMetadata, "objectMetadata", nil, "a key=value pair to set as metadata of every volume and manifest uploaded to the s3, gs, and azure destinations, taking precedence over an --objectTag of the same key. The value is a template like the one of --objectTag. Can be repeated.")
+	cmd.Flags().IntVar(&jobInfo.UploadReadSize, "uploadReadSize", helpers.DefaultUploadReadSize, "the size, in KiB, of the reads of the volumes uploaded to the file, gs, and b2 destinations (the azure and s3 destinations read them in uploadChunkSize chunks). Between 4KiB and 64MiB.")
 }
 
 // ResetSendJobInfo exists solely for integration testing
@@ -101,28 +158,59 @@ func ResetSendJobInfo() {
 	jobInfo.IncrementalSnapshot = helpers.SnapshotInfo{}
 	jobInfo.BaseSnapshot = helpers.SnapshotInfo{}
 	fullIncremental = ""
+	sendTags = nil
+	minRetention = ""
+	objectTags = nil
+	objectMetadata = nil
+	sendRemote = ""
+	sendExcludes = nil
+	sendDatasets = nil
+	windowSpec = ""
+	windowPolicy = windowPolicyPause
+	backupWindow = nil
+	jobInfo.Tags = nil
+	jobInfo.ObjectTags = nil
+	jobInfo.ObjectMetadata = nil
+	jobInfo.JobName = ""
 	jobInfo.Properties = false
+	dryRun = false
 
 	// Specific to download only
 	jobInfo.VolumeSize = 200
+	jobInfo.VolumeSizeOutput = false
 	jobInfo.CompressionLevel = 6
+	jobInfo.DigestAlgorithm = helpers.DigestSHA256
 	jobInfo.Resume = false
+	jobInfo.SendAgain = false
 	jobInfo.Full = false
 	jobInfo.Incremental = false
 	jobInfo.FullIfOlderThan = -1 * time.Minute
+	jobInfo.MaxChainLength = 0
+	jobInfo.LeaseTTL = 0
+	jobInfo.StealLease = false
 
 	jobInfo.MaxFileBuffer = 5
+	jobInfo.ContentAddressed = false
+	jobInfo.ParityVolumes = 0
+	jobInfo.ParityGroupSize = helpers.DefaultParityGroupSize
+	jobInfo.Chunking = ""
+	jobInfo.ChunkSize = helpers.DefaultChunkSize
 	jobInfo.MaxParallelUploads = 4
+	jobInfo.AutoTuneUploads = false
+	jobInfo.AutoTuneMaxUploads = 16
 	maxUploadSpeed = 0
+	maxMemory = 0
 	jobInfo.MaxRetryTime = 12 * time.Hour
 	jobInfo.MaxBackoffTime = 30 * time.Minute
 	jobInfo.Separator = "|"
 	jobInfo.UploadChunkSize = 10
+	jobInfo.SendReadSize = helpers.DefaultSendReadSize
+	jobInfo.CompressBlockSize = helpers.DefaultCompressorBlockSize
+	jobInfo.UploadReadSize = helpers.DefaultUploadReadSize
 	jobInfo.Compressor = helpers.InternalCompressor
 }
 
 func updateJobInfo(args []string) error {
-	jobInfo.StartTime = time.Now()
 	jobInfo.Version = helpers.VersionNumber
 
 	if fullIncremental != "" {
@@ -130,32 +218,80 @@ func updateJobInfo(args []string) error {
 		jobInfo.IntermediaryIncremental = true
 	}
 
+	tags, terr := parseTags(sendTags, false)
+	if terr != nil {
+		helpers.AppLogger.Errorf("%v", terr)
+		return errInvalidInput
+	}
+	jobInfo.Tags = tags
+
+	retention, rerr := helpers.ParseMinRetention(minRetention)
+	if rerr != nil {
+		helpers.AppLogger.Errorf("%v", rerr)
+		return errInvalidInput
+	}
+	jobInfo.MinRetention = retention
+
+	if err := parseObjectLabels(); err != nil {
+		return err
+	}
+
 	parts := strings.Split(args[0], "@")
 	jobInfo.VolumeName = parts[0]
 	jobInfo.Destinations = strings.Split(args[1], ",")
+	sendDatasets = nil
 
-	if len(jobInfo.Destinations) > 1 && jobInfo.MaxFileBuffer == 0 {
-		helpers.AppLogger.Errorf("Specifying multiple destinations and a MaxFileBuffer size of 0 is unsupported.")
-		return errInvalidInput
+	if err := validateDestinationURIs(jobInfo.Destinations); err != nil {
+		return err
 	}
 
-	for _, destination := range jobInfo.Destinations {
-		_, err := backends.GetBackendForURI(destination)
-		if err == backends.ErrInvalidPrefix {
-			helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", destination)
-			return err
-		} else if err == backends.ErrInvalidURI {
-			helpers.AppLogger.Errorf("Unsupported destination URI, was given %s", destination)
-			return err
-		}
+	pattern := helpers.IsDatasetPattern(jobInfo.VolumeName)
+	if len(sendExcludes) > 0 && !pattern {
+		helpers.AppLogger.Errorf("The --exclude flag can only be used when the volume to send is a pattern, e.g. tank/vm/*.")
+		return errInvalidInput
 	}
 
 	// If we aren't using a "smart" option, rely on the user to provide the snapshots to use!
 	if !jobInfo.Full && !jobInfo.Incremental && jobInfo.FullIfOlderThan == -1*time.Minute {
+		if pattern {
+			helpers.AppLogger.Errorf("When sending every dataset matching a pattern, please use a smart option (--full, --increment, or --fullIfOlderThan) as their snapshots differ.")
+			return errInvalidInput
+		}
 		if len(parts) != 2 {
 			helpers.AppLogger.Errorf("Invalid base snapshot provided. Expected format <volume>@<snapshot>, got %s instead", args[0])
 			return errInvalidInput
 		}
+	} else {
+		// Some basic checks here
+		onlyOneCheck := 0
+		if jobInfo.Full {
+			onlyOneCheck++
+		}
+		if jobInfo.Incremental {
+			onlyOneCheck++
+		}
+		if jobInfo.FullIfOlderThan != -1*time.Minute {
+			onlyOneCheck++
+		}
+		if onlyOneCheck > 1 {
+			helpers.AppLogger.Errorf("Please specify only one \"smart\" option at a time")
+			return errInvalidInput
+		}
+		if len(parts) != 1 {
+			helpers.AppLogger.Errorf("When using a smart option, please only specify the volume to backup, do not include any snapshot information.")
+			return errInvalidInput
+		}
+	}
+
+	return nil
+}
+
+// resolveSendSnapshots will look up the snapshots to send, once the preHook (e.g. taking the snapshot) ran.
+func resolveSendSnapshots(args []string) error {
+	jobInfo.StartTime = time.Now()
+
+	parts := strings.Split(args[0], "@")
+	if !jobInfo.Full && !jobInfo.Incremental && jobInfo.FullIfOlderThan == -1*time.Minute {
 		jobInfo.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
 		creationTime, err := helpers.GetCreationDate(context.TODO(), args[0])
 		if err != nil {
@@ -176,24 +312,9 @@ func updateJobInfo(args []string) error {
 			jobInfo.IncrementalSnapshot.CreationTime = creationTime
 		}
 	} else {
-		// Some basic checks here
-		onlyOneCheck := 0
-		if jobInfo.Full {
-			onlyOneCheck++
-		}
-		if jobInfo.Incremental {
-			onlyOneCheck++
-		}
-		if jobInfo.FullIfOlderThan != -1*time.Minute {
-			onlyOneCheck++
-		}
-		if onlyOneCheck > 1 {
-			helpers.AppLogger.Errorf("Please specify only one \"smart\" option at a time")
-			return errInvalidInput
-		}
-		if len(parts) != 1 {
-			helpers.AppLogger.Errorf("When using a smart option, please only specify the volume to backup, do not include any snapshot information.")
-			return errInvalidInput
+		// The smart option is processed for every dataset matching a pattern once it is sent
+		if helpers.IsDatasetPattern(jobInfo.VolumeName) {
+			return matchSendDatasets()
 		}
 		if err := backup.ProcessSmartOptions(context.Background(), &jobInfo); err != nil {
 			helpers.AppLogger.Errorf("Error while trying to process smart option - %v", err)
@@ -205,21 +326,200 @@ func updateJobInfo(args []string) error {
 	return nil
 }
 
+// matchSendDatasets will expand the pattern given as the volume to send to the datasets matching it, leaving
+// out the excluded ones.
+func matchSendDatasets() error {
+	datasets, err := helpers.MatchDatasets(context.Background(), jobInfo.VolumeName, sendExcludes)
+	if err != nil {
+		helpers.AppLogger.Errorf("Could not list the datasets matching %s - %v", jobInfo.VolumeName, err)
+		return err
+	}
+	if len(datasets) == 0 {
+		helpers.AppLogger.Errorf("No dataset matches %s.", jobInfo.VolumeName)
+		return errInvalidInput
+	}
+	helpers.AppLogger.Noticef("Sending the %d datasets matching %s: %s", len(datasets), jobInfo.VolumeName, strings.Join(datasets, ", "))
+	sendDatasets = datasets
+	return nil
+}
+
+// sendMatchingDatasets will send every dataset matching the pattern given as the volume to send, one after
+// the other. A dataset that fails to be sent does not stop the others, but interrupting the send or its
+// backup window closing does, and a dataset with nothing new to send with --increment is skipped.
+func sendMatchingDatasets() error {
+	base := jobInfo
+	var failed int
+	var err error
+	for _, dataset := range sendDatasets {
+		jobInfo = base
+		jobInfo.VolumeName = dataset
+
+		serr := backup.ProcessSmartOptions(context.Background(), &jobInfo)
+		if serr == backup.ErrNoOp {
+			helpers.AppLogger.Noticef("Nothing new to send for %s, skipping it.", dataset)
+			continue
+		}
+		if serr == nil && dryRun {
+			serr = runDryRun(backup.PlanBackup)
+		} else if serr == nil {
+			if serr = checkWindow("the send of " + dataset); serr != nil {
+				return serr
+			}
+			helpers.AppLogger.Noticef("Sending %s", dataset)
+			serr = runJob("send", func(ctx context.Context) error {
+				return backup.Backup(ctx, &jobInfo)
+			})
+		}
+
+		if serr == errInterrupted {
+			return serr
+		}
+		if serr != nil {
+			helpers.AppLogger.Errorf("Could not send %s - %v", dataset, serr)
+			failed++
+			if err == nil {
+				err = serr
+			}
+		}
+	}
+	if failed > 0 {
+		helpers.AppLogger.Errorf("%d of the %d datasets could not be sent.", failed, len(sendDatasets))
+	}
+	return err
+}
+
+// validateDestinationURIs will check every destination URI provided is supported.
+func validateDestinationURIs(destinations []string) error {
+	for _, destination := range destinations {
+		_, err := backends.GetBackendForURI(destination)
+		if err == backends.ErrInvalidPrefix {
+			helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", destination)
+			return err
+		} else if err == backends.ErrInvalidURI {
+			helpers.AppLogger.Errorf("Unsupported destination URI, was given %s", destination)
+			return err
+		}
+	}
+	return nil
+}
+
 func validateSendFlags(cmd *cobra.Command, args []string) error {
 	if len(args) != 2 {
 		cmd.Usage()
 		return errInvalidInput
 	}
 
+	if err := parseWindowFlags(); err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := checkWindow("the send of " + args[0]); err != nil {
+			// Being outside of the backup window is not a usage error
+			cmd.SilenceUsage = true
+			return err
+		}
+	}
+
+	if err := setRemote(sendRemote); err != nil {
+		return err
+	}
+
 	if jobInfo.IncrementalSnapshot.Name != "" && fullIncremental != "" {
 		helpers.AppLogger.Errorf("The flags -i and -I are mutually exclusive. Please specify only one of these flags.")
 		return errInvalidInput
 	}
 
-	if err := jobInfo.ValidateSendFlags(); err != nil {
+	if err := jobInfo.ValidateSendFlags(context.Background()); err != nil {
 		helpers.AppLogger.Error(err)
 		return err
 	}
 
-	return updateJobInfo(args)
+	if err := checkStagingSpace(&jobInfo); err != nil {
+		return err
+	}
+
+	if err := fitMemory(&jobInfo); err != nil {
+		return err
+	}
+
+	if err := updateJobInfo(args); err != nil {
+		return err
+	}
+
+	if err := runPreHook("send", args); err != nil {
+		return err
+	}
+
+	if err := resolveSendSnapshots(args); err != nil {
+		runFailedPostHook("send", err)
+		return err
+	}
+	return nil
+}
+
+// setRemote will point the zfs commands at the user@host[:port] given with --remote, if any.
+func setRemote(remote string) error {
+	if remote == "" {
+		return nil
+	}
+
+	user, host, port := "", remote, 0
+	if idx := strings.LastIndex(host, "@"); idx != -1 {
+		user, host = host[:idx+1], host[idx+1:]
+	}
+	// Anything that isn't host:port (e.g. a bare IPv6 address) is taken as the host
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		n, perr := strconv.Atoi(p)
+		if perr != nil || n <= 0 || n > 65535 {
+			helpers.AppLogger.Errorf("The port of the remote host provided (%s) is invalid.", remote)
+			return errInvalidInput
+		}
+		host, port = h, n
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		// A bracketed IPv6 address without a port, ssh expects it without the brackets
+		host = host[1 : len(host)-1]
+	}
+
+	if host == "" {
+		helpers.AppLogger.Errorf("The remote host provided (%s) is invalid. Expected format [user@]host[:port].", remote)
+		return errInvalidInput
+	}
+	host = user + host
+
+	if (helpers.SSHHost != "" && helpers.SSHHost != host) || (helpers.SSHPort != 0 && port != 0 && helpers.SSHPort != port) {
+		helpers.AppLogger.Errorf("The --remote flag (%s) conflicts with the --sshHost/--sshPort flags provided (%s:%d).", remote, helpers.SSHHost, helpers.SSHPort)
+		return errInvalidInput
+	}
+
+	helpers.SSHHost = host
+	if port != 0 {
+		helpers.SSHPort = port
+	}
+	helpers.AppLogger.Infof("Running the zfs commands on %s over ssh.", remote)
+	return nil
+}
+
+// parseObjectLabels will set the object tags and metadata of the jobInfo from the flags provided, confirming they render.
+func parseObjectLabels() error {
+	tags, err := parseTags(objectTags, false)
+	if err != nil {
+		helpers.AppLogger.Errorf("%v", err)
+		return errInvalidInput
+	}
+	metadata, err := parseTags(objectMetadata, false)
+	if err != nil {
+		helpers.AppLogger.Errorf("%v", err)
+		return errInvalidInput
+	}
+
+	for _, labels := range []map[string]string{tags, metadata} {
+		if _, err = jobInfo.RenderObjectLabels(labels); err != nil {
+			helpers.AppLogger.Errorf("%v", err)
+			return errInvalidInput
+		}
+	}
+
+	jobInfo.ObjectTags = tags
+	jobInfo.ObjectMetadata = metadata
+	return nil
 }
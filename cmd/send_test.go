@@ -0,0 +1,77 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestSetRemote(t *testing.T) {
+	oldHost, oldPort := helpers.SSHHost, helpers.SSHPort
+	defer func() { helpers.SSHHost, helpers.SSHPort = oldHost, oldPort }()
+
+	testCases := []struct {
+		remote      string
+		sshHost     string
+		sshPort     int
+		expectHost  string
+		expectPort  int
+		expectError bool
+	}{
+		{"", "", 0, "", 0, false},
+		{"agent", "", 0, "agent", 0, false},
+		{"backup@agent", "", 0, "backup@agent", 0, false},
+		{"backup@agent:2222", "", 0, "backup@agent", 2222, false},
+		{"agent:22", "", 0, "agent", 22, false},
+		{"[::1]:2222", "", 0, "::1", 2222, false},
+		{"backup@[fe80::1]:2222", "", 0, "backup@fe80::1", 2222, false},
+		{"fe80::1", "", 0, "fe80::1", 0, false},
+		{"[::1]", "", 0, "::1", 0, false},
+		{"backup@[fe80::1]", "", 0, "backup@fe80::1", 0, false},
+		{"[]", "", 0, "", 0, true},
+		{"agent:0", "", 0, "", 0, true},
+		{"agent:65536", "", 0, "", 0, true},
+		{"agent:ssh", "", 0, "", 0, true},
+		{"agent:", "", 0, "", 0, true},
+		{":2222", "", 0, "", 0, true},
+		{"backup@", "", 0, "", 0, true},
+		{"backup@:2222", "", 0, "", 0, true},
+		// Combined with --sshHost and --sshPort
+		{"agent", "agent", 2222, "agent", 2222, false},
+		{"agent:2222", "agent", 2222, "agent", 2222, false},
+		{"agent:22", "agent", 2222, "", 0, true},
+		{"other", "agent", 0, "", 0, true},
+	}
+
+	for idx, c := range testCases {
+		helpers.SSHHost, helpers.SSHPort = c.sshHost, c.sshPort
+		err := setRemote(c.remote)
+		if (err != nil) != c.expectError {
+			t.Errorf("%d: expected error %v, got %v", idx, c.expectError, err)
+			continue
+		}
+		if !c.expectError && (helpers.SSHHost != c.expectHost || helpers.SSHPort != c.expectPort) {
+			t.Errorf("%d: expected %s:%d, got %s:%d", idx, c.expectHost, c.expectPort, helpers.SSHHost, helpers.SSHPort)
+		}
+	}
+}
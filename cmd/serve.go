@@ -0,0 +1,454 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/dashboard"
+	"github.com/someone1/zfsbackup-go/helpers"
+	"github.com/someone1/zfsbackup-go/metrics"
+	"github.com/someone1/zfsbackup-go/rpc"
+)
+
+var (
+	grpcAddr         string
+	httpAddr         string
+	dashboardTargets string
+	runOnce          bool
+	runOnceJob       string
+	resultFile       string
+	maxJobs          int
+	maxUploads       int
+	serializePools   bool
+	tlsCert          string
+	tlsKey           string
+	tlsClientCA      string
+	authTokenFile    string
+)
+
+// unixPrefix is the prefix of a --grpcAddr naming a unix socket to listen on.
+const unixPrefix = "unix://"
+
+// runOnceResult is the outcome of a job run with --runOnce, written to the --resultFile.
+type runOnceResult struct {
+	Job string
+	control.Result
+	ExitCode int
+}
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve [flags]",
+	Short: "serve will run zfsbackup as a daemon exposing its operations over gRPC.",
+	Long: `serve will run zfsbackup as a daemon exposing the send, receive, list, and verify
+operations over gRPC. Progress for long running operations is streamed back to the
+caller. The global flags (keyrings, manifestPrefix, encryptTo, signFrom, etc.) apply to
+every request the daemon serves.
+
+Provide an HTTP address to also serve Prometheus metrics at /metrics and a web dashboard
+showing the backup history and chain health of every dataset found in the dashboard
+targets along with the recent jobs run by the daemon. Liveness checks are answered at
+/livez (or /healthz), and readiness checks at /readyz, which fail while the daemon starts
+up or shuts down.
+
+With --runOnce, the job definition named --job is run instead, as the run command would,
+answering the liveness and readiness checks on the HTTP address while it runs. The
+command exits with the exit code of the job, and its outcome is written as JSON to the
+--resultFile, e.g. /dev/termination-log as the termination message of a Kubernetes pod.
+
+So that many jobs requested at once don't swamp the pools and the network, --maxJobs
+caps how many of them run at the same time, --maxUploads how many volumes they upload
+at the same time together, and --serializePools runs only one send or receive per pool
+at a time. Jobs over a limit wait for their turn.
+
+The gRPC requests can receive over datasets, so they are only served to anyone on the
+host on a loopback address, or to its owner on a unix socket (e.g.
+unix:///run/zfsbackup.sock). To listen on any other address, serve them over TLS with
+--tlsCert and --tlsKey and require either a client certificate issued by --tlsClientCA or
+the bearer token found in --authTokenFile in the authorization metadata of every request.`,
+	PreRunE: validateServeFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		helpers.UploadSlots = helpers.NewSemaphore(maxUploads)
+		if runOnce {
+			return serveOnce(cmd)
+		}
+
+		opts, err := grpcServerOptions()
+		if err != nil {
+			return err
+		}
+		lis, err := listenGRPC(grpcAddr)
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not listen on %s due to error - %v", grpcAddr, err)
+			return err
+		}
+
+		server := grpc.NewServer(opts...)
+		m := metrics.New()
+		rpcServer := rpc.NewServer(jobInfo)
+		rpcServer.Limit(maxJobs, serializePools)
+		rpcServer.AddObserver(m)
+		if s := newStatsD(); s != nil {
+			defer s.Close()
+			rpcServer.AddObserver(s)
+		}
+		if n, nerr := newNotifier(); nerr != nil {
+			return nerr
+		} else if n != nil {
+			rpcServer.AddObserver(n)
+		}
+		rpcServer.Register(server)
+
+		ready := &readiness{}
+		var httpServer *http.Server
+		if httpAddr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", m.Handler())
+			mux.HandleFunc(healthPath, serveHealth)
+			mux.HandleFunc(livePath, serveHealth)
+			mux.Handle(readyPath, ready)
+			mux.Handle("/", dashboard.New(jobInfo, splitTargets(dashboardTargets), rpcServer.History()))
+			httpServer = &http.Server{Addr: httpAddr, Handler: mux}
+			go func() {
+				helpers.AppLogger.Noticef("Serving the dashboard on http://%s", httpAddr)
+				if herr := httpServer.ListenAndServe(); herr != nil && herr != http.ErrServerClosed {
+					helpers.AppLogger.Errorf("Could not serve the dashboard on %s due to error - %v", httpAddr, herr)
+				}
+			}()
+		}
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			sig := <-sigs
+			helpers.AppLogger.Noticef("Received %v, shutting down.", sig)
+			ready.set(false)
+			if httpServer != nil {
+				httpServer.Close()
+			}
+			server.GracefulStop()
+		}()
+
+		helpers.AppLogger.Noticef("Listening for gRPC requests on %s", lis.Addr())
+		ready.set(true)
+		return server.Serve(lis)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&grpcAddr, "grpcAddr", "127.0.0.1:50051", "the address to listen on for gRPC requests, or unix:///path/to/socket to listen on a unix socket only its owner may connect to. Any address but a loopback one or a unix socket requires --tlsCert, --tlsKey, and either --tlsClientCA or --authTokenFile.")
+	serveCmd.Flags().StringVar(&tlsCert, "tlsCert", "", "the path of the PEM encoded certificate to serve the gRPC requests over TLS with.")
+	serveCmd.Flags().StringVar(&tlsKey, "tlsKey", "", "the path of the PEM encoded private key of --tlsCert.")
+	serveCmd.Flags().StringVar(&tlsClientCA, "tlsClientCA", "", "the path of the PEM encoded certificates of the authorities issuing the client certificates every gRPC request must be made with, requires --tlsCert.")
+	serveCmd.Flags().StringVar(&authTokenFile, "authTokenFile", "", "the path of a file holding the token every gRPC request must carry as \"authorization: Bearer <token>\" metadata.")
+	serveCmd.Flags().StringVar(&httpAddr, "httpAddr", "", "the address to serve the web dashboard and Prometheus metrics on, e.g. 127.0.0.1:8080. Both are disabled if not provided.")
+	serveCmd.Flags().StringVar(&dashboardTargets, "dashboardTargets", "", "a comma separated list of destination URIs whose backup sets should be shown in the dashboard.")
+	serveCmd.Flags().Uint64Var(&maxUploadSpeed, "maxUploadSpeed", 0, "the maximum upload speed (in KB/s) of every send served by the daemon together, they all draw from the same budget. Use 0 for no limit")
+	serveCmd.Flags().IntVar(&maxJobs, "maxJobs", 0, "the maximum number of send, receive, and verify jobs the daemon runs at the same time, the others wait for their turn. Use 0 for no limit")
+	serveCmd.Flags().IntVar(&maxUploads, "maxUploads", 0, "the maximum number of volumes every job served by the daemon uploads at the same time together, on top of the maxParallelUploads of each job. Use 0 for no limit")
+	serveCmd.Flags().BoolVar(&serializePools, "serializePools", false, "run only one send or receive job per ZFS pool at a time, the others wait for their turn.")
+	serveCmd.Flags().BoolVar(&runOnce, "runOnce", false, "run the job definition named --job once instead of serving gRPC requests, answering the liveness and readiness checks on --httpAddr while it runs, and exit with the exit code of the job.")
+	serveCmd.Flags().StringVar(&runOnceJob, "job", "", "the name of the job definition in the config file to run with --runOnce.")
+	serveCmd.Flags().StringVar(&resultFile, "resultFile", "", "the path of a file to write the outcome of the --runOnce job to as JSON (job, operation, volume, snapshot, destinations, start and end time, bytes, error, and exit code), e.g. /dev/termination-log.")
+}
+
+// ResetServeJobInfo exists solely for integration testing
+func ResetServeJobInfo() {
+	resetRootFlags()
+	grpcAddr = "127.0.0.1:50051"
+	httpAddr = ""
+	dashboardTargets = ""
+	maxUploadSpeed = 0
+	maxJobs = 0
+	maxUploads = 0
+	serializePools = false
+	tlsCert = ""
+	tlsKey = ""
+	tlsClientCA = ""
+	authTokenFile = ""
+	helpers.UploadSlots = nil
+	runOnce = false
+	runOnceJob = ""
+	resultFile = ""
+}
+
+func validateServeFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 0 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	if maxJobs < 0 || maxUploads < 0 {
+		helpers.AppLogger.Errorf("The maxJobs and maxUploads flags must not be negative.")
+		return errInvalidInput
+	}
+
+	if runOnce && runOnceJob == "" {
+		helpers.AppLogger.Errorf("The runOnce flag requires the job flag.")
+		return errInvalidInput
+	}
+	if !runOnce && (runOnceJob != "" || resultFile != "") {
+		helpers.AppLogger.Errorf("The job and resultFile flags require the runOnce flag.")
+		return errInvalidInput
+	}
+
+	if (tlsCert == "") != (tlsKey == "") {
+		helpers.AppLogger.Errorf("The tlsCert and tlsKey flags must be provided together.")
+		return errInvalidInput
+	}
+	if tlsClientCA != "" && tlsCert == "" {
+		helpers.AppLogger.Errorf("The tlsClientCA flag requires the tlsCert and tlsKey flags.")
+		return errInvalidInput
+	}
+	if !runOnce && !localGRPCAddr(grpcAddr) && (tlsCert == "" || (tlsClientCA == "" && authTokenFile == "")) {
+		helpers.AppLogger.Errorf("Refusing to serve gRPC requests on %s without authentication, provide the tlsCert and tlsKey flags along with the tlsClientCA or authTokenFile flag, or listen on a loopback address or a unix socket instead.", grpcAddr)
+		return errInvalidInput
+	}
+
+	for _, destination := range splitTargets(dashboardTargets) {
+		_, err := backends.GetBackendForURI(destination)
+		if err == backends.ErrInvalidPrefix {
+			helpers.AppLogger.Errorf("Unsupported prefix provided in dashboard target URI, was given %s", destination)
+			return errInvalidInput
+		} else if err == backends.ErrInvalidURI {
+			helpers.AppLogger.Errorf("Invalid dashboard target URI, was given %s", destination)
+			return errInvalidInput
+		}
+	}
+	return nil
+}
+
+// localGRPCAddr reports whether only the host the daemon runs on can reach the address: a unix socket or a
+// loopback address.
+func localGRPCAddr(addr string) bool {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// listenGRPC will listen on the --grpcAddr, a unix socket only its owner may connect to if prefixed by unixPrefix.
+func listenGRPC(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, unixPrefix)
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		lis.Close()
+		return nil, err
+	}
+	return lis, nil
+}
+
+// grpcServerOptions will return the options of the gRPC server: its TLS credentials, if any, and the interceptors
+// authorizing the requests and running them in the environment of the daemon.
+func grpcServerOptions() ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+	if tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not load the TLS certificate %s and its key %s due to error - %v", tlsCert, tlsKey, err)
+			return nil, err
+		}
+		config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+		if tlsClientCA != "" {
+			pem, err := ioutil.ReadFile(tlsClientCA)
+			if err != nil {
+				helpers.AppLogger.Errorf("Could not read the client certificate authorities %s due to error - %v", tlsClientCA, err)
+				return nil, err
+			}
+			config.ClientCAs = x509.NewCertPool()
+			if !config.ClientCAs.AppendCertsFromPEM(pem) {
+				helpers.AppLogger.Errorf("Could not find any certificate in the client certificate authorities %s", tlsClientCA)
+				return nil, errInvalidInput
+			}
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+
+	var interceptor requestInterceptor
+	if authTokenFile != "" {
+		token, err := ioutil.ReadFile(authTokenFile)
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not read the token %s due to error - %v", authTokenFile, err)
+			return nil, err
+		}
+		if interceptor.token = strings.TrimSpace(string(token)); interceptor.token == "" {
+			helpers.AppLogger.Errorf("The token %s is empty.", authTokenFile)
+			return nil, errInvalidInput
+		}
+	}
+	return append(opts, grpc.UnaryInterceptor(interceptor.unary), grpc.StreamInterceptor(interceptor.stream)), nil
+}
+
+// requestInterceptor authorizes the gRPC requests served, with the bearer token they carry if token is set, and
+// runs them in the environment of the daemon.
+type requestInterceptor struct {
+	token string
+}
+
+func (i requestInterceptor) authorize(ctx context.Context) error {
+	if i.token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(value), []byte("Bearer "+i.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "a valid bearer token is required")
+}
+
+func (i requestInterceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := i.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(servedContext(ctx), req)
+}
+
+func (i requestInterceptor) stream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := i.authorize(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, servedStream{ServerStream: stream, ctx: servedContext(stream.Context())})
+}
+
+// servedContext will return ctx carrying the Environment of the daemon with the results discarded: the summaries
+// the backup package prints for a send or a verify reach the caller in the stream, the output of the daemon has
+// no use for them.
+func servedContext(ctx context.Context) context.Context {
+	env := *helpers.EnvironmentOf(ctx)
+	env.Stdout = ioutil.Discard
+	return helpers.WithEnvironment(ctx, env)
+}
+
+// servedStream is a grpc.ServerStream with the context of a request served.
+type servedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s servedStream) Context() context.Context {
+	return s.ctx
+}
+
+func splitTargets(targets string) []string {
+	if targets == "" {
+		return nil
+	}
+	return strings.Split(targets, ",")
+}
+
+// serveOnce will run the job definition named --job, answering the liveness and readiness checks on --httpAddr while
+// it runs, and write its outcome to the --resultFile.
+func serveOnce(cmd *cobra.Command) error {
+	ready := &readiness{}
+	if httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc(healthPath, serveHealth)
+		mux.HandleFunc(livePath, serveHealth)
+		mux.Handle(readyPath, ready)
+		httpServer := &http.Server{Addr: httpAddr, Handler: mux}
+		go func() {
+			helpers.AppLogger.Noticef("Answering health checks on http://%s", httpAddr)
+			if herr := httpServer.ListenAndServe(); herr != nil && herr != http.ErrServerClosed {
+				helpers.AppLogger.Errorf("Could not answer health checks on %s due to error - %v", httpAddr, herr)
+			}
+		}()
+		defer httpServer.Close()
+	}
+
+	jobs, err := readJobDefinitions()
+	if err != nil {
+		helpers.AppLogger.Errorf("Could not read the job definitions - %v", err)
+		err = helpers.NewError(helpers.ErrorKindConfig, err)
+	}
+	var job *jobDefinition
+	if err == nil {
+		job, err = findJobDefinition(jobs, runOnceJob)
+	}
+	started := time.Now()
+	operation := ""
+	if err == nil {
+		operation = job.Command
+		ready.set(true)
+		err = runJobDefinition(cmd, job)
+		ready.set(false)
+	}
+
+	result := runOnceResult{Job: runOnceJob, Result: control.NewResult(operation, &jobInfo, err)}
+	// The job may have failed before it started
+	if result.StartTime.IsZero() {
+		result.StartTime = started
+	}
+
+	if resultFile != "" {
+		if err != nil {
+			_, result.ExitCode = exitCode(err)
+		}
+		j, jerr := json.Marshal(result)
+		if jerr == nil {
+			jerr = ioutil.WriteFile(resultFile, append(j, '\n'), 0644)
+		}
+		if jerr != nil {
+			helpers.AppLogger.Errorf("Could not write the result of the job to %s - %v", resultFile, jerr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestServedContext(t *testing.T) {
+	oldWorkingDir := helpers.WorkingDir
+	defer func() { helpers.WorkingDir = oldWorkingDir }()
+	helpers.WorkingDir = "/var/lib/zfsbackup"
+
+	env := helpers.EnvironmentOf(servedContext(context.Background()))
+	if env.Stdout != ioutil.Discard {
+		t.Errorf("expected the results of a request served to be discarded")
+	}
+	if env.WorkingDir != helpers.WorkingDir || env.Transfers != helpers.Transfers {
+		t.Errorf("expected a request served to run in the environment of the daemon, got %+v", env)
+	}
+	if helpers.Stdout != os.Stdout {
+		t.Errorf("expected the output of the daemon to be left alone")
+	}
+}
+
+func TestValidateServeAuthentication(t *testing.T) {
+	defer func() {
+		grpcAddr = "127.0.0.1:50051"
+		tlsCert, tlsKey, tlsClientCA, authTokenFile = "", "", "", ""
+	}()
+
+	testCases := []struct {
+		addr, cert, key, clientCA, tokenFile string
+		valid                                bool
+	}{
+		{"127.0.0.1:50051", "", "", "", "", true},
+		{"[::1]:50051", "", "", "", "", true},
+		{"localhost:50051", "", "", "", "", true},
+		{"unix:///run/zfsbackup.sock", "", "", "", "", true},
+		{"0.0.0.0:50051", "", "", "", "", false},
+		{":50051", "", "", "", "", false},
+		{"backup.example.com:50051", "", "", "", "", false},
+		{"0.0.0.0:50051", "cert.pem", "key.pem", "", "", false},
+		{"0.0.0.0:50051", "", "", "", "token", false},
+		{"0.0.0.0:50051", "cert.pem", "key.pem", "ca.pem", "", true},
+		{"0.0.0.0:50051", "cert.pem", "key.pem", "", "token", true},
+		{"127.0.0.1:50051", "cert.pem", "", "", "", false},
+		{"127.0.0.1:50051", "", "", "ca.pem", "", false},
+	}
+
+	for idx, c := range testCases {
+		grpcAddr, tlsCert, tlsKey, tlsClientCA, authTokenFile = c.addr, c.cert, c.key, c.clientCA, c.tokenFile
+		if err := validateServeFlags(serveCmd, nil); (err == nil) != c.valid {
+			t.Errorf("%d: expected %s with %+v to be valid %v, got error %v", idx, c.addr, c, c.valid, err)
+		}
+	}
+}
+
+func TestRequestInterceptorAuthorize(t *testing.T) {
+	withToken := func(value string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", value))
+	}
+
+	if err := (requestInterceptor{}).authorize(context.Background()); err != nil {
+		t.Errorf("expected every request to be authorized without a token, got %v", err)
+	}
+
+	interceptor := requestInterceptor{token: "secret"}
+	if err := interceptor.authorize(withToken("Bearer secret")); err != nil {
+		t.Errorf("expected a request with the token to be authorized, got %v", err)
+	}
+	for _, ctx := range []context.Context{context.Background(), withToken("Bearer wrong"), withToken("secret")} {
+		if err := interceptor.authorize(ctx); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("expected a request without the token to be unauthenticated, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+const (
+	// memoryTempDir is the tmpfs mount used to stage volumes with --tempDirInMemory.
+	memoryTempDir = "/dev/shm"
+	// tmpfsMagic is the filesystem type statfs reports for a tmpfs mount.
+	tmpfsMagic = 0x01021994
+)
+
+var (
+	tempDir         string
+	tempDirInMemory bool
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&tempDir, "tempDir", "", "the directory to stage volumes in instead of the temp directory of the working directory, e.g. a tmpfs mount so the volumes of a send do not add to the I/O of the pool being backed up. A send or consolidate fails if it does not have the space for --maxFileBuffer volumes.")
+	RootCmd.PersistentFlags().BoolVar(&tempDirInMemory, "tempDirInMemory", false, "stage volumes in memory ("+memoryTempDir+"), same as --tempDir "+memoryTempDir+". Not available on macOS, use a RAM disk with --tempDir instead.")
+	RootCmd.PersistentFlags().BoolVar(&helpers.ShredTempFiles, "shredTempFiles", false, "overwrite the staged volumes and other temporary files with zeros before deleting them, so the data they held can not be read back from the disk. It does not reach the blocks a copy-on-write filesystem such as ZFS or btrfs already wrote them to, stage them on another filesystem or in memory there.")
+}
+
+func resetStagingFlags() {
+	tempDir = ""
+	tempDirInMemory = false
+	helpers.ShredTempFiles = false
+}
+
+// stagingDir will return the directory to create the temporary directory of this run in.
+func stagingDir() (string, error) {
+	if tempDirInMemory {
+		if tempDir != "" && tempDir != memoryTempDir {
+			helpers.AppLogger.Errorf("The flags --tempDir and --tempDirInMemory are mutually exclusive. Please specify only one of these flags.")
+			return "", errInvalidInput
+		}
+		if runtime.GOOS == "darwin" {
+			helpers.AppLogger.Errorf("macOS has no %s, create a RAM disk (e.g. with hdiutil and diskutil) and provide its mount point with --tempDir instead.", memoryTempDir)
+			return "", errInvalidInput
+		}
+		tempDir = memoryTempDir
+	}
+
+	if tempDir == "" {
+		dirPath := filepath.Join(workingDirectory, "temp")
+		if err := ensurePrivateDir(dirPath, "temp directory"); err != nil {
+			return "", err
+		}
+		return dirPath, nil
+	}
+
+	// An explicit directory is expected to be a mount point, so it is not created
+	if dir, serr := os.Stat(tempDir); serr != nil || !dir.IsDir() {
+		helpers.AppLogger.Errorf("The temp directory provided (%s) does not exist or is not a directory.", tempDir)
+		return "", errInvalidInput
+	}
+	helpers.AppLogger.Infof("Staging volumes in %s", tempDir)
+	return tempDir, nil
+}
+
+// stagingSpace will return the space available in the temporary directory and the space needed to stage
+// the maximum number of volumes of the job in it.
+func stagingSpace(j *helpers.JobInfo) (available, needed uint64, err error) {
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(helpers.BackupTempdir, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	available = uint64(stat.Bavail) * uint64(stat.Bsize)
+	// Volumes may grow slightly past the volume size
+	needed = j.VolumeSize * 1024 * 1024 * uint64(j.MaxFileBuffer+1)
+	return available, needed, nil
+}
+
+// checkStagingSpace will fail a job staging its volumes in a directory provided with --tempDir or
+// --tempDirInMemory that does not have the space for them, as filling up a tmpfs mount eats into the
+// memory of the system. The temp directory of the working directory is left to fill up as before.
+func checkStagingSpace(j *helpers.JobInfo) error {
+	if tempDir == "" || j.MaxFileBuffer == 0 {
+		return nil
+	}
+
+	available, needed, err := stagingSpace(j)
+	if err != nil {
+		helpers.AppLogger.Errorf("Could not get the space available in %s - %v", helpers.BackupTempdir, err)
+		return err
+	}
+
+	if available < needed {
+		helpers.AppLogger.Errorf("The temp directory %s only has %s available but %s is needed to stage %d volumes of %dMiB. Lower --volsize or --maxFileBuffer, or provide a larger --tempDir.", tempDir, humanize.IBytes(available), humanize.IBytes(needed), j.MaxFileBuffer, j.VolumeSize)
+		return errInvalidInput
+	}
+	return nil
+}
+
+// fitMemory will lower the parallelism of a job until the buffers it is expected to use fit in the
+// --maxMemory provided, if any. Volumes count towards it when staged in a tmpfs mount.
+func fitMemory(j *helpers.JobInfo) error {
+	if maxMemory == 0 {
+		return nil
+	}
+
+	var stat syscall.Statfs_t
+	inMemory := syscall.Statfs(helpers.BackupTempdir, &stat) == nil && int64(stat.Type) == tmpfsMagic
+	uploads, autoTuneUploads, fileBuffer, cores := j.MaxParallelUploads, j.AutoTuneMaxUploads, j.MaxFileBuffer, runtime.GOMAXPROCS(0)
+
+	limit := maxMemory * humanize.MiByte
+	fitted, err := backup.FitMemory(j, limit, cores, inMemory)
+	if err != nil {
+		helpers.AppLogger.Errorf("Could not fit the job in the memory allowed, raise --maxMemory or lower --uploadChunkSize, --compressorBlockSize, or --volsize - %v", err)
+		return errInvalidInput
+	}
+
+	if j.MaxParallelUploads != uploads {
+		helpers.AppLogger.Warningf("Lowering the number of parallel uploads from %d to %d to fit in %s of memory.", uploads, j.MaxParallelUploads, humanize.IBytes(limit))
+	}
+	if j.AutoTuneUploads && j.AutoTuneMaxUploads != autoTuneUploads {
+		helpers.AppLogger.Warningf("Lowering the highest number of parallel uploads to tune up to from %d to %d to fit in %s of memory.", autoTuneUploads, j.AutoTuneMaxUploads, humanize.IBytes(limit))
+	}
+	if fitted != cores {
+		helpers.AppLogger.Warningf("Lowering the number of cores to use from %d to %d to fit in %s of memory.", cores, fitted, humanize.IBytes(limit))
+		runtime.GOMAXPROCS(fitted)
+	}
+	if j.MaxFileBuffer != fileBuffer {
+		helpers.AppLogger.Warningf("Lowering the number of volumes staged in memory from %d to %d to fit in %s of memory.", fileBuffer, j.MaxFileBuffer, humanize.IBytes(limit))
+	}
+	helpers.AppLogger.Infof("The buffers of the job are expected to use %s of the %s of memory allowed.", humanize.IBytes(backup.EstimateMemory(j, fitted, inMemory).Total()), humanize.IBytes(limit))
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	statusDataset string
+	statusWarnAge time.Duration
+	statusMaxAge  time.Duration
+	statusCheck   bool
+)
+
+// checkResult is returned by a command run as a Nagios plugin to exit with the status level it found.
+type checkResult int
+
+func (c checkResult) Error() string {
+	return backup.StatusLevelNames[c]
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status [flags] uri",
+	Short: "status reports how recent and restorable the latest backup set of every volume found at the target is.",
+	Long: `status reports how recent and restorable the latest backup set of every volume found at
+the target is, or of the --dataset provided. A volume is critical if it has no backup
+set, if its latest one has a broken chain, or if its snapshot is older than --maxAge, and
+a warning if its snapshot is older than --warnAge.
+
+With --check, a one line summary is output in the format of a Nagios plugin, along with
+the age of the latest snapshot of every volume as performance data, and the command exits
+with 0 (OK), 1 (WARNING), 2 (CRITICAL), or 3 (UNKNOWN, the target could not be read). Use
+it as a Nagios, Icinga, Zabbix, or Sensu check.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			cmd.Usage()
+			return errInvalidInput
+		}
+		if statusWarnAge < 0 || statusMaxAge < 0 {
+			helpers.AppLogger.Errorf("The warnAge and maxAge flags must not be negative.")
+			return errInvalidInput
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// A failed check is not a usage error
+		cmd.SilenceUsage = true
+		jobInfo.Destinations = []string{args[0]}
+		statuses, err := backup.Status(context.Background(), &jobInfo, statusDataset, statusWarnAge, statusMaxAge)
+		if statusCheck {
+			level, summary := checkSummary(statuses, err)
+			fmt.Fprintf(helpers.Stdout, "ZFSBACKUP %s - %s\n", backup.StatusLevelNames[level], summary)
+			if level == backup.StatusOK {
+				return nil
+			}
+			return checkResult(level)
+		}
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not read the backup sets of %s due to error - %v", args[0], err)
+			return err
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(statuses)
+		}
+
+		colors := []string{helpers.ColorGreen, helpers.ColorYellow, helpers.ColorRed}
+		table := helpers.NewTable("VOLUME", "SNAPSHOT", "CREATED", "STATUS", "REASON")
+		for _, status := range statuses {
+			snapshot, created := "-", "-"
+			if status.Latest != nil {
+				snapshot = status.Snapshot
+				created = humanize.Time(status.Created)
+			}
+			table.Row(status.Volume, snapshot, created, helpers.Colorize(colors[status.Level], backup.StatusLevelNames[status.Level]), status.Reason)
+		}
+		_, err = table.WriteTo(helpers.Stdout)
+		return err
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVar(&statusDataset, "dataset", "", "only report the status of this volume, can end with a '*' to match as only a prefix. It is critical if it has no backup set.")
+	statusCmd.Flags().DurationVar(&statusWarnAge, "warnAge", 0, "warn about the volumes whose latest backup set is of a snapshot older than this, e.g. 25h for daily backups. Use 0 to not check.")
+	statusCmd.Flags().DurationVar(&statusMaxAge, "maxAge", 0, "the volumes whose latest backup set is of a snapshot older than this are critical, e.g. 26h for daily backups. Use 0 to not check.")
+	statusCmd.Flags().BoolVar(&statusCheck, "check", false, "output a one line summary in the Nagios plugin format and exit with 0 (OK), 1 (WARNING), 2 (CRITICAL), or 3 (UNKNOWN).")
+}
+
+// ResetStatusJobInfo exists solely for integration testing
+func ResetStatusJobInfo() {
+	resetRootFlags()
+	statusDataset = ""
+	statusWarnAge = 0
+	statusMaxAge = 0
+	statusCheck = false
+}
+
+// checkSummary will return the status level of the volumes provided, that of the most severe one, and the one line
+// summary output by a Nagios plugin followed by the age in seconds of every volume's latest snapshot as performance data.
+func checkSummary(statuses []backup.VolumeStatus, err error) (int, string) {
+	if err != nil {
+		return backup.StatusUnknown, fmt.Sprintf("could not read the backup sets - %v", err)
+	}
+	if len(statuses) == 0 {
+		return backup.StatusCritical, "no backup set found"
+	}
+
+	level := backup.StatusOK
+	var problems, perfdata []string
+	for _, status := range statuses {
+		if status.Level > level {
+			level = status.Level
+		}
+		if status.Level != backup.StatusOK {
+			problems = append(problems, status.Volume+": "+status.Reason)
+		}
+		if status.Latest != nil {
+			perfdata = append(perfdata, fmt.Sprintf("'%s'=%ds;%s;%s;0", status.Volume, int64(status.Age/time.Second), checkThreshold(statusWarnAge), checkThreshold(statusMaxAge)))
+		}
+	}
+
+	var summary string
+	switch {
+	case len(statuses) == 1:
+		summary = statuses[0].Volume + ": " + statuses[0].Reason
+	case len(problems) == 0:
+		summary = fmt.Sprintf("%d volumes backed up", len(statuses))
+	default:
+		summary = fmt.Sprintf("%d of %d volumes not OK, %s", len(problems), len(statuses), strings.Join(problems, ", "))
+	}
+	if len(perfdata) > 0 {
+		summary += " | " + strings.Join(perfdata, " ")
+	}
+	return level, summary
+}
+
+// checkThreshold will format an age threshold as the seconds of the performance data of a Nagios plugin, empty if not set.
+func checkThreshold(age time.Duration) string {
+	if age <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", int64(age/time.Second))
+}
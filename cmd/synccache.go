@@ -0,0 +1,91 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var cacheInvalidate bool
+
+// syncCacheCmd represents the sync-cache command
+var syncCacheCmd = &cobra.Command{
+	Use:   "sync-cache [flags] uri",
+	Short: "sync-cache will bring the local cache of the manifests found in the target up to date.",
+	Long: `sync-cache will bring the local cache of the manifests found in the target up to date.
+
+Every manifest missing from the local cache, or replaced in the target since it was cached, is downloaded.
+Manifests are told apart by their ETag or generation number where the target provides one. The list
+command falls back to the local cache when the target can't be reached, and the search command only
+reads the local cache unless told to refresh it.`,
+	SilenceErrors: true,
+	PreRunE:       validateSyncCacheFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status, err := backup.SyncCache(context.Background(), &jobInfo, cacheInvalidate)
+		if err != nil {
+			return err
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(status)
+		}
+		fmt.Fprintln(helpers.Stdout, status.String())
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(syncCacheCmd)
+
+	syncCacheCmd.Flags().BoolVar(&cacheInvalidate, "invalidate", false, "delete every manifest in the local cache of the target first, so all of them are downloaded again.")
+}
+
+// ResetSyncCacheJobInfo exists solely for integration testing
+func ResetSyncCacheJobInfo() {
+	resetRootFlags()
+	cacheInvalidate = false
+}
+
+func validateSyncCacheFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	_, err := backends.GetBackendForURI(args[0])
+	if err == backends.ErrInvalidPrefix {
+		helpers.AppLogger.Errorf("Unsupported prefix provided in destination URI, was given %s", args[0])
+		return errInvalidInput
+	} else if err == backends.ErrInvalidURI {
+		helpers.AppLogger.Errorf("Invalid destination URI, was given %s", args[0])
+		return errInvalidInput
+	}
+	jobInfo.Destinations = []string{args[0]}
+
+	return nil
+}
@@ -0,0 +1,544 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	systemdJob         string
+	systemdSchedule    string
+	systemdUnitDir     string
+	systemdUser        string
+	systemdCredentials string
+	systemdPrint       bool
+)
+
+// Environmental variables pointing a backend at a credentials file, keyed by the name of the
+// systemd credential the file is loaded as.
+var credentialEnvironment = map[string]string{
+	"aws": "AWS_SHARED_CREDENTIALS_FILE",
+	"gcs": "GOOGLE_APPLICATION_CREDENTIALS",
+}
+
+// Credentials named like an environmental variable are read as one.
+var envCredentialName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+var invalidUnitChars = regexp.MustCompile(`[^a-zA-Z0-9:_.\-]`)
+
+var cronDays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+var serviceTemplate = template.Must(template.New("service").Parse(`[Unit]
+Description=zfsbackup job {{.Job.Name}}{{if .Job.Description}}: {{.Job.Description}}{{end}}
+Documentation=https://github.com/someone1/zfsbackup-go
+Wants=network-online.target
+After=network-online.target zfs.target
+
+[Service]
+Type=oneshot
+{{- if .User}}
+User={{.User}}
+{{- end}}
+ExecStart={{.Executable}} --config {{.Config}} run {{.Job.Name}}
+{{- range .Credentials}}
+LoadCredential={{.Name}}:{{.Path}}
+{{- if .Environment}}
+Environment={{.Environment}}=%d/{{.Name}}
+{{- end}}
+{{- end}}
+Nice=10
+IOSchedulingClass=idle
+
+# Hardening
+ProtectSystem={{.ProtectSystem}}
+ReadWritePaths={{.ReadWritePaths}}
+ProtectHome=read-only
+PrivateTmp=true
+NoNewPrivileges=true
+ProtectKernelTunables=true
+ProtectKernelLogs=true
+ProtectControlGroups=true
+ProtectClock=true
+ProtectHostname=true
+RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6 AF_NETLINK
+RestrictNamespaces=true
+RestrictRealtime=true
+RestrictSUIDSGID=true
+LockPersonality=true
+MemoryDenyWriteExecute=true
+SystemCallArchitectures=native
+`))
+
+var timerTemplate = template.Must(template.New("timer").Parse(`[Unit]
+Description=Schedule for the zfsbackup job {{.Job.Name}}
+
+[Timer]
+{{- range .Schedules}}
+OnCalendar={{.}}
+{{- end}}
+Persistent=true
+RandomizedDelaySec=5m
+
+[Install]
+WantedBy=timers.target
+`))
+
+// unitCredential is a file loaded by systemd as a credential of the service.
+type unitCredential struct {
+	Name        string
+	Path        string
+	Environment string
+}
+
+// installSystemdCmd represents the install-systemd command
+var installSystemdCmd = &cobra.Command{
+	Use:   "install-systemd [flags]",
+	Short: "install-systemd will write a hardened systemd service and timer running a job defined in the config file.",
+	Long: `install-systemd will write a hardened systemd service and timer running a job defined in the config file.
+
+The timer runs the job on its schedule, converted from cron syntax if required. Secrets
+are provided to the service as systemd credentials (LoadCredential) instead of being
+written to the unit: credentials named like an environmental variable (e.g. PGP_PASSPHRASE,
+SMTP_PASSWORD, AZURE_ACCOUNT_KEY, or B2_ACCOUNT_KEY) are read as one, and the aws and gcs
+credentials are pointed at by AWS_SHARED_CREDENTIALS_FILE and GOOGLE_APPLICATION_CREDENTIALS.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		service, timer, err := systemdUnits()
+		if err != nil {
+			helpers.AppLogger.Errorf("Could not generate the systemd units - %v", err)
+			return err
+		}
+
+		name := "zfsbackup-" + invalidUnitChars.ReplaceAllString(systemdJob, "-")
+		if systemdPrint {
+			fmt.Fprintf(helpers.Stdout, "# %s.service\n%s\n# %s.timer\n%s", name, service, name, timer)
+			return nil
+		}
+
+		for ext, content := range map[string][]byte{"service": service, "timer": timer} {
+			path := filepath.Join(systemdUnitDir, fmt.Sprintf("%s.%s", name, ext))
+			if err = ioutil.WriteFile(path, content, 0644); err != nil {
+				helpers.AppLogger.Errorf("Could not write %s due to error - %v", path, err)
+				return err
+			}
+			helpers.AppLogger.Noticef("Wrote %s", path)
+		}
+		fmt.Fprintf(helpers.Stdout, "Enable the timer with: systemctl daemon-reload && systemctl enable --now %s.timer\n", name)
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(installSystemdCmd)
+
+	installSystemdCmd.Flags().StringVar(&systemdJob, "job", "", "the name of the job, defined in the config file, to run.")
+	installSystemdCmd.Flags().StringVar(&systemdSchedule, "schedule", "", "when to run the job, as a systemd calendar event (e.g. daily) or a cron expression. Defaults to the schedule of the job.")
+	installSystemdCmd.Flags().StringVar(&systemdUnitDir, "unitDir", "/etc/systemd/system", "the directory to write the units to.")
+	installSystemdCmd.Flags().StringVar(&systemdUser, "user", "", "the user to run the job as, defaults to root. The user must be allowed to run the zfs commands the job needs.")
+	installSystemdCmd.Flags().StringVar(&systemdCredentials, "credentials", "", "a comma separated list of name=path credentials to load, e.g. PGP_PASSPHRASE=/etc/zfsbackup/passphrase,gcs=/etc/zfsbackup/gcs.json.")
+	installSystemdCmd.Flags().BoolVar(&systemdPrint, "print", false, "print the units instead of writing them.")
+}
+
+func resetSystemdFlags() {
+	systemdJob = ""
+	systemdSchedule = ""
+	systemdUnitDir = "/etc/systemd/system"
+	systemdUser = ""
+	systemdCredentials = ""
+	systemdPrint = false
+}
+
+// loadCredentials will set the environmental variables provided as systemd credentials, see
+// install-systemd, unless they are already set.
+func loadCredentials() {
+	loadCredentialsFrom(os.Getenv("CREDENTIALS_DIRECTORY"))
+}
+
+// loadCredentialsFrom will set the environmental variables named like the files in dir to their contents,
+// unless they are already set.
+func loadCredentialsFrom(dir string) {
+	if dir == "" {
+		return
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if !envCredentialName.MatchString(file.Name()) || os.Getenv(file.Name()) != "" {
+			continue
+		}
+		if value, rerr := ioutil.ReadFile(filepath.Join(dir, file.Name())); rerr == nil {
+			os.Setenv(file.Name(), strings.TrimRight(string(value), "\r\n"))
+		}
+	}
+}
+
+func systemdUnits() (service, timer []byte, err error) {
+	if systemdJob == "" {
+		return nil, nil, fmt.Errorf("a job must be provided with --job")
+	}
+	if configFileUsed == "" {
+		return nil, nil, fmt.Errorf("no config file was found to read the job from")
+	}
+
+	jobs, err := readJobDefinitions()
+	if err != nil {
+		return nil, nil, err
+	}
+	var job *jobDefinition
+	for idx := range jobs {
+		if strings.EqualFold(jobs[idx].Name, systemdJob) {
+			job = &jobs[idx]
+		}
+	}
+	if job == nil {
+		return nil, nil, fmt.Errorf("could not find the job %s in the config file", systemdJob)
+	}
+
+	schedule := systemdSchedule
+	if schedule == "" {
+		schedule = job.Schedule
+	}
+	if schedule == "" {
+		return nil, nil, fmt.Errorf("the job %s has no schedule, provide one with --schedule", job.Name)
+	}
+	schedules, err := calendarEvents(schedule)
+	if err != nil {
+		return nil, nil, err
+	}
+	paths, err := jobWritePaths(job)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := filepath.Abs(configFileUsed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var credentials []unitCredential
+	if systemdCredentials != "" {
+		for _, pair := range strings.Split(systemdCredentials, ",") {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 || parts[0] == "" || !filepath.IsAbs(parts[1]) {
+				return nil, nil, fmt.Errorf("invalid credential %s, expected the format name=/absolute/path", pair)
+			}
+			credentials = append(credentials, unitCredential{Name: parts[0], Path: parts[1], Environment: credentialEnvironment[parts[0]]})
+		}
+	}
+
+	// Receiving may need to create mountpoints anywhere
+	protectSystem := "strict"
+	if job.Command == "receive" {
+		protectSystem = "full"
+	}
+
+	data := map[string]interface{}{
+		"Job":            job,
+		"User":           systemdUser,
+		"Executable":     executable,
+		"Config":         config,
+		"Credentials":    credentials,
+		"ProtectSystem":  protectSystem,
+		"ReadWritePaths": unitPaths(paths),
+		"Schedules":      schedules,
+	}
+
+	serviceB, timerB := new(bytes.Buffer), new(bytes.Buffer)
+	if err = serviceTemplate.Execute(serviceB, data); err != nil {
+		return nil, nil, err
+	}
+	if err = timerTemplate.Execute(timerB, data); err != nil {
+		return nil, nil, err
+	}
+	return serviceB.Bytes(), timerB.Bytes(), nil
+}
+
+// jobWritePaths will list the paths the job writes to, resolved from the config file as the service
+// runs it: its working and temp directories, the directories of its file:// destinations, and the
+// directories of its log, audit log, and progress files.
+func jobWritePaths(job *jobDefinition) ([]string, error) {
+	options, err := jobOptions(job)
+	if err != nil {
+		return nil, err
+	}
+
+	home := "/root"
+	if systemdUser != "" {
+		usr, uerr := user.Lookup(systemdUser)
+		if uerr != nil {
+			return nil, fmt.Errorf("could not find the home directory of the user %s - %v", systemdUser, uerr)
+		}
+		home = usr.HomeDir
+	}
+	// Relative paths are resolved from the root directory the service runs in
+	resolve := func(path string) string {
+		if strings.HasPrefix(path, "~") {
+			path = filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+		return filepath.Join("/", path)
+	}
+
+	workingDir := "~/.zfsbackup"
+	if dir, ok := options["workingdirectory"]; ok {
+		workingDir = dir
+	}
+	paths := []string{resolve(workingDir)}
+	if dir := options["tempdir"]; dir != "" {
+		paths = append(paths, resolve(dir))
+	} else if inMemory, _ := strconv.ParseBool(options["tempdirinmemory"]); inMemory {
+		paths = append(paths, memoryTempDir)
+	}
+	for _, destination := range job.Destinations {
+		if strings.HasPrefix(destination, backends.FileBackendPrefix+"://") {
+			paths = append(paths, resolve(strings.TrimPrefix(destination, backends.FileBackendPrefix+"://")))
+		}
+	}
+	// Log files are rotated and the progress file may be created, so their directories are writable
+	for _, key := range []string{"logfile", "auditlog", "progressjson"} {
+		if file := options[key]; file != "" && file != "-" {
+			paths = append(paths, filepath.Dir(resolve(file)))
+		}
+	}
+
+	unique := make([]string, 0, len(paths))
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			unique = append(unique, path)
+		}
+	}
+	return unique, nil
+}
+
+// unitPaths will return the paths as a list of a unit file (see systemd.syntax(7)), each prefixed with - so that
+// the paths missing when the service starts, e.g. the working directory before the first run, are skipped
+// instead of failing it.
+func unitPaths(paths []string) string {
+	words := make([]string, 0, len(paths))
+	for _, path := range paths {
+		word := "-" + strings.Replace(path, "%", "%%", -1)
+		if strings.ContainsAny(word, " \t\n\"'\\") {
+			word = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\t", `\t`, "\n", `\n`).Replace(word) + `"`
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
+
+// jobOptions will return the options the job is run with from the config file, keyed by the lower
+// case name of their flag. Only the config file is read: the service is not given the flags, profile,
+// or environmental variables the units are installed with.
+func jobOptions(job *jobDefinition) (map[string]string, error) {
+	var target *cobra.Command
+	for _, c := range RootCmd.Commands() {
+		if c.Name() == job.Command {
+			target = c
+		}
+	}
+	args := jobArgs(job)
+	if target == nil || args == nil {
+		return nil, fmt.Errorf("the job %s has an unsupported command %s, must be one of send, receive, or verify", job.Name, job.Command)
+	}
+
+	settings, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	oldJob, oldProfile := namedJob, profile
+	namedJob, profile = &configSection{name: "jobs " + job.Name, values: job.options}, ""
+	sections, err := configSections(target, args, settings)
+	namedJob, profile = oldJob, oldProfile
+	if err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]string)
+	for _, section := range sections {
+		for key, value := range section.values {
+			options[key] = configValue(value)
+		}
+	}
+	return options, nil
+}
+
+// calendarEvents will convert a cron expression (minute hour day-of-month month day-of-week)
+// to systemd calendar events, anything else is assumed to already be a calendar event. An event
+// only runs when all of its fields match, so when both the day of the month and the day of the week
+// are restricted an event is returned for each to keep the cron behavior of running on either.
+func calendarEvents(schedule string) ([]string, error) {
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 || strings.ContainsAny(schedule, ":") {
+		return []string{schedule}, nil
+	}
+
+	minute, err := cronField(fields[0], cronMinutes)
+	if err != nil {
+		return nil, err
+	}
+	hour, err := cronField(fields[1], cronHours)
+	if err != nil {
+		return nil, err
+	}
+	dom, err := cronField(fields[2], cronDaysOfMonth)
+	if err != nil {
+		return nil, err
+	}
+	month, err := cronField(fields[3], cronMonths)
+	if err != nil {
+		return nil, err
+	}
+
+	event := fmt.Sprintf("*-%s-%s %s:%s:00", month, dom, hour, minute)
+	if fields[4] == "*" {
+		return []string{event}, nil
+	}
+	dow, err := cronField(fields[4], cronWeekdays)
+	if err != nil {
+		return nil, err
+	}
+	if fields[2] == "*" {
+		return []string{dow + " " + event}, nil
+	}
+	return []string{event, fmt.Sprintf("%s *-%s-* %s:%s:00", dow, month, hour, minute)}, nil
+}
+
+// cronRange describes the values a field of a cron expression takes.
+type cronRange struct {
+	name     string
+	min, max int
+	names    []string // The names the values may be given as, from min, e.g. jan for 1
+	weekdays bool     // The values are written as the days of the week of a calendar event
+}
+
+var (
+	cronMinutes     = cronRange{name: "minute", min: 0, max: 59}
+	cronHours       = cronRange{name: "hour", min: 0, max: 23}
+	cronDaysOfMonth = cronRange{name: "day of the month", min: 1, max: 31}
+	cronMonths      = cronRange{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	cronWeekdays    = cronRange{name: "day of the week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}, weekdays: true}
+)
+
+// value will parse a single value of the field, given as a number or a name.
+func (r cronRange) value(value string) (int, error) {
+	for idx, name := range r.names {
+		if strings.EqualFold(value, name) {
+			return r.min + idx, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < r.min || number > r.max {
+		return 0, fmt.Errorf("invalid %s %s in the cron expression, expected a value between %d and %d", r.name, value, r.min, r.max)
+	}
+	return number, nil
+}
+
+func (r cronRange) format(value int) string {
+	if r.weekdays {
+		return cronDays[value]
+	}
+	return strconv.Itoa(value)
+}
+
+// cronField will convert a single field of a cron expression to its calendar event equivalent.
+func cronField(field string, r cronRange) (string, error) {
+	var parts []string
+	for _, part := range strings.Split(field, ",") {
+		value, step := part, 0
+		if idx := strings.Index(part, "/"); idx != -1 {
+			var err error
+			value = part[:idx]
+			if step, err = strconv.Atoi(part[idx+1:]); err != nil || step < 1 {
+				return "", fmt.Errorf("invalid step in the %s %s of the cron expression", r.name, part)
+			}
+		}
+
+		low, high := r.min, r.max
+		if r.weekdays {
+			// Sunday is both 0 and 7
+			high = 6
+		}
+		if value == "*" {
+			if step == 0 {
+				parts = append(parts, "*")
+				continue
+			}
+			if !r.weekdays {
+				parts = append(parts, fmt.Sprintf("%d/%d", r.min, step))
+				continue
+			}
+		} else {
+			bounds := strings.SplitN(value, "-", 2)
+			var err error
+			if low, err = r.value(bounds[0]); err != nil {
+				return "", err
+			}
+			switch {
+			case len(bounds) == 2:
+				if high, err = r.value(bounds[1]); err != nil {
+					return "", err
+				}
+				if high < low {
+					return "", fmt.Errorf("invalid range in the %s %s of the cron expression", r.name, part)
+				}
+			case step == 0:
+				parts = append(parts, r.format(low))
+				continue
+			}
+			// A step from a single value runs to the last value, e.g. 5/15 for minutes 5, 20, 35, and 50
+		}
+
+		// Calendar events have no ranges with steps, nor ranges ending on the second Sunday
+		if step == 0 && !(r.weekdays && high == 7) {
+			parts = append(parts, r.format(low)+".."+r.format(high))
+			continue
+		}
+		if step == 0 {
+			step = 1
+		}
+		for v := low; v <= high; v += step {
+			parts = append(parts, r.format(v))
+		}
+	}
+	return strings.Join(parts, ","), nil
+}
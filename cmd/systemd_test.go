@@ -0,0 +1,174 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCalendarEvents(t *testing.T) {
+	testCases := []struct {
+		schedule string
+		events   []string
+		valid    bool
+	}{
+		{"0 2 * * *", []string{"*-*-* 2:0:00"}, true},
+		{"30 4 1 * *", []string{"*-*-1 4:30:00"}, true},
+		{"*/15 * * * *", []string{"*-*-* *:0/15:00"}, true},
+		{"0 */6 * * *", []string{"*-*-* 0/6:0:00"}, true},
+		{"5/20 * * * *", []string{"*-*-* *:5,25,45:00"}, true},
+		{"0 8-18 * * *", []string{"*-*-* 8..18:0:00"}, true},
+		{"0 8-18/4 * * *", []string{"*-*-* 8,12,16:0:00"}, true},
+		{"0 0 1,15 * *", []string{"*-*-1,15 0:0:00"}, true},
+		{"0 3 * * 1-5", []string{"Mon..Fri *-*-* 3:0:00"}, true},
+		{"0 3 * * 0", []string{"Sun *-*-* 3:0:00"}, true},
+		{"0 3 * * 7", []string{"Sun *-*-* 3:0:00"}, true},
+		{"0 3 * * 5-7", []string{"Fri,Sat,Sun *-*-* 3:0:00"}, true},
+		{"0 3 * * */2", []string{"Sun,Tue,Thu,Sat *-*-* 3:0:00"}, true},
+		{"0 3 * * mon,WED,fri", []string{"Mon,Wed,Fri *-*-* 3:0:00"}, true},
+		{"0 3 * * sat-sun", nil, false},
+		{"0 3 1 jan *", []string{"*-1-1 3:0:00"}, true},
+		{"0 3 1 Jun-Aug *", []string{"*-6..8-1 3:0:00"}, true},
+		{"0 3 1 */3 *", []string{"*-1/3-1 3:0:00"}, true},
+		// Cron runs when either the day of the month or the day of the week matches
+		{"0 3 1 * 1", []string{"*-*-1 3:0:00", "Mon *-*-* 3:0:00"}, true},
+		{"0 3 1,15 jan mon-fri", []string{"*-1-1,15 3:0:00", "Mon..Fri *-1-* 3:0:00"}, true},
+		// Anything that isn't a cron expression is taken as a calendar event
+		{"daily", []string{"daily"}, true},
+		{"Mon *-*-* 03:00:00", []string{"Mon *-*-* 03:00:00"}, true},
+		{"60 * * * *", nil, false},
+		{"0 24 * * *", nil, false},
+		{"0 0 0 * *", nil, false},
+		{"0 0 * 13 *", nil, false},
+		{"0 0 * * 8", nil, false},
+		{"0 0 * * funday", nil, false},
+		{"*/0 * * * *", nil, false},
+		{"*/x * * * *", nil, false},
+		{"0 18-8 * * *", nil, false},
+		{"-1 * * * *", nil, false},
+	}
+
+	for _, c := range testCases {
+		events, err := calendarEvents(c.schedule)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected it to be valid: %v, got %v", c.schedule, c.valid, err)
+			continue
+		}
+		if c.valid && !reflect.DeepEqual(events, c.events) {
+			t.Errorf("%s: expected the calendar events %v, got %v", c.schedule, c.events, events)
+		}
+	}
+}
+
+func TestSystemdUnits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupsystemd")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer resetConfigFlags()
+	defer resetSystemdFlags()
+
+	configFile = filepath.Join(dir, "config.yaml")
+	configFileUsed = configFile
+	config := `workingDirectory: /var/lib/zfsbackup
+logFile: /var/log/zfsbackup/zfsbackup.log
+send:
+  auditLog: /var/lib/audit/zfsbackup.log
+jobs:
+  nightly:
+    dataset: Tank/Dataset
+    destinations: [file:///backups/tank, gs://bucket]
+    schedule: "0 3 1 * sun"
+    tempDir: /mnt/staging
+    progressJSON: "-"
+  other:
+    dataset: Tank/Other
+    destinations: [gs://bucket]
+    schedule: daily
+    workingDirectory: /srv/zfsbackup
+    tempDirInMemory: true
+  spaced:
+    dataset: Tank/Spaced
+    destinations: ["file:///backups/100%/tank"]
+    schedule: daily
+    tempDir: /mnt/my staging
+`
+	if err = ioutil.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("could not write config: %v", err)
+	}
+
+	testCases := []struct {
+		job       string
+		paths     string
+		schedules []string
+	}{
+		{
+			job:       "nightly",
+			paths:     "-/var/lib/zfsbackup -/mnt/staging -/backups/tank -/var/log/zfsbackup -/var/lib/audit",
+			schedules: []string{"*-*-1 3:0:00", "Sun *-*-* 3:0:00"},
+		},
+		{
+			job:       "other",
+			paths:     "-/srv/zfsbackup -/dev/shm -/var/log/zfsbackup -/var/lib/audit",
+			schedules: []string{"daily"},
+		},
+		{
+			job:       "spaced",
+			paths:     `-/var/lib/zfsbackup "-/mnt/my staging" -/backups/100%%/tank -/var/log/zfsbackup -/var/lib/audit`,
+			schedules: []string{"daily"},
+		},
+	}
+
+	for idx, c := range testCases {
+		systemdJob = c.job
+		service, timer, err := systemdUnits()
+		if err != nil {
+			t.Errorf("%d: unexpected error %v", idx, err)
+			continue
+		}
+		if !strings.Contains(string(service), "\nReadWritePaths="+c.paths+"\n") {
+			t.Errorf("%d: expected the service to allow writing to %s, got:\n%s", idx, c.paths, service)
+		}
+		var schedules []string
+		for _, line := range strings.Split(string(timer), "\n") {
+			if strings.HasPrefix(line, "OnCalendar=") {
+				schedules = append(schedules, strings.TrimPrefix(line, "OnCalendar="))
+			}
+		}
+		if !reflect.DeepEqual(schedules, c.schedules) {
+			t.Errorf("%d: expected the timer to run on %v, got %v", idx, c.schedules, schedules)
+		}
+	}
+}
+
+func TestUnitPaths(t *testing.T) {
+	paths := []string{"/var/lib/zfsbackup", "/mnt/my staging", `/mnt/a"b\c`, "/srv/50%"}
+	expected := `-/var/lib/zfsbackup "-/mnt/my staging" "-/mnt/a\"b\\c" -/srv/50%%`
+	if got := unitPaths(paths); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+var (
+	undeleteList    bool
+	undeleteObjects []string
+)
+
+// undeleteCmd represents the undelete command
+var undeleteCmd = &cobra.Command{
+	Use:   "undelete [flags] uri",
+	Short: "undelete will bring back the objects clean moved to the trash of the target.",
+	Long: `undelete will bring back the objects clean moved to the trash of the target with --trashFor,
+e.g. after a mistaken clean, as long as they did not expire.
+
+Every object in the trash is undeleted unless --object is provided. An object deleted several
+times is undeleted from the last time it was, and an object found in the target again is
+left in the trash. Use --list to only list the objects in the trash and when they expire.`,
+	PreRunE: validateUndeleteFlags,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		jobInfo.Destinations = []string{args[0]}
+
+		var objects []backup.TrashedObject
+		var err error
+		if undeleteList {
+			objects, err = backup.ListTrash(context.Background(), &jobInfo)
+		} else {
+			objects, err = backup.Undelete(context.Background(), &jobInfo, undeleteObjects)
+			for _, object := range objects {
+				if strings.HasPrefix(object.Object, jobInfo.ManifestPrefix) {
+					jobInfo.Manifests = append(jobInfo.Manifests, object.Object)
+				}
+			}
+			recordAudit("undelete", &jobInfo, err)
+		}
+		if err != nil {
+			return err
+		}
+
+		if helpers.JSONOutput {
+			return printJSON(objects)
+		}
+
+		if len(objects) == 0 && undeleteList {
+			fmt.Fprintln(helpers.Stdout, "No object found in the trash.")
+			return nil
+		} else if len(objects) == 0 {
+			fmt.Fprintln(helpers.Stdout, "No object to undelete found in the trash.")
+			return nil
+		}
+		table := helpers.NewTable("OBJECT", "EXPIRES")
+		for _, object := range objects {
+			table.Row(object.Object, object.Expires.Local().Format(time.RFC3339))
+		}
+		_, err = table.WriteTo(helpers.Stdout)
+		return err
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(undeleteCmd)
+
+	undeleteCmd.Flags().BoolVar(&undeleteList, "list", false, "only list the objects in the trash of the target and when they expire.")
+	undeleteCmd.Flags().StringSliceVar(&undeleteObjects, "object", nil, "a shell pattern of the objects to undelete, matched against the name they were deleted from, e.g. 'Tank/Data|snapshot-20170101*'. A '*' does not match a '/'. Can be repeated.")
+}
+
+// ResetUndeleteJobInfo exists solely for integration testing
+func ResetUndeleteJobInfo() {
+	resetRootFlags()
+	undeleteList = false
+	undeleteObjects = nil
+}
+
+func validateUndeleteFlags(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		cmd.Usage()
+		return errInvalidInput
+	}
+
+	if undeleteList && len(undeleteObjects) > 0 {
+		helpers.AppLogger.Errorf("The list and object flags are mutually exclusive.")
+		return errInvalidInput
+	}
+	for _, pattern := range undeleteObjects {
+		if _, err := path.Match(pattern, ""); err != nil {
+			helpers.AppLogger.Errorf("Invalid object pattern %s - %v", pattern, err)
+			return errInvalidInput
+		}
+	}
+
+	return validateDestinationURIs(args)
+}
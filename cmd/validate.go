@@ -0,0 +1,224 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// configCheck is the outcome of a single check made by the validate-config command.
+type configCheck struct {
+	Check  string
+	Target string
+	Error  string `json:",omitempty"`
+}
+
+// validateConfigCmd represents the validate-config command
+var validateConfigCmd = &cobra.Command{
+	Use:   "validate-config [flags] [filesystem|volume|snapshot uri(s)]",
+	Short: "validate-config will check the configuration along with every dataset and destination it references without moving any data.",
+	Long: `validate-config will check the configuration along with every dataset and destination it references without moving any data.
+
+The flags, environmental variables, and config file are parsed and the keyrings and
+keys they name are loaded. Every job definition is checked, the datasets to send from
+the arguments and job definitions must exist, and the manifests of every destination
+from the arguments and job definitions are listed to confirm the destination is valid
+and its credentials are usable.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		checks := validateConfig(cmd, args)
+
+		var failed int
+		for _, check := range checks {
+			if check.Error != "" {
+				failed++
+			}
+		}
+
+		if helpers.JSONOutput {
+			if err := printJSON(checks); err != nil {
+				return err
+			}
+		} else {
+			w := tabwriter.NewWriter(helpers.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "RESULT\tCHECK\tTARGET\tERROR")
+			for _, check := range checks {
+				result := "OK"
+				if check.Error != "" {
+					result = "FAILED"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result, check.Check, check.Target, check.Error)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+
+		if failed > 0 {
+			// The checks already describe what is wrong
+			cmd.SilenceUsage = true
+			err := fmt.Errorf("%d of %d checks failed", failed, len(checks))
+			helpers.AppLogger.Errorf("The configuration is invalid - %v", err)
+			return err
+		}
+		helpers.AppLogger.Noticef("The configuration is valid.")
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(validateConfigCmd)
+}
+
+// validateConfig will run every check, the flags and keyrings were already validated when processing the flags.
+func validateConfig(cmd *cobra.Command, args []string) []configCheck {
+	ctx := context.Background()
+	var checks []configCheck
+	addCheck := func(check, target string, err error) {
+		c := configCheck{Check: check, Target: target}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		checks = append(checks, c)
+	}
+
+	if configFileUsed != "" {
+		addCheck("config", configFileUsed, nil)
+	}
+	if secretKeyRingPath != "" {
+		addCheck("keyring", secretKeyRingPath, nil)
+	}
+	if publicKeyRingPath != "" {
+		addCheck("keyring", publicKeyRingPath, nil)
+	}
+	if jobInfo.EncryptTo != "" {
+		addCheck("key", jobInfo.EncryptTo, nil)
+	}
+	if jobInfo.SignFrom != "" {
+		addCheck("key", jobInfo.SignFrom, nil)
+	}
+
+	var datasets, destinations []string
+	dataset, argDestinations := jobTargets(args)
+	if dataset != "" {
+		datasets = append(datasets, dataset)
+	}
+	destinations = append(destinations, argDestinations...)
+
+	if configFileUsed != "" {
+		settings, err := readConfig()
+		if err != nil {
+			addCheck("config", configFileUsed, err)
+		} else {
+			for _, kerr := range checkConfigKeys(cmd.Root(), settings) {
+				addCheck("config", configFileUsed, kerr)
+			}
+		}
+
+		jobs, err := readJobDefinitions()
+		if err != nil {
+			addCheck("jobs", configFileUsed, err)
+		}
+		known := knownFlags(cmd.Root())
+		for idx := range jobs {
+			addCheck("job", jobs[idx].Name, checkJobDefinition(&jobs[idx], known))
+			if jobs[idx].Command == "send" {
+				datasets = append(datasets, jobs[idx].Dataset)
+			}
+			destinations = append(destinations, jobs[idx].Destinations...)
+		}
+	}
+
+	for _, dataset := range uniqueStrings(datasets) {
+		_, err := helpers.GetZFSProperty(ctx, "type", dataset)
+		addCheck("dataset", dataset, err)
+	}
+
+	for _, destination := range uniqueStrings(destinations) {
+		addCheck("destination", destination, backup.CheckDestination(ctx, &jobInfo, destination))
+	}
+
+	return checks
+}
+
+// checkJobDefinition will check the command and options of a job along with the keys it names.
+func checkJobDefinition(job *jobDefinition, known map[string]bool) error {
+	switch job.Command {
+	case "send", "verify":
+	case "receive":
+		if job.Target == "" {
+			return fmt.Errorf("a receive job must provide a target")
+		}
+	default:
+		return fmt.Errorf("unsupported command %s, must be one of send, receive, or verify", job.Command)
+	}
+
+	keys := make([]string, 0, len(job.options))
+	for key := range job.options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !known[key] {
+			return fmt.Errorf("unknown option %s", key)
+		}
+	}
+
+	ownRings := false
+	for _, ring := range []string{"secretkeyringpath", "publickeyringpath"} {
+		if path, ok := job.options[ring]; ok {
+			if _, err := os.Stat(configValue(path)); err != nil {
+				return err
+			}
+			ownRings = true
+		}
+	}
+	// Keys can only be looked up in the keyrings already loaded
+	if ownRings {
+		return nil
+	}
+	for _, key := range []string{"encryptto", "signfrom"} {
+		if email, ok := job.options[key]; ok && helpers.GetPrivateKeyByEmail(configValue(email)) == nil {
+			return fmt.Errorf("could not find the key for %s in the loaded keyrings", configValue(email))
+		}
+	}
+	return nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
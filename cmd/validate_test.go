@@ -0,0 +1,63 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckJobDefinition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupvalidate")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ring := filepath.Join(dir, "secring.gpg")
+	if err = ioutil.WriteFile(ring, nil, 0600); err != nil {
+		t.Fatalf("could not write keyring: %v", err)
+	}
+	missing := filepath.Join(dir, "missing.gpg")
+
+	known := map[string]bool{"secretkeyringpath": true, "publickeyringpath": true, "encryptto": true, "signfrom": true}
+	testCases := []struct {
+		name    string
+		options map[string]interface{}
+		valid   bool
+	}{
+		{"no keys", map[string]interface{}{}, true},
+		{"unknown option", map[string]interface{}{"nosuchflag": true}, false},
+		{"own rings", map[string]interface{}{"secretkeyringpath": ring, "publickeyringpath": ring, "encryptto": "someone@example.com"}, true},
+		{"missing public ring", map[string]interface{}{"secretkeyringpath": ring, "publickeyringpath": missing}, false},
+		{"missing secret ring", map[string]interface{}{"secretkeyringpath": missing, "publickeyringpath": ring}, false},
+		{"key not loaded", map[string]interface{}{"encryptto": "someone@example.com"}, false},
+		{"signing key not loaded", map[string]interface{}{"signfrom": "someone@example.com"}, false},
+	}
+
+	for _, c := range testCases {
+		job := &jobDefinition{Name: c.name, Command: "send", options: c.options}
+		if err := checkJobDefinition(job, known); (err == nil) != c.valid {
+			t.Errorf("%s: expected the job to be valid: %v, got %v", c.name, c.valid, err)
+		}
+	}
+}
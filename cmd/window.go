@@ -0,0 +1,135 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// The policies of a job still running when its backup window closes.
+const (
+	windowPolicyPause = "pause"
+	windowPolicyAbort = "abort"
+)
+
+var (
+	windowSpec       string
+	windowPolicy     string
+	backupWindow     *helpers.Window
+	errOutsideWindow = errors.New("outside of the backup window")
+)
+
+// parseWindowFlags will parse the --window and --windowPolicy flags into backupWindow.
+func parseWindowFlags() error {
+	backupWindow = nil
+	if windowPolicy != windowPolicyPause && windowPolicy != windowPolicyAbort {
+		helpers.AppLogger.Errorf("Invalid windowPolicy %s, must be one of %s or %s.", windowPolicy, windowPolicyPause, windowPolicyAbort)
+		return errInvalidInput
+	}
+	if windowSpec == "" {
+		return nil
+	}
+
+	w, err := helpers.ParseWindow(windowSpec)
+	if err != nil {
+		helpers.AppLogger.Errorf("%v", err)
+		return errInvalidInput
+	}
+	backupWindow = w
+	return nil
+}
+
+// checkWindow will refuse to start the job if it is outside of its backup window.
+func checkWindow(name string) error {
+	if now := time.Now(); backupWindow != nil && !backupWindow.Contains(now) {
+		helpers.AppLogger.Errorf("Not starting %s outside of the backup window %s, it opens again at %s.", name, backupWindow, backupWindow.NextOpen(now).Format(time.RFC1123))
+		return errOutsideWindow
+	}
+	return nil
+}
+
+// enforceWindow will hold every transfer of the job while its backup window is closed, or cancel the job
+// once it closes with the abort policy so it can be resumed later. Transfers paused otherwise, e.g. with
+// jobs pause, stay paused when the window opens. The first returned function reports whether the job was
+// canceled, the second one stops enforcing the window.
+func enforceWindow(ctx context.Context, operation string, cancel context.CancelFunc) (func() bool, func()) {
+	var closed int32
+	isClosed := func() bool {
+		return atomic.LoadInt32(&closed) == 1
+	}
+	if backupWindow == nil {
+		return isClosed, func() {}
+	}
+
+	w, policy := backupWindow, windowPolicy
+	done := make(chan struct{})
+	go func() {
+		for {
+			timer := time.NewTimer(time.Until(w.NextClose(time.Now())))
+			select {
+			case <-timer.C:
+			case <-done:
+				timer.Stop()
+				return
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+
+			if policy == windowPolicyAbort {
+				atomic.StoreInt32(&closed, 1)
+				helpers.AppLogger.Noticef("The backup window %s closed, stopping the %s job.", w, operation)
+				// Paused transfers must be able to notice the job is stopping
+				helpers.Transfers.Resume()
+				cancel()
+				return
+			}
+
+			opens := w.NextOpen(time.Now())
+			helpers.AppLogger.Noticef("The backup window %s closed, pausing the transfers of the %s job until it opens again at %s.", w, operation, opens.Format(time.RFC1123))
+			helpers.Transfers.Hold()
+			timer = time.NewTimer(time.Until(opens))
+			select {
+			case <-timer.C:
+				helpers.AppLogger.Noticef("The backup window %s opened, resuming the transfers of the %s job.", w, operation)
+				helpers.Transfers.Release()
+			case <-done:
+				timer.Stop()
+				helpers.Transfers.Release()
+				return
+			case <-ctx.Done():
+				// Held transfers must be able to notice the job is stopping
+				timer.Stop()
+				helpers.Transfers.Release()
+				return
+			}
+		}
+	}()
+
+	return isClosed, func() {
+		close(done)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestTracker(t *testing.T) {
+	start := time.Now()
+	tracker := NewTracker("receive", 1234)
+	tracker.now = func() time.Time { return start.Add(10 * time.Second) }
+
+	tracker.Update(helpers.ProgressEvent{Type: helpers.ProgressJobStarted, Time: start, VolumeName: "tank/data", Snapshot: "snap1"})
+	tracker.Update(helpers.ProgressEvent{Type: helpers.ProgressJobPlanned, Time: start, Bytes: 4000})
+	tracker.Update(helpers.ProgressEvent{Type: helpers.ProgressVolumeDownloaded, Time: start, ObjectName: "vol1", Bytes: 1000})
+	// Not the event that marks progress for a receive
+	tracker.Update(helpers.ProgressEvent{Type: helpers.ProgressVolumeRestored, Time: start, ObjectName: "vol1", Bytes: 1000})
+
+	status := tracker.Status()
+	if status.PID != 1234 || status.Operation != "receive" || status.VolumeName != "tank/data" || status.Snapshot != "snap1" {
+		t.Errorf("unexpected job details in status: %+v", status)
+	}
+	if status.CurrentVolume != "vol1" || status.VolumesDone != 1 || status.BytesDone != 1000 {
+		t.Errorf("unexpected progress in status: %+v", status)
+	}
+	if status.Throughput != 100 {
+		t.Errorf("expected a throughput of 100 bytes/s, got %v", status.Throughput)
+	}
+	if status.ETA != 30*time.Second {
+		t.Errorf("expected an ETA of 30s, got %v", status.ETA)
+	}
+	if status.Percent() != 25 {
+		t.Errorf("expected 25%% complete, got %v", status.Percent())
+	}
+}
+
+func TestTrackerUnknownTotal(t *testing.T) {
+	tracker := NewTracker("send", 1)
+	tracker.Update(helpers.ProgressEvent{Type: helpers.ProgressJobStarted, Time: time.Now().Add(-time.Second)})
+	tracker.Update(helpers.ProgressEvent{Type: helpers.ProgressVolumeCreated, Time: time.Now(), Bytes: 1000})
+
+	status := tracker.Status()
+	if status.ETA != 0 || status.Percent() != 0 {
+		t.Errorf("expected no ETA or percent without a planned total, got %v and %v", status.ETA, status.Percent())
+	}
+	if status.BytesDone != 1000 {
+		t.Errorf("expected 1000 bytes done, got %d", status.BytesDone)
+	}
+}
+
+func TestServeAndListJobs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "zfsbackupcontroltest")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	dir := SocketDir(tempDir)
+
+	tracker := NewTracker("send", os.Getpid())
+	tracker.Update(helpers.ProgressEvent{Type: helpers.ProgressJobStarted, Time: time.Now(), VolumeName: "tank/data"})
+
+	server, err := Serve(dir, tracker)
+	if err != nil {
+		t.Fatalf("could not serve control socket - %v", err)
+	}
+
+	// Leave behind a socket nobody is listening on
+	stalePath := filepath.Join(dir, "1"+socketSuffix)
+	stale, err := net.Listen("unix", stalePath)
+	if err != nil {
+		t.Fatalf("could not create stale socket - %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	statuses, err := ListJobs(dir)
+	if err != nil {
+		t.Fatalf("could not list jobs - %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].PID != os.Getpid() || statuses[0].VolumeName != "tank/data" {
+		t.Errorf("unexpected jobs listed: %+v", statuses)
+	}
+	if _, err = os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Errorf("expected stale socket to be removed, got %v", err)
+	}
+
+	if err = server.Close(); err != nil {
+		t.Errorf("could not close control socket - %v", err)
+	}
+	if _, err = os.Stat(server.Path()); !os.IsNotExist(err) {
+		t.Errorf("expected control socket to be removed, got %v", err)
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "zfsbackupcontroltest")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	dir := SocketDir(tempDir)
+
+	server, err := Serve(dir, NewTracker("send", os.Getpid()))
+	if err != nil {
+		t.Fatalf("could not serve control socket - %v", err)
+	}
+	defer server.Close()
+	defer helpers.Transfers.Resume()
+
+	testCases := []struct {
+		command string
+		paused  bool
+	}{
+		{CommandStatus, false},
+		{CommandPause, true},
+		{CommandStatus, true},
+		{CommandPause, true},
+		{CommandResume, false},
+		{CommandResume, false},
+	}
+
+	for idx, c := range testCases {
+		status, serr := Send(SocketPath(dir, os.Getpid()), c.command)
+		if serr != nil {
+			t.Fatalf("%d: could not send %s - %v", idx, c.command, serr)
+		}
+		if status.Paused != c.paused || helpers.Transfers.Paused() != c.paused {
+			t.Errorf("%d: expected paused to be %v after %s, got %v", idx, c.paused, c.command, status.Paused)
+		}
+	}
+
+	if _, err = Send(server.Path(), "bogus"); err == nil {
+		t.Errorf("expected an error sending an unknown command")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	history := NewHistory(2)
+	history.Record(Result{Snapshot: "snap1"})
+	history.Record(Result{Snapshot: "snap2", Error: "failed"})
+	history.Record(Result{Snapshot: "snap3"})
+
+	recent := history.Recent()
+	if len(recent) != 2 || recent[0].Snapshot != "snap3" || recent[1].Snapshot != "snap2" {
+		t.Errorf("unexpected recent results: %+v", recent)
+	}
+
+	failures := history.Failures()
+	if len(failures) != 1 || failures[0].Snapshot != "snap2" {
+		t.Errorf("unexpected failures: %+v", failures)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	var out bytes.Buffer
+	stream := NewEventStream(&out)
+	start := time.Now()
+	stream.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressJobStarted, Time: start, VolumeName: "tank/data", Snapshot: "snap1"})
+	stream.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressJobPlanned, Time: start, Bytes: 4000})
+	stream.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressVolumeCreated, Time: start, ObjectName: "vol1", Bytes: 1000})
+	stream.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressRetry, Time: start, ObjectName: "vol1", Message: "timeout"})
+	stream.Finished(NewResult("send", &helpers.JobInfo{VolumeName: "tank/data"}, helpers.NewError(helpers.ErrorKindBackend, errors.New("failed"))))
+	if err := stream.Close(); err != nil {
+		t.Errorf("unexpected error closing the stream: %v", err)
+	}
+
+	var events []Event
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("could not decode line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	expected := []struct {
+		event      string
+		bytesDone  uint64
+		totalBytes uint64
+	}{
+		{"job_started", 0, 0},
+		{"job_planned", 0, 4000},
+		{"volume_created", 1000, 4000},
+		{"retry", 1000, 4000},
+		{"job_result", 1000, 4000},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for idx, e := range expected {
+		if events[idx].Operation != "send" || events[idx].Event != e.event || events[idx].BytesDone != e.bytesDone || events[idx].TotalBytes != e.totalBytes {
+			t.Errorf("%d: unexpected event %+v", idx, events[idx])
+		}
+	}
+	if result := events[len(events)-1].Result; result == nil || result.Error != "failed" || result.ErrorKind != "backend" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	start := time.Now()
+	var buf bytes.Buffer
+	display := NewDisplay(&buf, 60, time.Hour)
+	display.now = func() time.Time { return start.Add(10 * time.Second) }
+
+	display.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressJobStarted, Time: start, VolumeName: "tank/data", Snapshot: "snap1"})
+	display.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressJobPlanned, Time: start, Bytes: 4000})
+	display.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressVolumeCreated, Time: start, VolumeNumber: 2, Bytes: 1000})
+	display.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressVolumeUploaded, Time: start, Destination: "file:///backups", Bytes: 500})
+	display.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressVolumeUploaded, Time: start, Destination: "gs://a-destination-with-a-very-long-name-cut-to-the-width", Bytes: 1000})
+
+	display.mu.Lock()
+	lines := display.render()
+	display.mu.Unlock()
+	expected := []string{
+		"send tank/data@snap1 - volume 2",
+		"  file:///backups: 500 B at 50 B/s",
+		"  gs://a-destination-with-a-very-long-name-cut-to-the-width",
+		"  [#######-----------------------]  25.0% 1000 B of 3.9 KiB",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("expected line %d to be %q, got %q", i, expected[i], lines[i])
+		}
+	}
+
+	// Writes are printed above the display, which is then drawn again
+	buf.Reset()
+	if _, err := display.Write([]byte("a log line\n")); err != nil {
+		t.Fatalf("unexpected error writing through the display - %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "\r\x1b[1A\x1b[1A\x1b[1A\x1b[Ja log line\nsend tank/data@snap1") {
+		t.Errorf("expected the display to be cleared, the line written, and the display drawn again, got %q", buf.String())
+	}
+
+	// Nothing is drawn once closed
+	display.Finished(Result{})
+	buf.Reset()
+	display.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressVolumeCreated, Time: start, VolumeNumber: 3, Bytes: 1000})
+	display.Write([]byte("another log line\n"))
+	if err := display.Close(); err != nil {
+		t.Errorf("unexpected error closing the display twice - %v", err)
+	}
+	if buf.String() != "another log line\n" {
+		t.Errorf("expected only the log line after the display is closed, got %q", buf.String())
+	}
+}
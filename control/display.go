@@ -0,0 +1,207 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package control
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+const progressBarWidth = 30
+
+// Display is an Observer drawing a live view of the progress of a job on a terminal: the snapshot and
+// volume being worked on, the throughput to or from every destination, and the overall progress and ETA
+// when the size of the job is known. Logs should be written through the Display so they are printed above
+// it instead of over it.
+type Display struct {
+	mu           sync.Mutex
+	w            io.Writer
+	width        int
+	tracker      *Tracker
+	volume       int64
+	destinations []string
+	destBytes    map[string]uint64
+	lines        int
+	done         chan struct{}
+	stopped      bool
+	now          func() time.Time
+}
+
+// NewDisplay will return a Display drawing to w, a terminal width characters wide (0 if unknown),
+// redrawn every interval until it is closed.
+func NewDisplay(w io.Writer, width int, interval time.Duration) *Display {
+	d := &Display{
+		w:         w,
+		width:     width,
+		destBytes: make(map[string]uint64),
+		done:      make(chan struct{}),
+		now:       time.Now,
+	}
+	go d.refresh(interval)
+	return d
+}
+
+func (d *Display) refresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			d.redraw()
+			d.mu.Unlock()
+		}
+	}
+}
+
+// Progress will fold the event into the Display.
+func (d *Display) Progress(operation string, event helpers.ProgressEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.tracker == nil {
+		d.tracker = NewTracker(operation, os.Getpid())
+		d.tracker.now = d.now
+	}
+	d.tracker.Update(event)
+
+	switch event.Type {
+	case helpers.ProgressVolumeUploaded, helpers.ProgressVolumeDownloaded:
+		if _, ok := d.destBytes[event.Destination]; !ok {
+			d.destinations = append(d.destinations, event.Destination)
+		}
+		d.destBytes[event.Destination] += event.Bytes
+	}
+	if event.Type == progressEvents[operation] && event.VolumeNumber > d.volume {
+		d.volume = event.VolumeNumber
+	}
+	d.redraw()
+}
+
+// Finished will stop and clear the Display, the result of the job is reported by the command itself.
+func (d *Display) Finished(result Result) {
+	d.Close()
+}
+
+// Close will stop and clear the Display. Writes are passed through as is afterwards.
+func (d *Display) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.stopped {
+		d.stopped = true
+		close(d.done)
+		d.clear()
+	}
+	return nil
+}
+
+// Write will print p above the Display.
+func (d *Display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.clear()
+	n, err := d.w.Write(p)
+	d.redraw()
+	return n, err
+}
+
+// clear will erase the lines drawn, leaving the cursor at the start of the first one.
+// Must be called with the lock held.
+func (d *Display) clear() {
+	if d.lines == 0 {
+		return
+	}
+	fmt.Fprintf(d.w, "\r%s\x1b[J", strings.Repeat("\x1b[1A", d.lines-1))
+	d.lines = 0
+}
+
+// redraw will replace the lines drawn with the current progress. Must be called with the lock held.
+func (d *Display) redraw() {
+	if d.stopped || d.tracker == nil {
+		return
+	}
+	lines := d.render()
+	d.clear()
+	fmt.Fprint(d.w, strings.Join(lines, "\n"))
+	d.lines = len(lines)
+}
+
+// render will return the lines showing the current progress, cut to the width of the terminal.
+// Must be called with the lock held.
+func (d *Display) render() []string {
+	status := d.tracker.Status()
+	elapsed := d.now().Sub(status.StartTime).Seconds()
+
+	header := fmt.Sprintf("%s %s@%s", status.Operation, status.VolumeName, status.Snapshot)
+	if d.volume > 0 {
+		header = fmt.Sprintf("%s - volume %d", header, d.volume)
+	}
+	if status.Retries > 0 {
+		header = fmt.Sprintf("%s - %d retries", header, status.Retries)
+	}
+	if helpers.Transfers.Paused() {
+		header += " - paused"
+	}
+	lines := []string{header}
+
+	for _, dest := range d.destinations {
+		line := fmt.Sprintf("  %s: %s", dest, humanize.IBytes(d.destBytes[dest]))
+		if elapsed > 0 && !status.StartTime.IsZero() {
+			line = fmt.Sprintf("%s at %s/s", line, humanize.IBytes(uint64(float64(d.destBytes[dest])/elapsed)))
+		}
+		lines = append(lines, line)
+	}
+
+	var total string
+	if status.TotalBytes > 0 {
+		done := int(status.Percent() / 100 * progressBarWidth)
+		total = fmt.Sprintf("  [%s%s] %5.1f%% %s of %s", strings.Repeat("#", done), strings.Repeat("-", progressBarWidth-done),
+			status.Percent(), humanize.IBytes(status.BytesDone), humanize.IBytes(status.TotalBytes))
+	} else {
+		total = fmt.Sprintf("  %s", humanize.IBytes(status.BytesDone))
+	}
+	total = fmt.Sprintf("%s at %s/s", total, humanize.IBytes(uint64(status.Throughput)))
+	if status.ETA > 0 {
+		total = fmt.Sprintf("%s, ETA %v", total, status.ETA.Round(time.Second))
+	}
+	lines = append(lines, total)
+
+	if d.width > 0 {
+		for i, line := range lines {
+			if len(line) >= d.width {
+				lines[i] = line[:d.width-1]
+			}
+		}
+	}
+	return lines
+}
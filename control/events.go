@@ -0,0 +1,137 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package control
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Event is a line of the newline delimited JSON stream written by an EventStream. Event is the name of
+// the helpers.ProgressEventType reported, or job_result for the last line with the Result of the job.
+// BytesDone and TotalBytes are the running totals of the job as tracked by a Tracker.
+type Event struct {
+	Time         time.Time
+	Operation    string
+	Event        string
+	VolumeName   string  `json:",omitempty"`
+	Snapshot     string  `json:",omitempty"`
+	ObjectName   string  `json:",omitempty"`
+	Destination  string  `json:",omitempty"`
+	VolumeNumber int64   `json:",omitempty"`
+	Bytes        uint64  `json:",omitempty"`
+	Message      string  `json:",omitempty"`
+	BytesDone    uint64  `json:",omitempty"`
+	TotalBytes   uint64  `json:",omitempty"`
+	Result       *Result `json:",omitempty"`
+}
+
+// EventStream is an Observer writing every progress event, and the result, of a job to a writer as
+// newline delimited JSON so wrappers can follow a job without parsing its logs.
+type EventStream struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	closer  io.Closer
+	tracker *Tracker
+	failed  bool
+}
+
+// NewEventStream will return an EventStream writing to w, which it will not close.
+func NewEventStream(w io.Writer) *EventStream {
+	return &EventStream{enc: json.NewEncoder(w)}
+}
+
+// OpenEventStream will return an EventStream appending to the file at path, created if it does not exist,
+// or writing to it if it is a FIFO, in which case opening it blocks until it is opened for reading.
+func OpenEventStream(path string) (*EventStream, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := NewEventStream(f)
+	s.closer = f
+	return s, nil
+}
+
+// Progress will write the event as a line of JSON.
+func (s *EventStream) Progress(operation string, event helpers.ProgressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tracker == nil {
+		s.tracker = NewTracker(operation, os.Getpid())
+	}
+	s.tracker.Update(event)
+	status := s.tracker.Status()
+
+	s.write(Event{
+		Time:         event.Time,
+		Operation:    operation,
+		Event:        event.Type.String(),
+		VolumeName:   event.VolumeName,
+		Snapshot:     event.Snapshot,
+		ObjectName:   event.ObjectName,
+		Destination:  event.Destination,
+		VolumeNumber: event.VolumeNumber,
+		Bytes:        event.Bytes,
+		Message:      event.Message,
+		BytesDone:    status.BytesDone,
+		TotalBytes:   status.TotalBytes,
+	})
+}
+
+// Finished will write the result of the job as the last line of JSON.
+func (s *EventStream) Finished(result Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event := Event{Time: result.EndTime, Operation: result.Operation, Event: "job_result", Result: &result}
+	if s.tracker != nil {
+		status := s.tracker.Status()
+		event.BytesDone, event.TotalBytes = status.BytesDone, status.TotalBytes
+	}
+	s.write(event)
+}
+
+// Close will close the file the EventStream writes to, if it opened it.
+func (s *EventStream) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// write will encode the event, giving up on the stream after the first error so a reader going away
+// doesn't affect the job. Must be called with the lock held.
+func (s *EventStream) write(event Event) {
+	if s.failed {
+		return
+	}
+	if err := s.enc.Encode(event); err != nil {
+		helpers.AppLogger.Warningf("Could not write the progress event, no more events will be written - %v", err)
+		s.failed = true
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package control
+
+import (
+	"sync"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Result describes the outcome of a job that has finished running.
+type Result struct {
+	Operation    string
+	VolumeName   string
+	Snapshot     string
+	Destinations []string
+	StartTime    time.Time
+	EndTime      time.Time
+	Bytes        uint64
+	StreamBytes  uint64
+	Error        string `json:",omitempty"`
+	ErrorKind    string `json:",omitempty"`
+}
+
+// NewResult will describe the outcome of the operation run for j, which ended with err.
+func NewResult(operation string, j *helpers.JobInfo, err error) Result {
+	result := Result{
+		Operation:    operation,
+		VolumeName:   j.VolumeName,
+		Snapshot:     j.BaseSnapshot.Name,
+		Destinations: j.Destinations,
+		StartTime:    j.StartTime,
+		EndTime:      time.Now(),
+		Bytes:        j.TotalBytesWritten(),
+		StreamBytes:  j.ZFSStreamBytes,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.ErrorKind = helpers.KindOf(err).String()
+	}
+	return result
+}
+
+// Duration will return how long the job ran for.
+func (r Result) Duration() time.Duration {
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// Failed will return true if the job ended with an error.
+func (r Result) Failed() bool {
+	return r.Error != ""
+}
+
+// History keeps the results of the most recently finished jobs.
+type History struct {
+	mu      sync.Mutex
+	results []Result
+	size    int
+}
+
+// NewHistory will return a History that remembers up to size results.
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = 1
+	}
+	return &History{size: size}
+}
+
+// Record will add the result to the History, forgetting the oldest result if it is full.
+func (h *History) Record(result Result) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.results = append(h.results, result)
+	if len(h.results) > h.size {
+		h.results = h.results[len(h.results)-h.size:]
+	}
+}
+
+// Recent will return the remembered results, most recent first.
+func (h *History) Recent() []Result {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	results := make([]Result, len(h.results))
+	for idx := range h.results {
+		results[len(h.results)-1-idx] = h.results[idx]
+	}
+	return results
+}
+
+// Failures will return the remembered results that ended with an error, most recent first.
+func (h *History) Failures() []Result {
+	var failures []Result
+	for _, result := range h.Recent() {
+		if result.Failed() {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+// Observer is notified of the progress and outcome of every job it watches. Progress may
+// be called concurrently from several goroutines.
+type Observer interface {
+	Progress(operation string, event helpers.ProgressEvent)
+	Finished(result Result)
+}
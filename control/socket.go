@@ -0,0 +1,204 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+const (
+	socketDirName = "jobs"
+	socketSuffix  = ".sock"
+	dialTimeout   = 5 * time.Second
+)
+
+// Commands a Server answers to, the Status of the job is returned for each one.
+const (
+	CommandStatus = "status"
+	// CommandPause will pause every transfer of the job, see helpers.Transfers.
+	CommandPause = "pause"
+	// CommandResume will resume the transfers of a paused job.
+	CommandResume = "resume"
+)
+
+// SocketDir will return the directory control sockets are created in for the given working directory.
+func SocketDir(workingDir string) string {
+	return filepath.Join(workingDir, socketDirName)
+}
+
+// Server answers every command sent to its unix socket with the current Status of a Tracker.
+type Server struct {
+	listener net.Listener
+	path     string
+	tracker  *Tracker
+	wg       sync.WaitGroup
+}
+
+// Serve will open a unix socket for the Tracker's process in dir and answer queries
+// on it until Close is called.
+func Serve(dir string, tracker *Tracker) (*Server, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	path := SocketPath(dir, tracker.status.PID)
+	// A previous process with our pid did not clean up after itself
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{listener: listener, path: path, tracker: tracker}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	command, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		helpers.AppLogger.Debugf("Could not read command from control socket - %v", err)
+		return
+	}
+
+	switch command = strings.TrimSpace(command); command {
+	case CommandStatus:
+	case CommandPause:
+		helpers.Transfers.Pause()
+	case CommandResume:
+		helpers.Transfers.Resume()
+	default:
+		helpers.AppLogger.Debugf("Ignoring unknown command %q sent to control socket", command)
+		return
+	}
+
+	status := s.tracker.Status()
+	status.Paused = helpers.Transfers.Paused()
+	if err = json.NewEncoder(conn).Encode(status); err != nil {
+		helpers.AppLogger.Debugf("Could not write job status to control socket - %v", err)
+	}
+}
+
+// Path will return the path to the unix socket.
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Close will stop answering queries and remove the unix socket.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	if rerr := os.Remove(s.path); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// Query will ask the job listening on the unix socket at path for its Status.
+func Query(path string) (Status, error) {
+	return Send(path, CommandStatus)
+}
+
+// Send will send the command to the job listening on the unix socket at path and return its Status.
+func Send(path, command string) (Status, error) {
+	var status Status
+	conn, err := net.DialTimeout("unix", path, dialTimeout)
+	if err != nil {
+		return status, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if _, err = fmt.Fprintln(conn, command); err != nil {
+		return status, err
+	}
+	if err = json.NewDecoder(conn).Decode(&status); err == io.EOF {
+		err = fmt.Errorf("the job did not accept the command %q", command)
+	}
+	return status, err
+}
+
+// SocketPath will return the path to the unix socket of the job run by the process pid.
+func SocketPath(dir string, pid int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d%s", pid, socketSuffix))
+}
+
+// ListJobs will query every job with a control socket in dir, oldest first. Sockets
+// left behind by jobs that are no longer running are removed.
+func ListJobs(dir string) ([]Status, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+socketSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(paths))
+	for _, path := range paths {
+		status, qerr := Query(path)
+		if errors.Is(qerr, syscall.ECONNREFUSED) {
+			helpers.AppLogger.Debugf("Removing stale control socket %s - %v", path, qerr)
+			if rerr := os.Remove(path); rerr != nil {
+				helpers.AppLogger.Warningf("Could not remove stale control socket %s - %v", path, rerr)
+			}
+			continue
+		} else if qerr != nil {
+			helpers.AppLogger.Warningf("Could not query job on control socket %s - %v", path, qerr)
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].StartTime.Before(statuses[j].StartTime)
+	})
+	return statuses, nil
+}
@@ -0,0 +1,162 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package control lets a running zfsbackup job publish its progress over a unix
+// socket so that other invocations can query it while it runs.
+package control
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Status is a point in time view of a running job.
+type Status struct {
+	PID           int
+	Operation     string
+	VolumeName    string
+	Snapshot      string
+	StartTime     time.Time
+	LastUpdate    time.Time
+	CurrentVolume string
+	VolumesDone   int64
+	Retries       int64
+	BytesDone     uint64
+	TotalBytes    uint64
+	Throughput    float64 // bytes per second
+	ETA           time.Duration
+	Paused        bool
+}
+
+// String will return a multi-line, human readable representation of this Status.
+func (s Status) String() string {
+	var output []string
+	output = append(output, fmt.Sprintf("Job: %s %s@%s (pid %d)", s.Operation, s.VolumeName, s.Snapshot, s.PID))
+	output = append(output, fmt.Sprintf("Started: %v (running for %v)", s.StartTime, time.Since(s.StartTime).Round(time.Second)))
+	if s.CurrentVolume != "" {
+		output = append(output, fmt.Sprintf("Current Volume: %s", s.CurrentVolume))
+	}
+	if s.TotalBytes > 0 {
+		output = append(output, fmt.Sprintf("Progress: %d volumes - %s of %s (%.1f%%)", s.VolumesDone, humanize.IBytes(s.BytesDone), humanize.IBytes(s.TotalBytes), s.Percent()))
+	} else {
+		output = append(output, fmt.Sprintf("Progress: %d volumes - %s", s.VolumesDone, humanize.IBytes(s.BytesDone)))
+	}
+	output = append(output, fmt.Sprintf("Throughput: %s/s", humanize.IBytes(uint64(s.Throughput))))
+	if s.Retries > 0 {
+		output = append(output, fmt.Sprintf("Retries: %d", s.Retries))
+	}
+	if s.ETA > 0 {
+		output = append(output, fmt.Sprintf("ETA: %v", s.ETA.Round(time.Second)))
+	}
+	if s.Paused {
+		output = append(output, "Paused: all transfers are paused")
+	}
+	return strings.Join(output, "\n\t")
+}
+
+// Percent will return how much of the job is complete, if the size of the job is known.
+func (s Status) Percent() float64 {
+	if s.TotalBytes == 0 {
+		return 0
+	}
+	percent := float64(s.BytesDone) / float64(s.TotalBytes) * 100
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// progressEvents maps an operation to the event that marks a volume as done for it.
+var progressEvents = map[string]helpers.ProgressEventType{
+	"send":    helpers.ProgressVolumeCreated,
+	"receive": helpers.ProgressVolumeDownloaded,
+	"verify":  helpers.ProgressVolumeVerified,
+}
+
+// Tracker consumes the progress events of a job to keep an up to date Status.
+type Tracker struct {
+	mu     sync.Mutex
+	status Status
+	now    func() time.Time
+}
+
+// NewTracker will return a Tracker for an operation (send, receive, or verify) run by the given process.
+func NewTracker(operation string, pid int) *Tracker {
+	return &Tracker{
+		status: Status{PID: pid, Operation: operation},
+		now:    time.Now,
+	}
+}
+
+// Update will fold the event into the Tracker's Status. It is a helpers.ProgressFunc.
+func (t *Tracker) Update(event helpers.ProgressEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.LastUpdate = event.Time
+	if event.VolumeName != "" {
+		t.status.VolumeName = event.VolumeName
+	}
+	if event.Snapshot != "" {
+		t.status.Snapshot = event.Snapshot
+	}
+
+	switch event.Type {
+	case helpers.ProgressJobStarted:
+		if t.status.StartTime.IsZero() {
+			t.status.StartTime = event.Time
+		}
+	case helpers.ProgressRetry:
+		t.status.Retries++
+	case helpers.ProgressJobPlanned:
+		// An auto restore plans every snapshot it restores in turn
+		t.status.TotalBytes += event.Bytes
+	case progressEvents[t.status.Operation]:
+		t.status.CurrentVolume = event.ObjectName
+		t.status.VolumesDone++
+		t.status.BytesDone += event.Bytes
+	}
+}
+
+// Status will return the current Status, with the throughput and ETA computed as of now.
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	status := t.status
+	t.mu.Unlock()
+
+	if status.StartTime.IsZero() {
+		return status
+	}
+
+	elapsed := t.now().Sub(status.StartTime).Seconds()
+	if elapsed > 0 {
+		status.Throughput = float64(status.BytesDone) / elapsed
+	}
+	if status.Throughput > 0 && status.TotalBytes > status.BytesDone {
+		status.ETA = time.Duration(float64(status.TotalBytes-status.BytesDone) / status.Throughput * float64(time.Second))
+	}
+	return status
+}
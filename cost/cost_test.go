@@ -0,0 +1,107 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cost
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestLoadPricing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackuppricing")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "pricing.json")
+	overrides := `{"S3": {"GLACIER": {"storagePerGiBMonth": 0.004}}, "wasabi": {"standard": {"storagePerGiBMonth": 0.0059}}}`
+	if err = ioutil.WriteFile(path, []byte(overrides), 0600); err != nil {
+		t.Fatalf("could not write pricing file - %v", err)
+	}
+
+	pricing, err := LoadPricing(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading pricing - %v", err)
+	}
+
+	testCases := []struct {
+		destination, class string
+		storage            float64
+		valid              bool
+	}{
+		{"s3://bucket", "glacier", 0.004, true},
+		{"s3://bucket", "", DefaultPricing["s3"]["standard"].StoragePerGiBMonth, true},
+		{"gs://bucket", "Coldline", DefaultPricing["gs"]["coldline"].StoragePerGiBMonth, true},
+		{"wasabi://bucket", "", 0, false},
+		{"wasabi://bucket", "standard", 0.0059, true},
+		{"gs://bucket", "unknown", 0, false},
+	}
+
+	for idx, testCase := range testCases {
+		_, price, err := pricing.Lookup(testCase.destination, testCase.class)
+		if (err == nil) != testCase.valid {
+			t.Errorf("%d: expected valid %v, got error %v", idx, testCase.valid, err)
+		} else if price.StoragePerGiBMonth != testCase.storage {
+			t.Errorf("%d: expected storage price %v, got %v", idx, testCase.storage, price.StoragePerGiBMonth)
+		}
+	}
+
+	if DefaultPricing["s3"]["glacier"].StoragePerGiBMonth == 0.004 {
+		t.Errorf("loading pricing overrides changed the default pricing")
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	set := func(bytes uint64, parent *helpers.JobInfo) *helpers.JobInfo {
+		return &helpers.JobInfo{Volumes: []*helpers.VolumeInfo{{Size: bytes}}, ParentSnap: parent}
+	}
+
+	full := set(10*bytesPerGiB, nil)
+	incremental := set(bytesPerGiB, full)
+	other := set(2*bytesPerGiB, nil)
+	volumes := map[string][]*helpers.JobInfo{
+		"tank/a": {set(5*bytesPerGiB, nil), full, incremental},
+		"tank/b": {other},
+	}
+
+	report := NewReport("s3://bucket", "standard_ia", Price{StoragePerGiBMonth: 0.01, RetrievalPerGiB: 0.01, EgressPerGiB: 0.09}, volumes)
+	if report.BackupSets != 4 {
+		t.Errorf("expected 4 backup sets, got %d", report.BackupSets)
+	}
+	if report.Bytes != 18*bytesPerGiB {
+		t.Errorf("expected %d bytes, got %d", 18*bytesPerGiB, report.Bytes)
+	}
+	if report.RestoreBytes != 13*bytesPerGiB {
+		t.Errorf("expected %d restore bytes, got %d", 13*bytesPerGiB, report.RestoreBytes)
+	}
+	if math.Abs(report.MonthlyStorage-0.18) > 1e-9 {
+		t.Errorf("expected a monthly storage cost of 0.18, got %v", report.MonthlyStorage)
+	}
+	if math.Abs(report.Restore-1.3) > 1e-9 {
+		t.Errorf("expected a restore cost of 1.3, got %v", report.Restore)
+	}
+}
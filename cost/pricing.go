@@ -0,0 +1,131 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package cost estimates what storing backup sets with a cloud storage provider, and
+// restoring them, costs.
+package cost
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Price of a storage class, in USD per GiB.
+type Price struct {
+	StoragePerGiBMonth float64 `json:"storagePerGiBMonth"`
+	RetrievalPerGiB    float64 `json:"retrievalPerGiB"`
+	EgressPerGiB       float64 `json:"egressPerGiB"`
+}
+
+// Pricing holds the price of every storage class, keyed by the URI prefix of the backend
+// (e.g. s3) and then the lower cased name of the storage class (e.g. glacier).
+type Pricing map[string]map[string]Price
+
+// DefaultClasses are the storage classes assumed when none is provided for a backend.
+var DefaultClasses = map[string]string{
+	"s3":    "standard",
+	"gs":    "standard",
+	"azure": "hot",
+	"b2":    "standard",
+	"file":  "standard",
+}
+
+// DefaultPricing holds list prices of a US region for the first TiB stored and transferred out
+// to the internet. Override them with LoadPricing to match your region, discounts, or provider.
+var DefaultPricing = Pricing{
+	"s3": {
+		"standard":     {StoragePerGiBMonth: 0.023, EgressPerGiB: 0.09},
+		"standard_ia":  {StoragePerGiBMonth: 0.0125, RetrievalPerGiB: 0.01, EgressPerGiB: 0.09},
+		"onezone_ia":   {StoragePerGiBMonth: 0.01, RetrievalPerGiB: 0.01, EgressPerGiB: 0.09},
+		"glacier":      {StoragePerGiBMonth: 0.0036, RetrievalPerGiB: 0.0025, EgressPerGiB: 0.09},
+		"deep_archive": {StoragePerGiBMonth: 0.00099, RetrievalPerGiB: 0.0025, EgressPerGiB: 0.09},
+	},
+	"gs": {
+		"standard": {StoragePerGiBMonth: 0.02, EgressPerGiB: 0.12},
+		"nearline": {StoragePerGiBMonth: 0.01, RetrievalPerGiB: 0.01, EgressPerGiB: 0.12},
+		"coldline": {StoragePerGiBMonth: 0.004, RetrievalPerGiB: 0.02, EgressPerGiB: 0.12},
+		"archive":  {StoragePerGiBMonth: 0.0012, RetrievalPerGiB: 0.05, EgressPerGiB: 0.12},
+	},
+	"azure": {
+		"hot":     {StoragePerGiBMonth: 0.0184, EgressPerGiB: 0.087},
+		"cool":    {StoragePerGiBMonth: 0.01, RetrievalPerGiB: 0.01, EgressPerGiB: 0.087},
+		"archive": {StoragePerGiBMonth: 0.00099, RetrievalPerGiB: 0.02, EgressPerGiB: 0.087},
+	},
+	"b2": {
+		"standard": {StoragePerGiBMonth: 0.006, EgressPerGiB: 0.01},
+	},
+	"file": {
+		"standard": {},
+	},
+}
+
+// LoadPricing will read a JSON file shaped like Pricing and return the default pricing with
+// every storage class found in the file added or replaced.
+func LoadPricing(path string) (Pricing, error) {
+	pricing := make(Pricing)
+	for backend, classes := range DefaultPricing {
+		pricing[backend] = make(map[string]Price)
+		for class, price := range classes {
+			pricing[backend][class] = price
+		}
+	}
+
+	if path == "" {
+		return pricing, nil
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var overrides Pricing
+	if err = json.Unmarshal(raw, &overrides); err != nil {
+		return nil, fmt.Errorf("could not parse pricing file %s - %v", path, err)
+	}
+
+	for backend, classes := range overrides {
+		backend = strings.ToLower(backend)
+		if pricing[backend] == nil {
+			pricing[backend] = make(map[string]Price)
+		}
+		for class, price := range classes {
+			pricing[backend][strings.ToLower(class)] = price
+		}
+	}
+	return pricing, nil
+}
+
+// Lookup will return the price of the storage class of the backend the destination URI
+// belongs to, using the backend's default class if none is provided.
+func (p Pricing) Lookup(destination, class string) (string, Price, error) {
+	backend := strings.ToLower(strings.SplitN(destination, "://", 2)[0])
+	if class == "" {
+		class = DefaultClasses[backend]
+	}
+	class = strings.ToLower(class)
+
+	price, ok := p[backend][class]
+	if !ok {
+		return class, Price{}, fmt.Errorf("no pricing found for the %s storage class of the %s backend", class, backend)
+	}
+	return class, price, nil
+}
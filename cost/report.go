@@ -0,0 +1,69 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cost
+
+import (
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+const bytesPerGiB = 1024 * 1024 * 1024
+
+// Report estimates the costs of the backup sets found at a destination.
+type Report struct {
+	Destination  string
+	StorageClass string
+	BackupSets   int
+	// The bytes stored by every backup set found.
+	Bytes uint64
+	// The cost, in USD, of storing every backup set for a month.
+	MonthlyStorage float64
+	// The bytes downloaded to restore the latest snapshot of every volume.
+	RestoreBytes uint64
+	// The cost, in USD, of retrieving and transferring the backup sets needed to restore
+	// the latest snapshot of every volume.
+	Restore float64
+}
+
+// NewReport will estimate the costs of the backup sets found at a destination, grouped by
+// volume with every incremental backup set linked to its parent (see backup.LinkBackupSets),
+// stored with the given storage class.
+func NewReport(destination, class string, price Price, volumes map[string][]*helpers.JobInfo) *Report {
+	report := &Report{Destination: destination, StorageClass: class}
+
+	for _, sets := range volumes {
+		for _, set := range sets {
+			report.BackupSets++
+			report.Bytes += set.TotalBytesWritten()
+		}
+
+		if len(sets) == 0 {
+			continue
+		}
+		// Restoring the latest snapshot requires every backup set it was taken from
+		for set := sets[len(sets)-1]; set != nil; set = set.ParentSnap {
+			report.RestoreBytes += set.TotalBytesWritten()
+		}
+	}
+
+	report.MonthlyStorage = float64(report.Bytes) / bytesPerGiB * price.StoragePerGiBMonth
+	report.Restore = float64(report.RestoreBytes) / bytesPerGiB * (price.RetrievalPerGiB + price.EgressPerGiB)
+	return report
+}
@@ -0,0 +1,262 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package dashboard serves a small web UI and JSON API summarizing the backup sets
+// found in a set of destinations along with the recent jobs run by the daemon.
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Summary is everything the dashboard displays.
+type Summary struct {
+	GeneratedAt    time.Time
+	Datasets       []DatasetSummary
+	Destinations   []DestinationSummary
+	RecentJobs     []control.Result
+	RecentFailures []control.Result
+}
+
+// DatasetSummary describes the backup history of a single dataset in a single destination.
+type DatasetSummary struct {
+	VolumeName   string
+	Destination  string
+	BackupSets   int
+	FullBackups  int
+	LastBackup   time.Time
+	LastFull     time.Time
+	TotalBytes   uint64
+	BrokenChains []string `json:",omitempty"` // Incremental snapshots whose parent could not be found
+	History      []BackupSetSummary
+}
+
+// Healthy will return true if every incremental backup set of the dataset can be restored.
+func (d DatasetSummary) Healthy() bool {
+	return len(d.BrokenChains) == 0
+}
+
+// BackupSetSummary describes a single backup set.
+type BackupSetSummary struct {
+	Snapshot     string
+	CreationTime time.Time
+	Incremental  string `json:",omitempty"`
+	Volumes      int
+	Bytes        uint64
+}
+
+// DestinationSummary describes the storage used in a single destination.
+type DestinationSummary struct {
+	Destination string
+	BackupSets  int
+	TotalBytes  uint64
+	Error       string `json:",omitempty"`
+}
+
+// RefreshInterval is how long the backup sets listed are shown for before the destinations are listed again.
+const RefreshInterval = time.Minute
+
+// Dashboard is an http.Handler serving the dashboard UI at / and its data at /api/summary.
+type Dashboard struct {
+	defaults     helpers.JobInfo
+	destinations []string
+	history      *control.History
+	mux          *http.ServeMux
+	refresh      time.Duration
+
+	// The last listing of the destinations, shared by every request until refresh passes
+	listMu   sync.Mutex
+	listed   time.Time
+	dests    []DestinationSummary
+	datasets []DatasetSummary
+}
+
+// New will return a Dashboard summarizing the backup sets found in destinations, reading
+// manifests with the options in defaults. The history is optional.
+func New(defaults helpers.JobInfo, destinations []string, history *control.History) *Dashboard {
+	d := &Dashboard{
+		defaults:     defaults,
+		destinations: destinations,
+		history:      history,
+		mux:          http.NewServeMux(),
+		refresh:      RefreshInterval,
+	}
+	d.mux.HandleFunc("/", d.serveIndex)
+	d.mux.HandleFunc("/api/summary", d.serveSummary)
+	return d
+}
+
+// ServeHTTP implements http.Handler.
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+func (d *Dashboard) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	summary := d.Summary(r.Context())
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, summary); err != nil {
+		helpers.AppLogger.Warningf("Could not render dashboard - %v", err)
+	}
+}
+
+func (d *Dashboard) serveSummary(w http.ResponseWriter, r *http.Request) {
+	summary := d.Summary(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		helpers.AppLogger.Warningf("Could not write dashboard summary - %v", err)
+	}
+}
+
+// Summary will summarize the backup sets found in every destination, listing them again if the last listing
+// is older than RefreshInterval. Concurrent calls wait for a single listing rather than each syncing the manifest
+// cache.
+func (d *Dashboard) Summary(ctx context.Context) Summary {
+	d.listMu.Lock()
+	if d.listed.IsZero() || time.Since(d.listed) >= d.refresh {
+		listed := time.Now()
+		dests, datasets := d.list(ctx)
+		// Don't keep what a canceled request could not list
+		if ctx.Err() == nil {
+			d.listed, d.dests, d.datasets = listed, dests, datasets
+		} else {
+			d.listMu.Unlock()
+			return Summary{GeneratedAt: listed, Destinations: dests, Datasets: datasets}
+		}
+	}
+	summary := Summary{
+		GeneratedAt:  d.listed,
+		Destinations: append([]DestinationSummary(nil), d.dests...),
+		Datasets:     append([]DatasetSummary(nil), d.datasets...),
+	}
+	d.listMu.Unlock()
+
+	if d.history != nil {
+		summary.RecentJobs = d.history.Recent()
+		summary.RecentFailures = d.history.Failures()
+	}
+	return summary
+}
+
+// list will list the backup sets found in every destination, in parallel, and summarize them.
+func (d *Dashboard) list(ctx context.Context) ([]DestinationSummary, []DatasetSummary) {
+	dests := make([]DestinationSummary, len(d.destinations))
+	datasets := make([][]DatasetSummary, len(d.destinations))
+
+	var wg sync.WaitGroup
+	for idx := range d.destinations {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			destination := d.destinations[idx]
+			j := d.defaults
+			j.Destinations = []string{destination}
+
+			sets, err := backup.ListBackupSets(ctx, &j, "", time.Time{}, time.Time{})
+			if err != nil {
+				helpers.AppLogger.Warningf("Could not list backup sets in %s - %v", destination, err)
+				dests[idx] = DestinationSummary{Destination: destination, Error: err.Error()}
+				return
+			}
+			dests[idx], datasets[idx] = summarize(destination, sets)
+		}(idx)
+	}
+	wg.Wait()
+
+	var all []DatasetSummary
+	for _, ds := range datasets {
+		all = append(all, ds...)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].VolumeName < all[j].VolumeName
+	})
+	return dests, all
+}
+
+// summarize will group the backup sets found in destination by dataset.
+func summarize(destination string, sets []*helpers.JobInfo) (DestinationSummary, []DatasetSummary) {
+	dest := DestinationSummary{Destination: destination, BackupSets: len(sets)}
+	linked := backup.LinkBackupSets(context.Background(), sets)
+
+	datasets := make([]DatasetSummary, 0, len(linked))
+	for volumeName, volumeSets := range linked {
+		ds := DatasetSummary{VolumeName: volumeName, Destination: destination, BackupSets: len(volumeSets)}
+		for _, set := range volumeSets {
+			bytes := set.TotalBytesWritten()
+			ds.TotalBytes += bytes
+			if set.BaseSnapshot.CreationTime.After(ds.LastBackup) {
+				ds.LastBackup = set.BaseSnapshot.CreationTime
+			}
+			if set.IncrementalSnapshot.Name == "" {
+				ds.FullBackups++
+				if set.BaseSnapshot.CreationTime.After(ds.LastFull) {
+					ds.LastFull = set.BaseSnapshot.CreationTime
+				}
+			} else if set.ParentSnap == nil {
+				ds.BrokenChains = append(ds.BrokenChains, set.BaseSnapshot.Name)
+			}
+			ds.History = append(ds.History, BackupSetSummary{
+				Snapshot:     set.BaseSnapshot.Name,
+				CreationTime: set.BaseSnapshot.CreationTime,
+				Incremental:  set.IncrementalSnapshot.Name,
+				Volumes:      len(set.Volumes),
+				Bytes:        bytes,
+			})
+		}
+		// Most recent first
+		sort.SliceStable(ds.History, func(i, j int) bool {
+			return ds.History[i].CreationTime.After(ds.History[j].CreationTime)
+		})
+		dest.TotalBytes += ds.TotalBytes
+		datasets = append(datasets, ds)
+	}
+
+	sort.Slice(datasets, func(i, j int) bool {
+		return datasets[i].VolumeName < datasets[j].VolumeName
+	})
+	return dest, datasets
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(template.FuncMap{
+	"bytes": humanize.IBytes,
+	"date":  formatTime,
+}).Parse(indexHTML))
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format("2006-01-02 15:04:05 MST")
+}
@@ -0,0 +1,149 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestSummarize(t *testing.T) {
+	now := time.Now()
+	full := &helpers.JobInfo{
+		VolumeName:   "tank/data",
+		BaseSnapshot: helpers.SnapshotInfo{Name: "snap1", CreationTime: now.Add(-2 * time.Hour)},
+		Volumes:      []*helpers.VolumeInfo{{Size: 100}},
+	}
+	incremental := &helpers.JobInfo{
+		VolumeName:          "tank/data",
+		BaseSnapshot:        helpers.SnapshotInfo{Name: "snap2", CreationTime: now.Add(-time.Hour)},
+		IncrementalSnapshot: full.BaseSnapshot,
+		Volumes:             []*helpers.VolumeInfo{{Size: 10}},
+	}
+	orphan := &helpers.JobInfo{
+		VolumeName:          "tank/other",
+		BaseSnapshot:        helpers.SnapshotInfo{Name: "snap4", CreationTime: now},
+		IncrementalSnapshot: helpers.SnapshotInfo{Name: "snap3", CreationTime: now.Add(-time.Minute)},
+		Volumes:             []*helpers.VolumeInfo{{Size: 5}, {Size: 5}},
+	}
+
+	dest, datasets := summarize("file:///backups", []*helpers.JobInfo{full, incremental, orphan})
+	if dest.BackupSets != 3 || dest.TotalBytes != 120 {
+		t.Errorf("unexpected destination summary: %+v", dest)
+	}
+	if len(datasets) != 2 {
+		t.Fatalf("expected 2 datasets, got %d", len(datasets))
+	}
+
+	data := datasets[0]
+	if data.VolumeName != "tank/data" || !data.Healthy() || data.FullBackups != 1 || data.TotalBytes != 110 {
+		t.Errorf("unexpected dataset summary: %+v", data)
+	}
+	if !data.LastBackup.Equal(incremental.BaseSnapshot.CreationTime) || !data.LastFull.Equal(full.BaseSnapshot.CreationTime) {
+		t.Errorf("unexpected last backup times: %v, %v", data.LastBackup, data.LastFull)
+	}
+	if len(data.History) != 2 || data.History[0].Snapshot != "snap2" {
+		t.Errorf("expected history to be most recent first, got %+v", data.History)
+	}
+
+	other := datasets[1]
+	if other.Healthy() || len(other.BrokenChains) != 1 || other.BrokenChains[0] != "snap4" {
+		t.Errorf("expected a broken chain at snap4, got %+v", other)
+	}
+}
+
+func TestDashboardHandler(t *testing.T) {
+	history := control.NewHistory(10)
+	history.Record(control.Result{Operation: "send", VolumeName: "tank/data", Snapshot: "snap1", Error: "upload failed"})
+	d := New(helpers.JobInfo{}, nil, history)
+
+	req := httptest.NewRequest("GET", "/api/summary", nil)
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var summary Summary
+	if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+		t.Fatalf("could not decode summary - %v", err)
+	}
+	if len(summary.RecentFailures) != 1 || summary.RecentFailures[0].Error != "upload failed" {
+		t.Errorf("unexpected recent failures: %+v", summary.RecentFailures)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	rec = httptest.NewRecorder()
+	d.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "upload failed") {
+		t.Errorf("expected the index to render the recent failure, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/missing", nil)
+	rec = httptest.NewRecorder()
+	d.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	if s := d.Summary(context.Background()); len(s.Datasets) != 0 || len(s.Destinations) != 0 {
+		t.Errorf("expected an empty summary without destinations, got %+v", s)
+	}
+}
+
+func TestSummaryRefresh(t *testing.T) {
+	d := New(helpers.JobInfo{ManifestPrefix: "manifests"}, []string{"invalid://target"}, nil)
+
+	// Concurrent requests share a single listing
+	summaries := make(chan Summary, 5)
+	for i := 0; i < cap(summaries); i++ {
+		go func() { summaries <- d.Summary(context.Background()) }()
+	}
+	first := <-summaries
+	for i := 1; i < cap(summaries); i++ {
+		if s := <-summaries; !s.GeneratedAt.Equal(first.GeneratedAt) {
+			t.Errorf("expected every request to share the listing of %v, got one of %v", first.GeneratedAt, s.GeneratedAt)
+		}
+	}
+	if len(first.Destinations) != 1 || first.Destinations[0].Error == "" {
+		t.Errorf("expected the destination to fail to be listed, got %+v", first.Destinations)
+	}
+
+	d.refresh = 0
+	if s := d.Summary(context.Background()); !s.GeneratedAt.After(first.GeneratedAt) {
+		t.Errorf("expected the destinations to be listed again once the refresh interval passed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	listed := d.listed
+	d.Summary(ctx)
+	if !d.listed.Equal(listed) {
+		t.Errorf("expected the listing of a canceled request not to be kept")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dashboard
+
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>zfsbackup</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+th { background: #eee; }
+.ok { color: #2a7a2a; }
+.bad { color: #b22; font-weight: bold; }
+details { margin-bottom: 0.3em; }
+</style>
+</head>
+<body>
+<h1>zfsbackup</h1>
+<p>Generated {{date .GeneratedAt}} &middot; <a href="/api/summary">JSON</a></p>
+
+<h2>Recent Failures</h2>
+{{if .RecentFailures}}
+<table>
+<tr><th>Finished</th><th>Operation</th><th>Snapshot</th><th>Destinations</th><th>Error</th></tr>
+{{range .RecentFailures}}
+<tr><td>{{date .EndTime}}</td><td>{{.Operation}}</td><td>{{.VolumeName}}@{{.Snapshot}}</td><td>{{range .Destinations}}{{.}} {{end}}</td><td class="bad">{{.Error}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p class="ok">No recent failures.</p>
+{{end}}
+
+<h2>Datasets</h2>
+<table>
+<tr><th>Dataset</th><th>Destination</th><th>Backup Sets</th><th>Last Backup</th><th>Last Full Backup</th><th>Size</th><th>Chain</th></tr>
+{{range .Datasets}}
+<tr>
+<td>{{.VolumeName}}</td><td>{{.Destination}}</td><td>{{.BackupSets}} ({{.FullBackups}} full)</td>
+<td>{{date .LastBackup}}</td><td>{{date .LastFull}}</td><td>{{bytes .TotalBytes}}</td>
+<td>{{if .Healthy}}<span class="ok">healthy</span>{{else}}<span class="bad">broken at {{range .BrokenChains}}{{.}} {{end}}</span>{{end}}</td>
+</tr>
+{{end}}
+</table>
+
+<h2>Backup History</h2>
+{{range .Datasets}}
+<details>
+<summary>{{.VolumeName}} in {{.Destination}}</summary>
+<table>
+<tr><th>Snapshot</th><th>Created</th><th>Incremental From</th><th>Volumes</th><th>Size</th></tr>
+{{range .History}}
+<tr><td>{{.Snapshot}}</td><td>{{date .CreationTime}}</td><td>{{if .Incremental}}{{.Incremental}}{{else}}full{{end}}</td><td>{{.Volumes}}</td><td>{{bytes .Bytes}}</td></tr>
+{{end}}
+</table>
+</details>
+{{end}}
+
+<h2>Destinations</h2>
+<table>
+<tr><th>Destination</th><th>Backup Sets</th><th>Storage Used</th><th>Status</th></tr>
+{{range .Destinations}}
+<tr><td>{{.Destination}}</td><td>{{.BackupSets}}</td><td>{{bytes .TotalBytes}}</td><td>{{if .Error}}<span class="bad">{{.Error}}</span>{{else}}<span class="ok">ok</span>{{end}}</td></tr>
+{{end}}
+</table>
+
+<h2>Recent Jobs</h2>
+<table>
+<tr><th>Finished</th><th>Operation</th><th>Snapshot</th><th>Size</th><th>Duration</th><th>Status</th></tr>
+{{range .RecentJobs}}
+<tr><td>{{date .EndTime}}</td><td>{{.Operation}}</td><td>{{.VolumeName}}@{{.Snapshot}}</td><td>{{bytes .Bytes}}</td><td>{{.EndTime.Sub .StartTime}}</td><td>{{if .Failed}}<span class="bad">failed</span>{{else}}<span class="ok">ok</span>{{end}}</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`
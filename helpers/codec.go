@@ -0,0 +1,202 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	gzip "github.com/klauspost/pgzip"
+)
+
+// CodecLevel is replaced by the compression level in the commands of an ExecCodec.
+const CodecLevel = "{level}"
+
+// CodecOptions are the settings a volume is compressed with.
+type CodecOptions struct {
+	Level     int // Compression level, from 1 to 9
+	BlockSize int // Bytes compressed at a time by the codecs compressing blocks in parallel
+}
+
+// Codec compresses and decompresses the volumes of the backup sets sent with the compressor it is
+// registered as, the name of which is recorded in their manifests so they are decompressed with it
+// on restore.
+type Codec interface {
+	// Compress returns a writer compressing what is written to it to w. Closing it must flush
+	// everything to w, but not close w.
+	Compress(ctx context.Context, w io.Writer, opts CodecOptions) (io.WriteCloser, error)
+	// Decompress returns a reader of what r decompresses to. Closing it must release what it holds,
+	// but not close r.
+	Decompress(ctx context.Context, r io.Reader) (io.ReadCloser, error)
+	// Extension is added to the names of the volumes compressed with the codec.
+	Extension() string
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{InternalCompressor: gzipCodec{}}
+
+	codecNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_+-]*$`)
+)
+
+// RegisterCodec will have the compressor name use the codec, replacing any codec registered as name
+// before. The internal compressor can be replaced, but not the zfs compressor or no compression.
+func RegisterCodec(name string, codec Codec) error {
+	if !codecNameRegex.MatchString(name) || name == ZfsCompressor {
+		return fmt.Errorf("invalid codec name %q, it must start with a letter or digit and only contain letters, digits, _, +, or -, and can not be %s", name, ZfsCompressor)
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = codec
+	return nil
+}
+
+// LookupCodec will return the codec registered as the compressor name, or nil if there is none.
+func LookupCodec(name string) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecs[name]
+}
+
+// gzipCodec is the internal compressor, compressing blocks in parallel.
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(_ context.Context, w io.Writer, opts CodecOptions) (io.WriteCloser, error) {
+	return newGzipWriter(w, opts.Level, opts.BlockSize)
+}
+
+func (gzipCodec) Decompress(_ context.Context, r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipCodec) Extension() string {
+	return "gz"
+}
+
+// ExecCodec is a Codec running external binaries, streaming the volumes through their stdin and stdout.
+type ExecCodec struct {
+	CompressCommand   []string // The command compressing its stdin to its stdout, CodecLevel is replaced in its arguments
+	DecompressCommand []string // The command decompressing its stdin to its stdout
+	Ext               string   // The extension of the volumes
+}
+
+// ParseExecCodec will parse a codec definition of the form name=<compress command>;<decompress command>,
+// e.g. zstd=zstd -c -{level};zstd -d -c, returning its name and codec. The arguments of the commands are
+// split on whitespace and the extension of the volumes is the name of the codec.
+func ParseExecCodec(definition string) (string, *ExecCodec, error) {
+	parts := strings.SplitN(definition, "=", 2)
+	if len(parts) != 2 {
+		return "", nil, fmt.Errorf("invalid codec %q, expected name=<compress command>;<decompress command>", definition)
+	}
+	name := strings.TrimSpace(parts[0])
+	commands := strings.Split(parts[1], ";")
+	if len(commands) != 2 {
+		return "", nil, fmt.Errorf("invalid codec %q, expected a compress and a decompress command separated by ;", definition)
+	}
+	codec := &ExecCodec{
+		CompressCommand:   strings.Fields(commands[0]),
+		DecompressCommand: strings.Fields(commands[1]),
+		Ext:               name,
+	}
+	if len(codec.CompressCommand) == 0 || len(codec.DecompressCommand) == 0 {
+		return "", nil, fmt.Errorf("invalid codec %q, both the compress and decompress commands are required", definition)
+	}
+	if !codecNameRegex.MatchString(name) || name == ZfsCompressor || name == InternalCompressor {
+		return "", nil, fmt.Errorf("invalid codec name %q, it must start with a letter or digit and only contain letters, digits, _, +, or -, and can not be %s or %s", name, ZfsCompressor, InternalCompressor)
+	}
+	return name, codec, nil
+}
+
+// Compress will start the compress command writing to w.
+func (e *ExecCodec) Compress(ctx context.Context, w io.Writer, opts CodecOptions) (io.WriteCloser, error) {
+	args := make([]string, len(e.CompressCommand)-1)
+	for idx, arg := range e.CompressCommand[1:] {
+		args[idx] = strings.Replace(arg, CodecLevel, strconv.Itoa(opts.Level), -1)
+	}
+	path, args := prioritized(e.CompressCommand[0], args...)
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execWriter{WriteCloser: stdin, cmd: cmd}, nil
+}
+
+// Decompress will start the decompress command reading from r.
+func (e *ExecCodec) Decompress(ctx context.Context, r io.Reader) (io.ReadCloser, error) {
+	path, args := prioritized(e.DecompressCommand[0], e.DecompressCommand[1:]...)
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execReader{ReadCloser: stdout, cmd: cmd}, nil
+}
+
+// Extension will return the extension of the volumes.
+func (e *ExecCodec) Extension() string {
+	return e.Ext
+}
+
+// execWriter feeds the compress command, waiting for it to finish once closed.
+type execWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+func (e *execWriter) Close() error {
+	cerr := e.WriteCloser.Close()
+	if err := e.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed - %v", e.cmd.Path, err)
+	}
+	return cerr
+}
+
+// execReader reads the output of the decompress command, waiting for it to finish once closed.
+type execReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (e *execReader) Close() error {
+	cerr := e.ReadCloser.Close()
+	if err := e.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed - %v", e.cmd.Path, err)
+	}
+	return cerr
+}
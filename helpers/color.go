@@ -0,0 +1,109 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Color will signal if the human readable output written to Stdout should be colorized
+var Color bool = false
+
+// The SGR parameters of the colors output can be highlighted with.
+const (
+	ColorBold   = "1"
+	ColorRed    = "31"
+	ColorGreen  = "32"
+	ColorYellow = "33"
+)
+
+var escapeSequence = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// Colorize returns s wrapped in the escape sequences to display it in the color provided, or s as is if
+// Color is false.
+func Colorize(color, s string) string {
+	if !Color || s == "" {
+		return s
+	}
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, s)
+}
+
+// visibleWidth returns the number of characters s takes on a terminal, without its escape sequences.
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(escapeSequence.ReplaceAllString(s, ""))
+}
+
+// Table aligns rows of cells in columns like text/tabwriter does, but ignoring the escape sequences
+// Colorize adds to a cell when computing the width of its column. The header is shown in bold.
+type Table struct {
+	rows [][]string
+}
+
+// NewTable returns a Table with the header provided as its first row.
+func NewTable(header ...string) *Table {
+	cells := make([]string, len(header))
+	for idx := range header {
+		cells[idx] = Colorize(ColorBold, header[idx])
+	}
+	return &Table{rows: [][]string{cells}}
+}
+
+// Row adds a row of cells to the table.
+func (t *Table) Row(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// WriteTo will write the table to w, separating columns by two spaces.
+func (t *Table) WriteTo(w io.Writer) (int64, error) {
+	var widths []int
+	for _, row := range t.rows {
+		for idx, cell := range row {
+			if idx == len(widths) {
+				widths = append(widths, 0)
+			}
+			if width := visibleWidth(cell); width > widths[idx] {
+				widths[idx] = width
+			}
+		}
+	}
+
+	var total int64
+	for _, row := range t.rows {
+		var line strings.Builder
+		for idx, cell := range row {
+			line.WriteString(cell)
+			if idx < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[idx]-visibleWidth(cell)+2))
+			}
+		}
+		line.WriteString("\n")
+		n, err := io.WriteString(w, line.String())
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
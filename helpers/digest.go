@@ -0,0 +1,100 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/zeebo/xxh3"
+	"lukechampine.com/blake3"
+)
+
+// The algorithms the digest of a volume, recorded in its manifest and checked when it is downloaded, can be
+// computed with.
+const (
+	DigestSHA256 = "sha256"
+	DigestBLAKE3 = "blake3"
+	// DigestXXH3 is the fastest but is not a cryptographic hash, it detects corruption but not tampering
+	DigestXXH3 = "xxh3"
+)
+
+// DigestAlgorithms are the algorithms the digest of a volume can be computed with.
+var DigestAlgorithms = []string{DigestSHA256, DigestBLAKE3, DigestXXH3}
+
+// ValidateDigestAlgorithm will return an error if the digest algorithm provided is not supported.
+func ValidateDigestAlgorithm(algorithm string) error {
+	_, err := newDigest(algorithm)
+	return err
+}
+
+func newDigest(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "", DigestSHA256:
+		return sha256.New(), nil
+	case DigestBLAKE3:
+		return blake3.New(32, nil), nil
+	case DigestXXH3:
+		return xxh3.New(), nil
+	default:
+		return nil, fmt.Errorf("The digest algorithm provided (%s) is not one of %s", algorithm, strings.Join(DigestAlgorithms, ", "))
+	}
+}
+
+// ChecksumAlgorithm returns the algorithm the digest of the volume was computed with, volumes recorded before
+// it could be chosen have a SHA256 digest.
+func (v *VolumeInfo) ChecksumAlgorithm() string {
+	if v.DigestAlgorithm == "" {
+		return DigestSHA256
+	}
+	return v.DigestAlgorithm
+}
+
+// Checksum returns the digest of the volume, computed with its ChecksumAlgorithm.
+func (v *VolumeInfo) Checksum() string {
+	if v.ChecksumAlgorithm() == DigestSHA256 {
+		return v.SHA256Sum
+	}
+	return v.DigestSum
+}
+
+// VerifyDigests will return an ErrorKindVerification error unless the digests of the volume, once closed, match
+// every digest recorded for it: its SHA256 digest and the digest of its DigestAlgorithm, if another one.
+func (v *VolumeInfo) VerifyDigests(recorded *VolumeInfo) error {
+	if recorded.SHA256Sum == "" && recorded.DigestSum == "" {
+		return NewError(ErrorKindVerification, fmt.Errorf("no digest is recorded for %s", recorded.ObjectName))
+	}
+	if recorded.SHA256Sum != "" && v.SHA256Sum != recorded.SHA256Sum {
+		return NewError(ErrorKindVerification, fmt.Errorf("SHA256 hash mismatch for %s, got %s but expected %s", recorded.ObjectName, v.SHA256Sum, recorded.SHA256Sum))
+	}
+	if recorded.DigestSum != "" && (v.DigestAlgorithm != recorded.DigestAlgorithm || v.DigestSum != recorded.DigestSum) {
+		return NewError(ErrorKindVerification, fmt.Errorf("%s hash mismatch for %s, got %s but expected %s", strings.ToUpper(recorded.DigestAlgorithm), recorded.ObjectName, v.DigestSum, recorded.DigestSum))
+	}
+	return nil
+}
+
+// ExpectDigests will make the reader of a piped volume fail, instead of reaching the end of the volume, if the
+// volume written to it does not match the digests recorded for it. Must be called before the volume is closed.
+func (v *VolumeInfo) ExpectDigests(recorded *VolumeInfo) {
+	v.expected = recorded
+}
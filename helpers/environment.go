@@ -0,0 +1,116 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/juju/ratelimit"
+	"github.com/op/go-logging"
+	"golang.org/x/crypto/openpgp"
+)
+
+// Environment is what the jobs of a process share unless told otherwise: the directories they work in, the
+// host their zfs commands run on, the keyrings they read, the limits and pauses of their transfers, and where
+// they log and output their results. A context may carry an Environment of its own so that jobs with different
+// ones can run at the same time, e.g. the calls of the Clients of the zfsbackup package.
+type Environment struct {
+	WorkingDir      string             // Directory the manifest cache, staged volumes, and state of the jobs are kept in
+	TempDir         string             // Directory the temporary files are created in
+	ZFSPath         string             // Path of the zfs executable
+	SSHHost         string             // The [user@]host the zfs commands are run on over ssh, they are run locally if empty
+	SSHPort         int                // Port of SSHHost, the ssh default if 0
+	SSHIdentityFile string             // Private key to authenticate to SSHHost with, the ssh default if empty
+	PublicKeyRing   openpgp.EntityList // Keys the backup sets may be encrypted to or signed from
+	SecretKeyRing   openpgp.EntityList // Keys the backup sets may be decrypted with, their private keys already decrypted
+	UploadBucket    *ratelimit.Bucket  // The rate-limit bucket every upload draws from, there is no limit if nil
+	UploadSlots     Semaphore          // Caps how many volumes are uploaded at the same time, there is no cap if nil
+	Transfers       *Pauser            // Pauses the transfers to and from the backends
+	Logger          *logging.Logger    // Where the logs go
+	Stdout          io.Writer          // Where the results are output
+	JSONOutput      bool               // Whether the results are output JSON formatted
+}
+
+type environmentKey struct{}
+
+// WithEnvironment will return a copy of ctx carrying env, used in place of the package level variables by
+// everything run with the context. A nil Transfers, Logger, or Stdout is one never paused or discarding its output.
+func WithEnvironment(ctx context.Context, env Environment) context.Context {
+	if env.Transfers == nil {
+		env.Transfers = new(Pauser)
+	}
+	if env.Logger == nil {
+		env.Logger = &logging.Logger{Module: LogModuleName}
+		env.Logger.SetBackend(logging.AddModuleLevel(logging.NewLogBackend(ioutil.Discard, "", 0)))
+	}
+	if env.Stdout == nil {
+		env.Stdout = ioutil.Discard
+	}
+	return context.WithValue(ctx, environmentKey{}, &env)
+}
+
+// EnvironmentOf will return the Environment carried by ctx, or the one of the package level variables
+// (WorkingDir, BackupTempdir, ZFSPath, SSHHost, the keyrings loaded, AppLogger, JSONOutput, etc.) if it carries
+// none.
+func EnvironmentOf(ctx context.Context) *Environment {
+	if ctx != nil {
+		if env, ok := ctx.Value(environmentKey{}).(*Environment); ok {
+			return env
+		}
+	}
+	return &Environment{
+		WorkingDir:      WorkingDir,
+		TempDir:         BackupTempdir,
+		ZFSPath:         ZFSPath,
+		SSHHost:         SSHHost,
+		SSHPort:         SSHPort,
+		SSHIdentityFile: SSHIdentityFile,
+		PublicKeyRing:   pubRing,
+		SecretKeyRing:   secRing,
+		UploadBucket:    BackupUploadBucket,
+		UploadSlots:     UploadSlots,
+		Transfers:       Transfers,
+		Logger:          AppLogger,
+		Stdout:          Stdout,
+		JSONOutput:      JSONOutput,
+	}
+}
+
+// DetachedContext will return a context that is never canceled carrying the Environment of ctx, e.g. to clean
+// up once ctx is canceled.
+func DetachedContext(ctx context.Context) context.Context {
+	return context.WithValue(context.Background(), environmentKey{}, EnvironmentOf(ctx))
+}
+
+// keyRing will return both the public and secret key rings of the Environment combined.
+func (e *Environment) keyRing() openpgp.KeyRing {
+	return append(append(openpgp.EntityList{}, e.PublicKeyRing...), e.SecretKeyRing...)
+}
+
+// throttled will return r rate limited by the UploadBucket and blocked while Transfers is paused.
+func (e *Environment) throttled(r io.Reader) io.Reader {
+	if e.UploadBucket != nil {
+		r = ratelimit.Reader(r, e.UploadBucket)
+	}
+	return e.Transfers.Reader(r)
+}
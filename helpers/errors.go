@@ -0,0 +1,91 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"errors"
+)
+
+// ErrorKind classifies the cause of a failed operation so it can be told apart, e.g. by the exit code
+// of the process, without parsing the error message.
+type ErrorKind int
+
+// The kinds of errors an operation may fail with. ErrorKindPartial is used when the operation itself
+// succeeded but something done after it, e.g. a post hook, failed.
+const (
+	ErrorKindUnknown ErrorKind = iota
+	ErrorKindConfig
+	ErrorKindZFS
+	ErrorKindBackend
+	ErrorKindVerification
+	ErrorKindPartial
+)
+
+var errorKindNames = map[ErrorKind]string{
+	ErrorKindUnknown:      "unknown",
+	ErrorKindConfig:       "config",
+	ErrorKindZFS:          "zfs",
+	ErrorKindBackend:      "backend",
+	ErrorKindVerification: "verification",
+	ErrorKindPartial:      "partial",
+}
+
+// String will return a short, machine friendly name for the error kind.
+func (k ErrorKind) String() string {
+	if name, ok := errorKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Error is an error of a known ErrorKind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+// Error will return the message of the underlying error.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap will return the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError will classify err as an error of the kind provided. Errors already classified keep their kind
+// so the cause closest to the failure wins, and a nil error stays nil.
+func NewError(kind ErrorKind, err error) error {
+	if err == nil || KindOf(err) != ErrorKindUnknown {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// KindOf will return the kind of the error provided, ErrorKindUnknown if it was never classified.
+func KindOf(err error) ErrorKind {
+	var kerr *Error
+	if errors.As(err, &kerr) {
+		return kerr.Kind
+	}
+	return ErrorKindUnknown
+}
@@ -0,0 +1,73 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HookShell is the shell used to run hook commands.
+var HookShell = "/bin/sh"
+
+// RunHook will run the command with the HookShell, adding env to the environment, and
+// kill it if it has not completed within the timeout (0 for no limit). The output of
+// the command is logged.
+func RunHook(ctx context.Context, command string, timeout time.Duration, env map[string]string) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, HookShell, "-c", command)
+	cmd.Env = os.Environ()
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, env[key]))
+	}
+
+	output := new(bytes.Buffer)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	EnvironmentOf(ctx).Logger.Infof("Running hook \"%s\"", command)
+	err := cmd.Run()
+	if out := strings.TrimSpace(output.String()); out != "" {
+		EnvironmentOf(ctx).Logger.Infof("Output of hook \"%s\":\n%s", command, out)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("hook \"%s\" did not complete within %v", command, timeout)
+	} else if err != nil {
+		return fmt.Errorf("hook \"%s\" failed - %v", command, err)
+	}
+	return nil
+}
@@ -21,8 +21,11 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,6 +33,23 @@ import (
 	"golang.org/x/crypto/openpgp"
 )
 
+const (
+	// KeepTag is the tag key marking how long a backup set must be kept
+	KeepTag = "keep"
+	// KeepForeverValue is the KeepTag value protecting a backup set from ever being deleted
+	KeepForeverValue = "forever"
+	// DefaultManifestWorkers is the number of manifests downloaded and read at once
+	DefaultManifestWorkers = 8
+	// DefaultParityGroupSize is the number of volumes a parity group holds unless told otherwise
+	DefaultParityGroupSize = 16
+	// MaxParityShards is the highest number of volumes, data and parity, a parity group may hold
+	MaxParityShards = 256
+	// ChunkingCDC splits the zfs send stream into chunks at boundaries found in its content
+	ChunkingCDC = "cdc"
+	// DefaultChunkSize is the average size, in KiB, of the chunks of the zfs send stream unless told otherwise
+	DefaultChunkSize = 1024
+)
+
 var (
 	disallowedSeps = regexp.MustCompile(`^[\w\-:\.]+`) // Disallowed by ZFS
 )
@@ -44,43 +64,159 @@ type JobInfo struct {
 	IncrementalSnapshot     SnapshotInfo
 	Compressor              string
 	CompressionLevel        int
+	DigestAlgorithm         string `json:",omitempty"` // Algorithm the digests of the volumes are computed with, SHA256 if empty
 	Separator               string
 	ZFSCommandLine          string
 	ZFSStreamBytes          uint64
 	Volumes                 []*VolumeInfo
 	Version                 float64
+	SchemaVersion           int `json:",omitempty"` // ManifestSchemaVersion of the manifest, missing from the manifests of schema 1
 	EncryptTo               string
 	SignFrom                string
 	Replication             bool
 	Deduplication           bool
 	Properties              bool
 	IntermediaryIncremental bool
-	Resume                  bool `json:"-"`
+	Tags                    map[string]string        `json:",omitempty"`
+	MinRetention            map[string]time.Duration `json:",omitempty"` // How long after it started the backup set must be kept, by backend prefix or "" for any
+	ContentAddressed        bool                     `json:",omitempty"` // Volumes are named by their SHA256 digest and shared with other backup sets
+	ParityVolumes           int                      `json:",omitempty"` // Reed-Solomon parity volumes computed for every ParityGroupSize volumes
+	ParityGroupSize         int                      `json:",omitempty"` // Volumes in a parity group, the last group of a backup set may hold fewer
+	Parity                  []*VolumeInfo            `json:",omitempty"` // The parity volumes, numbered from 1 in the order of their group
+	Chunking                string                   `json:",omitempty"` // ChunkingCDC when the stream is stored as chunks and layout volumes
+	ChunkSize               int                      `json:",omitempty"` // Average size, in KiB, of the chunks of the stream
+	Stats                   *BackupStats             `json:",omitempty"` // How the backup set was sent, recorded once it is complete
+	External                string                   `json:",omitempty"` // Replication tool holding the snapshot of a backup set without volumes, see backup.Adopt
+	Resume                  bool                     `json:"-"`
+	SendAgain               bool                     `json:"-"` // Send the backup set even if every destination already holds it
+	AlreadyBackedUp         *JobInfo                 `json:"-"` // Manifest of the backup set every destination already held, set when nothing was sent
 	// "Smart" Options
 	Full            bool          `json:"-"`
 	Incremental     bool          `json:"-"`
 	FullIfOlderThan time.Duration `json:"-"`
+	MaxChainLength  int           `json:"-"`
 
 	// ZFS Receive options
-	Force       bool   `json:"-"`
-	FullPath    bool   `json:"-"`
-	LastPath    bool   `json:"-"`
-	NotMounted  bool   `json:"-"`
-	Origin      string `json:"-"`
-	LocalVolume string `json:"-"`
-	AutoRestore bool   `json:"-"`
+	Force       bool      `json:"-"`
+	FullPath    bool      `json:"-"`
+	LastPath    bool      `json:"-"`
+	NotMounted  bool      `json:"-"`
+	Origin      string    `json:"-"`
+	LocalVolume string    `json:"-"`
+	AutoRestore bool      `json:"-"`
+	ToFile      string    `json:"-"`
+	RestoreAt   time.Time `json:"-"` // Restore the latest snapshot taken at or before this time when no snapshot is provided
+	VerifyFirst bool      `json:"-"` // Download and verify every volume before restoring any of them
 
 	Destinations       []string        `json:"-"`
 	VolumeSize         uint64          `json:"-"`
+	VolumeSizeOutput   bool            `json:"-"` // VolumeSize bounds the compressed and encrypted size of the volumes rather than being a target
 	ManifestPrefix     string          `json:"-"`
+	ManifestFormat     string          `json:"-"` // Format the manifest was read in, or is written in, ManifestFormatNative if empty
+	ManifestWorkers    int             `json:"-"` // Manifests downloaded and read at once, DefaultManifestWorkers if 0
 	MaxBackoffTime     time.Duration   `json:"-"`
 	MaxRetryTime       time.Duration   `json:"-"`
 	MaxParallelUploads int             `json:"-"`
+	AutoTuneUploads    bool            `json:"-"` // Adjust the number of parallel uploads while a send runs, starting from MaxParallelUploads
+	AutoTuneMaxUploads int             `json:"-"` // Highest number of parallel uploads AutoTuneUploads may reach
 	MaxFileBuffer      int             `json:"-"`
 	EncryptKey         *openpgp.Entity `json:"-"`
 	SignKey            *openpgp.Entity `json:"-"`
 	ParentSnap         *JobInfo        `json:"-"`
 	UploadChunkSize    int             `json:"-"`
+	SendReadSize       int             `json:"-"` // KiB read from the zfs send stream at a time, volumes are split between reads. DefaultSendReadSize if 0
+	CompressBlockSize  int             `json:"-"` // KiB blocks the internal compressor compresses in parallel, DefaultCompressorBlockSize if 0
+	UploadReadSize     int             `json:"-"` // KiB read at a time by the uploaders streaming volumes, DefaultUploadReadSize if 0
+	Progress           ProgressFunc    `json:"-"`
+	ManifestPath       string          `json:"-"` // Local cache path of the final manifest written by a send
+	StagingDir         string          `json:"-"` // Directory the volumes of a send are written to, the temporary directory if empty
+	LeaseTTL           time.Duration   `json:"-"` // How long the lease of a send at its destinations lasts without being renewed, none is taken if 0
+	StealLease         bool            `json:"-"` // Take over a lease held by another send even if it is not expired
+	ForceBreakChain    bool            `json:"-"` // Delete backup sets even if retained restore points depend on them
+	SendVolume         string          `json:"-"` // Local volume the zfs send stream is taken from, the VolumeName if empty
+	DiscoverManifests  bool            `json:"-"` // Find the manifests by their extension instead of the manifest prefix and separator
+	Inventory          string          `json:"-"` // Manifest of the S3 Inventory or GCS Storage Insights report clean reads instead of listing the destination
+	TrashFor           time.Duration   `json:"-"` // How long the objects clean deletes are kept in the trash of the destination, deleted right away if 0
+	Manifests          []string        `json:"-"` // Object names of the manifests a job wrote, restored, verified, or deleted, for the audit log
+
+	// Labels of the objects uploaded
+	JobName        string            `json:"-"` // Name of the job run by the run command, empty otherwise
+	ObjectTags     map[string]string `json:"-"` // Key=template pairs rendered with the ObjectLabelFields and set as tags of the objects uploaded
+	ObjectMetadata map[string]string `json:"-"` // Key=template pairs rendered with the ObjectLabelFields and set as metadata of the objects uploaded
+}
+
+// SourceVolume will return the local volume the zfs send stream of this JobInfo is taken from.
+func (j *JobInfo) SourceVolume() string {
+	if j.SendVolume != "" {
+		return j.SendVolume
+	}
+	return j.VolumeName
+}
+
+// UploadWorkers will return the number of uploads of this JobInfo that may run at once, the highest
+// number AutoTuneUploads may reach when the uploads are tuned.
+func (j *JobInfo) UploadWorkers() int {
+	if j.AutoTuneUploads && j.AutoTuneMaxUploads > j.MaxParallelUploads {
+		return j.AutoTuneMaxUploads
+	}
+	return j.MaxParallelUploads
+}
+
+// KeepForever reports whether the backup set is tagged keep=forever and must never be deleted.
+func (j *JobInfo) KeepForever() bool {
+	return j.Tags[KeepTag] == KeepForeverValue
+}
+
+// RetainedUntil returns the time until which clean must not delete the backup set from the destination
+// provided, zero if it has no minimum retention there.
+func (j *JobInfo) RetainedUntil(destination string) time.Time {
+	retention, ok := j.MinRetention[strings.ToLower(strings.SplitN(destination, "://", 2)[0])]
+	if !ok {
+		retention = j.MinRetention[""]
+	}
+	if retention <= 0 {
+		return time.Time{}
+	}
+	return j.StartTime.Add(retention)
+}
+
+// ParseMinRetention will parse a minimum retention for every destination (e.g. 30d) or a comma separated
+// list of them by backend prefix (e.g. s3=90d,gs=30d). Durations are Go durations or a number of days
+// (d) or weeks (w).
+func ParseMinRetention(value string) (map[string]time.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+	retention := make(map[string]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		prefix, duration := "", pair
+		if parts := strings.SplitN(pair, "=", 2); len(parts) == 2 {
+			prefix, duration = strings.ToLower(strings.TrimSpace(parts[0])), parts[1]
+			if prefix == "" {
+				return nil, fmt.Errorf("invalid minimum retention %s, expected the format backend=duration", pair)
+			}
+		}
+		d, err := ParseDays(strings.TrimSpace(duration))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid minimum retention %s, expected a positive duration such as 30d or 12h", pair)
+		}
+		retention[prefix] = d
+	}
+	return retention, nil
+}
+
+// ParseDays will parse a Go duration or a whole number of days (e.g. 30d) or weeks (e.g. 4w).
+func ParseDays(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(value, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(value)
 }
 
 // SnapshotInfo represents a snapshot with relevant information.
@@ -109,6 +245,42 @@ func (j *JobInfo) TotalBytesWritten() uint64 {
 	return total
 }
 
+// BackupStats summarizes how a backup set was sent.
+type BackupStats struct {
+	StreamBytes      uint64        // Bytes of the zfs send stream
+	StoredBytes      uint64        // Bytes of the volumes as stored, compressed and encrypted
+	CompressionRatio float64       // StreamBytes over StoredBytes
+	WallTime         time.Duration // From the start of the backup to its manifest
+	Throughput       uint64        // Bytes of the zfs send stream sent per second of WallTime
+	UploadTime       time.Duration // Time spent uploading the volumes, added up over every volume and destination
+	Retries          int           // Uploads of the volumes that failed and were retried
+}
+
+// BackupStats returns the statistics of the backup set, those recorded when it was sent or, for a backup
+// set sent before they were, the ones that can be computed from its manifest.
+func (j *JobInfo) BackupStats() BackupStats {
+	if j.Stats != nil {
+		return *j.Stats
+	}
+
+	stats := BackupStats{
+		StreamBytes: j.ZFSStreamBytes,
+		StoredBytes: j.TotalBytesWritten(),
+		WallTime:    j.EndTime.Sub(j.StartTime),
+	}
+	if stats.StoredBytes > 0 {
+		stats.CompressionRatio = float64(stats.StreamBytes) / float64(stats.StoredBytes)
+	}
+	if stats.WallTime > 0 {
+		stats.Throughput = uint64(float64(stats.StreamBytes) / stats.WallTime.Seconds())
+	}
+	for _, vol := range append(append([]*VolumeInfo{}, j.Volumes...), j.Parity...) {
+		stats.UploadTime += vol.UploadTime
+		stats.Retries += vol.Retries
+	}
+	return stats
+}
+
 // String will return a string representation of this JobInfo.
 func (j *JobInfo) String() string {
 	var output []string
@@ -119,9 +291,31 @@ func (j *JobInfo) String() string {
 		output = append(output, fmt.Sprintf("Intermediary: %v", j.IntermediaryIncremental))
 	}
 	output = append(output, fmt.Sprintf("Replication: %v", j.Replication))
+	if len(j.Tags) > 0 {
+		tags := make([]string, 0, len(j.Tags))
+		for key, value := range j.Tags {
+			tags = append(tags, fmt.Sprintf("%s=%s", key, value))
+		}
+		sort.Strings(tags)
+		output = append(output, fmt.Sprintf("Tags: %s", strings.Join(tags, ", ")))
+	}
+	if len(j.MinRetention) > 0 {
+		retention := make([]string, 0, len(j.MinRetention))
+		for prefix, duration := range j.MinRetention {
+			if prefix == "" {
+				prefix = "any"
+			}
+			retention = append(retention, fmt.Sprintf("%s=%v", prefix, duration))
+		}
+		sort.Strings(retention)
+		output = append(output, fmt.Sprintf("Minimum Retention: %s", strings.Join(retention, ", ")))
+	}
 	totalWrittenBytes := j.TotalBytesWritten()
 	output = append(output, fmt.Sprintf("Archives: %d - %d bytes (%s)", len(j.Volumes), totalWrittenBytes, humanize.IBytes(totalWrittenBytes)))
 	output = append(output, fmt.Sprintf("Volume Size (Raw): %d bytes (%s)", j.ZFSStreamBytes, humanize.IBytes(j.ZFSStreamBytes)))
+	stats := j.BackupStats()
+	output = append(output, fmt.Sprintf("Compression Ratio: %.2fx", stats.CompressionRatio))
+	output = append(output, fmt.Sprintf("Throughput: %s/s (uploads took %v, %d retries)", humanize.IBytes(stats.Throughput), stats.UploadTime.Round(time.Millisecond), stats.Retries))
 	output = append(output, fmt.Sprintf("Uploaded: %v (took %v)\n\n", j.StartTime, j.EndTime.Sub(j.StartTime)))
 	return strings.Join(output, "\n\t")
 }
@@ -145,7 +339,7 @@ func (j *JobInfo) TotalBytesStreamedAndVols() (total uint64, volnum int64) {
 
 // ValidateSendFlags will check if the options assigned to this JobInfo object is
 // properly within the bounds for a send backup operation.
-func (j *JobInfo) ValidateSendFlags() error {
+func (j *JobInfo) ValidateSendFlags(ctx context.Context) error {
 	if j.MaxFileBuffer < 0 {
 		return fmt.Errorf("The number of active files must be set to a value greater than or equal to 0. Was given %d", j.MaxFileBuffer)
 	}
@@ -154,8 +348,12 @@ func (j *JobInfo) ValidateSendFlags() error {
 		return fmt.Errorf("The number of parallel uploads must be set to a value greater than 0. Was given %d", j.MaxParallelUploads)
 	}
 
+	if j.AutoTuneUploads && j.AutoTuneMaxUploads < j.MaxParallelUploads {
+		return fmt.Errorf("The highest number of parallel uploads to tune up to must be at least the number of parallel uploads to start with (%d). Was given %d", j.MaxParallelUploads, j.AutoTuneMaxUploads)
+	}
+
 	if j.MaxFileBuffer < j.MaxParallelUploads {
-		AppLogger.Warningf("The number of parallel uploads (%d) is greater than the number of active files allowed (%d), this may result in an unachievable max parallel upload target.", j.MaxParallelUploads, j.MaxFileBuffer)
+		EnvironmentOf(ctx).Logger.Warningf("The number of parallel uploads (%d) is greater than the number of active files allowed (%d), this may result in an unachievable max parallel upload target.", j.MaxParallelUploads, j.MaxFileBuffer)
 	}
 
 	if j.MaxRetryTime < 0 {
@@ -178,5 +376,75 @@ func (j *JobInfo) ValidateSendFlags() error {
 		return fmt.Errorf("The uploadChunkSize provided (%d) is not between 5 and 100", j.UploadChunkSize)
 	}
 
+	if j.SendReadSize != 0 && (j.SendReadSize < 4 || j.SendReadSize > 65536) {
+		return fmt.Errorf("The sendReadSize provided (%d) is not between 4 and 65536", j.SendReadSize)
+	}
+
+	if j.CompressBlockSize != 0 && (j.CompressBlockSize < 32 || j.CompressBlockSize > 65536) {
+		return fmt.Errorf("The compressorBlockSize provided (%d) is not between 32 and 65536", j.CompressBlockSize)
+	}
+
+	if j.UploadReadSize != 0 && (j.UploadReadSize < 4 || j.UploadReadSize > 65536) {
+		return fmt.Errorf("The uploadReadSize provided (%d) is not between 4 and 65536", j.UploadReadSize)
+	}
+
+	if err := ValidateDigestAlgorithm(j.DigestAlgorithm); err != nil {
+		return err
+	}
+
+	if j.ParityVolumes < 0 {
+		return fmt.Errorf("The number of parity volumes must be set to a value greater than or equal to 0. Was given %d", j.ParityVolumes)
+	}
+
+	if j.ParityVolumes > 0 {
+		if j.ParityGroupSize < 1 || j.ParityGroupSize+j.ParityVolumes > MaxParityShards {
+			return fmt.Errorf("The parityGroupSize provided (%d) must be at least 1 and, with the %d parity volumes, at most %d", j.ParityGroupSize, j.ParityVolumes, MaxParityShards)
+		}
+		if j.MaxFileBuffer == 0 {
+			return fmt.Errorf("The parityVolumes flag requires volumes to be staged before they are uploaded, the maxFileBuffer must be greater than 0")
+		}
+	}
+
+	if j.ContentAddressed && j.MaxFileBuffer == 0 {
+		return fmt.Errorf("The contentAddressed flag requires volumes to be staged before they are uploaded, the maxFileBuffer must be greater than 0")
+	}
+
+	if j.VolumeSizeOutput && (j.ContentAddressed || j.Chunking != "") {
+		return fmt.Errorf("The volsizeOutput flag can not be used with the contentAddressed or chunking flags, their volumes hold a set amount of the zfs send stream")
+	}
+
+	if j.VolumeSizeOutput && j.VolumeSize == 0 {
+		return fmt.Errorf("The volsizeOutput flag requires a volsize of at least 1 MiB")
+	}
+
+	switch j.Chunking {
+	case "":
+	case ChunkingCDC:
+		if j.MaxFileBuffer == 0 {
+			return fmt.Errorf("The chunking flag requires chunks to be staged before they are uploaded, the maxFileBuffer must be greater than 0")
+		}
+		if j.ChunkSize < 64 || j.ChunkSize > 16384 || j.ChunkSize&(j.ChunkSize-1) != 0 {
+			return fmt.Errorf("The chunkSize must be a power of two between 64 and 16384 KiB. Was given %d", j.ChunkSize)
+		}
+	default:
+		return fmt.Errorf("The chunking provided (%s) is not supported, use %s or leave it empty", j.Chunking, ChunkingCDC)
+	}
+
+	if j.MaxChainLength < 0 {
+		return fmt.Errorf("The max chain length must be set to a value greater than or equal to 0. Was given %d", j.MaxChainLength)
+	}
+
+	if j.MaxChainLength > 0 && !j.Incremental && j.FullIfOlderThan == -1*time.Minute {
+		return fmt.Errorf("The maxChainLength flag requires the increment or fullIfOlderThan smart option")
+	}
+
+	if j.LeaseTTL != 0 && j.LeaseTTL < 10*time.Second {
+		return fmt.Errorf("The lease TTL must be 0 (no lease) or at least 10s. Was given %v", j.LeaseTTL)
+	}
+
+	if j.StealLease && j.LeaseTTL == 0 {
+		return fmt.Errorf("The stealLease flag requires a leaseTTL")
+	}
+
 	return nil
 }
@@ -21,8 +21,11 @@
 package helpers
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"os"
+	"sync"
 
 	"github.com/op/go-logging"
 )
@@ -38,3 +41,100 @@ var Stdout io.Writer = os.Stdout
 
 // JSONOutput will signal if we should dump the results to Stdout JSON formatted
 var JSONOutput bool = false
+
+// logBackend is the backend set with SetLogBackend, nil until then.
+var logBackend logging.LeveledBackend
+
+var (
+	logDatasetMu sync.RWMutex
+	logDataset   string
+)
+
+// SetLogBackend will have AppLogger go to the backend, or back to the default backend of go-logging if nil.
+func SetLogBackend(backend logging.LeveledBackend) {
+	logBackend = backend
+	if backend == nil {
+		// A Logger without a backend of its own logs to the default one, it can't be unset otherwise
+		*AppLogger = logging.Logger{Module: AppLogger.Module, ExtraCalldepth: AppLogger.ExtraCalldepth}
+		return
+	}
+	AppLogger.SetBackend(backend)
+}
+
+// LogBackend will return the backend set with SetLogBackend, or nil if the default backend is still in use.
+func LogBackend() logging.LeveledBackend {
+	return logBackend
+}
+
+// SetLogDataset sets the dataset of the job being run, used as the dataset of the lines logged without one.
+func SetLogDataset(dataset string) {
+	logDatasetMu.Lock()
+	defer logDatasetMu.Unlock()
+	logDataset = dataset
+}
+
+// LogFields are the details of a line logged with them, used as fields by structured log outputs
+// (e.g. JSON logs or the systemd journal) and left out of the text of the line.
+type LogFields struct {
+	Dataset     string `json:"dataset,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Volume      string `json:"volume,omitempty"`
+	Bytes       uint64 `json:"bytes,omitempty"`
+	Err         error  `json:"-"`
+}
+
+// String will return an empty string so the fields don't show up in the text of the line.
+func (f LogFields) String() string {
+	return ""
+}
+
+// FieldsOf will return the LogFields a record was logged with, with the dataset of the job being run
+// filled in if it was left empty.
+func FieldsOf(rec *logging.Record) LogFields {
+	var fields LogFields
+	for _, arg := range rec.Args {
+		if f, ok := arg.(LogFields); ok {
+			fields = f
+			break
+		}
+	}
+	if fields.Dataset == "" {
+		logDatasetMu.RLock()
+		fields.Dataset = logDataset
+		logDatasetMu.RUnlock()
+	}
+	return fields
+}
+
+// logger will return the Logger of the Environment of ctx, accounting for the extra call of the methods of
+// LogFields so backends report their caller.
+func (f LogFields) logger(ctx context.Context) *logging.Logger {
+	l := *EnvironmentOf(ctx).Logger
+	l.ExtraCalldepth++
+	return &l
+}
+
+// Errorf will log the line at the error level along with the fields.
+func (f LogFields) Errorf(ctx context.Context, format string, args ...interface{}) {
+	f.logger(ctx).Errorf("%s%v", fmt.Sprintf(format, args...), f)
+}
+
+// Warningf will log the line at the warning level along with the fields.
+func (f LogFields) Warningf(ctx context.Context, format string, args ...interface{}) {
+	f.logger(ctx).Warningf("%s%v", fmt.Sprintf(format, args...), f)
+}
+
+// Noticef will log the line at the notice level along with the fields.
+func (f LogFields) Noticef(ctx context.Context, format string, args ...interface{}) {
+	f.logger(ctx).Noticef("%s%v", fmt.Sprintf(format, args...), f)
+}
+
+// Infof will log the line at the info level along with the fields.
+func (f LogFields) Infof(ctx context.Context, format string, args ...interface{}) {
+	f.logger(ctx).Infof("%s%v", fmt.Sprintf(format, args...), f)
+}
+
+// Debugf will log the line at the debug level along with the fields.
+func (f LogFields) Debugf(ctx context.Context, format string, args ...interface{}) {
+	f.logger(ctx).Debugf("%s%v", fmt.Sprintf(format, args...), f)
+}
@@ -0,0 +1,324 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ManifestJSONSchema is the JSON Schema (draft-07) of the manifests of ManifestSchemaVersion, as exported by the
+// manifest export command. It is kept in manifest.schema.json next to this file for tools that don't read it from
+// the manifest schema command.
+const ManifestJSONSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "zfsbackup manifest",
+  "description": "The manifest of a backup set, describing the zfs send stream it holds and every volume it is stored as. Durations are in nanoseconds.",
+  "type": "object",
+  "required": ["StartTime", "EndTime", "VolumeName", "BaseSnapshot", "IncrementalSnapshot", "Compressor", "Separator", "Volumes"],
+  "additionalProperties": false,
+  "properties": {
+    "StartTime": {"$ref": "#/definitions/time"},
+    "EndTime": {"$ref": "#/definitions/time"},
+    "VolumeName": {"type": "string", "minLength": 1, "description": "The dataset backed up"},
+    "BaseSnapshot": {"$ref": "#/definitions/snapshot", "description": "The snapshot backed up"},
+    "IncrementalSnapshot": {"$ref": "#/definitions/snapshot", "description": "The snapshot the backup set is incremental from, with an empty name for a full backup set"},
+    "Compressor": {"type": "string", "description": "The codec the volumes are compressed with, internal for gzip, zfs or empty for none"},
+    "CompressionLevel": {"type": "integer", "minimum": 0, "maximum": 9},
+    "DigestAlgorithm": {"$ref": "#/definitions/digestAlgorithm"},
+    "Separator": {"type": "string", "minLength": 1, "description": "The separator between the components of the object names"},
+    "ZFSCommandLine": {"type": "string"},
+    "ZFSStreamBytes": {"$ref": "#/definitions/count"},
+    "Volumes": {"type": ["array", "null"], "items": {"$ref": "#/definitions/volume"}},
+    "Version": {"type": "number", "description": "The version of zfsbackup the backup set was sent with"},
+    "SchemaVersion": {"type": "integer", "minimum": 1, "description": "The schema of the manifest, missing from the manifests of schema 1"},
+    "EncryptTo": {"type": "string"},
+    "SignFrom": {"type": "string"},
+    "Replication": {"type": "boolean"},
+    "Deduplication": {"type": "boolean"},
+    "Properties": {"type": "boolean"},
+    "IntermediaryIncremental": {"type": "boolean"},
+    "Tags": {"type": ["object", "null"], "additionalProperties": {"type": "string"}},
+    "MinRetention": {"type": ["object", "null"], "additionalProperties": {"$ref": "#/definitions/count"}, "description": "How long after it started the backup set must be kept, by backend prefix or empty for any"},
+    "ContentAddressed": {"type": "boolean"},
+    "ParityVolumes": {"$ref": "#/definitions/count"},
+    "ParityGroupSize": {"$ref": "#/definitions/count"},
+    "Parity": {"type": ["array", "null"], "items": {"$ref": "#/definitions/volume"}},
+    "Chunking": {"type": "string", "enum": ["", "cdc"]},
+    "ChunkSize": {"$ref": "#/definitions/count"},
+    "Stats": {"$ref": "#/definitions/stats"},
+    "External": {"type": "string", "description": "The replication tool holding the snapshot of a backup set adopted without volumes"}
+  },
+  "definitions": {
+    "time": {"type": "string", "format": "date-time"},
+    "count": {"type": "integer", "minimum": 0},
+    "digestAlgorithm": {"type": "string", "enum": ["", "sha256", "blake3", "xxh3"]},
+    "hex": {"type": "string", "pattern": "^([0-9a-f]{2})*$"},
+    "snapshot": {
+      "type": "object",
+      "required": ["CreationTime", "Name"],
+      "additionalProperties": false,
+      "properties": {
+        "CreationTime": {"$ref": "#/definitions/time"},
+        "Name": {"type": "string"}
+      }
+    },
+    "volume": {
+      "type": "object",
+      "required": ["ObjectName", "VolumeNumber", "SHA256Sum", "Size"],
+      "additionalProperties": false,
+      "properties": {
+        "ObjectName": {"type": "string", "minLength": 1},
+        "VolumeNumber": {"$ref": "#/definitions/count"},
+        "DigestAlgorithm": {"$ref": "#/definitions/digestAlgorithm"},
+        "DigestSum": {"$ref": "#/definitions/hex"},
+        "SHA256Sum": {"type": "string", "pattern": "^([0-9a-f]{64})?$"},
+        "MD5Sum": {"type": "string", "pattern": "^([0-9a-f]{32})?$"},
+        "CRC32CSum32": {"type": "integer", "minimum": 0, "maximum": 4294967295},
+        "Size": {"$ref": "#/definitions/count"},
+        "ZFSStreamBytes": {"$ref": "#/definitions/count"},
+        "CreateTime": {"$ref": "#/definitions/time"},
+        "CloseTime": {"$ref": "#/definitions/time"},
+        "IsManifest": {"type": "boolean"},
+        "IsFinalManifest": {"type": "boolean"},
+        "IsParity": {"type": "boolean"},
+        "IsLayout": {"type": "boolean"},
+        "UploadTime": {"$ref": "#/definitions/count"},
+        "Retries": {"$ref": "#/definitions/count"}
+      }
+    },
+    "stats": {
+      "type": ["object", "null"],
+      "additionalProperties": false,
+      "properties": {
+        "StreamBytes": {"$ref": "#/definitions/count"},
+        "StoredBytes": {"$ref": "#/definitions/count"},
+        "CompressionRatio": {"type": "number", "minimum": 0},
+        "WallTime": {"$ref": "#/definitions/count"},
+        "Throughput": {"$ref": "#/definitions/count"},
+        "UploadTime": {"$ref": "#/definitions/count"},
+        "Retries": {"$ref": "#/definitions/count"}
+      }
+    }
+  }
+}
+`
+
+var (
+	manifestSchemaOnce sync.Once
+	manifestSchema     map[string]interface{}
+	schemaPatternsMu   sync.Mutex
+	schemaPatterns     = make(map[string]*regexp.Regexp)
+)
+
+// ValidateManifestJSON will check the JSON encoded manifest against ManifestJSONSchema, returning every way it does
+// not conform to it, or that it is of a schema newer than this version of zfsbackup reads. Only the keywords used by
+// ManifestJSONSchema are supported.
+func ValidateManifestJSON(data []byte) []string {
+	manifestSchemaOnce.Do(func() {
+		if err := json.Unmarshal([]byte(ManifestJSONSchema), &manifestSchema); err != nil {
+			panic(fmt.Sprintf("invalid manifest schema - %v", err))
+		}
+	})
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return []string{fmt.Sprintf("not a JSON document - %v", err)}
+	}
+	if decoder.More() {
+		return []string{"more than one JSON document"}
+	}
+
+	var problems []string
+	validateSchema(manifestSchema, "", document, &problems)
+	if len(problems) > 0 {
+		return problems
+	}
+
+	manifest := new(JobInfo)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return []string{err.Error()}
+	}
+	if _, err := MigrateManifest(manifest); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// validateSchema will add the ways value, found at path, does not conform to schema to problems.
+func validateSchema(schema map[string]interface{}, path string, value interface{}, problems *[]string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		definitions, _ := manifestSchema["definitions"].(map[string]interface{})
+		definition, _ := definitions[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+		validateSchema(definition, path, value, problems)
+		return
+	}
+
+	report := func(format string, args ...interface{}) {
+		location := path
+		if location == "" {
+			location = "/"
+		}
+		*problems = append(*problems, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		actual := jsonType(value)
+		matched := false
+		for _, t := range types {
+			if t == actual || (t == "number" && actual == "integer") {
+				matched = true
+			}
+		}
+		if !matched {
+			report("expected %s, got %s", strings.Join(types, " or "), actual)
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+			}
+		}
+		if !found {
+			report("%v is not one of %v", value, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if minLength, ok := schema["minLength"].(float64); ok && float64(len(v)) < minLength {
+			report("expected at least %v characters", minLength)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			schemaPatternsMu.Lock()
+			re, found := schemaPatterns[pattern]
+			if !found {
+				re = regexp.MustCompile(pattern)
+				schemaPatterns[pattern] = re
+			}
+			schemaPatternsMu.Unlock()
+			if !re.MatchString(v) {
+				report("%q does not match %s", v, pattern)
+			}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				report("%q is not a date-time - %v", v, err)
+			}
+		}
+	case json.Number:
+		number, _ := strconv.ParseFloat(v.String(), 64)
+		if minimum, ok := schema["minimum"].(float64); ok && number < minimum {
+			report("%s is less than %v", v, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && number > maximum {
+			report("%s is greater than %v", v, maximum)
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, found := v[r.(string)]; !found {
+					report("missing the required property %s", r)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				validateSchema(property, path+"/"+key, v[key], problems)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					report("unknown property %s", key)
+				}
+			case map[string]interface{}:
+				validateSchema(additional, path+"/"+key, v[key], problems)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for idx := range v {
+				validateSchema(items, path+"/"+strconv.Itoa(idx), v[idx], problems)
+			}
+		}
+	}
+}
+
+// schemaTypes will return the types allowed by the type keyword of a schema.
+func schemaTypes(keyword interface{}) []string {
+	switch t := keyword.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := make([]string, len(t))
+		for idx := range t {
+			types[idx], _ = t[idx].(string)
+		}
+		return types
+	}
+	return nil
+}
+
+// jsonType will return the JSON Schema type of a value decoded with numbers as json.Number.
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		if _, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,88 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+var (
+	// Keys every backend accepts, Azure metadata names must be valid C# identifiers
+	objectLabelKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ObjectLabelFields are the fields the templates of the object tags and metadata are rendered with.
+type ObjectLabelFields struct {
+	Dataset      string // The volume backed up
+	Snapshot     string // The snapshot backed up
+	SnapshotDate string // The creation time of the snapshot backed up, RFC3339 formatted
+	BackupType   string // full or incremental
+	Job          string // The name of the job sending the backup set, empty unless it is run by the run command
+}
+
+// ObjectLabelFields returns the fields the object tags and metadata of this JobInfo are rendered with.
+func (j *JobInfo) ObjectLabelFields() ObjectLabelFields {
+	fields := ObjectLabelFields{
+		Dataset:    j.VolumeName,
+		Snapshot:   j.BaseSnapshot.Name,
+		BackupType: "full",
+		Job:        j.JobName,
+	}
+	if !j.BaseSnapshot.CreationTime.IsZero() {
+		fields.SnapshotDate = j.BaseSnapshot.CreationTime.UTC().Format(time.RFC3339)
+	}
+	if j.IncrementalSnapshot.Name != "" {
+		fields.BackupType = "incremental"
+	}
+	return fields
+}
+
+// RenderObjectLabels will render the key=template pairs provided, e.g. the ObjectTags or ObjectMetadata,
+// with the ObjectLabelFields of this JobInfo. Labels rendered empty are left out.
+func (j *JobInfo) RenderObjectLabels(labels map[string]string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	fields := j.ObjectLabelFields()
+	rendered := make(map[string]string, len(labels))
+	for key, value := range labels {
+		if !objectLabelKey.MatchString(key) {
+			return nil, fmt.Errorf("invalid object label key %s, it must start with a letter or an underscore followed by letters, digits, or underscores", key)
+		}
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for the object label %s - %v", key, err)
+		}
+		var buf bytes.Buffer
+		if err = tmpl.Execute(&buf, fields); err != nil {
+			return nil, fmt.Errorf("could not render the object label %s - %v", key, err)
+		}
+		if buf.Len() > 0 {
+			rendered[key] = buf.String()
+		}
+	}
+	return rendered, nil
+}
@@ -0,0 +1,143 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"io"
+	"sync"
+)
+
+// Transfers can pause every transfer to and from the backends of this process, e.g. so a backup can yield during
+// an unexpected load spike without being killed. Transfers already started block until they are resumed.
+var Transfers = new(Pauser)
+
+// Pauser blocks its callers while it is paused or held. The zero value is ready to use and not paused.
+type Pauser struct {
+	mu      sync.Mutex
+	paused  bool          // by Pause, e.g. from jobs pause
+	holds   int           // by Hold, e.g. while the backup window is closed
+	resumed chan struct{} // nil when neither paused nor held
+}
+
+// Pause will block any caller of Wait until Resume is called. It returns false if it was already paused.
+func (p *Pauser) Pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused {
+		return false
+	}
+	p.paused = true
+	p.block()
+	AppLogger.Noticef("Pausing all transfers, temporary files are kept until the transfers are resumed.")
+	return true
+}
+
+// Resume will undo Pause, unblocking every caller of Wait unless the Pauser is also held. It returns false
+// if it was not paused.
+func (p *Pauser) Resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.paused {
+		return false
+	}
+	p.paused = false
+	p.unblock()
+	if p.holds > 0 {
+		AppLogger.Noticef("Resuming all transfers once they are not held anymore.")
+	} else {
+		AppLogger.Noticef("Resuming all transfers.")
+	}
+	return true
+}
+
+// Hold will block any caller of Wait until Release is called, independently of Pause and Resume so that
+// releasing it does not resume transfers paused with Pause.
+func (p *Pauser) Hold() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.holds++
+	p.block()
+}
+
+// Release will undo a Hold.
+func (p *Pauser) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.holds == 0 {
+		return
+	}
+	p.holds--
+	p.unblock()
+}
+
+// Paused reports whether the Pauser is paused or held.
+func (p *Pauser) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed != nil
+}
+
+// block will make the callers of Wait block, if they do not already.
+// Must be called with the lock held.
+func (p *Pauser) block() {
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+	}
+}
+
+// unblock will unblock the callers of Wait once the Pauser is neither paused nor held.
+// Must be called with the lock held.
+func (p *Pauser) unblock() {
+	if !p.paused && p.holds == 0 && p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+}
+
+// Wait will block while the Pauser is paused.
+func (p *Pauser) Wait() {
+	p.mu.Lock()
+	resumed := p.resumed
+	p.mu.Unlock()
+
+	if resumed != nil {
+		<-resumed
+	}
+}
+
+// Reader will return an io.Reader that waits for the Pauser before every read from r.
+func (p *Pauser) Reader(r io.Reader) io.Reader {
+	return &pausingReader{r: r, p: p}
+}
+
+type pausingReader struct {
+	r io.Reader
+	p *Pauser
+}
+
+func (r *pausingReader) Read(b []byte) (int, error) {
+	r.p.Wait()
+	return r.r.Read(b)
+}
@@ -0,0 +1,104 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+// blocked reports whether Wait blocks on the Pauser.
+func blocked(p *Pauser) bool {
+	waited := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		return false
+	case <-time.After(50 * time.Millisecond):
+		return true
+	}
+}
+
+func TestPauser(t *testing.T) {
+	p := new(Pauser)
+	if p.Paused() || blocked(p) {
+		t.Fatalf("expected a new Pauser not to block")
+	}
+
+	if !p.Pause() || p.Pause() || !p.Paused() || !blocked(p) {
+		t.Errorf("expected Pause to block once")
+	}
+	if !p.Resume() || p.Resume() || p.Paused() || blocked(p) {
+		t.Errorf("expected Resume to unblock once")
+	}
+
+	// A hold released does not resume transfers paused in the meantime, e.g. by jobs pause while the
+	// backup window was closed
+	p.Hold()
+	if !p.Paused() || !blocked(p) {
+		t.Errorf("expected Hold to block")
+	}
+	p.Pause()
+	p.Release()
+	if !p.Paused() || !blocked(p) {
+		t.Errorf("expected the Pauser to stay paused once released")
+	}
+	p.Resume()
+	if p.Paused() || blocked(p) {
+		t.Errorf("expected Resume to unblock")
+	}
+
+	// Resuming does not release a hold
+	p.Hold()
+	p.Pause()
+	if !p.Resume() || !p.Paused() || !blocked(p) {
+		t.Errorf("expected the Pauser to stay held once resumed")
+	}
+	p.Release()
+	if p.Paused() || blocked(p) {
+		t.Errorf("expected Release to unblock")
+	}
+
+	// Releasing more than held does nothing
+	p.Release()
+	p.Hold()
+	if !blocked(p) {
+		t.Errorf("expected Hold to block after an extra Release")
+	}
+	p.Release()
+
+	// Waiting callers are unblocked
+	p.Pause()
+	waited := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(waited)
+	}()
+	p.Resume()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Errorf("expected Resume to unblock a waiting caller")
+	}
+}
@@ -21,11 +21,18 @@
 package helpers
 
 import (
+	"bytes"
+	"crypto"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
 )
 
 var (
@@ -45,9 +52,15 @@ func GetPrivateKeyByEmail(email string) *openpgp.Entity {
 	return getKeyByEmail(secRing, email)
 }
 
-// getCombinedKeyRing will return both the public and secret key rings combined
-func getCombinedKeyRing() openpgp.KeyRing {
-	return append(pubRing, secRing...)
+// KeyRings will return the public and secret PGP rings loaded.
+func KeyRings() (openpgp.EntityList, openpgp.EntityList) {
+	return pubRing, secRing
+}
+
+// SetKeyRings will replace the public and secret PGP rings, e.g. with rings not read from a file. The private
+// keys of the secret ring must already be decrypted.
+func SetKeyRings(public, secret openpgp.EntityList) {
+	pubRing, secRing = public, secret
 }
 
 // promptFunc is used to satisfy the openpgp package's requirements
@@ -88,6 +101,83 @@ func LoadPrivateRing(path string) error {
 	return err
 }
 
+// GenerateKeyRings will create a new PGP key for the name and email provided and write it,
+// unencrypted, to an armored secret keyring and public keyring at the paths provided. Either both
+// keyrings are written or neither is, and existing files are never overwritten.
+func GenerateKeyRings(name, email, secretPath, publicPath string) error {
+	for _, path := range []string{secretPath, publicPath} {
+		if _, err := os.Lstat(path); err == nil {
+			return &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Without preferences, encrypting to the key falls back to algorithms that are not compiled in
+	config := &packet.Config{DefaultHash: crypto.SHA256, DefaultCipher: packet.CipherAES256}
+	entity, err := openpgp.NewEntity(name, ProgramName, email, config)
+	if err != nil {
+		return err
+	}
+
+	// Serializing the private key signs the identities, do it first so the public keyring includes the signatures
+	secret, err := armored(openpgp.PrivateKeyType, func(w io.Writer) error { return entity.SerializePrivate(w, nil) })
+	if err != nil {
+		return err
+	}
+	public, err := armored(openpgp.PublicKeyType, entity.Serialize)
+	if err != nil {
+		return err
+	}
+
+	if err = writeNew(secretPath, secret); err != nil {
+		return err
+	}
+	if err = writeNew(publicPath, public); err != nil {
+		os.Remove(secretPath)
+		return err
+	}
+	return nil
+}
+
+func armored(blockType string, serialize func(io.Writer) error) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w, err := armor.Encode(buf, blockType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = serialize(w); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeNew will write the data to a temporary file next to the path provided and link it into place,
+// so the path is either missing or complete, and is never overwritten if it already exists.
+func writeNew(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Link(f.Name(), path)
+}
+
 // PrintPGPDebugInformation will output a debug log entry listing the keys it has read in from each keyring.
 func PrintPGPDebugInformation() {
 	debugStr := make([]string, 0, 4)
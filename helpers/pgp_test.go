@@ -0,0 +1,80 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateKeyRings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackuppgp")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretPath := filepath.Join(dir, "secring.gpg.asc")
+	publicPath := filepath.Join(dir, "pubring.gpg.asc")
+	if err = GenerateKeyRings("test", "test@example.com", secretPath, publicPath); err != nil {
+		t.Fatalf("could not generate the keyrings: %v", err)
+	}
+	if err = LoadPrivateRing(secretPath); err != nil {
+		t.Fatalf("could not load the secret keyring: %v", err)
+	}
+	if err = LoadPublicRing(publicPath); err != nil {
+		t.Fatalf("could not load the public keyring: %v", err)
+	}
+	if GetPrivateKeyByEmail("test@example.com") == nil || GetPublicKeyByEmail("test@example.com") == nil {
+		t.Errorf("expected the generated key to be found in both keyrings")
+	}
+	if info, serr := os.Stat(secretPath); serr != nil {
+		t.Errorf("could not stat the secret keyring: %v", serr)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the secret keyring to only be readable by its owner, got %v", info.Mode())
+	}
+
+	// Existing keyrings are left alone
+	secret, _ := ioutil.ReadFile(secretPath)
+	if err = GenerateKeyRings("test", "test@example.com", secretPath, publicPath); !os.IsExist(err) {
+		t.Errorf("expected an error for existing keyrings, got %v", err)
+	}
+	if again, _ := ioutil.ReadFile(secretPath); string(again) != string(secret) {
+		t.Errorf("expected the existing secret keyring to be left alone")
+	}
+
+	// A failure writing the public keyring does not leave a secret keyring behind
+	secretPath = filepath.Join(dir, "other-secring.gpg.asc")
+	publicPath = filepath.Join(dir, "missing", "pubring.gpg.asc")
+	if err = GenerateKeyRings("test", "test@example.com", secretPath, publicPath); err == nil {
+		t.Errorf("expected an error writing the public keyring")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		t.Errorf("expected only the first keyrings to be left, found %v", names)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"bufio"
+	"io"
+	"runtime"
+	"sync"
+
+	gzip "github.com/klauspost/pgzip"
+)
+
+// BufferPool hands out byte slices of a fixed size to be reused once done with, so the data copied
+// through the pipeline of long running jobs does not allocate a new buffer for every chunk.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewBufferPool returns a BufferPool of byte slices of the size provided.
+func NewBufferPool(size int) *BufferPool {
+	p := &BufferPool{size: size}
+	p.pool.New = func() interface{} {
+		b := make([]byte, size)
+		return &b
+	}
+	return p
+}
+
+// Get returns a byte slice of the size of the pool. Hand it back with Put once done with it.
+func (p *BufferPool) Get() []byte {
+	return *(p.pool.Get().(*[]byte))
+}
+
+// Put returns a byte slice obtained from Get to the pool, it must not be used afterwards.
+func (p *BufferPool) Put(b []byte) {
+	if cap(b) < p.size {
+		return
+	}
+	b = b[:p.size]
+	p.pool.Put(&b)
+}
+
+var bufferPools sync.Map // size -> *BufferPool
+
+// BuffersOf returns the BufferPool of byte slices of the size provided shared by Copy, CopySize, and CopyN.
+func BuffersOf(size int) *BufferPool {
+	if p, ok := bufferPools.Load(size); ok {
+		return p.(*BufferPool)
+	}
+	p, _ := bufferPools.LoadOrStore(size, NewBufferPool(size))
+	return p.(*BufferPool)
+}
+
+// CopySize is io.Copy reading src in pooled buffers of the size provided, BufferSize if 0 or less.
+func CopySize(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		size = BufferSize
+	}
+	buffers := BuffersOf(size)
+	buf := buffers.Get()
+	defer buffers.Put(buf)
+	return io.CopyBuffer(dst, src, buf)
+}
+
+// Copy is io.Copy using a pooled buffer of BufferSize instead of allocating one.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	return CopySize(dst, src, BufferSize)
+}
+
+// CopyN is io.CopyN using a pooled buffer of BufferSize instead of allocating one.
+func CopyN(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	written, err := Copy(dst, io.LimitReader(src, n))
+	if written == n {
+		return n, nil
+	}
+	if written < n && err == nil {
+		// src stopped early, it must have been EOF
+		err = io.EOF
+	}
+	return written, err
+}
+
+var bufWriters = sync.Pool{
+	New: func() interface{} { return bufio.NewWriterSize(nil, BufferSize) },
+}
+
+// getBufWriter returns a BufferSize bufio.Writer writing to w, hand it back with putBufWriter once flushed.
+func getBufWriter(w io.Writer) *bufio.Writer {
+	bw := bufWriters.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return bw
+}
+
+func putBufWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	bufWriters.Put(bw)
+}
+
+// The internal compressor allocates its compression state and blocks per writer, so they are reused
+// across volumes instead, pooled per compression level and block size.
+var gzipWriters sync.Map // gzipWriterKey -> *sync.Pool
+
+type gzipWriterKey struct {
+	level, blockSize int
+}
+
+// pooledGzipWriter is returned to the pool it was taken from once closed.
+type pooledGzipWriter struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+func (w *pooledGzipWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return err
+	}
+	w.Writer.Reset(nil)
+	w.pool.Put(w.Writer)
+	return nil
+}
+
+// newGzipWriter returns a gzip writer of the compression level provided, compressing blocks of blockSize
+// bytes in parallel, writing to w. A previously closed one is reused if available.
+func newGzipWriter(w io.Writer, level, blockSize int) (io.WriteCloser, error) {
+	p, _ := gzipWriters.LoadOrStore(gzipWriterKey{level, blockSize}, new(sync.Pool))
+	pool := p.(*sync.Pool)
+	z, ok := pool.Get().(*gzip.Writer)
+	if ok {
+		z.Reset(w)
+	} else {
+		var err error
+		if z, err = gzip.NewWriterLevel(w, level); err != nil {
+			return nil, err
+		}
+	}
+
+	// Reset restores the default concurrency
+	if err := z.SetConcurrency(blockSize, runtime.GOMAXPROCS(0)); err != nil {
+		return nil, err
+	}
+	return &pooledGzipWriter{z, pool}, nil
+}
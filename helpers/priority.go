@@ -0,0 +1,179 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Nice is the niceness the zfs send and receive commands and the external compressors are run with,
+	// through the nice binary, so a backup yields the CPU to the other workloads of the host. Their
+	// niceness is left as is if 0.
+	Nice int
+	// NicePath is the path to the nice binary.
+	NicePath = "nice"
+	// IONice is the I/O scheduling class the zfs send and receive commands and the external compressors are
+	// run with, through the ionice binary: idle, or best-effort or realtime with an optional level from 0
+	// (highest) to 7, e.g. best-effort:7. Their class is left as is if empty.
+	IONice string
+	// IONicePath is the path to the ionice binary.
+	IONicePath = "ionice"
+)
+
+// The I/O scheduling classes of ionice
+var ioniceClasses = map[string]string{
+	"realtime":    "1",
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// cgroupRoot is where the cgroup filesystems are mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// ValidatePriority will check that Nice and IONice are valid.
+func ValidatePriority() error {
+	if Nice < -20 || Nice > 19 {
+		return fmt.Errorf("the niceness must be between -20 and 19, was given %d", Nice)
+	}
+	// The external compressors are always run locally
+	if IONice != "" && runtime.GOOS != "linux" {
+		return fmt.Errorf("the I/O scheduling class can only be set on Linux, use the niceness instead on %s", runtime.GOOS)
+	}
+	_, err := ioniceArgs(IONice)
+	return err
+}
+
+// ioniceArgs will return the arguments of ionice for the class given, none if it is empty.
+func ioniceArgs(class string) ([]string, error) {
+	if class == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(strings.ToLower(class), ":", 2)
+	id, ok := ioniceClasses[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("invalid I/O scheduling class %s, expected idle, best-effort, or realtime", class)
+	}
+	args := []string{"-c", id}
+	if len(parts) == 2 {
+		level, err := strconv.Atoi(parts[1])
+		if err != nil || level < 0 || level > 7 || parts[0] == "idle" {
+			return nil, fmt.Errorf("invalid I/O scheduling level in %s, expected a level from 0 to 7 for the best-effort and realtime classes", class)
+		}
+		args = append(args, "-n", parts[1])
+	}
+	return args, nil
+}
+
+// prioritized will return the path and arguments running the binary at path with the given arguments
+// at the Nice and IONice requested.
+func prioritized(path string, args ...string) (string, []string) {
+	var prefix []string
+	if Nice != 0 {
+		prefix = append(prefix, NicePath, "-n", strconv.Itoa(Nice))
+	}
+	if ionice, _ := ioniceArgs(IONice); ionice != nil {
+		prefix = append(append(prefix, IONicePath), ionice...)
+	}
+	if len(prefix) == 0 {
+		return path, args
+	}
+	return prefix[0], append(append(prefix[1:], path), args...)
+}
+
+// CgroupCPULimit will return the number of CPUs the CPU quota of the cgroup of this process allows it
+// to use, rounded up, or 0 if it has none.
+func CgroupCPULimit() int {
+	return cgroupCPULimit(cgroupRoot, "/proc/self/cgroup")
+}
+
+// cgroupCPULimit will return the CPU limit of the cgroup listed in the cgroup file provided, as found under
+// the cgroup filesystems mounted at root.
+func cgroupCPULimit(root, cgroupFile string) int {
+	path := ownCgroup(cgroupFile)
+	// cgroup v2
+	for _, dir := range []string{filepath.Join(root, path), root} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "cpu.max"))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0
+		}
+		return cpuQuota(fields[0], fields[1])
+	}
+
+	// cgroup v1
+	for _, dir := range []string{filepath.Join(root, "cpu", path), filepath.Join(root, "cpu")} {
+		quota, err := ioutil.ReadFile(filepath.Join(dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			continue
+		}
+		period, err := ioutil.ReadFile(filepath.Join(dir, "cpu.cfs_period_us"))
+		if err != nil {
+			return 0
+		}
+		return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	}
+	return 0
+}
+
+// ownCgroup will return the path of the cgroup listed in the cgroup file provided (e.g. /proc/self/cgroup),
+// the cgroup v2 one if any.
+func ownCgroup(cgroupFile string) string {
+	data, err := ioutil.ReadFile(cgroupFile)
+	if err != nil {
+		return ""
+	}
+	var path string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2]
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller == "cpu" {
+				path = parts[2]
+			}
+		}
+	}
+	return path
+}
+
+func cpuQuota(quota, period string) int {
+	q, qerr := strconv.ParseFloat(quota, 64)
+	p, perr := strconv.ParseFloat(period, 64)
+	if qerr != nil || perr != nil || q <= 0 || p <= 0 {
+		return 0
+	}
+	return int(math.Ceil(q / p))
+}
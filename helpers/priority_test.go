@@ -0,0 +1,185 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIONiceArgs(t *testing.T) {
+	testCases := []struct {
+		class       string
+		expect      []string
+		expectError bool
+	}{
+		{"", nil, false},
+		{"idle", []string{"-c", "3"}, false},
+		{"IDLE", []string{"-c", "3"}, false},
+		{"best-effort", []string{"-c", "2"}, false},
+		{"best-effort:0", []string{"-c", "2", "-n", "0"}, false},
+		{"best-effort:7", []string{"-c", "2", "-n", "7"}, false},
+		{"realtime:4", []string{"-c", "1", "-n", "4"}, false},
+		{"best-effort:8", nil, true},
+		{"best-effort:-1", nil, true},
+		{"best-effort:", nil, true},
+		{"best-effort:high", nil, true},
+		{"idle:0", nil, true},
+		{"idle:7", nil, true},
+		{"background", nil, true},
+		{"3", nil, true},
+	}
+
+	for idx, c := range testCases {
+		args, err := ioniceArgs(c.class)
+		if (err != nil) != c.expectError {
+			t.Errorf("%d: expected error %v, got %v", idx, c.expectError, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, c.expect) {
+			t.Errorf("%d: expected %v, got %v", idx, c.expect, args)
+		}
+	}
+}
+
+func TestPrioritized(t *testing.T) {
+	oldNice, oldIONice := Nice, IONice
+	defer func() { Nice, IONice = oldNice, oldIONice }()
+
+	testCases := []struct {
+		nice       int
+		ionice     string
+		expectPath string
+		expectArgs []string
+	}{
+		{0, "", "zfs", []string{"send", "tank@snap"}},
+		{10, "", "nice", []string{"-n", "10", "zfs", "send", "tank@snap"}},
+		{0, "idle", "ionice", []string{"-c", "3", "zfs", "send", "tank@snap"}},
+		{-5, "best-effort:7", "nice", []string{"-n", "-5", "ionice", "-c", "2", "-n", "7", "zfs", "send", "tank@snap"}},
+	}
+
+	for idx, c := range testCases {
+		Nice, IONice = c.nice, c.ionice
+		path, args := prioritized("zfs", "send", "tank@snap")
+		if path != c.expectPath || !reflect.DeepEqual(args, c.expectArgs) {
+			t.Errorf("%d: expected %s %v, got %s %v", idx, c.expectPath, c.expectArgs, path, args)
+		}
+	}
+}
+
+func TestCPUQuota(t *testing.T) {
+	testCases := []struct {
+		quota  string
+		period string
+		expect int
+	}{
+		{"100000", "100000", 1},
+		{"150000", "100000", 2},
+		{"50000", "100000", 1},
+		{"400000", "100000", 4},
+		{"-1", "100000", 0},
+		{"max", "100000", 0},
+		{"100000", "0", 0},
+		{"", "", 0},
+	}
+
+	for idx, c := range testCases {
+		if got := cpuQuota(c.quota, c.period); got != c.expect {
+			t.Errorf("%d: expected %d, got %d", idx, c.expect, got)
+		}
+	}
+}
+
+func TestCgroupCPULimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupcgroup")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		cgroup       string
+		files        map[string]string
+		expectCgroup string
+		expect       int
+	}{
+		// cgroup v2
+		{"0::/system.slice/zfsbackup.service\n", map[string]string{"system.slice/zfsbackup.service/cpu.max": "150000 100000\n"}, "/system.slice/zfsbackup.service", 2},
+		{"0::/system.slice/zfsbackup.service\n", map[string]string{"system.slice/zfsbackup.service/cpu.max": "max 100000\n"}, "/system.slice/zfsbackup.service", 0},
+		// In a container, the cgroup of the process is the root of the mounted filesystem
+		{"0::/\n", map[string]string{"cpu.max": "200000 100000\n"}, "/", 2},
+		{"0::/docker/abc\n", map[string]string{"cpu.max": "50000 100000\n"}, "/docker/abc", 1},
+		// cgroup v1
+		{"5:memory:/user.slice\n4:cpu,cpuacct:/user.slice/backup\n1:name=systemd:/user.slice\n", map[string]string{
+			"cpu/user.slice/backup/cpu.cfs_quota_us":  "300000\n",
+			"cpu/user.slice/backup/cpu.cfs_period_us": "100000\n",
+		}, "/user.slice/backup", 3},
+		{"4:cpu,cpuacct:/user.slice\n", map[string]string{
+			"cpu/user.slice/cpu.cfs_quota_us":  "-1\n",
+			"cpu/user.slice/cpu.cfs_period_us": "100000\n",
+		}, "/user.slice", 0},
+		{"4:cpuacct,cpu:/docker/abc\n", map[string]string{
+			"cpu/cpu.cfs_quota_us":  "250000\n",
+			"cpu/cpu.cfs_period_us": "100000\n",
+		}, "/docker/abc", 3},
+		{"4:cpu:/user.slice\n", map[string]string{"cpu/user.slice/cpu.cfs_quota_us": "100000\n"}, "/user.slice", 0},
+		// Hybrid, the cgroup v2 hierarchy wins
+		{"4:cpu,cpuacct:/user.slice\n0::/user.slice/backup\n", map[string]string{
+			"user.slice/backup/cpu.max":        "100000 100000\n",
+			"cpu/user.slice/cpu.cfs_quota_us":  "400000\n",
+			"cpu/user.slice/cpu.cfs_period_us": "100000\n",
+		}, "/user.slice/backup", 1},
+		// No quota files at all
+		{"0::/\n", nil, "/", 0},
+		{"", nil, "", 0},
+	}
+
+	for idx, c := range testCases {
+		root := filepath.Join(dir, "case", string(rune('a'+idx)))
+		for name, content := range c.files {
+			path := filepath.Join(root, name)
+			if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				t.Fatalf("could not create %s: %v", filepath.Dir(path), err)
+			}
+			if err = ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+				t.Fatalf("could not write %s: %v", path, err)
+			}
+		}
+		cgroupFile := filepath.Join(dir, "cgroup")
+		if err = ioutil.WriteFile(cgroupFile, []byte(c.cgroup), 0600); err != nil {
+			t.Fatalf("could not write the cgroup file: %v", err)
+		}
+
+		if got := ownCgroup(cgroupFile); got != c.expectCgroup {
+			t.Errorf("%d: expected the cgroup %s, got %s", idx, c.expectCgroup, got)
+		}
+		if got := cgroupCPULimit(root, cgroupFile); got != c.expect {
+			t.Errorf("%d: expected a limit of %d CPUs, got %d", idx, c.expect, got)
+		}
+	}
+
+	if got := ownCgroup(filepath.Join(dir, "missing")); got != "" {
+		t.Errorf("expected no cgroup without a cgroup file, got %s", got)
+	}
+}
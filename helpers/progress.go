@@ -0,0 +1,99 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"time"
+)
+
+// ProgressEventType identifies the kind of step a running job is reporting on.
+type ProgressEventType int
+
+// The progress events a job may report. ProgressJobPlanned is reported once the size
+// of the work ahead is known, with the expected number of bytes in Bytes. ProgressRetry
+// is reported for every failed attempt at uploading or downloading a volume, with the
+// error in Message.
+const (
+	ProgressJobStarted ProgressEventType = iota + 1
+	ProgressVolumeCreated
+	ProgressVolumeUploaded
+	ProgressVolumeDownloaded
+	ProgressVolumeRestored
+	ProgressVolumeVerified
+	ProgressJobFinished
+	ProgressJobPlanned
+	ProgressRetry
+)
+
+var progressEventNames = map[ProgressEventType]string{
+	ProgressJobStarted:       "job_started",
+	ProgressVolumeCreated:    "volume_created",
+	ProgressVolumeUploaded:   "volume_uploaded",
+	ProgressVolumeDownloaded: "volume_downloaded",
+	ProgressVolumeRestored:   "volume_restored",
+	ProgressVolumeVerified:   "volume_verified",
+	ProgressJobFinished:      "job_finished",
+	ProgressJobPlanned:       "job_planned",
+	ProgressRetry:            "retry",
+}
+
+// String will return a short, machine friendly name for the event type.
+func (p ProgressEventType) String() string {
+	if name, ok := progressEventNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ProgressEvent describes a single step taken by a running send, receive, or verify job.
+type ProgressEvent struct {
+	Type         ProgressEventType
+	Time         time.Time
+	VolumeName   string
+	Snapshot     string
+	ObjectName   string
+	Destination  string
+	VolumeNumber int64
+	Bytes        uint64
+	Message      string
+}
+
+// ProgressFunc is called for every ProgressEvent a job reports. It may be called
+// concurrently from several goroutines and should not block for long.
+type ProgressFunc func(ProgressEvent)
+
+// ReportProgress will pass the event along to the job's Progress function, if one
+// is set, filling in the time and dataset details when they were left empty.
+func (j *JobInfo) ReportProgress(event ProgressEvent) {
+	if j.Progress == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.VolumeName == "" {
+		event.VolumeName = j.VolumeName
+	}
+	if event.Snapshot == "" {
+		event.Snapshot = j.BaseSnapshot.Name
+	}
+	j.Progress(event)
+}
@@ -0,0 +1,110 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import "fmt"
+
+// The schema of a manifest is versioned so zfsbackup can tell what it may rely on when reading it:
+//
+//   - A manifest of an older schema is always readable. It is migrated, in memory, to the current schema
+//     as it is read, so the rest of zfsbackup only ever deals with manifests of the current schema. The
+//     migrate-manifests command writes the migrated manifests back to a target.
+//   - A manifest of a newer schema is never read, it may rely on something this version of zfsbackup
+//     does not know about and restoring or modifying its backup set could silently go wrong.
+//   - Adding a field that older versions can safely ignore does not change the schema. Anything else,
+//     e.g. a field older versions must understand or a change to the meaning of an existing one, bumps
+//     ManifestSchemaVersion and adds the migration of the previous schema to manifestMigrations.
+//
+// Schema 1 is every manifest written before schemas were versioned, which do not record one. It is also the
+// format of the manifests written by the upstream releases of zfsbackup-go, see ManifestFormatUpstream.
+
+// The formats a manifest can be written in. Manifests of either format are always read.
+const (
+	// ManifestFormatNative manifests record the ManifestSchemaVersion they were written with
+	ManifestFormatNative = "native"
+	// ManifestFormatUpstream manifests are unversioned manifests of schema 1, as written and read by the upstream
+	// releases of zfsbackup-go. No record of them is kept in the history of the manifests.
+	ManifestFormatUpstream = "upstream"
+	// ManifestFormatAuto writes manifests in the format of those already found at the destinations
+	ManifestFormatAuto = "auto"
+)
+
+// manifestMigrations upgrade a manifest from the schema of their index plus one to the next one.
+var manifestMigrations = []func(j *JobInfo){
+	// 1 to 2: the digest algorithm of the backup set is always recorded, it was SHA256 when missing
+	func(j *JobInfo) {
+		if j.DigestAlgorithm == "" {
+			j.DigestAlgorithm = DigestSHA256
+		}
+	},
+}
+
+// ManifestSchema returns the schema version of the manifest.
+func (j *JobInfo) ManifestSchema() int {
+	if j.SchemaVersion == 0 {
+		return 1
+	}
+	return j.SchemaVersion
+}
+
+// MigrateManifest will upgrade the manifest, as decoded, to ManifestSchemaVersion and report whether
+// it had to. It returns an error if the manifest is of a newer schema than this version of zfsbackup
+// can read. The ManifestFormat of the manifest is set to the one it was read in, so it is written
+// back in the same format.
+func MigrateManifest(j *JobInfo) (bool, error) {
+	schema := j.ManifestSchema()
+	j.ManifestFormat = ManifestFormatNative
+	if schema == 1 {
+		j.ManifestFormat = ManifestFormatUpstream
+	}
+	if schema > ManifestSchemaVersion {
+		return false, fmt.Errorf("the manifest of %s@%s is of schema %d but this version of %s only reads up to schema %d, please upgrade", j.VolumeName, j.BaseSnapshot.Name, schema, ProgramName, ManifestSchemaVersion)
+	}
+	for ; schema < ManifestSchemaVersion; schema++ {
+		manifestMigrations[schema-1](j)
+	}
+	migrated := j.SchemaVersion != ManifestSchemaVersion
+	j.SchemaVersion = ManifestSchemaVersion
+	return migrated, nil
+}
+
+// UpstreamIncompatibilities returns the options of the backup set the upstream releases of zfsbackup-go would not
+// restore or clean up correctly, making it unfit to be written in ManifestFormatUpstream.
+func (j *JobInfo) UpstreamIncompatibilities() []string {
+	var problems []string
+	if j.ContentAddressed {
+		problems = append(problems, "volumes shared with other backup sets (--contentAddressed)")
+	}
+	if j.Chunking != "" {
+		problems = append(problems, fmt.Sprintf("a stream stored as chunks (--chunking %s)", j.Chunking))
+	}
+	switch j.Compressor {
+	case InternalCompressor, ZfsCompressor, "":
+	default:
+		if LookupCodec(j.Compressor) != nil {
+			problems = append(problems, fmt.Sprintf("volumes compressed with the codec %s", j.Compressor))
+		}
+	}
+	if j.External != "" {
+		problems = append(problems, fmt.Sprintf("a snapshot adopted from %s", j.External))
+	}
+	return problems
+}
@@ -0,0 +1,108 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ShredTempFiles is set to overwrite the volumes and other temporary files written by a job with zeros before
+// they are deleted, so the data they held, unencrypted unless encryption was requested, can not be read back
+// from the disk afterwards. It does not reach the blocks a copy-on-write filesystem (e.g. ZFS or btrfs)
+// already wrote them to.
+var ShredTempFiles bool
+
+// RemoveTempFile will delete a temporary file, overwriting it first if ShredTempFiles is set. The file is
+// deleted even if it could not be overwritten.
+func RemoveTempFile(path string) error {
+	var err error
+	if ShredTempFiles {
+		err = shredFile(path)
+	}
+	if rerr := os.Remove(path); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// RemoveTempDir will delete a temporary directory and everything in it, overwriting its files first if
+// ShredTempFiles is set. Everything is deleted even if some files could not be overwritten.
+func RemoveTempDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	var err error
+	if ShredTempFiles {
+		filepath.Walk(dir, func(path string, info os.FileInfo, ferr error) error {
+			if ferr != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+			if serr := shredFile(path); serr != nil && err == nil {
+				err = serr
+			}
+			return nil
+		})
+	}
+	if rerr := os.RemoveAll(dir); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// shredFile will overwrite the file at path with zeros and flush it to disk.
+func shredFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	buffers := BuffersOf(BufferSize)
+	buf := buffers.Get()
+	defer buffers.Put(buf)
+	// Pooled buffers hold whatever they were last used for
+	for i := range buf {
+		buf[i] = 0
+	}
+
+	for remaining := info.Size(); remaining > 0; {
+		n := int64(len(buf))
+		if remaining < n {
+			n = remaining
+		}
+		if _, err = f.Write(buf[:n]); err != nil {
+			f.Close()
+			return err
+		}
+		remaining -= n
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
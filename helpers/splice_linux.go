@@ -0,0 +1,130 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+//go:build linux
+// +build linux
+
+package helpers
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+const (
+	spliceMove = 0x1 // SPLICE_F_MOVE
+	spliceMore = 0x4 // SPLICE_F_MORE
+
+	// spliceChunk is the most moved by a single splice or tee, the default capacity of a pipe
+	spliceChunk = 64 * 1024
+)
+
+// spliceN moves n bytes from the pipe src to dst, a pipe or a file, within the kernel instead of copying them
+// through user space. It reports false, having moved nothing, if the kernel can't splice between the two so
+// the caller can copy them instead, and returns io.EOF if src ended before n bytes were moved.
+func spliceN(dst, src *os.File, n int64) (int64, bool, error) {
+	written, err := spliceFd(int(dst.Fd()), int(src.Fd()), n)
+	if err == syscall.EINVAL && written == 0 {
+		return 0, false, nil
+	}
+	return written, true, err
+}
+
+func spliceFd(dst, src int, n int64) (int64, error) {
+	var written int64
+	for written < n {
+		chunk := n - written
+		if chunk > spliceChunk {
+			chunk = spliceChunk
+		}
+		moved, err := syscall.Splice(src, nil, dst, nil, int(chunk), spliceMove|spliceMore)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return written, err
+		}
+		if moved == 0 {
+			return written, io.EOF
+		}
+		written += moved
+	}
+	return written, nil
+}
+
+// teeFile drains the pipe src into the file dst within the kernel, handing w a copy of everything moved,
+// duplicated with tee, for the hashes and counters that need to see it. It reports false if the kernel can't
+// tee or splice between them, once done with anything it already duplicated, so the caller can copy the rest.
+func teeFile(dst *os.File, w io.Writer, src *os.File) (bool, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return false, err
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	buffers := BuffersOf(spliceChunk)
+	buf := buffers.Get()
+	defer buffers.Put(buf)
+
+	in, out, teed := int(src.Fd()), int(dst.Fd()), int(pw.Fd())
+	var moved bool
+	for {
+		n, err := syscall.Tee(in, teed, len(buf), 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EINVAL && !moved {
+			return false, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		if n == 0 {
+			return true, nil
+		}
+
+		if _, err = spliceFd(out, in, n); err == syscall.EINVAL && !moved {
+			// The file can't be spliced to, write out the duplicate instead and drop what it duplicated
+			if _, err = io.ReadFull(pr, buf[:n]); err != nil {
+				return true, err
+			}
+			if _, err = dst.Write(buf[:n]); err != nil {
+				return true, err
+			}
+			if _, err = w.Write(buf[:n]); err != nil {
+				return true, err
+			}
+			_, err = io.CopyN(ioutil.Discard, src, n)
+			return false, err
+		} else if err != nil {
+			return true, err
+		}
+		moved = true
+
+		if _, err = io.ReadFull(pr, buf[:n]); err != nil {
+			return true, err
+		}
+		if _, err = w.Write(buf[:n]); err != nil {
+			return true, err
+		}
+	}
+}
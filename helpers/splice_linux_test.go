@@ -0,0 +1,193 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build linux
+// +build linux
+
+package helpers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pipeOf returns the read end of a pipe the data provided is written to, closed once written.
+func pipeOf(t *testing.T, data []byte) *os.File {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("could not create pipe: %v", err)
+	}
+	go func() {
+		pw.Write(data)
+		pw.Close()
+	}()
+	return pr
+}
+
+func TestSpliceN(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackupsplice")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 3*spliceChunk+1234)
+	if _, err = rand.Read(data); err != nil {
+		t.Fatalf("could not generate data: %v", err)
+	}
+	n := int64(2*spliceChunk + 100)
+
+	// To a file
+	src := pipeOf(t, data)
+	defer src.Close()
+	dst, err := os.Create(filepath.Join(dir, "spliced"))
+	if err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	defer dst.Close()
+	written, spliced, err := spliceN(dst, src, n)
+	if err != nil || !spliced || written != n {
+		t.Fatalf("expected %d bytes to be spliced, got %d (%v, %v)", n, written, spliced, err)
+	}
+	if got, _ := ioutil.ReadFile(dst.Name()); !bytes.Equal(got, data[:n]) {
+		t.Errorf("expected the file to hold the first %d bytes, got %d different bytes", n, len(got))
+	}
+
+	// The rest is left in the pipe, which ends before the next n bytes
+	written, spliced, err = spliceN(dst, src, n)
+	if err != io.EOF || !spliced || written != int64(len(data))-n {
+		t.Errorf("expected io.EOF after %d bytes, got %d (%v, %v)", int64(len(data))-n, written, spliced, err)
+	}
+	if got, _ := ioutil.ReadFile(dst.Name()); !bytes.Equal(got, data) {
+		t.Errorf("expected the file to hold all the data")
+	}
+
+	// To a pipe
+	src = pipeOf(t, data)
+	defer src.Close()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("could not create pipe: %v", err)
+	}
+	defer pr.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		got, _ := ioutil.ReadAll(pr)
+		received <- got
+	}()
+	written, spliced, err = spliceN(pw, src, int64(len(data)))
+	pw.Close()
+	if err != nil || !spliced || written != int64(len(data)) {
+		t.Errorf("expected %d bytes to be spliced, got %d (%v, %v)", len(data), written, spliced, err)
+	}
+	if got := <-received; !bytes.Equal(got, data) {
+		t.Errorf("expected the pipe to receive all the data, got %d bytes", len(got))
+	}
+
+	// A file opened to append to can't be spliced to, nothing is moved so the caller can copy instead
+	src = pipeOf(t, data)
+	defer src.Close()
+	appended, err := os.OpenFile(filepath.Join(dir, "appended"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("could not create file: %v", err)
+	}
+	defer appended.Close()
+	written, spliced, err = spliceN(appended, src, n)
+	if err != nil || spliced || written != 0 {
+		t.Errorf("expected the splice to fall back, got %d (%v, %v)", written, spliced, err)
+	}
+	if got, _ := ioutil.ReadAll(src); !bytes.Equal(got, data) {
+		t.Errorf("expected the data to be left in the pipe, got %d bytes", len(got))
+	}
+}
+
+func TestTeeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackuptee")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 5*spliceChunk+4321)
+	if _, err = rand.Read(data); err != nil {
+		t.Fatalf("could not generate data: %v", err)
+	}
+	expected := sha256.Sum256(data)
+
+	// Drains src into the file opened with the flags given, copying the rest if teeFile falls back
+	// like drainCompressor does, and returns whether it teed along with the digests of the file and w.
+	drain := func(name string, flag int, src *os.File) (bool, [sha256.Size]byte, [sha256.Size]byte) {
+		dst, ferr := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|flag, 0600)
+		if ferr != nil {
+			t.Fatalf("could not create file: %v", ferr)
+		}
+		defer dst.Close()
+		sum := sha256.New()
+		teed, terr := teeFile(dst, sum, src)
+		if terr != nil {
+			t.Fatalf("unexpected error draining %s: %v", name, terr)
+		}
+		if !teed {
+			if _, terr = io.Copy(io.MultiWriter(dst, sum), src); terr != nil {
+				t.Fatalf("could not copy the rest to %s: %v", name, terr)
+			}
+		}
+		content, _ := ioutil.ReadFile(dst.Name())
+		var digest [sha256.Size]byte
+		copy(digest[:], sum.Sum(nil))
+		return teed, sha256.Sum256(content), digest
+	}
+
+	// Teed and spliced within the kernel
+	src := pipeOf(t, data)
+	defer src.Close()
+	teed, fileDigest, digest := drain("teed", os.O_TRUNC, src)
+	if !teed || fileDigest != expected || digest != expected {
+		t.Errorf("expected the teed file and its copy to match the data, got %v, %x and %x", teed, fileDigest, digest)
+	}
+
+	// The file can't be spliced to, the first chunk is written out from the duplicate and the rest copied
+	src = pipeOf(t, data)
+	defer src.Close()
+	teed, fileDigest, digest = drain("appended", os.O_APPEND, src)
+	if teed || fileDigest != expected || digest != expected {
+		t.Errorf("expected the copied file and its copy to match the data, got %v, %x and %x", teed, fileDigest, digest)
+	}
+
+	// A regular file can't be teed from, nothing is moved
+	if err = ioutil.WriteFile(filepath.Join(dir, "source"), data, 0600); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+	file, err := os.Open(filepath.Join(dir, "source"))
+	if err != nil {
+		t.Fatalf("could not open file: %v", err)
+	}
+	defer file.Close()
+	teed, fileDigest, digest = drain("fromfile", os.O_TRUNC, file)
+	if teed || fileDigest != expected || digest != expected {
+		t.Errorf("expected the copied file and its copy to match the data, got %v, %x and %x", teed, fileDigest, digest)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+var (
+	// SSHHost is the [user@]host the zfs commands are run on over ssh, letting a central backup server pull
+	// the zfs send streams of agent hosts so that credentials and keys only live on the server. The zfs
+	// commands are run locally if empty.
+	SSHHost string
+	// SSHPort is the port to connect to SSHHost on, the ssh default is used if 0.
+	SSHPort int
+	// SSHIdentityFile is the private key to authenticate to SSHHost with, the ssh default is used if empty.
+	SSHIdentityFile string
+	// SSHPath is the path to the ssh binary.
+	SSHPath = "ssh"
+)
+
+// zfsCommand will return the command running the zfs (or zpool) binary at path with the given arguments,
+// on the SSHHost of the Environment of ctx when set. Its standard streams are those of the remote command.
+func zfsCommand(ctx context.Context, path string, args ...string) *exec.Cmd {
+	env := EnvironmentOf(ctx)
+	if env.SSHHost == "" {
+		return exec.CommandContext(ctx, path, args...)
+	}
+
+	// Never prompt for a password or host key confirmation, we have no terminal to do it from
+	sshArgs := []string{"-o", "BatchMode=yes"}
+	if env.SSHPort != 0 {
+		sshArgs = append(sshArgs, "-p", strconv.Itoa(env.SSHPort))
+	}
+	if env.SSHIdentityFile != "" {
+		sshArgs = append(sshArgs, "-i", env.SSHIdentityFile)
+	}
+
+	// The remote command is interpreted by the remote user's shell
+	quoted := []string{shellQuote(path)}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	sshArgs = append(sshArgs, "--", env.SSHHost, strings.Join(quoted, " "))
+	return exec.CommandContext(ctx, SSHPath, sshArgs...)
+}
+
+// shellQuote will quote s so that a POSIX shell reads it as a single word.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,86 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	testCases := []struct {
+		in     string
+		expect string
+	}{
+		{"tank/data@snap1", "tank/data@snap1"},
+		{"/sbin/zfs", "/sbin/zfs"},
+		{"name,creation", "name,creation"},
+		{"", "''"},
+		{"tank/my data", "'tank/my data'"},
+		{"it's", `'it'\''s'`},
+		{"'", `''\'''`},
+		{"$HOME", "'$HOME'"},
+		{"a;rm -rf /", "'a;rm -rf /'"},
+		{"`id`", "'`id`'"},
+		{"tank/*", "'tank/*'"},
+		{"line\nbreak", "'line\nbreak'"},
+	}
+
+	for idx, c := range testCases {
+		got := shellQuote(c.in)
+		if got != c.expect {
+			t.Errorf("%d: expected %s, got %s", idx, c.expect, got)
+		}
+		// The remote shell must read the quoted string back as the original word
+		out, err := exec.Command("sh", "-c", "printf %s "+got).Output()
+		if err != nil || string(out) != c.in {
+			t.Errorf("%d: expected the shell to read %q, got %q (%v)", idx, c.in, out, err)
+		}
+	}
+}
+
+func TestZFSCommand(t *testing.T) {
+	oldHost, oldPort, oldIdentity := SSHHost, SSHPort, SSHIdentityFile
+	defer func() { SSHHost, SSHPort, SSHIdentityFile = oldHost, oldPort, oldIdentity }()
+
+	testCases := []struct {
+		host     string
+		port     int
+		identity string
+		args     []string
+		expect   []string
+	}{
+		{"", 0, "", []string{"list", "-H", "tank/my data"}, []string{"zfs", "list", "-H", "tank/my data"}},
+		{"backup@agent", 0, "", []string{"list", "-H", "tank/data"}, []string{"ssh", "-o", "BatchMode=yes", "--", "backup@agent", "zfs list -H tank/data"}},
+		{"agent", 2222, "/root/.ssh/id", []string{"send", "tank/my data@$snap"}, []string{"ssh", "-o", "BatchMode=yes", "-p", "2222", "-i", "/root/.ssh/id", "--", "agent", "zfs send 'tank/my data@$snap'"}},
+		{"agent", 0, "", []string{"get", "-o", "value", ""}, []string{"ssh", "-o", "BatchMode=yes", "--", "agent", "zfs get -o value ''"}},
+	}
+
+	for idx, c := range testCases {
+		SSHHost, SSHPort, SSHIdentityFile = c.host, c.port, c.identity
+		cmd := zfsCommand(context.Background(), "zfs", c.args...)
+		if !reflect.DeepEqual(cmd.Args, c.expect) {
+			t.Errorf("%d: expected %q, got %q", idx, c.expect, cmd.Args)
+		}
+	}
+}
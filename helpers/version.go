@@ -27,6 +27,8 @@ const (
 	VersionNumber = .3
 	// ProgramName is the name for zfsbackup
 	ProgramName = "zfsbackup"
+	// ManifestSchemaVersion is the version of the manifests written by zfsbackup, see MigrateManifest
+	ManifestSchemaVersion = 2
 )
 
 // Version will return the current version of zfsbackup
@@ -39,7 +39,6 @@ import (
 
 	"github.com/dustin/go-humanize"
 	"github.com/juju/ratelimit"
-	gzip "github.com/klauspost/pgzip"
 	"github.com/miolini/datacounter"
 	"golang.org/x/crypto/openpgp"
 	"golang.org/x/crypto/openpgp/packet"
@@ -58,9 +57,20 @@ var (
 const (
 	// BufferSize is the size of various buffers and copy limits around the application
 	BufferSize = 256 * humanize.KiByte // 256KiB
+	// DefaultSendReadSize is the size, in KiB, of the reads of the zfs send stream volumes are split between
+	DefaultSendReadSize = 512
+	// DefaultCompressorBlockSize is the size, in KiB, of the blocks the internal compressor compresses in parallel
+	DefaultCompressorBlockSize = 1024
+	// DefaultUploadReadSize is the size, in KiB, of the reads of the uploaders streaming volumes
+	DefaultUploadReadSize = 256
 	// InternalCompressor is the key used to indicate we want to utilize the internal compressor
 	InternalCompressor = "internal"
 	ZfsCompressor      = "zfs"
+	// ContentVolumePrefix is the prefix of the name of the volumes stored by their content, see ContentVolumeObjectName
+	ContentVolumePrefix = "content"
+	// ManifestHistoryExtension separates the name of a manifest from the time a record of it was written at, see
+	// ManifestHistoryObjectName
+	ManifestHistoryExtension = ".history-"
 )
 
 // VolumeInfo holds all necessary information for a Volume as part of a backup
@@ -72,6 +82,9 @@ type VolumeInfo struct {
 	CRC32C          hash.Hash32 `json:"-"`
 	SHA1            hash.Hash   `json:"-"`
 	SHA1Sum         string      `json:"-"`
+	Digest          hash.Hash   `json:"-"` // Computes the second digest of a volume whose DigestAlgorithm is not SHA256
+	DigestAlgorithm string      `json:",omitempty"`
+	DigestSum       string      `json:",omitempty"`
 	SHA256Sum       string
 	MD5Sum          string
 	CRC32CSum32     uint32
@@ -81,8 +94,13 @@ type VolumeInfo struct {
 	CloseTime       time.Time
 	IsManifest      bool
 	IsFinalManifest bool
+	IsParity        bool          `json:",omitempty"`
+	IsLayout        bool          `json:",omitempty"`
+	UploadTime      time.Duration `json:",omitempty"` // Time spent uploading the volume to every destination
+	Retries         int           `json:",omitempty"` // Uploads of the volume that failed and were retried
 
 	filename string
+	env      *Environment // Limits and pauses the reads of the volume, see OpenVolume
 	w        io.Writer
 	r        io.Reader
 	bufw     *bufio.Writer
@@ -90,19 +108,32 @@ type VolumeInfo struct {
 	// Pipe Objects
 	pw *io.PipeWriter
 	pr *io.PipeReader
+	// Digests the volume must match before its reader can reach its end
+	expected *VolumeInfo
+	// Tee Objects, for a pipe read by more than one destination
+	tee      *teeWriter
+	branches []*VolumeInfo
 	// (de)compressor objects
 	cw  io.WriteCloser
 	rw  io.ReadCloser
 	cmd *exec.Cmd
+	// The external compressor's ends, spliced into and drained from where the platform allows it
+	stdin    *os.File
+	noSplice bool
+	drained  chan error
 	// PGP objects
 	pgpw io.WriteCloser
 	pgpr *openpgp.MessageDetails
+	// Names the volume once its content is known, see ContentVolumeObjectName
+	nameByContent func(sha256sum string) string
 	// Detail Objects
+	sums      io.Writer
 	counter   *datacounter.WriterCounter
 	usingPipe bool
 	isClosed  bool
 	isOpened  bool
 	lock      sync.Mutex
+	statsLock sync.Mutex
 }
 
 // ByVolumeNumber is used to sort a VolumeInfo slice by VolumeNumber.
@@ -117,6 +148,20 @@ func (v *VolumeInfo) Counter() uint64 {
 	return v.counter.Count()
 }
 
+// Flush will have the compressor of the volume, if any, write out what it holds so Counter accounts for
+// everything written to the volume so far. It returns false if the compressor can not be flushed, e.g. an
+// external binary.
+func (v *VolumeInfo) Flush() (bool, error) {
+	if v.cw == nil {
+		return true, nil
+	}
+	f, ok := v.cw.(interface{ Flush() error })
+	if !ok {
+		return false, nil
+	}
+	return true, f.Flush()
+}
+
 // Read will passthru the command to the underlying io.Reader, which will be setup
 // to ratelimit where applicable.
 func (v *VolumeInfo) Read(p []byte) (int, error) {
@@ -159,7 +204,8 @@ func (v *VolumeInfo) ReadAt(p []byte, off int64) (int, error) {
 }
 
 // OpenVolume will open this VolumeInfo in a read-only mode. It will automatically
-// rate limit the amount of bytes that can be read at a time so no buffer should
+// rate limit the amount of bytes that can be read at a time, and block reads while
+// the transfers of the Environment it was created in are paused, so no buffer should
 // be used for reading from this Reader.
 // Only valid to be called after creating a new Volume and closing it or when
 // a MaxFileBuffer of 0 in which case this does nothing.
@@ -175,9 +221,7 @@ func (v *VolumeInfo) OpenVolume() error {
 	v.r = f
 	v.isClosed = false
 	v.isOpened = true
-	if BackupUploadBucket != nil {
-		v.r = ratelimit.Reader(v.r, BackupUploadBucket)
-	}
+	v.r = v.environment().throttled(v.r)
 
 	return nil
 }
@@ -208,7 +252,7 @@ func (v *VolumeInfo) Extract(ctx context.Context, j *JobInfo, isManifest bool) e
 		config := new(packet.Config)
 		config.DefaultCompressionAlgo = packet.CompressionNone // We will do our own, thank you very much!
 		config.DefaultCipher = packet.CipherAES256
-		pgpReader, perr := openpgp.ReadMessage(v.r, getCombinedKeyRing(), promptFunc, config)
+		pgpReader, perr := openpgp.ReadMessage(v.r, EnvironmentOf(ctx).keyRing(), promptFunc, config)
 		if perr != nil {
 			return perr
 		}
@@ -216,23 +260,29 @@ func (v *VolumeInfo) Extract(ctx context.Context, j *JobInfo, isManifest bool) e
 		v.r = pgpReader.UnverifiedBody
 	}
 
-	var err error
 	compressor := j.Compressor
 	if isManifest {
 		compressor = InternalCompressor
 	}
 
 	switch compressor {
-	case InternalCompressor:
-		v.rw, err = gzip.NewReader(v.r)
-		if err != nil {
-			return err
-		}
-		v.r = v.rw
 	case "":
 	case ZfsCompressor:
 	default:
-		v.cmd = exec.CommandContext(ctx, compressor, "-c", "-d")
+		if codec := LookupCodec(compressor); codec != nil {
+			rw, err := codec.Decompress(ctx, v.r)
+			if err != nil {
+				return err
+			}
+			v.rw = rw
+			v.r = v.rw
+			break
+		}
+		if _, err := exec.LookPath(compressor); err != nil {
+			return fmt.Errorf("no codec is registered as the compressor %s and it is not an executable found in the PATH (provide it with --codec) - %v", compressor, err)
+		}
+		path, args := prioritized(compressor, "-c", "-d")
+		v.cmd = exec.CommandContext(ctx, path, args...)
 		v.cmd.Stdin = v.r
 
 		decompressor, err := v.cmd.StdoutPipe()
@@ -248,13 +298,89 @@ func (v *VolumeInfo) Extract(ctx context.Context, j *JobInfo, isManifest bool) e
 	return nil
 }
 
+// Branches returns a reader of the volume for every destination it is teed to, nil unless the volume is a
+// pipe created for more than one destination. Each branch must be read, and closed, for the volume to be written.
+func (v *VolumeInfo) Branches() []*VolumeInfo {
+	for _, b := range v.branches {
+		b.ObjectName = v.ObjectName
+		b.VolumeNumber = v.VolumeNumber
+		b.IsManifest = v.IsManifest
+	}
+	return v.branches
+}
+
+// RecordUpload will add an upload of the volume to a destination, which took d and was retried retries
+// times, to its statistics. A volume may be uploaded to several destinations at once.
+func (v *VolumeInfo) RecordUpload(d time.Duration, retries int) {
+	v.statsLock.Lock()
+	defer v.statsLock.Unlock()
+	v.UploadTime += d
+	v.Retries += retries
+}
+
+// CopyAs returns a copy of the closed volume, read from the same local file, to be stored as objectName.
+func (v *VolumeInfo) CopyAs(objectName string) *VolumeInfo {
+	return &VolumeInfo{
+		ObjectName:      objectName,
+		VolumeNumber:    v.VolumeNumber,
+		SHA1Sum:         v.SHA1Sum,
+		DigestAlgorithm: v.DigestAlgorithm,
+		DigestSum:       v.DigestSum,
+		SHA256Sum:       v.SHA256Sum,
+		MD5Sum:          v.MD5Sum,
+		CRC32CSum32:     v.CRC32CSum32,
+		Size:            v.Size,
+		ZFSStreamBytes:  v.ZFSStreamBytes,
+		CreateTime:      v.CreateTime,
+		CloseTime:       v.CloseTime,
+		IsManifest:      v.IsManifest,
+		IsFinalManifest: v.IsFinalManifest,
+		IsParity:        v.IsParity,
+		IsLayout:        v.IsLayout,
+		filename:        v.filename,
+		env:             v.env,
+		usingPipe:       v.usingPipe,
+		isClosed:        true,
+	}
+}
+
+// StagedPath returns the path of the local file holding the volume, empty if the volume is a pipe.
+func (v *VolumeInfo) StagedPath() string {
+	return v.filename
+}
+
+// UseStagedFile will set the local file holding the volume, e.g. to upload a volume written by a previous
+// run whose information was read back from disk. The volume is expected to be closed.
+func (v *VolumeInfo) UseStagedFile(path string) {
+	v.filename = path
+}
+
 // DeleteVolume will delete the volume from the temporary directory it was written to.
 // Only valid to be called after creating a new Volume and closing it.
 func (v *VolumeInfo) DeleteVolume() error {
 	if v.usingPipe {
 		return nil // Nothing to delete
 	}
-	return os.Remove(v.filename)
+	return RemoveTempFile(v.filename)
+}
+
+// environment will return the Environment the volume was created in, the one of the package level variables if
+// it was not created by this package.
+func (v *VolumeInfo) environment() *Environment {
+	if v.env == nil {
+		return EnvironmentOf(context.Background())
+	}
+	return v.env
+}
+
+// discard will close and delete the temporary file of a volume that could not be prepared.
+func (v *VolumeInfo) discard() {
+	if v.fw != nil {
+		v.fw.Close()
+		if err := RemoveTempFile(v.filename); err != nil && !os.IsNotExist(err) {
+			v.environment().Logger.Warningf("Could not delete the temporary file %s - %v", v.filename, err)
+		}
+	}
 }
 
 // Write writes through to the underlying writer, satisfying the io.Writer interface.
@@ -262,6 +388,20 @@ func (v *VolumeInfo) Write(p []byte) (int, error) {
 	return v.w.Write(p)
 }
 
+// CopyFrom writes the next n bytes read from the pipe src to the volume, returning io.EOF if src ends before
+// then. They are spliced straight into an external compressor on platforms that allow it instead of being
+// copied through user space.
+func (v *VolumeInfo) CopyFrom(src *os.File, n int64) (int64, error) {
+	if v.stdin != nil && !v.noSplice {
+		written, spliced, err := spliceN(v.stdin, src, n)
+		if spliced {
+			return written, err
+		}
+		v.noSplice = true
+	}
+	return CopyN(v, src, n)
+}
+
 // Close should be called after creating a new volume or after calling OpenVolume
 func (v *VolumeInfo) Close() error {
 	// Protect against multiple calls to this function
@@ -273,7 +413,7 @@ func (v *VolumeInfo) Close() error {
 	}
 	v.isClosed = true
 
-	if !v.isOpened || v.pw != nil {
+	if !v.isOpened || v.pw != nil || v.tee != nil {
 		v.CloseTime = time.Now()
 	}
 
@@ -281,13 +421,15 @@ func (v *VolumeInfo) Close() error {
 		v.isOpened = false
 	}
 
-	// Close the (de)compressor, if any
-	if v.cw != nil || v.rw != nil {
+	// Close the (de)compressor, if any. A piped volume's decompressor is left to
+	// its reader, which can only finish once the pipe is closed below.
+	if v.cw != nil || (v.rw != nil && v.pw == nil) {
 		if v.cw != nil {
 			if err := v.cw.Close(); err != nil {
 				return err
 			}
 			v.cw = nil
+			v.stdin = nil
 		}
 
 		if v.rw != nil {
@@ -304,6 +446,14 @@ func (v *VolumeInfo) Close() error {
 			}
 			v.cmd = nil
 		}
+
+		// And for what it output to be written out
+		if v.drained != nil {
+			if err := <-v.drained; err != nil {
+				return err
+			}
+			v.drained = nil
+		}
 	}
 
 	// Close the (de/en)crypter, if any
@@ -323,33 +473,10 @@ func (v *VolumeInfo) Close() error {
 	// Flush the buffered writer
 	if v.bufw != nil {
 		v.bufw.Flush()
+		putBufWriter(v.bufw)
 		v.bufw = nil
 	}
 
-	// Finally, close the actual file or Pipe
-	if v.fw != nil {
-		if err := v.fw.Close(); err != nil {
-			return err
-		}
-		v.fw = nil
-	}
-
-	if v.pw != nil {
-		// Special case for when we are using pipes, make the volume think its still
-		// open and needs to be closed by the reader.
-		v.isClosed = false
-		v.isOpened = true
-		if err := v.pw.Close(); err != nil {
-			return err
-		}
-		v.pw = nil
-	} else if v.pr != nil {
-		if err := v.pr.Close(); err != nil {
-			return err
-		}
-		v.pr = nil
-	}
-
 	// Record computed metrics and release resources
 	if v.counter != nil {
 		v.Size = v.counter.Count()
@@ -359,6 +486,10 @@ func (v *VolumeInfo) Close() error {
 	if v.SHA256 != nil {
 		v.SHA256Sum = fmt.Sprintf("%x", v.SHA256.Sum(nil))
 		v.SHA256 = nil
+		if v.nameByContent != nil {
+			v.ObjectName = v.nameByContent(v.SHA256Sum)
+			v.nameByContent = nil
+		}
 	}
 
 	if v.CRC32C != nil {
@@ -376,6 +507,55 @@ func (v *VolumeInfo) Close() error {
 		v.SHA1 = nil
 	}
 
+	if v.Digest != nil {
+		v.DigestSum = fmt.Sprintf("%x", v.Digest.Sum(nil))
+		v.Digest = nil
+	}
+
+	// Let the readers of a teed volume know what they read before they reach its end
+	for _, b := range v.branches {
+		b.Size = v.Size
+		b.SHA256Sum, b.CRC32CSum32, b.MD5Sum, b.SHA1Sum = v.SHA256Sum, v.CRC32CSum32, v.MD5Sum, v.SHA1Sum
+		b.DigestAlgorithm, b.DigestSum = v.DigestAlgorithm, v.DigestSum
+	}
+
+	// Finally, close the actual file or Pipe
+	if v.fw != nil {
+		if err := v.fw.Close(); err != nil {
+			return err
+		}
+		v.fw = nil
+	}
+
+	if v.pw != nil {
+		// Special case for when we are using pipes, make the volume think its still
+		// open and needs to be closed by the reader.
+		v.isClosed = false
+		v.isOpened = true
+		if v.expected != nil {
+			// Fail the reader instead of letting it take a volume that doesn't match its digests as complete
+			if err := v.VerifyDigests(v.expected); err != nil {
+				v.pw.CloseWithError(err)
+				v.pw = nil
+				return err
+			}
+		}
+		if err := v.pw.Close(); err != nil {
+			return err
+		}
+		v.pw = nil
+	} else if v.tee != nil {
+		if err := v.tee.Close(); err != nil {
+			return err
+		}
+		v.tee = nil
+	} else if v.pr != nil {
+		if err := v.pr.Close(); err != nil {
+			return err
+		}
+		v.pr = nil
+	}
+
 	v.w = nil
 	if v.pr == nil {
 		v.r = nil
@@ -396,26 +576,41 @@ func (v *VolumeInfo) CopyTo(dest string) (err error) {
 		return
 	}
 	defer out.Close()
-	if _, err = io.Copy(out, in); err != nil {
+	if _, err = Copy(out, in); err != nil {
 		return
 	}
 	err = out.Sync()
 	return
 }
 
-// prepareVolume returns a VolumeInfo, filename parts, extension parts, and an error
+// prepareVolume returns a VolumeInfo and an error
 // compress -> encrypt/sign -> output
-func prepareVolume(ctx context.Context, j *JobInfo, pipe bool, isManifest bool) (*VolumeInfo, []string, []string, error) {
-	v, err := CreateSimpleVolume(ctx, pipe)
+func prepareVolume(ctx context.Context, j *JobInfo, pipe bool, isManifest bool) (vol *VolumeInfo, err error) {
+	dir := EnvironmentOf(ctx).TempDir
+	if !isManifest && j.StagingDir != "" {
+		dir = j.StagingDir
+	}
+	pipes := 0
+	if pipe {
+		// A pipe can only be read once, so it is teed to every destination
+		pipes = len(j.Destinations)
+		if pipes < 1 {
+			pipes = 1
+		}
+	}
+	v, err := createSimpleVolume(ctx, pipes, j.DigestAlgorithm, dir)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
-
-	extensions := make([]string, 0, 2)
+	// Don't leave the temporary file of a volume we could not prepare behind
+	defer func() {
+		if err != nil {
+			v.discard()
+		}
+	}()
 
 	// Prepare the Encryption/Signing writer, if required
 	if j.EncryptKey != nil || j.SignKey != nil {
-		extensions = append(extensions, "pgp")
 		config := new(packet.Config)
 		config.DefaultCompressionAlgo = packet.CompressionNone // We will do our own, thank you very much!
 		config.DefaultCipher = packet.CipherAES256
@@ -423,7 +618,7 @@ func prepareVolume(ctx context.Context, j *JobInfo, pipe bool, isManifest bool)
 		fileHints.IsBinary = true
 		pgpWriter, err := openpgp.Encrypt(v.w, []*openpgp.Entity{j.EncryptKey}, j.SignKey, fileHints, config)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
 		v.pgpw = pgpWriter
 		v.w = pgpWriter
@@ -436,51 +631,195 @@ func prepareVolume(ctx context.Context, j *JobInfo, pipe bool, isManifest bool)
 
 	// Prepare the compression writer, if any
 	switch compressorName {
-	case InternalCompressor:
-		v.cw, _ = gzip.NewWriterLevel(v.w, j.CompressionLevel)
-		v.w = v.cw
-		extensions = append([]string{"gz"}, extensions...)
-		printCompressCMD.Do(func() {
-			AppLogger.Infof("Will be using internal gzip compressor with compression level %d.", j.CompressionLevel)
-		})
 	case "":
-		printCompressCMD.Do(func() { AppLogger.Infof("Will not be using any compression.") })
+		printCompressCMD.Do(func() { EnvironmentOf(ctx).Logger.Infof("Will not be using any compression.") })
 	case ZfsCompressor:
-		printCompressCMD.Do(func() { AppLogger.Infof("Will send a ZFS compressed stream") })
+		printCompressCMD.Do(func() { EnvironmentOf(ctx).Logger.Infof("Will send a ZFS compressed stream") })
 	default:
-		extensions = append([]string{compressorName}, extensions...)
+		if codec := LookupCodec(compressorName); codec != nil {
+			blockSize := j.CompressBlockSize
+			if blockSize <= 0 {
+				blockSize = DefaultCompressorBlockSize
+			}
+			cw, err := codec.Compress(ctx, v.w, CodecOptions{Level: j.CompressionLevel, BlockSize: blockSize * humanize.KiByte})
+			if err != nil {
+				return nil, err
+			}
+			v.cw = cw
+			v.w = v.cw
+			printCompressCMD.Do(func() {
+				if compressorName == InternalCompressor {
+					EnvironmentOf(ctx).Logger.Infof("Will be using internal gzip compressor with compression level %d.", j.CompressionLevel)
+				} else {
+					EnvironmentOf(ctx).Logger.Infof("Will be using the %s codec for compression with compression level %d.", compressorName, j.CompressionLevel)
+				}
+			})
+			break
+		}
 
-		v.cmd = exec.CommandContext(ctx, compressorName, "-c", fmt.Sprintf("-%d", j.CompressionLevel))
-		v.cmd.Stdout = v.w
+		path, args := prioritized(compressorName, "-c", fmt.Sprintf("-%d", j.CompressionLevel))
+		v.cmd = exec.CommandContext(ctx, path, args...)
+		v.cmd.Stderr = os.Stderr
 
-		compressor, err := v.cmd.StdinPipe()
+		// The compressor is fed through a pipe of our own so the zfs stream can be spliced into it
+		stdin, compressor, err := os.Pipe()
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
+		}
+		v.cmd.Stdin = stdin
+
+		// and drains into one too when staging to a file, to splice its output there
+		var stdout, output *os.File
+		if v.fw != nil && v.pgpw == nil {
+			if stdout, output, err = os.Pipe(); err != nil {
+				stdin.Close()
+				compressor.Close()
+				return nil, err
+			}
+			v.cmd.Stdout = output
+		} else {
+			v.cmd.Stdout = v.w
 		}
 		v.cw = compressor
 		v.w = v.cw
-		v.cmd.Stderr = os.Stderr
+		v.stdin = compressor
 
 		printCompressCMD.Do(func() {
-			AppLogger.Infof("Will be using the external binary %s for compression with compression level %d. The executing command will be: %s", j.Compressor, j.CompressionLevel, strings.Join(v.cmd.Args, " "))
+			EnvironmentOf(ctx).Logger.Infof("Will be using the external binary %s for compression with compression level %d. The executing command will be: %s", j.Compressor, j.CompressionLevel, strings.Join(v.cmd.Args, " "))
 		})
 
 		err = v.cmd.Start()
+		stdin.Close()
+		if output != nil {
+			output.Close()
+		}
 		if err != nil {
-			return nil, nil, nil, err
+			compressor.Close()
+			if stdout != nil {
+				stdout.Close()
+			}
+			return nil, err
+		}
+		if stdout != nil {
+			v.drainCompressor(stdout)
 		}
 
 		// TODO: Signal properly if the process closes prematurely
 	}
 
+	return v, nil
+}
+
+// drainCompressor writes what the external compressor outputs to the staged file in the background, splicing
+// it there and only reading a copy of it for the hashes where the platform allows it.
+func (v *VolumeInfo) drainCompressor(stdout *os.File) {
+	// The staged file is written to directly, so only count what is hashed
+	v.counter = datacounter.NewWriterCounter(v.sums)
+	v.drained = make(chan error, 1)
+	go func() {
+		// Closing it stops the compressor, should the file not be written to anymore
+		defer stdout.Close()
+		teed, err := teeFile(v.fw, v.counter, stdout)
+		if !teed && err == nil {
+			_, err = Copy(io.MultiWriter(v.fw, v.counter), stdout)
+		}
+		v.drained <- err
+	}()
+}
+
+// objectNameParts returns the filename parts shared by every object of the backup set described by the JobInfo
+func objectNameParts(j *JobInfo) []string {
 	nameParts := []string{j.VolumeName}
 	if j.IncrementalSnapshot.Name != "" {
 		nameParts = append(nameParts, j.IncrementalSnapshot.Name, "to", j.BaseSnapshot.Name)
 	} else {
 		nameParts = append(nameParts, j.BaseSnapshot.Name)
 	}
+	return nameParts
+}
+
+// objectExtensions returns the extension parts matching the compression and encryption/signing of an object
+func objectExtensions(j *JobInfo, isManifest bool) []string {
+	extensions := make([]string, 0, 2)
+
+	compressorName := j.Compressor
+	if isManifest {
+		compressorName = InternalCompressor
+	}
+
+	switch compressorName {
+	case "", ZfsCompressor:
+	default:
+		if codec := LookupCodec(compressorName); codec != nil {
+			extensions = append(extensions, codec.Extension())
+		} else {
+			extensions = append(extensions, compressorName)
+		}
+	}
 
-	return v, nameParts, extensions, nil
+	if j.EncryptKey != nil || j.SignKey != nil {
+		extensions = append(extensions, "pgp")
+	}
+	return extensions
+}
+
+// ManifestObjectName returns the name the manifest of the backup set described by the JobInfo is stored as.
+func ManifestObjectName(j *JobInfo) string {
+	nameParts := append([]string{j.ManifestPrefix}, objectNameParts(j)...)
+	extensions := append([]string{"manifest"}, objectExtensions(j, true)...)
+	return fmt.Sprintf("%s.%s", strings.Join(nameParts, j.Separator), strings.Join(extensions, "."))
+}
+
+// IsManifestObjectName reports whether the object name provided is the name of a manifest stored with
+// the encryption/signing of the JobInfo, whatever manifest prefix and separator it was stored with.
+func IsManifestObjectName(j *JobInfo, objectName string) bool {
+	extensions := append([]string{"manifest"}, objectExtensions(j, true)...)
+	return strings.HasSuffix(objectName, "."+strings.Join(extensions, "."))
+}
+
+// manifestHistoryTimeFormat formats the time a record of the history of a manifest was written at so records sort by it
+const manifestHistoryTimeFormat = "20060102T150405.000000000Z"
+
+// ManifestHistoryObjectName returns the name the record of the manifest stored as manifestObjectName, written at the
+// time provided, is kept as in the history of the manifest.
+func ManifestHistoryObjectName(manifestObjectName string, written time.Time) string {
+	return fmt.Sprintf("%s%s%s", manifestObjectName, ManifestHistoryExtension, written.UTC().Format(manifestHistoryTimeFormat))
+}
+
+// ParseManifestHistoryObjectName returns the name of the manifest the record stored as objectName is of and the time
+// it was written at. It returns false if objectName is not the name of a record of the history of a manifest.
+func ParseManifestHistoryObjectName(objectName string) (string, time.Time, bool) {
+	idx := strings.LastIndex(objectName, ManifestHistoryExtension)
+	if idx < 0 {
+		return "", time.Time{}, false
+	}
+	written, err := time.Parse(manifestHistoryTimeFormat, objectName[idx+len(ManifestHistoryExtension):])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return objectName[:idx], written, true
+}
+
+// BackupVolumeObjectName returns the name the given volume of the backup set described by the JobInfo is stored as.
+func BackupVolumeObjectName(j *JobInfo, volnum int64) string {
+	extensions := append([]string{"zstream"}, objectExtensions(j, false)...)
+	extensions = append(extensions, fmt.Sprintf("vol%d", volnum))
+	return fmt.Sprintf("%s.%s", strings.Join(objectNameParts(j), j.Separator), strings.Join(extensions, "."))
+}
+
+// ContentVolumeObjectName returns the name a volume of the backup set described by the JobInfo is stored as
+// when volumes are stored by their content, i.e. the SHA256 digest provided. Volumes of the same content, of
+// this or any other backup set, share the object.
+func ContentVolumeObjectName(j *JobInfo, sha256sum string) string {
+	extensions := append([]string{"zstream"}, objectExtensions(j, false)...)
+	return fmt.Sprintf("%s%s%s.%s", ContentVolumePrefix, j.Separator, sha256sum, strings.Join(extensions, "."))
+}
+
+// ParityVolumeObjectName returns the name the given parity volume of the backup set described by the JobInfo is stored as.
+func ParityVolumeObjectName(j *JobInfo, number int64) string {
+	extensions := append([]string{"zstream"}, objectExtensions(j, false)...)
+	extensions = append(extensions, fmt.Sprintf("parity%d", number))
+	return fmt.Sprintf("%s.%s", strings.Join(objectNameParts(j), j.Separator), strings.Join(extensions, "."))
 }
 
 // CreateManifestVolume will call CreateSimpleVolume and add options to compress,
@@ -488,18 +827,12 @@ func prepareVolume(ctx context.Context, j *JobInfo, pipe bool, isManifest bool)
 // It will also name the file accordingly as a manifest file.
 func CreateManifestVolume(ctx context.Context, j *JobInfo) (*VolumeInfo, error) {
 	// Create and name the manifest file
-	extensions := []string{"manifest"}
-	nameParts := []string{j.ManifestPrefix}
-
-	v, baseParts, ext, err := prepareVolume(ctx, j, false, true)
+	v, err := prepareVolume(ctx, j, false, true)
 	if err != nil {
 		return nil, err
 	}
 
-	extensions = append(extensions, ext...)
-	nameParts = append(nameParts, baseParts...)
-
-	v.ObjectName = fmt.Sprintf("%s.%s", strings.Join(nameParts, j.Separator), strings.Join(extensions, "."))
+	v.ObjectName = ManifestObjectName(j)
 	v.IsManifest = true
 
 	return v, nil
@@ -510,23 +843,41 @@ func CreateManifestVolume(ctx context.Context, j *JobInfo) (*VolumeInfo, error)
 // It will also name the file accordingly as a volume as part of backup set.
 func CreateBackupVolume(ctx context.Context, j *JobInfo, volnum int64) (*VolumeInfo, error) {
 	// Create and name the backup file
-	extensions := []string{"zstream"}
-
 	pipe := false
 	if j.MaxFileBuffer == 0 {
 		pipe = true
 	}
 
-	v, nameParts, ext, err := prepareVolume(ctx, j, pipe, false)
+	v, err := prepareVolume(ctx, j, pipe, false)
 	if err != nil {
 		return nil, err
 	}
 
 	v.VolumeNumber = volnum
-	extensions = append(extensions, ext...)
-	extensions = append(extensions, fmt.Sprintf("vol%d", v.VolumeNumber))
+	v.ObjectName = BackupVolumeObjectName(j, volnum)
+	if j.ContentAddressed && !pipe {
+		// Renamed once closed, when its digest is known
+		v.nameByContent = func(sha256sum string) string {
+			return ContentVolumeObjectName(j, sha256sum)
+		}
+	}
 
-	v.ObjectName = fmt.Sprintf("%s.%s", strings.Join(nameParts, j.Separator), strings.Join(extensions, "."))
+	return v, nil
+}
+
+// CreateLayoutVolume will call CreateSimpleVolume and add options to compress,
+// encrypt, and/or sign the file as it is written depending on the provided options.
+// It will also name the file accordingly as a volume of the backup set, holding how
+// its zfs send stream is put back together from chunks, see ChunkingCDC.
+func CreateLayoutVolume(ctx context.Context, j *JobInfo, volnum int64) (*VolumeInfo, error) {
+	v, err := prepareVolume(ctx, j, false, false)
+	if err != nil {
+		return nil, err
+	}
+
+	v.VolumeNumber = volnum
+	v.ObjectName = BackupVolumeObjectName(j, volnum)
+	v.IsLayout = true
 
 	return v, nil
 }
@@ -535,25 +886,65 @@ func CreateBackupVolume(ctx context.Context, j *JobInfo, volnum int64) (*VolumeI
 // MaxParallelUploads is set to 0, no temporary file will be used and an OS Pipe
 // will be used instead.
 func CreateSimpleVolume(ctx context.Context, pipe bool) (*VolumeInfo, error) {
-	v := &VolumeInfo{
-		SHA256:     sha256.New(),
-		CRC32C:     crc32.New(crc32.MakeTable(crc32.Castagnoli)),
-		MD5:        md5.New(),
-		SHA1:       sha1.New(),
-		CreateTime: time.Now(),
-	}
+	return CreateDigestVolume(ctx, pipe, DigestSHA256)
+}
 
+// CreateDigestVolume will create a volume like CreateSimpleVolume, computing a second digest with the algorithm
+// provided, e.g. to check a downloaded volume against the digests recorded in its manifest.
+func CreateDigestVolume(ctx context.Context, pipe bool, algorithm string) (*VolumeInfo, error) {
+	pipes := 0
 	if pipe {
+		pipes = 1
+	}
+	return createSimpleVolume(ctx, pipes, algorithm, EnvironmentOf(ctx).TempDir)
+}
+
+// createSimpleVolume will create a temporary file in dir to write to, or a pipe if pipes is 1, or a pipe teed
+// to that many readers if more.
+func createSimpleVolume(ctx context.Context, pipes int, digest string, dir string) (*VolumeInfo, error) {
+	env := EnvironmentOf(ctx)
+	v := &VolumeInfo{
+		env:             env,
+		SHA256:          sha256.New(),
+		CRC32C:          crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+		MD5:             md5.New(),
+		SHA1:            sha1.New(),
+		DigestAlgorithm: digest,
+		CreateTime:      time.Now(),
+	}
+	// The SHA256 digest is always recorded, another algorithm adds a second digest
+	if digest == "" || digest == DigestSHA256 {
+		v.DigestAlgorithm = DigestSHA256
+	} else {
+		d, err := newDigest(digest)
+		if err != nil {
+			return nil, err
+		}
+		v.Digest = d
+	}
+
+	if pipes > 1 {
+		v.tee = new(teeWriter)
+		for i := 0; i < pipes; i++ {
+			b := &VolumeInfo{CreateTime: v.CreateTime, env: env, isOpened: true, usingPipe: true}
+			var pw *io.PipeWriter
+			b.pr, pw = io.Pipe()
+			b.r = env.throttled(b.pr)
+			v.tee.pws = append(v.tee.pws, pw)
+			v.branches = append(v.branches, b)
+		}
+		v.w = v.tee
+		v.isOpened = true
+		v.usingPipe = true
+	} else if pipes == 1 {
 		v.pr, v.pw = io.Pipe()
 		v.r = v.pr
 		v.w = v.pw
 		v.isOpened = true
 		v.usingPipe = true
-		if BackupUploadBucket != nil {
-			v.r = ratelimit.Reader(v.r, BackupUploadBucket)
-		}
+		v.r = env.throttled(v.r)
 	} else {
-		tempFile, err := ioutil.TempFile(BackupTempdir, LogModuleName)
+		tempFile, err := ioutil.TempFile(dir, LogModuleName)
 		if err != nil {
 			return nil, err
 		}
@@ -563,11 +954,16 @@ func CreateSimpleVolume(ctx context.Context, pipe bool) (*VolumeInfo, error) {
 	}
 
 	// Buffer the writes to double the default block size (128KB)
-	v.bufw = bufio.NewWriterSize(v.w, BufferSize)
+	v.bufw = getBufWriter(v.w)
 	v.w = v.bufw
 
 	// Compute hashes
-	v.w = io.MultiWriter(v.w, v.SHA256, v.CRC32C, v.MD5, v.SHA1)
+	hashes := []io.Writer{v.SHA256, v.CRC32C, v.MD5, v.SHA1}
+	if v.Digest != nil {
+		hashes = append(hashes, v.Digest)
+	}
+	v.sums = io.MultiWriter(hashes...)
+	v.w = io.MultiWriter(v.w, v.sums)
 
 	// Add a writer that counts how many bytes have been written
 	v.counter = datacounter.NewWriterCounter(v.w)
@@ -575,3 +971,47 @@ func CreateSimpleVolume(ctx context.Context, pipe bool) (*VolumeInfo, error) {
 
 	return v, nil
 }
+
+// teeWriter writes to every pipe of a teed volume at once, so its destinations read it side by side instead of
+// taking turns.
+type teeWriter struct {
+	pws []*io.PipeWriter
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	errs := make([]error, len(t.pws))
+	var wg sync.WaitGroup
+	wg.Add(len(t.pws))
+	for i, pw := range t.pws {
+		go func(i int, pw *io.PipeWriter) {
+			defer wg.Done()
+			_, errs[i] = pw.Write(p)
+		}(i, pw)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			// Don't leave the other destinations waiting on the rest of a volume that won't be written
+			t.closeWithError(err)
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (t *teeWriter) closeWithError(err error) {
+	for _, pw := range t.pws {
+		pw.CloseWithError(err)
+	}
+}
+
+// Close will close every pipe, letting their readers know the volume is complete.
+func (t *teeWriter) Close() error {
+	for _, pw := range t.pws {
+		if err := pw.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
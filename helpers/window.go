@@ -0,0 +1,88 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a time of the day, in the local time zone, a job may run in, e.g. 22:00-06:00. It spans midnight
+// when it ends before it starts.
+type Window struct {
+	spec       string
+	start, end int // minutes since midnight
+}
+
+// ParseWindow will parse a window given as HH:MM-HH:MM.
+func ParseWindow(spec string) (*Window, error) {
+	parts := strings.Split(spec, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", spec)
+	}
+
+	w := &Window{spec: spec}
+	for idx, field := range []*int{&w.start, &w.end} {
+		t, err := time.Parse("15:04", strings.TrimSpace(parts[idx]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM - %v", spec, err)
+		}
+		*field = t.Hour()*60 + t.Minute()
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("invalid window %q, it must not start and end at the same time", spec)
+	}
+	return w, nil
+}
+
+// String will return the window as it was given.
+func (w *Window) String() string {
+	return w.spec
+}
+
+// Contains reports whether t is within the window.
+func (w *Window) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// NextOpen will return the first time after t the window opens.
+func (w *Window) NextOpen(t time.Time) time.Time {
+	return nextMinute(t, w.start)
+}
+
+// NextClose will return the first time after t the window closes.
+func (w *Window) NextClose(t time.Time) time.Time {
+	return nextMinute(t, w.end)
+}
+
+// nextMinute will return the first time after t that is the given number of minutes past midnight.
+func nextMinute(t time.Time, minute int) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day(), minute/60, minute%60, 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(t.Year(), t.Month(), t.Day()+1, minute/60, minute%60, 0, 0, t.Location())
+	}
+	return next
+}
@@ -0,0 +1,112 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	testCases := []struct {
+		spec        string
+		start, end  int
+		expectError bool
+	}{
+		{"22:00-06:00", 22 * 60, 6 * 60, false},
+		{"09:00-17:00", 9 * 60, 17 * 60, false},
+		{"09:30 - 17:45", 9*60 + 30, 17*60 + 45, false},
+		{"00:00-23:59", 0, 23*60 + 59, false},
+		{"23:59-00:00", 23*60 + 59, 0, false},
+		{"", 0, 0, true},
+		{"22:00", 0, 0, true},
+		{"22:00-", 0, 0, true},
+		{"22:00-06:00-07:00", 0, 0, true},
+		{"24:00-06:00", 0, 0, true},
+		{"22:60-06:00", 0, 0, true},
+		{"22h-06h", 0, 0, true},
+		{"10:00-10:00", 0, 0, true},
+	}
+
+	for idx, c := range testCases {
+		w, err := ParseWindow(c.spec)
+		if (err != nil) != c.expectError {
+			t.Errorf("%d: expected error %v, got %v", idx, c.expectError, err)
+			continue
+		}
+		if !c.expectError && (w.start != c.start || w.end != c.end || w.String() != c.spec) {
+			t.Errorf("%d: expected %d-%d, got %d-%d (%s)", idx, c.start, c.end, w.start, w.end, w)
+		}
+	}
+}
+
+func TestWindow(t *testing.T) {
+	at := func(day, hour, minute, second int) time.Time {
+		return time.Date(2024, 3, day, hour, minute, second, 0, time.UTC)
+	}
+	night, err := ParseWindow("22:00-06:00")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	day, err := ParseWindow("09:00-17:00")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		w         *Window
+		t         time.Time
+		contains  bool
+		nextOpen  time.Time
+		nextClose time.Time
+	}{
+		// Spanning midnight
+		{night, at(10, 21, 59, 59), false, at(10, 22, 0, 0), at(11, 6, 0, 0)},
+		{night, at(10, 22, 0, 0), true, at(11, 22, 0, 0), at(11, 6, 0, 0)},
+		{night, at(10, 23, 30, 0), true, at(11, 22, 0, 0), at(11, 6, 0, 0)},
+		{night, at(11, 0, 0, 0), true, at(11, 22, 0, 0), at(11, 6, 0, 0)},
+		{night, at(11, 5, 59, 59), true, at(11, 22, 0, 0), at(11, 6, 0, 0)},
+		{night, at(11, 6, 0, 0), false, at(11, 22, 0, 0), at(12, 6, 0, 0)},
+		{night, at(11, 12, 0, 0), false, at(11, 22, 0, 0), at(12, 6, 0, 0)},
+		// Within a day
+		{day, at(10, 8, 59, 59), false, at(10, 9, 0, 0), at(10, 17, 0, 0)},
+		{day, at(10, 9, 0, 0), true, at(11, 9, 0, 0), at(10, 17, 0, 0)},
+		{day, at(10, 12, 0, 0), true, at(11, 9, 0, 0), at(10, 17, 0, 0)},
+		{day, at(10, 16, 59, 59), true, at(11, 9, 0, 0), at(10, 17, 0, 0)},
+		{day, at(10, 17, 0, 0), false, at(11, 9, 0, 0), at(11, 17, 0, 0)},
+		{day, at(10, 23, 0, 0), false, at(11, 9, 0, 0), at(11, 17, 0, 0)},
+		{day, at(11, 0, 0, 0), false, at(11, 9, 0, 0), at(11, 17, 0, 0)},
+		// Across the end of a month
+		{night, at(31, 23, 0, 0), true, time.Date(2024, 4, 1, 22, 0, 0, 0, time.UTC), time.Date(2024, 4, 1, 6, 0, 0, 0, time.UTC)},
+	}
+
+	for idx, c := range testCases {
+		if got := c.w.Contains(c.t); got != c.contains {
+			t.Errorf("%d: expected %s to contain %v: %v, got %v", idx, c.w, c.t, c.contains, got)
+		}
+		if got := c.w.NextOpen(c.t); !got.Equal(c.nextOpen) {
+			t.Errorf("%d: expected %s to open next at %v after %v, got %v", idx, c.w, c.nextOpen, c.t, got)
+		}
+		if got := c.w.NextClose(c.t); !got.Equal(c.nextClose) {
+			t.Errorf("%d: expected %s to close next at %v after %v, got %v", idx, c.w, c.nextClose, c.t, got)
+		}
+	}
+}
@@ -24,7 +24,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"time"
@@ -35,6 +39,32 @@ var (
 	ZFSPath = "zfs"
 )
 
+// zfsInstallDirs are where the zfs binaries are installed outside of the default PATH, which is all launchd and
+// cron jobs get, by operating system. OpenZFS on macOS installs them in /usr/local/zfs/bin, older releases in
+// /usr/local/bin.
+var zfsInstallDirs = map[string][]string{
+	"darwin": {"/usr/local/zfs/bin", "/usr/local/bin"},
+}
+
+// ResolveZFSPath will look for the zfs binary where it is installed by the operating system when the default ZFSPath
+// is not found in the PATH, e.g. when run by launchd on macOS. It is left as is when run over ssh.
+func ResolveZFSPath() {
+	if ZFSPath != "zfs" || SSHHost != "" {
+		return
+	}
+	if _, err := exec.LookPath(ZFSPath); err == nil {
+		return
+	}
+	for _, dir := range zfsInstallDirs[runtime.GOOS] {
+		path := filepath.Join(dir, "zfs")
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			AppLogger.Debugf("Using the zfs binary found at %s", path)
+			ZFSPath = path
+			return
+		}
+	}
+}
+
 // GetCreationDate will use the zfs command to get and parse the creation datetime
 // of the specified volume/snapshot
 func GetCreationDate(ctx context.Context, target string) (time.Time, error) {
@@ -49,11 +79,16 @@ func GetCreationDate(ctx context.Context, target string) (time.Time, error) {
 	return time.Unix(epochTime, 0), nil
 }
 
+// zfsError will return the error of a failed zfs command along with what it wrote to stderr.
+func zfsError(stderr *bytes.Buffer, err error) error {
+	return NewError(ErrorKindZFS, fmt.Errorf("%s (%v)", strings.TrimSpace(stderr.String()), err))
+}
+
 // GetSnapshots will retrieve all snapshots for the given target
 func GetSnapshots(ctx context.Context, target string) ([]SnapshotInfo, error) {
 	errB := new(bytes.Buffer)
-	cmd := exec.CommandContext(ctx, ZFSPath, "list", "-H", "-d", "1", "-p", "-t", "snapshot", "-r", "-o", "name,creation", "-S", "creation", target)
-	AppLogger.Debugf("Getting ZFS Snapshots with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd := zfsCommand(ctx, zfsPath(ctx), "list", "-H", "-d", "1", "-p", "-t", "snapshot", "-r", "-o", "name,creation", "-S", "creation", target)
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS Snapshots with command \"%s\"", strings.Join(cmd.Args, " "))
 	cmd.Stderr = errB
 	rpipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -61,7 +96,7 @@ func GetSnapshots(ctx context.Context, target string) ([]SnapshotInfo, error) {
 	}
 	err = cmd.Start()
 	if err != nil {
-		return nil, fmt.Errorf("%s (%v)", strings.TrimSpace(errB.String()), err)
+		return nil, zfsError(errB, err)
 	}
 	var snapshots []SnapshotInfo
 	for {
@@ -77,7 +112,7 @@ func GetSnapshots(ctx context.Context, target string) ([]SnapshotInfo, error) {
 	}
 	err = cmd.Wait()
 	if err != nil {
-		return nil, err
+		return nil, zfsError(errB, err)
 	}
 
 	return snapshots, nil
@@ -88,57 +123,344 @@ func GetSnapshots(ctx context.Context, target string) ([]SnapshotInfo, error) {
 func GetZFSProperty(ctx context.Context, prop, target string) (string, error) {
 	b := new(bytes.Buffer)
 	errB := new(bytes.Buffer)
-	cmd := exec.CommandContext(ctx, ZFSPath, "get", "-H", "-p", "-o", "value", prop, target)
-	AppLogger.Debugf("Getting ZFS Property with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd := zfsCommand(ctx, zfsPath(ctx), "get", "-H", "-p", "-o", "value", prop, target)
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS Property with command \"%s\"", strings.Join(cmd.Args, " "))
 	cmd.Stdout = b
 	cmd.Stderr = errB
 	err := cmd.Run()
 	if err != nil {
-		return "", fmt.Errorf("%s (%v)", strings.TrimSpace(errB.String()), err)
+		return "", zfsError(errB, err)
 	}
 	return strings.TrimSpace(b.String()), nil
 }
 
 // GetZFSSendCommand will return the send command to use for the given JobInfo
 func GetZFSSendCommand(ctx context.Context, j *JobInfo) *exec.Cmd {
+	path, args := prioritized(zfsPath(ctx), zfsSendArgs(ctx, j)...)
+	return zfsCommand(ctx, path, args...)
+}
 
-	// Prepare the zfs send command
+// zfsSendArgs will return the arguments of the zfs send command for the given JobInfo.
+func zfsSendArgs(ctx context.Context, j *JobInfo) []string {
 	zfsArgs := []string{"send"}
 
 	if j.Replication {
-		AppLogger.Infof("Enabling the replication (-R) flag on the send.")
+		EnvironmentOf(ctx).Logger.Infof("Enabling the replication (-R) flag on the send.")
 		zfsArgs = append(zfsArgs, "-R")
 	}
 
 	if j.Deduplication {
-		AppLogger.Infof("Enabling the deduplication (-D) flag on the send.")
+		EnvironmentOf(ctx).Logger.Infof("Enabling the deduplication (-D) flag on the send.")
 		zfsArgs = append(zfsArgs, "-D")
 	}
 
 	if j.Properties {
-		AppLogger.Infof("Enabling the properties (-p) flag on the send.")
+		EnvironmentOf(ctx).Logger.Infof("Enabling the properties (-p) flag on the send.")
 		zfsArgs = append(zfsArgs, "-p")
 	}
 
 	if j.Compressor == ZfsCompressor {
-		AppLogger.Infof("Enabling the compression (-c) flag on the send.")
+		EnvironmentOf(ctx).Logger.Infof("Enabling the compression (-c) flag on the send.")
 		zfsArgs = append(zfsArgs, "-c")
 	}
 
 	if j.IntermediaryIncremental && j.IncrementalSnapshot.Name != "" {
-		AppLogger.Infof("Enabling an incremental stream with all intermediary snapshots (-I) on the send to snapshot %s", j.IncrementalSnapshot.Name)
+		EnvironmentOf(ctx).Logger.Infof("Enabling an incremental stream with all intermediary snapshots (-I) on the send to snapshot %s", j.IncrementalSnapshot.Name)
 		zfsArgs = append(zfsArgs, "-I", j.IncrementalSnapshot.Name)
 	}
 
 	if !j.IntermediaryIncremental && j.IncrementalSnapshot.Name != "" {
-		AppLogger.Infof("Enabling an incremental stream (-i) on the send to snapshot %s", j.IncrementalSnapshot.Name)
+		EnvironmentOf(ctx).Logger.Infof("Enabling an incremental stream (-i) on the send to snapshot %s", j.IncrementalSnapshot.Name)
 		zfsArgs = append(zfsArgs, "-i", j.IncrementalSnapshot.Name)
 	}
 
-	zfsArgs = append(zfsArgs, fmt.Sprintf("%s@%s", j.VolumeName, j.BaseSnapshot.Name))
-	cmd := exec.CommandContext(ctx, ZFSPath, zfsArgs...)
+	return append(zfsArgs, fmt.Sprintf("%s@%s", j.SourceVolume(), j.BaseSnapshot.Name))
+}
+
+// GetZFSSendEstimate will perform a dry run of the send command for the given JobInfo
+// and return the estimated size, in bytes, of the resulting zfs stream.
+func GetZFSSendEstimate(ctx context.Context, j *JobInfo) (uint64, error) {
+	zfsArgs := append([]string{"send", "-n", "-P"}, zfsSendArgs(ctx, j)[1:]...)
 
-	return cmd
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zfsPath(ctx), zfsArgs...)
+	EnvironmentOf(ctx).Logger.Debugf("Estimating ZFS send size with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stderr = errB
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, zfsError(errB, err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "size" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("could not find size estimate in zfs output")
+}
+
+// ZFSChange is a change reported by the "zfs diff" command.
+type ZFSChange struct {
+	// Type is one of - (removed), + (added), M (modified), or R (renamed)
+	Type string
+	Path string
+	// NewPath is the path a renamed file was renamed to
+	NewPath string `json:",omitempty"`
+}
+
+// GetZFSDiff will return the changes made to the files of a filesystem between the from
+// snapshot and the to snapshot (or the filesystem itself) as reported by "zfs diff".
+func GetZFSDiff(ctx context.Context, from, to string) ([]ZFSChange, error) {
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zfsPath(ctx), "diff", "-H", from, to)
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS changes with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stderr = errB
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, zfsError(errB, err)
+	}
+
+	var changes []ZFSChange
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		change := ZFSChange{Type: fields[0], Path: fields[1]}
+		if len(fields) > 2 {
+			change.NewPath = fields[2]
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// GetZFSVersion will return the userland version reported by the "zfs version" command, e.g. zfs-2.1.5-1.
+// Releases of ZFS that predate the command will return an error.
+func GetZFSVersion(ctx context.Context) (string, error) {
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zfsPath(ctx), "version")
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS version with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stderr = errB
+	output, err := cmd.Output()
+	if err != nil {
+		return "", zfsError(errB, err)
+	}
+	return strings.TrimSpace(strings.Split(string(output), "\n")[0]), nil
+}
+
+// GetZFSPools will return the name of every imported pool.
+func GetZFSPools(ctx context.Context) ([]string, error) {
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zfsPath(ctx), "list", "-H", "-o", "name", "-d", "0")
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS pools with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stderr = errB
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, zfsError(errB, err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// GetZFSDatasets will return the name of every filesystem and volume.
+func GetZFSDatasets(ctx context.Context) ([]string, error) {
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zfsPath(ctx), "list", "-H", "-o", "name", "-t", "filesystem,volume")
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS datasets with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stderr = errB
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, zfsError(errB, err)
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// IsDatasetPattern will return whether the dataset name provided is a shell pattern, see MatchDatasets.
+func IsDatasetPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// MatchDatasets will return every filesystem and volume matching the shell pattern provided, leaving out
+// those matching any of the excludes. Patterns are matched with path.Match, so a '*' does not match
+// across a '/': tank/vm/* matches the children of tank/vm but not their own children.
+func MatchDatasets(ctx context.Context, pattern string, excludes []string) ([]string, error) {
+	for _, p := range append([]string{pattern}, excludes...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid dataset pattern %s - %v", p, err)
+		}
+	}
+
+	datasets, err := GetZFSDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	for _, dataset := range datasets {
+		if ok, _ := path.Match(pattern, dataset); !ok {
+			continue
+		}
+		excluded := false
+		for _, exclude := range excludes {
+			if ok, _ := path.Match(exclude, dataset); ok {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			matched = append(matched, dataset)
+		}
+	}
+	return matched, nil
+}
+
+// ZFSObject is a snapshot or bookmark of a dataset.
+type ZFSObject struct {
+	Name         string // Name of the snapshot or bookmark, without the dataset
+	Bookmark     bool
+	GUID         string
+	CreationTime time.Time
+}
+
+// GetSnapshotsAndBookmarks will return the snapshots and bookmarks of the target, newest first. A bookmark has
+// the GUID of the snapshot it was created from.
+func GetSnapshotsAndBookmarks(ctx context.Context, target string) ([]ZFSObject, error) {
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zfsPath(ctx), "list", "-H", "-p", "-d", "1", "-t", "snapshot,bookmark", "-o", "name,guid,creation", "-S", "creation", target)
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS snapshots and bookmarks with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stderr = errB
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, zfsError(errB, err)
+	}
+
+	var objects []ZFSObject
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		creation, perr := strconv.ParseInt(fields[2], 10, 64)
+		if perr != nil {
+			return nil, fmt.Errorf("could not parse the creation time of %s - %v", fields[0], perr)
+		}
+		object := ZFSObject{Name: fields[0], GUID: fields[1], CreationTime: time.Unix(creation, 0)}
+		if idx := strings.IndexAny(object.Name, "@#"); idx >= 0 {
+			object.Bookmark = object.Name[idx] == '#'
+			object.Name = object.Name[idx+1:]
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// GetZFSProperties will return the properties set on the target, or on every snapshot of the target when snapshots
+// is true, keyed by the name of the dataset or snapshot and then by the property. Properties without a value are left
+// out. Every property is returned if none are provided.
+func GetZFSProperties(ctx context.Context, target string, snapshots bool, props ...string) (map[string]map[string]string, error) {
+	if len(props) == 0 {
+		props = []string{"all"}
+	}
+	zfsArgs := []string{"get", "-H", "-p", "-o", "name,property,value,source"}
+	if snapshots {
+		zfsArgs = append(zfsArgs, "-d", "1", "-t", "snapshot")
+	}
+	zfsArgs = append(zfsArgs, strings.Join(props, ","), target)
+
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zfsPath(ctx), zfsArgs...)
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS properties with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stderr = errB
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, zfsError(errB, err)
+	}
+
+	properties := make(map[string]map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 || fields[3] == "-" && fields[2] == "-" {
+			continue
+		}
+		if properties[fields[0]] == nil {
+			properties[fields[0]] = make(map[string]string)
+		}
+		properties[fields[0]][fields[1]] = fields[2]
+	}
+	return properties, nil
+}
+
+// GetZFSHolds will return the tags of the user holds on the snapshots provided, keyed by the snapshot.
+func GetZFSHolds(ctx context.Context, snapshots ...string) (map[string][]string, error) {
+	holds := make(map[string][]string)
+	if len(snapshots) == 0 {
+		return holds, nil
+	}
+
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zfsPath(ctx), append([]string{"holds", "-H"}, snapshots...)...)
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS holds with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stderr = errB
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, zfsError(errB, err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		holds[fields[0]] = append(holds[fields[0]], fields[1])
+	}
+	return holds, nil
+}
+
+// GetZPoolFeatures will return the state (disabled, enabled, or active) of every feature
+// flag of the given pool, keyed by the feature name (e.g. large_blocks).
+func GetZPoolFeatures(ctx context.Context, pool string) (map[string]string, error) {
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zpoolPath(ctx), "get", "-H", "-o", "property,value", "all", pool)
+	EnvironmentOf(ctx).Logger.Debugf("Getting ZFS pool features with command \"%s\"", strings.Join(cmd.Args, " "))
+	cmd.Stderr = errB
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, zfsError(errB, err)
+	}
+
+	features := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.HasPrefix(fields[0], "feature@") {
+			features[strings.TrimPrefix(fields[0], "feature@")] = fields[1]
+		}
+	}
+	return features, nil
+}
+
+// zfsPath will return the path to the zfs binary of the Environment of ctx.
+func zfsPath(ctx context.Context) string {
+	return EnvironmentOf(ctx).ZFSPath
+}
+
+// zpoolPath will return the path to the zpool binary, expected alongside the zfs binary.
+func zpoolPath(ctx context.Context) string {
+	if dir := filepath.Dir(zfsPath(ctx)); dir != "." {
+		return filepath.Join(dir, "zpool")
+	}
+	return "zpool"
+}
+
+// DestroyZFSVolume will recursively destroy the volume provided along with all of its snapshots.
+func DestroyZFSVolume(ctx context.Context, target string) error {
+	errB := new(bytes.Buffer)
+	cmd := zfsCommand(ctx, zfsPath(ctx), "destroy", "-r", target)
+	cmd.Stderr = errB
+	if err := cmd.Run(); err != nil {
+		return zfsError(errB, err)
+	}
+	return nil
 }
 
 // GetZFSReceiveCommand will return the recv command to use for the given JobInfo
@@ -148,32 +470,33 @@ func GetZFSReceiveCommand(ctx context.Context, j *JobInfo) *exec.Cmd {
 	zfsArgs := []string{"receive"}
 
 	if j.FullPath {
-		AppLogger.Infof("Enabling the full path (-d) flag on the receive.")
+		EnvironmentOf(ctx).Logger.Infof("Enabling the full path (-d) flag on the receive.")
 		zfsArgs = append(zfsArgs, "-d")
 	}
 
 	if j.LastPath {
-		AppLogger.Infof("Enabling the last path (-e) flag on the receive.")
+		EnvironmentOf(ctx).Logger.Infof("Enabling the last path (-e) flag on the receive.")
 		zfsArgs = append(zfsArgs, "-e")
 	}
 
 	if j.NotMounted {
-		AppLogger.Infof("Enabling the not mounted (-u) flag on the receive.")
+		EnvironmentOf(ctx).Logger.Infof("Enabling the not mounted (-u) flag on the receive.")
 		zfsArgs = append(zfsArgs, "-u")
 	}
 
 	if j.Force {
-		AppLogger.Infof("Enabling the forced rollback (-F) flag on the receive.")
+		EnvironmentOf(ctx).Logger.Infof("Enabling the forced rollback (-F) flag on the receive.")
 		zfsArgs = append(zfsArgs, "-F")
 	}
 
 	if j.Origin != "" {
-		AppLogger.Infof("Enabling the origin flag (-o) on the receive to %s", j.Origin)
+		EnvironmentOf(ctx).Logger.Infof("Enabling the origin flag (-o) on the receive to %s", j.Origin)
 		zfsArgs = append(zfsArgs, "-o", "origin="+j.Origin)
 	}
 
 	zfsArgs = append(zfsArgs, j.LocalVolume)
-	cmd := exec.CommandContext(ctx, ZFSPath, zfsArgs...)
+	path, args := prioritized(zfsPath(ctx), zfsArgs...)
+	cmd := zfsCommand(ctx, path, args...)
 
 	return cmd
 }
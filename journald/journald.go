@@ -0,0 +1,121 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package journald sends log entries with structured fields to the systemd journal
+// using its native protocol.
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// SocketPath is where the systemd journal listens for entries.
+const SocketPath = "/run/systemd/journal/socket"
+
+// Journal sends entries to the systemd journal.
+type Journal struct {
+	conn *net.UnixConn
+}
+
+// Open will return a Journal sending entries to the journal listening on the unix datagram socket at path.
+func Open(path string) (*Journal, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{conn: conn}, nil
+}
+
+// Send will send an entry made of the fields given, which must include MESSAGE. Field names
+// must only be made of uppercase letters, digits, and underscores and not start with an underscore.
+func (j *Journal) Send(fields map[string]string) error {
+	entry, err := encode(fields)
+	if err != nil {
+		return err
+	}
+	_, err = j.conn.Write(entry)
+	return err
+}
+
+// Close will close the connection to the journal.
+func (j *Journal) Close() error {
+	return j.conn.Close()
+}
+
+// encode will serialize the fields in the native journal protocol, in the order of their names.
+func encode(fields map[string]string) ([]byte, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if !validName(name) {
+			return nil, fmt.Errorf("invalid journal field name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		value := fields[name]
+		if !strings.Contains(value, "\n") {
+			fmt.Fprintf(&buf, "%s=%s\n", name, value)
+			continue
+		}
+		// Values spanning several lines are prefixed with their length instead
+		buf.WriteString(name)
+		buf.WriteByte('\n')
+		binary.Write(&buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func validName(name string) bool {
+	if name == "" || name[0] == '_' || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// StreamConnected reports whether f is connected to the journal, as systemd does for the stdout and
+// stderr of its services, according to the JOURNAL_STREAM environmental variable it sets.
+func StreamConnected(f *os.File) bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return false
+	}
+	return stream == fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
@@ -0,0 +1,119 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package journald
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fields   map[string]string
+		expected []byte
+		valid    bool
+	}{
+		{
+			name:     "SingleLine",
+			fields:   map[string]string{"MESSAGE": "hello", "PRIORITY": "6"},
+			expected: []byte("MESSAGE=hello\nPRIORITY=6\n"),
+			valid:    true,
+		},
+		{
+			name:     "MultiLine",
+			fields:   map[string]string{"MESSAGE": "a\nb"},
+			expected: []byte("MESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n"),
+			valid:    true,
+		},
+		{
+			name:   "Lowercase",
+			fields: map[string]string{"message": "hello"},
+		},
+		{
+			name:   "Underscore",
+			fields: map[string]string{"_PID": "1"},
+		},
+		{
+			name:   "Digit",
+			fields: map[string]string{"1FIELD": "1"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			entry, err := encode(test.fields)
+			if !test.valid {
+				if err == nil {
+					t.Errorf("expected an error encoding %v", test.fields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error encoding %v - %v", test.fields, err)
+			}
+			if !bytes.Equal(entry, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, entry)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "zfsbackupjournaldtest")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "socket")
+
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("could not listen on %s - %v", path, err)
+	}
+	defer listener.Close()
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatalf("could not open the journal - %v", err)
+	}
+	defer j.Close()
+	if err = j.Send(map[string]string{"MESSAGE": "hello", "SYSLOG_IDENTIFIER": "zfsbackup"}); err != nil {
+		t.Fatalf("unexpected error sending an entry - %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	n, err := listener.Read(buf)
+	if err != nil {
+		t.Fatalf("could not read the entry - %v", err)
+	}
+	if expected := "MESSAGE=hello\nSYSLOG_IDENTIFIER=zfsbackup\n"; string(buf[:n]) != expected {
+		t.Errorf("expected %q, got %q", expected, buf[:n])
+	}
+
+	if _, err = Open(filepath.Join(tempDir, "missing")); err == nil {
+		t.Errorf("expected an error opening a journal that isn't listening")
+	}
+}
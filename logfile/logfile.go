@@ -0,0 +1,185 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package logfile provides a log file writer rotating the file by size and time
+// and keeping a limited number of the rotated files around.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat is appended to the path of a rotated file, it sorts in the order the files were rotated.
+const rotatedTimeFormat = "20060102T150405.000000000"
+
+// Writer appends to a log file, rotating it once it would grow past MaxSize or once a write happens in
+// a later RotateEvery period than the last write to the file (e.g. every day at midnight UTC with 24h).
+// Rotated files are renamed with the time of the rotation appended to their path and only the Keep most
+// recent of them are kept. Several processes may write to the same log file: each of them picks up the
+// new file once another one rotated it.
+type Writer struct {
+	Path        string
+	MaxSize     int64         // Rotate the file before it grows past this many bytes, never if 0
+	RotateEvery time.Duration // Rotate the file once a write happens in a later period of this duration, never if 0
+	Keep        int           // The number of rotated files to keep, all of them if 0
+
+	mu   sync.Mutex
+	file *os.File
+	now  func() time.Time
+}
+
+// New will return a Writer appending to the file at path, opening it right away so
+// errors are reported early.
+func New(path string, maxSize int64, rotateEvery time.Duration, keep int) (*Writer, error) {
+	w := &Writer{Path: path, MaxSize: maxSize, RotateEvery: rotateEvery, Keep: keep, now: time.Now}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write will append p to the log file, rotating it first if needed.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := w.current()
+	if err != nil {
+		return 0, err
+	}
+	if w.due(info, int64(len(p))) {
+		if err = w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	return w.file.Write(p)
+}
+
+// Close will close the log file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *Writer) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.Path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	return nil
+}
+
+// current will return the details of the log file, reopening it if it was rotated or removed
+// since it was opened. Must be called with the lock held.
+func (w *Writer) current() (os.FileInfo, error) {
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return nil, err
+		}
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if onDisk, serr := os.Stat(w.Path); serr != nil || !os.SameFile(info, onDisk) {
+		w.file.Close()
+		if err = w.open(); err != nil {
+			w.file = nil
+			return nil, err
+		}
+		return w.file.Stat()
+	}
+	return info, nil
+}
+
+// due reports whether the log file should be rotated before writing size more bytes to it.
+func (w *Writer) due(info os.FileInfo, size int64) bool {
+	if info.Size() == 0 {
+		return false
+	}
+	if w.MaxSize > 0 && info.Size()+size > w.MaxSize {
+		return true
+	}
+	return w.RotateEvery > 0 && info.ModTime().Before(w.now().Truncate(w.RotateEvery))
+}
+
+// rotate will rename the log file, open a new one in its place, and remove the rotated files
+// that should no longer be kept. Must be called with the lock held.
+func (w *Writer) rotate() error {
+	if err := os.Rename(w.Path, fmt.Sprintf("%s.%s", w.Path, w.now().UTC().Format(rotatedTimeFormat))); err != nil {
+		return err
+	}
+	w.file.Close()
+	if err := w.open(); err != nil {
+		w.file = nil
+		return err
+	}
+	return w.prune()
+}
+
+// prune will remove the oldest rotated files beyond the number to keep.
+func (w *Writer) prune() error {
+	if w.Keep <= 0 {
+		return nil
+	}
+	rotated, err := Rotated(w.Path)
+	if err != nil {
+		return err
+	}
+	for len(rotated) > w.Keep {
+		if err = os.Remove(rotated[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Rotated will return the paths of the files rotated from the log file at path, oldest first.
+func Rotated(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	for _, match := range matches {
+		if _, perr := time.Parse(rotatedTimeFormat, match[len(path)+1:]); perr == nil {
+			rotated = append(rotated, match)
+		}
+	}
+	sort.Strings(rotated)
+	return rotated, nil
+}
@@ -0,0 +1,202 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package logfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read %s - %v", path, err)
+	}
+	return string(b)
+}
+
+func TestWriter(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "zfsbackuplogfiletest")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "logs", "zfsbackup.log")
+
+	now := time.Now()
+	testCases := []struct {
+		name        string
+		maxSize     int64
+		rotateEvery time.Duration
+		after       time.Duration
+		writes      []string
+		current     string
+		rotated     []string
+	}{
+		{
+			name:    "NoRotation",
+			writes:  []string{"line 1\n", "line 2\n"},
+			current: "line 1\nline 2\n",
+		},
+		{
+			name:    "Size",
+			maxSize: 14,
+			writes:  []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n", "line 5\n"},
+			current: "line 5\n",
+			rotated: []string{"line 1\nline 2\n", "line 3\nline 4\n"},
+		},
+		{
+			name:    "LargerThanMaxSize",
+			maxSize: 4,
+			writes:  []string{"line 1\n", "line 2\n"},
+			current: "line 2\n",
+			rotated: []string{"line 1\n"},
+		},
+		{
+			name:        "TimeNotDue",
+			rotateEvery: 24 * time.Hour,
+			writes:      []string{"line 1\n", "line 2\n"},
+			current:     "line 1\nline 2\n",
+		},
+		{
+			name:        "Time",
+			rotateEvery: 24 * time.Hour,
+			after:       48 * time.Hour,
+			writes:      []string{"line 1\n", "line 2\n"},
+			current:     "line 2\n",
+			rotated:     []string{"line 1\n"},
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			os.RemoveAll(filepath.Dir(path))
+			w, err := New(path, test.maxSize, test.rotateEvery, 0)
+			if err != nil {
+				t.Fatalf("could not open the log file - %v", err)
+			}
+			defer w.Close()
+
+			clock := now
+			w.now = func() time.Time {
+				clock = clock.Add(time.Millisecond)
+				return clock
+			}
+			for idx, line := range test.writes {
+				if idx == 1 {
+					clock = clock.Add(test.after)
+				}
+				if _, err = w.Write([]byte(line)); err != nil {
+					t.Fatalf("unexpected error writing to the log file - %v", err)
+				}
+			}
+
+			if current := readFile(t, path); current != test.current {
+				t.Errorf("expected the log file to contain %q, got %q", test.current, current)
+			}
+			rotated, err := Rotated(path)
+			if err != nil {
+				t.Fatalf("could not list the rotated files - %v", err)
+			}
+			if len(rotated) != len(test.rotated) {
+				t.Fatalf("expected %d rotated files, got %v", len(test.rotated), rotated)
+			}
+			for idx := range rotated {
+				if content := readFile(t, rotated[idx]); content != test.rotated[idx] {
+					t.Errorf("expected rotated file %s to contain %q, got %q", rotated[idx], test.rotated[idx], content)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterKeep(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "zfsbackuplogfiletest")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "zfsbackup.log")
+
+	// Not a rotated file, should never be removed
+	if err = ioutil.WriteFile(path+".old", []byte("unrelated"), 0644); err != nil {
+		t.Fatalf("could not write file - %v", err)
+	}
+
+	w, err := New(path, 1, 0, 2)
+	if err != nil {
+		t.Fatalf("could not open the log file - %v", err)
+	}
+	defer w.Close()
+	for _, line := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+		if _, err = w.Write([]byte(line)); err != nil {
+			t.Fatalf("unexpected error writing to the log file - %v", err)
+		}
+	}
+
+	rotated, err := Rotated(path)
+	if err != nil {
+		t.Fatalf("could not list the rotated files - %v", err)
+	}
+	if len(rotated) != 2 || readFile(t, rotated[0]) != "3\n" || readFile(t, rotated[1]) != "4\n" {
+		t.Errorf("expected the two most recent rotated files to be kept, got %v", rotated)
+	}
+	if readFile(t, path+".old") != "unrelated" {
+		t.Errorf("expected an unrelated file to be left alone")
+	}
+}
+
+func TestWriterReopen(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "zfsbackuplogfiletest")
+	if err != nil {
+		t.Fatalf("could not create temp dir - %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "zfsbackup.log")
+
+	w, err := New(path, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("could not open the log file - %v", err)
+	}
+	defer w.Close()
+	if _, err = w.Write([]byte("before\n")); err != nil {
+		t.Fatalf("unexpected error writing to the log file - %v", err)
+	}
+
+	// Another process rotating the file
+	if err = os.Rename(path, path+".rotated"); err != nil {
+		t.Fatalf("could not rename the log file - %v", err)
+	}
+	if _, err = w.Write([]byte("after\n")); err != nil {
+		t.Fatalf("unexpected error writing to the log file - %v", err)
+	}
+
+	if content := readFile(t, path); content != "after\n" {
+		t.Errorf("expected the writer to reopen the log file, got %q", content)
+	}
+	if content := readFile(t, path+".rotated"); content != "before\n" {
+		t.Errorf("expected the rotated file to be left as is, got %q", content)
+	}
+}
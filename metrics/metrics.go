@@ -0,0 +1,160 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package metrics publishes Prometheus metrics describing the jobs zfsbackup runs,
+// either from an HTTP endpoint in daemon mode or pushed to a Pushgateway for one-shot runs.
+package metrics
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+const namespace = "zfsbackup"
+
+// Metrics is a control.Observer that keeps Prometheus metrics for the jobs it watches.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	bytesUploaded     *prometheus.CounterVec
+	volumesUploaded   *prometheus.CounterVec
+	bytesDownloaded   *prometheus.CounterVec
+	volumesDownloaded *prometheus.CounterVec
+	retries           *prometheus.CounterVec
+	jobs              *prometheus.CounterVec
+	jobDuration       *prometheus.GaugeVec
+	lastSuccess       *prometheus.GaugeVec
+	lastFailure       *prometheus.GaugeVec
+}
+
+// New will return Metrics registered with their own registry.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		bytesUploaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "uploaded_bytes_total",
+			Help:      "Bytes uploaded to a destination.",
+		}, []string{"volume", "destination"}),
+		volumesUploaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "uploaded_volumes_total",
+			Help:      "Volumes uploaded to a destination.",
+		}, []string{"volume", "destination"}),
+		bytesDownloaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "downloaded_bytes_total",
+			Help:      "Bytes downloaded from a destination, by receive and verify jobs.",
+		}, []string{"operation", "volume", "destination"}),
+		volumesDownloaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "downloaded_volumes_total",
+			Help:      "Volumes downloaded from a destination, by receive and verify jobs.",
+		}, []string{"operation", "volume", "destination"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Failed attempts at uploading or downloading a volume.",
+		}, []string{"operation", "volume", "destination"}),
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_total",
+			Help:      "Jobs run, by status (success or failure).",
+		}, []string{"operation", "volume", "status"}),
+		jobDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_job_duration_seconds",
+			Help:      "How long the last job took to run.",
+		}, []string{"operation", "volume"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time the last successful job finished.",
+		}, []string{"operation", "volume", "destination"}),
+		lastFailure: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_failure_timestamp_seconds",
+			Help:      "Unix time the last failed job finished.",
+		}, []string{"operation", "volume", "destination"}),
+	}
+
+	m.registry.MustRegister(
+		m.bytesUploaded,
+		m.volumesUploaded,
+		m.bytesDownloaded,
+		m.volumesDownloaded,
+		m.retries,
+		m.jobs,
+		m.jobDuration,
+		m.lastSuccess,
+		m.lastFailure,
+	)
+	return m
+}
+
+// Progress implements control.Observer.
+func (m *Metrics) Progress(operation string, event helpers.ProgressEvent) {
+	switch event.Type {
+	case helpers.ProgressVolumeUploaded:
+		m.bytesUploaded.WithLabelValues(event.VolumeName, event.Destination).Add(float64(event.Bytes))
+		m.volumesUploaded.WithLabelValues(event.VolumeName, event.Destination).Inc()
+	case helpers.ProgressVolumeDownloaded, helpers.ProgressVolumeVerified:
+		m.bytesDownloaded.WithLabelValues(operation, event.VolumeName, event.Destination).Add(float64(event.Bytes))
+		m.volumesDownloaded.WithLabelValues(operation, event.VolumeName, event.Destination).Inc()
+	case helpers.ProgressRetry:
+		m.retries.WithLabelValues(operation, event.VolumeName, event.Destination).Inc()
+	}
+}
+
+// Finished implements control.Observer.
+func (m *Metrics) Finished(result control.Result) {
+	status, last := "success", m.lastSuccess
+	if result.Failed() {
+		status, last = "failure", m.lastFailure
+	}
+
+	m.jobs.WithLabelValues(result.Operation, result.VolumeName, status).Inc()
+	m.jobDuration.WithLabelValues(result.Operation, result.VolumeName).Set(result.Duration().Seconds())
+	for _, destination := range result.Destinations {
+		last.WithLabelValues(result.Operation, result.VolumeName, destination).Set(float64(result.EndTime.Unix()))
+	}
+}
+
+// Handler will return an http.Handler serving the metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Push will push the metrics to the Pushgateway at url, grouped by host and volume so
+// one-shot runs for different datasets do not replace each other's metrics.
+func (m *Metrics) Push(url, volume string) error {
+	pusher := push.New(url, namespace).Gatherer(m.registry).Grouping("dataset", volume)
+	if hostname, err := os.Hostname(); err == nil {
+		pusher = pusher.Grouping("instance", hostname)
+	}
+	return pusher.Push()
+}
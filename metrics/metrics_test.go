@@ -0,0 +1,171 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressVolumeUploaded, VolumeName: "tank/data", Destination: "gs://bucket", Bytes: 1024})
+	m.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressRetry, VolumeName: "tank/data", Destination: "gs://bucket"})
+	m.Finished(control.Result{
+		Operation:    "send",
+		VolumeName:   "tank/data",
+		Destinations: []string{"gs://bucket"},
+		StartTime:    time.Unix(1000, 0),
+		EndTime:      time.Unix(1060, 0),
+	})
+	m.Finished(control.Result{
+		Operation:    "send",
+		VolumeName:   "tank/data",
+		Destinations: []string{"gs://bucket"},
+		StartTime:    time.Unix(2000, 0),
+		EndTime:      time.Unix(2010, 0),
+		Error:        errors.New("failed").Error(),
+	})
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, expected := range []string{
+		`zfsbackup_uploaded_bytes_total{destination="gs://bucket",volume="tank/data"} 1024`,
+		`zfsbackup_uploaded_volumes_total{destination="gs://bucket",volume="tank/data"} 1`,
+		`zfsbackup_retries_total{destination="gs://bucket",operation="send",volume="tank/data"} 1`,
+		`zfsbackup_jobs_total{operation="send",status="success",volume="tank/data"} 1`,
+		`zfsbackup_jobs_total{operation="send",status="failure",volume="tank/data"} 1`,
+		`zfsbackup_last_job_duration_seconds{operation="send",volume="tank/data"} 10`,
+		`zfsbackup_last_success_timestamp_seconds{destination="gs://bucket",operation="send",volume="tank/data"} 1060`,
+		`zfsbackup_last_failure_timestamp_seconds{destination="gs://bucket",operation="send",volume="tank/data"} 2010`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected metrics to contain %s, got:\n%s", expected, body)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	m := New()
+	m.Finished(control.Result{Operation: "send", VolumeName: "tank/data", StartTime: time.Now(), EndTime: time.Now()})
+	if err := m.Push(server.URL, "tank/data"); err != nil {
+		t.Fatalf("could not push metrics - %v", err)
+	}
+
+	if !strings.HasPrefix(path, "/metrics/job/zfsbackup/") || !strings.Contains(path, "dataset") {
+		t.Errorf("unexpected push path %s", path)
+	}
+	if body == "" {
+		t.Errorf("expected metrics to be pushed")
+	}
+}
+
+func readStatsD(t *testing.T, conn net.PacketConn, n int) []string {
+	var lines []string
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < n; i++ {
+		read, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("could not read statsd packet - %v", err)
+		}
+		lines = append(lines, string(buf[:read]))
+	}
+	return lines
+}
+
+func TestStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen for statsd packets - %v", err)
+	}
+	defer conn.Close()
+
+	testCases := []struct {
+		datadog  bool
+		expected []string
+	}{
+		{
+			datadog: false,
+			expected: []string{
+				"zfsbackup.tank_data.retries:1|c",
+				"zfsbackup.tank_data.jobs.success:1|c",
+				"zfsbackup.tank_data.job.duration:10000|ms",
+				"zfsbackup.tank_data.job.throughput:100.000000|g",
+				"zfsbackup.tank_data.job.compression_ratio:2.000000|g",
+			},
+		},
+		{
+			datadog: true,
+			expected: []string{
+				"zfsbackup.retries:1|c|#operation:send,volume:tank/data,destination:gs://bucket",
+				"zfsbackup.jobs.success:1|c|#operation:send,volume:tank/data,status:success",
+				"zfsbackup.job.duration:10000|ms|#operation:send,volume:tank/data,status:success",
+				"zfsbackup.job.throughput:100.000000|g|#operation:send,volume:tank/data,status:success",
+				"zfsbackup.job.compression_ratio:2.000000|g|#operation:send,volume:tank/data,status:success",
+			},
+		},
+	}
+
+	for _, test := range testCases {
+		s, serr := NewStatsD(conn.LocalAddr().String(), test.datadog)
+		if serr != nil {
+			t.Fatalf("could not create statsd emitter - %v", serr)
+		}
+		s.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressRetry, VolumeName: "tank/data", Destination: "gs://bucket"})
+		s.Finished(control.Result{
+			Operation:   "send",
+			VolumeName:  "tank/data",
+			StartTime:   time.Unix(0, 0),
+			EndTime:     time.Unix(10, 0),
+			Bytes:       1000,
+			StreamBytes: 2000,
+		})
+		s.Close()
+
+		lines := readStatsD(t, conn, len(test.expected))
+		for idx := range test.expected {
+			if lines[idx] != test.expected[idx] {
+				t.Errorf("datadog=%v: expected %s, got %s", test.datadog, test.expected[idx], lines[idx])
+			}
+		}
+	}
+}
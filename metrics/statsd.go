@@ -0,0 +1,112 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// StatsD is a control.Observer that emits per job counters and timings over statsd. With
+// Datadog enabled, the job details are sent as dogstatsd tags, otherwise the volume name is
+// made part of the metric name.
+type StatsD struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	datadog bool
+}
+
+// NewStatsD will return a StatsD emitting to the statsd server listening on addr over UDP.
+func NewStatsD(addr string, datadog bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsD{conn: conn, datadog: datadog}, nil
+}
+
+// Close will close the connection to the statsd server.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+// Progress implements control.Observer.
+func (s *StatsD) Progress(operation string, event helpers.ProgressEvent) {
+	tags := []string{"operation:" + operation, "volume:" + event.VolumeName, "destination:" + event.Destination}
+	switch event.Type {
+	case helpers.ProgressVolumeUploaded:
+		s.send(event.VolumeName, "uploaded_bytes", fmt.Sprintf("%d|c", event.Bytes), tags)
+		s.send(event.VolumeName, "uploaded_volumes", "1|c", tags)
+	case helpers.ProgressVolumeDownloaded, helpers.ProgressVolumeVerified:
+		s.send(event.VolumeName, "downloaded_bytes", fmt.Sprintf("%d|c", event.Bytes), tags)
+		s.send(event.VolumeName, "downloaded_volumes", "1|c", tags)
+	case helpers.ProgressRetry:
+		s.send(event.VolumeName, "retries", "1|c", tags)
+	}
+}
+
+// Finished implements control.Observer.
+func (s *StatsD) Finished(result control.Result) {
+	status := "success"
+	if result.Failed() {
+		status = "failure"
+	}
+	tags := []string{"operation:" + result.Operation, "volume:" + result.VolumeName, "status:" + status}
+
+	s.send(result.VolumeName, "jobs."+status, "1|c", tags)
+	s.send(result.VolumeName, "job.duration", fmt.Sprintf("%d|ms", result.Duration().Nanoseconds()/1e6), tags)
+	if seconds := result.Duration().Seconds(); seconds > 0 && result.Bytes > 0 {
+		s.send(result.VolumeName, "job.throughput", fmt.Sprintf("%f|g", float64(result.Bytes)/seconds), tags)
+	}
+	if result.Bytes > 0 && result.StreamBytes > 0 {
+		s.send(result.VolumeName, "job.compression_ratio", fmt.Sprintf("%f|g", float64(result.StreamBytes)/float64(result.Bytes)), tags)
+	}
+}
+
+var (
+	// Characters with meaning in the statsd line protocol
+	tagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+	// Characters that would split the volume into several parts of a plain statsd metric name
+	nameReplacer = strings.NewReplacer("/", "_", ".", "_", ":", "_", "|", "_", "@", "_", " ", "_", "\n", "_")
+)
+
+func (s *StatsD) send(volume, name, value string, tags []string) {
+	var line string
+	if s.datadog {
+		for idx := range tags {
+			tags[idx] = tagReplacer.Replace(tags[idx])
+		}
+		line = fmt.Sprintf("%s.%s:%s|#%s", namespace, name, value, strings.Join(tags, ","))
+	} else {
+		line = fmt.Sprintf("%s.%s.%s:%s", namespace, nameReplacer.Replace(volume), name, value)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		helpers.AppLogger.Debugf("Could not send %s to statsd - %v", line, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package notify lets external services know when jobs start, succeed, and fail.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// requestTimeout bounds how long a notification may hold up a job.
+const requestTimeout = 10 * time.Second
+
+// Healthcheck is a control.Observer that pings a dead man's switch service such as
+// healthchecks.io: <url>/start when the job starts, <url> when it succeeds, and
+// <url>/fail, with the error as the request body, when it fails.
+type Healthcheck struct {
+	url     string
+	client  *http.Client
+	started sync.Once
+}
+
+// NewHealthcheck will return a Healthcheck pinging the check at url.
+func NewHealthcheck(url string) *Healthcheck {
+	return &Healthcheck{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Progress implements control.Observer.
+func (h *Healthcheck) Progress(operation string, event helpers.ProgressEvent) {
+	if event.Type == helpers.ProgressJobStarted {
+		h.started.Do(func() {
+			h.ping(h.url+"/start", fmt.Sprintf("%s of %s@%s started", operation, event.VolumeName, event.Snapshot))
+		})
+	}
+}
+
+// Finished implements control.Observer.
+func (h *Healthcheck) Finished(result control.Result) {
+	if result.Failed() {
+		h.ping(h.url+"/fail", fmt.Sprintf("%s of %s@%s failed after %v: %s", result.Operation, result.VolumeName, result.Snapshot, result.Duration(), result.Error))
+		return
+	}
+	h.ping(h.url, fmt.Sprintf("%s of %s@%s succeeded in %v", result.Operation, result.VolumeName, result.Snapshot, result.Duration()))
+}
+
+func (h *Healthcheck) ping(url, body string) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		helpers.AppLogger.Warningf("Could not create healthcheck ping for %s - %v", url, err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		helpers.AppLogger.Warningf("Could not ping healthcheck %s - %v", url, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		helpers.AppLogger.Warningf("Healthcheck %s responded with unexpected status %s", url, resp.Status)
+		return
+	}
+	helpers.AppLogger.Debugf("Pinged healthcheck %s", url)
+}
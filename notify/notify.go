@@ -0,0 +1,168 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// The statuses a finished job may be notified with. A degraded job succeeded, but only
+// after retrying some of its uploads or downloads.
+const (
+	StatusSuccess  = "success"
+	StatusFailure  = "failure"
+	StatusDegraded = "degraded"
+)
+
+// DefaultTemplate is the text/template used to render notification messages when none is provided.
+const DefaultTemplate = `zfsbackup {{.Operation}} of {{.VolumeName}}@{{.Snapshot}} on {{.Hostname}} finished with status {{.Status}} in {{.Duration}}.
+Bytes written: {{.HumanBytes}}{{if .Retries}}
+Retries: {{.Retries}}{{end}}{{if .Destinations}}
+Destinations: {{join .Destinations ", "}}{{end}}{{if .Error}}
+Error: {{.Error}}{{end}}`
+
+// Event describes a finished job to be notified about.
+type Event struct {
+	control.Result
+	Status   string
+	Retries  int64
+	Hostname string
+}
+
+// HumanBytes will return the bytes written by the job in a human readable form.
+func (e Event) HumanBytes() string {
+	return humanize.IBytes(e.Bytes)
+}
+
+// Subject will return a one line summary of the event.
+func (e Event) Subject() string {
+	return fmt.Sprintf("zfsbackup %s of %s@%s: %s", e.Operation, e.VolumeName, e.Snapshot, e.Status)
+}
+
+// Sender delivers a rendered notification message somewhere.
+type Sender interface {
+	Send(ctx context.Context, event Event, message string) error
+}
+
+// Notifier is a control.Observer that renders a message for every finished job with one
+// of the requested statuses and passes it to its senders.
+type Notifier struct {
+	senders  []Sender
+	statuses map[string]bool
+	tmpl     *template.Template
+
+	// Retries of every running job, serve and run --parallel running several at once
+	retriesMu sync.Mutex
+	retries   map[jobKey]int64
+}
+
+// jobKey identifies the job a progress event or result belongs to.
+type jobKey struct {
+	operation, volumeName, snapshot string
+}
+
+// NewNotifier will return a Notifier delivering messages rendered with tmpl, or the
+// DefaultTemplate if tmpl is empty, to the senders for jobs finishing with one of statuses.
+func NewNotifier(tmpl string, statuses []string, senders ...Sender) (*Notifier, error) {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	parsed, err := template.New("notification").Funcs(template.FuncMap{"join": strings.Join}).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Notifier{senders: senders, statuses: make(map[string]bool), tmpl: parsed, retries: make(map[jobKey]int64)}
+	for _, status := range statuses {
+		status = strings.ToLower(strings.TrimSpace(status))
+		switch status {
+		case StatusSuccess, StatusFailure, StatusDegraded:
+			n.statuses[status] = true
+		default:
+			return nil, fmt.Errorf("unknown notification status %q, expected one of %s, %s, or %s", status, StatusSuccess, StatusFailure, StatusDegraded)
+		}
+	}
+	return n, nil
+}
+
+// Progress implements control.Observer.
+func (n *Notifier) Progress(operation string, event helpers.ProgressEvent) {
+	if event.Type == helpers.ProgressRetry {
+		n.retriesMu.Lock()
+		n.retries[jobKey{operation, event.VolumeName, event.Snapshot}]++
+		n.retriesMu.Unlock()
+	}
+}
+
+// Finished implements control.Observer.
+func (n *Notifier) Finished(result control.Result) {
+	key := jobKey{result.Operation, result.VolumeName, result.Snapshot}
+	n.retriesMu.Lock()
+	retries := n.retries[key]
+	delete(n.retries, key)
+	n.retriesMu.Unlock()
+
+	event := Event{Result: result, Status: StatusSuccess, Retries: retries}
+	if result.Failed() {
+		event.Status = StatusFailure
+	} else if event.Retries > 0 {
+		event.Status = StatusDegraded
+	}
+	if !n.statuses[event.Status] {
+		return
+	}
+	event.Hostname, _ = os.Hostname()
+
+	message, err := n.Render(event)
+	if err != nil {
+		helpers.AppLogger.Warningf("Could not render notification message - %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(len(n.senders))*requestTimeout)
+	defer cancel()
+	for _, sender := range n.senders {
+		if serr := sender.Send(ctx, event, message); serr != nil {
+			helpers.AppLogger.Warningf("Could not send notification - %v", serr)
+		}
+	}
+}
+
+// Render will render the notification message for the event.
+func (n *Notifier) Render(event Event) (string, error) {
+	var b bytes.Buffer
+	if err := n.tmpl.Execute(&b, event); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,198 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+type recordedRequest struct {
+	path        string
+	contentType string
+	body        string
+}
+
+type recorder struct {
+	mu       sync.Mutex
+	requests []recordedRequest
+}
+
+func (r *recorder) server() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, recordedRequest{req.URL.Path, req.Header.Get("Content-Type"), string(body)})
+		r.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestHealthcheck(t *testing.T) {
+	rec := &recorder{}
+	server := rec.server()
+	defer server.Close()
+
+	h := NewHealthcheck(server.URL + "/ping/uuid/")
+	h.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressJobStarted, VolumeName: "tank/data", Snapshot: "snap1"})
+	// Only the first start is pinged
+	h.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressJobStarted, VolumeName: "tank/data", Snapshot: "snap1"})
+	h.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressVolumeUploaded, VolumeName: "tank/data", Snapshot: "snap1"})
+	h.Finished(control.Result{Operation: "send", VolumeName: "tank/data", Snapshot: "snap1", StartTime: time.Now(), EndTime: time.Now()})
+	h.Finished(control.Result{Operation: "send", VolumeName: "tank/data", Snapshot: "snap1", StartTime: time.Now(), EndTime: time.Now(), Error: "upload failed"})
+
+	expected := []string{"/ping/uuid/start", "/ping/uuid", "/ping/uuid/fail"}
+	if len(rec.requests) != len(expected) {
+		t.Fatalf("expected %d pings, got %d: %+v", len(expected), len(rec.requests), rec.requests)
+	}
+	for idx := range expected {
+		if rec.requests[idx].path != expected[idx] {
+			t.Errorf("expected ping to %s, got %s", expected[idx], rec.requests[idx].path)
+		}
+	}
+	if !strings.Contains(rec.requests[2].body, "upload failed") {
+		t.Errorf("expected the failure ping to include the error, got %s", rec.requests[2].body)
+	}
+}
+
+type mockSender struct {
+	events   []Event
+	messages []string
+}
+
+func (m *mockSender) Send(ctx context.Context, event Event, message string) error {
+	m.events = append(m.events, event)
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func TestNotifier(t *testing.T) {
+	if _, err := NewNotifier("", []string{"sometimes"}); err == nil {
+		t.Errorf("expected an error for an unknown status")
+	}
+	if _, err := NewNotifier("{{.Missing", nil); err == nil {
+		t.Errorf("expected an error for an invalid template")
+	}
+
+	sender := &mockSender{}
+	n, err := NewNotifier("", []string{"failure", " Degraded"}, sender)
+	if err != nil {
+		t.Fatalf("could not create notifier - %v", err)
+	}
+
+	result := control.Result{
+		Operation:    "send",
+		VolumeName:   "tank/data",
+		Snapshot:     "snap1",
+		Destinations: []string{"gs://bucket"},
+		StartTime:    time.Unix(0, 0),
+		EndTime:      time.Unix(90, 0),
+		Bytes:        2048,
+	}
+
+	// Success is not requested
+	n.Finished(result)
+	// Degraded, the retry of another job running at the same time is not counted
+	n.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressRetry, VolumeName: "tank/data", Snapshot: "snap1"})
+	n.Progress("send", helpers.ProgressEvent{Type: helpers.ProgressRetry, VolumeName: "tank/other", Snapshot: "snap1"})
+	n.Finished(result)
+	// Failure
+	result.Error = "upload failed"
+	n.Finished(result)
+	// The other job is notified of its own retry
+	n.Finished(control.Result{Operation: "send", VolumeName: "tank/other", Snapshot: "snap1"})
+
+	if len(sender.events) != 3 {
+		t.Fatalf("expected 3 notifications, got %d", len(sender.events))
+	}
+	if sender.events[2].Status != StatusDegraded || sender.events[2].Retries != 1 {
+		t.Errorf("expected the other job to be degraded with its own retry, got %+v", sender.events[2])
+	}
+	if sender.events[0].Status != StatusDegraded || sender.events[0].Retries != 1 {
+		t.Errorf("expected a degraded notification with 1 retry, got %+v", sender.events[0])
+	}
+	if sender.events[1].Status != StatusFailure || sender.events[1].Retries != 0 {
+		t.Errorf("expected a failure notification without retries, got %+v", sender.events[1])
+	}
+	for _, expected := range []string{"send of tank/data@snap1", "status failure in 1m30s", "2.0 KiB", "gs://bucket", "Error: upload failed"} {
+		if !strings.Contains(sender.messages[1], expected) {
+			t.Errorf("expected message to contain %q, got %s", expected, sender.messages[1])
+		}
+	}
+}
+
+func TestWebhookAndSlack(t *testing.T) {
+	rec := &recorder{}
+	server := rec.server()
+	defer server.Close()
+
+	event := Event{Result: control.Result{Operation: "send", VolumeName: "tank/data", Error: "upload failed"}, Status: StatusFailure}
+	if err := NewWebhook(server.URL+"/hook").Send(context.Background(), event, "message"); err != nil {
+		t.Fatalf("could not send webhook - %v", err)
+	}
+	if err := NewSlack(server.URL+"/slack").Send(context.Background(), event, "message"); err != nil {
+		t.Fatalf("could not send slack message - %v", err)
+	}
+
+	if len(rec.requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(rec.requests))
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(rec.requests[0].body), &payload); err != nil {
+		t.Fatalf("could not decode webhook payload - %v", err)
+	}
+	if payload["Status"] != StatusFailure || payload["VolumeName"] != "tank/data" || payload["Error"] != "upload failed" || payload["Message"] != "message" {
+		t.Errorf("unexpected webhook payload: %v", payload)
+	}
+	if rec.requests[0].contentType != "application/json" {
+		t.Errorf("expected a JSON webhook, got %s", rec.requests[0].contentType)
+	}
+
+	if rec.requests[1].path != "/slack" || rec.requests[1].body != `{"text":"message"}` {
+		t.Errorf("unexpected slack request: %+v", rec.requests[1])
+	}
+}
+
+func TestBuildEmail(t *testing.T) {
+	msg := string(buildEmail("zfsbackup@example.com", []string{"a@example.com", "b@example.com"}, "subject", "line 1\nline 2", time.Unix(0, 0).UTC()))
+	for _, expected := range []string{
+		"From: zfsbackup@example.com\r\n",
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: subject\r\n",
+		"Date: Thu, 01 Jan 1970 00:00:00 +0000\r\n",
+		"\r\n\r\nline 1\r\nline 2\r\n",
+	} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("expected email to contain %q, got %q", expected, msg)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Webhook is a Sender that POSTs the event, along with the rendered message, as JSON.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook will return a Webhook posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: requestTimeout}}
+}
+
+// Send implements Sender.
+func (w *Webhook) Send(ctx context.Context, event Event, message string) error {
+	return postJSON(ctx, w.client, w.url, struct {
+		Event
+		Message string
+	}{event, message})
+}
+
+// Slack is a Sender that posts the rendered message to a Slack compatible incoming webhook.
+type Slack struct {
+	url    string
+	client *http.Client
+}
+
+// NewSlack will return a Slack sender posting to the incoming webhook at url.
+func NewSlack(url string) *Slack {
+	return &Slack{url: url, client: &http.Client{Timeout: requestTimeout}}
+}
+
+// Send implements Sender.
+func (s *Slack) Send(ctx context.Context, event Event, message string) error {
+	return postJSON(ctx, s.client, s.url, map[string]string{"text": message})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with unexpected status %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package pinentry prompts for a secret with a pinentry program, as used by GnuPG, speaking the Assuan
+// protocol to it over its stdin and stdout.
+package pinentry
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// The libgpg-error codes of a prompt that was cancelled or closed
+const (
+	errCanceled     = 99
+	errNotConfirmed = 114
+)
+
+// ErrCancelled is returned when the prompt was cancelled or closed instead of a secret being entered.
+var ErrCancelled = errors.New("pinentry: cancelled")
+
+// Prompt describes what to ask for.
+type Prompt struct {
+	Title       string // The title of the window
+	Description string // What the secret is asked for, e.g. which key it unlocks
+	Prompt      string // The label of the input, e.g. Passphrase:
+	Error       string // Shown above the description when asking again after a wrong secret
+	TTYName     string // The terminal a curses or tty pinentry prompts on, usually GPG_TTY
+	TTYType     string // The type of that terminal, usually TERM
+	Display     string // The X display a graphical pinentry prompts on, usually DISPLAY
+}
+
+// GetPIN will run the pinentry program at path and return the secret entered at the prompt described.
+func GetPIN(ctx context.Context, path string, p Prompt) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := &conn{w: stdin, r: bufio.NewReader(stdout)}
+	pin, err := c.getPIN(p)
+	// Let it exit on its own, it may still be drawing or restoring the terminal
+	c.command("BYE")
+	stdin.Close()
+	if werr := cmd.Wait(); werr != nil && err == nil {
+		err = werr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pin, nil
+}
+
+// conn speaks the Assuan protocol to a pinentry program.
+type conn struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func (c *conn) getPIN(p Prompt) ([]byte, error) {
+	if _, err := c.response(); err != nil {
+		return nil, err
+	}
+
+	// A pinentry may not know about every option, they only help it find where to prompt
+	options := []struct{ name, value string }{
+		{"ttyname", p.TTYName},
+		{"ttytype", p.TTYType},
+		{"display", p.Display},
+	}
+	for _, o := range options {
+		if o.value != "" {
+			c.command("OPTION " + o.name + "=" + o.value)
+		}
+	}
+
+	settings := []struct{ command, value string }{
+		{"SETTITLE", p.Title},
+		{"SETDESC", p.Description},
+		{"SETPROMPT", p.Prompt},
+		{"SETERROR", p.Error},
+	}
+	for _, s := range settings {
+		if s.value == "" {
+			continue
+		}
+		if _, err := c.command(s.command + " " + escape(s.value)); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := c.command("GETPIN")
+	if err != nil {
+		return nil, err
+	}
+	return []byte(unescape(data)), nil
+}
+
+// command will send the command and return the data it responded with.
+func (c *conn) command(command string) (string, error) {
+	if _, err := io.WriteString(c.w, command+"\n"); err != nil {
+		return "", err
+	}
+	return c.response()
+}
+
+// response will read lines until the OK or ERR ending a response and return the data lines joined.
+func (c *conn) response() (string, error) {
+	var data strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return "", ErrCancelled
+			}
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.String(), nil
+		case strings.HasPrefix(line, "D "):
+			data.WriteString(line[2:])
+		case strings.HasPrefix(line, "ERR "):
+			// The low 16 bits of the code are the error, the rest its source
+			fields := strings.Fields(line)
+			if len(fields) > 1 {
+				if code, perr := strconv.ParseUint(fields[1], 10, 32); perr == nil && (code&0xffff == errCanceled || code&0xffff == errNotConfirmed) {
+					return "", ErrCancelled
+				}
+			}
+			return "", fmt.Errorf("pinentry: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		// Status (S) and comment (#) lines are of no interest
+	}
+}
+
+// escape will percent encode what can't be sent in a line as Assuan expects.
+func escape(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// unescape will decode the percent encoded data of a response.
+func unescape(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
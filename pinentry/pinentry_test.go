@@ -0,0 +1,139 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pinentry
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscape(t *testing.T) {
+	testCases := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"Plain", "Passphrase:", "Passphrase:"},
+		{"Percent", "100%", "100%25"},
+		{"Lines", "a\r\nb", "a%0D%0Ab"},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			escaped := escape(c.value)
+			if escaped != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, escaped)
+			}
+			if unescaped := unescape(escaped); unescaped != c.value {
+				t.Errorf("expected %q back, got %q", c.value, unescaped)
+			}
+		})
+	}
+}
+
+func TestResponse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		lines    string
+		expected string
+		err      error
+	}{
+		{"OK", "OK Pleased to meet you\n", "", nil},
+		{"Data", "S PASSWORD_FROM_CACHE\nD sec\nD ret%25\nOK\n", "secret%25", nil},
+		{"Cancelled", "ERR 83886179 Operation cancelled <Pinentry>\n", "", ErrCancelled},
+		{"NotConfirmed", "ERR 83886194 Not confirmed <Pinentry>\n", "", ErrCancelled},
+		{"Closed", "D sec", "", ErrCancelled},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			conn := &conn{w: ioutil.Discard, r: bufio.NewReader(strings.NewReader(c.lines))}
+			data, err := conn.response()
+			if err != c.err {
+				t.Fatalf("expected error %v, got %v", c.err, err)
+			}
+			if data != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, data)
+			}
+		})
+	}
+
+	conn := &conn{w: ioutil.Discard, r: bufio.NewReader(strings.NewReader("ERR 83886360 Invalid option\n"))}
+	if _, err := conn.response(); err == nil || err == ErrCancelled {
+		t.Errorf("expected an error other than ErrCancelled, got %v", err)
+	}
+}
+
+// fakePinentry answers GETPIN with the secret and logs the commands it was sent to log.
+const fakePinentry = `#!/bin/sh
+echo "OK Pleased to meet you"
+while read -r cmd; do
+	echo "$cmd" >> "%s"
+	case "$cmd" in
+	GETPIN) echo "D %s"; echo OK;;
+	BYE) echo OK; exit 0;;
+	*) echo OK;;
+	esac
+done
+`
+
+func TestGetPIN(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pinentry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "commands")
+	path := filepath.Join(dir, "pinentry")
+	script := strings.Replace(strings.Replace(fakePinentry, "%s", log, 1), "%s", "open%25sesame", 1)
+	if err = ioutil.WriteFile(path, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	pin, err := GetPIN(context.Background(), path, Prompt{
+		Description: "Unlock the key\nof backup@example.com",
+		Prompt:      "Passphrase:",
+		Error:       "Wrong passphrase",
+		TTYName:     "/dev/pts/1",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !bytes.Equal(pin, []byte("open%sesame")) {
+		t.Errorf("expected open%%sesame, got %q", pin)
+	}
+
+	commands, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "OPTION ttyname=/dev/pts/1\nSETDESC Unlock the key%0Aof backup@example.com\nSETPROMPT Passphrase:\nSETERROR Wrong passphrase\nGETPIN\nBYE\n"
+	if string(commands) != expected {
+		t.Errorf("expected the commands %q, got %q", expected, commands)
+	}
+}
@@ -0,0 +1,489 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package rpc exposes the send, receive, list, and verify operations of zfsbackup
+// over gRPC so that other services may drive backups with typed clients.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative zfsbackup.proto
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/control"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// historySize is the number of finished jobs the Server remembers.
+const historySize = 100
+
+// Server implements the ZFSBackupServer interface on top of the backup package.
+type Server struct {
+	UnimplementedZFSBackupServer
+
+	defaults  helpers.JobInfo
+	history   *control.History
+	observers []control.Observer
+
+	jobs    helpers.Semaphore
+	perPool bool
+	poolsMu sync.Mutex
+	pools   map[string]helpers.Semaphore
+}
+
+// NewServer will return a Server that uses the manifest prefix and PGP keys found
+// in defaults for every job it runs. Any option not provided in a request will
+// fall back to the same default the command line uses.
+func NewServer(defaults helpers.JobInfo) *Server {
+	return &Server{defaults: defaults, history: control.NewHistory(historySize)}
+}
+
+// History will return the results of the most recent jobs run by the Server.
+func (s *Server) History() *control.History {
+	return s.history
+}
+
+// AddObserver will notify o of the progress and outcome of every job run by the Server.
+// It must be called before the Server is registered.
+func (s *Server) AddObserver(o control.Observer) {
+	s.observers = append(s.observers, o)
+}
+
+// Limit will make the Server run at most maxJobs send, receive, and verify jobs at the same time, or any
+// number of them if maxJobs is not positive. If perPool is set, only one send or receive job runs against a
+// ZFS pool at a time. Jobs over either limit wait for their turn. It must be called before the Server is registered.
+func (s *Server) Limit(maxJobs int, perPool bool) {
+	s.jobs = helpers.NewSemaphore(maxJobs)
+	s.perPool = perPool
+	s.pools = make(map[string]helpers.Semaphore)
+}
+
+// admit will block until the job may run within the limits of the Server, returning the function to call once
+// it is done. Pass an empty pool for jobs that do not touch a ZFS pool.
+func (s *Server) admit(ctx context.Context, operation, volume, pool string) (func(), error) {
+	var poolLock helpers.Semaphore
+	if s.perPool && pool != "" {
+		s.poolsMu.Lock()
+		poolLock = s.pools[pool]
+		if poolLock == nil {
+			poolLock = helpers.NewSemaphore(1)
+			s.pools[pool] = poolLock
+		}
+		s.poolsMu.Unlock()
+	}
+
+	// The pool is taken first so a job waiting on a busy pool doesn't hold a slot another pool could use
+	if !poolLock.TryAcquire() {
+		helpers.AppLogger.Noticef("Queued %s of %s until the running job on pool %s is done.", operation, volume, pool)
+		if err := poolLock.Acquire(ctx); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+	}
+	if !s.jobs.TryAcquire() {
+		helpers.AppLogger.Noticef("Queued %s of %s until one of the %d running jobs is done.", operation, volume, cap(s.jobs))
+		if err := s.jobs.Acquire(ctx); err != nil {
+			poolLock.Release()
+			return nil, status.FromContextError(err).Err()
+		}
+	}
+	return func() {
+		s.jobs.Release()
+		poolLock.Release()
+	}, nil
+}
+
+func (s *Server) record(operation string, j *helpers.JobInfo, err error) {
+	result := control.NewResult(operation, j, err)
+	s.history.Record(result)
+	for _, o := range s.observers {
+		o.Finished(result)
+	}
+}
+
+// progress returns a ProgressFunc that streams every event to the caller and passes it along to the observers.
+func (s *Server) progress(operation string, stream progressStream) helpers.ProgressFunc {
+	send := progressSender(stream)
+	return func(event helpers.ProgressEvent) {
+		send(event)
+		for _, o := range s.observers {
+			o.Progress(operation, event)
+		}
+	}
+}
+
+// Register will register the Server with the provided grpc.Server.
+func (s *Server) Register(g *grpc.Server) {
+	RegisterZFSBackupServer(g, s)
+}
+
+func (s *Server) newJobInfo() *helpers.JobInfo {
+	return &helpers.JobInfo{
+		StartTime:          time.Now(),
+		Version:            helpers.VersionNumber,
+		ManifestPrefix:     s.defaults.ManifestPrefix,
+		ManifestWorkers:    s.defaults.ManifestWorkers,
+		EncryptTo:          s.defaults.EncryptTo,
+		SignFrom:           s.defaults.SignFrom,
+		EncryptKey:         s.defaults.EncryptKey,
+		SignKey:            s.defaults.SignKey,
+		Compressor:         helpers.InternalCompressor,
+		CompressionLevel:   6,
+		DigestAlgorithm:    helpers.DigestSHA256,
+		VolumeSize:         200,
+		MaxFileBuffer:      5,
+		MaxParallelUploads: 4,
+		MaxRetryTime:       12 * time.Hour,
+		MaxBackoffTime:     30 * time.Minute,
+		Separator:          "|",
+		UploadChunkSize:    10,
+		SendReadSize:       helpers.DefaultSendReadSize,
+		CompressBlockSize:  helpers.DefaultCompressorBlockSize,
+		UploadReadSize:     helpers.DefaultUploadReadSize,
+		FullIfOlderThan:    -1 * time.Minute,
+	}
+}
+
+// Send will run a backup as described by the request, streaming progress as it goes.
+func (s *Server) Send(req *SendRequest, stream ZFSBackup_SendServer) error {
+	ctx := stream.Context()
+	j := s.newJobInfo()
+
+	parts := strings.Split(req.GetVolume(), "@")
+	j.VolumeName = parts[0]
+	j.Destinations = req.GetDestinations()
+	j.Replication = req.GetReplication()
+	j.Deduplication = req.GetDeduplication()
+	j.Properties = req.GetProperties()
+	j.Full = req.GetFull()
+	j.Incremental = req.GetIncrement()
+	j.Resume = req.GetResume()
+	if req.GetIncremental() != "" && req.GetIntermediary() != "" {
+		return status.Error(codes.InvalidArgument, "incremental and intermediary are mutually exclusive")
+	}
+	j.IncrementalSnapshot.Name = req.GetIncremental()
+	if req.GetIntermediary() != "" {
+		j.IncrementalSnapshot.Name = req.GetIntermediary()
+		j.IntermediaryIncremental = true
+	}
+	if req.GetFullIfOlderThan() != nil {
+		j.FullIfOlderThan = req.GetFullIfOlderThan().AsDuration()
+	}
+	if req.GetCompressor() != "" {
+		j.Compressor = req.GetCompressor()
+	}
+	if req.GetCompressionLevel() != 0 {
+		j.CompressionLevel = int(req.GetCompressionLevel())
+	}
+	if req.GetVolumeSize() != 0 {
+		j.VolumeSize = req.GetVolumeSize()
+	}
+	if req.GetMaxFileBuffer() != 0 {
+		j.MaxFileBuffer = int(req.GetMaxFileBuffer())
+	}
+	if req.GetMaxParallelUploads() != 0 {
+		j.MaxParallelUploads = int(req.GetMaxParallelUploads())
+	}
+	if req.GetUploadChunkSize() != 0 {
+		j.UploadChunkSize = int(req.GetUploadChunkSize())
+	}
+	applyCommonOptions(j, req.GetMaxRetryTime(), req.GetMaxBackoffTime(), req.GetSeparator())
+
+	if j.VolumeName == "" {
+		return status.Error(codes.InvalidArgument, "a volume to backup is required")
+	}
+	if err := validateDestinations(j.Destinations); err != nil {
+		return err
+	}
+	if err := j.ValidateSendFlags(stream.Context()); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if !j.Full && !j.Incremental && j.FullIfOlderThan == -1*time.Minute {
+		if len(parts) != 2 {
+			return status.Errorf(codes.InvalidArgument, "invalid base snapshot provided, expected format <volume>@<snapshot>, got %s instead", req.GetVolume())
+		}
+		j.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+		creationTime, err := helpers.GetCreationDate(ctx, req.GetVolume())
+		if err != nil {
+			return status.Errorf(codes.FailedPrecondition, "could not get creation date of base snapshot - %v", err)
+		}
+		j.BaseSnapshot.CreationTime = creationTime
+
+		if j.IncrementalSnapshot.Name != "" {
+			j.IncrementalSnapshot.Name = strings.TrimPrefix(j.IncrementalSnapshot.Name, j.VolumeName)
+			j.IncrementalSnapshot.Name = strings.TrimPrefix(j.IncrementalSnapshot.Name, "@")
+			creationTime, err = helpers.GetCreationDate(ctx, fmt.Sprintf("%s@%s", j.VolumeName, j.IncrementalSnapshot.Name))
+			if err != nil {
+				return status.Errorf(codes.FailedPrecondition, "could not get creation date of incremental snapshot - %v", err)
+			}
+			j.IncrementalSnapshot.CreationTime = creationTime
+		}
+	} else {
+		if len(parts) != 1 {
+			return status.Error(codes.InvalidArgument, "when using a smart option, only specify the volume to backup without any snapshot information")
+		}
+		if err := backup.ProcessSmartOptions(ctx, j); err != nil {
+			return status.Errorf(codes.FailedPrecondition, "could not process smart option - %v", err)
+		}
+	}
+
+	done, err := s.admit(ctx, "send", j.VolumeName, poolOf(j.VolumeName))
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	j.Progress = s.progress("send", stream)
+	err = backup.Backup(ctx, j)
+	s.record("send", j, err)
+	return err
+}
+
+// Receive will restore a backup as described by the request, streaming progress as it goes.
+func (s *Server) Receive(req *ReceiveRequest, stream ZFSBackup_ReceiveServer) error {
+	ctx := stream.Context()
+	j := s.newJobInfo()
+
+	parts := strings.Split(req.GetVolume(), "@")
+	j.VolumeName = parts[0]
+	j.AutoRestore = req.GetAuto()
+	j.FullPath = req.GetFullPath()
+	j.LastPath = req.GetLastPath()
+	j.Force = req.GetForce()
+	j.NotMounted = req.GetUnmounted()
+	j.Origin = strings.TrimPrefix(req.GetOrigin(), "origin=")
+	j.LocalVolume = req.GetLocalVolume()
+	j.Destinations = []string{req.GetDestination()}
+	if req.GetMaxFileBuffer() != 0 {
+		j.MaxFileBuffer = int(req.GetMaxFileBuffer())
+	}
+	applyCommonOptions(j, req.GetMaxRetryTime(), req.GetMaxBackoffTime(), req.GetSeparator())
+
+	if len(parts) != 2 && !j.AutoRestore {
+		return status.Errorf(codes.InvalidArgument, "invalid base snapshot provided, expected format <volume>@<snapshot>, got %s instead", req.GetVolume())
+	} else if len(parts) == 2 {
+		j.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+		j.AutoRestore = j.AutoRestore || backup.IsLatestSelector(parts[1])
+	}
+	if j.LocalVolume == "" {
+		return status.Error(codes.InvalidArgument, "a local volume to restore to is required")
+	}
+	if j.FullPath && j.LastPath {
+		return status.Error(codes.InvalidArgument, "full_path and last_path are mutually exclusive")
+	}
+	if j.AutoRestore && req.GetIncremental() != "" {
+		return status.Error(codes.InvalidArgument, "cannot request auto restore and provide an incremental snapshot to restore from")
+	}
+	if err := validateDestinations(j.Destinations); err != nil {
+		return err
+	}
+
+	if !j.AutoRestore {
+		if creationTime, err := helpers.GetCreationDate(ctx, fmt.Sprintf("%s@%s", j.LocalVolume, j.BaseSnapshot.Name)); err == nil {
+			j.BaseSnapshot.CreationTime = creationTime
+		}
+		if req.GetIncremental() != "" {
+			j.IncrementalSnapshot.Name = strings.TrimPrefix(req.GetIncremental(), j.VolumeName)
+			j.IncrementalSnapshot.Name = strings.TrimPrefix(j.IncrementalSnapshot.Name, "@")
+			if creationTime, err := helpers.GetCreationDate(ctx, fmt.Sprintf("%s@%s", j.LocalVolume, j.IncrementalSnapshot.Name)); err == nil {
+				j.IncrementalSnapshot.CreationTime = creationTime
+			}
+		}
+	}
+
+	done, err := s.admit(ctx, "receive", j.LocalVolume, poolOf(j.LocalVolume))
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	j.Progress = s.progress("receive", stream)
+	if j.AutoRestore {
+		err = backup.AutoRestore(ctx, j)
+	} else {
+		err = backup.Receive(ctx, j)
+	}
+	s.record("receive", j, err)
+	return err
+}
+
+// List will return the backup sets found at the requested destination.
+func (s *Server) List(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	j := s.newJobInfo()
+	j.Destinations = []string{req.GetDestination()}
+	if err := validateDestinations(j.Destinations); err != nil {
+		return nil, err
+	}
+
+	var before, after time.Time
+	if req.GetBefore() != nil {
+		before = req.GetBefore().AsTime()
+	}
+	if req.GetAfter() != nil {
+		after = req.GetAfter().AsTime()
+	}
+
+	sets, err := backup.ListBackupSets(ctx, j, req.GetVolumeName(), before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ListResponse{BackupSets: make([]*BackupSet, 0, len(sets))}
+	for _, set := range sets {
+		resp.BackupSets = append(resp.BackupSets, toBackupSet(set))
+	}
+	return resp, nil
+}
+
+// Verify will download and check every volume of the requested backup set, streaming progress as it goes.
+func (s *Server) Verify(req *VerifyRequest, stream ZFSBackup_VerifyServer) error {
+	j := s.newJobInfo()
+
+	parts := strings.Split(req.GetVolume(), "@")
+	if len(parts) != 2 {
+		return status.Errorf(codes.InvalidArgument, "invalid snapshot provided, expected format <volume>@<snapshot>, got %s instead", req.GetVolume())
+	}
+	j.VolumeName = parts[0]
+	j.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+	j.Destinations = []string{req.GetDestination()}
+	if req.GetIncremental() != "" {
+		j.IncrementalSnapshot.Name = strings.TrimPrefix(req.GetIncremental(), j.VolumeName)
+		j.IncrementalSnapshot.Name = strings.TrimPrefix(j.IncrementalSnapshot.Name, "@")
+	}
+	if req.GetMaxFileBuffer() != 0 {
+		j.MaxFileBuffer = int(req.GetMaxFileBuffer())
+	}
+	applyCommonOptions(j, req.GetMaxRetryTime(), req.GetMaxBackoffTime(), req.GetSeparator())
+
+	if err := validateDestinations(j.Destinations); err != nil {
+		return err
+	}
+
+	done, err := s.admit(stream.Context(), "verify", j.VolumeName, "")
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	j.Progress = s.progress("verify", stream)
+	err = backup.Verify(stream.Context(), j)
+	s.record("verify", j, err)
+	return err
+}
+
+// poolOf will return the name of the pool the volume lives in.
+func poolOf(volume string) string {
+	return strings.SplitN(volume, "/", 2)[0]
+}
+
+func applyCommonOptions(j *helpers.JobInfo, maxRetryTime, maxBackoffTime *durationpb.Duration, separator string) {
+	if maxRetryTime != nil {
+		j.MaxRetryTime = maxRetryTime.AsDuration()
+	}
+	if maxBackoffTime != nil {
+		j.MaxBackoffTime = maxBackoffTime.AsDuration()
+	}
+	if separator != "" {
+		j.Separator = separator
+	}
+}
+
+func validateDestinations(destinations []string) error {
+	if len(destinations) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one destination is required")
+	}
+	for _, destination := range destinations {
+		_, err := backends.GetBackendForURI(destination)
+		if err == backends.ErrInvalidPrefix {
+			return status.Errorf(codes.InvalidArgument, "unsupported prefix provided in destination URI, was given %s", destination)
+		} else if err == backends.ErrInvalidURI {
+			return status.Errorf(codes.InvalidArgument, "invalid destination URI, was given %s", destination)
+		}
+	}
+	return nil
+}
+
+type progressStream interface {
+	Send(*ProgressUpdate) error
+}
+
+// progressSender returns a ProgressFunc that forwards every event to the stream. Jobs
+// report progress from several goroutines so sends are serialized.
+func progressSender(stream progressStream) helpers.ProgressFunc {
+	var mu sync.Mutex
+	return func(event helpers.ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := stream.Send(toProgressUpdate(event)); err != nil {
+			helpers.AppLogger.Warningf("Could not send progress update to client - %v", err)
+		}
+	}
+}
+
+func toProgressUpdate(event helpers.ProgressEvent) *ProgressUpdate {
+	return &ProgressUpdate{
+		Type:         ProgressUpdate_Type(event.Type),
+		Time:         timestamppb.New(event.Time),
+		VolumeName:   event.VolumeName,
+		Snapshot:     event.Snapshot,
+		ObjectName:   event.ObjectName,
+		Destination:  event.Destination,
+		VolumeNumber: event.VolumeNumber,
+		Bytes:        event.Bytes,
+		Message:      event.Message,
+	}
+}
+
+func toSnapshot(s helpers.SnapshotInfo) *Snapshot {
+	if s.Name == "" {
+		return nil
+	}
+	return &Snapshot{Name: s.Name, CreationTime: timestamppb.New(s.CreationTime)}
+}
+
+func toBackupSet(j *helpers.JobInfo) *BackupSet {
+	return &BackupSet{
+		VolumeName:              j.VolumeName,
+		BaseSnapshot:            toSnapshot(j.BaseSnapshot),
+		IncrementalSnapshot:     toSnapshot(j.IncrementalSnapshot),
+		IntermediaryIncremental: j.IntermediaryIncremental,
+		Replication:             j.Replication,
+		Compressor:              j.Compressor,
+		EncryptTo:               j.EncryptTo,
+		SignFrom:                j.SignFrom,
+		VolumeCount:             int64(len(j.Volumes)),
+		TotalBytes:              j.TotalBytesWritten(),
+		ZfsStreamBytes:          j.ZFSStreamBytes,
+		StartTime:               timestamppb.New(j.StartTime),
+		EndTime:                 timestamppb.New(j.EndTime),
+	}
+}
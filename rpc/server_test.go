@@ -0,0 +1,182 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+type mockProgressStream struct {
+	grpc.ServerStream
+	updates []*ProgressUpdate
+}
+
+func (m *mockProgressStream) Context() context.Context {
+	return context.Background()
+}
+
+func (m *mockProgressStream) Send(u *ProgressUpdate) error {
+	m.updates = append(m.updates, u)
+	return nil
+}
+
+func TestProgressSender(t *testing.T) {
+	stream := &mockProgressStream{}
+	now := time.Now()
+	progressSender(stream)(helpers.ProgressEvent{
+		Type:         helpers.ProgressVolumeUploaded,
+		Time:         now,
+		VolumeName:   "tank/data",
+		Snapshot:     "snap1",
+		ObjectName:   "tank/data|snap1.zstream.gz.vol1",
+		VolumeNumber: 1,
+		Bytes:        1024,
+	})
+
+	if len(stream.updates) != 1 {
+		t.Fatalf("expected 1 update, got %d", len(stream.updates))
+	}
+	u := stream.updates[0]
+	if u.GetType() != ProgressUpdate_VOLUME_UPLOADED {
+		t.Errorf("expected type %v, got %v", ProgressUpdate_VOLUME_UPLOADED, u.GetType())
+	}
+	if !u.GetTime().AsTime().Equal(now) {
+		t.Errorf("expected time %v, got %v", now, u.GetTime().AsTime())
+	}
+	if u.GetVolumeNumber() != 1 || u.GetBytes() != 1024 || u.GetSnapshot() != "snap1" {
+		t.Errorf("unexpected update contents: %v", u)
+	}
+}
+
+func TestProgressTypesMatch(t *testing.T) {
+	testCases := map[helpers.ProgressEventType]ProgressUpdate_Type{
+		helpers.ProgressJobStarted:       ProgressUpdate_JOB_STARTED,
+		helpers.ProgressVolumeCreated:    ProgressUpdate_VOLUME_CREATED,
+		helpers.ProgressVolumeUploaded:   ProgressUpdate_VOLUME_UPLOADED,
+		helpers.ProgressVolumeDownloaded: ProgressUpdate_VOLUME_DOWNLOADED,
+		helpers.ProgressVolumeRestored:   ProgressUpdate_VOLUME_RESTORED,
+		helpers.ProgressVolumeVerified:   ProgressUpdate_VOLUME_VERIFIED,
+		helpers.ProgressJobFinished:      ProgressUpdate_JOB_FINISHED,
+		helpers.ProgressJobPlanned:       ProgressUpdate_JOB_PLANNED,
+		helpers.ProgressRetry:            ProgressUpdate_RETRY,
+	}
+
+	for event, expected := range testCases {
+		if got := toProgressUpdate(helpers.ProgressEvent{Type: event}).GetType(); got != expected {
+			t.Errorf("%v: expected %v, got %v", event, expected, got)
+		}
+	}
+}
+
+func TestToBackupSet(t *testing.T) {
+	j := &helpers.JobInfo{
+		VolumeName:     "tank/data",
+		BaseSnapshot:   helpers.SnapshotInfo{Name: "snap2", CreationTime: time.Now()},
+		ZFSStreamBytes: 300,
+		Volumes: []*helpers.VolumeInfo{
+			{Size: 100},
+			{Size: 50},
+		},
+	}
+
+	set := toBackupSet(j)
+	if set.GetIncrementalSnapshot() != nil {
+		t.Errorf("expected no incremental snapshot, got %v", set.GetIncrementalSnapshot())
+	}
+	if set.GetBaseSnapshot().GetName() != "snap2" {
+		t.Errorf("expected base snapshot snap2, got %s", set.GetBaseSnapshot().GetName())
+	}
+	if set.GetVolumeCount() != 2 || set.GetTotalBytes() != 150 || set.GetZfsStreamBytes() != 300 {
+		t.Errorf("unexpected backup set contents: %v", set)
+	}
+}
+
+func TestInvalidRequests(t *testing.T) {
+	s := NewServer(helpers.JobInfo{ManifestPrefix: "manifests"})
+
+	_, err := s.List(context.Background(), &ListRequest{Destination: "notvalid://bucket"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("List: expected %v, got %v", codes.InvalidArgument, err)
+	}
+
+	err = s.Verify(&VerifyRequest{Volume: "tank/data", Destination: "file:///tmp"}, &mockProgressStream{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Verify: expected %v, got %v", codes.InvalidArgument, err)
+	}
+
+	err = s.Send(&SendRequest{Volume: "tank/data@snap1"}, &mockProgressStream{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Send: expected %v, got %v", codes.InvalidArgument, err)
+	}
+
+	err = s.Receive(&ReceiveRequest{Volume: "tank/data@snap1", Destination: "file:///tmp"}, &mockProgressStream{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Receive: expected %v, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	s := NewServer(helpers.JobInfo{})
+	s.Limit(2, true)
+
+	admit := func(pool string, wait time.Duration) (func(), error) {
+		ctx, cancel := context.WithTimeout(context.Background(), wait)
+		defer cancel()
+		return s.admit(ctx, "send", pool+"/data", pool)
+	}
+
+	doneTank, err := admit("tank", time.Second)
+	if err != nil {
+		t.Fatalf("expected the first job to run, got %v", err)
+	}
+	if _, err = admit("tank", 50*time.Millisecond); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected a second job on the same pool to wait, got %v", err)
+	}
+	doneBackup, err := admit("backup", time.Second)
+	if err != nil {
+		t.Fatalf("expected a job on another pool to run, got %v", err)
+	}
+	if _, err = admit("", 50*time.Millisecond); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected a job over maxJobs to wait, got %v", err)
+	}
+	doneBackup()
+
+	doneVerify, err := admit("", time.Second)
+	if err != nil {
+		t.Fatalf("expected a job to run once a slot is released, got %v", err)
+	}
+	doneVerify()
+	doneTank()
+
+	if doneTank, err = admit("tank", time.Second); err != nil {
+		t.Errorf("expected a job to run once the pool is released, got %v", err)
+	} else {
+		doneTank()
+	}
+}
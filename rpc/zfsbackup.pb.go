@@ -0,0 +1,1180 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: zfsbackup.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ProgressUpdate_Type int32
+
+const (
+	ProgressUpdate_UNKNOWN           ProgressUpdate_Type = 0
+	ProgressUpdate_JOB_STARTED       ProgressUpdate_Type = 1
+	ProgressUpdate_VOLUME_CREATED    ProgressUpdate_Type = 2
+	ProgressUpdate_VOLUME_UPLOADED   ProgressUpdate_Type = 3
+	ProgressUpdate_VOLUME_DOWNLOADED ProgressUpdate_Type = 4
+	ProgressUpdate_VOLUME_RESTORED   ProgressUpdate_Type = 5
+	ProgressUpdate_VOLUME_VERIFIED   ProgressUpdate_Type = 6
+	ProgressUpdate_JOB_FINISHED      ProgressUpdate_Type = 7
+	// Reported once the expected number of bytes for the job, found in bytes, is known.
+	ProgressUpdate_JOB_PLANNED ProgressUpdate_Type = 8
+	// Reported for every failed attempt at uploading or downloading a volume, the error is found in message.
+	ProgressUpdate_RETRY ProgressUpdate_Type = 9
+)
+
+// Enum value maps for ProgressUpdate_Type.
+var (
+	ProgressUpdate_Type_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "JOB_STARTED",
+		2: "VOLUME_CREATED",
+		3: "VOLUME_UPLOADED",
+		4: "VOLUME_DOWNLOADED",
+		5: "VOLUME_RESTORED",
+		6: "VOLUME_VERIFIED",
+		7: "JOB_FINISHED",
+		8: "JOB_PLANNED",
+		9: "RETRY",
+	}
+	ProgressUpdate_Type_value = map[string]int32{
+		"UNKNOWN":           0,
+		"JOB_STARTED":       1,
+		"VOLUME_CREATED":    2,
+		"VOLUME_UPLOADED":   3,
+		"VOLUME_DOWNLOADED": 4,
+		"VOLUME_RESTORED":   5,
+		"VOLUME_VERIFIED":   6,
+		"JOB_FINISHED":      7,
+		"JOB_PLANNED":       8,
+		"RETRY":             9,
+	}
+)
+
+func (x ProgressUpdate_Type) Enum() *ProgressUpdate_Type {
+	p := new(ProgressUpdate_Type)
+	*p = x
+	return p
+}
+
+func (x ProgressUpdate_Type) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ProgressUpdate_Type) Descriptor() protoreflect.EnumDescriptor {
+	return file_zfsbackup_proto_enumTypes[0].Descriptor()
+}
+
+func (ProgressUpdate_Type) Type() protoreflect.EnumType {
+	return &file_zfsbackup_proto_enumTypes[0]
+}
+
+func (x ProgressUpdate_Type) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ProgressUpdate_Type.Descriptor instead.
+func (ProgressUpdate_Type) EnumDescriptor() ([]byte, []int) {
+	return file_zfsbackup_proto_rawDescGZIP(), []int{7, 0}
+}
+
+// SendRequest mirrors the options of the send command. Unset numeric options
+// fall back to the same defaults the command line uses.
+type SendRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The filesystem|volume|snapshot to backup, e.g. tank/data@snap1
+	Volume       string   `protobuf:"bytes,1,opt,name=volume,proto3" json:"volume,omitempty"`
+	Destinations []string `protobuf:"bytes,2,rep,name=destinations,proto3" json:"destinations,omitempty"`
+	// zfs send options
+	Replication   bool   `protobuf:"varint,3,opt,name=replication,proto3" json:"replication,omitempty"`
+	Deduplication bool   `protobuf:"varint,4,opt,name=deduplication,proto3" json:"deduplication,omitempty"`
+	Properties    bool   `protobuf:"varint,5,opt,name=properties,proto3" json:"properties,omitempty"`
+	Incremental   string `protobuf:"bytes,6,opt,name=incremental,proto3" json:"incremental,omitempty"`
+	Intermediary  string `protobuf:"bytes,7,opt,name=intermediary,proto3" json:"intermediary,omitempty"`
+	// "Smart" options
+	Full               bool                 `protobuf:"varint,8,opt,name=full,proto3" json:"full,omitempty"`
+	Increment          bool                 `protobuf:"varint,9,opt,name=increment,proto3" json:"increment,omitempty"`
+	FullIfOlderThan    *durationpb.Duration `protobuf:"bytes,10,opt,name=full_if_older_than,json=fullIfOlderThan,proto3" json:"full_if_older_than,omitempty"`
+	Resume             bool                 `protobuf:"varint,11,opt,name=resume,proto3" json:"resume,omitempty"`
+	Compressor         string               `protobuf:"bytes,12,opt,name=compressor,proto3" json:"compressor,omitempty"`
+	CompressionLevel   int32                `protobuf:"varint,13,opt,name=compression_level,json=compressionLevel,proto3" json:"compression_level,omitempty"`
+	VolumeSize         uint64               `protobuf:"varint,14,opt,name=volume_size,json=volumeSize,proto3" json:"volume_size,omitempty"`
+	MaxFileBuffer      int32                `protobuf:"varint,15,opt,name=max_file_buffer,json=maxFileBuffer,proto3" json:"max_file_buffer,omitempty"`
+	MaxParallelUploads int32                `protobuf:"varint,16,opt,name=max_parallel_uploads,json=maxParallelUploads,proto3" json:"max_parallel_uploads,omitempty"`
+	MaxRetryTime       *durationpb.Duration `protobuf:"bytes,17,opt,name=max_retry_time,json=maxRetryTime,proto3" json:"max_retry_time,omitempty"`
+	MaxBackoffTime     *durationpb.Duration `protobuf:"bytes,18,opt,name=max_backoff_time,json=maxBackoffTime,proto3" json:"max_backoff_time,omitempty"`
+	Separator          string               `protobuf:"bytes,19,opt,name=separator,proto3" json:"separator,omitempty"`
+	UploadChunkSize    int32                `protobuf:"varint,20,opt,name=upload_chunk_size,json=uploadChunkSize,proto3" json:"upload_chunk_size,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *SendRequest) Reset() {
+	*x = SendRequest{}
+	mi := &file_zfsbackup_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendRequest) ProtoMessage() {}
+
+func (x *SendRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zfsbackup_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendRequest.ProtoReflect.Descriptor instead.
+func (*SendRequest) Descriptor() ([]byte, []int) {
+	return file_zfsbackup_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SendRequest) GetVolume() string {
+	if x != nil {
+		return x.Volume
+	}
+	return ""
+}
+
+func (x *SendRequest) GetDestinations() []string {
+	if x != nil {
+		return x.Destinations
+	}
+	return nil
+}
+
+func (x *SendRequest) GetReplication() bool {
+	if x != nil {
+		return x.Replication
+	}
+	return false
+}
+
+func (x *SendRequest) GetDeduplication() bool {
+	if x != nil {
+		return x.Deduplication
+	}
+	return false
+}
+
+func (x *SendRequest) GetProperties() bool {
+	if x != nil {
+		return x.Properties
+	}
+	return false
+}
+
+func (x *SendRequest) GetIncremental() string {
+	if x != nil {
+		return x.Incremental
+	}
+	return ""
+}
+
+func (x *SendRequest) GetIntermediary() string {
+	if x != nil {
+		return x.Intermediary
+	}
+	return ""
+}
+
+func (x *SendRequest) GetFull() bool {
+	if x != nil {
+		return x.Full
+	}
+	return false
+}
+
+func (x *SendRequest) GetIncrement() bool {
+	if x != nil {
+		return x.Increment
+	}
+	return false
+}
+
+func (x *SendRequest) GetFullIfOlderThan() *durationpb.Duration {
+	if x != nil {
+		return x.FullIfOlderThan
+	}
+	return nil
+}
+
+func (x *SendRequest) GetResume() bool {
+	if x != nil {
+		return x.Resume
+	}
+	return false
+}
+
+func (x *SendRequest) GetCompressor() string {
+	if x != nil {
+		return x.Compressor
+	}
+	return ""
+}
+
+func (x *SendRequest) GetCompressionLevel() int32 {
+	if x != nil {
+		return x.CompressionLevel
+	}
+	return 0
+}
+
+func (x *SendRequest) GetVolumeSize() uint64 {
+	if x != nil {
+		return x.VolumeSize
+	}
+	return 0
+}
+
+func (x *SendRequest) GetMaxFileBuffer() int32 {
+	if x != nil {
+		return x.MaxFileBuffer
+	}
+	return 0
+}
+
+func (x *SendRequest) GetMaxParallelUploads() int32 {
+	if x != nil {
+		return x.MaxParallelUploads
+	}
+	return 0
+}
+
+func (x *SendRequest) GetMaxRetryTime() *durationpb.Duration {
+	if x != nil {
+		return x.MaxRetryTime
+	}
+	return nil
+}
+
+func (x *SendRequest) GetMaxBackoffTime() *durationpb.Duration {
+	if x != nil {
+		return x.MaxBackoffTime
+	}
+	return nil
+}
+
+func (x *SendRequest) GetSeparator() string {
+	if x != nil {
+		return x.Separator
+	}
+	return ""
+}
+
+func (x *SendRequest) GetUploadChunkSize() int32 {
+	if x != nil {
+		return x.UploadChunkSize
+	}
+	return 0
+}
+
+// ReceiveRequest mirrors the options of the receive command.
+type ReceiveRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The filesystem|volume|snapshot to restore, e.g. tank/data@snap1, or tank/data@latest
+	// (or @latest-full) for the newest restore point, implying auto
+	Volume         string               `protobuf:"bytes,1,opt,name=volume,proto3" json:"volume,omitempty"`
+	Destination    string               `protobuf:"bytes,2,opt,name=destination,proto3" json:"destination,omitempty"`
+	LocalVolume    string               `protobuf:"bytes,3,opt,name=local_volume,json=localVolume,proto3" json:"local_volume,omitempty"`
+	Auto           bool                 `protobuf:"varint,4,opt,name=auto,proto3" json:"auto,omitempty"`
+	FullPath       bool                 `protobuf:"varint,5,opt,name=full_path,json=fullPath,proto3" json:"full_path,omitempty"`
+	LastPath       bool                 `protobuf:"varint,6,opt,name=last_path,json=lastPath,proto3" json:"last_path,omitempty"`
+	Force          bool                 `protobuf:"varint,7,opt,name=force,proto3" json:"force,omitempty"`
+	Unmounted      bool                 `protobuf:"varint,8,opt,name=unmounted,proto3" json:"unmounted,omitempty"`
+	Origin         string               `protobuf:"bytes,9,opt,name=origin,proto3" json:"origin,omitempty"`
+	Incremental    string               `protobuf:"bytes,10,opt,name=incremental,proto3" json:"incremental,omitempty"`
+	MaxFileBuffer  int32                `protobuf:"varint,11,opt,name=max_file_buffer,json=maxFileBuffer,proto3" json:"max_file_buffer,omitempty"`
+	MaxRetryTime   *durationpb.Duration `protobuf:"bytes,12,opt,name=max_retry_time,json=maxRetryTime,proto3" json:"max_retry_time,omitempty"`
+	MaxBackoffTime *durationpb.Duration `protobuf:"bytes,13,opt,name=max_backoff_time,json=maxBackoffTime,proto3" json:"max_backoff_time,omitempty"`
+	Separator      string               `protobuf:"bytes,14,opt,name=separator,proto3" json:"separator,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *ReceiveRequest) Reset() {
+	*x = ReceiveRequest{}
+	mi := &file_zfsbackup_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReceiveRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReceiveRequest) ProtoMessage() {}
+
+func (x *ReceiveRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zfsbackup_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReceiveRequest.ProtoReflect.Descriptor instead.
+func (*ReceiveRequest) Descriptor() ([]byte, []int) {
+	return file_zfsbackup_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ReceiveRequest) GetVolume() string {
+	if x != nil {
+		return x.Volume
+	}
+	return ""
+}
+
+func (x *ReceiveRequest) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+func (x *ReceiveRequest) GetLocalVolume() string {
+	if x != nil {
+		return x.LocalVolume
+	}
+	return ""
+}
+
+func (x *ReceiveRequest) GetAuto() bool {
+	if x != nil {
+		return x.Auto
+	}
+	return false
+}
+
+func (x *ReceiveRequest) GetFullPath() bool {
+	if x != nil {
+		return x.FullPath
+	}
+	return false
+}
+
+func (x *ReceiveRequest) GetLastPath() bool {
+	if x != nil {
+		return x.LastPath
+	}
+	return false
+}
+
+func (x *ReceiveRequest) GetForce() bool {
+	if x != nil {
+		return x.Force
+	}
+	return false
+}
+
+func (x *ReceiveRequest) GetUnmounted() bool {
+	if x != nil {
+		return x.Unmounted
+	}
+	return false
+}
+
+func (x *ReceiveRequest) GetOrigin() string {
+	if x != nil {
+		return x.Origin
+	}
+	return ""
+}
+
+func (x *ReceiveRequest) GetIncremental() string {
+	if x != nil {
+		return x.Incremental
+	}
+	return ""
+}
+
+func (x *ReceiveRequest) GetMaxFileBuffer() int32 {
+	if x != nil {
+		return x.MaxFileBuffer
+	}
+	return 0
+}
+
+func (x *ReceiveRequest) GetMaxRetryTime() *durationpb.Duration {
+	if x != nil {
+		return x.MaxRetryTime
+	}
+	return nil
+}
+
+func (x *ReceiveRequest) GetMaxBackoffTime() *durationpb.Duration {
+	if x != nil {
+		return x.MaxBackoffTime
+	}
+	return nil
+}
+
+func (x *ReceiveRequest) GetSeparator() string {
+	if x != nil {
+		return x.Separator
+	}
+	return ""
+}
+
+// ListRequest mirrors the options of the list command.
+type ListRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Destination string                 `protobuf:"bytes,1,opt,name=destination,proto3" json:"destination,omitempty"`
+	// Filter results to this volume name, can end with a '*' to match as a prefix.
+	VolumeName    string                 `protobuf:"bytes,2,opt,name=volume_name,json=volumeName,proto3" json:"volume_name,omitempty"`
+	Before        *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=before,proto3" json:"before,omitempty"`
+	After         *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=after,proto3" json:"after,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRequest) Reset() {
+	*x = ListRequest{}
+	mi := &file_zfsbackup_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRequest) ProtoMessage() {}
+
+func (x *ListRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zfsbackup_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRequest.ProtoReflect.Descriptor instead.
+func (*ListRequest) Descriptor() ([]byte, []int) {
+	return file_zfsbackup_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListRequest) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+func (x *ListRequest) GetVolumeName() string {
+	if x != nil {
+		return x.VolumeName
+	}
+	return ""
+}
+
+func (x *ListRequest) GetBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Before
+	}
+	return nil
+}
+
+func (x *ListRequest) GetAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.After
+	}
+	return nil
+}
+
+type ListResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	BackupSets    []*BackupSet           `protobuf:"bytes,1,rep,name=backup_sets,json=backupSets,proto3" json:"backup_sets,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListResponse) Reset() {
+	*x = ListResponse{}
+	mi := &file_zfsbackup_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListResponse) ProtoMessage() {}
+
+func (x *ListResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_zfsbackup_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListResponse.ProtoReflect.Descriptor instead.
+func (*ListResponse) Descriptor() ([]byte, []int) {
+	return file_zfsbackup_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListResponse) GetBackupSets() []*BackupSet {
+	if x != nil {
+		return x.BackupSets
+	}
+	return nil
+}
+
+// VerifyRequest identifies a backup set whose volumes should be downloaded and
+// checked against the hashes recorded in its manifest.
+type VerifyRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The snapshot to verify, e.g. tank/data@snap1
+	Volume         string               `protobuf:"bytes,1,opt,name=volume,proto3" json:"volume,omitempty"`
+	Destination    string               `protobuf:"bytes,2,opt,name=destination,proto3" json:"destination,omitempty"`
+	Incremental    string               `protobuf:"bytes,3,opt,name=incremental,proto3" json:"incremental,omitempty"`
+	MaxFileBuffer  int32                `protobuf:"varint,4,opt,name=max_file_buffer,json=maxFileBuffer,proto3" json:"max_file_buffer,omitempty"`
+	MaxRetryTime   *durationpb.Duration `protobuf:"bytes,5,opt,name=max_retry_time,json=maxRetryTime,proto3" json:"max_retry_time,omitempty"`
+	MaxBackoffTime *durationpb.Duration `protobuf:"bytes,6,opt,name=max_backoff_time,json=maxBackoffTime,proto3" json:"max_backoff_time,omitempty"`
+	Separator      string               `protobuf:"bytes,7,opt,name=separator,proto3" json:"separator,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *VerifyRequest) Reset() {
+	*x = VerifyRequest{}
+	mi := &file_zfsbackup_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyRequest) ProtoMessage() {}
+
+func (x *VerifyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_zfsbackup_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyRequest.ProtoReflect.Descriptor instead.
+func (*VerifyRequest) Descriptor() ([]byte, []int) {
+	return file_zfsbackup_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *VerifyRequest) GetVolume() string {
+	if x != nil {
+		return x.Volume
+	}
+	return ""
+}
+
+func (x *VerifyRequest) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+func (x *VerifyRequest) GetIncremental() string {
+	if x != nil {
+		return x.Incremental
+	}
+	return ""
+}
+
+func (x *VerifyRequest) GetMaxFileBuffer() int32 {
+	if x != nil {
+		return x.MaxFileBuffer
+	}
+	return 0
+}
+
+func (x *VerifyRequest) GetMaxRetryTime() *durationpb.Duration {
+	if x != nil {
+		return x.MaxRetryTime
+	}
+	return nil
+}
+
+func (x *VerifyRequest) GetMaxBackoffTime() *durationpb.Duration {
+	if x != nil {
+		return x.MaxBackoffTime
+	}
+	return nil
+}
+
+func (x *VerifyRequest) GetSeparator() string {
+	if x != nil {
+		return x.Separator
+	}
+	return ""
+}
+
+type Snapshot struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	CreationTime  *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=creation_time,json=creationTime,proto3" json:"creation_time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Snapshot) Reset() {
+	*x = Snapshot{}
+	mi := &file_zfsbackup_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Snapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Snapshot) ProtoMessage() {}
+
+func (x *Snapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_zfsbackup_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Snapshot.ProtoReflect.Descriptor instead.
+func (*Snapshot) Descriptor() ([]byte, []int) {
+	return file_zfsbackup_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Snapshot) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Snapshot) GetCreationTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreationTime
+	}
+	return nil
+}
+
+type BackupSet struct {
+	state                   protoimpl.MessageState `protogen:"open.v1"`
+	VolumeName              string                 `protobuf:"bytes,1,opt,name=volume_name,json=volumeName,proto3" json:"volume_name,omitempty"`
+	BaseSnapshot            *Snapshot              `protobuf:"bytes,2,opt,name=base_snapshot,json=baseSnapshot,proto3" json:"base_snapshot,omitempty"`
+	IncrementalSnapshot     *Snapshot              `protobuf:"bytes,3,opt,name=incremental_snapshot,json=incrementalSnapshot,proto3" json:"incremental_snapshot,omitempty"`
+	IntermediaryIncremental bool                   `protobuf:"varint,4,opt,name=intermediary_incremental,json=intermediaryIncremental,proto3" json:"intermediary_incremental,omitempty"`
+	Replication             bool                   `protobuf:"varint,5,opt,name=replication,proto3" json:"replication,omitempty"`
+	Compressor              string                 `protobuf:"bytes,6,opt,name=compressor,proto3" json:"compressor,omitempty"`
+	EncryptTo               string                 `protobuf:"bytes,7,opt,name=encrypt_to,json=encryptTo,proto3" json:"encrypt_to,omitempty"`
+	SignFrom                string                 `protobuf:"bytes,8,opt,name=sign_from,json=signFrom,proto3" json:"sign_from,omitempty"`
+	VolumeCount             int64                  `protobuf:"varint,9,opt,name=volume_count,json=volumeCount,proto3" json:"volume_count,omitempty"`
+	TotalBytes              uint64                 `protobuf:"varint,10,opt,name=total_bytes,json=totalBytes,proto3" json:"total_bytes,omitempty"`
+	ZfsStreamBytes          uint64                 `protobuf:"varint,11,opt,name=zfs_stream_bytes,json=zfsStreamBytes,proto3" json:"zfs_stream_bytes,omitempty"`
+	StartTime               *timestamppb.Timestamp `protobuf:"bytes,12,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	EndTime                 *timestamppb.Timestamp `protobuf:"bytes,13,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`
+	unknownFields           protoimpl.UnknownFields
+	sizeCache               protoimpl.SizeCache
+}
+
+func (x *BackupSet) Reset() {
+	*x = BackupSet{}
+	mi := &file_zfsbackup_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BackupSet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BackupSet) ProtoMessage() {}
+
+func (x *BackupSet) ProtoReflect() protoreflect.Message {
+	mi := &file_zfsbackup_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BackupSet.ProtoReflect.Descriptor instead.
+func (*BackupSet) Descriptor() ([]byte, []int) {
+	return file_zfsbackup_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *BackupSet) GetVolumeName() string {
+	if x != nil {
+		return x.VolumeName
+	}
+	return ""
+}
+
+func (x *BackupSet) GetBaseSnapshot() *Snapshot {
+	if x != nil {
+		return x.BaseSnapshot
+	}
+	return nil
+}
+
+func (x *BackupSet) GetIncrementalSnapshot() *Snapshot {
+	if x != nil {
+		return x.IncrementalSnapshot
+	}
+	return nil
+}
+
+func (x *BackupSet) GetIntermediaryIncremental() bool {
+	if x != nil {
+		return x.IntermediaryIncremental
+	}
+	return false
+}
+
+func (x *BackupSet) GetReplication() bool {
+	if x != nil {
+		return x.Replication
+	}
+	return false
+}
+
+func (x *BackupSet) GetCompressor() string {
+	if x != nil {
+		return x.Compressor
+	}
+	return ""
+}
+
+func (x *BackupSet) GetEncryptTo() string {
+	if x != nil {
+		return x.EncryptTo
+	}
+	return ""
+}
+
+func (x *BackupSet) GetSignFrom() string {
+	if x != nil {
+		return x.SignFrom
+	}
+	return ""
+}
+
+func (x *BackupSet) GetVolumeCount() int64 {
+	if x != nil {
+		return x.VolumeCount
+	}
+	return 0
+}
+
+func (x *BackupSet) GetTotalBytes() uint64 {
+	if x != nil {
+		return x.TotalBytes
+	}
+	return 0
+}
+
+func (x *BackupSet) GetZfsStreamBytes() uint64 {
+	if x != nil {
+		return x.ZfsStreamBytes
+	}
+	return 0
+}
+
+func (x *BackupSet) GetStartTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartTime
+	}
+	return nil
+}
+
+func (x *BackupSet) GetEndTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.EndTime
+	}
+	return nil
+}
+
+type ProgressUpdate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          ProgressUpdate_Type    `protobuf:"varint,1,opt,name=type,proto3,enum=zfsbackup.ProgressUpdate_Type" json:"type,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	VolumeName    string                 `protobuf:"bytes,3,opt,name=volume_name,json=volumeName,proto3" json:"volume_name,omitempty"`
+	Snapshot      string                 `protobuf:"bytes,4,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	ObjectName    string                 `protobuf:"bytes,5,opt,name=object_name,json=objectName,proto3" json:"object_name,omitempty"`
+	Destination   string                 `protobuf:"bytes,6,opt,name=destination,proto3" json:"destination,omitempty"`
+	VolumeNumber  int64                  `protobuf:"varint,7,opt,name=volume_number,json=volumeNumber,proto3" json:"volume_number,omitempty"`
+	Bytes         uint64                 `protobuf:"varint,8,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	Message       string                 `protobuf:"bytes,9,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProgressUpdate) Reset() {
+	*x = ProgressUpdate{}
+	mi := &file_zfsbackup_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProgressUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProgressUpdate) ProtoMessage() {}
+
+func (x *ProgressUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_zfsbackup_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProgressUpdate.ProtoReflect.Descriptor instead.
+func (*ProgressUpdate) Descriptor() ([]byte, []int) {
+	return file_zfsbackup_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ProgressUpdate) GetType() ProgressUpdate_Type {
+	if x != nil {
+		return x.Type
+	}
+	return ProgressUpdate_UNKNOWN
+}
+
+func (x *ProgressUpdate) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *ProgressUpdate) GetVolumeName() string {
+	if x != nil {
+		return x.VolumeName
+	}
+	return ""
+}
+
+func (x *ProgressUpdate) GetSnapshot() string {
+	if x != nil {
+		return x.Snapshot
+	}
+	return ""
+}
+
+func (x *ProgressUpdate) GetObjectName() string {
+	if x != nil {
+		return x.ObjectName
+	}
+	return ""
+}
+
+func (x *ProgressUpdate) GetDestination() string {
+	if x != nil {
+		return x.Destination
+	}
+	return ""
+}
+
+func (x *ProgressUpdate) GetVolumeNumber() int64 {
+	if x != nil {
+		return x.VolumeNumber
+	}
+	return 0
+}
+
+func (x *ProgressUpdate) GetBytes() uint64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *ProgressUpdate) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+var File_zfsbackup_proto protoreflect.FileDescriptor
+
+const file_zfsbackup_proto_rawDesc = "" +
+	"\n" +
+	"\x0fzfsbackup.proto\x12\tzfsbackup\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa1\x06\n" +
+	"\vSendRequest\x12\x16\n" +
+	"\x06volume\x18\x01 \x01(\tR\x06volume\x12\"\n" +
+	"\fdestinations\x18\x02 \x03(\tR\fdestinations\x12 \n" +
+	"\vreplication\x18\x03 \x01(\bR\vreplication\x12$\n" +
+	"\rdeduplication\x18\x04 \x01(\bR\rdeduplication\x12\x1e\n" +
+	"\n" +
+	"properties\x18\x05 \x01(\bR\n" +
+	"properties\x12 \n" +
+	"\vincremental\x18\x06 \x01(\tR\vincremental\x12\"\n" +
+	"\fintermediary\x18\a \x01(\tR\fintermediary\x12\x12\n" +
+	"\x04full\x18\b \x01(\bR\x04full\x12\x1c\n" +
+	"\tincrement\x18\t \x01(\bR\tincrement\x12F\n" +
+	"\x12full_if_older_than\x18\n" +
+	" \x01(\v2\x19.google.protobuf.DurationR\x0ffullIfOlderThan\x12\x16\n" +
+	"\x06resume\x18\v \x01(\bR\x06resume\x12\x1e\n" +
+	"\n" +
+	"compressor\x18\f \x01(\tR\n" +
+	"compressor\x12+\n" +
+	"\x11compression_level\x18\r \x01(\x05R\x10compressionLevel\x12\x1f\n" +
+	"\vvolume_size\x18\x0e \x01(\x04R\n" +
+	"volumeSize\x12&\n" +
+	"\x0fmax_file_buffer\x18\x0f \x01(\x05R\rmaxFileBuffer\x120\n" +
+	"\x14max_parallel_uploads\x18\x10 \x01(\x05R\x12maxParallelUploads\x12?\n" +
+	"\x0emax_retry_time\x18\x11 \x01(\v2\x19.google.protobuf.DurationR\fmaxRetryTime\x12C\n" +
+	"\x10max_backoff_time\x18\x12 \x01(\v2\x19.google.protobuf.DurationR\x0emaxBackoffTime\x12\x1c\n" +
+	"\tseparator\x18\x13 \x01(\tR\tseparator\x12*\n" +
+	"\x11upload_chunk_size\x18\x14 \x01(\x05R\x0fuploadChunkSize\"\xf5\x03\n" +
+	"\x0eReceiveRequest\x12\x16\n" +
+	"\x06volume\x18\x01 \x01(\tR\x06volume\x12 \n" +
+	"\vdestination\x18\x02 \x01(\tR\vdestination\x12!\n" +
+	"\flocal_volume\x18\x03 \x01(\tR\vlocalVolume\x12\x12\n" +
+	"\x04auto\x18\x04 \x01(\bR\x04auto\x12\x1b\n" +
+	"\tfull_path\x18\x05 \x01(\bR\bfullPath\x12\x1b\n" +
+	"\tlast_path\x18\x06 \x01(\bR\blastPath\x12\x14\n" +
+	"\x05force\x18\a \x01(\bR\x05force\x12\x1c\n" +
+	"\tunmounted\x18\b \x01(\bR\tunmounted\x12\x16\n" +
+	"\x06origin\x18\t \x01(\tR\x06origin\x12 \n" +
+	"\vincremental\x18\n" +
+	" \x01(\tR\vincremental\x12&\n" +
+	"\x0fmax_file_buffer\x18\v \x01(\x05R\rmaxFileBuffer\x12?\n" +
+	"\x0emax_retry_time\x18\f \x01(\v2\x19.google.protobuf.DurationR\fmaxRetryTime\x12C\n" +
+	"\x10max_backoff_time\x18\r \x01(\v2\x19.google.protobuf.DurationR\x0emaxBackoffTime\x12\x1c\n" +
+	"\tseparator\x18\x0e \x01(\tR\tseparator\"\xb6\x01\n" +
+	"\vListRequest\x12 \n" +
+	"\vdestination\x18\x01 \x01(\tR\vdestination\x12\x1f\n" +
+	"\vvolume_name\x18\x02 \x01(\tR\n" +
+	"volumeName\x122\n" +
+	"\x06before\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x06before\x120\n" +
+	"\x05after\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x05after\"E\n" +
+	"\fListResponse\x125\n" +
+	"\vbackup_sets\x18\x01 \x03(\v2\x14.zfsbackup.BackupSetR\n" +
+	"backupSets\"\xb7\x02\n" +
+	"\rVerifyRequest\x12\x16\n" +
+	"\x06volume\x18\x01 \x01(\tR\x06volume\x12 \n" +
+	"\vdestination\x18\x02 \x01(\tR\vdestination\x12 \n" +
+	"\vincremental\x18\x03 \x01(\tR\vincremental\x12&\n" +
+	"\x0fmax_file_buffer\x18\x04 \x01(\x05R\rmaxFileBuffer\x12?\n" +
+	"\x0emax_retry_time\x18\x05 \x01(\v2\x19.google.protobuf.DurationR\fmaxRetryTime\x12C\n" +
+	"\x10max_backoff_time\x18\x06 \x01(\v2\x19.google.protobuf.DurationR\x0emaxBackoffTime\x12\x1c\n" +
+	"\tseparator\x18\a \x01(\tR\tseparator\"_\n" +
+	"\bSnapshot\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12?\n" +
+	"\rcreation_time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\fcreationTime\"\xc7\x04\n" +
+	"\tBackupSet\x12\x1f\n" +
+	"\vvolume_name\x18\x01 \x01(\tR\n" +
+	"volumeName\x128\n" +
+	"\rbase_snapshot\x18\x02 \x01(\v2\x13.zfsbackup.SnapshotR\fbaseSnapshot\x12F\n" +
+	"\x14incremental_snapshot\x18\x03 \x01(\v2\x13.zfsbackup.SnapshotR\x13incrementalSnapshot\x129\n" +
+	"\x18intermediary_incremental\x18\x04 \x01(\bR\x17intermediaryIncremental\x12 \n" +
+	"\vreplication\x18\x05 \x01(\bR\vreplication\x12\x1e\n" +
+	"\n" +
+	"compressor\x18\x06 \x01(\tR\n" +
+	"compressor\x12\x1d\n" +
+	"\n" +
+	"encrypt_to\x18\a \x01(\tR\tencryptTo\x12\x1b\n" +
+	"\tsign_from\x18\b \x01(\tR\bsignFrom\x12!\n" +
+	"\fvolume_count\x18\t \x01(\x03R\vvolumeCount\x12\x1f\n" +
+	"\vtotal_bytes\x18\n" +
+	" \x01(\x04R\n" +
+	"totalBytes\x12(\n" +
+	"\x10zfs_stream_bytes\x18\v \x01(\x04R\x0ezfsStreamBytes\x129\n" +
+	"\n" +
+	"start_time\x18\f \x01(\v2\x1a.google.protobuf.TimestampR\tstartTime\x125\n" +
+	"\bend_time\x18\r \x01(\v2\x1a.google.protobuf.TimestampR\aendTime\"\x88\x04\n" +
+	"\x0eProgressUpdate\x122\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x1e.zfsbackup.ProgressUpdate.TypeR\x04type\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1f\n" +
+	"\vvolume_name\x18\x03 \x01(\tR\n" +
+	"volumeName\x12\x1a\n" +
+	"\bsnapshot\x18\x04 \x01(\tR\bsnapshot\x12\x1f\n" +
+	"\vobject_name\x18\x05 \x01(\tR\n" +
+	"objectName\x12 \n" +
+	"\vdestination\x18\x06 \x01(\tR\vdestination\x12#\n" +
+	"\rvolume_number\x18\a \x01(\x03R\fvolumeNumber\x12\x14\n" +
+	"\x05bytes\x18\b \x01(\x04R\x05bytes\x12\x18\n" +
+	"\amessage\x18\t \x01(\tR\amessage\"\xbc\x01\n" +
+	"\x04Type\x12\v\n" +
+	"\aUNKNOWN\x10\x00\x12\x0f\n" +
+	"\vJOB_STARTED\x10\x01\x12\x12\n" +
+	"\x0eVOLUME_CREATED\x10\x02\x12\x13\n" +
+	"\x0fVOLUME_UPLOADED\x10\x03\x12\x15\n" +
+	"\x11VOLUME_DOWNLOADED\x10\x04\x12\x13\n" +
+	"\x0fVOLUME_RESTORED\x10\x05\x12\x13\n" +
+	"\x0fVOLUME_VERIFIED\x10\x06\x12\x10\n" +
+	"\fJOB_FINISHED\x10\a\x12\x0f\n" +
+	"\vJOB_PLANNED\x10\b\x12\t\n" +
+	"\x05RETRY\x10\t2\x85\x02\n" +
+	"\tZFSBackup\x12;\n" +
+	"\x04Send\x12\x16.zfsbackup.SendRequest\x1a\x19.zfsbackup.ProgressUpdate0\x01\x12A\n" +
+	"\aReceive\x12\x19.zfsbackup.ReceiveRequest\x1a\x19.zfsbackup.ProgressUpdate0\x01\x127\n" +
+	"\x04List\x12\x16.zfsbackup.ListRequest\x1a\x17.zfsbackup.ListResponse\x12?\n" +
+	"\x06Verify\x12\x18.zfsbackup.VerifyRequest\x1a\x19.zfsbackup.ProgressUpdate0\x01B&Z$github.com/someone1/zfsbackup-go/rpcb\x06proto3"
+
+var (
+	file_zfsbackup_proto_rawDescOnce sync.Once
+	file_zfsbackup_proto_rawDescData []byte
+)
+
+func file_zfsbackup_proto_rawDescGZIP() []byte {
+	file_zfsbackup_proto_rawDescOnce.Do(func() {
+		file_zfsbackup_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_zfsbackup_proto_rawDesc), len(file_zfsbackup_proto_rawDesc)))
+	})
+	return file_zfsbackup_proto_rawDescData
+}
+
+var file_zfsbackup_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_zfsbackup_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_zfsbackup_proto_goTypes = []any{
+	(ProgressUpdate_Type)(0),      // 0: zfsbackup.ProgressUpdate.Type
+	(*SendRequest)(nil),           // 1: zfsbackup.SendRequest
+	(*ReceiveRequest)(nil),        // 2: zfsbackup.ReceiveRequest
+	(*ListRequest)(nil),           // 3: zfsbackup.ListRequest
+	(*ListResponse)(nil),          // 4: zfsbackup.ListResponse
+	(*VerifyRequest)(nil),         // 5: zfsbackup.VerifyRequest
+	(*Snapshot)(nil),              // 6: zfsbackup.Snapshot
+	(*BackupSet)(nil),             // 7: zfsbackup.BackupSet
+	(*ProgressUpdate)(nil),        // 8: zfsbackup.ProgressUpdate
+	(*durationpb.Duration)(nil),   // 9: google.protobuf.Duration
+	(*timestamppb.Timestamp)(nil), // 10: google.protobuf.Timestamp
+}
+var file_zfsbackup_proto_depIdxs = []int32{
+	9,  // 0: zfsbackup.SendRequest.full_if_older_than:type_name -> google.protobuf.Duration
+	9,  // 1: zfsbackup.SendRequest.max_retry_time:type_name -> google.protobuf.Duration
+	9,  // 2: zfsbackup.SendRequest.max_backoff_time:type_name -> google.protobuf.Duration
+	9,  // 3: zfsbackup.ReceiveRequest.max_retry_time:type_name -> google.protobuf.Duration
+	9,  // 4: zfsbackup.ReceiveRequest.max_backoff_time:type_name -> google.protobuf.Duration
+	10, // 5: zfsbackup.ListRequest.before:type_name -> google.protobuf.Timestamp
+	10, // 6: zfsbackup.ListRequest.after:type_name -> google.protobuf.Timestamp
+	7,  // 7: zfsbackup.ListResponse.backup_sets:type_name -> zfsbackup.BackupSet
+	9,  // 8: zfsbackup.VerifyRequest.max_retry_time:type_name -> google.protobuf.Duration
+	9,  // 9: zfsbackup.VerifyRequest.max_backoff_time:type_name -> google.protobuf.Duration
+	10, // 10: zfsbackup.Snapshot.creation_time:type_name -> google.protobuf.Timestamp
+	6,  // 11: zfsbackup.BackupSet.base_snapshot:type_name -> zfsbackup.Snapshot
+	6,  // 12: zfsbackup.BackupSet.incremental_snapshot:type_name -> zfsbackup.Snapshot
+	10, // 13: zfsbackup.BackupSet.start_time:type_name -> google.protobuf.Timestamp
+	10, // 14: zfsbackup.BackupSet.end_time:type_name -> google.protobuf.Timestamp
+	0,  // 15: zfsbackup.ProgressUpdate.type:type_name -> zfsbackup.ProgressUpdate.Type
+	10, // 16: zfsbackup.ProgressUpdate.time:type_name -> google.protobuf.Timestamp
+	1,  // 17: zfsbackup.ZFSBackup.Send:input_type -> zfsbackup.SendRequest
+	2,  // 18: zfsbackup.ZFSBackup.Receive:input_type -> zfsbackup.ReceiveRequest
+	3,  // 19: zfsbackup.ZFSBackup.List:input_type -> zfsbackup.ListRequest
+	5,  // 20: zfsbackup.ZFSBackup.Verify:input_type -> zfsbackup.VerifyRequest
+	8,  // 21: zfsbackup.ZFSBackup.Send:output_type -> zfsbackup.ProgressUpdate
+	8,  // 22: zfsbackup.ZFSBackup.Receive:output_type -> zfsbackup.ProgressUpdate
+	4,  // 23: zfsbackup.ZFSBackup.List:output_type -> zfsbackup.ListResponse
+	8,  // 24: zfsbackup.ZFSBackup.Verify:output_type -> zfsbackup.ProgressUpdate
+	21, // [21:25] is the sub-list for method output_type
+	17, // [17:21] is the sub-list for method input_type
+	17, // [17:17] is the sub-list for extension type_name
+	17, // [17:17] is the sub-list for extension extendee
+	0,  // [0:17] is the sub-list for field type_name
+}
+
+func init() { file_zfsbackup_proto_init() }
+func file_zfsbackup_proto_init() {
+	if File_zfsbackup_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_zfsbackup_proto_rawDesc), len(file_zfsbackup_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_zfsbackup_proto_goTypes,
+		DependencyIndexes: file_zfsbackup_proto_depIdxs,
+		EnumInfos:         file_zfsbackup_proto_enumTypes,
+		MessageInfos:      file_zfsbackup_proto_msgTypes,
+	}.Build()
+	File_zfsbackup_proto = out.File
+	file_zfsbackup_proto_goTypes = nil
+	file_zfsbackup_proto_depIdxs = nil
+}
@@ -0,0 +1,271 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: zfsbackup.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ZFSBackup_Send_FullMethodName    = "/zfsbackup.ZFSBackup/Send"
+	ZFSBackup_Receive_FullMethodName = "/zfsbackup.ZFSBackup/Receive"
+	ZFSBackup_List_FullMethodName    = "/zfsbackup.ZFSBackup/List"
+	ZFSBackup_Verify_FullMethodName  = "/zfsbackup.ZFSBackup/Verify"
+)
+
+// ZFSBackupClient is the client API for ZFSBackup service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ZFSBackup mirrors the send, receive, list, and verify commands. Long running
+// operations stream progress updates back to the caller until they complete.
+type ZFSBackupClient interface {
+	Send(ctx context.Context, in *SendRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressUpdate], error)
+	Receive(ctx context.Context, in *ReceiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressUpdate], error)
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressUpdate], error)
+}
+
+type zFSBackupClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewZFSBackupClient(cc grpc.ClientConnInterface) ZFSBackupClient {
+	return &zFSBackupClient{cc}
+}
+
+func (c *zFSBackupClient) Send(ctx context.Context, in *SendRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ZFSBackup_ServiceDesc.Streams[0], ZFSBackup_Send_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SendRequest, ProgressUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ZFSBackup_SendClient = grpc.ServerStreamingClient[ProgressUpdate]
+
+func (c *zFSBackupClient) Receive(ctx context.Context, in *ReceiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ZFSBackup_ServiceDesc.Streams[1], ZFSBackup_Receive_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ReceiveRequest, ProgressUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ZFSBackup_ReceiveClient = grpc.ServerStreamingClient[ProgressUpdate]
+
+func (c *zFSBackupClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, ZFSBackup_List_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *zFSBackupClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ProgressUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ZFSBackup_ServiceDesc.Streams[2], ZFSBackup_Verify_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[VerifyRequest, ProgressUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ZFSBackup_VerifyClient = grpc.ServerStreamingClient[ProgressUpdate]
+
+// ZFSBackupServer is the server API for ZFSBackup service.
+// All implementations must embed UnimplementedZFSBackupServer
+// for forward compatibility.
+//
+// ZFSBackup mirrors the send, receive, list, and verify commands. Long running
+// operations stream progress updates back to the caller until they complete.
+type ZFSBackupServer interface {
+	Send(*SendRequest, grpc.ServerStreamingServer[ProgressUpdate]) error
+	Receive(*ReceiveRequest, grpc.ServerStreamingServer[ProgressUpdate]) error
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	Verify(*VerifyRequest, grpc.ServerStreamingServer[ProgressUpdate]) error
+	mustEmbedUnimplementedZFSBackupServer()
+}
+
+// UnimplementedZFSBackupServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedZFSBackupServer struct{}
+
+func (UnimplementedZFSBackupServer) Send(*SendRequest, grpc.ServerStreamingServer[ProgressUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method Send not implemented")
+}
+func (UnimplementedZFSBackupServer) Receive(*ReceiveRequest, grpc.ServerStreamingServer[ProgressUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method Receive not implemented")
+}
+func (UnimplementedZFSBackupServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedZFSBackupServer) Verify(*VerifyRequest, grpc.ServerStreamingServer[ProgressUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedZFSBackupServer) mustEmbedUnimplementedZFSBackupServer() {}
+func (UnimplementedZFSBackupServer) testEmbeddedByValue()                   {}
+
+// UnsafeZFSBackupServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ZFSBackupServer will
+// result in compilation errors.
+type UnsafeZFSBackupServer interface {
+	mustEmbedUnimplementedZFSBackupServer()
+}
+
+func RegisterZFSBackupServer(s grpc.ServiceRegistrar, srv ZFSBackupServer) {
+	// If the following call pancis, it indicates UnimplementedZFSBackupServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ZFSBackup_ServiceDesc, srv)
+}
+
+func _ZFSBackup_Send_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SendRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ZFSBackupServer).Send(m, &grpc.GenericServerStream[SendRequest, ProgressUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ZFSBackup_SendServer = grpc.ServerStreamingServer[ProgressUpdate]
+
+func _ZFSBackup_Receive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReceiveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ZFSBackupServer).Receive(m, &grpc.GenericServerStream[ReceiveRequest, ProgressUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ZFSBackup_ReceiveServer = grpc.ServerStreamingServer[ProgressUpdate]
+
+func _ZFSBackup_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ZFSBackupServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ZFSBackup_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ZFSBackupServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ZFSBackup_Verify_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(VerifyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ZFSBackupServer).Verify(m, &grpc.GenericServerStream[VerifyRequest, ProgressUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ZFSBackup_VerifyServer = grpc.ServerStreamingServer[ProgressUpdate]
+
+// ZFSBackup_ServiceDesc is the grpc.ServiceDesc for ZFSBackup service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ZFSBackup_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "zfsbackup.ZFSBackup",
+	HandlerType: (*ZFSBackupServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "List",
+			Handler:    _ZFSBackup_List_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Send",
+			Handler:       _ZFSBackup_Send_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Receive",
+			Handler:       _ZFSBackup_Receive_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Verify",
+			Handler:       _ZFSBackup_Verify_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "zfsbackup.proto",
+}
@@ -0,0 +1,128 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zfsbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backends"
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// Snapshot identifies a snapshot of a backup set.
+type Snapshot struct {
+	Name         string
+	CreationTime time.Time
+}
+
+// BackupSet describes a backup set as recorded by its manifest.
+type BackupSet struct {
+	Manifest                string    // Object name of the manifest of the backup set
+	VolumeName              string    // The dataset backed up
+	BaseSnapshot            Snapshot  // The snapshot backed up
+	IncrementalSnapshot     *Snapshot // The snapshot the backup set is incremental from, nil for a full backup set
+	IntermediaryIncremental bool      // The backup set holds every snapshot between IncrementalSnapshot and BaseSnapshot
+	Replication             bool
+	Compressor              string
+	EncryptTo               string
+	SignFrom                string
+	Tags                    map[string]string
+	Volumes                 int    // Number of volumes the backup set is split in
+	ZFSStreamBytes          uint64 // Bytes of the zfs send stream
+	BackupBytes             uint64 // Bytes of the volumes as stored
+	StartTime               time.Time
+	EndTime                 time.Time
+}
+
+// ListOptions filters the backup sets returned by List.
+type ListOptions struct {
+	VolumePrefix string    // Only list the backup sets of the volumes starting with this
+	Before       time.Time // Only list the backup sets of snapshots taken before this, ignored if zero
+	After        time.Time // Only list the backup sets of snapshots taken after this, ignored if zero
+}
+
+// List will return the backup sets found at the destination, oldest first for every volume.
+func (c *Client) List(ctx context.Context, destination string, opts ListOptions) ([]BackupSet, error) {
+	if err := validateDestinations([]string{destination}); err != nil {
+		return nil, err
+	}
+	j := c.newJobInfo()
+	j.Destinations = []string{destination}
+
+	var sets []*helpers.JobInfo
+	err := c.call(ctx, func(ctx context.Context) (err error) {
+		sets, err = backup.ListBackupSets(ctx, j, opts.VolumePrefix, opts.Before, opts.After)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]BackupSet, 0, len(sets))
+	for _, set := range sets {
+		result = append(result, c.toBackupSet(set))
+	}
+	return result, nil
+}
+
+// toBackupSet returns the BackupSet described by a manifest. The manifest prefix is not saved to the
+// manifests, the one of the Client is assumed for those read from a destination.
+func (c *Client) toBackupSet(j *helpers.JobInfo) BackupSet {
+	if j.ManifestPrefix == "" {
+		j.ManifestPrefix = c.config.ManifestPrefix
+	}
+	set := BackupSet{
+		Manifest:                helpers.ManifestObjectName(j),
+		VolumeName:              j.VolumeName,
+		BaseSnapshot:            Snapshot{Name: j.BaseSnapshot.Name, CreationTime: j.BaseSnapshot.CreationTime},
+		IntermediaryIncremental: j.IntermediaryIncremental,
+		Replication:             j.Replication,
+		Compressor:              j.Compressor,
+		EncryptTo:               j.EncryptTo,
+		SignFrom:                j.SignFrom,
+		Tags:                    j.Tags,
+		Volumes:                 len(j.Volumes),
+		ZFSStreamBytes:          j.ZFSStreamBytes,
+		BackupBytes:             j.TotalBytesWritten(),
+		StartTime:               j.StartTime,
+		EndTime:                 j.EndTime,
+	}
+	if j.IncrementalSnapshot.Name != "" {
+		set.IncrementalSnapshot = &Snapshot{Name: j.IncrementalSnapshot.Name, CreationTime: j.IncrementalSnapshot.CreationTime}
+	}
+	return set
+}
+
+func validateDestinations(destinations []string) error {
+	if len(destinations) == 0 {
+		return errors.New("at least one destination is required")
+	}
+	for _, destination := range destinations {
+		if _, err := backends.GetBackendForURI(destination); err != nil {
+			return fmt.Errorf("invalid destination %s - %v", destination, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zfsbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// ReceiveOptions describes a backup set, or chain of backup sets, to restore.
+type ReceiveOptions struct {
+	// Volume is the backup set to restore, <volume>@<snapshot>, or only the volume when Auto is set. The
+	// snapshot latest or latest-full selects the newest restore point, or full backup set, and implies Auto.
+	Volume string
+	// Incremental is the snapshot the backup set to restore is incremental from, <snapshot> or <volume>@<snapshot>.
+	Incremental string
+	// Auto will restore every backup set needed to bring LocalVolume up to the latest snapshot of Volume,
+	// or up to the snapshot of Volume when one is given.
+	Auto bool
+
+	Destination    string        // URI of the destination holding the backup sets
+	LocalVolume    string        // The dataset to receive into, required unless ToFile is set
+	ToFile         string        // Write the zfs send stream to this local file instead of receiving it
+	Force          bool          // Rollback LocalVolume to its latest snapshot before receiving (zfs recv -F)
+	FullPath       bool          // Receive into LocalVolume followed by the full name of Volume (zfs recv -d)
+	LastPath       bool          // Receive into LocalVolume followed by the last element of Volume (zfs recv -e)
+	NotMounted     bool          // Do not mount the received datasets (zfs recv -u)
+	Origin         string        // Clone of this snapshot to receive an incremental stream into (zfs recv -o origin=)
+	MaxFileBuffer  int           // Volumes kept on disk at once, 5 if 0
+	MaxRetryTime   time.Duration // Retry failed downloads for up to this long, 12 hours if 0
+	MaxBackoffTime time.Duration // Wait at most this long between retries, 30 minutes if 0
+	Progress       ProgressFunc  // Called for every step of the restore, may be nil
+}
+
+// Receive will restore a backup set as described by the options.
+func (c *Client) Receive(ctx context.Context, opts ReceiveOptions) error {
+	j := c.newJobInfo()
+
+	parts := strings.Split(opts.Volume, "@")
+	j.VolumeName = parts[0]
+	j.AutoRestore = opts.Auto
+	j.FullPath = opts.FullPath
+	j.LastPath = opts.LastPath
+	j.Force = opts.Force
+	j.NotMounted = opts.NotMounted
+	j.Origin = strings.TrimPrefix(opts.Origin, "origin=")
+	j.LocalVolume = opts.LocalVolume
+	j.ToFile = opts.ToFile
+	j.Destinations = []string{opts.Destination}
+	j.Progress = opts.Progress
+	if opts.MaxFileBuffer != 0 {
+		j.MaxFileBuffer = opts.MaxFileBuffer
+	}
+	applyCommonOptions(j, opts.MaxRetryTime, opts.MaxBackoffTime)
+
+	if len(parts) != 2 && !j.AutoRestore {
+		return fmt.Errorf("invalid base snapshot provided, expected format <volume>@<snapshot>, got %s instead", opts.Volume)
+	} else if len(parts) == 2 {
+		j.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+		j.AutoRestore = j.AutoRestore || backup.IsLatestSelector(parts[1])
+	}
+	if j.LocalVolume == "" && j.ToFile == "" {
+		return errors.New("a local volume to restore to is required")
+	}
+	if j.FullPath && j.LastPath {
+		return errors.New("full path and last path are mutually exclusive")
+	}
+	if j.AutoRestore && opts.Incremental != "" {
+		return errors.New("cannot request auto restore and provide an incremental snapshot to restore from")
+	}
+	if err := validateDestinations(j.Destinations); err != nil {
+		return err
+	}
+
+	return c.call(ctx, func(ctx context.Context) error {
+		if !j.AutoRestore {
+			if j.LocalVolume != "" {
+				if creationTime, err := helpers.GetCreationDate(ctx, fmt.Sprintf("%s@%s", j.LocalVolume, j.BaseSnapshot.Name)); err == nil {
+					j.BaseSnapshot.CreationTime = creationTime
+				}
+			}
+			if opts.Incremental != "" {
+				j.IncrementalSnapshot.Name = strings.TrimPrefix(opts.Incremental, j.VolumeName)
+				j.IncrementalSnapshot.Name = strings.TrimPrefix(j.IncrementalSnapshot.Name, "@")
+				if j.LocalVolume != "" {
+					if creationTime, err := helpers.GetCreationDate(ctx, fmt.Sprintf("%s@%s", j.LocalVolume, j.IncrementalSnapshot.Name)); err == nil {
+						j.IncrementalSnapshot.CreationTime = creationTime
+					}
+				}
+			}
+			return backup.Receive(ctx, j)
+		}
+		return backup.AutoRestore(ctx, j)
+	})
+}
+
+// VerifyOptions describes a backup set to verify.
+type VerifyOptions struct {
+	Volume         string        // The backup set to verify, <volume>@<snapshot>
+	Incremental    string        // The snapshot the backup set is incremental from, <snapshot> or <volume>@<snapshot>
+	Destination    string        // URI of the destination holding the backup set
+	MaxFileBuffer  int           // Volumes kept on disk at once, 5 if 0
+	MaxRetryTime   time.Duration // Retry failed downloads for up to this long, 12 hours if 0
+	MaxBackoffTime time.Duration // Wait at most this long between retries, 30 minutes if 0
+	Progress       ProgressFunc  // Called for every step of the verification, may be nil
+}
+
+// Verify will download every volume of a backup set and check it against its manifest, returning an error if
+// any of them is missing or corrupt.
+func (c *Client) Verify(ctx context.Context, opts VerifyOptions) error {
+	j := c.newJobInfo()
+
+	parts := strings.Split(opts.Volume, "@")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid snapshot provided, expected format <volume>@<snapshot>, got %s instead", opts.Volume)
+	}
+	j.VolumeName = parts[0]
+	j.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+	j.Destinations = []string{opts.Destination}
+	j.Progress = opts.Progress
+	if opts.Incremental != "" {
+		j.IncrementalSnapshot.Name = strings.TrimPrefix(opts.Incremental, j.VolumeName)
+		j.IncrementalSnapshot.Name = strings.TrimPrefix(j.IncrementalSnapshot.Name, "@")
+	}
+	if opts.MaxFileBuffer != 0 {
+		j.MaxFileBuffer = opts.MaxFileBuffer
+	}
+	applyCommonOptions(j, opts.MaxRetryTime, opts.MaxBackoffTime)
+
+	if err := validateDestinations(j.Destinations); err != nil {
+		return err
+	}
+
+	return c.call(ctx, func(ctx context.Context) error {
+		return backup.Verify(ctx, j)
+	})
+}
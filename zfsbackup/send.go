@@ -0,0 +1,212 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zfsbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/someone1/zfsbackup-go/backup"
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// ErrNoOp is returned by Send when Increment finds no snapshot newer than the last one backed up.
+var ErrNoOp = backup.ErrNoOp
+
+// ProgressEvent describes a single step taken by a running send, receive, or verify.
+type ProgressEvent = helpers.ProgressEvent
+
+// ProgressFunc is called for every ProgressEvent of a call. It may be called concurrently from several
+// goroutines and should not block for long.
+type ProgressFunc = helpers.ProgressFunc
+
+// BackupStats summarizes how a backup set was sent.
+type BackupStats = helpers.BackupStats
+
+// Codec compresses and decompresses volumes, see RegisterCodec.
+type Codec = helpers.Codec
+
+// CodecOptions are the settings a volume is compressed with.
+type CodecOptions = helpers.CodecOptions
+
+// RegisterCodec will have the volumes sent with SendOptions.Compressor set to name compressed with the codec.
+// The name is recorded in the manifest, the codec must be registered as the same name to restore them.
+func RegisterCodec(name string, codec Codec) error {
+	return helpers.RegisterCodec(name, codec)
+}
+
+// SendOptions describes a backup to send. The zero value of every option but Volume and Destinations is the
+// default of the command line.
+type SendOptions struct {
+	// Volume is the snapshot to backup, <volume>@<snapshot>, or only the volume when one of Full, Increment,
+	// or FullIfOlderThan picks the snapshots instead.
+	Volume string
+	// Incremental is the snapshot to send an incremental stream from, <snapshot> or <volume>@<snapshot>.
+	Incremental string
+	// Intermediary is the same as Incremental but sends every snapshot in between as well (zfs send -I).
+	Intermediary string
+	// Full will backup the latest snapshot of Volume as a full backup set.
+	Full bool
+	// Increment will backup the latest snapshot of Volume as an incremental backup set from the latest
+	// snapshot already backed up.
+	Increment bool
+	// FullIfOlderThan will backup the latest snapshot of Volume as a full backup set if the last full
+	// backup set is older than this, or as an incremental one otherwise. Ignored if 0.
+	FullIfOlderThan time.Duration
+
+	Destinations       []string // URIs of the destinations to send the backup set to, at least one is required
+	Compressor         string   // Compressor of the volumes, the internal one if empty
+	CompressionLevel   int      // Compression level between 1 and 9, 6 if 0
+	VolumeSize         uint64   // Size of every volume in MiB, 200 if 0
+	VolumeSizeOutput   bool     // Keep the volumes under VolumeSize once compressed and encrypted
+	MaxFileBuffer      int      // Volumes kept on disk at once waiting to be uploaded, 5 if 0
+	MaxParallelUploads int      // Volumes uploaded at once, 4 if 0
+	UploadChunkSize    int      // MiB uploaded at a time to the destinations that split uploads, 10 if 0
+	MaxRetryTime       time.Duration
+	MaxBackoffTime     time.Duration
+	Replication        bool              // Send the whole dataset tree with its properties (zfs send -R)
+	Deduplication      bool              // Send a deduplicated stream (zfs send -D)
+	Properties         bool              // Send the properties of the dataset (zfs send -p)
+	Tags               map[string]string // Labels saved to the manifest of the backup set
+	Resume             bool              // Resume an interrupted send of the same backup set
+	SendAgain          bool              // Send even if the destinations already hold the same backup set
+	Progress           ProgressFunc      // Called for every step of the send, may be nil
+}
+
+// SendResult describes a backup set sent, or found already at every destination.
+type SendResult struct {
+	BackupSet       BackupSet
+	AlreadyBackedUp bool        // The destinations already held the same backup set, nothing was sent
+	Stats           BackupStats // How the backup set was sent, empty if AlreadyBackedUp
+}
+
+// Send will backup a snapshot as described by the options.
+func (c *Client) Send(ctx context.Context, opts SendOptions) (*SendResult, error) {
+	j := c.newJobInfo()
+
+	parts := strings.Split(opts.Volume, "@")
+	j.VolumeName = parts[0]
+	if j.VolumeName == "" {
+		return nil, errors.New("a volume to backup is required")
+	}
+	if err := validateDestinations(opts.Destinations); err != nil {
+		return nil, err
+	}
+	if opts.Incremental != "" && opts.Intermediary != "" {
+		return nil, errors.New("incremental and intermediary are mutually exclusive")
+	}
+
+	j.Destinations = opts.Destinations
+	j.Replication = opts.Replication
+	j.Deduplication = opts.Deduplication
+	j.Properties = opts.Properties
+	j.Full = opts.Full
+	j.Incremental = opts.Increment
+	j.Tags = opts.Tags
+	j.Resume = opts.Resume
+	j.SendAgain = opts.SendAgain
+	j.Progress = opts.Progress
+	j.IncrementalSnapshot.Name = opts.Incremental
+	if opts.Intermediary != "" {
+		j.IncrementalSnapshot.Name = opts.Intermediary
+		j.IntermediaryIncremental = true
+	}
+	if opts.FullIfOlderThan != 0 {
+		j.FullIfOlderThan = opts.FullIfOlderThan
+	}
+	if opts.Compressor != "" {
+		j.Compressor = opts.Compressor
+	}
+	if opts.CompressionLevel != 0 {
+		j.CompressionLevel = opts.CompressionLevel
+	}
+	if opts.VolumeSize != 0 {
+		j.VolumeSize = opts.VolumeSize
+	}
+	j.VolumeSizeOutput = opts.VolumeSizeOutput
+	if opts.MaxFileBuffer != 0 {
+		j.MaxFileBuffer = opts.MaxFileBuffer
+	}
+	if opts.MaxParallelUploads != 0 {
+		j.MaxParallelUploads = opts.MaxParallelUploads
+	}
+	if opts.UploadChunkSize != 0 {
+		j.UploadChunkSize = opts.UploadChunkSize
+	}
+	applyCommonOptions(j, opts.MaxRetryTime, opts.MaxBackoffTime)
+
+	err := c.call(ctx, func(ctx context.Context) error {
+		if err := j.ValidateSendFlags(ctx); err != nil {
+			return err
+		}
+
+		if !j.Full && !j.Incremental && j.FullIfOlderThan == -1*time.Minute {
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid base snapshot provided, expected format <volume>@<snapshot>, got %s instead", opts.Volume)
+			}
+			j.BaseSnapshot = helpers.SnapshotInfo{Name: parts[1]}
+			creationTime, err := helpers.GetCreationDate(ctx, opts.Volume)
+			if err != nil {
+				return fmt.Errorf("could not get creation date of base snapshot - %v", err)
+			}
+			j.BaseSnapshot.CreationTime = creationTime
+
+			if j.IncrementalSnapshot.Name != "" {
+				j.IncrementalSnapshot.Name = strings.TrimPrefix(j.IncrementalSnapshot.Name, j.VolumeName)
+				j.IncrementalSnapshot.Name = strings.TrimPrefix(j.IncrementalSnapshot.Name, "@")
+				creationTime, err = helpers.GetCreationDate(ctx, fmt.Sprintf("%s@%s", j.VolumeName, j.IncrementalSnapshot.Name))
+				if err != nil {
+					return fmt.Errorf("could not get creation date of incremental snapshot - %v", err)
+				}
+				j.IncrementalSnapshot.CreationTime = creationTime
+			}
+		} else {
+			if len(parts) != 1 {
+				return errors.New("when using a smart option, only specify the volume to backup without any snapshot information")
+			}
+			if err := backup.ProcessSmartOptions(ctx, j); err != nil {
+				return fmt.Errorf("could not process smart option - %v", err)
+			}
+		}
+
+		return backup.Backup(ctx, j)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if j.AlreadyBackedUp != nil {
+		return &SendResult{BackupSet: c.toBackupSet(j.AlreadyBackedUp), AlreadyBackedUp: true}, nil
+	}
+	return &SendResult{BackupSet: c.toBackupSet(j), Stats: j.BackupStats()}, nil
+}
+
+func applyCommonOptions(j *helpers.JobInfo, maxRetryTime, maxBackoffTime time.Duration) {
+	if maxRetryTime != 0 {
+		j.MaxRetryTime = maxRetryTime
+	}
+	if maxBackoffTime != 0 {
+		j.MaxBackoffTime = maxBackoffTime
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package zfsbackup is the library API of zfsbackup, letting other Go programs send, receive, list, and verify
+// backups of ZFS datasets without going through the command line.
+//
+// Everything a Client does is described by its Config and the options of every call instead of the flags,
+// environmental variables, and config file of the command line. Nothing is logged unless Config.Log is set, and
+// nothing is written to stdout, the outcome of every call is returned instead.
+//
+// Every call runs with the Config of its Client: the working directory, zfs and ssh settings, keyrings, and logger
+// are carried by the context of the call (see helpers.Environment) rather than set in the package level variables
+// of helpers, so the calls of any number of Clients can run at the same time, each logging to its own Log only.
+// Their transfers are neither rate limited nor paused by helpers.BackupUploadBucket, helpers.UploadSlots, or
+// helpers.Transfers, and nothing they do goes through helpers.AppLogger.
+package zfsbackup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	logging "github.com/op/go-logging"
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// DefaultManifestPrefix is the prefix of the manifests unless told otherwise, the same as the command line.
+const DefaultManifestPrefix = "manifests"
+
+// ErrNoWorkingDir is returned by New when the Config does not provide a working directory.
+var ErrNoWorkingDir = errors.New("a working directory is required")
+
+// Config describes the environment a Client runs in.
+type Config struct {
+	WorkingDir      string             // Directory the manifest cache, staged volumes, and state of the jobs are kept in, created if missing
+	TempDir         string             // Directory the temporary files of every call are created in, os.TempDir() if empty
+	ZFSPath         string             // Path of the zfs executable, "zfs" if empty
+	SSHHost         string             // The user@host the zfs commands are run on over ssh, they are run locally if empty
+	SSHPort         int                // Port of SSHHost, 22 if 0
+	SSHIdentityFile string             // Private key used to log in to SSHHost, the ssh default if empty
+	ManifestPrefix  string             // Prefix of the manifests at the destinations, DefaultManifestPrefix if empty
+	ManifestFormat  string             // Format the manifests are written in, helpers.ManifestFormatNative if empty
+	PublicKeyRing   openpgp.EntityList // Keys the backup sets may be encrypted to or signed from
+	SecretKeyRing   openpgp.EntityList // Keys the backup sets may be decrypted or signed with, their private keys must already be decrypted
+	EncryptTo       string             // Email of the key of the rings the backup sets sent are encrypted to, they are not encrypted if empty
+	SignFrom        string             // Email of the key of SecretKeyRing the backup sets sent are signed with, they are not signed if empty
+	Log             io.Writer          // Where to write the logs, nothing is logged if nil
+	LogLevel        string             // One of critical, error, warning, notice, info, or debug, notice if empty
+}
+
+// Client runs the operations of zfsbackup as described by its Config.
+type Client struct {
+	config     Config
+	logger     *logging.Logger
+	encryptKey *openpgp.Entity
+	signKey    *openpgp.Entity
+}
+
+// New will return a Client for the Config provided, checking it is valid.
+func New(config Config) (*Client, error) {
+	if config.WorkingDir == "" {
+		return nil, ErrNoWorkingDir
+	}
+	workingDir, err := filepath.Abs(config.WorkingDir)
+	if err != nil {
+		return nil, err
+	}
+	config.WorkingDir = workingDir
+	if config.ZFSPath == "" {
+		config.ZFSPath = "zfs"
+	}
+	if config.ManifestPrefix == "" {
+		config.ManifestPrefix = DefaultManifestPrefix
+	}
+	if config.LogLevel == "" {
+		config.LogLevel = "notice"
+	}
+	switch config.ManifestFormat {
+	case "", helpers.ManifestFormatNative, helpers.ManifestFormatUpstream, helpers.ManifestFormatAuto:
+	default:
+		return nil, errors.New("unsupported manifest format " + config.ManifestFormat)
+	}
+
+	c := &Client{config: config}
+
+	level, err := logging.LogLevel(config.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	logOutput := config.Log
+	if logOutput == nil {
+		logOutput = ioutil.Discard
+	}
+	logBackend := logging.AddModuleLevel(logging.NewLogBackend(logOutput, "", log.LstdFlags))
+	logBackend.SetLevel(level, helpers.LogModuleName)
+	c.logger = &logging.Logger{Module: helpers.LogModuleName}
+	c.logger.SetBackend(logBackend)
+
+	keyring := append(append(openpgp.EntityList{}, config.PublicKeyRing...), config.SecretKeyRing...)
+	if config.EncryptTo != "" {
+		if c.encryptKey = keyByEmail(keyring, config.EncryptTo); c.encryptKey == nil {
+			return nil, errors.New("could not find the key of " + config.EncryptTo + " to encrypt to")
+		}
+	}
+	if config.SignFrom != "" {
+		if c.signKey = keyByEmail(config.SecretKeyRing, config.SignFrom); c.signKey == nil {
+			return nil, errors.New("could not find the private key of " + config.SignFrom + " to sign from")
+		}
+	}
+
+	if err = os.MkdirAll(filepath.Join(config.WorkingDir, "cache"), 0700); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func keyByEmail(keyring openpgp.EntityList, email string) *openpgp.Entity {
+	for _, entity := range keyring {
+		for _, ident := range entity.Identities {
+			if ident.UserId.Email == email {
+				return entity
+			}
+		}
+	}
+	return nil
+}
+
+// newJobInfo returns a JobInfo holding the defaults of the command line along with the Config of the Client.
+func (c *Client) newJobInfo() *helpers.JobInfo {
+	return &helpers.JobInfo{
+		StartTime:          time.Now(),
+		Version:            helpers.VersionNumber,
+		ManifestPrefix:     c.config.ManifestPrefix,
+		ManifestFormat:     c.config.ManifestFormat,
+		ManifestWorkers:    helpers.DefaultManifestWorkers,
+		EncryptTo:          c.config.EncryptTo,
+		SignFrom:           c.config.SignFrom,
+		EncryptKey:         c.encryptKey,
+		SignKey:            c.signKey,
+		Compressor:         helpers.InternalCompressor,
+		CompressionLevel:   6,
+		DigestAlgorithm:    helpers.DigestSHA256,
+		VolumeSize:         200,
+		MaxFileBuffer:      5,
+		MaxParallelUploads: 4,
+		MaxRetryTime:       12 * time.Hour,
+		MaxBackoffTime:     30 * time.Minute,
+		Separator:          "|",
+		UploadChunkSize:    10,
+		SendReadSize:       helpers.DefaultSendReadSize,
+		CompressBlockSize:  helpers.DefaultCompressorBlockSize,
+		UploadReadSize:     helpers.DefaultUploadReadSize,
+		FullIfOlderThan:    -1 * time.Minute,
+	}
+}
+
+// call will run fn with a context carrying the Config of the Client, in a temporary directory of its own
+// removed once done.
+func (c *Client) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	tempDir, err := ioutil.TempDir(c.config.TempDir, helpers.LogModuleName)
+	if err != nil {
+		return err
+	}
+	defer helpers.RemoveTempDir(tempDir)
+
+	return fn(helpers.WithEnvironment(ctx, helpers.Environment{
+		WorkingDir:      c.config.WorkingDir,
+		TempDir:         tempDir,
+		ZFSPath:         c.config.ZFSPath,
+		SSHHost:         c.config.SSHHost,
+		SSHPort:         c.config.SSHPort,
+		SSHIdentityFile: c.config.SSHIdentityFile,
+		PublicKeyRing:   c.config.PublicKeyRing,
+		SecretKeyRing:   c.config.SecretKeyRing,
+		Logger:          c.logger,
+	}))
+}
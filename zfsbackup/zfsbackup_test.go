@@ -0,0 +1,263 @@
+// Copyright © 2017 Prateek Malhotra (someone1@gmail.com)
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package zfsbackup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	logging "github.com/op/go-logging"
+
+	"github.com/someone1/zfsbackup-go/helpers"
+)
+
+// fakeZFS lists Tank/Data@snap1 and sends the stream file given.
+const fakeZFS = `#!/bin/sh
+case "$1" in
+list) printf 'Tank/Data@snap1\t1700000000\n';;
+get) echo 1700000000;;
+send) if [ "$2" = "-n" ]; then printf 'size\t%d\n' $(wc -c < "%s"); else cat "%s"; fi;;
+esac
+`
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{}); err != ErrNoWorkingDir {
+		t.Errorf("expected %v, got %v", ErrNoWorkingDir, err)
+	}
+	if _, err := New(Config{WorkingDir: os.TempDir(), LogLevel: "loud"}); err == nil {
+		t.Errorf("expected an error for an invalid log level, got none")
+	}
+	if _, err := New(Config{WorkingDir: os.TempDir(), EncryptTo: "nobody@example.com"}); err == nil {
+		t.Errorf("expected an error for a missing encryption key, got none")
+	}
+}
+
+func TestCallLogging(t *testing.T) {
+	defaultLog := new(bytes.Buffer)
+	logging.SetBackend(logging.NewLogBackend(defaultLog, "", 0))
+	defer logging.SetBackend(logging.NewLogBackend(os.Stderr, "", log.LstdFlags))
+
+	clientLog := new(bytes.Buffer)
+	client, err := New(Config{WorkingDir: os.TempDir(), Log: clientLog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.call(context.Background(), func(ctx context.Context) error {
+		helpers.EnvironmentOf(ctx).Logger.Noticef("logged by the call")
+		helpers.LogFields{Volume: "vol1"}.Noticef(ctx, "logged with fields by the call")
+		helpers.AppLogger.Noticef("logged by the program")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(clientLog.String(), "logged by the call") || !strings.Contains(clientLog.String(), "logged with fields by the call") {
+		t.Errorf("expected the logs of the call to go to the Client, got %q", clientLog.String())
+	}
+	if strings.Contains(clientLog.String(), "by the program") {
+		t.Errorf("expected the logs of the program not to go to the Client, got %q", clientLog.String())
+	}
+	if !strings.Contains(defaultLog.String(), "logged by the program") || strings.Contains(defaultLog.String(), "by the call") {
+		t.Errorf("expected only the logs of the program to go to the default backend of go-logging, got %q", defaultLog.String())
+	}
+	if helpers.LogBackend() != nil {
+		t.Errorf("expected the log backend of helpers to be left alone")
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paused := helpers.Transfers.Pause()
+	defer func() {
+		if paused {
+			helpers.Transfers.Resume()
+		}
+	}()
+	helpers.JSONOutput = true
+	defer func() { helpers.JSONOutput = false }()
+
+	clients := make([]*Client, 2)
+	logs := make([]*bytes.Buffer, len(clients))
+	for idx := range clients {
+		logs[idx] = new(bytes.Buffer)
+		if clients[idx], err = New(Config{WorkingDir: filepath.Join(dir, fmt.Sprintf("working%d", idx)), ZFSPath: fmt.Sprintf("zfs%d", idx), Log: logs[idx]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each call waits for the other one to start, which it can only do if they run at the same time
+	started := make(chan struct{}, len(clients))
+	errs := make(chan error, len(clients))
+	for _, client := range clients {
+		go func(client *Client) {
+			errs <- client.call(context.Background(), func(ctx context.Context) error {
+				env := helpers.EnvironmentOf(ctx)
+				if env.WorkingDir != client.config.WorkingDir || env.ZFSPath != client.config.ZFSPath {
+					return fmt.Errorf("expected the environment of %s, got %s", client.config.WorkingDir, env.WorkingDir)
+				}
+				if env.Transfers.Paused() {
+					return fmt.Errorf("expected the transfers of %s not to be paused", client.config.WorkingDir)
+				}
+				if env.JSONOutput {
+					return fmt.Errorf("expected the results of %s not to be output JSON formatted", client.config.WorkingDir)
+				}
+				started <- struct{}{}
+				for len(started) < len(clients) {
+					select {
+					case <-time.After(10 * time.Millisecond):
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+				env.Logger.Noticef("logged by the call in %s", env.WorkingDir)
+				return nil
+			})
+		}(client)
+	}
+
+	for range clients {
+		select {
+		case err = <-errs:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the calls of the clients to run at the same time")
+		}
+	}
+	for idx, client := range clients {
+		if !strings.Contains(logs[idx].String(), client.config.WorkingDir) || strings.Count(logs[idx].String(), "logged by the call") != 1 {
+			t.Errorf("expected the Log of the client in %s to only get its own records, got %q", client.config.WorkingDir, logs[idx].String())
+		}
+	}
+}
+
+func TestSendReceive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stream := make([]byte, 3*1024*1024)
+	if _, err = rand.Read(stream); err != nil {
+		t.Fatal(err)
+	}
+	streamPath := filepath.Join(dir, "stream")
+	if err = ioutil.WriteFile(streamPath, stream, 0600); err != nil {
+		t.Fatal(err)
+	}
+	zfsPath := filepath.Join(dir, "zfs")
+	if err = ioutil.WriteFile(zfsPath, []byte(strings.Replace(fakeZFS, "%s", streamPath, -1)), 0700); err != nil {
+		t.Fatal(err)
+	}
+	destDir := filepath.Join(dir, "destination")
+	if err = os.Mkdir(destDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	destination := "file://" + destDir
+
+	oldWorkingDir := helpers.WorkingDir
+	client, err := New(Config{WorkingDir: filepath.Join(dir, "working"), TempDir: dir, ZFSPath: zfsPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	events := make(map[helpers.ProgressEventType]int)
+	progress := func(event ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[event.Type]++
+	}
+
+	ctx := context.Background()
+	result, err := client.Send(ctx, SendOptions{Volume: "Tank/Data@snap1", Destinations: []string{destination}, VolumeSize: 1, Progress: progress})
+	if err != nil {
+		t.Fatalf("unexpected error sending - %v", err)
+	}
+	if result.AlreadyBackedUp {
+		t.Errorf("expected the backup set to be sent")
+	}
+	if result.BackupSet.ZFSStreamBytes != uint64(len(stream)) {
+		t.Errorf("expected %d zfs stream bytes, got %d", len(stream), result.BackupSet.ZFSStreamBytes)
+	}
+	if result.BackupSet.Volumes < 2 {
+		t.Errorf("expected at least 2 volumes, got %d", result.BackupSet.Volumes)
+	}
+	if events[helpers.ProgressJobFinished] != 1 || events[helpers.ProgressVolumeUploaded] < result.BackupSet.Volumes {
+		t.Errorf("unexpected progress events %v", events)
+	}
+	if helpers.WorkingDir != oldWorkingDir {
+		t.Errorf("expected the working directory to be restored to %s, got %s", oldWorkingDir, helpers.WorkingDir)
+	}
+
+	again, err := client.Send(ctx, SendOptions{Volume: "Tank/Data@snap1", Destinations: []string{destination}, VolumeSize: 1})
+	if err != nil {
+		t.Fatalf("unexpected error sending again - %v", err)
+	}
+	if !again.AlreadyBackedUp || again.BackupSet.Manifest != result.BackupSet.Manifest {
+		t.Errorf("expected the backup set %s to be found already backed up, got %+v", result.BackupSet.Manifest, again)
+	}
+
+	sets, err := client.List(ctx, destination, ListOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error listing - %v", err)
+	}
+	if len(sets) != 1 || sets[0].Manifest != result.BackupSet.Manifest || sets[0].BaseSnapshot.Name != "snap1" {
+		t.Errorf("expected the backup set %s, got %+v", result.BackupSet.Manifest, sets)
+	}
+
+	if err = client.Verify(ctx, VerifyOptions{Volume: "Tank/Data@snap1", Destination: destination}); err != nil {
+		t.Errorf("unexpected error verifying - %v", err)
+	}
+
+	toFile := filepath.Join(dir, "restored")
+	if err = client.Receive(ctx, ReceiveOptions{Volume: "Tank/Data@snap1", Destination: destination, ToFile: toFile}); err != nil {
+		t.Fatalf("unexpected error receiving - %v", err)
+	}
+	restored, err := ioutil.ReadFile(toFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, stream) {
+		t.Errorf("expected the restored stream to match the one sent")
+	}
+
+	if err = client.Verify(ctx, VerifyOptions{Volume: "Tank/Data@snap2", Destination: destination}); err == nil {
+		t.Errorf("expected an error verifying a missing backup set, got none")
+	}
+}